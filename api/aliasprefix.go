@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+func validateAliasPrefixes(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Used {
+		if len(resource.AliasPrefix) == 0 {
+			continue
+		}
+
+		if len(resource.Alias) > 0 {
+			return &ValidationError{
+				"Alias and AliasPrefix can not both be set on a used resource",
+				map[string]string{"Alias": resource.Alias, "AliasPrefix": resource.AliasPrefix},
+			}
+		}
+
+		if len(resource.PropertyMap) > 0 {
+			return &ValidationError{
+				"PropertyMap can not be used with AliasPrefix, as every resource it resolves to would get the same environment variables",
+				map[string]string{"AliasPrefix": resource.AliasPrefix},
+			}
+		}
+	}
+
+	return nil
+}
+
+// ResolveAliasPrefixes replaces each used resource with an alias prefix by a used resource per alias with that prefix
+// in the scope of the deployment, in alphabetical order. Each of them is injected like any other used resource, with
+// environment variables named after its alias. A prefix that resolves to no resources fails, unless it is optional.
+func ResolveAliasPrefixes(fasit FasitClientAdapter, manifest *NaisManifest, environment, application, zone string) error {
+	var used []UsedResource
+	for _, resource := range manifest.FasitResources.Used {
+		if len(resource.AliasPrefix) == 0 {
+			used = append(used, resource)
+			continue
+		}
+
+		aliases, err := fasit.FindResourceAliases(resource.AliasPrefix, resource.ResourceType, environment, application, zone)
+		if _, ok := err.(*FasitError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("unable to resolve alias prefix %s (%s): %s", resource.AliasPrefix, resource.ResourceType, err)
+		}
+		if len(aliases) == 0 && !resource.Optional {
+			return fmt.Errorf("no resources of type %s with alias prefix %s found in %s", resource.ResourceType, resource.AliasPrefix, environment)
+		}
+
+		for _, alias := range aliases {
+			resolved := resource
+			resolved.Alias = alias
+			resolved.AliasPrefix = ""
+			used = append(used, resolved)
+		}
+	}
+	manifest.FasitResources.Used = used
+	return nil
+}
+
+// FindResourceAliases returns the aliases of the resources of a type in scope that start with prefix
+func (fasit FasitClient) FindResourceAliases(prefix, resourceType, environment, application, zone string) ([]string, error) {
+	req, err := fasit.buildRequest("GET", "/api/v2/resources", map[string]string{
+		"type":        resourceType,
+		"environment": environment,
+		"application": application,
+		"zone":        zone,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, appErr := fasit.doRequest(fmt.Sprintf("find resources with alias prefix %s (%s)", prefix, resourceType), req)
+	if appErr != nil {
+		if appErr.Code() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, appErr
+	}
+
+	var resources []FasitResource
+	if err := json.Unmarshal(body, &resources); err != nil {
+		errorCounter.WithLabelValues("unmarshal_body").Inc()
+		return nil, fmt.Errorf("could not unmarshal body: %s", err)
+	}
+
+	// a resource can be in several scopes, but is resolved once, in the one that fits the deployment best
+	found := make(map[string]bool)
+	var aliases []string
+	for _, resource := range resources {
+		if strings.HasPrefix(resource.Alias, prefix) && !found[resource.Alias] {
+			found[resource.Alias] = true
+			aliases = append(aliases, resource.Alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases, nil
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestValidateAliasPrefixes(t *testing.T) {
+	used := func(resources ...UsedResource) NaisManifest {
+		return NaisManifest{FasitResources: FasitResources{Used: resources}}
+	}
+
+	assert.Nil(t, validateAliasPrefixes(used(UsedResource{AliasPrefix: "myapp_queue_", ResourceType: "queue"}, UsedResource{Alias: "mydb", ResourceType: "datasource"})))
+	assert.NotNil(t, validateAliasPrefixes(used(UsedResource{Alias: "myapp_queue_in", AliasPrefix: "myapp_queue_", ResourceType: "queue"})))
+	assert.NotNil(t, validateAliasPrefixes(used(UsedResource{AliasPrefix: "myapp_queue_", ResourceType: "queue", PropertyMap: map[string]string{"queueName": "QUEUE"}})))
+	assert.Nil(t, validateResources(used(UsedResource{AliasPrefix: "myapp_queue_", ResourceType: "queue"})))
+	assert.NotNil(t, validateResources(used(UsedResource{ResourceType: "queue"})))
+}
+
+func TestResolveAliasPrefixes(t *testing.T) {
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	defer gock.Off()
+
+	t.Run("Prefixes are replaced by the aliases that have them", func(t *testing.T) {
+		gock.New("https://fasit.local").
+			Get("/api/v2/resources").
+			MatchParam("type", "queue").
+			MatchParam("environment", "t1").
+			MatchParam("application", "app").
+			MatchParam("zone", "fss").
+			Reply(200).
+			BodyString(`[{"id": 1, "alias": "myapp_queue_out", "type": "queue"}, {"id": 2, "alias": "otherapp_queue", "type": "queue"}, {"id": 3, "alias": "myapp_queue_in", "type": "queue"}, {"id": 4, "alias": "myapp_queue_in", "type": "queue"}]`)
+
+		manifest := NaisManifest{FasitResources: FasitResources{Used: []UsedResource{
+			{Alias: "mydb", ResourceType: "datasource"},
+			{AliasPrefix: "myapp_queue_", ResourceType: "queue", Check: DependencyCheckTcp},
+		}}}
+
+		assert.NoError(t, ResolveAliasPrefixes(fasit, &manifest, "t1", "app", "fss"))
+		assert.Equal(t, []UsedResource{
+			{Alias: "mydb", ResourceType: "datasource"},
+			{Alias: "myapp_queue_in", ResourceType: "queue", Check: DependencyCheckTcp},
+			{Alias: "myapp_queue_out", ResourceType: "queue", Check: DependencyCheckTcp},
+		}, manifest.FasitResources.Used)
+	})
+
+	t.Run("Prefixes that resolve to nothing fail unless they are optional", func(t *testing.T) {
+		gock.New("https://fasit.local").
+			Get("/api/v2/resources").
+			Times(2).
+			Reply(200).
+			BodyString(`[]`)
+
+		manifest := NaisManifest{FasitResources: FasitResources{Used: []UsedResource{{AliasPrefix: "myapp_queue_", ResourceType: "queue"}}}}
+		assert.EqualError(t, ResolveAliasPrefixes(fasit, &manifest, "t1", "app", "fss"), "no resources of type queue with alias prefix myapp_queue_ found in t1")
+
+		manifest.FasitResources.Used[0].Optional = true
+		assert.NoError(t, ResolveAliasPrefixes(fasit, &manifest, "t1", "app", "fss"))
+		assert.Empty(t, manifest.FasitResources.Used)
+	})
+}
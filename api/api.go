@@ -12,17 +12,55 @@ import (
 	"goji.io/pat"
 	"io"
 	"io/ioutil"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
 	"k8s.io/client-go/kubernetes"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type Api struct {
-	Clientset              kubernetes.Interface
-	FasitUrl               string
-	ClusterSubdomain       string
-	ClusterName            string
-	IstioEnabled           bool
-	DeploymentStatusViewer DeploymentStatusViewer
+	Clientset                 kubernetes.Interface
+	FasitUrl                  string
+	ClusterSubdomain          string
+	ClusterName               string
+	IstioEnabled              bool
+	DeploymentStatusViewer    DeploymentStatusViewer
+	FasitEventsEnabled        bool
+	FasitStopPrevious         bool
+	FasitEndpoints            map[string]FasitEndpoint
+	FasitRetryPolicy          RetryPolicy
+	FasitTransport            http.RoundTripper
+	FasitApplicationInstance  ApplicationInstanceConfig
+	OfflineFasit              *OfflineFasit
+	Provenance                ProvenanceConfig
+	Scanner                   ScannerConfig
+	Policies                  Policies
+	EnvironmentClass          string
+	PullRequestProviders      map[string]PullRequestProvider
+	DnsAllowList              DnsAllowList
+	EmptyDirLimits            EmptyDirLimits
+	Egress                    EgressConfig
+	Firewall                  FirewallConfig
+	DeploymentLog             DeploymentLogConfig
+	ManifestProfiles          map[string]string
+	ResourceTemplates         map[string]ExposedResource
+	DefaultEnv                map[string]string
+	DeploySteps               DeploySteps
+	FasitCredentialsNamespace string
+	AuditLog                  *AuditLog
+	DeploymentHistory         *DeploymentHistory
+	Metrics                   prometheus.Gatherer
+	Status                    *DaemonStatus
+	FeatureFlags              *FeatureFlags
+	LoadShedder               *LoadShedder
+	Deployments               *DeploymentTracker
+	Pipelines                 *Pipelines
+	Transfers                 *Transfers
+	Rollouts                  *Rollouts
+	MaxDeployDuration         time.Duration
+	OperatorToken             string
+	Identities                []Identity
 }
 
 type AppError interface {
@@ -65,20 +103,50 @@ var (
 	)
 )
 
-func init() {
-	prometheus.MustRegister(requests)
-	prometheus.MustRegister(deploys)
-}
-
 func (api Api) Handler() http.Handler {
 	mux := goji.NewMux()
 
 	mux.Handle(pat.Get("/isalive"), appHandler(api.isAlive))
 	mux.Handle(pat.Post("/deploy"), appHandler(api.deploy))
-	mux.Handle(pat.Get("/metrics"), promhttp.Handler())
+	mux.Handle(pat.Get("/deploy"), compressed(appHandler(api.listDeployments)))
+	mux.Handle(pat.Get("/deploy/:id"), appHandler(api.getDeployment))
+	mux.Handle(pat.Delete("/deploy/:id"), api.requireOperator(api.cancelDeployment))
+	mux.Handle(pat.Post("/redeploy/:environment/:application"), api.requireOperator(api.redeploy))
+	mux.Handle(pat.Post("/rollout"), api.requireOperator(api.startRollout))
+	mux.Handle(pat.Get("/rollout/:id"), appHandler(api.getRollout))
+	mux.Handle(pat.Delete("/rollout/:id"), api.requireOperator(api.cancelRollout))
+	mux.Handle(pat.Post("/pipeline"), appHandler(api.startPipeline))
+	mux.Handle(pat.Get("/pipeline/:id"), appHandler(api.getPipeline))
+	mux.Handle(pat.Post("/pipeline/:id/approve"), appHandler(api.approvePipeline))
+	mux.Handle(pat.Delete("/pipeline/:id"), appHandler(api.cancelPipeline))
+	mux.Handle(pat.Post("/transfer"), appHandler(api.requestTransfer))
+	mux.Handle(pat.Get("/transfer/:id"), appHandler(api.getTransfer))
+	mux.Handle(pat.Post("/transfer/:id/approve"), appHandler(api.approveTransfer))
+	mux.Handle(pat.Post("/render"), appHandler(api.render))
+	mux.Handle(pat.Get("/compare/:application"), appHandler(api.compare))
+	mux.Handle(pat.Get("/metrics"), promhttp.HandlerFor(api.metricsGatherer(), promhttp.HandlerOpts{}))
 	mux.Handle(pat.Get("/version"), appHandler(api.version))
+	mux.Handle(pat.Get("/version/:environment/:application"), appHandler(api.applicationVersions))
+	mux.Handle(pat.Get("/fasithealth"), appHandler(api.fasitHealth))
+	mux.Handle(pat.Get("/internal/status"), appHandler(api.internalStatus))
+	mux.Handle(pat.Get("/internal/info"), appHandler(api.info))
+	mux.Handle(pat.Get("/internal/pause"), api.requireOperator(api.listPauses))
+	mux.Handle(pat.Post("/internal/pause"), api.requireOperator(api.pauseDeployments))
+	mux.Handle(pat.Post("/internal/resume"), api.requireOperator(api.resumeDeployments))
+	mux.Handle(pat.Get("/internal/state"), compressed(api.requireOperator(api.getState)))
+	mux.Handle(pat.Put("/internal/state"), api.requireOperator(api.putState))
+	mux.Handle(pat.Get("/audit"), compressed(appHandler(api.audit)))
+	mux.Handle(pat.Get("/report/resource-usage"), compressed(appHandler(api.resourceUsageReport)))
+	mux.Handle(pat.Get("/report/egress"), compressed(appHandler(api.egressReport)))
+	mux.Handle(pat.Get("/report/deployments"), compressed(appHandler(api.deploymentReport)))
 	mux.Handle(pat.Get("/deploystatus/:namespace/:deployName"), appHandler(api.deploymentStatusHandler))
+	mux.Handle(pat.Get("/revisions/:namespace/:deployName"), appHandler(api.revisions))
+	mux.Handle(pat.Get("/logs/:namespace/:deployName"), compressed(appHandler(api.applicationLogs)))
 	mux.Handle(pat.Delete("/app/:namespace/:deployName"), appHandler(api.deleteApplication))
+	mux.Handle(pat.Post("/preview/:namespace/:deployName/expire"), appHandler(api.expirePreview))
+	mux.Handle(pat.Post("/mirror/:namespace/:deployName/stop"), appHandler(api.stopMirror))
+	mux.Handle(pat.Post("/darklaunch/:namespace/:deployName/promote"), appHandler(api.promoteDarkLaunch))
+	mux.Handle(pat.Post("/darklaunch/:namespace/:deployName/discard"), appHandler(api.discardDarkLaunch))
 	return mux
 }
 
@@ -90,27 +158,188 @@ func NewApi(clientset kubernetes.Interface, fasitUrl, clusterDomain, clusterName
 		ClusterName:            clusterName,
 		IstioEnabled:           istioEnabled,
 		DeploymentStatusViewer: d,
+		AuditLog:               NewAuditLog(),
+		DeploymentHistory:      NewDeploymentHistory(),
+		Status:                 NewDaemonStatus(),
+		LoadShedder:            NewLoadShedder(),
+		Deployments:            NewDeploymentTracker(),
+		Pipelines:              NewPipelines(),
+		Transfers:              NewTransfers(),
+		Rollouts:               NewRollouts(),
 	}
 }
 
 func (api Api) deploy(w http.ResponseWriter, r *http.Request) *appError {
 	requests.With(prometheus.Labels{"path": "deploy"}).Inc()
 
+	deploymentsQueued.Inc()
+	queued := true
+	dequeue := func() {
+		if queued {
+			deploymentsQueued.Dec()
+			queued = false
+		}
+	}
+	defer dequeue()
+
 	deploymentRequest, err := unmarshalDeploymentRequest(r.Body)
 
 	if err != nil {
 		return &appError{err, "unable to unmarshal deployment request", http.StatusBadRequest}
 	}
 
-	fasit := FasitClient{api.FasitUrl, deploymentRequest.FasitUsername, deploymentRequest.FasitPassword}
+	// a dry run only reads from Fasit, so it is neither queued nor tracked as a deployment
+	if deploymentRequest.FasitDryRun {
+		dequeue()
+		return api.fasitDryRun(w, r, deploymentRequest)
+	}
+
+	// each of them renames the application, and clients other than the CLI do not validate the request
+	if deploymentRequest.Preview != nil && deploymentRequest.Mirror != nil {
+		return &appError{fmt.Errorf("preview and mirror can not be combined"), "invalid deployment request", http.StatusBadRequest}
+	}
+	if deploymentRequest.DarkLaunch != nil && (deploymentRequest.Preview != nil || deploymentRequest.Mirror != nil) {
+		return &appError{fmt.Errorf("dark launch can not be combined with preview or mirror"), "invalid deployment request", http.StatusBadRequest}
+	}
+
+	if err := api.LoadShedder.admit(deploymentRequest.Namespace, api.Status.DeploymentsInProgress()); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(loadSheddingRetryAfter))
+		return &appError{err, "naisd is not accepting deployments right now, retry later", http.StatusServiceUnavailable}
+	}
+	api.Status.deploymentStarted()
+	defer api.Status.deploymentFinished()
+
+	var previewExpires time.Time
+	previewOf := deploymentRequest.Application
+	if deploymentRequest.Preview != nil {
+		if previewExpires, err = applyPreview(&deploymentRequest, time.Now()); err != nil {
+			return &appError{err, "invalid preview", http.StatusBadRequest}
+		}
+		if err := checkPreviewName(deploymentRequest.Namespace, deploymentRequest.Application, previewOf, deploymentRequest.Preview.Branch, api.Clientset); err != nil {
+			return &appError{err, "the name of the preview is taken", http.StatusConflict}
+		}
+	}
+
+	deployment, err := api.Deployments.start(r.Context(), deploymentRequest, api.MaxDeployDuration)
+	dequeue()
+	if err != nil {
+		return &appError{err, "application is already being deployed", http.StatusConflict}
+	}
+	succeeded := false
+	record := DeploymentRecord{
+		DeploymentId:    deployment.Id,
+		Application:     deploymentRequest.Application,
+		Namespace:       deploymentRequest.Namespace,
+		Version:         deploymentRequest.Version,
+		PreviousVersion: api.DeploymentHistory.previousVersion(deploymentRequest.FasitEnvironment, deploymentRequest.Namespace, deploymentRequest.Application),
+		Environment:     deploymentRequest.FasitEnvironment,
+		Zone:            deploymentRequest.Zone,
+		Cluster:         api.ClusterName,
+		DeployedBy:      deploymentRequest.OnBehalfOf,
+	}
+	defer func() {
+		api.Deployments.finish(deployment, succeeded)
+		if !succeeded {
+			record.Result = deployment.Status
+			api.DeploymentHistory.Add(record)
+		}
+		api.logDeployment(record, "deploy", deployment.Started)
+	}()
+	w.Header().Set("X-Deployment-Id", deployment.Id)
 
 	glog.Infof("Starting deployment. Deploying %s:%s to %s\n", deploymentRequest.Application, deploymentRequest.Version, deploymentRequest.FasitEnvironment)
 
-	manifest, err := GenerateManifest(deploymentRequest)
+	if appErr := api.enterPhase(deployment, PhaseManifest); appErr != nil {
+		return appErr
+	}
+
+	manifest, declared, err := generateManifest(deploymentRequest, api.ManifestProfiles, api.ResourceTemplates)
 	if err != nil {
 		return &appError{err, "unable to generate manifest/nais.yaml", http.StatusInternalServerError}
 	}
 
+	manifest.DefaultEnv = api.DefaultEnv
+	record.Team = manifest.Team
+
+	if err := checkTransferredFrom(deploymentRequest.Namespace, deploymentRequest.Application, manifest.Team, api.Clientset); err != nil {
+		return &appError{err, "application has been transferred to another team", http.StatusForbidden}
+	}
+	if appErr := api.resolveFasitCredentials(r, &deploymentRequest, manifest.Team); appErr != nil {
+		return appErr
+	}
+	if maxDeployDuration, _ := time.ParseDuration(manifest.MaxDeployDuration); maxDeployDuration > 0 {
+		api.Deployments.limit(deployment, maxDeployDuration)
+	}
+	fasit := api.fasitClient(&deploymentRequest, api.isOperator(r)).forDeployment(deployment)
+	if deploymentRequest.BypassFasitCache {
+		fasit = fasit.withoutCache()
+	}
+	fasitBackend := api.fasitBackend(fasit)
+
+	external := isExternal(manifest)
+	if external {
+		if err := checkExternalDeployment(deploymentRequest); err != nil {
+			return &appError{err, "invalid deployment of external application", http.StatusBadRequest}
+		}
+	}
+
+	var mirrorOf string
+	var mirrorExpires time.Time
+	if deploymentRequest.Mirror != nil {
+		mirrorOf = deploymentRequest.Application
+		if mirrorExpires, err = applyMirror(&deploymentRequest, &manifest, time.Now()); err != nil {
+			return &appError{err, "invalid mirror", http.StatusBadRequest}
+		}
+		if err := checkMirrorName(deploymentRequest.Namespace, deploymentRequest.Application, mirrorOf, api.Clientset); err != nil {
+			return &appError{err, "the name of the mirror is taken", http.StatusConflict}
+		}
+		record.Application = deploymentRequest.Application
+	}
+
+	// the dark launch is promoted by deploying its version as the application, as if it had not been dark launched
+	var darkLaunchOf string
+	var promotion *deploymentSpec
+	if deploymentRequest.DarkLaunch != nil {
+		darkLaunchOf = deploymentRequest.Application
+		stableRequest := deploymentRequest
+		stableRequest.DarkLaunch = nil
+		promotion = newDeploymentSpec(stableRequest, manifest, nil)
+		promotion.declared = declared
+		applyDarkLaunch(&deploymentRequest, &manifest)
+		if err := checkDarkLaunchName(deploymentRequest.Namespace, deploymentRequest.Application, darkLaunchOf, api.Clientset); err != nil {
+			return &appError{err, "the name of the dark launch is taken", http.StatusConflict}
+		}
+		record.Application = deploymentRequest.Application
+	}
+
+	if err := checkDnsAllowList(manifest, api.DnsAllowList); err != nil {
+		return &appError{err, "manifest uses DNS settings that are not permitted", http.StatusBadRequest}
+	}
+
+	if err := applyEmptyDirLimits(&manifest, api.EmptyDirLimits); err != nil {
+		return &appError{err, "manifest has emptyDirs larger than permitted", http.StatusBadRequest}
+	}
+
+	if err := checkErrorBackend(deploymentRequest.Namespace, manifest, api.Clientset); err != nil {
+		return &appError{err, "ingress error backend not found", http.StatusBadRequest}
+	}
+
+	provenance, err := verifyProvenance(deploymentRequest, manifest, api.Provenance)
+	api.AuditLog.Record(AuditEntry{
+		Event:       "provenance_verification",
+		Application: deploymentRequest.Application,
+		Namespace:   deploymentRequest.Namespace,
+		Version:     deploymentRequest.Version,
+		Details:     provenance.details(),
+	})
+	if err != nil {
+		return &appError{err, "provenance verification failed", http.StatusBadRequest}
+	}
+
+	if appErr := api.enterPhase(deployment, PhaseFasit); appErr != nil {
+		return appErr
+	}
+
 	var fasitEnvironmentClass string
 	var naisResources []NaisResource
 
@@ -120,38 +349,298 @@ func (api Api) deploy(w http.ResponseWriter, r *http.Request) *appError {
 		glog.Infof("Starting deployment. Deploying %s:%s. Fasit will be skipped\n", deploymentRequest.Application, deploymentRequest.Version)
 	}
 
+	if !deploymentRequest.SkipFasit && fasit.unavailable() {
+		return &appError{errFasitUnavailable, "Fasit unavailable", http.StatusServiceUnavailable}
+	}
+
 	if !deploymentRequest.SkipFasit {
 		if hasResources(manifest) {
 			if deploymentRequest.FasitEnvironment == "" {
 				return &appError{err, "no fasit environment provided, but contains resources to be consumed or exposed", http.StatusInternalServerError}
 			}
-			if err := validateFasitRequirements(fasit, deploymentRequest.Application, deploymentRequest.FasitEnvironment); err != nil {
-				return &appError{err, "validating requirements for deployment failed", http.StatusInternalServerError}
+			if err := validateFasitRequirements(fasitBackend, deploymentRequest.Application, deploymentRequest.FasitEnvironment); err != nil {
+				return fasitAppError(fasit, err, "validating requirements for deployment failed", http.StatusInternalServerError)
 			}
-			fasitEnvironmentClass, err = fasit.GetFasitEnvironmentClass(deploymentRequest.FasitEnvironment)
+			fasitEnvironmentClass, err = fasitBackend.GetFasitEnvironmentClass(deploymentRequest.FasitEnvironment)
 		}
 
-		naisResources, err = FetchFasitResources(fasit, deploymentRequest.Application, deploymentRequest.FasitEnvironment, deploymentRequest.Zone, manifest.FasitResources.Used)
+		if err := ResolveAliasPrefixes(fasitBackend, &manifest, deploymentRequest.FasitEnvironment, deploymentRequest.Application, deploymentRequest.Zone); err != nil {
+			return fasitAppError(fasit, err, "unable to fetch fasit resources", http.StatusBadRequest)
+		}
+		naisResources, err = FetchFasitResources(fasitBackend, deploymentRequest.Application, deploymentRequest.FasitEnvironment, deploymentRequest.Zone, manifest.FasitResources.Used)
 		if err != nil {
-			return &appError{err, "unable to fetch fasit resources", http.StatusBadRequest}
+			return fasitAppError(fasit, err, "unable to fetch fasit resources", http.StatusBadRequest)
 		}
 	}
 
-	deploymentResult, err := createOrUpdateK8sResources(deploymentRequest, manifest, naisResources, api.ClusterSubdomain, api.IstioEnabled, api.Clientset)
+	if !external {
+		if err := checkObjectSizes(deploymentRequest, naisResources); err != nil {
+			return &appError{err, "generated objects are too large", http.StatusBadRequest}
+		}
+	}
+
+	if len(fasitEnvironmentClass) == 0 && (len(api.Scanner.Url) > 0 || len(api.Policies) > 0) && !deploymentRequest.SkipFasit && len(deploymentRequest.FasitEnvironment) > 0 {
+		if fasitEnvironmentClass, err = fasitBackend.GetFasitEnvironmentClass(deploymentRequest.FasitEnvironment); err != nil {
+			return fasitAppError(fasit, err, "unable to get environment class for vulnerability scan and deployment policy", http.StatusInternalServerError)
+		}
+	}
+
+	// previews, mirrors and dark launches run in the same cluster as the application, so they follow its policy too.
+	// External applications have no pods for the policy to be about.
+	if !external {
+		policyEnvironmentClass, err := api.policyEnvironmentClass(deploymentRequest.Namespace, fasitEnvironmentClass)
+		if err != nil {
+			return &appError{err, "unable to get environment class for deployment policy", http.StatusInternalServerError}
+		}
+		if appErr := api.enforceDeploymentPolicy(r, deploymentRequest, manifest, declared, policyEnvironmentClass); appErr != nil {
+			return appErr
+		}
+	}
+
+	if appErr := api.enterPhase(deployment, PhaseScan); appErr != nil {
+		return appErr
+	}
+
+	// naisd does not know the image an external application runs
+	scanner := api.Scanner
+	if external {
+		scanner = ScannerConfig{}
+	}
+	image := fmt.Sprintf("%s:%s", manifest.Image, deploymentRequest.Version)
+	scanResult, err := runVulnerabilityGate(scanner, image, fasitEnvironmentClass)
+	if scanResult != nil {
+		api.AuditLog.Record(AuditEntry{
+			Event:       "vulnerability_scan",
+			Application: deploymentRequest.Application,
+			Namespace:   deploymentRequest.Namespace,
+			Version:     deploymentRequest.Version,
+			Details:     map[string]string{"image": image, "digest": scanResult.Digest, "summary": scanResult.Summary(), "blocked": strconv.FormatBool(scanResult.Blocked)},
+		})
+	}
 	if err != nil {
+		return &appError{err, "vulnerability scan did not pass", http.StatusBadRequest}
+	}
+
+	if appErr := api.waitForDependencies(deployment, deploymentRequest, manifest); appErr != nil {
+		return appErr
+	}
+
+	var previousDeployment *k8sextensions.Deployment
+	if deploymentRequest.PullRequest != nil && !external {
+		if previousDeployment, err = getExistingDeployment(deploymentRequest.Application, deploymentRequest.Namespace, api.Clientset); err != nil {
+			glog.Warningf("unable to get existing deployment for pull request diff: %s", err)
+		}
+	}
+
+	// hooks and verification run against the application in the cluster, which an external application or a mirror does
+	// not have of its own
+	inCluster := !external && deploymentRequest.Mirror == nil
+	var hookWarnings []string
+	if inCluster {
+		warnings, appErr := api.runHooks(deployment, PhasePreDeploy, manifest.Hooks.PreDeploy, deploymentRequest, manifest, naisResources)
+		if appErr != nil {
+			return appErr
+		}
+		hookWarnings = append(hookWarnings, warnings...)
+	}
+
+	if appErr := api.enterPhase(deployment, PhaseKubernetes); appErr != nil {
+		return appErr
+	}
+
+	var deploymentResult DeploymentResult
+	if external {
+		deploymentResult.External = true
+	} else if deploymentResult, err = createOrUpdateK8sResources(deploymentRequest, manifest, naisResources, api.ClusterSubdomain, api.IstioEnabled, api.Clientset); err != nil {
 		return &appError{err, "failed while creating or updating k8s-resources", http.StatusInternalServerError}
 	}
+	if !external {
+		api.auditDefaultEnvChanges(deploymentRequest, manifest)
+		cause := DeploymentCause{Action: "deploy", DeploymentId: deployment.Id, Version: deploymentRequest.Version, DeployedBy: deploymentRequest.OnBehalfOf}
+		if err := annotateDeploymentCause(deploymentRequest.Namespace, deploymentRequest.Application, cause, api.Clientset); err != nil {
+			if appErr := api.stepFailed(StepChangeCause, err, "unable to set change cause of deployment", &deploymentResult); appErr != nil {
+				return appErr
+			}
+		}
+	}
+	deploymentResult.ManifestChecksum = manifest.Checksum
+	deploymentResult.Warnings = append(deploymentResult.Warnings, hookWarnings...)
+
+	if deploymentRequest.Preview != nil {
+		if err := markPreviewDeployment(deploymentRequest.Namespace, deploymentRequest.Application, previewOf, deploymentRequest.Preview.Branch, previewExpires, api.Clientset); err != nil {
+			return &appError{err, "unable to mark deployment as preview", http.StatusInternalServerError}
+		}
+		deploymentResult.PreviewExpires = previewExpires.UTC().Format(time.RFC3339)
+	}
+
+	if deploymentRequest.Mirror != nil {
+		if err := startMirroring(deploymentRequest.Namespace, mirrorOf, deploymentRequest.Application, mirrorExpires, api.Clientset); err != nil {
+			return &appError{err, "unable to mirror traffic", http.StatusInternalServerError}
+		}
+		deploymentResult.MirrorOf = mirrorOf
+		deploymentResult.MirrorExpires = mirrorExpires.UTC().Format(time.RFC3339)
+	}
+
+	if deploymentRequest.DarkLaunch != nil {
+		if deploymentResult.Ingress, err = startDarkLaunch(deploymentRequest.Namespace, darkLaunchOf, deploymentRequest.Application, *deploymentRequest.DarkLaunch, manifest.Team, api.Clientset); err != nil {
+			return &appError{err, "unable to route dark launch traffic", http.StatusInternalServerError}
+		}
+		deploymentResult.DarkLaunchOf = darkLaunchOf
+		deploymentResult.DarkLaunchRoute = darkLaunchRoute(*deploymentRequest.DarkLaunch)
+	}
+
+	if scanResult != nil {
+		deploymentResult.VulnerabilityScan = scanResult.Summary()
+		if len(scanResult.Warning) > 0 {
+			deploymentResult.Warnings = append(deploymentResult.Warnings, scanResult.Warning)
+		}
+		if err := annotateDeploymentWithScanResult(deploymentRequest.Namespace, deploymentRequest.Application, *scanResult, api.Clientset); err != nil {
+			if appErr := api.stepFailed(StepScanAnnotation, err, "unable to attach vulnerability scan result to deployment", &deploymentResult); appErr != nil {
+				return appErr
+			}
+		}
+	}
+
+	if api.Egress.NetworkPolicies && !external {
+		networkPolicy, err := createOrUpdateNetworkPolicy(deploymentRequest, manifest, naisResources, api.Clientset)
+		if err != nil {
+			if appErr := api.stepFailed(StepNetworkPolicy, err, "failed while creating or updating network policy", &deploymentResult); appErr != nil {
+				return appErr
+			}
+		}
+		deploymentResult.NetworkPolicy = networkPolicy
+	}
+
+	if deploymentResult.IngressPaused {
+		go api.resumeIngressAfterRollout(deploymentRequest, manifest, naisResources)
+	}
 
 	deploys.With(prometheus.Labels{"nais_app": deploymentRequest.Application}).Inc()
 
-	if !deploymentRequest.SkipFasit && hasResources(manifest) {
-		if err := updateFasit(fasit, deploymentRequest, naisResources, manifest, createIngressHostname(deploymentRequest.Application, deploymentRequest.Namespace, api.ClusterSubdomain), fasitEnvironmentClass, deploymentRequest.FasitEnvironment, api.ClusterSubdomain); err != nil {
-			return &appError{err, "failed while updating Fasit", http.StatusInternalServerError}
+	// Fasit is written last, so a deployment cancelled before this phase leaves nothing in Fasit to clean up
+	if appErr := api.enterPhase(deployment, PhaseFasitUpdate); appErr != nil {
+		return appErr
+	}
+
+	// neither a mirror nor a dark launch is an instance of the application, so they are not registered in Fasit
+	registerInFasit := !deploymentRequest.SkipFasit && deploymentRequest.Mirror == nil && deploymentRequest.DarkLaunch == nil
+
+	hostname, domain := createIngressHostname(deploymentRequest.Application, deploymentRequest.Namespace, api.ClusterSubdomain), api.ClusterSubdomain
+	if external {
+		hostname, domain = manifest.Hostname, manifest.Hostname
+	}
+
+	// the application instance is all there is of an external application, so it is registered even without resources
+	if registerInFasit && (hasResources(manifest) || external) {
+		if err := api.resolveDataSourcePasswords(&manifest, deploymentRequest.Namespace, deploymentRequest.Application); err != nil {
+			// registering the resources without their passwords would blank them in Fasit, so nothing is registered
+			if appErr := api.stepFailed(StepFasitUpdate, err, "failed while updating Fasit", &deploymentResult); appErr != nil {
+				return appErr
+			}
+		} else {
+			// the instance the deployment replaces is looked up first, and only stopped once the new one is registered
+			var previous *ApplicationInstance
+			if api.FasitStopPrevious {
+				instance, err := fasitBackend.GetApplicationInstance(deploymentRequest.Application, deploymentRequest.FasitEnvironment)
+				if err != nil {
+					if appErr := api.stepFailed(StepFasitStopPrevious, err, "unable to find the previous application instance in Fasit", &deploymentResult); appErr != nil {
+						return appErr
+					}
+				}
+				previous = instance
+			}
+			warnings, err := updateFasit(fasitBackend, deploymentRequest, naisResources, manifest, hostname, fasitEnvironmentClass, deploymentRequest.FasitEnvironment, domain, api.FasitApplicationInstance)
+			deploymentResult.Warnings = append(deploymentResult.Warnings, warnings...)
+			if err != nil {
+				if appErr := api.stepFailed(StepFasitUpdate, err, "failed while updating Fasit", &deploymentResult); appErr != nil {
+					return appErr
+				}
+			} else if err := stopPreviousInstance(fasitBackend, deploymentRequest, deploymentRequest.FasitEnvironment, previous); err != nil {
+				if appErr := api.stepFailed(StepFasitStopPrevious, err, "unable to stop the previous application instance in Fasit", &deploymentResult); appErr != nil {
+					return appErr
+				}
+			}
+		}
+	}
+
+	if deploymentRequest.PullRequest != nil && !external {
+		if err := api.commentOnPullRequest(deploymentRequest, previousDeployment, deploymentResult); err != nil {
+			if appErr := api.stepFailed(StepPullRequestComment, err, "unable to comment on pull request", &deploymentResult); appErr != nil {
+				return appErr
+			}
+		}
+	}
+
+	// verification and post-deploy hooks test the new version, so it has to have rolled out first
+	postDeployHooks := inCluster && len(manifest.Hooks.PostDeploy) > 0
+	verify := inCluster && len(manifest.Verification.Checks) > 0
+	// the timeout of a postStart hook can only be enforced by waiting for the pods it holds
+	postStartTimeout, _ := time.ParseDuration(manifest.PostStartHook.Timeout)
+	holdsRollout := inCluster && postStartTimeout > 0
+	waitForRollout := deploymentRequest.WaitForRollout || postDeployHooks || verify || holdsRollout
+	if waitForRollout {
+		if holdsRollout {
+			if appErr := api.waitForPostStartHooks(deployment, deploymentRequest, postStartTimeout); appErr != nil {
+				return appErr
+			}
+		}
+		if appErr := api.waitForRollout(deployment, deploymentRequest); appErr != nil {
+			return appErr
+		}
+	}
+
+	if verify {
+		if appErr := api.verifyDeployment(deployment, deploymentRequest, manifest); appErr != nil {
+			return appErr
+		}
+	}
+
+	if postDeployHooks {
+		warnings, appErr := api.runHooks(deployment, PhasePostDeploy, manifest.Hooks.PostDeploy, deploymentRequest, manifest, naisResources)
+		if appErr != nil {
+			return appErr
+		}
+		deploymentResult.Warnings = append(deploymentResult.Warnings, warnings...)
+	}
+
+	// the change log tells what is running, so the event is only posted once the rollout has succeeded
+	if registerInFasit && api.FasitEventsEnabled {
+		if waitForRollout || !inCluster {
+			if err := fasitBackend.CreateDeploymentEvent(deploymentRequest, api.ClusterName); err != nil {
+				if appErr := api.stepFailed(StepFasitEvent, err, "unable to register deployment event in Fasit", &deploymentResult); appErr != nil {
+					return appErr
+				}
+			}
+		} else {
+			go api.createDeploymentEventAfterRollout(api.fasitBackend(api.fasitClient(&deploymentRequest, false)), deploymentRequest)
 		}
 	}
 
+	record.Result = DeploymentSucceeded
+	record.FasitResources = manifest.FasitResources
+	record.ManifestChecksum = manifest.Checksum
+	record.ExternalServices = manifest.ExternalServices
+	record.ResourceEndpoints = resourceEndpoints(naisResources)
+	if !external && deploymentRequest.Preview == nil && deploymentRequest.Mirror == nil && deploymentRequest.DarkLaunch == nil {
+		record.spec = newDeploymentSpec(deploymentRequest, manifest, naisResources)
+	}
+	if promotion != nil {
+		promotion.resources = naisResources
+		record.promotion = promotion
+	}
+
+	if deploymentResult.FirewallRequests, err = api.requestFirewallOpenings(record); err != nil {
+		if appErr := api.stepFailed(StepFirewallRequests, err, "unable to request firewall openings", &deploymentResult); appErr != nil {
+			return appErr
+		}
+	}
+
+	api.DeploymentHistory.Add(record)
+
 	NotifySensuAboutDeploy(&deploymentRequest, &api.ClusterName)
 
+	succeeded = true
+
 	w.WriteHeader(200)
 	w.Write(createResponse(deploymentResult))
 	return nil
@@ -200,6 +689,47 @@ func (api Api) version(w http.ResponseWriter, _ *http.Request) *appError {
 	return nil
 }
 
+func (api Api) fasitHealth(w http.ResponseWriter, _ *http.Request) *appError {
+	results := checkFasitEndpoints(api.FasitUrl, api.FasitEndpoints, api.FasitTransport)
+
+	status := http.StatusOK
+	for _, result := range results {
+		if !result.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+	return nil
+}
+
+func (api Api) audit(w http.ResponseWriter, r *http.Request) *appError {
+	query, err := parsePageQuery(r.URL.Query(), maxAuditEntries)
+	if err != nil {
+		return &appError{err, "invalid audit query", http.StatusBadRequest}
+	}
+
+	entries, total, more := api.AuditLog.Page(query)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if more {
+		w.Header().Set("Link", nextPageLink(r, entries[len(entries)-1].Sequence, query.limit))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+
+	return nil
+}
+
 func (api Api) deleteApplication(w http.ResponseWriter, r *http.Request) *appError {
 	namespace := pat.Param(r, "namespace")
 	deployName := pat.Param(r, "deployName")
@@ -261,6 +791,15 @@ func createResponse(deploymentResult DeploymentResult) []byte {
 	if deploymentResult.Ingress != nil {
 		response += "- created ingress\n"
 	}
+	if deploymentResult.FeatureToggles != nil {
+		response += "- created feature toggles configmap\n"
+	}
+	if deploymentResult.NetworkPolicy != nil {
+		response += "- created network policy\n"
+	}
+	if deploymentResult.IngressPaused {
+		response += "- paused ingress until rollout has finished\n"
+	}
 	if deploymentResult.Autoscaler != nil {
 		response += "- created autoscaler\n"
 	}
@@ -270,6 +809,30 @@ func createResponse(deploymentResult DeploymentResult) []byte {
 	if deploymentResult.Redis != nil {
 		response += "- created redis\n"
 	}
+	if deploymentResult.External {
+		response += "- external application, registered in Fasit only\n"
+	}
+	if len(deploymentResult.ManifestChecksum) > 0 {
+		response += "- manifest checksum " + deploymentResult.ManifestChecksum + "\n"
+	}
+	if len(deploymentResult.PreviewExpires) > 0 {
+		response += "- preview " + deploymentResult.Deployment.Name + " expires " + deploymentResult.PreviewExpires + "\n"
+	}
+	if len(deploymentResult.MirrorExpires) > 0 {
+		response += "- mirroring traffic from " + deploymentResult.MirrorOf + " to " + deploymentResult.Deployment.Name + " until " + deploymentResult.MirrorExpires + "\n"
+	}
+	if len(deploymentResult.DarkLaunchOf) > 0 {
+		response += "- dark launch of " + deploymentResult.DarkLaunchOf + " reached with " + deploymentResult.DarkLaunchRoute + "\n"
+	}
+	if deploymentResult.FirewallRequests > 0 {
+		response += "- requested " + strconv.Itoa(deploymentResult.FirewallRequests) + " firewall openings\n"
+	}
+	if len(deploymentResult.VulnerabilityScan) > 0 {
+		response += "- vulnerability scan " + deploymentResult.VulnerabilityScan + "\n"
+	}
+	for _, warning := range deploymentResult.Warnings {
+		response += "- warning: " + warning + "\n"
+	}
 
 	return []byte(response)
 }
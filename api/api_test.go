@@ -152,7 +152,7 @@ func TestValidDeploymentRequestAndManifestCreateResources(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 
-	api := Api{clientset, "https://fasit.local", "nais.example.tk", "test-cluster", false, nil}
+	api := Api{Clientset: clientset, FasitUrl: "https://fasit.local", ClusterSubdomain: "nais.example.tk", ClusterName: "test-cluster"}
 
 	depReq := naisrequest.Deploy{
 		Application:      appName,
@@ -167,7 +167,7 @@ func TestValidDeploymentRequestAndManifestCreateResources(t *testing.T) {
 		Image: image,
 		Port:  321,
 		FasitResources: FasitResources{
-			Used: []UsedResource{{resourceAlias, resourceType, nil}},
+			Used: []UsedResource{{resourceAlias, "", resourceType, nil, nil, "", false}},
 		},
 	}
 	response := "anything"
@@ -227,7 +227,7 @@ func TestValidDeploymentRequestAndManifestCreateResources(t *testing.T) {
 
 	assert.Equal(t, 200, rr.Code)
 	assert.True(t, gock.IsDone())
-	assert.Equal(t, "result: \n- created deployment\n- created secret\n- created service\n- created ingress\n- created autoscaler\n", string(rr.Body.Bytes()))
+	assert.Equal(t, "result: \n- created deployment\n- created secret\n- created service\n- created ingress\n- created autoscaler\n- manifest checksum "+manifestChecksum(data)+"\n", string(rr.Body.Bytes()))
 }
 
 func TestValidDeploymentRequestAndManifestCreateAlerts(t *testing.T) {
@@ -241,7 +241,7 @@ func TestValidDeploymentRequestAndManifestCreateAlerts(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 
-	api := Api{clientset, "https://fasit.local", "nais.example.tk", "test-cluster", false, nil}
+	api := Api{Clientset: clientset, FasitUrl: "https://fasit.local", ClusterSubdomain: "nais.example.tk", ClusterName: "test-cluster"}
 
 	depReq := naisrequest.Deploy{
 		Application:      appName,
@@ -298,7 +298,7 @@ func TestValidDeploymentRequestAndManifestCreateAlerts(t *testing.T) {
 
 	assert.Equal(t, 200, rr.Code)
 	assert.True(t, gock.IsDone())
-	assert.Equal(t, "result: \n- created deployment\n- created secret\n- created service\n- created ingress\n- created autoscaler\n- updated alerts configmap (app-rules)\n", string(rr.Body.Bytes()))
+	assert.Equal(t, "result: \n- created deployment\n- created secret\n- created service\n- created ingress\n- created autoscaler\n- updated alerts configmap (app-rules)\n- manifest checksum "+manifestChecksum(data)+"\n", string(rr.Body.Bytes()))
 }
 
 func TestThatFasitIsSkippedOnValidDeployment(t *testing.T) {
@@ -311,7 +311,7 @@ func TestThatFasitIsSkippedOnValidDeployment(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 
-	api := Api{clientset, "https://fasit.local", "nais.example.tk", "test-cluster", false, nil}
+	api := Api{Clientset: clientset, FasitUrl: "https://fasit.local", ClusterSubdomain: "nais.example.tk", ClusterName: "test-cluster"}
 
 	depReq := naisrequest.Deploy{
 		Application:      appName,
@@ -358,7 +358,7 @@ func TestThatFasitIsSkippedOnValidDeployment(t *testing.T) {
 
 	assert.Equal(t, 200, rr.Code)
 	assert.True(t, gock.IsDone())
-	assert.Equal(t, "result: \n- created deployment\n- created service\n- created ingress\n- created autoscaler\n- updated alerts configmap (app-rules)\n", string(rr.Body.Bytes()))
+	assert.Equal(t, "result: \n- created deployment\n- created service\n- created ingress\n- created autoscaler\n- updated alerts configmap (app-rules)\n- manifest checksum "+manifestChecksum(data)+"\n", string(rr.Body.Bytes()))
 }
 
 func TestMissingResources(t *testing.T) {
@@ -369,7 +369,7 @@ func TestMissingResources(t *testing.T) {
 		Image: "name/Container",
 		Port:  321,
 		FasitResources: FasitResources{
-			Used: []UsedResource{{resourceAlias, resourceType, nil}},
+			Used: []UsedResource{{resourceAlias, "", resourceType, nil, nil, "", false}},
 		},
 	}
 	data, _ := yaml.Marshal(manifest)
@@ -398,7 +398,7 @@ func TestMissingResources(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(CreateDefaultDeploymentRequest()))
 
 	rr := httptest.NewRecorder()
-	api := Api{fake.NewSimpleClientset(), "https://fasit.local", "nais.example.tk", "clustername", false, nil}
+	api := Api{Clientset: fake.NewSimpleClientset(), FasitUrl: "https://fasit.local", ClusterSubdomain: "nais.example.tk", ClusterName: "clustername"}
 	handler := http.Handler(appHandler(api.deploy))
 
 	handler.ServeHTTP(rr, req)
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	k8sapps "k8s.io/api/apps/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	k8sappsclient "k8s.io/client-go/kubernetes/typed/apps/v1"
+	"k8s.io/client-go/rest"
+)
+
+// API versions naisd can manage deployments and ingresses with. Objects are generated as extensions/v1beta1, and
+// converted to the version the cluster serves when they are read or written.
+const (
+	ExtensionsV1beta1 = "extensions/v1beta1"
+	AppsV1            = "apps/v1"
+	NetworkingV1beta1 = "networking.k8s.io/v1beta1"
+)
+
+// Preferred API versions first
+var (
+	deploymentApiVersions = []string{AppsV1, ExtensionsV1beta1}
+	ingressApiVersions    = []string{NetworkingV1beta1, ExtensionsV1beta1}
+	managedApiVersions    = []string{AppsV1, NetworkingV1beta1, ExtensionsV1beta1}
+)
+
+// How long the API versions a cluster serves are remembered, so upgrades of the cluster are picked up without a restart
+var apiVersionCacheTtl = 5 * time.Minute
+
+type servedApiVersions struct {
+	versions map[string]bool
+	detected time.Time
+}
+
+var apiVersionCache = struct {
+	sync.Mutex
+	clusters map[kubernetes.Interface]servedApiVersions
+}{clusters: make(map[kubernetes.Interface]servedApiVersions)}
+
+// deploymentInterface is the part of the Kubernetes deployment client naisd uses, independent of API version
+type deploymentInterface interface {
+	Get(name string, options k8smeta.GetOptions) (*k8sextensions.Deployment, error)
+	List(options k8smeta.ListOptions) (*k8sextensions.DeploymentList, error)
+	Create(deployment *k8sextensions.Deployment) (*k8sextensions.Deployment, error)
+	Update(deployment *k8sextensions.Deployment) (*k8sextensions.Deployment, error)
+	Delete(name string, options *k8smeta.DeleteOptions) error
+}
+
+// deployments returns a client for the deployments in the namespace, using the best API version the cluster serves
+func deployments(k8sClient kubernetes.Interface, namespace string) deploymentInterface {
+	if apiVersion(k8sClient, "deployments", deploymentApiVersions) == AppsV1 {
+		return appsV1Deployments{k8sClient.AppsV1().Deployments(namespace)}
+	}
+	return k8sClient.ExtensionsV1beta1().Deployments(namespace)
+}
+
+// ingresses returns a client for the ingresses in the namespace, using the best API version the cluster serves
+func ingresses(k8sClient kubernetes.Interface, namespace string) ingressInterface {
+	if apiVersion(k8sClient, "ingresses", ingressApiVersions) == NetworkingV1beta1 {
+		return networkingV1beta1Ingresses{k8sClient.ExtensionsV1beta1().RESTClient(), namespace}
+	}
+	return k8sClient.ExtensionsV1beta1().Ingresses(namespace)
+}
+
+type ingressInterface interface {
+	Get(name string, options k8smeta.GetOptions) (*k8sextensions.Ingress, error)
+	Create(ingress *k8sextensions.Ingress) (*k8sextensions.Ingress, error)
+	Update(ingress *k8sextensions.Ingress) (*k8sextensions.Ingress, error)
+	Delete(name string, options *k8smeta.DeleteOptions) error
+}
+
+// apiVersion returns the first of the versions the cluster serves the resource in. If none of them are served, or the
+// cluster can not tell, it is the last, oldest, version.
+func apiVersion(k8sClient kubernetes.Interface, resource string, versions []string) string {
+	served := servedVersions(k8sClient)
+	for _, version := range versions {
+		if served[version+"/"+resource] {
+			return version
+		}
+	}
+	return versions[len(versions)-1]
+}
+
+// servedVersions returns the group versions of managedApiVersions the cluster serves, and the resources in them as
+// version/resource
+func servedVersions(k8sClient kubernetes.Interface) map[string]bool {
+	apiVersionCache.Lock()
+	defer apiVersionCache.Unlock()
+
+	if cached, ok := apiVersionCache.clusters[k8sClient]; ok && time.Since(cached.detected) < apiVersionCacheTtl {
+		return cached.versions
+	}
+
+	served := make(map[string]bool)
+	for _, version := range managedApiVersions {
+		resources, err := k8sClient.Discovery().ServerResourcesForGroupVersion(version)
+		if err != nil {
+			continue
+		}
+		served[version] = true
+		for _, resource := range resources.APIResources {
+			served[version+"/"+resource.Name] = true
+		}
+	}
+
+	apiVersionCache.clusters[k8sClient] = servedApiVersions{served, time.Now()}
+	return served
+}
+
+// appsV1Deployments reads and writes extensions/v1beta1 deployments as apps/v1. The two have the same fields, except
+// that apps/v1 requires a selector, which extensions/v1beta1 defaults to the labels of the pod template.
+type appsV1Deployments struct {
+	client k8sappsclient.DeploymentInterface
+}
+
+func (d appsV1Deployments) Get(name string, options k8smeta.GetOptions) (*k8sextensions.Deployment, error) {
+	deployment, err := d.client.Get(name, options)
+	if err != nil {
+		return nil, err
+	}
+	return fromAppsV1(deployment)
+}
+
+func (d appsV1Deployments) List(options k8smeta.ListOptions) (*k8sextensions.DeploymentList, error) {
+	list, err := d.client.List(options)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := &k8sextensions.DeploymentList{ListMeta: list.ListMeta}
+	for i := range list.Items {
+		deployment, err := fromAppsV1(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		deployments.Items = append(deployments.Items, *deployment)
+	}
+	return deployments, nil
+}
+
+func (d appsV1Deployments) Create(deployment *k8sextensions.Deployment) (*k8sextensions.Deployment, error) {
+	converted, err := toAppsV1(deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := d.client.Create(converted)
+	if err != nil {
+		return nil, err
+	}
+	return fromAppsV1(created)
+}
+
+func (d appsV1Deployments) Update(deployment *k8sextensions.Deployment) (*k8sextensions.Deployment, error) {
+	converted, err := toAppsV1(deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := d.client.Update(converted)
+	if err != nil {
+		return nil, err
+	}
+	return fromAppsV1(updated)
+}
+
+func (d appsV1Deployments) Delete(name string, options *k8smeta.DeleteOptions) error {
+	return d.client.Delete(name, options)
+}
+
+func toAppsV1(deployment *k8sextensions.Deployment) (*k8sapps.Deployment, error) {
+	converted := &k8sapps.Deployment{}
+	if err := convertDeployment(deployment, converted); err != nil {
+		return nil, err
+	}
+
+	converted.TypeMeta = k8smeta.TypeMeta{Kind: "Deployment", APIVersion: AppsV1}
+	if converted.Spec.Selector == nil {
+		converted.Spec.Selector = &k8smeta.LabelSelector{MatchLabels: map[string]string{"app": converted.Name}}
+	}
+	return converted, nil
+}
+
+func fromAppsV1(deployment *k8sapps.Deployment) (*k8sextensions.Deployment, error) {
+	converted := &k8sextensions.Deployment{}
+	if err := convertDeployment(deployment, converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+func convertDeployment(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return fmt.Errorf("unable to convert deployment: %s", err)
+	}
+	if err := json.Unmarshal(data, to); err != nil {
+		return fmt.Errorf("unable to convert deployment: %s", err)
+	}
+	return nil
+}
+
+// networkingV1beta1Ingresses reads and writes extensions/v1beta1 ingresses as networking.k8s.io/v1beta1, which has the
+// same fields. The client-go naisd is built with has no client for it, so its REST paths are requested directly.
+type networkingV1beta1Ingresses struct {
+	client    rest.Interface
+	namespace string
+}
+
+func (i networkingV1beta1Ingresses) path(name ...string) []string {
+	return append([]string{"/apis", NetworkingV1beta1, "namespaces", i.namespace, "ingresses"}, name...)
+}
+
+func (i networkingV1beta1Ingresses) Get(name string, options k8smeta.GetOptions) (*k8sextensions.Ingress, error) {
+	return fromNetworkingV1beta1(i.client.Get().AbsPath(i.path(name)...).Do().Raw())
+}
+
+func (i networkingV1beta1Ingresses) Create(ingress *k8sextensions.Ingress) (*k8sextensions.Ingress, error) {
+	body, err := toNetworkingV1beta1(ingress)
+	if err != nil {
+		return nil, err
+	}
+	return fromNetworkingV1beta1(i.client.Post().AbsPath(i.path()...).SetHeader("Content-Type", "application/json").Body(body).Do().Raw())
+}
+
+func (i networkingV1beta1Ingresses) Update(ingress *k8sextensions.Ingress) (*k8sextensions.Ingress, error) {
+	body, err := toNetworkingV1beta1(ingress)
+	if err != nil {
+		return nil, err
+	}
+	return fromNetworkingV1beta1(i.client.Put().AbsPath(i.path(ingress.Name)...).SetHeader("Content-Type", "application/json").Body(body).Do().Raw())
+}
+
+func (i networkingV1beta1Ingresses) Delete(name string, options *k8smeta.DeleteOptions) error {
+	request := i.client.Delete().AbsPath(i.path(name)...)
+	if options != nil {
+		request = request.Body(options)
+	}
+	return request.Do().Error()
+}
+
+func toNetworkingV1beta1(ingress *k8sextensions.Ingress) ([]byte, error) {
+	converted := *ingress
+	converted.TypeMeta = k8smeta.TypeMeta{Kind: "Ingress", APIVersion: NetworkingV1beta1}
+	data, err := json.Marshal(converted)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert ingress: %s", err)
+	}
+	return data, nil
+}
+
+func fromNetworkingV1beta1(data []byte, err error) (*k8sextensions.Ingress, error) {
+	if err != nil {
+		return nil, err
+	}
+	ingress := &k8sextensions.Ingress{}
+	if err := json.Unmarshal(data, ingress); err != nil {
+		return nil, fmt.Errorf("unable to convert ingress: %s", err)
+	}
+	ingress.TypeMeta = k8smeta.TypeMeta{}
+	return ingress, nil
+}
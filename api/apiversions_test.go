@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// The fake discovery client has a copy of the clientset's Fake, so the served resources are set on it
+func serveResources(clientset *fake.Clientset, resources ...*k8smeta.APIResourceList) {
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = resources
+}
+
+func servedResources(groupVersion string, resources ...string) *k8smeta.APIResourceList {
+	list := &k8smeta.APIResourceList{GroupVersion: groupVersion}
+	for _, resource := range resources {
+		list.APIResources = append(list.APIResources, k8smeta.APIResource{Name: resource})
+	}
+	return list
+}
+
+func TestApiVersion(t *testing.T) {
+	t.Run("Oldest version is used when the cluster can not tell", func(t *testing.T) {
+		assert.Equal(t, ExtensionsV1beta1, apiVersion(fake.NewSimpleClientset(), "deployments", deploymentApiVersions))
+	})
+
+	t.Run("Preferred version is used when the cluster serves it", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		serveResources(clientset, servedResources(AppsV1, "deployments", "statefulsets"), servedResources(ExtensionsV1beta1, "deployments", "ingresses"))
+		assert.Equal(t, AppsV1, apiVersion(clientset, "deployments", deploymentApiVersions))
+	})
+
+	t.Run("Cluster upgrades are picked up when the cache expires", func(t *testing.T) {
+		ttl := apiVersionCacheTtl
+		defer func() { apiVersionCacheTtl = ttl }()
+		apiVersionCacheTtl = time.Millisecond
+
+		clientset := fake.NewSimpleClientset()
+		serveResources(clientset, servedResources(ExtensionsV1beta1, "deployments", "ingresses"))
+		assert.Equal(t, ExtensionsV1beta1, apiVersion(clientset, "deployments", deploymentApiVersions))
+
+		serveResources(clientset, servedResources(AppsV1, "deployments"))
+		time.Sleep(2 * time.Millisecond)
+		assert.Equal(t, AppsV1, apiVersion(clientset, "deployments", deploymentApiVersions))
+	})
+}
+
+func TestAppsV1Deployments(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	serveResources(clientset, servedResources(AppsV1, "deployments"))
+
+	deployment, err := createDeploymentDef([]NaisResource{}, newDefaultManifest(), naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version}, nil, false)
+	assert.NoError(t, err)
+
+	created, err := deployments(clientset, namespace).Create(deployment)
+	assert.NoError(t, err)
+	assert.Equal(t, appName, created.Name)
+
+	stored, err := clientset.AppsV1().Deployments(namespace).Get(appName, k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": appName}, stored.Spec.Selector.MatchLabels)
+	assert.Equal(t, deployment.Spec.Template.Spec.Containers[0].Image, stored.Spec.Template.Spec.Containers[0].Image)
+
+	existing, err := getExistingDeployment(appName, namespace, clientset)
+	assert.NoError(t, err)
+	assert.IsType(t, &k8sextensions.Deployment{}, existing)
+	assert.Equal(t, stored.Spec.Selector, existing.Spec.Selector)
+
+	updated, err := createDeploymentDef([]NaisResource{}, newDefaultManifest(), naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version}, existing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Spec.Selector, updated.Spec.Selector, "the selector of an existing deployment is kept")
+
+	list, err := deployments(clientset, "").List(k8smeta.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+}
+
+// networkingApiServer is a cluster that only serves ingresses in networking.k8s.io/v1beta1, keeping them by name
+func networkingApiServer(t *testing.T, stored map[string][]byte) *httptest.Server {
+	prefix := "/apis/" + NetworkingV1beta1 + "/namespaces/" + namespace + "/ingresses"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/apis/"+NetworkingV1beta1:
+			json.NewEncoder(w).Encode(servedResources(NetworkingV1beta1, "ingresses"))
+		case strings.HasPrefix(r.URL.Path, prefix):
+			name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+			body, _ := ioutil.ReadAll(r.Body)
+			switch r.Method {
+			case http.MethodPost:
+				ingress := k8sextensions.Ingress{}
+				assert.NoError(t, json.Unmarshal(body, &ingress))
+				name = ingress.Name
+				fallthrough
+			case http.MethodPut:
+				stored[name] = body
+			case http.MethodDelete:
+				delete(stored, name)
+				w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Success"}`))
+				return
+			}
+			if _, ok := stored[name]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+				return
+			}
+			w.Write(stored[name])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestNetworkingV1beta1Ingresses(t *testing.T) {
+	stored := make(map[string][]byte)
+	server := networkingApiServer(t, stored)
+	defer server.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, NetworkingV1beta1, apiVersion(clientset, "ingresses", ingressApiVersions))
+
+	ingress := &k8sextensions.Ingress{
+		ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace},
+		Spec:       k8sextensions.IngressSpec{Rules: []k8sextensions.IngressRule{{Host: "app.nais.example"}}},
+	}
+	created, err := ingresses(clientset, namespace).Create(ingress)
+	assert.NoError(t, err)
+	assert.Equal(t, ingress.Spec, created.Spec)
+	assert.Contains(t, string(stored[appName]), `"apiVersion":"`+NetworkingV1beta1+`"`)
+
+	created.Spec.Rules[0].Host = "app.nais.other"
+	_, err = ingresses(clientset, namespace).Update(created)
+	assert.NoError(t, err)
+
+	existing, err := ingresses(clientset, namespace).Get(appName, k8smeta.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "app.nais.other", existing.Spec.Rules[0].Host)
+
+	assert.NoError(t, ingresses(clientset, namespace).Delete(appName, &k8smeta.DeleteOptions{}))
+	_, err = ingresses(clientset, namespace).Get(appName, k8smeta.GetOptions{})
+	assert.True(t, k8serrors.IsNotFound(err))
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ApplicationVersions is the version of an application registered in Fasit next to the version running in the cluster.
+// Versions are empty when the application is not registered or not running.
+type ApplicationVersions struct {
+	Application string `json:"application"`
+	Environment string `json:"environment"`
+	Namespace   string `json:"namespace"`
+	Fasit       string `json:"fasit"`
+	Cluster     string `json:"cluster"`
+	InSync      bool   `json:"inSync"`
+}
+
+func imageTag(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return image[i+1:]
+}
+
+// Returns the image tag of the application's container in the running deployment
+func runningVersion(application, namespace string, k8sClient kubernetes.Interface) (string, error) {
+	deployment, err := getExistingDeployment(application, namespace, k8sClient)
+	if err != nil || deployment == nil {
+		return "", err
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	for _, container := range containers {
+		if container.Name == application {
+			return imageTag(container.Image), nil
+		}
+	}
+	if len(containers) > 0 {
+		return imageTag(containers[0].Image), nil
+	}
+	return "", nil
+}
+
+func (api Api) applicationVersions(w http.ResponseWriter, r *http.Request) *appError {
+	environment := pat.Param(r, "environment")
+	application := pat.Param(r, "application")
+
+	namespace := r.URL.Query().Get("namespace")
+	if len(namespace) == 0 {
+		namespace = environment
+	}
+
+	fasit := api.fasitBackend(api.fasitClient(&naisrequest.Deploy{Zone: r.URL.Query().Get("zone")}, api.isOperator(r)).withContext(r.Context()))
+	fasitVersion, err := applicationInstanceVersion(fasit, application, environment)
+	if err != nil {
+		return &appError{err, "unable to get application instance from Fasit", http.StatusBadGateway}
+	}
+
+	clusterVersion, err := runningVersion(application, namespace, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to get running deployment", http.StatusInternalServerError}
+	}
+
+	versions := ApplicationVersions{
+		Application: application,
+		Environment: environment,
+		Namespace:   namespace,
+		Fasit:       fasitVersion,
+		Cluster:     clusterVersion,
+		InSync:      len(fasitVersion) > 0 && fasitVersion == clusterVersion,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(versions); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestImageTag(t *testing.T) {
+	assert.Equal(t, "1.2.3", imageTag("docker.adeo.no:5000/app:1.2.3"))
+	assert.Equal(t, "", imageTag("docker.adeo.no:5000/app"))
+	assert.Equal(t, "latest", imageTag("app:latest"))
+}
+
+func TestApplicationVersions(t *testing.T) {
+	deployment := &k8sextensions.Deployment{
+		ObjectMeta: k8smeta.ObjectMeta{Name: "app", Namespace: "t1"},
+		Spec: k8sextensions.DeploymentSpec{
+			Template: k8score.PodTemplateSpec{
+				Spec: k8score.PodSpec{
+					Containers: []k8score.Container{{Name: "app", Image: "docker.adeo.no:5000/app:1.2.3"}},
+				},
+			},
+		},
+	}
+	api := Api{FasitUrl: "https://fasit.local", Clientset: fake.NewSimpleClientset(deployment)}
+
+	t.Run("Fasit and cluster versions are returned side by side", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/applicationinstances/environment/t1/application/app").
+			Reply(200).
+			JSON(map[string]string{"version": "1.2.3"})
+
+		req, _ := http.NewRequest("GET", "/version/t1/app", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		var versions ApplicationVersions
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &versions))
+		assert.Equal(t, ApplicationVersions{Application: "app", Environment: "t1", Namespace: "t1", Fasit: "1.2.3", Cluster: "1.2.3", InSync: true}, versions)
+	})
+
+	t.Run("Application missing from Fasit and cluster gives empty versions", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/applicationinstances/environment/t1/application/app").
+			Reply(404)
+
+		req, _ := http.NewRequest("GET", "/version/t1/app?namespace=default", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		var versions ApplicationVersions
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &versions))
+		assert.Equal(t, "", versions.Fasit)
+		assert.Equal(t, "", versions.Cluster)
+		assert.False(t, versions.InSync)
+	})
+
+	t.Run("Fasit error gives bad gateway", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/applicationinstances/environment/t1/application/app").
+			Reply(500)
+
+		req, _ := http.NewRequest("GET", "/version/t1/app", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadGateway, rr.Code)
+	})
+}
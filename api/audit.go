@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const maxAuditEntries = 10000
+
+type AuditEntry struct {
+	Sequence    uint64            `json:"sequence"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Event       string            `json:"event"`
+	Application string            `json:"application"`
+	Namespace   string            `json:"namespace"`
+	Version     string            `json:"version,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+// AuditLog keeps the most recent audit entries in memory. All entries are also written to the log.
+type AuditLog struct {
+	mutex    sync.RWMutex
+	entries  []AuditEntry
+	sequence uint64
+	store    StateStore
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+func (a *AuditLog) Record(entry AuditEntry) {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	glog.Infof("audit: %s %s/%s %s %v", entry.Event, entry.Namespace, entry.Application, entry.Version, entry.Details)
+
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	a.sequence++
+	entry.Sequence = a.sequence
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > maxAuditEntries {
+		a.entries = a.entries[len(a.entries)-maxAuditEntries:]
+	}
+	store := a.store
+	a.mutex.Unlock()
+
+	// stored without the lock, like the deployment history
+	saveState(store, StateAuditLog, entry.Sequence, entry, maxAuditEntries)
+}
+
+// Restore loads the entries kept in store, and keeps every entry recorded from now on in it too
+func (a *AuditLog) Restore(store StateStore) error {
+	loaded, err := store.Load(StateAuditLog)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]AuditEntry, 0, len(loaded))
+	for _, b := range loaded {
+		var entry AuditEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return fmt.Errorf("unable to restore audit log: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Sequence < entries[j].Sequence })
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.entries = append(entries, a.entries...)
+	if len(a.entries) > maxAuditEntries {
+		a.entries = a.entries[len(a.entries)-maxAuditEntries:]
+	}
+	if len(entries) > 0 && entries[len(entries)-1].Sequence > a.sequence {
+		a.sequence = entries[len(entries)-1].Sequence
+	}
+	a.store = store
+	return nil
+}
+
+func (a *AuditLog) Entries() []AuditEntry {
+	if a == nil {
+		return []AuditEntry{}
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	entries := make([]AuditEntry, len(a.entries))
+	copy(entries, a.entries)
+	return entries
+}
+
+// Replace discards the entries in the audit log, and keeps entries, oldest first, in their place
+func (a *AuditLog) Replace(entries []AuditEntry) error {
+	if len(entries) > maxAuditEntries {
+		entries = entries[len(entries)-maxAuditEntries:]
+	}
+
+	stored := make([]StateEntry, len(entries))
+	for i, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		stored[i] = StateEntry{Sequence: entry.Sequence, Entry: b}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.store != nil {
+		if err := a.store.Replace(StateAuditLog, stored); err != nil {
+			return fmt.Errorf("unable to store audit log: %s", err)
+		}
+	}
+
+	a.entries = make([]AuditEntry, len(entries))
+	copy(a.entries, entries)
+	if len(entries) > 0 {
+		a.sequence = entries[len(entries)-1].Sequence
+	}
+	return nil
+}
+
+// Page returns the entries the query asks for, oldest first, the number of entries, and whether there are more after
+// the page
+func (a *AuditLog) Page(query pageQuery) ([]AuditEntry, int, bool) {
+	if a == nil {
+		return []AuditEntry{}, 0, false
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	start := sort.Search(len(a.entries), func(i int) bool { return a.entries[i].Sequence > query.cursor })
+	end := len(a.entries)
+	if end-start > query.limit {
+		end = start + query.limit
+	}
+
+	entries := make([]AuditEntry, end-start)
+	copy(entries, a.entries[start:end])
+	return entries, len(a.entries), end < len(a.entries)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Run("Entries are recorded in order with timestamp", func(t *testing.T) {
+		auditLog := NewAuditLog()
+		auditLog.Record(AuditEntry{Event: "first", Application: "app"})
+		auditLog.Record(AuditEntry{Event: "second", Application: "app"})
+
+		entries := auditLog.Entries()
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "first", entries[0].Event)
+		assert.Equal(t, "second", entries[1].Event)
+		assert.False(t, entries[0].Timestamp.IsZero())
+	})
+
+	t.Run("Nil audit log only logs", func(t *testing.T) {
+		var auditLog *AuditLog
+		auditLog.Record(AuditEntry{Event: "event"})
+		assert.Empty(t, auditLog.Entries())
+	})
+
+	t.Run("Old entries are discarded", func(t *testing.T) {
+		auditLog := NewAuditLog()
+		for i := 0; i < maxAuditEntries+1; i++ {
+			auditLog.Record(AuditEntry{Event: "event"})
+		}
+		assert.Len(t, auditLog.Entries(), maxAuditEntries)
+	})
+
+	t.Run("Entries are served as JSON", func(t *testing.T) {
+		api := Api{AuditLog: NewAuditLog()}
+		api.AuditLog.Record(AuditEntry{Event: "provenance_verification", Application: "app", Details: map[string]string{"manifestVerified": "true"}})
+
+		req, _ := http.NewRequest("GET", "/audit", nil)
+		rr := httptest.NewRecorder()
+		appHandler(api.audit).ServeHTTP(rr, req)
+
+		var entries []AuditEntry
+		assert.Equal(t, 200, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+		assert.Equal(t, "true", entries[0].Details["manifestVerified"])
+	})
+}
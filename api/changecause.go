@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"goji.io/pat"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// shown by kubectl rollout history. The deployment controller copies the annotations of a deployment to the replica
+	// set of its revision, so each revision keeps the cause and naisd deployment it was made by.
+	ChangeCauseAnnotation     = "kubernetes.io/change-cause"
+	DeploymentCauseAnnotation = "nais.io/deployment-cause"
+	RevisionAnnotation        = "deployment.kubernetes.io/revision"
+)
+
+// DeploymentCause is what made naisd apply a deployment: a deploy, redeploy, rollback or dark launch promotion
+type DeploymentCause struct {
+	Action       string `json:"action"`
+	DeploymentId string `json:"deploymentId"`
+	Version      string `json:"version"`
+	DeployedBy   string `json:"deployedBy,omitempty"`
+}
+
+// e.g. naisd deploy of 1.2.3 by alice (deployment 1a2b3c)
+func (cause DeploymentCause) String() string {
+	s := fmt.Sprintf("naisd %s of %s", cause.Action, cause.Version)
+	if len(cause.DeployedBy) > 0 {
+		s += " by " + cause.DeployedBy
+	}
+	return s + fmt.Sprintf(" (deployment %s)", cause.DeploymentId)
+}
+
+// Revision is a revision of a Kubernetes deployment, and the naisd deployment that made it
+type Revision struct {
+	Revision     int64     `json:"revision"`
+	Current      bool      `json:"current"`
+	Created      time.Time `json:"created"`
+	ChangeCause  string    `json:"changeCause,omitempty"`
+	Action       string    `json:"action,omitempty"`
+	DeploymentId string    `json:"deploymentId,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	DeployedBy   string    `json:"deployedBy,omitempty"`
+}
+
+// annotateDeploymentCause sets the change cause of the deployment. Like the vulnerability scan result, it is set after
+// the deployment is applied, and the deployment controller copies it to the replica set of the new revision.
+func annotateDeploymentCause(namespace, application string, cause DeploymentCause, k8sClient kubernetes.Interface) error {
+	structured, err := json.Marshal(cause)
+	if err != nil {
+		return fmt.Errorf("unable to marshal deployment cause: %s", err)
+	}
+
+	deployment, err := deployments(k8sClient, namespace).Get(application, k8smeta.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get deployment: %s", err)
+	}
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Annotations[ChangeCauseAnnotation] = cause.String()
+	deployment.Annotations[DeploymentCauseAnnotation] = string(structured)
+
+	_, err = deployments(k8sClient, namespace).Update(deployment)
+	return err
+}
+
+// revisionOf reads the revision and deployment cause from the annotations of a replica set. Replica sets made before
+// naisd set the cause, or by kubectl, only have the revision.
+func revisionOf(meta k8smeta.ObjectMeta) (Revision, bool) {
+	number, err := strconv.ParseInt(meta.Annotations[RevisionAnnotation], 10, 64)
+	if err != nil {
+		return Revision{}, false
+	}
+
+	revision := Revision{Revision: number, Created: meta.CreationTimestamp.Time, ChangeCause: meta.Annotations[ChangeCauseAnnotation]}
+
+	var cause DeploymentCause
+	if err := json.Unmarshal([]byte(meta.Annotations[DeploymentCauseAnnotation]), &cause); err == nil {
+		revision.Action = cause.Action
+		revision.DeploymentId = cause.DeploymentId
+		revision.Version = cause.Version
+		revision.DeployedBy = cause.DeployedBy
+	}
+	return revision, true
+}
+
+// listRevisions returns the revisions of the application Kubernetes still has a replica set of, newest first
+func listRevisions(namespace, application string, k8sClient kubernetes.Interface) ([]Revision, error) {
+	deployment, err := getExistingDeployment(application, namespace, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get deployment: %s", err)
+	}
+	if deployment == nil {
+		return nil, nil
+	}
+
+	replicaSets, err := listReplicaSetMeta(namespace, "app="+application, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list replica sets: %s", err)
+	}
+
+	revisions := []Revision{}
+	for _, meta := range replicaSets {
+		if !ownedBy(meta, deployment.UID) {
+			continue
+		}
+		if revision, ok := revisionOf(meta); ok {
+			revision.Current = meta.Annotations[RevisionAnnotation] == deployment.Annotations[RevisionAnnotation]
+			revisions = append(revisions, revision)
+		}
+	}
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision > revisions[j].Revision })
+	return revisions, nil
+}
+
+// ownedBy is true if the object is owned by the object with the uid. Objects without owners are counted as owned, as
+// is everything when the owner has no uid, e.g. in tests.
+func ownedBy(meta k8smeta.ObjectMeta, uid types.UID) bool {
+	if len(meta.OwnerReferences) == 0 || len(uid) == 0 {
+		return true
+	}
+	for _, owner := range meta.OwnerReferences {
+		if owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// listReplicaSetMeta returns the metadata of the replica sets matching the selector, in the API version deployments
+// are managed with
+func listReplicaSetMeta(namespace, selector string, k8sClient kubernetes.Interface) ([]k8smeta.ObjectMeta, error) {
+	options := k8smeta.ListOptions{LabelSelector: selector}
+
+	var metas []k8smeta.ObjectMeta
+	if apiVersion(k8sClient, "replicasets", deploymentApiVersions) == AppsV1 {
+		list, err := k8sClient.AppsV1().ReplicaSets(namespace).List(options)
+		if err != nil {
+			return nil, err
+		}
+		for _, replicaSet := range list.Items {
+			metas = append(metas, replicaSet.ObjectMeta)
+		}
+		return metas, nil
+	}
+
+	list, err := k8sClient.ExtensionsV1beta1().ReplicaSets(namespace).List(options)
+	if err != nil {
+		return nil, err
+	}
+	for _, replicaSet := range list.Items {
+		metas = append(metas, replicaSet.ObjectMeta)
+	}
+	return metas, nil
+}
+
+// revisions maps the revisions of a deployment, as in kubectl rollout history, to the naisd deployments that made them
+func (api Api) revisions(w http.ResponseWriter, r *http.Request) *appError {
+	namespace := pat.Param(r, "namespace")
+	deployName := pat.Param(r, "deployName")
+
+	revisions, err := listRevisions(namespace, deployName, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to list revisions", http.StatusInternalServerError}
+	}
+	if revisions == nil {
+		return &appError{fmt.Errorf("no deployment %s in %s", deployName, namespace), "deployment not found", http.StatusNotFound}
+	}
+
+	body, err := json.Marshal(revisions)
+	if err != nil {
+		return &appError{err, "unable to marshal revisions", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	return nil
+}
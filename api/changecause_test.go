@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeploymentCause(t *testing.T) {
+	assert.Equal(t, "naisd deploy of 1.2.3 by alice (deployment 1a2b3c)", DeploymentCause{Action: "deploy", DeploymentId: "1a2b3c", Version: "1.2.3", DeployedBy: "alice"}.String())
+	assert.Equal(t, "naisd redeploy of 1.2.3 (deployment 1a2b3c)", DeploymentCause{Action: "redeploy", DeploymentId: "1a2b3c", Version: "1.2.3"}.String())
+}
+
+func TestAnnotateDeploymentCause(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: createObjectMeta(appName, namespace, teamName)})
+
+	assert.NoError(t, annotateDeploymentCause(namespace, appName, DeploymentCause{Action: "deploy", DeploymentId: "1a2b3c", Version: "1.2.3"}, clientset))
+
+	deployment, _ := getExistingDeployment(appName, namespace, clientset)
+	assert.Equal(t, "naisd deploy of 1.2.3 (deployment 1a2b3c)", deployment.Annotations[ChangeCauseAnnotation])
+	assert.Equal(t, `{"action":"deploy","deploymentId":"1a2b3c","version":"1.2.3"}`, deployment.Annotations[DeploymentCauseAnnotation])
+
+	assert.Error(t, annotateDeploymentCause(namespace, "unknown", DeploymentCause{}, clientset))
+}
+
+func TestRevisions(t *testing.T) {
+	replicaSet := func(name, revision string, cause *DeploymentCause) *k8sextensions.ReplicaSet {
+		meta := createObjectMeta(name, namespace, teamName)
+		meta.Labels["app"] = appName
+		meta.Annotations = map[string]string{RevisionAnnotation: revision}
+		if cause != nil {
+			structured, _ := json.Marshal(cause)
+			meta.Annotations[ChangeCauseAnnotation] = cause.String()
+			meta.Annotations[DeploymentCauseAnnotation] = string(structured)
+		}
+		return &k8sextensions.ReplicaSet{ObjectMeta: meta}
+	}
+
+	deployment := &k8sextensions.Deployment{ObjectMeta: createObjectMeta(appName, namespace, teamName)}
+	deployment.Annotations = map[string]string{RevisionAnnotation: "3"}
+	other := replicaSet(appName+"-other", "7", nil)
+	other.OwnerReferences = []k8smeta.OwnerReference{{UID: "other"}}
+	deployment.UID = "app"
+
+	clientset := fake.NewSimpleClientset(
+		deployment,
+		replicaSet(appName+"-1", "1", nil),
+		replicaSet(appName+"-2", "2", &DeploymentCause{Action: "deploy", DeploymentId: "first", Version: "1"}),
+		replicaSet(appName+"-3", "3", &DeploymentCause{Action: "redeploy", DeploymentId: "second", Version: "1", DeployedBy: "operator"}),
+		other,
+	)
+
+	revisions, err := listRevisions(namespace, appName, clientset)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 3, "replica sets of other deployments are left out")
+	assert.Equal(t, int64(3), revisions[0].Revision)
+	assert.True(t, revisions[0].Current)
+	assert.Equal(t, "second", revisions[0].DeploymentId)
+	assert.Equal(t, "operator", revisions[0].DeployedBy)
+	assert.Equal(t, "first", revisions[1].DeploymentId)
+	assert.False(t, revisions[1].Current)
+	assert.Empty(t, revisions[2].DeploymentId, "revisions made before naisd set the cause only have their number")
+
+	t.Run("Revisions are listed by the endpoint", func(t *testing.T) {
+		api := Api{Clientset: clientset}
+
+		req, _ := http.NewRequest("GET", "/revisions/"+namespace+"/"+appName, nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var listed []Revision
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&listed))
+		assert.Len(t, listed, 3)
+
+		req, _ = http.NewRequest("GET", "/revisions/"+namespace+"/unknown", nil)
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
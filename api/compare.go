@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/pmezard/go-difflib/difflib"
+	"goji.io/pat"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const DefaultCompareNamespace = "default"
+
+// EnvironmentComparison is the difference between the objects an application gets in two Fasit environments
+type EnvironmentComparison struct {
+	Application string `json:"application"`
+	Version     string `json:"version"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Identical   bool   `json:"identical"`
+	Diff        string `json:"diff"`
+}
+
+// Replaces secret values so they can be compared without being shown. Values that are the same in both environments
+// are masked the same way, values that differ are masked with the name of their environment. The masks are put in
+// stringData to be readable.
+func maskSecrets(from, to []runtime.Object, fromEnvironment, toEnvironment string) {
+	secrets := func(objects []runtime.Object) map[string]*k8score.Secret {
+		found := make(map[string]*k8score.Secret)
+		for _, obj := range objects {
+			if secret, ok := obj.(*k8score.Secret); ok {
+				found[secret.Name] = secret
+			}
+		}
+		return found
+	}
+
+	fromSecrets, toSecrets := secrets(from), secrets(to)
+	mask := func(secret, other *k8score.Secret, environment string) {
+		secret.StringData = make(map[string]string)
+		for key, value := range secret.Data {
+			if other != nil && other.Data != nil && string(other.Data[key]) == string(value) {
+				secret.StringData[key] = "<masked>"
+			} else {
+				secret.StringData[key] = "<masked, " + environment + " value>"
+			}
+		}
+	}
+
+	for name, secret := range fromSecrets {
+		mask(secret, toSecrets[name], fromEnvironment)
+	}
+	for name, secret := range toSecrets {
+		mask(secret, fromSecrets[name], toEnvironment)
+	}
+	for _, secret := range fromSecrets {
+		secret.Data = nil
+	}
+	for _, secret := range toSecrets {
+		secret.Data = nil
+	}
+}
+
+// Renders the application as it would be deployed to the environment. The namespace is the same for every
+// environment so that it does not show up as a difference. Fasit is read with naisd's credentials if
+// serviceCredentials is set.
+func (api Api) renderForEnvironment(ctx context.Context, application, version, environment, zone, namespace string, serviceCredentials bool) ([]runtime.Object, error) {
+	deploymentRequest := naisrequest.Deploy{
+		Application:      application,
+		Version:          version,
+		Namespace:        namespace,
+		FasitEnvironment: environment,
+		Zone:             zone,
+	}
+
+	manifest, err := GenerateManifestWithProfiles(deploymentRequest, api.ManifestProfiles, api.ResourceTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate manifest: %s", err)
+	}
+	manifest.DefaultEnv = api.DefaultEnv
+
+	fasit := api.fasitBackend(api.fasitClient(&deploymentRequest, serviceCredentials).withContext(ctx))
+	if err := ResolveAliasPrefixes(fasit, &manifest, environment, application, zone); err != nil {
+		return nil, fmt.Errorf("unable to fetch Fasit resources for %s: %s", environment, err)
+	}
+	naisResources, err := FetchFasitResources(fasit, application, environment, zone, manifest.FasitResources.Used)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch Fasit resources for %s: %s", environment, err)
+	}
+
+	return Render(deploymentRequest, manifest, naisResources, api.ClusterSubdomain, api.IstioEnabled)
+}
+
+func compareEnvironments(from, to []runtime.Object, fromEnvironment, toEnvironment string) (string, error) {
+	maskSecrets(from, to, fromEnvironment, toEnvironment)
+
+	fromYaml, err := MarshalObjects(from)
+	if err != nil {
+		return "", err
+	}
+	toYaml, err := MarshalObjects(to)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromYaml),
+		B:        difflib.SplitLines(toYaml),
+		FromFile: fromEnvironment,
+		ToFile:   toEnvironment,
+		Context:  3,
+	})
+}
+
+func (api Api) compare(w http.ResponseWriter, r *http.Request) *appError {
+	application := pat.Param(r, "application")
+	query := r.URL.Query()
+	fromEnvironment, toEnvironment := query.Get("from"), query.Get("to")
+	zone, version, namespace := query.Get("zone"), query.Get("version"), query.Get("namespace")
+
+	if len(fromEnvironment) == 0 || len(toEnvironment) == 0 {
+		return &appError{fmt.Errorf("from and to must be set"), "missing environment", http.StatusBadRequest}
+	}
+	if len(namespace) == 0 {
+		namespace = DefaultCompareNamespace
+	}
+
+	if len(version) == 0 {
+		fasit := api.fasitBackend(api.fasitClient(&naisrequest.Deploy{Zone: zone}, api.isOperator(r)).withContext(r.Context()))
+		registered, err := applicationInstanceVersion(fasit, application, fromEnvironment)
+		if err != nil {
+			return &appError{err, "unable to get application instance from Fasit", http.StatusBadGateway}
+		}
+		if len(registered) == 0 {
+			return &appError{fmt.Errorf("%s is not deployed to %s", application, fromEnvironment), "version must be given", http.StatusBadRequest}
+		}
+		version = registered
+	}
+
+	from, err := api.renderForEnvironment(r.Context(), application, version, fromEnvironment, zone, namespace, api.isOperator(r))
+	if err != nil {
+		return &appError{err, "unable to render " + fromEnvironment, http.StatusBadRequest}
+	}
+	to, err := api.renderForEnvironment(r.Context(), application, version, toEnvironment, zone, namespace, api.isOperator(r))
+	if err != nil {
+		return &appError{err, "unable to render " + toEnvironment, http.StatusBadRequest}
+	}
+
+	diff, err := compareEnvironments(from, to, fromEnvironment, toEnvironment)
+	if err != nil {
+		return &appError{err, "unable to compare environments", http.StatusInternalServerError}
+	}
+
+	comparison := EnvironmentComparison{
+		Application: application,
+		Version:     version,
+		From:        fromEnvironment,
+		To:          toEnvironment,
+		Identical:   len(diff) == 0,
+		Diff:        diff,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(comparison); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func secretWithData(data map[string]string) *k8score.Secret {
+	secret := &k8score.Secret{ObjectMeta: k8smeta.ObjectMeta{Name: "app", Namespace: "default"}, Data: map[string][]byte{}}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+func TestMaskSecrets(t *testing.T) {
+	from := secretWithData(map[string]string{"DB_PASSWORD": "secret", "API_KEY": "q-key", "OLD": "x"})
+	to := secretWithData(map[string]string{"DB_PASSWORD": "secret", "API_KEY": "p-key", "NEW": "y"})
+
+	maskSecrets([]runtime.Object{from}, []runtime.Object{to}, "q1", "p")
+
+	assert.Equal(t, "<masked>", from.StringData["DB_PASSWORD"])
+	assert.Equal(t, "<masked>", to.StringData["DB_PASSWORD"])
+	assert.Equal(t, "<masked, q1 value>", from.StringData["API_KEY"])
+	assert.Equal(t, "<masked, p value>", to.StringData["API_KEY"])
+	assert.Equal(t, "<masked, q1 value>", from.StringData["OLD"])
+	assert.Equal(t, "<masked, p value>", to.StringData["NEW"])
+	assert.Nil(t, from.Data)
+	assert.Nil(t, to.Data)
+}
+
+func TestCompareEnvironments(t *testing.T) {
+	t.Run("Same objects give empty diff", func(t *testing.T) {
+		diff, err := compareEnvironments(
+			[]runtime.Object{secretWithData(map[string]string{"DB_PASSWORD": "secret"})},
+			[]runtime.Object{secretWithData(map[string]string{"DB_PASSWORD": "secret"})},
+			"q1", "p",
+		)
+		assert.NoError(t, err)
+		assert.Empty(t, diff)
+	})
+
+	t.Run("Differing secret shows up masked", func(t *testing.T) {
+		diff, err := compareEnvironments(
+			[]runtime.Object{secretWithData(map[string]string{"DB_PASSWORD": "q-secret", "DB_USERNAME": "user"})},
+			[]runtime.Object{secretWithData(map[string]string{"DB_PASSWORD": "p-secret", "DB_USERNAME": "user"})},
+			"q1", "p",
+		)
+		assert.NoError(t, err)
+		assert.Contains(t, diff, "--- q1\n+++ p\n")
+		assert.Contains(t, diff, "+  DB_PASSWORD: <masked, p value>")
+		assert.Contains(t, diff, "   DB_USERNAME: <masked>")
+		assert.NotContains(t, diff, "secret\n")
+		assert.NotContains(t, diff, "cS1zZWNyZXQ")
+	})
+}
+
+func TestCompareRequiresEnvironments(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/compare/app?from=q1", nil)
+	rr := httptest.NewRecorder()
+	Api{}.Handler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DaemonConfig holds naisd settings that are too structured to be passed as command line flags
+type DaemonConfig struct {
+	FasitEndpoints       map[string]FasitEndpoint `yaml:"fasitEndpoints"`
+	Provenance           ProvenanceConfig
+	Scanner              ScannerConfig
+	Policies             Policies
+	EnvironmentClass     string                         `yaml:"environmentClass"`
+	PullRequestProviders map[string]PullRequestProvider `yaml:"pullRequestProviders"`
+	DnsAllowList         DnsAllowList                   `yaml:"dnsAllowList"`
+	EmptyDirLimits       EmptyDirLimits                 `yaml:"emptyDirLimits"`
+	Egress               EgressConfig
+	Firewall             FirewallConfig
+	DeploymentLog        DeploymentLogConfig        `yaml:"deploymentLog"`
+	ApplicationInstance  ApplicationInstanceConfig  `yaml:"applicationInstance"`
+	ManifestProfiles     map[string]string          `yaml:"manifestProfiles"`
+	ResourceTemplates    map[string]ExposedResource `yaml:"resourceTemplates"`
+	DefaultEnv           map[string]string          `yaml:"defaultEnv"`
+	Steps                DeploySteps                `yaml:"steps"`
+	FeatureFlags         map[string]FeatureFlag     `yaml:"featureFlags"`
+	OperatorToken        string                     `yaml:"operatorToken"`
+	Identities           []Identity
+}
+
+// FasitEndpoint is a Fasit instance serving a single zone/security domain
+type FasitEndpoint struct {
+	Url             string
+	Username        string
+	Password        string
+	HealthCheckPath string `yaml:"healthCheckPath"`
+}
+
+func LoadDaemonConfig(path string) (DaemonConfig, error) {
+	var config DaemonConfig
+
+	if len(path) == 0 {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("unable to read daemon config %s: %s", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("unable to unmarshal daemon config %s: %s", path, err)
+	}
+
+	for zone, endpoint := range config.FasitEndpoints {
+		if len(endpoint.Url) == 0 {
+			return config, fmt.Errorf("fasit endpoint for zone %s has no url", zone)
+		}
+	}
+
+	if err := validateResourceTemplates(config.ResourceTemplates); err != nil {
+		return config, err
+	}
+
+	if err := validateDefaultEnv(config.DefaultEnv); err != nil {
+		return config, err
+	}
+
+	if err := validateScanThresholds(config.Scanner.Thresholds); err != nil {
+		return config, err
+	}
+
+	if err := validateDeploySteps(config.Steps); err != nil {
+		return config, err
+	}
+
+	if err := validateEmptyDirLimits(config.EmptyDirLimits); err != nil {
+		return config, err
+	}
+
+	if err := validateIdentities(config.Identities, config.OperatorToken); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
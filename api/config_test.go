@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDaemonConfig(t *testing.T) {
+	t.Run("Empty path gives empty config", func(t *testing.T) {
+		config, err := LoadDaemonConfig("")
+		assert.NoError(t, err)
+		assert.Empty(t, config.FasitEndpoints)
+	})
+
+	t.Run("Fasit endpoints are read per zone", func(t *testing.T) {
+		config, err := LoadDaemonConfig("testdata/daemon_config.yaml")
+		assert.NoError(t, err)
+		assert.Len(t, config.FasitEndpoints, 2)
+		assert.Equal(t, "https://fasit-sbs.local", config.FasitEndpoints["sbs"].Url)
+		assert.Equal(t, "srvnaisd", config.FasitEndpoints["sbs"].Username)
+		assert.Equal(t, "secret", config.FasitEndpoints["sbs"].Password)
+		assert.Equal(t, "/selftest", config.FasitEndpoints["iapp"].HealthCheckPath)
+	})
+
+	t.Run("Application instance cluster and domain are read", func(t *testing.T) {
+		config, err := LoadDaemonConfig("testdata/daemon_config.yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, ApplicationInstanceConfig{ClusterName: "nais-dev-sbs", Domain: "dev-sbs.local"}, config.ApplicationInstance)
+	})
+
+	t.Run("Identities are read", func(t *testing.T) {
+		config, err := LoadDaemonConfig("testdata/daemon_config.yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, []Identity{{Name: "alice", Teams: []string{"aura"}, Token: "alice-token"}}, config.Identities)
+	})
+
+	t.Run("Missing file gives error", func(t *testing.T) {
+		_, err := LoadDaemonConfig("testdata/nonexisting.yaml")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// set on the pod template. Pods read the Secret and the feature toggle environment variables when they start, and
+	// do not notice when the content changes, so the content is hashed into the template: a changed secret or toggle
+	// makes a new revision and restarts the pods, and a deploy with the same version and content leaves them running.
+	SecretChecksumAnnotation        = "nais.io/secret-checksum"
+	FeatureToggleChecksumAnnotation = "nais.io/feature-toggle-checksum"
+)
+
+// checksumOf hashes the keys and values of the data in the order of the keys, so that the checksum of the same content
+// is always the same
+func checksumOf(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		// the lengths keep {"ab": "c"} and {"a": "bc"} apart
+		fmt.Fprintf(hash, "%d:%s%d:", len(key), key, len(data[key]))
+		hash.Write(data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// configChecksumAnnotations are the checksums of the Secret and feature toggle ConfigMap created for the resources.
+// An application without secrets or toggles gets none, as there is nothing in them to change.
+func configChecksumAnnotations(naisResources []NaisResource) map[string]string {
+	annotations := make(map[string]string)
+
+	if secretData := createSecretData(naisResources); len(secretData) > 0 {
+		annotations[SecretChecksumAnnotation] = checksumOf(secretData)
+	}
+
+	if hasFeatureToggles(naisResources) {
+		annotations[FeatureToggleChecksumAnnotation] = featureToggleChecksum(createFeatureToggleData(naisResources))
+	}
+
+	return annotations
+}
+
+// featureToggleChecksum is the checksum of the data of a feature toggle ConfigMap
+func featureToggleChecksum(data map[string]string) string {
+	toggles := make(map[string][]byte, len(data))
+	for key, value := range data {
+		toggles[key] = []byte(value)
+	}
+	return checksumOf(toggles)
+}
+
+// updateFeatureToggleChecksum sets the checksum of the toggles on the pod template of the application's deployment when
+// the toggles are synced from Fasit, so its pods are restarted and read the new toggles like after a deploy. A
+// deployment without the checksum, from before naisd set it, only gets it when the toggles have changed, so that
+// upgrading naisd does not restart every application.
+func updateFeatureToggleChecksum(namespace, application string, data map[string]string, changed bool, k8sClient kubernetes.Interface) error {
+	deployment, err := getExistingDeployment(application, namespace, k8sClient)
+	if err != nil || deployment == nil {
+		return err
+	}
+
+	checksum := featureToggleChecksum(data)
+	current, ok := deployment.Spec.Template.Annotations[FeatureToggleChecksumAnnotation]
+	if current == checksum || (!ok && !changed) {
+		return nil
+	}
+
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = make(map[string]string)
+	}
+	deployment.Spec.Template.Annotations[FeatureToggleChecksumAnnotation] = checksum
+	_, err = deployments(k8sClient, namespace).Update(deployment)
+	return err
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestChecksumOf(t *testing.T) {
+	data := map[string][]byte{"mydb_password": []byte("hunter2"), "mycert_app.jks": []byte("keystore")}
+
+	assert.Equal(t, checksumOf(data), checksumOf(map[string][]byte{"mycert_app.jks": []byte("keystore"), "mydb_password": []byte("hunter2")}))
+	assert.NotEqual(t, checksumOf(data), checksumOf(map[string][]byte{"mydb_password": []byte("hunter3"), "mycert_app.jks": []byte("keystore")}))
+	assert.NotEqual(t, checksumOf(map[string][]byte{"ab": []byte("c")}), checksumOf(map[string][]byte{"a": []byte("bc")}))
+}
+
+func TestConfigChecksumAnnotations(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Namespace: "default", Application: "app", Version: "1.0"}
+	withSecret := func(password string) []NaisResource {
+		return []NaisResource{
+			{name: "mydb", resourceType: "DataSource", properties: map[string]string{"url": "jdbc:oracle:thin:@t1"}, secret: map[string]string{"password": password}},
+			featureToggleResource(map[string]string{"newfeature": "true"}),
+		}
+	}
+
+	t.Run("Pod template is annotated with checksums of the secret and feature toggles", func(t *testing.T) {
+		spec, err := createDeploymentSpec(deploymentRequest, newDefaultManifest(), withSecret("hunter2"), false)
+		assert.NoError(t, err)
+
+		annotations := spec.Template.Annotations
+		assert.Equal(t, checksumOf(createSecretData(withSecret("hunter2"))), annotations[SecretChecksumAnnotation])
+		assert.Equal(t, checksumOf(map[string][]byte{"toggles_newfeature": []byte("true")}), annotations[FeatureToggleChecksumAnnotation])
+	})
+
+	t.Run("Pod template only changes when the content changes", func(t *testing.T) {
+		spec, err := createDeploymentSpec(deploymentRequest, newDefaultManifest(), withSecret("hunter2"), false)
+		assert.NoError(t, err)
+		same, err := createDeploymentSpec(deploymentRequest, newDefaultManifest(), withSecret("hunter2"), false)
+		assert.NoError(t, err)
+		rotated, err := createDeploymentSpec(deploymentRequest, newDefaultManifest(), withSecret("hunter3"), false)
+		assert.NoError(t, err)
+
+		assert.Equal(t, spec.Template, same.Template)
+		assert.NotEqual(t, spec.Template.Annotations[SecretChecksumAnnotation], rotated.Template.Annotations[SecretChecksumAnnotation])
+		assert.Equal(t, spec.Template.Annotations[FeatureToggleChecksumAnnotation], rotated.Template.Annotations[FeatureToggleChecksumAnnotation])
+	})
+
+	t.Run("Applications without secrets or feature toggles get no checksums", func(t *testing.T) {
+		resources := []NaisResource{{name: "myapi", resourceType: "RestService", properties: map[string]string{"url": "https://api"}}}
+
+		assert.Empty(t, configChecksumAnnotations(resources))
+	})
+}
+
+func TestUpdateFeatureToggleChecksum(t *testing.T) {
+	data := map[string]string{"toggles_newfeature": "true"}
+	clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app", Namespace: "default"}})
+
+	assert.NoError(t, updateFeatureToggleChecksum("default", "app", data, false, clientset))
+	deployment, _ := getExistingDeployment("app", "default", clientset)
+	assert.Empty(t, deployment.Spec.Template.Annotations, "deployments without the checksum are left alone until the toggles change")
+
+	assert.NoError(t, updateFeatureToggleChecksum("default", "app", data, true, clientset))
+	deployment, _ = getExistingDeployment("app", "default", clientset)
+	assert.Equal(t, featureToggleChecksum(data), deployment.Spec.Template.Annotations[FeatureToggleChecksumAnnotation])
+
+	assert.NoError(t, updateFeatureToggleChecksum("default", "missing", data, true, clientset), "applications without a deployment are skipped")
+}
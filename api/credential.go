@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// CredentialResourceType is a username and password, e.g. of a service user the application has been given. The
+// password is read from a secret in the namespace of the application, like the one of a DataSource, but is created
+// as a secret in Fasit on its own, which the resource refers to.
+const CredentialResourceType = "Credential"
+
+type CredentialResourcePayload struct {
+	Alias      string               `json:"alias"`
+	Scope      Scope                `json:"scope"`
+	Type       string               `json:"type"`
+	Properties CredentialProperties `json:"properties"`
+	Secrets    map[string]Password  `json:"secrets,omitempty"`
+	Metadata   *ResourceMetadata    `json:"metadata,omitempty"`
+}
+type CredentialProperties struct {
+	Username    string `json:"username"`
+	Description string `json:"description,omitempty"`
+}
+
+func isCredential(resourceType string) bool {
+	return strings.EqualFold(CredentialResourceType, resourceType)
+}
+
+// The password is left out until it has been created in Fasit, as it is never sent as part of the resource
+func buildCredentialPayload(resource ExposedResource, scope Scope) CredentialResourcePayload {
+	payload := CredentialResourcePayload{
+		Type:  CredentialResourceType,
+		Alias: resource.Alias,
+		Properties: CredentialProperties{
+			Username:    resource.Username,
+			Description: resource.Description,
+		},
+		Scope: scope,
+	}
+	if len(resource.passwordRef) > 0 {
+		payload.Secrets = map[string]Password{"password": {Ref: resource.passwordRef}}
+	}
+	return payload
+}
+
+// withCredentialSecret stores the password of an exposed Credential as a secret in Fasit, returning the resource with
+// a reference to it. The secret of the existing resource is kept when the password is the same, and updated when it
+// has changed, so Fasit is not given a new secret on every deployment. Other resources are returned as they are.
+func (fasit FasitClient) withCredentialSecret(resource ExposedResource, existingResource NaisResource, deploymentRequest naisrequest.Deploy) (ExposedResource, error) {
+	if !isCredential(resource.ResourceType) || len(resource.password) == 0 {
+		return resource, nil
+	}
+
+	description := fmt.Sprintf("password of %s", resource.Alias)
+	if ref := existingResource.secretRefs["password"]; len(ref) > 0 {
+		if existingResource.secret["password"] != resource.password {
+			if err := fasit.updateSecret(ref, resource.password, description, deploymentRequest); err != nil {
+				return resource, err
+			}
+		}
+		resource.passwordRef = ref
+		return resource, nil
+	}
+
+	ref, err := fasit.createSecret(resource.password, description, deploymentRequest)
+	if err != nil {
+		return resource, err
+	}
+	resource.passwordRef = ref
+	return resource, nil
+}
+
+// createSecret stores the value as a secret in Fasit, returning the url it can be referred to by
+func (fasit FasitClient) createSecret(value, description string, deploymentRequest naisrequest.Deploy) (string, error) {
+	resp, err := fasit.sendSecret("POST", fasit.FasitUrl+"/api/v2/secrets/", "create secret with the "+description, value, deploymentRequest)
+	if err != nil {
+		return "", err
+	}
+
+	ref := resp.Header.Get("Location")
+	if len(ref) == 0 {
+		return "", fmt.Errorf("didn't receive the location of the secret from Fasit")
+	}
+	return ref, nil
+}
+
+// updateSecret replaces the value of the secret Fasit has at ref
+func (fasit FasitClient) updateSecret(ref, value, description string, deploymentRequest naisrequest.Deploy) error {
+	_, err := fasit.sendSecret("PUT", ref, "update secret with the "+description, value, deploymentRequest)
+	return err
+}
+
+func (fasit FasitClient) sendSecret(method, url, operation, value string, deploymentRequest naisrequest.Deploy) (*http.Response, error) {
+	payload, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return nil, fmt.Errorf("unable to create payload (%s)", err)
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+
+	req.SetBasicAuth(deploymentRequest.FasitUsername, deploymentRequest.FasitPassword)
+	req.Header.Set("Content-Type", "application/json")
+	setFasitAuditHeaders(req, deploymentRequest, operation)
+
+	resp, _, fasitErr := fasit.exchange(operation, req)
+	if fasitErr != nil {
+		return nil, fasitErr
+	}
+	return resp, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestCredential(t *testing.T) {
+	resource := ExposedResource{Alias: "myapp_srvuser", ResourceType: "credential", Username: "srvmyapp", PasswordSecret: "myapp-srvuser"}
+	resource.password = "secret"
+
+	t.Run("Payload refers to the password instead of holding it", func(t *testing.T) {
+		payload, err := json.Marshal(buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"))
+		assert.NoError(t, err)
+		assert.NotContains(t, string(payload), "secret")
+
+		withRef := resource
+		withRef.passwordRef = "https://fasit.local/api/v2/secrets/7"
+		payload, err = json.Marshal(buildResourcePayload(withRef, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"alias":"myapp_srvuser","scope":{"environmentclass":"t","environment":"t1","zone":"fss"},"type":"Credential",`+
+			`"properties":{"username":"srvmyapp"},"secrets":{"password":{"ref":"https://fasit.local/api/v2/secrets/7"}}}`, string(payload))
+	})
+
+	t.Run("Password is created as a secret before the resource", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Post("/api/v2/secrets").
+			HeaderPresent("Authorization").
+			JSON(map[string]string{"value": "secret"}).
+			Reply(201).
+			SetHeader("Location", "https://fasit.local/api/v2/secrets/7")
+		gock.New("https://fasit.local").
+			Post("/api/v2/resources").
+			BodyString(`"secrets":{"password":{"ref":"https://fasit.local/api/v2/secrets/7"}}`).
+			Reply(201).
+			SetHeader("Location", "https://fasit.local/api/v2/resources/4242")
+
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+		id, err := fasit.CreateResource(resource, "t", "t1", "myapp.nais.local", ResourceMetadata{}, naisrequest.Deploy{Application: "myapp", Zone: "fss"})
+		assert.NoError(t, err)
+		assert.Equal(t, 4242, id)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Failing to create the secret fails the resource", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Post("/api/v2/secrets").
+			Reply(500)
+
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+		_, err := fasit.CreateResource(resource, "t", "t1", "myapp.nais.local", ResourceMetadata{}, naisrequest.Deploy{Application: "myapp", Zone: "fss"})
+		assert.Error(t, err)
+	})
+
+	t.Run("The secret of the existing resource is kept when the password is the same", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Put("/api/v2/resources/4242").
+			BodyString(`"secrets":{"password":{"ref":"https://fasit.local/api/v2/secrets/7"}}`).
+			Reply(200)
+
+		existing := NaisResource{id: 4242, secret: map[string]string{"password": "secret"}, secretRefs: map[string]string{"password": "https://fasit.local/api/v2/secrets/7"}}
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+		id, err := fasit.UpdateResource(existing, resource, "t", "t1", "myapp.nais.local", ResourceMetadata{}, naisrequest.Deploy{Application: "myapp", Zone: "fss"})
+		assert.NoError(t, err)
+		assert.Equal(t, 4242, id)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("The secret of the existing resource is updated when the password has changed", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Put("/api/v2/secrets/7").
+			JSON(map[string]string{"value": "secret"}).
+			Reply(200)
+		gock.New("https://fasit.local").
+			Put("/api/v2/resources/4242").
+			BodyString(`"secrets":{"password":{"ref":"https://fasit.local/api/v2/secrets/7"}}`).
+			Reply(200)
+
+		existing := NaisResource{id: 4242, secret: map[string]string{"password": "old"}, secretRefs: map[string]string{"password": "https://fasit.local/api/v2/secrets/7"}}
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+		_, err := fasit.UpdateResource(existing, resource, "t", "t1", "myapp.nais.local", ResourceMetadata{}, naisrequest.Deploy{Application: "myapp", Zone: "fss"})
+		assert.NoError(t, err)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Username and password secret must be specified", func(t *testing.T) {
+		exposed := func(resource ExposedResource) NaisManifest {
+			return NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{resource}}}
+		}
+		assert.Nil(t, validateResources(exposed(resource)))
+		assert.Nil(t, validateExposedResourceProperties(exposed(resource)))
+		assert.NotNil(t, validateExposedResourceProperties(exposed(ExposedResource{Alias: "myapp_srvuser", ResourceType: "Credential", Username: "srvmyapp"})))
+	})
+}
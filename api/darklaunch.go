@@ -0,0 +1,263 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DarkLaunchOfLabel = "nais.io/dark-launch-of"
+	// nginx sends requests matching the header or cookie of a canary ingress to its backend instead of the one of the
+	// ingress with the same host
+	CanaryAnnotation         = "nginx.ingress.kubernetes.io/canary"
+	CanaryByHeaderAnnotation = "nginx.ingress.kubernetes.io/canary-by-header"
+	CanaryByCookieAnnotation = "nginx.ingress.kubernetes.io/canary-by-cookie"
+	DefaultDarkLaunchHeader  = "X-Nais-Dark-Launch"
+	darkLaunchSuffix         = "-dark"
+)
+
+// darkLaunchApplicationName is the name of the dark launch of the application
+func darkLaunchApplicationName(application string) string {
+	return suffixedApplicationName(application, darkLaunchSuffix)
+}
+
+// checkDarkLaunchName fails if the name of the dark launch is taken by something other than an earlier dark launch of
+// the application, like an application that happens to end in -dark
+func checkDarkLaunchName(namespace, darkLaunch, application string, k8sClient kubernetes.Interface) error {
+	deployment, err := getExistingDeployment(darkLaunch, namespace, k8sClient)
+	if err != nil || deployment == nil {
+		return err
+	}
+
+	switch darkLaunchOf := deployment.Labels[DarkLaunchOfLabel]; darkLaunchOf {
+	case application:
+		return nil
+	case "":
+		return fmt.Errorf("%s/%s is an application, not a dark launch", namespace, darkLaunch)
+	default:
+		return fmt.Errorf("%s/%s is the dark launch of %s", namespace, darkLaunch, darkLaunchOf)
+	}
+}
+
+// Describes how requests reach the dark launch, e.g. header X-Nais-Dark-Launch: always
+func darkLaunchRoute(darkLaunch naisrequest.DarkLaunch) string {
+	var routes []string
+	if len(darkLaunch.Header) > 0 {
+		routes = append(routes, "header "+darkLaunch.Header+": always")
+	}
+	if len(darkLaunch.Cookie) > 0 {
+		routes = append(routes, "cookie "+darkLaunch.Cookie+"=always")
+	}
+	if len(routes) == 2 {
+		return routes[0] + " or " + routes[1]
+	}
+	return routes[0]
+}
+
+// Rewrites the deployment into a dark launch of the application. The dark launch gets its traffic through the ingress
+// created by startDarkLaunch instead of one of its own, and is never registered in Fasit, but uses the same Fasit
+// resources as the application.
+func applyDarkLaunch(deploymentRequest *naisrequest.Deploy, manifest *NaisManifest) {
+	if len(deploymentRequest.DarkLaunch.Header) == 0 && len(deploymentRequest.DarkLaunch.Cookie) == 0 {
+		deploymentRequest.DarkLaunch.Header = DefaultDarkLaunchHeader
+	}
+
+	deploymentRequest.Application = darkLaunchApplicationName(deploymentRequest.Application)
+	manifest.Ingress.Disabled = true
+	manifest.FasitResources.Exposed = nil
+}
+
+// Marks the dark launch deployment and creates a canary ingress with the hosts and paths of the application's ingress,
+// sending requests with the header or cookie of the dark launch to it
+func startDarkLaunch(namespace, application, darkLaunch string, route naisrequest.DarkLaunch, teamName string, k8sClient kubernetes.Interface) (*k8sextensions.Ingress, error) {
+	ingress, err := getExistingIngress(application, namespace, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get ingress: %s", err)
+	}
+	if ingress == nil {
+		return nil, fmt.Errorf("application %s has no ingress to route dark launch traffic from", application)
+	}
+
+	deployment, err := deployments(k8sClient, namespace).Get(darkLaunch, k8smeta.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get deployment: %s", err)
+	}
+	if deployment.Labels == nil {
+		deployment.Labels = make(map[string]string)
+	}
+	deployment.Labels[DarkLaunchOfLabel] = application
+	if _, err := deployments(k8sClient, namespace).Update(deployment); err != nil {
+		return nil, fmt.Errorf("unable to mark dark launch deployment: %s", err)
+	}
+
+	canary, err := getExistingIngress(darkLaunch, namespace, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get existing dark launch ingress: %s", err)
+	}
+	if canary == nil {
+		canary = createIngressDef(darkLaunch, namespace, teamName)
+	}
+
+	if canary.Labels == nil {
+		canary.Labels = make(map[string]string)
+	}
+	canary.Labels[DarkLaunchOfLabel] = application
+	canary.Annotations = map[string]string{CanaryAnnotation: "true"}
+	if len(route.Header) > 0 {
+		canary.Annotations[CanaryByHeaderAnnotation] = route.Header
+	}
+	if len(route.Cookie) > 0 {
+		canary.Annotations[CanaryByCookieAnnotation] = route.Cookie
+	}
+
+	canary.Spec.TLS = ingress.Spec.TLS
+	canary.Spec.Rules = nil
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		var paths []k8sextensions.HTTPIngressPath
+		for _, path := range rule.HTTP.Paths {
+			path.Backend.ServiceName = darkLaunch
+			paths = append(paths, path)
+		}
+		canary.Spec.Rules = append(canary.Spec.Rules, k8sextensions.IngressRule{
+			Host:             rule.Host,
+			IngressRuleValue: k8sextensions.IngressRuleValue{HTTP: &k8sextensions.HTTPIngressRuleValue{Paths: paths}},
+		})
+	}
+
+	return createOrUpdateIngressResource(canary, namespace, k8sClient)
+}
+
+// getDarkLaunch returns the application the deployment is a dark launch of, if the request is authenticated as an
+// identity of its team, or an appError if it is not a dark launch or the identity can not act for the team
+func (api Api) getDarkLaunch(r *http.Request, namespace, deployName string) (string, Identity, *appError) {
+	deployment, err := getExistingDeployment(deployName, namespace, api.Clientset)
+	if err != nil {
+		return "", Identity{}, &appError{err, "unable to get deployment", http.StatusInternalServerError}
+	}
+	if deployment == nil || len(deployment.Labels[DarkLaunchOfLabel]) == 0 {
+		return "", Identity{}, &appError{fmt.Errorf("%s/%s is not a dark launch", namespace, deployName), "dark launch not found", http.StatusNotFound}
+	}
+
+	identity, appErr := api.authorizeTeam(r, deployment.Labels["team"])
+	if appErr != nil {
+		return "", identity, appErr
+	}
+	return deployment.Labels[DarkLaunchOfLabel], identity, nil
+}
+
+// promoteDarkLaunch deploys the version of a dark launch as the application itself, and deletes the dark launch. Like
+// a redeploy, the application is deployed from the deployment history, so only dark launches made since naisd started
+// can be promoted, and nothing is written to Fasit. The application must follow the deployment policy of its namespace,
+// as the dark launch is promoted without another deployment.
+func (api Api) promoteDarkLaunch(w http.ResponseWriter, r *http.Request) *appError {
+	namespace := pat.Param(r, "namespace")
+	deployName := pat.Param(r, "deployName")
+
+	application, identity, appErr := api.getDarkLaunch(r, namespace, deployName)
+	if appErr != nil {
+		return appErr
+	}
+
+	darkLaunch, ok := api.DeploymentHistory.lastDarkLaunch(namespace, deployName)
+	if !ok {
+		return &appError{fmt.Errorf("no dark launch %s in %s since naisd started that can be promoted", deployName, namespace), "nothing to promote", http.StatusNotFound}
+	}
+	spec := darkLaunch.promotion
+
+	policyEnvironmentClass, err := api.policyEnvironmentClass(namespace, "")
+	if err != nil {
+		return &appError{err, "unable to get environment class for deployment policy", http.StatusInternalServerError}
+	}
+	if appErr := api.enforceDeploymentPolicy(r, spec.request, spec.manifest, spec.declared, policyEnvironmentClass); appErr != nil {
+		return appErr
+	}
+
+	api.Status.deploymentStarted()
+	defer api.Status.deploymentFinished()
+
+	deployment, err := api.Deployments.start(r.Context(), spec.request, api.MaxDeployDuration)
+	if err != nil {
+		return &appError{err, "application is already being deployed", http.StatusConflict}
+	}
+	succeeded := false
+	record := darkLaunch
+	record.Timestamp = time.Time{}
+	record.DeploymentId = deployment.Id
+	record.Application = application
+	record.FasitResources = spec.manifest.FasitResources
+	record.PreviousVersion = api.DeploymentHistory.previousVersion(record.Environment, namespace, application)
+	record.spec = spec
+	record.promotion = nil
+	defer func() {
+		api.Deployments.finish(deployment, succeeded)
+		if !succeeded {
+			record.Result = deployment.Status
+		}
+		api.logDeployment(record, "promotion", deployment.Started)
+	}()
+	w.Header().Set("X-Deployment-Id", deployment.Id)
+
+	glog.Infof("Promoting dark launch %s of %s:%s in %s\n", deployName, application, spec.request.Version, namespace)
+
+	if appErr := api.enterPhase(deployment, PhaseKubernetes); appErr != nil {
+		return appErr
+	}
+
+	deploymentResult, appErr := api.applyDeploymentSpec(spec, DeploymentCause{Action: "promotion", DeploymentId: deployment.Id, Version: spec.request.Version, DeployedBy: darkLaunch.DeployedBy})
+	if appErr != nil {
+		return appErr
+	}
+
+	if _, err := deleteK8sResouces(namespace, deployName, api.Clientset); err != nil {
+		return &appError{err, "unable to delete dark launch after promoting it", http.StatusInternalServerError}
+	}
+
+	record.Result = DeploymentSucceeded
+	api.DeploymentHistory.Add(record)
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "dark_launch_promoted",
+		Application: application,
+		Namespace:   namespace,
+		Version:     spec.request.Version,
+		Details:     map[string]string{"darkLaunch": deployName, "from": darkLaunch.DeploymentId, "promotedBy": identity.Name},
+	})
+
+	succeeded = true
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(createResponse(deploymentResult))
+	return nil
+}
+
+// discardDarkLaunch deletes a dark launch and its ingress, leaving the application alone
+func (api Api) discardDarkLaunch(w http.ResponseWriter, r *http.Request) *appError {
+	namespace := pat.Param(r, "namespace")
+	deployName := pat.Param(r, "deployName")
+
+	application, identity, appErr := api.getDarkLaunch(r, namespace, deployName)
+	if appErr != nil {
+		return appErr
+	}
+
+	if _, err := deleteK8sResouces(namespace, deployName, api.Clientset); err != nil {
+		return &appError{err, "unable to delete dark launch", http.StatusInternalServerError}
+	}
+
+	api.AuditLog.Record(AuditEntry{Event: "dark_launch_discarded", Application: application, Namespace: namespace, Details: map[string]string{"discardedBy": identity.Name}})
+	glog.Infof("Discarded dark launch %s in %s\n", deployName, namespace)
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
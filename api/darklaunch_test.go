@@ -0,0 +1,203 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDarkLaunchApplicationName(t *testing.T) {
+	assert.Equal(t, "app-dark", darkLaunchApplicationName("app"))
+
+	long := darkLaunchApplicationName(strings.Repeat("a", 100))
+	assert.True(t, len(long) <= maxApplicationNameLength)
+	assert.True(t, strings.HasSuffix(long, darkLaunchSuffix))
+	assert.NotEqual(t, long, darkLaunchApplicationName(strings.Repeat("a", 100)+"b"), "long applications with the same beginning get different dark launches")
+}
+
+func TestCheckDarkLaunchName(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-dark", Namespace: namespace, Labels: map[string]string{DarkLaunchOfLabel: "app"}}},
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "other-dark", Namespace: namespace}},
+	)
+
+	assert.NoError(t, checkDarkLaunchName(namespace, "new-dark", "new", clientset))
+	assert.NoError(t, checkDarkLaunchName(namespace, "app-dark", "app", clientset), "an application is dark launched again")
+	assert.EqualError(t, checkDarkLaunchName(namespace, "other-dark", "other", clientset), namespace+"/other-dark is an application, not a dark launch")
+	assert.EqualError(t, checkDarkLaunchName(namespace, "app-dark", "app2", clientset), namespace+"/app-dark is the dark launch of app")
+}
+
+func TestDarkLaunchOfMirrorIsRejected(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	api := Api{Clientset: clientset}
+
+	for _, body := range []string{
+		`{"application": "app", "version": "1", "namespace": "default", "mirror": {}, "darkLaunch": {}}`,
+		`{"application": "app", "version": "1", "namespace": "default", "preview": {"branch": "feature"}, "darkLaunch": {}}`,
+	} {
+		req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		appHandler(api.deploy).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "dark launch can not be combined with preview or mirror")
+	}
+	assert.Empty(t, clientset.Actions(), "nothing is created")
+}
+
+func TestDarkLaunchRoute(t *testing.T) {
+	assert.Equal(t, "header X-Canary: always", darkLaunchRoute(naisrequest.DarkLaunch{Header: "X-Canary"}))
+	assert.Equal(t, "cookie canary=always", darkLaunchRoute(naisrequest.DarkLaunch{Cookie: "canary"}))
+	assert.Equal(t, "header X-Canary: always or cookie canary=always", darkLaunchRoute(naisrequest.DarkLaunch{Header: "X-Canary", Cookie: "canary"}))
+}
+
+func TestApplyDarkLaunch(t *testing.T) {
+	t.Run("Dark launch has no ingress of its own and exposes no resources", func(t *testing.T) {
+		request := naisrequest.Deploy{Application: "app", DarkLaunch: &naisrequest.DarkLaunch{Cookie: "canary"}}
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "api"}}}}
+
+		applyDarkLaunch(&request, &manifest)
+		assert.Equal(t, "app-dark", request.Application)
+		assert.True(t, manifest.Ingress.Disabled)
+		assert.Empty(t, manifest.FasitResources.Exposed)
+		assert.Empty(t, request.DarkLaunch.Header)
+	})
+
+	t.Run("Default header is used when neither header nor cookie is set", func(t *testing.T) {
+		request := naisrequest.Deploy{Application: "app", DarkLaunch: &naisrequest.DarkLaunch{}}
+		applyDarkLaunch(&request, &NaisManifest{})
+		assert.Equal(t, DefaultDarkLaunchHeader, request.DarkLaunch.Header)
+	})
+}
+
+func TestStartDarkLaunch(t *testing.T) {
+	route := naisrequest.DarkLaunch{Header: "X-Canary", Cookie: "canary"}
+
+	t.Run("Application without ingress can not be dark launched", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-dark", Namespace: namespace}})
+
+		_, err := startDarkLaunch(namespace, appName, "app-dark", route, teamName, clientset)
+		assert.EqualError(t, err, "application "+appName+" has no ingress to route dark launch traffic from")
+	})
+
+	t.Run("Canary ingress routes the hosts of the application to the dark launch", func(t *testing.T) {
+		ingress := createIngressDef(appName, namespace, teamName)
+		ingress.Spec.Rules = []k8sextensions.IngressRule{createIngressRule(appName, "app.nais.example.no", ""), createIngressRule(appName, "app.example.no", "api")}
+		clientset := fake.NewSimpleClientset(ingress, &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-dark", Namespace: namespace}})
+
+		_, err := startDarkLaunch(namespace, appName, "app-dark", route, teamName, clientset)
+		assert.NoError(t, err)
+
+		canary, _ := getExistingIngress("app-dark", namespace, clientset)
+		assert.Equal(t, "true", canary.Annotations[CanaryAnnotation])
+		assert.Equal(t, "X-Canary", canary.Annotations[CanaryByHeaderAnnotation])
+		assert.Equal(t, "canary", canary.Annotations[CanaryByCookieAnnotation])
+		assert.Len(t, canary.Spec.Rules, 2)
+		assert.Equal(t, "app.example.no", canary.Spec.Rules[1].Host)
+		assert.Equal(t, "/api", canary.Spec.Rules[1].HTTP.Paths[0].Path)
+		assert.Equal(t, "app-dark", canary.Spec.Rules[1].HTTP.Paths[0].Backend.ServiceName)
+
+		deployment, _ := getExistingDeployment("app-dark", namespace, clientset)
+		assert.Equal(t, appName, deployment.Labels[DarkLaunchOfLabel])
+
+		stable, _ := getExistingIngress(appName, namespace, clientset)
+		assert.Equal(t, appName, stable.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName, "the application's ingress is left alone")
+	})
+}
+
+func TestPromoteAndDiscardDarkLaunch(t *testing.T) {
+	darkLaunch := func() *k8sextensions.Deployment {
+		return &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-dark", Namespace: namespace, Labels: map[string]string{DarkLaunchOfLabel: appName, "team": teamName}}}
+	}
+	identities := []Identity{{Name: "alice", Teams: []string{teamName}, Token: "alice-token"}, {Name: "bob", Teams: []string{"other"}, Token: "bob-token"}}
+	postAs := func(api Api, path, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, requestWithMethod("POST", path, token))
+		return rr
+	}
+	post := func(api Api, path string) *httptest.ResponseRecorder {
+		return postAs(api, path, "alice-token")
+	}
+
+	t.Run("Deployments that are not dark launches are not found", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}})
+		api := Api{Clientset: clientset, DeploymentHistory: NewDeploymentHistory(), AuditLog: NewAuditLog(), Identities: identities}
+
+		assert.Equal(t, http.StatusNotFound, post(api, "/darklaunch/"+namespace+"/"+appName+"/promote").Code)
+		assert.Equal(t, http.StatusNotFound, post(api, "/darklaunch/"+namespace+"/"+appName+"/discard").Code)
+	})
+
+	t.Run("Dark launch without a spec in the history can not be promoted", func(t *testing.T) {
+		api := Api{Clientset: fake.NewSimpleClientset(darkLaunch()), DeploymentHistory: NewDeploymentHistory(), AuditLog: NewAuditLog(), Identities: identities}
+
+		assert.Equal(t, http.StatusNotFound, post(api, "/darklaunch/"+namespace+"/app-dark/promote").Code)
+	})
+
+	t.Run("Promoting deploys the version as the application and deletes the dark launch", func(t *testing.T) {
+		stableRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: "2", FasitEnvironment: "t1", Zone: "fss"}
+		history := NewDeploymentHistory()
+		history.Add(DeploymentRecord{DeploymentId: "stable", Application: appName, Namespace: namespace, Environment: "t1", Version: "1"})
+		history.Add(DeploymentRecord{
+			DeploymentId: "dark",
+			Application:  "app-dark",
+			Namespace:    namespace,
+			Environment:  "t1",
+			Version:      "2",
+			promotion:    newDeploymentSpec(stableRequest, newDefaultManifest(), []NaisResource{}),
+		})
+		clientset := fake.NewSimpleClientset(darkLaunch(), alertsConfigMap())
+		api := Api{Clientset: clientset, ClusterSubdomain: "nais.example.no", DeploymentHistory: history, AuditLog: NewAuditLog(), Identities: identities}
+
+		assert.Equal(t, http.StatusUnauthorized, postAs(api, "/darklaunch/"+namespace+"/app-dark/promote", "").Code)
+		assert.Equal(t, http.StatusForbidden, postAs(api, "/darklaunch/"+namespace+"/app-dark/promote", "bob-token").Code)
+
+		rr := post(api, "/darklaunch/"+namespace+"/app-dark/promote")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		deployment, _ := getExistingDeployment(appName, namespace, clientset)
+		assert.NotNil(t, deployment)
+		dark, _ := getExistingDeployment("app-dark", namespace, clientset)
+		assert.Nil(t, dark)
+
+		records := history.Records()
+		assert.Len(t, records, 3)
+		assert.Equal(t, appName, records[2].Application)
+		assert.Equal(t, "1", records[2].PreviousVersion)
+		assert.NotNil(t, records[2].spec, "the promoted version can be redeployed")
+		assert.Equal(t, "dark_launch_promoted", api.AuditLog.Entries()[0].Event)
+		assert.Equal(t, "alice", api.AuditLog.Entries()[0].Details["promotedBy"])
+	})
+
+	t.Run("Promotions follow the deployment policy", func(t *testing.T) {
+		stableRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: "2", FasitEnvironment: "t1", Zone: "fss"}
+		history := NewDeploymentHistory()
+		history.Add(DeploymentRecord{DeploymentId: "dark", Application: "app-dark", Namespace: namespace, Environment: "t1", Version: "2", promotion: newDeploymentSpec(stableRequest, newDefaultManifest(), []NaisResource{})})
+		clientset := fake.NewSimpleClientset(darkLaunch(), alertsConfigMap())
+		api := Api{Clientset: clientset, DeploymentHistory: history, AuditLog: NewAuditLog(), Identities: identities, Policies: Policies{DefaultPolicyKey: {MinReplicas: 5}}}
+
+		assert.Equal(t, http.StatusBadRequest, post(api, "/darklaunch/"+namespace+"/app-dark/promote").Code)
+		deployment, _ := getExistingDeployment(appName, namespace, clientset)
+		assert.Nil(t, deployment)
+	})
+
+	t.Run("Discarding deletes the dark launch", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(darkLaunch(), createIngressDef("app-dark", namespace, teamName), alertsConfigMap())
+		api := Api{Clientset: clientset, DeploymentHistory: NewDeploymentHistory(), AuditLog: NewAuditLog(), Identities: identities}
+
+		assert.Equal(t, http.StatusForbidden, postAs(api, "/darklaunch/"+namespace+"/app-dark/discard", "bob-token").Code)
+		assert.Equal(t, http.StatusOK, post(api, "/darklaunch/"+namespace+"/app-dark/discard").Code)
+
+		dark, _ := getExistingDeployment("app-dark", namespace, clientset)
+		assert.Nil(t, dark)
+		canary, _ := getExistingIngress("app-dark", namespace, clientset)
+		assert.Nil(t, canary)
+		assert.Equal(t, "dark_launch_discarded", api.AuditLog.Entries()[0].Event)
+	})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DataSourceResourceType = "DataSource"
+
+	// defaultPasswordKey is the key of the password in the secret of an exposed DataSource, unless passwordKey is set
+	defaultPasswordKey = "password"
+)
+
+type DataSourceResourcePayload struct {
+	Alias      string               `json:"alias"`
+	Scope      Scope                `json:"scope"`
+	Type       string               `json:"type"`
+	Properties DataSourceProperties `json:"properties"`
+	Secrets    map[string]Password  `json:"secrets"`
+	Metadata   *ResourceMetadata    `json:"metadata,omitempty"`
+}
+type DataSourceProperties struct {
+	Url         string `json:"url"`
+	Username    string `json:"username"`
+	Description string `json:"description,omitempty"`
+}
+
+func isDataSource(resourceType string) bool {
+	return strings.EqualFold(DataSourceResourceType, resourceType)
+}
+
+// The password is given to Fasit as the value of a secret, which Fasit stores and refers to from the resource
+func buildDataSourcePayload(resource ExposedResource, scope Scope) DataSourceResourcePayload {
+	return DataSourceResourcePayload{
+		Type:  DataSourceResourceType,
+		Alias: resource.Alias,
+		Properties: DataSourceProperties{
+			Url:         resource.Url,
+			Username:    resource.Username,
+			Description: resource.Description,
+		},
+		Secrets: map[string]Password{"password": {Value: resource.password}},
+		Scope:   scope,
+	}
+}
+
+func validateDataSources(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Exposed {
+		if !isDataSource(resource.ResourceType) {
+			continue
+		}
+
+		// that the url, username and password secret are there is checked by validateExposedResourceProperties
+		if len(resource.Url) > 0 && !strings.HasPrefix(resource.Url, "jdbc:") {
+			return &ValidationError{"Url of an exposed DataSource must be a JDBC url", map[string]string{"Alias": resource.Alias, "Url": resource.Url}}
+		}
+	}
+
+	return nil
+}
+
+// resolveDataSourcePasswords reads the passwords of the exposed DataSources and Credentials from the secrets in the
+// namespace of the application, so they can be sent to Fasit without ever being in the manifest. Only secrets labelled
+// with the application are read, so a manifest can not publish the secrets of other applications in the namespace. The
+// exposed resources are copied, as the manifest may be shared with other deployments.
+func (api Api) resolveDataSourcePasswords(manifest *NaisManifest, namespace, application string) error {
+	exposed := make([]ExposedResource, len(manifest.FasitResources.Exposed))
+	copy(exposed, manifest.FasitResources.Exposed)
+
+	for i, resource := range exposed {
+		if !isDataSource(resource.ResourceType) && !isCredential(resource.ResourceType) {
+			continue
+		}
+
+		resourceType := DataSourceResourceType
+		if isCredential(resource.ResourceType) {
+			resourceType = CredentialResourceType
+		}
+
+		key := resource.PasswordKey
+		if len(key) == 0 {
+			key = defaultPasswordKey
+		}
+
+		secret, err := api.Clientset.CoreV1().Secrets(namespace).Get(resource.PasswordSecret, k8smeta.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			return fmt.Errorf("secret %s with the password of %s %s not found in %s", resource.PasswordSecret, resourceType, resource.Alias, namespace)
+		case err != nil:
+			return fmt.Errorf("unable to read the password of %s %s: %s", resourceType, resource.Alias, err)
+		}
+		if secret.Labels["app"] != application {
+			return fmt.Errorf("secret %s with the password of %s %s is not labelled app: %s", resource.PasswordSecret, resourceType, resource.Alias, application)
+		}
+
+		password := string(secret.Data[key])
+		if len(password) == 0 {
+			return fmt.Errorf("secret %s has no %s for %s %s", resource.PasswordSecret, key, resourceType, resource.Alias)
+		}
+		exposed[i].password = password
+	}
+
+	manifest.FasitResources.Exposed = exposed
+	return nil
+}
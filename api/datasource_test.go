@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func exposedDataSource() ExposedResource {
+	return ExposedResource{
+		Alias:          "myapp_db",
+		ResourceType:   "datasource",
+		Url:            "jdbc:oracle:thin:@db.example.com:1521/MYAPP",
+		Username:       "myapp",
+		PasswordSecret: "myapp-db",
+	}
+}
+
+func TestBuildDataSourcePayload(t *testing.T) {
+	resource := exposedDataSource()
+	resource.password = "secret"
+
+	payload, err := json.Marshal(withResourceMetadata(buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"), ResourceMetadata{ManagedBy: ManagedByNaisd}))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"alias":"myapp_db","scope":{"environmentclass":"t","environment":"t1","zone":"fss"},"type":"DataSource",`+
+		`"properties":{"url":"jdbc:oracle:thin:@db.example.com:1521/MYAPP","username":"myapp"},"secrets":{"password":{"value":"secret"}},`+
+		`"metadata":{"managed-by":"naisd"}}`, string(payload))
+}
+
+func TestValidateDataSources(t *testing.T) {
+	exposed := func(modify func(resource *ExposedResource)) NaisManifest {
+		resource := exposedDataSource()
+		modify(&resource)
+		return NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{resource}}}
+	}
+
+	assert.Nil(t, validateDataSources(exposed(func(*ExposedResource) {})))
+	assert.Nil(t, validateResources(exposed(func(*ExposedResource) {})))
+	assert.NotNil(t, validateDataSources(exposed(func(r *ExposedResource) { r.Url = "db.example.com:1521" })))
+	assert.Nil(t, validateExposedResourceProperties(exposed(func(*ExposedResource) {})))
+	assert.NotNil(t, validateExposedResourceProperties(exposed(func(r *ExposedResource) { r.Username = "" })))
+	assert.NotNil(t, validateExposedResourceProperties(exposed(func(r *ExposedResource) { r.PasswordSecret = "" })))
+	assert.NotNil(t, validateExposedResourceProperties(exposed(func(r *ExposedResource) { r.Path = "/db" })))
+}
+
+func TestResolveDataSourcePasswords(t *testing.T) {
+	secret := func(name, application string, data map[string][]byte) *k8score.Secret {
+		return &k8score.Secret{ObjectMeta: k8smeta.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": application}}, Data: data}
+	}
+	api := Api{Clientset: fake.NewSimpleClientset(
+		secret("myapp-db", "myapp", map[string][]byte{"password": []byte("secret")}),
+		secret("myapp-creds", "myapp", map[string][]byte{"dbPassword": []byte("other")}),
+		secret("otherapp-db", "otherapp", map[string][]byte{"password": []byte("theirs")}),
+	)}
+
+	t.Run("passwords are read from the secrets, leaving the manifest's resources as they were", func(t *testing.T) {
+		withKey := exposedDataSource()
+		withKey.PasswordSecret, withKey.PasswordKey = "myapp-creds", "dbPassword"
+		original := []ExposedResource{{Alias: "myapi", ResourceType: "RestService"}, exposedDataSource(), withKey}
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: original}}
+
+		assert.NoError(t, api.resolveDataSourcePasswords(&manifest, "default", "myapp"))
+		assert.Equal(t, "", manifest.FasitResources.Exposed[0].password)
+		assert.Equal(t, "secret", manifest.FasitResources.Exposed[1].password)
+		assert.Equal(t, "other", manifest.FasitResources.Exposed[2].password)
+		assert.Equal(t, "", original[1].password)
+	})
+
+	t.Run("missing secrets and passwords fail", func(t *testing.T) {
+		missing := exposedDataSource()
+		missing.PasswordSecret = "missing"
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{missing}}}
+		assert.EqualError(t, api.resolveDataSourcePasswords(&manifest, "default", "myapp"), "secret missing with the password of DataSource myapp_db not found in default")
+
+		wrongKey := exposedDataSource()
+		wrongKey.PasswordSecret = "myapp-creds"
+		manifest = NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{wrongKey}}}
+		assert.EqualError(t, api.resolveDataSourcePasswords(&manifest, "default", "myapp"), "secret myapp-creds has no password for DataSource myapp_db")
+	})
+
+	t.Run("secrets of other applications can not be read", func(t *testing.T) {
+		theirs := exposedDataSource()
+		theirs.PasswordSecret = "otherapp-db"
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{theirs}}}
+		assert.EqualError(t, api.resolveDataSourcePasswords(&manifest, "default", "myapp"), "secret otherapp-db with the password of DataSource myapp_db is not labelled app: myapp")
+		assert.Empty(t, manifest.FasitResources.Exposed[0].password)
+	})
+}
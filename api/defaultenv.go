@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/nais/naisd/api/naisrequest"
+	k8score "k8s.io/api/core/v1"
+)
+
+var environmentVariableName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+func validateEnv(manifest NaisManifest) *ValidationError {
+	for name := range manifest.Env {
+		if !environmentVariableName.MatchString(name) {
+			return &ValidationError{
+				"Env must be environment variable names of letters, digits and _, not starting with a digit",
+				map[string]string{"Env": name},
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateDefaultEnv(env map[string]string) error {
+	for name := range env {
+		if !environmentVariableName.MatchString(name) {
+			return fmt.Errorf("default environment variable %s is not a valid name", name)
+		}
+	}
+
+	return nil
+}
+
+// Adds the environment variables set in the manifest, and the cluster-wide defaults the application does not set
+// itself, either in the manifest or through its Fasit resources
+func appendManifestEnvironmentVariables(envVars []k8score.EnvVar, manifest NaisManifest) ([]k8score.EnvVar, error) {
+	defined := make(map[string]bool)
+	for _, envVar := range envVars {
+		defined[envVar.Name] = true
+	}
+
+	for _, name := range sortedKeys(manifest.Env) {
+		if defined[name] {
+			return nil, fmt.Errorf("environment variable %s in env is already set by naisd or a Fasit resource", name)
+		}
+		defined[name] = true
+		envVars = append(envVars, k8score.EnvVar{Name: name, Value: manifest.Env[name]})
+	}
+
+	for _, name := range sortedKeys(manifest.DefaultEnv) {
+		if defined[name] {
+			continue
+		}
+		envVars = append(envVars, k8score.EnvVar{Name: name, Value: manifest.DefaultEnv[name]})
+	}
+
+	return envVars, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Records in the audit log which cluster-wide defaults have changed since the previous deployment of the application,
+// as they change its environment without a change to its manifest
+func (api Api) auditDefaultEnvChanges(deploymentRequest naisrequest.Deploy, manifest NaisManifest) {
+	previous, ok := api.DeploymentHistory.lastKnownGood(deploymentRequest.FasitEnvironment, deploymentRequest.Namespace, deploymentRequest.Application)
+	if !ok {
+		return
+	}
+
+	changes := defaultEnvChanges(previous.spec.manifest.DefaultEnv, manifest.DefaultEnv)
+	if len(changes) == 0 {
+		return
+	}
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "default_env_changed",
+		Application: deploymentRequest.Application,
+		Namespace:   deploymentRequest.Namespace,
+		Version:     deploymentRequest.Version,
+		Details:     changes,
+	})
+}
+
+// defaultEnvChanges returns the names of the variables that are added, changed or removed
+func defaultEnvChanges(previous, current map[string]string) map[string]string {
+	changes := make(map[string]string)
+	for name, value := range current {
+		previousValue, ok := previous[name]
+		switch {
+		case !ok:
+			changes[name] = "added"
+		case previousValue != value:
+			changes[name] = "changed"
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changes[name] = "removed"
+		}
+	}
+	return changes
+}
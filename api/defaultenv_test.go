@@ -0,0 +1,75 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+)
+
+func TestValidateEnv(t *testing.T) {
+	assert.Nil(t, validateEnv(NaisManifest{Env: map[string]string{"JAVA_OPTS": "-Xmx1g", "_private": ""}}))
+	assert.NotNil(t, validateEnv(NaisManifest{Env: map[string]string{"1FOO": ""}}))
+	assert.NotNil(t, validateEnv(NaisManifest{Env: map[string]string{"FOO-BAR": ""}}))
+
+	assert.NoError(t, validateDefaultEnv(map[string]string{"JAVA_TOOL_OPTIONS": "-XX:+UseContainerSupport"}))
+	assert.Error(t, validateDefaultEnv(map[string]string{"java.opts": ""}))
+}
+
+func TestAppendManifestEnvironmentVariables(t *testing.T) {
+	existing := []k8score.EnvVar{{Name: "APP_NAME", Value: "app"}, {Name: "DB_URL", Value: "jdbc:..."}}
+
+	t.Run("Defaults are added unless the application sets them", func(t *testing.T) {
+		manifest := NaisManifest{
+			Env:        map[string]string{"JAVA_TOOL_OPTIONS": "-Xmx2g"},
+			DefaultEnv: map[string]string{"JAVA_TOOL_OPTIONS": "-Xmx512m", "NAV_TRUSTSTORE_PATH": "/etc/ssl/truststore.jks", "DB_URL": "default"},
+		}
+
+		envVars, err := appendManifestEnvironmentVariables(existing, manifest)
+		assert.NoError(t, err)
+		assert.Equal(t, []k8score.EnvVar{
+			{Name: "APP_NAME", Value: "app"},
+			{Name: "DB_URL", Value: "jdbc:..."},
+			{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx2g"},
+			{Name: "NAV_TRUSTSTORE_PATH", Value: "/etc/ssl/truststore.jks"},
+		}, envVars)
+	})
+
+	t.Run("Env can not set variables naisd or Fasit resources set", func(t *testing.T) {
+		_, err := appendManifestEnvironmentVariables(existing, NaisManifest{Env: map[string]string{"DB_URL": "mine"}})
+		assert.EqualError(t, err, "environment variable DB_URL in env is already set by naisd or a Fasit resource")
+	})
+}
+
+func TestDefaultEnvInContainer(t *testing.T) {
+	manifest := newDefaultManifest()
+	manifest.DefaultEnv = map[string]string{"JAVA_TOOL_OPTIONS": "-Xmx512m"}
+
+	spec, err := createPodSpec(naisrequest.Deploy{Application: appName, Version: version, SkipFasit: true}, manifest, []NaisResource{})
+	assert.NoError(t, err)
+	assert.Contains(t, spec.Containers[0].Env, k8score.EnvVar{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx512m"})
+}
+
+func TestAuditDefaultEnvChanges(t *testing.T) {
+	request := naisrequest.Deploy{Application: appName, Namespace: namespace, FasitEnvironment: "t1", Version: version}
+	previous := newDefaultManifest()
+	previous.DefaultEnv = map[string]string{"JAVA_TOOL_OPTIONS": "-Xmx512m", "OLD": "1", "SAME": "x"}
+
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Application: appName, Namespace: namespace, Environment: "t1", spec: newDeploymentSpec(request, previous, nil)})
+	api := Api{DeploymentHistory: history, AuditLog: NewAuditLog()}
+
+	current := newDefaultManifest()
+	current.DefaultEnv = map[string]string{"JAVA_TOOL_OPTIONS": "-Xmx1g", "NEW": "1", "SAME": "x"}
+	api.auditDefaultEnvChanges(request, current)
+
+	entries := api.AuditLog.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "default_env_changed", entries[0].Event)
+	assert.Equal(t, map[string]string{"JAVA_TOOL_OPTIONS": "changed", "NEW": "added", "OLD": "removed"}, entries[0].Details)
+
+	api.auditDefaultEnvChanges(request, previous)
+	api.auditDefaultEnvChanges(naisrequest.Deploy{Application: "other", Namespace: namespace, FasitEnvironment: "t1"}, current)
+	assert.Len(t, api.AuditLog.Entries(), 1, "only changes since the previous deployment of the same application are recorded")
+}
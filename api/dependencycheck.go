@@ -0,0 +1,171 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	k8score "k8s.io/api/core/v1"
+)
+
+const (
+	DependencyCheckTcp  = "tcp"
+	DependencyCheckHttp = "http"
+
+	DependencyCheckContainerName = "dependency-check"
+	DependencyCheckImage         = "busybox:1.28"
+	dependencyCheckAttempts      = 30
+	dependencyCheckTimeout       = 5
+)
+
+var (
+	jdbcHostPort = regexp.MustCompile(`@(?://)?([^:/@]+):(\d+)`)
+	// host names, as a host starting with - would be taken as an option by nc
+	dependencyCheckHost = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+)
+
+type dependencyCheck struct {
+	alias  string
+	check  string
+	target string
+}
+
+func validateDependencyChecks(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Used {
+		if len(resource.Check) > 0 && resource.Check != DependencyCheckTcp && resource.Check != DependencyCheckHttp {
+			return &ValidationError{
+				"FasitResources.Used.Check must be " + DependencyCheckTcp + " or " + DependencyCheckHttp,
+				map[string]string{"Alias": resource.Alias, "Check": resource.Check},
+			}
+		}
+	}
+	return nil
+}
+
+// Finds host:port of the resource from its url, a JDBC url, or hostname and port properties
+func tcpDependencyTarget(properties map[string]string) (string, error) {
+	host, port, err := tcpDependencyHostPort(properties)
+	if err != nil {
+		return "", err
+	}
+
+	if !dependencyCheckHost.MatchString(host) && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func tcpDependencyHostPort(properties map[string]string) (string, string, error) {
+	if matches := jdbcHostPort.FindStringSubmatch(properties["url"]); matches != nil {
+		return matches[1], matches[2], nil
+	}
+
+	if u, err := url.Parse(properties["url"]); err == nil && len(u.Hostname()) > 0 {
+		port := u.Port()
+		if len(port) == 0 {
+			switch u.Scheme {
+			case "http":
+				port = "80"
+			case "https":
+				port = "443"
+			default:
+				return "", "", fmt.Errorf("no port in url %s", properties["url"])
+			}
+		}
+		return u.Hostname(), port, nil
+	}
+
+	if len(properties["hostname"]) > 0 && len(properties["port"]) > 0 {
+		return properties["hostname"], properties["port"], nil
+	}
+
+	return "", "", fmt.Errorf("no url, or hostname and port, to check")
+}
+
+func httpDependencyTarget(properties map[string]string) (string, error) {
+	u, err := url.Parse(properties["url"])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("no http url to check")
+	}
+	return u.String(), nil
+}
+
+func dependencyChecks(manifest NaisManifest, naisResources []NaisResource) ([]dependencyCheck, error) {
+	var checks []dependencyCheck
+
+	for _, used := range manifest.FasitResources.Used {
+		if len(used.Check) == 0 {
+			continue
+		}
+
+		for _, resource := range naisResources {
+			if resource.name != used.Alias || !strings.EqualFold(resource.resourceType, used.ResourceType) {
+				continue
+			}
+
+			var target string
+			var err error
+			if used.Check == DependencyCheckHttp {
+				target, err = httpDependencyTarget(resource.properties)
+			} else {
+				target, err = tcpDependencyTarget(resource.properties)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to check %s: %s", used.Alias, err)
+			}
+
+			checks = append(checks, dependencyCheck{alias: used.Alias, check: used.Check, target: target})
+		}
+	}
+
+	return checks, nil
+}
+
+// dependencyCheckScript checks the resources one at a time. The addresses come from Fasit, so they are given to the
+// script as arguments instead of being written into it, and never run as shell.
+func dependencyCheckScript(checks []dependencyCheck) (string, []string) {
+	script := []string{"set -e"}
+	var args []string
+	arg := func(value string) string {
+		args = append(args, value)
+		return fmt.Sprintf(`"${%d}"`, len(args))
+	}
+
+	for _, check := range checks {
+		var command string
+		if check.check == DependencyCheckHttp {
+			command = fmt.Sprintf("wget -q -T %d --spider %s", dependencyCheckTimeout, arg(check.target))
+		} else {
+			host, port, _ := net.SplitHostPort(check.target)
+			command = fmt.Sprintf("nc -z -w %d %s %s", dependencyCheckTimeout, arg(host), arg(port))
+		}
+
+		script = append(script, fmt.Sprintf(
+			`i=0; until %s; do i=$((i+1)); if [ $i -ge %d ]; then echo %s does not respond; exit 1; fi; sleep 2; done`,
+			command, dependencyCheckAttempts, arg(fmt.Sprintf("%s (%s)", check.alias, check.target)),
+		))
+	}
+	return strings.Join(script, "\n"), args
+}
+
+// The pod does not start, and so never becomes ready, until the checked resources respond
+func createDependencyCheckContainer(checks []dependencyCheck) k8score.Container {
+	return k8score.Container{
+		Name:            DependencyCheckContainerName,
+		Image:           DependencyCheckImage,
+		ImagePullPolicy: k8score.PullIfNotPresent,
+		Command:         dependencyCheckCommand(checks),
+	}
+}
+
+// dependencyCheckCommand runs the script with sh -c, which takes the argument after the script as $0
+func dependencyCheckCommand(checks []dependencyCheck) []string {
+	script, args := dependencyCheckScript(checks)
+	return append([]string{"sh", "-c", script, DependencyCheckContainerName}, args...)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDependencyChecks(t *testing.T) {
+	t.Run("Known checks are accepted", func(t *testing.T) {
+		manifest := NaisManifest{FasitResources: FasitResources{Used: []UsedResource{
+			{Alias: "db", ResourceType: "datasource", Check: "tcp"},
+			{Alias: "api", ResourceType: "restservice", Check: "http"},
+			{Alias: "creds", ResourceType: "credential"},
+		}}}
+
+		assert.Nil(t, validateDependencyChecks(manifest))
+	})
+
+	t.Run("Unknown check gives error", func(t *testing.T) {
+		manifest := NaisManifest{FasitResources: FasitResources{Used: []UsedResource{
+			{Alias: "db", ResourceType: "datasource", Check: "ping"},
+		}}}
+
+		err := validateDependencyChecks(manifest)
+		assert.Equal(t, "ping", err.Fields["Check"])
+	})
+}
+
+func TestDependencyTargets(t *testing.T) {
+	t.Run("TCP target from JDBC url", func(t *testing.T) {
+		target, err := tcpDependencyTarget(map[string]string{"url": "jdbc:oracle:thin:@//dbhost.example.com:1521/SERVICE"})
+		assert.NoError(t, err)
+		assert.Equal(t, "dbhost.example.com:1521", target)
+	})
+
+	t.Run("TCP target from url uses default port of scheme", func(t *testing.T) {
+		target, err := tcpDependencyTarget(map[string]string{"url": "https://api.example.com/path"})
+		assert.NoError(t, err)
+		assert.Equal(t, "api.example.com:443", target)
+	})
+
+	t.Run("TCP target from hostname and port", func(t *testing.T) {
+		target, err := tcpDependencyTarget(map[string]string{"hostname": "mq.example.com", "port": "1414"})
+		assert.NoError(t, err)
+		assert.Equal(t, "mq.example.com:1414", target)
+	})
+
+	t.Run("TCP target must be a host and a port", func(t *testing.T) {
+		_, err := tcpDependencyTarget(map[string]string{"hostname": "-e/bin/sh", "port": "1414"})
+		assert.Error(t, err)
+		_, err = tcpDependencyTarget(map[string]string{"hostname": "mq.example.com", "port": "1414; reboot"})
+		assert.Error(t, err)
+		target, err := tcpDependencyTarget(map[string]string{"hostname": "10.0.0.1", "port": "1414"})
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1:1414", target)
+	})
+
+	t.Run("TCP target without host gives error", func(t *testing.T) {
+		_, err := tcpDependencyTarget(map[string]string{"username": "user"})
+		assert.Error(t, err)
+	})
+
+	t.Run("HTTP target requires http url", func(t *testing.T) {
+		target, err := httpDependencyTarget(map[string]string{"url": "https://api.example.com/isalive"})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://api.example.com/isalive", target)
+
+		_, err = httpDependencyTarget(map[string]string{"url": "jdbc:oracle:thin:@db:1521/X"})
+		assert.Error(t, err)
+	})
+}
+
+func TestDependencyCheckInitContainer(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}
+	resources := []NaisResource{
+		{name: "db", resourceType: "DataSource", properties: map[string]string{"url": "jdbc:oracle:thin:@db.example.com:1521/X"}},
+		{name: "api", resourceType: "RestService", properties: map[string]string{"url": "https://api.example.com/isalive"}},
+		{name: "other", resourceType: "RestService", properties: map[string]string{"url": "https://other.example.com"}},
+	}
+
+	t.Run("No checks gives no init container", func(t *testing.T) {
+		spec, err := createPodSpec(deploymentRequest, newDefaultManifest(), resources)
+		assert.NoError(t, err)
+		assert.Empty(t, spec.InitContainers)
+	})
+
+	t.Run("Checked resources are verified by an init container", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.FasitResources.Used = []UsedResource{
+			{Alias: "db", ResourceType: "datasource", Check: "tcp"},
+			{Alias: "api", ResourceType: "restservice", Check: "http"},
+			{Alias: "other", ResourceType: "restservice"},
+		}
+
+		spec, err := createPodSpec(deploymentRequest, manifest, resources)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(spec.InitContainers))
+
+		container := spec.InitContainers[0]
+		assert.Equal(t, DependencyCheckContainerName, container.Name)
+		assert.Equal(t, DependencyCheckImage, container.Image)
+		assert.Equal(t, []string{"sh", "-c"}, container.Command[:2])
+		script, args := container.Command[2], container.Command[4:]
+		assert.Contains(t, script, `nc -z -w 5 "${1}" "${2}"`)
+		assert.Contains(t, script, `wget -q -T 5 --spider "${4}"`)
+		assert.Equal(t, []string{"db.example.com", "1521", "db (db.example.com:1521)", "https://api.example.com/isalive", "api (https://api.example.com/isalive)"}, args)
+		assert.NotContains(t, strings.Join(container.Command, " "), "other.example.com")
+	})
+
+	t.Run("Addresses from Fasit are never part of the script", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.FasitResources.Used = []UsedResource{{Alias: "api", ResourceType: "restservice", Check: "http"}}
+		injected := []NaisResource{{name: "api", resourceType: "RestService", properties: map[string]string{"url": "https://api.example.com/'; rm -rf /; '"}}}
+
+		spec, err := createPodSpec(deploymentRequest, manifest, injected)
+		assert.NoError(t, err)
+		assert.NotContains(t, spec.InitContainers[0].Command[2], "rm -rf")
+	})
+
+	t.Run("Resource without checkable address gives error", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.FasitResources.Used = []UsedResource{{Alias: "db", ResourceType: "datasource", Check: "http"}}
+
+		_, err := createPodSpec(deploymentRequest, manifest, resources)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const PhaseDependencies = "dependencies"
+
+// How long a deployment waits for the applications it depends on, unless its max duration ends first
+var dependsOnTimeout = 5 * time.Minute
+
+func validateDependsOn(manifest NaisManifest) *ValidationError {
+	for _, dependency := range manifest.DependsOn {
+		if len(dependency) == 0 || len(dependency) > maxApplicationNameLength {
+			return &ValidationError{
+				fmt.Sprintf("DependsOn must be application names of at most %d characters", maxApplicationNameLength),
+				map[string]string{"DependsOn": dependency},
+			}
+		}
+	}
+
+	return nil
+}
+
+// Waits until the applications the manifest depends on have rolled out in the namespace of the deployment. Nothing is
+// applied until they have, and the deployment fails if one of them has failed or they are not ready in time.
+func (api Api) waitForDependencies(deployment *trackedDeployment, deploymentRequest naisrequest.Deploy, manifest NaisManifest) *appError {
+	if len(manifest.DependsOn) == 0 {
+		return nil
+	}
+
+	if appErr := api.enterPhase(deployment, PhaseDependencies); appErr != nil {
+		return appErr
+	}
+
+	ctx, cancel := context.WithTimeout(deployment.context(), dependsOnTimeout)
+	defer cancel()
+
+	waiting := manifest.DependsOn
+	for {
+		var notReady []string
+		for _, dependency := range waiting {
+			status, view, err := api.DeploymentStatusViewer.DeploymentStatusView(deploymentRequest.Namespace, dependency)
+			switch {
+			case err == nil && status == Success:
+			case err == nil && status == Failed:
+				return &appError{fmt.Errorf("%s has failed: %s", dependency, view.Reason), "dependency is not healthy", http.StatusFailedDependency}
+			default:
+				notReady = append(notReady, dependency)
+			}
+		}
+
+		if len(notReady) == 0 {
+			return nil
+		}
+		waiting = notReady
+
+		select {
+		case <-ctx.Done():
+			if deployment.context().Err() == nil {
+				return &appError{fmt.Errorf("%s not ready within %s", strings.Join(notReady, ", "), dependsOnTimeout), "dependencies are not ready", http.StatusGatewayTimeout}
+			}
+			return api.enterPhase(deployment, PhaseDependencies)
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
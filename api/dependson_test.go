@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Gives the status of each application by name, as if it was not deployed when it is not listed
+type applicationStatusViewer map[string]DeployStatus
+
+func (v applicationStatusViewer) DeploymentStatusView(namespace string, deployName string) (DeployStatus, DeploymentStatusView, error) {
+	status, ok := v[deployName]
+	if !ok {
+		return InProgress, DeploymentStatusView{}, fmt.Errorf("deployment %s not found", deployName)
+	}
+	return status, DeploymentStatusView{Reason: "ProgressDeadlineExceeded"}, nil
+}
+
+func TestValidateDependsOn(t *testing.T) {
+	assert.Nil(t, validateDependsOn(NaisManifest{DependsOn: []string{"api"}}))
+	assert.NotNil(t, validateDependsOn(NaisManifest{DependsOn: []string{""}}))
+}
+
+func TestWaitForDependencies(t *testing.T) {
+	request := naisrequest.Deploy{Application: "batch", Namespace: "default"}
+
+	timeout := dependsOnTimeout
+	defer func() { dependsOnTimeout = timeout }()
+	dependsOnTimeout = 10 * time.Millisecond
+
+	t.Run("Nothing to wait for without dependencies", func(t *testing.T) {
+		api := Api{}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+		assert.Nil(t, api.waitForDependencies(deployment, request, NaisManifest{}))
+		assert.Empty(t, deployment.Phase)
+	})
+
+	t.Run("Dependencies that have rolled out", func(t *testing.T) {
+		api := Api{DeploymentStatusViewer: applicationStatusViewer{"api": Success, "db-proxy": Success}}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+		assert.Nil(t, api.waitForDependencies(deployment, request, NaisManifest{DependsOn: []string{"api", "db-proxy"}}))
+		assert.Equal(t, PhaseDependencies, deployment.Phase)
+	})
+
+	t.Run("A failed dependency fails the deployment", func(t *testing.T) {
+		api := Api{DeploymentStatusViewer: applicationStatusViewer{"api": Failed}}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+		appErr := api.waitForDependencies(deployment, request, NaisManifest{DependsOn: []string{"api"}})
+		assert.Equal(t, http.StatusFailedDependency, appErr.StatusCode)
+		assert.EqualError(t, appErr.OriginalError, "api has failed: ProgressDeadlineExceeded")
+	})
+
+	t.Run("Dependencies that are not ready in time", func(t *testing.T) {
+		api := Api{DeploymentStatusViewer: applicationStatusViewer{"api": Success, "rolling": InProgress}}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+		appErr := api.waitForDependencies(deployment, request, NaisManifest{DependsOn: []string{"api", "rolling", "missing"}})
+		assert.Equal(t, http.StatusGatewayTimeout, appErr.StatusCode)
+		assert.EqualError(t, appErr.OriginalError, "rolling, missing not ready within 10ms")
+	})
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const deploymentLogTimeout = 10 * time.Second
+
+var deploymentLogClient = &http.Client{Timeout: deploymentLogTimeout}
+
+// DeploymentLogConfig is an Elasticsearch index a record of every deployment is written to, for the deployment
+// dashboards in Kibana. With DailyIndex the date of the deployment is appended to the index, e.g. deployments-2018.06.01.
+type DeploymentLogConfig struct {
+	Url        string
+	Index      string
+	DailyIndex bool `yaml:"dailyIndex"`
+	Username   string
+	Password   string
+}
+
+// deploymentLogEntry is the document written for a deployment. The field names are those of the deployment log the
+// dashboards were made for. Action is what applied it, like the action of its DeploymentCause: a deploy, redeploy,
+// rollout, rollback or promotion.
+type deploymentLogEntry struct {
+	Timestamp       string  `json:"@timestamp"`
+	DeploymentId    string  `json:"deploymentId"`
+	Action          string  `json:"action"`
+	Application     string  `json:"application"`
+	Environment     string  `json:"environment,omitempty"`
+	Namespace       string  `json:"namespace"`
+	Zone            string  `json:"zone"`
+	Cluster         string  `json:"cluster"`
+	Team            string  `json:"team,omitempty"`
+	Version         string  `json:"version"`
+	PreviousVersion string  `json:"previousVersion,omitempty"`
+	DeployedBy      string  `json:"deployedBy,omitempty"`
+	Result          string  `json:"result"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+func newDeploymentLogEntry(record DeploymentRecord, action string, started, finished time.Time) deploymentLogEntry {
+	return deploymentLogEntry{
+		Timestamp:       finished.UTC().Format(time.RFC3339),
+		DeploymentId:    record.DeploymentId,
+		Action:          action,
+		Application:     record.Application,
+		Environment:     record.Environment,
+		Namespace:       record.Namespace,
+		Zone:            record.Zone,
+		Cluster:         record.Cluster,
+		Team:            record.Team,
+		Version:         record.Version,
+		PreviousVersion: record.PreviousVersion,
+		DeployedBy:      record.DeployedBy,
+		Result:          record.Result,
+		DurationSeconds: finished.Sub(started).Seconds(),
+	}
+}
+
+func deploymentLogIndex(config DeploymentLogConfig, finished time.Time) string {
+	if config.DailyIndex {
+		return config.Index + "-" + finished.UTC().Format("2006.01.02")
+	}
+	return config.Index
+}
+
+func writeDeploymentLog(config DeploymentLogConfig, entry deploymentLogEntry, finished time.Time) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to marshal deployment log entry: %s", err)
+	}
+
+	url := strings.TrimSuffix(config.Url, "/") + "/" + deploymentLogIndex(config, finished) + "/_doc"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to create deployment log request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(config.Username) > 0 {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	resp, err := deploymentLogClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to contact Elasticsearch: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("Elasticsearch responded with HTTP %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// logDeployment writes the outcome of a deployment to the deployment log in the background. A deployment is never
+// held back or failed by the log.
+func (api Api) logDeployment(record DeploymentRecord, action string, started time.Time) {
+	if len(api.DeploymentLog.Url) == 0 {
+		return
+	}
+
+	finished := time.Now()
+	entry := newDeploymentLogEntry(record, action, started, finished)
+	go func() {
+		if err := writeDeploymentLog(api.DeploymentLog, entry, finished); err != nil {
+			deploymentLogFailures.Inc()
+			glog.Warningf("unable to log %s %s of %s to %s: %s", action, record.DeploymentId, record.Application, api.DeploymentLog.Url, err)
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestDeploymentLogEntry(t *testing.T) {
+	started := time.Date(2018, 6, 1, 23, 59, 30, 0, time.UTC)
+	finished := started.Add(90 * time.Second)
+	record := DeploymentRecord{DeploymentId: "abc", Application: "app", Environment: "t1", Namespace: "default", Zone: "fss", Cluster: "preprod-fss", Team: "team", Version: "2", PreviousVersion: "1", DeployedBy: "alice", Result: DeploymentSucceeded}
+
+	entry := newDeploymentLogEntry(record, "rollback", started, finished)
+	assert.Equal(t, "2018-06-02T00:01:00Z", entry.Timestamp)
+	assert.Equal(t, 90.0, entry.DurationSeconds)
+	assert.Equal(t, DeploymentSucceeded, entry.Result)
+	assert.Equal(t, "rollback", entry.Action)
+
+	assert.Equal(t, "deployments", deploymentLogIndex(DeploymentLogConfig{Index: "deployments"}, finished))
+	assert.Equal(t, "deployments-2018.06.02", deploymentLogIndex(DeploymentLogConfig{Index: "deployments", DailyIndex: true}, finished))
+}
+
+func TestWriteDeploymentLog(t *testing.T) {
+	gock.InterceptClient(deploymentLogClient)
+	defer gock.RestoreClient(deploymentLogClient)
+	defer gock.Off()
+
+	config := DeploymentLogConfig{Url: "https://elasticsearch.local/", Index: "deployments", Username: "naisd", Password: "secret"}
+	finished := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	entry := deploymentLogEntry{Timestamp: "2018-06-01T12:00:00Z", DeploymentId: "abc", Action: "deploy", Application: "app", Namespace: "default", Zone: "fss", Cluster: "c", Version: "2", Result: DeploymentFailed, DurationSeconds: 12.5}
+
+	t.Run("The entry is indexed as a document", func(t *testing.T) {
+		gock.New("https://elasticsearch.local").
+			Post("/deployments/_doc").
+			MatchHeader("Authorization", "Basic bmFpc2Q6c2VjcmV0").
+			JSON(map[string]interface{}{"@timestamp": "2018-06-01T12:00:00Z", "deploymentId": "abc", "action": "deploy", "application": "app", "namespace": "default", "zone": "fss", "cluster": "c", "version": "2", "result": DeploymentFailed, "durationSeconds": 12.5}).
+			Reply(201)
+
+		assert.NoError(t, writeDeploymentLog(config, entry, finished))
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Errors from Elasticsearch are returned", func(t *testing.T) {
+		gock.New("https://elasticsearch.local").
+			Post("/deployments/_doc").
+			Reply(403)
+
+		assert.EqualError(t, writeDeploymentLog(config, entry, finished), "Elasticsearch responded with HTTP 403")
+	})
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeploymentReportCsv    = "csv"
+	DeploymentReportNdjson = "ndjson"
+
+	dateLayout = "2006-01-02"
+)
+
+var deploymentReportColumns = []string{"timestamp", "deploymentId", "result", "application", "namespace", "environment",
+	"zone", "cluster", "team", "deployedBy", "version", "previousVersion", "manifestChecksum"}
+
+type deploymentReportQuery struct {
+	from        time.Time
+	to          time.Time
+	team        string
+	application string
+	namespace   string
+	offset      int
+	format      string
+	pageQuery
+}
+
+// Parses a time given as RFC 3339 or as a date. A date used as the end of a period includes the whole day.
+func parseReportTime(value string, endOfPeriod bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is neither a date (%s) nor a time (RFC 3339)", value, dateLayout)
+	}
+	if endOfPeriod {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func parseReportInt(values url.Values, name string, defaultValue int) (int, error) {
+	value := values.Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%s must be a positive number, got %s", name, value)
+	}
+	return i, nil
+}
+
+func parseDeploymentReportQuery(values url.Values, accept string) (deploymentReportQuery, error) {
+	query := deploymentReportQuery{
+		team:        values.Get("team"),
+		application: values.Get("application"),
+		namespace:   values.Get("namespace"),
+		format:      values.Get("format"),
+	}
+
+	var err error
+	if from := values.Get("from"); len(from) > 0 {
+		if query.from, err = parseReportTime(from, false); err != nil {
+			return query, err
+		}
+	}
+	if to := values.Get("to"); len(to) > 0 {
+		if query.to, err = parseReportTime(to, true); err != nil {
+			return query, err
+		}
+	}
+	if query.offset, err = parseReportInt(values, "offset", 0); err != nil {
+		return query, err
+	}
+	if query.pageQuery, err = parsePageQuery(values, maxDeploymentRecords); err != nil {
+		return query, err
+	}
+	if query.offset > 0 && query.cursor > 0 {
+		return query, fmt.Errorf("offset and cursor can not both be given")
+	}
+
+	if len(query.format) == 0 {
+		query.format = DeploymentReportNdjson
+		if strings.Contains(accept, "text/csv") {
+			query.format = DeploymentReportCsv
+		}
+	}
+	if query.format != DeploymentReportCsv && query.format != DeploymentReportNdjson {
+		return query, fmt.Errorf("format must be %s or %s", DeploymentReportCsv, DeploymentReportNdjson)
+	}
+
+	return query, nil
+}
+
+func (query deploymentReportQuery) matches(record DeploymentRecord) bool {
+	return (query.from.IsZero() || !record.Timestamp.Before(query.from)) &&
+		(query.to.IsZero() || record.Timestamp.Before(query.to)) &&
+		(len(query.team) == 0 || record.Team == query.team) &&
+		(len(query.application) == 0 || record.Application == query.application) &&
+		(len(query.namespace) == 0 || record.Namespace == query.namespace)
+}
+
+// Returns the page of matching records the query asks for, oldest first, the number of matching records, and whether
+// there are more after the page
+func deploymentReport(records []DeploymentRecord, query deploymentReportQuery) ([]DeploymentRecord, int, bool) {
+	var matching []DeploymentRecord
+	for _, record := range records {
+		if query.matches(record) {
+			matching = append(matching, record)
+		}
+	}
+
+	page := matching[sort.Search(len(matching), func(i int) bool { return matching[i].Sequence > query.cursor }):]
+	if query.offset >= len(page) {
+		return []DeploymentRecord{}, len(matching), false
+	}
+	page = page[query.offset:]
+	if len(page) > query.limit {
+		return page[:query.limit], len(matching), true
+	}
+	return page, len(matching), false
+}
+
+func deploymentReportRow(record DeploymentRecord) []string {
+	result := record.Result
+	if len(result) == 0 {
+		result = DeploymentSucceeded
+	}
+
+	return []string{record.Timestamp.UTC().Format(time.RFC3339), record.DeploymentId, result, record.Application,
+		record.Namespace, record.Environment, record.Zone, record.Cluster, record.Team, record.DeployedBy, record.Version,
+		record.PreviousVersion, record.ManifestChecksum}
+}
+
+func writeDeploymentReportCsv(w http.ResponseWriter, records []DeploymentRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(deploymentReportColumns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := writer.Write(deploymentReportRow(record)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeDeploymentReportNdjson(w http.ResponseWriter, records []DeploymentRecord) error {
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if len(record.Result) == 0 {
+			record.Result = DeploymentSucceeded
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (api Api) deploymentReport(w http.ResponseWriter, r *http.Request) *appError {
+	query, err := parseDeploymentReportQuery(r.URL.Query(), r.Header.Get("Accept"))
+	if err != nil {
+		return &appError{err, "invalid report query", http.StatusBadRequest}
+	}
+
+	records, total, more := deploymentReport(api.DeploymentHistory.Records(), query)
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if more {
+		w.Header().Set("Link", nextPageLink(r, records[len(records)-1].Sequence, query.limit))
+	}
+
+	if query.format == DeploymentReportCsv {
+		w.Header().Set("Content-Type", "text/csv")
+		err = writeDeploymentReportCsv(w, records)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = writeDeploymentReportNdjson(w, records)
+	}
+	if err != nil {
+		return &appError{err, "unable to write report", http.StatusInternalServerError}
+	}
+
+	return nil
+}
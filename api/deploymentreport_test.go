@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentReport(t *testing.T) {
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Timestamp: time.Date(2018, 1, 15, 10, 0, 0, 0, time.UTC), DeploymentId: "a1", Application: "app", Namespace: "default", Team: "aura", Version: "1", DeployedBy: "A123456"})
+	history.Add(DeploymentRecord{Timestamp: time.Date(2018, 2, 1, 10, 0, 0, 0, time.UTC), DeploymentId: "b1", Result: DeploymentFailed, Application: "other", Namespace: "default", Team: "teamx", Version: "7"})
+	history.Add(DeploymentRecord{Timestamp: time.Date(2018, 3, 31, 23, 0, 0, 0, time.UTC), DeploymentId: "a2", Result: DeploymentSucceeded, Application: "app", Namespace: "default", Team: "aura", Version: "2", PreviousVersion: "1"})
+	history.Add(DeploymentRecord{Timestamp: time.Date(2018, 4, 1, 10, 0, 0, 0, time.UTC), DeploymentId: "a3", Result: DeploymentSucceeded, Application: "app", Namespace: "default", Team: "aura", Version: "3", PreviousVersion: "2"})
+	api := Api{DeploymentHistory: history}
+
+	t.Run("NDJSON is the default, filtered on period and team", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/report/deployments?from=2018-01-01&to=2018-03-31&team=aura", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		assert.Len(t, lines, 2)
+		var first, second DeploymentRecord
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+		assert.Equal(t, "a1", first.DeploymentId)
+		assert.Equal(t, DeploymentSucceeded, first.Result)
+		assert.Equal(t, "a2", second.DeploymentId)
+		assert.Equal(t, "1", second.PreviousVersion)
+	})
+
+	t.Run("CSV is written when asked for", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/report/deployments?team=teamx", nil)
+		req.Header.Set("Accept", "text/csv")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+		rows, err := csv.NewReader(rr.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{
+			deploymentReportColumns,
+			{"2018-02-01T10:00:00Z", "b1", DeploymentFailed, "other", "default", "", "", "", "teamx", "", "7", "", ""},
+		}, rows)
+	})
+
+	t.Run("Large reports are paginated", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/report/deployments?application=app&limit=2", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, "3", rr.Header().Get("X-Total-Count"))
+		assert.Equal(t, `</report/deployments?application=app&cursor=3&limit=2>; rel="next"`, rr.Header().Get("Link"))
+		assert.Len(t, strings.Split(strings.TrimSpace(rr.Body.String()), "\n"), 2)
+
+		req, _ = http.NewRequest("GET", "/report/deployments?application=app&cursor=3&limit=2", nil)
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, "3", rr.Header().Get("X-Total-Count"))
+		assert.Empty(t, rr.Header().Get("Link"))
+		assert.Contains(t, rr.Body.String(), `"deploymentId":"a3"`)
+
+		req, _ = http.NewRequest("GET", "/report/deployments?application=app&limit=2&offset=2", nil)
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Empty(t, rr.Header().Get("Link"))
+		assert.Contains(t, rr.Body.String(), `"deploymentId":"a3"`)
+	})
+
+	t.Run("Invalid queries are rejected", func(t *testing.T) {
+		for _, query := range []string{"from=yesterday", "limit=-1", "format=xml", "cursor=abc", "cursor=1&offset=1"} {
+			req, _ := http.NewRequest("GET", "/report/deployments?"+query, nil)
+			rr := httptest.NewRecorder()
+			api.Handler().ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		}
+	})
+}
+
+func TestParseDeploymentReportQuery(t *testing.T) {
+	query, err := parseDeploymentReportQuery(url.Values{"from": {"2018-01-01T12:00:00Z"}, "to": {"2018-03-31"}, "limit": {"0"}}, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), query.from)
+	assert.Equal(t, time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC), query.to)
+	assert.Equal(t, maxDeploymentRecords, query.limit)
+	assert.Equal(t, DeploymentReportNdjson, query.format)
+}
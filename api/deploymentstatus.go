@@ -45,7 +45,7 @@ func NewDeploymentStatusViewer(clientset kubernetes.Interface) DeploymentStatusV
 }
 
 func (d deploymentStatusViewerImpl) DeploymentStatusView(namespace string, deployName string) (DeployStatus, DeploymentStatusView, error) {
-	dep, err := d.client.ExtensionsV1beta1().Deployments(namespace).Get(deployName, k8smeta.GetOptions{})
+	dep, err := deployments(d.client, namespace).Get(deployName, k8smeta.GetOptions{})
 	if err != nil {
 		errMess := fmt.Sprintf("did not find deployment: %s in namespace: %s", deployName, namespace)
 		glog.Error(errMess)
@@ -58,30 +58,32 @@ func (d deploymentStatusViewerImpl) DeploymentStatusView(namespace string, deplo
 }
 
 type DeploymentStatusView struct {
-	Name       string
-	Desired    int32
-	Current    int32
-	UpToDate   int32
-	Available  int32
-	Containers []string
-	Images     []string
-	Status     string
-	Reason     string
+	Name              string
+	Desired           int32
+	Current           int32
+	UpToDate          int32
+	Available         int32
+	Containers        []string
+	Images            []string
+	Status            string
+	Reason            string
+	VulnerabilityScan string `json:",omitempty"`
 }
 
 func deploymentStatusViewFrom(status DeployStatus, reason string, deployment k8sextensions.Deployment) DeploymentStatusView {
 	containers, images := findContainerImages(deployment.Spec.Template.Spec.Containers)
 
 	return DeploymentStatusView{
-		Name:       deployment.Name,
-		Desired:    *deployment.Spec.Replicas,
-		Current:    deployment.Status.Replicas,
-		UpToDate:   deployment.Status.UpdatedReplicas,
-		Available:  deployment.Status.AvailableReplicas,
-		Containers: containers,
-		Images:     images,
-		Status:     status.String(),
-		Reason:     reason,
+		Name:              deployment.Name,
+		Desired:           *deployment.Spec.Replicas,
+		Current:           deployment.Status.Replicas,
+		UpToDate:          deployment.Status.UpdatedReplicas,
+		Available:         deployment.Status.AvailableReplicas,
+		Containers:        containers,
+		Images:            images,
+		Status:            status.String(),
+		Reason:            reason,
+		VulnerabilityScan: deployment.Annotations[VulnerabilityScanAnnotation],
 	}
 
 }
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	StrategyRollingUpdate = "rollingupdate"
+	StrategyRecreate      = "recreate"
+
+	// A single replica must have been ready this long before the old pod is terminated
+	SingleReplicaMinReadySeconds = 10
+)
+
+var (
+	ingressResumePollInterval = 5 * time.Second
+	ingressResumeTimeout      = 10 * time.Minute
+)
+
+func singleReplica(manifest NaisManifest) bool {
+	return manifest.Replicas.Max == 1
+}
+
+func recreate(manifest NaisManifest) bool {
+	return manifest.Strategy == StrategyRecreate
+}
+
+// Traffic is paused by removing the ingress while a recreate rollout is in progress
+func pauseIngress(manifest NaisManifest) bool {
+	return recreate(manifest) && manifest.Ingress.PauseDuringRecreate && !manifest.Ingress.Disabled
+}
+
+func rollingUpdateStrategy(maxSurge, maxUnavailable int32) k8sextensions.DeploymentStrategy {
+	return k8sextensions.DeploymentStrategy{
+		Type: k8sextensions.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &k8sextensions.RollingUpdateDeployment{
+			MaxUnavailable: &intstr.IntOrString{
+				Type:   intstr.Int,
+				IntVal: maxUnavailable,
+			},
+			MaxSurge: &intstr.IntOrString{
+				Type:   intstr.Int,
+				IntVal: maxSurge,
+			},
+		},
+	}
+}
+
+// New pods are started and must be ready before old ones are terminated, unless the app has disabled surge
+// or uses the recreate strategy because it can't run two instances or versions at once.
+func createDeploymentStrategy(manifest NaisManifest) k8sextensions.DeploymentStrategy {
+	if recreate(manifest) {
+		return k8sextensions.DeploymentStrategy{Type: k8sextensions.RecreateDeploymentStrategyType}
+	}
+	if manifest.Replicas.DisableSurge {
+		return rollingUpdateStrategy(0, 1)
+	}
+	return rollingUpdateStrategy(1, 0)
+}
+
+func createMinReadySeconds(manifest NaisManifest) int32 {
+	if singleReplica(manifest) && !manifest.Replicas.DisableSurge && !recreate(manifest) {
+		return SingleReplicaMinReadySeconds
+	}
+	return 0
+}
+
+func validateDisableSurge(manifest NaisManifest) *ValidationError {
+	if manifest.Replicas.DisableSurge && !singleReplica(manifest) {
+		return &ValidationError{
+			"Replicas.DisableSurge can only be used with a single replica (Replicas.Max: 1)",
+			map[string]string{"Replicas.Max": strconv.Itoa(manifest.Replicas.Max)},
+		}
+	}
+	return nil
+}
+
+func validateStrategy(manifest NaisManifest) *ValidationError {
+	if len(manifest.Strategy) > 0 && manifest.Strategy != StrategyRollingUpdate && manifest.Strategy != StrategyRecreate {
+		return &ValidationError{
+			"Strategy must be " + StrategyRollingUpdate + " or " + StrategyRecreate,
+			map[string]string{"Strategy": manifest.Strategy},
+		}
+	}
+
+	if manifest.Ingress.PauseDuringRecreate && !recreate(manifest) {
+		return &ValidationError{
+			"Ingress.PauseDuringRecreate can only be used with strategy " + StrategyRecreate,
+			map[string]string{"Strategy": manifest.Strategy},
+		}
+	}
+
+	return nil
+}
+
+// Waits for the rollout of a recreate deployment to finish before the ingress is created again. A rollout that has not
+// finished by the deadline gets its traffic back anyway, so the application is not left unreachable, and the timeout is
+// recorded in the audit log.
+func (api Api) resumeIngressAfterRollout(deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource) {
+	deadline := time.Now().Add(ingressResumeTimeout)
+
+	finished := false
+	for time.Now().Before(deadline) {
+		status, view, err := api.DeploymentStatusViewer.DeploymentStatusView(deploymentRequest.Namespace, deploymentRequest.Application)
+		if err == nil && status != InProgress {
+			finished = true
+			glog.Infof("rollout of %s in %s finished: %s", deploymentRequest.Application, deploymentRequest.Namespace, view.Reason)
+			break
+		}
+		time.Sleep(ingressResumePollInterval)
+	}
+
+	if !finished {
+		glog.Errorf("rollout of %s in %s did not finish within %s, resuming traffic anyway", deploymentRequest.Application, deploymentRequest.Namespace, ingressResumeTimeout)
+		api.AuditLog.Record(AuditEntry{
+			Event:       "ingress_resume_timeout",
+			Application: deploymentRequest.Application,
+			Namespace:   deploymentRequest.Namespace,
+			Version:     deploymentRequest.Version,
+			Details:     map[string]string{"timeout": ingressResumeTimeout.String()},
+		})
+	}
+
+	if _, err := createOrUpdateIngress(deploymentRequest, manifest, api.ClusterSubdomain, naisResources, api.Clientset); err != nil {
+		glog.Errorf("unable to resume traffic to %s in %s: %s", deploymentRequest.Application, deploymentRequest.Namespace, err)
+		return
+	}
+
+	glog.Infof("resumed traffic to %s in %s", deploymentRequest.Application, deploymentRequest.Namespace)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreateDeploymentStrategy(t *testing.T) {
+	t.Run("New pods surge before old are terminated", func(t *testing.T) {
+		manifest := NaisManifest{Replicas: Replicas{Min: 2, Max: 4}}
+
+		strategy := createDeploymentStrategy(manifest)
+		assert.Equal(t, k8sextensions.RollingUpdateDeploymentStrategyType, strategy.Type)
+		assert.Equal(t, int32(1), strategy.RollingUpdate.MaxSurge.IntVal)
+		assert.Equal(t, int32(0), strategy.RollingUpdate.MaxUnavailable.IntVal)
+		assert.Equal(t, int32(0), createMinReadySeconds(manifest))
+	})
+
+	t.Run("Single replica must be ready for a while before the old one is terminated", func(t *testing.T) {
+		manifest := NaisManifest{Replicas: Replicas{Min: 1, Max: 1}}
+
+		strategy := createDeploymentStrategy(manifest)
+		assert.Equal(t, int32(1), strategy.RollingUpdate.MaxSurge.IntVal)
+		assert.Equal(t, int32(0), strategy.RollingUpdate.MaxUnavailable.IntVal)
+		assert.Equal(t, int32(SingleReplicaMinReadySeconds), createMinReadySeconds(manifest))
+	})
+
+	t.Run("Disabled surge terminates the old pod first", func(t *testing.T) {
+		manifest := NaisManifest{Replicas: Replicas{Min: 1, Max: 1, DisableSurge: true}}
+
+		strategy := createDeploymentStrategy(manifest)
+		assert.Equal(t, int32(0), strategy.RollingUpdate.MaxSurge.IntVal)
+		assert.Equal(t, int32(1), strategy.RollingUpdate.MaxUnavailable.IntVal)
+		assert.Equal(t, int32(0), createMinReadySeconds(manifest))
+	})
+
+	t.Run("Recreate strategy terminates all pods first", func(t *testing.T) {
+		manifest := NaisManifest{Replicas: Replicas{Min: 1, Max: 1}, Strategy: StrategyRecreate}
+
+		strategy := createDeploymentStrategy(manifest)
+		assert.Equal(t, k8sextensions.RecreateDeploymentStrategyType, strategy.Type)
+		assert.Nil(t, strategy.RollingUpdate)
+		assert.Equal(t, int32(0), createMinReadySeconds(manifest))
+	})
+}
+
+func TestValidateDisableSurge(t *testing.T) {
+	assert.Nil(t, validateDisableSurge(NaisManifest{Replicas: Replicas{Min: 1, Max: 1, DisableSurge: true}}))
+	assert.Nil(t, validateDisableSurge(NaisManifest{Replicas: Replicas{Min: 2, Max: 4}}))
+
+	err := validateDisableSurge(NaisManifest{Replicas: Replicas{Min: 2, Max: 4, DisableSurge: true}})
+	assert.Equal(t, "4", err.Fields["Replicas.Max"])
+}
+
+func TestValidateStrategy(t *testing.T) {
+	assert.Nil(t, validateStrategy(NaisManifest{}))
+	assert.Nil(t, validateStrategy(NaisManifest{Strategy: StrategyRollingUpdate}))
+	assert.Nil(t, validateStrategy(NaisManifest{Strategy: StrategyRecreate, Ingress: Ingress{PauseDuringRecreate: true}}))
+
+	err := validateStrategy(NaisManifest{Strategy: "bluegreen"})
+	assert.Equal(t, "bluegreen", err.Fields["Strategy"])
+
+	err = validateStrategy(NaisManifest{Ingress: Ingress{PauseDuringRecreate: true}})
+	assert.Equal(t, "Ingress.PauseDuringRecreate can only be used with strategy recreate", err.ErrorMessage)
+}
+
+func TestPausedIngress(t *testing.T) {
+	ingressResumePollInterval = time.Millisecond
+	manifest := newDefaultManifest()
+	manifest.Strategy = StrategyRecreate
+	manifest.Ingress.PauseDuringRecreate = true
+	deploymentRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version, SkipFasit: true}
+
+	existingIngress := createIngressDef(appName, namespace, teamName)
+	existingIngress.ObjectMeta.ResourceVersion = resourceVersion
+	clientset := fake.NewSimpleClientset(existingIngress, alertsConfigMap())
+
+	result, err := createOrUpdateK8sResources(deploymentRequest, manifest, []NaisResource{}, "nais.example.no", false, clientset)
+	assert.NoError(t, err)
+	assert.True(t, result.IngressPaused)
+	assert.Nil(t, result.Ingress)
+
+	ingress, _ := getExistingIngress(appName, namespace, clientset)
+	assert.Nil(t, ingress)
+
+	api := Api{Clientset: clientset, ClusterSubdomain: "nais.example.no", DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: Success}}
+	api.resumeIngressAfterRollout(deploymentRequest, manifest, []NaisResource{})
+
+	ingress, _ = getExistingIngress(appName, namespace, clientset)
+	assert.NotNil(t, ingress)
+	assert.Equal(t, createIngressHostname(appName, namespace, "nais.example.no"), ingress.Spec.Rules[0].Host)
+}
+
+func TestPausedIngressRolloutTimeout(t *testing.T) {
+	ingressResumePollInterval = time.Millisecond
+	timeout := ingressResumeTimeout
+	defer func() { ingressResumeTimeout = timeout }()
+	ingressResumeTimeout = 5 * time.Millisecond
+
+	manifest := newDefaultManifest()
+	manifest.Strategy = StrategyRecreate
+	manifest.Ingress.PauseDuringRecreate = true
+	deploymentRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version, SkipFasit: true}
+	clientset := fake.NewSimpleClientset(alertsConfigMap())
+
+	api := Api{Clientset: clientset, ClusterSubdomain: "nais.example.no", AuditLog: NewAuditLog(), DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: InProgress}}
+	api.resumeIngressAfterRollout(deploymentRequest, manifest, []NaisResource{})
+
+	ingress, _ := getExistingIngress(appName, namespace, clientset)
+	assert.NotNil(t, ingress, "traffic is resumed when the rollout does not finish in time")
+	assert.Equal(t, "ingress_resume_timeout", api.AuditLog.Entries()[0].Event)
+}
@@ -0,0 +1,430 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+)
+
+const (
+	PhaseManifest    = "manifest"
+	PhaseFasit       = "fasit"
+	PhaseScan        = "scan"
+	PhaseKubernetes  = "kubernetes"
+	PhaseFasitUpdate = "fasit-update"
+	PhaseRollout     = "rollout"
+
+	DeploymentInProgress = "in_progress"
+	DeploymentSucceeded  = "succeeded"
+	DeploymentFailed     = "failed"
+	DeploymentCancelled  = "cancelled"
+	DeploymentTimedOut   = "timed_out"
+
+	maxFinishedDeployments = 100
+)
+
+var (
+	rolloutPollInterval   = 5 * time.Second
+	defaultRolloutTimeout = 10 * time.Minute
+)
+
+// TrackedDeployment is a deployment naisd is running or has recently finished
+type TrackedDeployment struct {
+	Id          string           `json:"id"`
+	Application string           `json:"application"`
+	Namespace   string           `json:"namespace"`
+	Version     string           `json:"version"`
+	Started     time.Time        `json:"started"`
+	MaxDuration string           `json:"maxDuration,omitempty"`
+	Phase       string           `json:"phase"`
+	Status      string           `json:"status"`
+	Resources   []ResourceTiming `json:"resources,omitempty"`
+	// set when the deployment has been asked to stop, until it stops at its next phase
+	CancelRequested bool `json:"cancelRequested,omitempty"`
+}
+
+type trackedDeployment struct {
+	TrackedDeployment
+	// mutex guards ctx, cancel and maxDuration, which are replaced when the deployment is limited while other
+	// goroutines, e.g. the requests to Fasit, read them
+	mutex       sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	maxDuration time.Duration
+	tracker     *DeploymentTracker
+	// applied is set when the deployment enters the kubernetes phase, after which it can no longer be cancelled
+	applied bool
+	// done is set when the deployment has returned, and releases the application
+	done bool
+}
+
+// DeploymentTracker allows one deployment per application at a time, and lets operators cancel them. A cancelled
+// deployment stops at the start of its next phase, and releases the application when it has stopped. Deployments can
+// only be cancelled until they are applied to Kubernetes, as what has been applied is not rolled back.
+type DeploymentTracker struct {
+	mutex       sync.Mutex
+	deployments map[string]*trackedDeployment
+	finished    []string
+}
+
+func NewDeploymentTracker() *DeploymentTracker {
+	return &DeploymentTracker{deployments: make(map[string]*trackedDeployment)}
+}
+
+// lock takes the tracker's lock, recording how long it had to wait for it
+func (t *DeploymentTracker) lock() {
+	waitStarted := time.Now()
+	t.mutex.Lock()
+	deploymentLockWait.Observe(time.Since(waitStarted).Seconds())
+}
+
+func newDeploymentId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// start registers the deployment. A positive maxDuration is the time the deployment has to finish all its phases. The
+// deployment is cancelled along with parent, e.g. when the client that requested it goes away.
+func (t *DeploymentTracker) start(parent context.Context, deploymentRequest naisrequest.Deploy, maxDuration time.Duration) (*trackedDeployment, error) {
+	ctx, cancel := context.WithCancel(parent)
+	deployment := &trackedDeployment{
+		TrackedDeployment: TrackedDeployment{
+			Id:          newDeploymentId(),
+			Application: deploymentRequest.Application,
+			Namespace:   deploymentRequest.Namespace,
+			Version:     deploymentRequest.Version,
+			Started:     time.Now(),
+			Status:      DeploymentInProgress,
+		},
+		ctx:     ctx,
+		cancel:  cancel,
+		tracker: t,
+	}
+	deployment.limit(maxDuration)
+
+	if t == nil {
+		return deployment, nil
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+
+	for _, existing := range t.deployments {
+		if !existing.done && existing.Application == deployment.Application && existing.Namespace == deployment.Namespace {
+			cancel()
+			return nil, fmt.Errorf("deployment %s of %s/%s is in progress", existing.Id, existing.Namespace, existing.Application)
+		}
+	}
+
+	t.deployments[deployment.Id] = deployment
+	deploymentsActive.Inc()
+	return deployment, nil
+}
+
+func (deployment *trackedDeployment) limit(maxDuration time.Duration) {
+	if maxDuration <= 0 {
+		return
+	}
+
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+
+	ctx, cancel := context.WithDeadline(deployment.ctx, deployment.Started.Add(maxDuration))
+	parentCancel := deployment.cancel
+	deployment.ctx = ctx
+	deployment.cancel = func() {
+		cancel()
+		parentCancel()
+	}
+	deployment.maxDuration = maxDuration
+	deployment.MaxDuration = maxDuration.String()
+}
+
+// context is cancelled when the deployment is cancelled or runs out of time
+func (deployment *trackedDeployment) context() context.Context {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+	return deployment.ctx
+}
+
+// timeLimit is the max duration of the deployment, 0 if it has none
+func (deployment *trackedDeployment) timeLimit() time.Duration {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+	return deployment.maxDuration
+}
+
+// stop cancels the context of the deployment, releasing what it holds
+func (deployment *trackedDeployment) stop() {
+	deployment.mutex.Lock()
+	defer deployment.mutex.Unlock()
+	deployment.cancel()
+}
+
+// limit replaces the max duration of the deployment, counted from when it started
+func (t *DeploymentTracker) limit(deployment *trackedDeployment, maxDuration time.Duration) {
+	if t == nil {
+		deployment.limit(maxDuration)
+		return
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+	deployment.limit(maxDuration)
+}
+
+func (t *DeploymentTracker) timedOut(deployment *trackedDeployment) error {
+	phase := deployment.Phase
+	if t != nil {
+		t.lock()
+		defer t.mutex.Unlock()
+		deployment.Status = DeploymentTimedOut
+	}
+	return fmt.Errorf("deployment %s exceeded its max duration of %s in the %s phase", deployment.Id, deployment.timeLimit(), phase)
+}
+
+// enter moves the deployment to the next phase, unless it has been cancelled or has run out of time
+func (t *DeploymentTracker) enter(deployment *trackedDeployment, phase string) error {
+	switch deployment.context().Err() {
+	case context.Canceled:
+		return fmt.Errorf("deployment %s was cancelled before the %s phase", deployment.Id, phase)
+	case context.DeadlineExceeded:
+		return t.timedOut(deployment)
+	}
+
+	if t == nil {
+		deployment.Phase = phase
+		return nil
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+	if deployment.CancelRequested {
+		deployment.Status = DeploymentCancelled
+		return fmt.Errorf("deployment %s was cancelled before the %s phase", deployment.Id, phase)
+	}
+	if phase == PhaseKubernetes {
+		deployment.applied = true
+	}
+	if len(deployment.Phase) > 0 {
+		deploymentsInPhase.WithLabelValues(deployment.Phase).Dec()
+	}
+	deploymentsInPhase.WithLabelValues(phase).Inc()
+	deployment.Phase = phase
+	return nil
+}
+
+func validateMaxDeployDuration(manifest NaisManifest) *ValidationError {
+	if len(manifest.MaxDeployDuration) == 0 {
+		return nil
+	}
+
+	if duration, err := time.ParseDuration(manifest.MaxDeployDuration); err != nil || duration <= 0 {
+		return &ValidationError{
+			"MaxDeployDuration must be a positive duration, e.g. 10m",
+			map[string]string{"MaxDeployDuration": manifest.MaxDeployDuration},
+		}
+	}
+
+	return nil
+}
+
+// enterPhase is enter with the error as the deploy handler responds with it
+func (api Api) enterPhase(deployment *trackedDeployment, phase string) *appError {
+	err := api.Deployments.enter(deployment, phase)
+	switch {
+	case err == nil:
+		return nil
+	case deployment.context().Err() == context.DeadlineExceeded:
+		return &appError{err, "deployment timed out", http.StatusGatewayTimeout}
+	default:
+		return &appError{err, "deployment cancelled", http.StatusConflict}
+	}
+}
+
+// waitForRollout waits until the deployment's pods have rolled out, or the deployment is cancelled or times out
+func (api Api) waitForRollout(deployment *trackedDeployment, deploymentRequest naisrequest.Deploy) *appError {
+	if appErr := api.enterPhase(deployment, PhaseRollout); appErr != nil {
+		return appErr
+	}
+
+	ctx := deployment.context()
+	if deployment.timeLimit() <= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRolloutTimeout)
+		defer cancel()
+	}
+
+	for {
+		status, view, err := api.DeploymentStatusViewer.DeploymentStatusView(deploymentRequest.Namespace, deploymentRequest.Application)
+		switch {
+		case err == nil && status == Success:
+			return nil
+		case err == nil && status == Failed:
+			return &appError{fmt.Errorf("rollout failed: %s", view.Reason), "rollout failed", http.StatusInternalServerError}
+		}
+
+		select {
+		case <-ctx.Done():
+			if deployment.context().Err() == nil {
+				return &appError{fmt.Errorf("rollout did not finish within %s", defaultRolloutTimeout), "deployment timed out", http.StatusGatewayTimeout}
+			}
+			return api.enterPhase(deployment, PhaseRollout)
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+// finish records the outcome of the deployment. Only the most recent finished deployments are kept.
+func (t *DeploymentTracker) finish(deployment *trackedDeployment, succeeded bool) {
+	deployment.stop()
+
+	if t == nil {
+		return
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+
+	deployment.done = true
+	deploymentsActive.Dec()
+	if len(deployment.Phase) > 0 {
+		deploymentsInPhase.WithLabelValues(deployment.Phase).Dec()
+	}
+
+	if deployment.Status == DeploymentInProgress {
+		if succeeded {
+			deployment.Status = DeploymentSucceeded
+		} else {
+			deployment.Status = DeploymentFailed
+		}
+	}
+
+	t.finished = append(t.finished, deployment.Id)
+	if len(t.finished) > maxFinishedDeployments {
+		delete(t.deployments, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
+
+// Cancel asks the deployment to stop at the start of its next phase. It is not cancelled until it gets there, and
+// keeps the application until it has stopped, so it is never applied to Kubernetes by two deployments at once.
+func (t *DeploymentTracker) Cancel(id string) (TrackedDeployment, error) {
+	if t == nil {
+		return TrackedDeployment{}, fmt.Errorf("deployment %s not found", id)
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+
+	deployment, ok := t.deployments[id]
+	if !ok {
+		return TrackedDeployment{}, fmt.Errorf("deployment %s not found", id)
+	}
+	switch {
+	case deployment.Status != DeploymentInProgress:
+		return deployment.TrackedDeployment, fmt.Errorf("deployment %s has already %s", id, deployment.Status)
+	case deployment.applied:
+		return deployment.TrackedDeployment, fmt.Errorf("deployment %s has already been applied to Kubernetes", id)
+	}
+
+	deployment.CancelRequested = true
+	return deployment.TrackedDeployment, nil
+}
+
+func (t *DeploymentTracker) Get(id string) (TrackedDeployment, bool) {
+	if t == nil {
+		return TrackedDeployment{}, false
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+
+	deployment, ok := t.deployments[id]
+	if !ok {
+		return TrackedDeployment{}, false
+	}
+	return deployment.TrackedDeployment, true
+}
+
+func (t *DeploymentTracker) InProgress() []TrackedDeployment {
+	deployments := []TrackedDeployment{}
+	if t == nil {
+		return deployments
+	}
+
+	t.lock()
+	defer t.mutex.Unlock()
+
+	for _, deployment := range t.deployments {
+		if deployment.Status == DeploymentInProgress {
+			deployments = append(deployments, deployment.TrackedDeployment)
+		}
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].Started.Before(deployments[j].Started)
+	})
+	return deployments
+}
+
+func (api Api) listDeployments(w http.ResponseWriter, _ *http.Request) *appError {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.Deployments.InProgress()); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (api Api) getDeployment(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	deployment, ok := api.Deployments.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("deployment %s not found", id), "deployment not found", http.StatusNotFound}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(deployment); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (api Api) cancelDeployment(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	if _, ok := api.Deployments.Get(id); !ok {
+		return &appError{fmt.Errorf("deployment %s not found", id), "deployment not found", http.StatusNotFound}
+	}
+
+	deployment, err := api.Deployments.Cancel(id)
+	if err != nil {
+		return &appError{err, "unable to cancel deployment", http.StatusConflict}
+	}
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "deployment_cancel_requested",
+		Application: deployment.Application,
+		Namespace:   deployment.Namespace,
+		Version:     deployment.Version,
+		Details:     map[string]string{"id": deployment.Id, "phase": deployment.Phase},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(deployment); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
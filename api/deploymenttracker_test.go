@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentTracker(t *testing.T) {
+	request := naisrequest.Deploy{Application: "app", Namespace: "default", Version: "1.0.0"}
+
+	t.Run("Only one deployment per application at a time", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		first, err := tracker.start(context.Background(), request, 0)
+		assert.NoError(t, err)
+
+		_, err = tracker.start(context.Background(), request, 0)
+		assert.Error(t, err)
+
+		other, err := tracker.start(context.Background(), naisrequest.Deploy{Application: "app", Namespace: "t1"}, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(tracker.InProgress()))
+
+		tracker.finish(first, true)
+		tracker.finish(other, false)
+		_, err = tracker.start(context.Background(), request, 0)
+		assert.NoError(t, err)
+
+		deployment, _ := tracker.Get(first.Id)
+		assert.Equal(t, DeploymentSucceeded, deployment.Status)
+		deployment, _ = tracker.Get(other.Id)
+		assert.Equal(t, DeploymentFailed, deployment.Status)
+	})
+
+	t.Run("Cancelled deployment stops at next phase and releases the application when it has stopped", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		deployment, _ := tracker.start(context.Background(), request, 0)
+		assert.NoError(t, tracker.enter(deployment, PhaseManifest))
+
+		cancelled, err := tracker.Cancel(deployment.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, DeploymentInProgress, cancelled.Status)
+		assert.True(t, cancelled.CancelRequested)
+		assert.Equal(t, PhaseManifest, cancelled.Phase)
+		assert.NoError(t, deployment.ctx.Err(), "the phase in progress is not interrupted")
+
+		assert.EqualError(t, tracker.enter(deployment, PhaseFasit), "deployment "+deployment.Id+" was cancelled before the fasit phase")
+		_, err = tracker.start(context.Background(), request, 0)
+		assert.Error(t, err, "the application is kept until the deployment has returned")
+
+		tracker.finish(deployment, false)
+		status, _ := tracker.Get(deployment.Id)
+		assert.Equal(t, DeploymentCancelled, status.Status)
+		_, err = tracker.start(context.Background(), request, 0)
+		assert.NoError(t, err)
+
+		_, err = tracker.Cancel(deployment.Id)
+		assert.EqualError(t, err, "deployment "+deployment.Id+" has already cancelled")
+	})
+
+	t.Run("Deployments applied to Kubernetes can not be cancelled", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		deployment, _ := tracker.start(context.Background(), request, 0)
+		defer tracker.finish(deployment, true)
+		assert.NoError(t, tracker.enter(deployment, PhaseKubernetes))
+
+		_, err := tracker.Cancel(deployment.Id)
+		assert.EqualError(t, err, "deployment "+deployment.Id+" has already been applied to Kubernetes")
+		assert.NoError(t, tracker.enter(deployment, PhaseFasitUpdate))
+	})
+
+	t.Run("Only the most recent finished deployments are kept", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		first, _ := tracker.start(context.Background(), request, 0)
+		tracker.finish(first, true)
+		for i := 0; i < maxFinishedDeployments; i++ {
+			deployment, _ := tracker.start(context.Background(), request, 0)
+			tracker.finish(deployment, true)
+		}
+
+		_, ok := tracker.Get(first.Id)
+		assert.False(t, ok)
+	})
+
+	t.Run("Deployments exceeding their max duration time out in the current phase", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		deployment, _ := tracker.start(context.Background(), request, time.Hour)
+		assert.NoError(t, tracker.enter(deployment, PhaseKubernetes))
+
+		tracker.limit(deployment, time.Nanosecond)
+		<-deployment.context().Done()
+		assert.EqualError(t, tracker.enter(deployment, PhaseFasitUpdate), "deployment "+deployment.Id+" exceeded its max duration of 1ns in the kubernetes phase")
+		tracker.finish(deployment, false)
+
+		status, _ := tracker.Get(deployment.Id)
+		assert.Equal(t, DeploymentTimedOut, status.Status)
+		assert.Equal(t, "1ns", status.MaxDuration)
+	})
+
+	t.Run("Deployments are cancelled along with the request that started them", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		ctx, cancel := context.WithCancel(context.Background())
+		deployment, _ := tracker.start(ctx, request, 0)
+		defer tracker.finish(deployment, false)
+
+		cancel()
+		assert.EqualError(t, tracker.enter(deployment, PhaseFasit), "deployment "+deployment.Id+" was cancelled before the fasit phase")
+	})
+
+	t.Run("Deployments can be limited while their context is in use", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		deployment, _ := tracker.start(context.Background(), request, 0)
+		defer tracker.finish(deployment, true)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				deployment.context().Err()
+				deployment.resolved(ResourceTiming{Alias: "mydb"})
+			}
+		}()
+		tracker.limit(deployment, time.Hour)
+		<-done
+
+		_, hasDeadline := deployment.context().Deadline()
+		assert.True(t, hasDeadline)
+		assert.Equal(t, time.Hour, deployment.timeLimit())
+	})
+
+	t.Run("Nil tracker allows deployments", func(t *testing.T) {
+		var tracker *DeploymentTracker
+		deployment, err := tracker.start(context.Background(), request, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, tracker.enter(deployment, PhaseManifest))
+		tracker.finish(deployment, true)
+	})
+}
+
+func TestCancelDeployment(t *testing.T) {
+	api := Api{Deployments: NewDeploymentTracker(), OperatorToken: "operator"}
+	deployment, _ := api.Deployments.start(context.Background(), naisrequest.Deploy{Application: "app", Namespace: "default"}, 0)
+
+	req, _ := http.NewRequest("GET", "/deploy", nil)
+	rr := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+	var inProgress []TrackedDeployment
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &inProgress))
+	assert.Equal(t, deployment.Id, inProgress[0].Id)
+
+	req, _ = http.NewRequest("DELETE", "/deploy/"+deployment.Id, nil)
+	rr = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "only operators can cancel deployments")
+
+	cancel := func(id string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/deploy/"+id, nil)
+		req.Header.Set("Authorization", "Bearer operator")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, http.StatusAccepted, cancel(deployment.Id).Code)
+
+	req, _ = http.NewRequest("GET", "/deploy/"+deployment.Id, nil)
+	rr = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+	var status TrackedDeployment
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.True(t, status.CancelRequested)
+
+	api.Deployments.enter(deployment, PhaseFasit)
+	api.Deployments.finish(deployment, false)
+	assert.Equal(t, http.StatusConflict, cancel(deployment.Id).Code)
+	assert.Equal(t, http.StatusNotFound, cancel("unknown").Code)
+}
+
+func TestValidateMaxDeployDuration(t *testing.T) {
+	assert.Nil(t, validateMaxDeployDuration(NaisManifest{}))
+	assert.Nil(t, validateMaxDeployDuration(NaisManifest{MaxDeployDuration: "15m"}))
+	assert.NotNil(t, validateMaxDeployDuration(NaisManifest{MaxDeployDuration: "15"}))
+	assert.NotNil(t, validateMaxDeployDuration(NaisManifest{MaxDeployDuration: "-1m"}))
+}
+
+func TestWaitForRollout(t *testing.T) {
+	request := naisrequest.Deploy{Application: "app", Namespace: "default"}
+
+	t.Run("Successful rollout", func(t *testing.T) {
+		api := Api{DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: Success}}
+		deployment, _ := api.Deployments.start(context.Background(), request, time.Minute)
+		assert.Nil(t, api.waitForRollout(deployment, request))
+	})
+
+	t.Run("Failed rollout", func(t *testing.T) {
+		api := Api{DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: Failed, viewToReturn: DeploymentStatusView{Reason: "ProgressDeadlineExceeded"}}}
+		deployment, _ := api.Deployments.start(context.Background(), request, time.Minute)
+		appErr := api.waitForRollout(deployment, request)
+		assert.Equal(t, http.StatusInternalServerError, appErr.StatusCode)
+		assert.EqualError(t, appErr.OriginalError, "rollout failed: ProgressDeadlineExceeded")
+	})
+
+	t.Run("Rollout exceeding the max duration times out", func(t *testing.T) {
+		api := Api{DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: InProgress}}
+		deployment, _ := api.Deployments.start(context.Background(), request, 10*time.Millisecond)
+		appErr := api.waitForRollout(deployment, request)
+		assert.Equal(t, http.StatusGatewayTimeout, appErr.StatusCode)
+		assert.EqualError(t, appErr.OriginalError, "deployment "+deployment.Id+" exceeded its max duration of 10ms in the rollout phase")
+	})
+}
+
+func gaugeValue(gauge prometheus.Gauge) float64 {
+	var metric dto.Metric
+	gauge.Write(&metric)
+	return metric.GetGauge().GetValue()
+}
+
+func TestDeploymentTrackerMetrics(t *testing.T) {
+	tracker := NewDeploymentTracker()
+	active := gaugeValue(deploymentsActive)
+	inKubernetes := gaugeValue(deploymentsInPhase.WithLabelValues(PhaseKubernetes))
+
+	deployment, _ := tracker.start(context.Background(), naisrequest.Deploy{Application: "metrics", Namespace: "default"}, 0)
+	assert.Equal(t, active+1, gaugeValue(deploymentsActive))
+
+	tracker.enter(deployment, PhaseScan)
+	tracker.enter(deployment, PhaseKubernetes)
+	assert.Equal(t, inKubernetes+1, gaugeValue(deploymentsInPhase.WithLabelValues(PhaseKubernetes)))
+
+	tracker.finish(deployment, true)
+	assert.Equal(t, active, gaugeValue(deploymentsActive))
+	assert.Equal(t, inKubernetes, gaugeValue(deploymentsInPhase.WithLabelValues(PhaseKubernetes)))
+}
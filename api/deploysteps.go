@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// Steps of a deployment after the application is running in Kubernetes. A critical step that fails fails the
+// deployment, while a best-effort step that fails is reported as a warning in the result.
+const (
+	StepNetworkPolicy      = "network-policy"
+	StepChangeCause        = "change-cause"
+	StepFasitStopPrevious  = "fasit-stop-previous"
+	StepFasitUpdate        = "fasit-update"
+	StepFasitEvent         = "fasit-event"
+	StepScanAnnotation     = "scan-annotation"
+	StepPullRequestComment = "pull-request-comment"
+	StepFirewallRequests   = "firewall-requests"
+
+	StepCritical   = "critical"
+	StepBestEffort = "best-effort"
+)
+
+// defaultSteps is how each step is classified unless the steps setting in the daemon config says otherwise
+var defaultSteps = map[string]string{
+	StepNetworkPolicy:      StepCritical,
+	StepChangeCause:        StepBestEffort,
+	StepFasitStopPrevious:  StepBestEffort,
+	StepFasitUpdate:        StepCritical,
+	StepFasitEvent:         StepBestEffort,
+	StepScanAnnotation:     StepBestEffort,
+	StepPullRequestComment: StepBestEffort,
+	StepFirewallRequests:   StepBestEffort,
+}
+
+// DeploySteps classifies steps as critical or best-effort, overriding defaultSteps
+type DeploySteps map[string]string
+
+func validateDeploySteps(steps DeploySteps) error {
+	for step, classification := range steps {
+		if _, ok := defaultSteps[step]; !ok {
+			return fmt.Errorf("unknown deployment step %s in steps, expected one of %v", step, sortedKeys(defaultSteps))
+		}
+		if classification != StepCritical && classification != StepBestEffort {
+			return fmt.Errorf("deployment step %s must be %s or %s, not %q", step, StepCritical, StepBestEffort, classification)
+		}
+	}
+	return nil
+}
+
+func (steps DeploySteps) critical(step string) bool {
+	if classification, ok := steps[step]; ok {
+		return classification == StepCritical
+	}
+	return defaultSteps[step] == StepCritical
+}
+
+// classifications returns every step with the classification it has
+func (steps DeploySteps) classifications() map[string]string {
+	classifications := make(map[string]string, len(defaultSteps))
+	for step := range defaultSteps {
+		classifications[step] = StepBestEffort
+		if steps.critical(step) {
+			classifications[step] = StepCritical
+		}
+	}
+	return classifications
+}
+
+// stepFailed fails the deployment with message if the step is critical. Otherwise the failure is added to the warnings
+// of the result, and the deployment carries on.
+func (api Api) stepFailed(step string, err error, message string, result *DeploymentResult) *appError {
+	if api.DeploySteps.critical(step) {
+		return &appError{err, message, fasitResponseStatus(err, http.StatusInternalServerError)}
+	}
+
+	glog.Warningf("best-effort step %s failed: %s: %s", step, message, err)
+	result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s", message, err))
+	bestEffortFailures.WithLabelValues(step).Inc()
+	return nil
+}
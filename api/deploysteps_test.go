@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeploySteps(t *testing.T) {
+	assert.NoError(t, validateDeploySteps(DeploySteps{StepFasitUpdate: StepBestEffort, StepFirewallRequests: StepCritical}))
+	assert.EqualError(t, validateDeploySteps(DeploySteps{StepFasitUpdate: "optional"}), `deployment step fasit-update must be critical or best-effort, not "optional"`)
+	assert.Error(t, validateDeploySteps(DeploySteps{"slack": StepBestEffort}))
+}
+
+func TestDeployStepClassifications(t *testing.T) {
+	steps := DeploySteps{StepFasitUpdate: StepBestEffort, StepFirewallRequests: StepCritical}
+
+	assert.True(t, steps.critical(StepNetworkPolicy))
+	assert.False(t, steps.critical(StepFasitUpdate))
+	assert.True(t, steps.critical(StepFirewallRequests))
+	assert.False(t, steps.critical(StepPullRequestComment))
+
+	classifications := steps.classifications()
+	assert.Len(t, classifications, len(defaultSteps))
+	assert.Equal(t, StepBestEffort, classifications[StepFasitUpdate])
+	assert.Equal(t, StepCritical, classifications[StepNetworkPolicy])
+}
+
+func TestStepFailed(t *testing.T) {
+	api := Api{DeploySteps: DeploySteps{StepFasitUpdate: StepBestEffort}}
+	err := errors.New("fasit is down")
+
+	t.Run("Critical steps fail the deployment", func(t *testing.T) {
+		var result DeploymentResult
+		appErr := api.stepFailed(StepNetworkPolicy, err, "failed while creating or updating network policy", &result)
+		assert.Equal(t, http.StatusInternalServerError, appErr.StatusCode)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("Best-effort steps add a warning", func(t *testing.T) {
+		var result DeploymentResult
+		failures := counterValue(bestEffortFailures.WithLabelValues(StepFasitUpdate))
+
+		assert.Nil(t, api.stepFailed(StepFasitUpdate, err, "failed while updating Fasit", &result))
+		assert.Equal(t, []string{"failed while updating Fasit: fasit is down"}, result.Warnings)
+		assert.Equal(t, failures+1, counterValue(bestEffortFailures.WithLabelValues(StepFasitUpdate)))
+	})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	k8score "k8s.io/api/core/v1"
+)
+
+type DnsConfig struct {
+	Nameservers []string
+	Searches    []string
+	Options     []DnsOption
+}
+
+type DnsOption struct {
+	Name  string
+	Value string
+}
+
+type HostAlias struct {
+	Ip        string
+	Hostnames []string
+}
+
+// DnsAllowList is configured by the operator. HostAliases maps IP addresses to the hostnames applications may
+// give them, and Nameservers lists the resolvers applications may use.
+type DnsAllowList struct {
+	HostAliases map[string][]string `yaml:"hostAliases"`
+	Nameservers []string
+}
+
+var dnsPolicies = []string{
+	string(k8score.DNSClusterFirst),
+	string(k8score.DNSDefault),
+	string(k8score.DNSNone),
+}
+
+func validateDns(manifest NaisManifest) *ValidationError {
+	if len(manifest.DnsPolicy) > 0 && !contains(dnsPolicies, manifest.DnsPolicy) {
+		return &ValidationError{
+			"DnsPolicy must be one of " + strings.Join(dnsPolicies, ", "),
+			map[string]string{"DnsPolicy": manifest.DnsPolicy},
+		}
+	}
+
+	if manifest.DnsPolicy == string(k8score.DNSNone) && len(manifest.DnsConfig.Nameservers) == 0 {
+		return &ValidationError{
+			"DnsConfig.Nameservers must be set when DnsPolicy is None",
+			map[string]string{"DnsPolicy": manifest.DnsPolicy},
+		}
+	}
+
+	for _, nameserver := range manifest.DnsConfig.Nameservers {
+		if net.ParseIP(nameserver) == nil {
+			return &ValidationError{
+				"DnsConfig.Nameservers must be IP addresses",
+				map[string]string{"Nameserver": nameserver},
+			}
+		}
+	}
+
+	for _, alias := range manifest.HostAliases {
+		if net.ParseIP(alias.Ip) == nil {
+			return &ValidationError{
+				"HostAliases.Ip must be an IP address",
+				map[string]string{"Ip": alias.Ip},
+			}
+		}
+		if len(alias.Hostnames) == 0 {
+			return &ValidationError{
+				"HostAliases.Hostnames must be set",
+				map[string]string{"Ip": alias.Ip},
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks host aliases and nameservers in the manifest against what the operator has permitted
+func checkDnsAllowList(manifest NaisManifest, allowList DnsAllowList) error {
+	for _, alias := range manifest.HostAliases {
+		allowed, ok := allowList.HostAliases[alias.Ip]
+		if !ok {
+			return fmt.Errorf("host alias IP %s is not permitted", alias.Ip)
+		}
+		for _, hostname := range alias.Hostnames {
+			if !contains(allowed, hostname) {
+				return fmt.Errorf("host alias %s for %s is not permitted", hostname, alias.Ip)
+			}
+		}
+	}
+
+	for _, nameserver := range manifest.DnsConfig.Nameservers {
+		if !contains(allowList.Nameservers, nameserver) {
+			return fmt.Errorf("nameserver %s is not permitted", nameserver)
+		}
+	}
+
+	return nil
+}
+
+func createDnsPolicy(manifest NaisManifest) k8score.DNSPolicy {
+	if len(manifest.DnsPolicy) == 0 {
+		return k8score.DNSClusterFirst
+	}
+	return k8score.DNSPolicy(manifest.DnsPolicy)
+}
+
+func createPodDnsConfig(config DnsConfig) *k8score.PodDNSConfig {
+	if len(config.Nameservers) == 0 && len(config.Searches) == 0 && len(config.Options) == 0 {
+		return nil
+	}
+
+	podDnsConfig := &k8score.PodDNSConfig{
+		Nameservers: config.Nameservers,
+		Searches:    config.Searches,
+	}
+	for _, option := range config.Options {
+		podDnsOption := k8score.PodDNSConfigOption{Name: option.Name}
+		if len(option.Value) > 0 {
+			value := option.Value
+			podDnsOption.Value = &value
+		}
+		podDnsConfig.Options = append(podDnsConfig.Options, podDnsOption)
+	}
+
+	return podDnsConfig
+}
+
+func createHostAliases(aliases []HostAlias) []k8score.HostAlias {
+	var hostAliases []k8score.HostAlias
+	for _, alias := range aliases {
+		hostAliases = append(hostAliases, k8score.HostAlias{IP: alias.Ip, Hostnames: alias.Hostnames})
+	}
+	return hostAliases
+}
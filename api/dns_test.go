@@ -0,0 +1,97 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateDns(t *testing.T) {
+	t.Run("Valid DNS settings are accepted", func(t *testing.T) {
+		manifest := NaisManifest{
+			DnsPolicy:   "None",
+			DnsConfig:   DnsConfig{Nameservers: []string{"10.0.0.53"}, Searches: []string{"adeo.no"}},
+			HostAliases: []HostAlias{{Ip: "10.0.0.1", Hostnames: []string{"legacy.adeo.no"}}},
+		}
+		assert.Nil(t, validateDns(manifest))
+	})
+
+	t.Run("Unknown DNS policy gives error", func(t *testing.T) {
+		err := validateDns(NaisManifest{DnsPolicy: "ClusterFirstWithHostNet"})
+		assert.Equal(t, "ClusterFirstWithHostNet", err.Fields["DnsPolicy"])
+	})
+
+	t.Run("DNS policy None requires nameservers", func(t *testing.T) {
+		err := validateDns(NaisManifest{DnsPolicy: "None"})
+		assert.Equal(t, "DnsConfig.Nameservers must be set when DnsPolicy is None", err.ErrorMessage)
+	})
+
+	t.Run("Invalid IP addresses give error", func(t *testing.T) {
+		err := validateDns(NaisManifest{DnsConfig: DnsConfig{Nameservers: []string{"dns.adeo.no"}}})
+		assert.Equal(t, "dns.adeo.no", err.Fields["Nameserver"])
+
+		err = validateDns(NaisManifest{HostAliases: []HostAlias{{Ip: "legacy", Hostnames: []string{"legacy.adeo.no"}}}})
+		assert.Equal(t, "legacy", err.Fields["Ip"])
+
+		err = validateDns(NaisManifest{HostAliases: []HostAlias{{Ip: "10.0.0.1"}}})
+		assert.Equal(t, "HostAliases.Hostnames must be set", err.ErrorMessage)
+	})
+}
+
+func TestCheckDnsAllowList(t *testing.T) {
+	allowList := DnsAllowList{
+		HostAliases: map[string][]string{"10.0.0.1": {"legacy.adeo.no", "legacy"}},
+		Nameservers: []string{"10.0.0.53"},
+	}
+
+	assert.NoError(t, checkDnsAllowList(NaisManifest{}, DnsAllowList{}))
+	assert.NoError(t, checkDnsAllowList(NaisManifest{
+		HostAliases: []HostAlias{{Ip: "10.0.0.1", Hostnames: []string{"legacy"}}},
+		DnsConfig:   DnsConfig{Nameservers: []string{"10.0.0.53"}},
+	}, allowList))
+
+	assert.EqualError(t, checkDnsAllowList(NaisManifest{
+		HostAliases: []HostAlias{{Ip: "10.0.0.2", Hostnames: []string{"legacy"}}},
+	}, allowList), "host alias IP 10.0.0.2 is not permitted")
+
+	assert.EqualError(t, checkDnsAllowList(NaisManifest{
+		HostAliases: []HostAlias{{Ip: "10.0.0.1", Hostnames: []string{"other.adeo.no"}}},
+	}, allowList), "host alias other.adeo.no for 10.0.0.1 is not permitted")
+
+	assert.EqualError(t, checkDnsAllowList(NaisManifest{
+		DnsConfig: DnsConfig{Nameservers: []string{"8.8.8.8"}},
+	}, allowList), "nameserver 8.8.8.8 is not permitted")
+}
+
+func TestDnsInPodSpec(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version, SkipFasit: true}
+
+	t.Run("Cluster DNS is used by default", func(t *testing.T) {
+		spec, err := createPodSpec(deploymentRequest, newDefaultManifest(), []NaisResource{})
+		assert.NoError(t, err)
+		assert.Equal(t, k8score.DNSClusterFirst, spec.DNSPolicy)
+		assert.Nil(t, spec.DNSConfig)
+		assert.Empty(t, spec.HostAliases)
+	})
+
+	t.Run("DNS settings from manifest are used", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.DnsPolicy = "None"
+		manifest.DnsConfig = DnsConfig{Nameservers: []string{"10.0.0.53"}, Options: []DnsOption{{Name: "ndots", Value: "2"}, {Name: "edns0"}}}
+		manifest.HostAliases = []HostAlias{{Ip: "10.0.0.1", Hostnames: []string{"legacy.adeo.no"}}}
+
+		deployment, err := createOrUpdateDeployment(deploymentRequest, manifest, []NaisResource{}, false, clientset)
+		assert.NoError(t, err)
+
+		spec := deployment.Spec.Template.Spec
+		assert.Equal(t, k8score.DNSNone, spec.DNSPolicy)
+		assert.Equal(t, []string{"10.0.0.53"}, spec.DNSConfig.Nameservers)
+		assert.Equal(t, "2", *spec.DNSConfig.Options[0].Value)
+		assert.Nil(t, spec.DNSConfig.Options[1].Value)
+		assert.Equal(t, []k8score.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"legacy.adeo.no"}}}, spec.HostAliases)
+	})
+}
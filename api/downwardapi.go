@@ -0,0 +1,161 @@
+package api
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	k8score "k8s.io/api/core/v1"
+)
+
+const (
+	DownwardApiMountPoint = "/var/run/naisd.io/podinfo/"
+	DownwardApiVolumeName = "podinfo"
+)
+
+type DownwardApiConfig struct {
+	Env   []DownwardApiField
+	Files []DownwardApiField
+}
+
+// DownwardApiField selects a single pod field or container resource. For environment
+// variables Name is the variable name, for files it is the file path relative to the mount point.
+type DownwardApiField struct {
+	Name      string
+	FieldPath string `yaml:"fieldPath"`
+	Resource  string
+}
+
+var (
+	downwardApiFieldPaths = []string{
+		"metadata.name",
+		"metadata.namespace",
+		"metadata.uid",
+		"spec.nodeName",
+		"spec.serviceAccountName",
+		"status.hostIP",
+		"status.podIP",
+	}
+	downwardApiFilesOnlyFieldPaths = []string{
+		"metadata.labels",
+		"metadata.annotations",
+	}
+	downwardApiResources = []string{
+		"limits.cpu",
+		"limits.memory",
+		"requests.cpu",
+		"requests.memory",
+	}
+	downwardApiSubscriptedFieldPath = regexp.MustCompile(`^metadata\.(labels|annotations)\['[^']+'\]$`)
+)
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validDownwardApiFieldPath(fieldPath string, isFile bool) bool {
+	if contains(downwardApiFieldPaths, fieldPath) || downwardApiSubscriptedFieldPath.MatchString(fieldPath) {
+		return true
+	}
+	return isFile && contains(downwardApiFilesOnlyFieldPaths, fieldPath)
+}
+
+func validateDownwardApiField(field DownwardApiField, isFile bool) *ValidationError {
+	if len(field.Name) == 0 {
+		return &ValidationError{
+			"Name must be specified for downward API fields",
+			map[string]string{"FieldPath": field.FieldPath, "Resource": field.Resource},
+		}
+	}
+
+	if (len(field.FieldPath) > 0) == (len(field.Resource) > 0) {
+		return &ValidationError{
+			"Exactly one of fieldPath and resource must be specified",
+			map[string]string{"Name": field.Name},
+		}
+	}
+
+	if len(field.FieldPath) > 0 && !validDownwardApiFieldPath(field.FieldPath, isFile) {
+		return &ValidationError{
+			"Unsupported downward API field path",
+			map[string]string{"Name": field.Name, "FieldPath": field.FieldPath},
+		}
+	}
+
+	if len(field.Resource) > 0 && !contains(downwardApiResources, field.Resource) {
+		return &ValidationError{
+			"Unsupported downward API resource, must be one of " + strings.Join(downwardApiResources, ", "),
+			map[string]string{"Name": field.Name, "Resource": field.Resource},
+		}
+	}
+
+	if isFile && (path.IsAbs(field.Name) || strings.HasPrefix(path.Clean(field.Name), "..")) {
+		return &ValidationError{
+			"Downward API file path must be relative to " + DownwardApiMountPoint,
+			map[string]string{"Name": field.Name},
+		}
+	}
+
+	return nil
+}
+
+func validateDownwardApi(manifest NaisManifest) *ValidationError {
+	for _, field := range manifest.DownwardApi.Env {
+		if err := validateDownwardApiField(field, false); err != nil {
+			return err
+		}
+	}
+	for _, field := range manifest.DownwardApi.Files {
+		if err := validateDownwardApiField(field, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createDownwardApiEnvironmentVariables(appName string, fields []DownwardApiField) []k8score.EnvVar {
+	var envVars []k8score.EnvVar
+	for _, field := range fields {
+		envVar := k8score.EnvVar{Name: field.Name, ValueFrom: &k8score.EnvVarSource{}}
+		if len(field.FieldPath) > 0 {
+			envVar.ValueFrom.FieldRef = &k8score.ObjectFieldSelector{FieldPath: field.FieldPath}
+		} else {
+			envVar.ValueFrom.ResourceFieldRef = &k8score.ResourceFieldSelector{ContainerName: appName, Resource: field.Resource}
+		}
+		envVars = append(envVars, envVar)
+	}
+	return envVars
+}
+
+func createDownwardApiVolume(appName string, fields []DownwardApiField) k8score.Volume {
+	var items []k8score.DownwardAPIVolumeFile
+	for _, field := range fields {
+		item := k8score.DownwardAPIVolumeFile{Path: field.Name}
+		if len(field.FieldPath) > 0 {
+			item.FieldRef = &k8score.ObjectFieldSelector{FieldPath: field.FieldPath}
+		} else {
+			item.ResourceFieldRef = &k8score.ResourceFieldSelector{ContainerName: appName, Resource: field.Resource}
+		}
+		items = append(items, item)
+	}
+
+	return k8score.Volume{
+		Name: DownwardApiVolumeName,
+		VolumeSource: k8score.VolumeSource{
+			DownwardAPI: &k8score.DownwardAPIVolumeSource{Items: items},
+		},
+	}
+}
+
+func createDownwardApiVolumeMount() k8score.VolumeMount {
+	return k8score.VolumeMount{
+		Name:      DownwardApiVolumeName,
+		MountPath: DownwardApiMountPoint,
+		ReadOnly:  true,
+	}
+}
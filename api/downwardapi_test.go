@@ -0,0 +1,112 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateDownwardApi(t *testing.T) {
+	t.Run("Valid field paths and resources are accepted", func(t *testing.T) {
+		manifest := NaisManifest{DownwardApi: DownwardApiConfig{
+			Env: []DownwardApiField{
+				{Name: "POD_NAME", FieldPath: "metadata.name"},
+				{Name: "POD_IP", FieldPath: "status.podIP"},
+				{Name: "TEAM", FieldPath: "metadata.labels['team']"},
+				{Name: "MEMORY_LIMIT", Resource: "limits.memory"},
+			},
+			Files: []DownwardApiField{
+				{Name: "labels", FieldPath: "metadata.labels"},
+				{Name: "limits/cpu", Resource: "limits.cpu"},
+			},
+		}}
+
+		assert.Nil(t, validateDownwardApi(manifest))
+	})
+
+	t.Run("Unknown field path gives error", func(t *testing.T) {
+		manifest := NaisManifest{DownwardApi: DownwardApiConfig{
+			Env: []DownwardApiField{{Name: "NODE", FieldPath: "spec.containers"}},
+		}}
+
+		err := validateDownwardApi(manifest)
+		assert.Equal(t, "Unsupported downward API field path", err.ErrorMessage)
+		assert.Equal(t, "spec.containers", err.Fields["FieldPath"])
+	})
+
+	t.Run("All labels can only be exposed as files", func(t *testing.T) {
+		manifest := NaisManifest{DownwardApi: DownwardApiConfig{
+			Env: []DownwardApiField{{Name: "LABELS", FieldPath: "metadata.labels"}},
+		}}
+
+		err := validateDownwardApi(manifest)
+		assert.Equal(t, "Unsupported downward API field path", err.ErrorMessage)
+	})
+
+	t.Run("Unknown resource gives error", func(t *testing.T) {
+		manifest := NaisManifest{DownwardApi: DownwardApiConfig{
+			Env: []DownwardApiField{{Name: "STORAGE", Resource: "limits.storage"}},
+		}}
+
+		err := validateDownwardApi(manifest)
+		assert.Equal(t, "limits.storage", err.Fields["Resource"])
+	})
+
+	t.Run("Both or none of fieldPath and resource gives error", func(t *testing.T) {
+		both := NaisManifest{DownwardApi: DownwardApiConfig{
+			Env: []DownwardApiField{{Name: "X", FieldPath: "metadata.name", Resource: "limits.cpu"}},
+		}}
+		none := NaisManifest{DownwardApi: DownwardApiConfig{
+			Env: []DownwardApiField{{Name: "X"}},
+		}}
+
+		assert.Equal(t, "Exactly one of fieldPath and resource must be specified", validateDownwardApi(both).ErrorMessage)
+		assert.Equal(t, "Exactly one of fieldPath and resource must be specified", validateDownwardApi(none).ErrorMessage)
+	})
+
+	t.Run("File paths must stay inside the mount point", func(t *testing.T) {
+		absolute := NaisManifest{DownwardApi: DownwardApiConfig{
+			Files: []DownwardApiField{{Name: "/etc/labels", FieldPath: "metadata.labels"}},
+		}}
+		escaping := NaisManifest{DownwardApi: DownwardApiConfig{
+			Files: []DownwardApiField{{Name: "../labels", FieldPath: "metadata.labels"}},
+		}}
+
+		assert.NotNil(t, validateDownwardApi(absolute))
+		assert.NotNil(t, validateDownwardApi(escaping))
+	})
+}
+
+func TestDownwardApiInPodSpec(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	manifest := newDefaultManifest()
+	manifest.DownwardApi = DownwardApiConfig{
+		Env: []DownwardApiField{
+			{Name: "POD_NAME", FieldPath: "metadata.name"},
+			{Name: "CPU_LIMIT", Resource: "limits.cpu"},
+		},
+		Files: []DownwardApiField{
+			{Name: "annotations", FieldPath: "metadata.annotations"},
+		},
+	}
+
+	deployment, err := createOrUpdateDeployment(naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version, SkipFasit: true}, manifest, []NaisResource{}, false, clientset)
+	assert.NoError(t, err)
+
+	spec := deployment.Spec.Template.Spec
+	env := spec.Containers[0].Env
+	assert.Equal(t, 4, len(env))
+	assert.Equal(t, "POD_NAME", env[2].Name)
+	assert.Equal(t, "metadata.name", env[2].ValueFrom.FieldRef.FieldPath)
+	assert.Equal(t, "CPU_LIMIT", env[3].Name)
+	assert.Equal(t, "limits.cpu", env[3].ValueFrom.ResourceFieldRef.Resource)
+	assert.Equal(t, appName, env[3].ValueFrom.ResourceFieldRef.ContainerName)
+
+	assert.Equal(t, 1, len(spec.Volumes))
+	assert.Equal(t, DownwardApiVolumeName, spec.Volumes[0].Name)
+	assert.Equal(t, "annotations", spec.Volumes[0].DownwardAPI.Items[0].Path)
+	assert.Equal(t, "metadata.annotations", spec.Volumes[0].DownwardAPI.Items[0].FieldRef.FieldPath)
+	assert.Equal(t, DownwardApiMountPoint, spec.Containers[0].VolumeMounts[0].MountPath)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/nais/naisd/api/naisrequest"
+	k8score "k8s.io/api/core/v1"
+	k8snetworking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const DefaultExternalServiceProtocol = "TCP"
+
+// ExternalService is a service outside the cluster the application connects to. Host is a hostname, an IP address or a CIDR.
+type ExternalService struct {
+	Host     string
+	Port     int
+	Protocol string
+}
+
+// EgressConfig describes the cluster's egress identity. Ips are the addresses traffic leaves the cluster from, per zone.
+type EgressConfig struct {
+	Ips             map[string][]string
+	NetworkPolicies bool `yaml:"networkPolicies"`
+}
+
+// EgressRequest is a single firewall opening needed by an application
+type EgressRequest struct {
+	Application string   `json:"application"`
+	Namespace   string   `json:"namespace"`
+	Environment string   `json:"environment,omitempty"`
+	Zone        string   `json:"zone"`
+	Cluster     string   `json:"cluster"`
+	SourceIps   []string `json:"sourceIps"`
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	Protocol    string   `json:"protocol"`
+	Resource    string   `json:"resource,omitempty"`
+}
+
+func externalServiceProtocol(service ExternalService) string {
+	if len(service.Protocol) == 0 {
+		return DefaultExternalServiceProtocol
+	}
+	return service.Protocol
+}
+
+func validateExternalServices(manifest NaisManifest) *ValidationError {
+	for _, service := range manifest.ExternalServices {
+		fields := map[string]string{"Host": service.Host, "Port": strconv.Itoa(service.Port)}
+
+		if len(service.Host) == 0 {
+			return &ValidationError{"ExternalServices.Host must be set", fields}
+		}
+		if service.Port < 1 || service.Port > 65535 {
+			return &ValidationError{"ExternalServices.Port must be between 1 and 65535", fields}
+		}
+		if protocol := externalServiceProtocol(service); protocol != "TCP" && protocol != "UDP" {
+			fields["Protocol"] = protocol
+			return &ValidationError{"ExternalServices.Protocol must be TCP or UDP", fields}
+		}
+	}
+	return nil
+}
+
+// Hostnames can't be expressed in a NetworkPolicy, so traffic to them is allowed to any address on the given port
+func externalServiceCidr(host string) string {
+	if _, _, err := net.ParseCIDR(host); err == nil {
+		return host
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return host + "/32"
+		}
+		return host + "/128"
+	}
+	return "0.0.0.0/0"
+}
+
+func networkPolicyPort(port int, protocol string) k8snetworking.NetworkPolicyPort {
+	p := intstr.FromInt(port)
+	proto := k8score.Protocol(protocol)
+	return k8snetworking.NetworkPolicyPort{Protocol: &proto, Port: &p}
+}
+
+// Allows egress to everything inside the cluster, DNS, the declared external services and the endpoints of the used
+// Fasit resources, the same openings the egress report and the firewall requests are made of
+func createNetworkPolicyDef(deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource, existing *k8snetworking.NetworkPolicy) *k8snetworking.NetworkPolicy {
+	policy := existing
+	if policy == nil {
+		policy = &k8snetworking.NetworkPolicy{
+			TypeMeta: k8smeta.TypeMeta{
+				Kind:       "NetworkPolicy",
+				APIVersion: "networking.k8s.io/v1",
+			},
+			ObjectMeta: createObjectMeta(deploymentRequest.Application, deploymentRequest.Namespace, manifest.Team),
+		}
+	}
+
+	egress := []k8snetworking.NetworkPolicyEgressRule{
+		{To: []k8snetworking.NetworkPolicyPeer{{NamespaceSelector: &k8smeta.LabelSelector{}}}},
+		{Ports: []k8snetworking.NetworkPolicyPort{networkPolicyPort(53, "UDP"), networkPolicyPort(53, "TCP")}},
+	}
+	for _, service := range manifest.ExternalServices {
+		egress = append(egress, k8snetworking.NetworkPolicyEgressRule{
+			To:    []k8snetworking.NetworkPolicyPeer{{IPBlock: &k8snetworking.IPBlock{CIDR: externalServiceCidr(service.Host)}}},
+			Ports: []k8snetworking.NetworkPolicyPort{networkPolicyPort(service.Port, externalServiceProtocol(service))},
+		})
+	}
+	for _, endpoint := range resourceEndpoints(naisResources) {
+		egress = append(egress, k8snetworking.NetworkPolicyEgressRule{
+			To:    []k8snetworking.NetworkPolicyPeer{{IPBlock: &k8snetworking.IPBlock{CIDR: externalServiceCidr(endpoint.Host)}}},
+			Ports: []k8snetworking.NetworkPolicyPort{networkPolicyPort(endpoint.Port, DefaultExternalServiceProtocol)},
+		})
+	}
+
+	policy.Spec = k8snetworking.NetworkPolicySpec{
+		PodSelector: k8smeta.LabelSelector{MatchLabels: map[string]string{"app": deploymentRequest.Application}},
+		PolicyTypes: []k8snetworking.PolicyType{k8snetworking.PolicyTypeEgress},
+		Egress:      egress,
+	}
+
+	return policy
+}
+
+func getExistingNetworkPolicy(application, namespace string, k8sClient kubernetes.Interface) (*k8snetworking.NetworkPolicy, error) {
+	policy, err := k8sClient.NetworkingV1().NetworkPolicies(namespace).Get(application, k8smeta.GetOptions{})
+
+	switch {
+	case err == nil:
+		return policy, err
+	case errors.IsNotFound(err):
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected error: %s", err)
+	}
+}
+
+func createOrUpdateNetworkPolicy(deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource, k8sClient kubernetes.Interface) (*k8snetworking.NetworkPolicy, error) {
+	existing, err := getExistingNetworkPolicy(deploymentRequest.Application, deploymentRequest.Namespace, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get existing network policy: %s", err)
+	}
+
+	policy := createNetworkPolicyDef(deploymentRequest, manifest, naisResources, existing)
+	if existing != nil {
+		return k8sClient.NetworkingV1().NetworkPolicies(deploymentRequest.Namespace).Update(policy)
+	}
+	return k8sClient.NetworkingV1().NetworkPolicies(deploymentRequest.Namespace).Create(policy)
+}
+
+func egressRequests(records []DeploymentRecord, egressIps map[string][]string) []EgressRequest {
+	requests := []EgressRequest{}
+	for _, record := range records {
+		sourceIps := egressIps[record.Zone]
+		if sourceIps == nil {
+			sourceIps = []string{}
+		}
+		for _, service := range record.ExternalServices {
+			requests = append(requests, EgressRequest{
+				Application: record.Application,
+				Namespace:   record.Namespace,
+				Environment: record.Environment,
+				Zone:        record.Zone,
+				Cluster:     record.Cluster,
+				SourceIps:   sourceIps,
+				Host:        service.Host,
+				Port:        service.Port,
+				Protocol:    externalServiceProtocol(service),
+			})
+		}
+		for _, endpoint := range record.ResourceEndpoints {
+			requests = append(requests, EgressRequest{
+				Application: record.Application,
+				Namespace:   record.Namespace,
+				Environment: record.Environment,
+				Zone:        record.Zone,
+				Cluster:     record.Cluster,
+				SourceIps:   sourceIps,
+				Host:        endpoint.Host,
+				Port:        endpoint.Port,
+				Protocol:    DefaultExternalServiceProtocol,
+				Resource:    endpoint.Alias,
+			})
+		}
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		if requests[i].Namespace != requests[j].Namespace {
+			return requests[i].Namespace < requests[j].Namespace
+		}
+		return requests[i].Application < requests[j].Application
+	})
+
+	return requests
+}
+
+func (api Api) egressReport(w http.ResponseWriter, r *http.Request) *appError {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(egressRequests(api.DeploymentHistory.Latest(), api.Egress.Ips)); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
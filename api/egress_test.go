@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8snetworking "k8s.io/api/networking/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateExternalServices(t *testing.T) {
+	assert.Nil(t, validateExternalServices(NaisManifest{ExternalServices: []ExternalService{
+		{Host: "legacy.adeo.no", Port: 443},
+		{Host: "10.0.0.0/24", Port: 1521, Protocol: "TCP"},
+	}}))
+
+	err := validateExternalServices(NaisManifest{ExternalServices: []ExternalService{{Port: 443}}})
+	assert.Equal(t, "ExternalServices.Host must be set", err.ErrorMessage)
+
+	err = validateExternalServices(NaisManifest{ExternalServices: []ExternalService{{Host: "legacy.adeo.no", Port: 70000}}})
+	assert.Equal(t, "70000", err.Fields["Port"])
+
+	err = validateExternalServices(NaisManifest{ExternalServices: []ExternalService{{Host: "legacy.adeo.no", Port: 443, Protocol: "ICMP"}}})
+	assert.Equal(t, "ICMP", err.Fields["Protocol"])
+}
+
+func TestExternalServiceCidr(t *testing.T) {
+	assert.Equal(t, "10.0.0.0/24", externalServiceCidr("10.0.0.0/24"))
+	assert.Equal(t, "10.0.0.1/32", externalServiceCidr("10.0.0.1"))
+	assert.Equal(t, "::1/128", externalServiceCidr("::1"))
+	assert.Equal(t, "0.0.0.0/0", externalServiceCidr("legacy.adeo.no"))
+}
+
+func TestCreateOrUpdateNetworkPolicy(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: appName, Namespace: namespace}
+	manifest := NaisManifest{Team: teamName, ExternalServices: []ExternalService{{Host: "10.0.0.1", Port: 1521}}}
+	clientset := fake.NewSimpleClientset()
+
+	t.Run("Network policy allows cluster, DNS and external services", func(t *testing.T) {
+		policy, err := createOrUpdateNetworkPolicy(deploymentRequest, manifest, nil, clientset)
+		assert.NoError(t, err)
+
+		assert.Equal(t, appName, policy.Spec.PodSelector.MatchLabels["app"])
+		assert.Equal(t, []k8snetworking.PolicyType{k8snetworking.PolicyTypeEgress}, policy.Spec.PolicyTypes)
+		assert.Equal(t, 3, len(policy.Spec.Egress))
+		assert.NotNil(t, policy.Spec.Egress[0].To[0].NamespaceSelector)
+		assert.Equal(t, int32(53), policy.Spec.Egress[1].Ports[0].Port.IntVal)
+		assert.Equal(t, "10.0.0.1/32", policy.Spec.Egress[2].To[0].IPBlock.CIDR)
+		assert.Equal(t, int32(1521), policy.Spec.Egress[2].Ports[0].Port.IntVal)
+	})
+
+	t.Run("Existing network policy is updated", func(t *testing.T) {
+		manifest.ExternalServices = append(manifest.ExternalServices, ExternalService{Host: "legacy.adeo.no", Port: 443})
+
+		_, err := createOrUpdateNetworkPolicy(deploymentRequest, manifest, nil, clientset)
+		assert.NoError(t, err)
+
+		policy, _ := clientset.NetworkingV1().NetworkPolicies(namespace).Get(appName, k8smeta.GetOptions{})
+		assert.Equal(t, 4, len(policy.Spec.Egress))
+		assert.Equal(t, "0.0.0.0/0", policy.Spec.Egress[3].To[0].IPBlock.CIDR)
+	})
+
+	t.Run("Network policy allows the endpoints of used Fasit resources", func(t *testing.T) {
+		resources := []NaisResource{
+			{name: "mydb", resourceType: "DataSource", properties: map[string]string{"url": "jdbc:oracle:thin:@//10.0.0.2:1521/db"}},
+			{name: "mycred", resourceType: "Credential", properties: map[string]string{"username": "user"}},
+		}
+
+		policy, err := createOrUpdateNetworkPolicy(deploymentRequest, NaisManifest{Team: teamName}, resources, clientset)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 3, len(policy.Spec.Egress))
+		assert.Equal(t, "10.0.0.2/32", policy.Spec.Egress[2].To[0].IPBlock.CIDR)
+		assert.Equal(t, int32(1521), policy.Spec.Egress[2].Ports[0].Port.IntVal)
+	})
+
+	t.Run("Network policy is deleted with the application", func(t *testing.T) {
+		result, err := deleteNetworkPolicy(namespace, appName, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, "network policy: OK", result)
+
+		policy, _ := getExistingNetworkPolicy(appName, namespace, clientset)
+		assert.Nil(t, policy)
+	})
+}
+
+func TestEgressReport(t *testing.T) {
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Application: "app", Namespace: "default", Zone: "fss", Cluster: "preprod-fss", ExternalServices: []ExternalService{{Host: "legacy.adeo.no", Port: 443}}})
+	history.Add(DeploymentRecord{Application: "other", Namespace: "default", Zone: "sbs"})
+	api := Api{DeploymentHistory: history, Egress: EgressConfig{Ips: map[string][]string{"fss": {"10.1.0.1", "10.1.0.2"}}}}
+
+	req, _ := http.NewRequest("GET", "/report/egress", nil)
+	rr := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+
+	var report []EgressRequest
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, []EgressRequest{{
+		Application: "app",
+		Namespace:   "default",
+		Zone:        "fss",
+		Cluster:     "preprod-fss",
+		SourceIps:   []string{"10.1.0.1", "10.1.0.2"},
+		Host:        "legacy.adeo.no",
+		Port:        443,
+		Protocol:    "TCP",
+	}}, report)
+}
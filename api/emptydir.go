@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"path"
+
+	k8score "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// EmptyDir is a writable directory the application gets at Path, e.g. /tmp, emptied whenever the pod is replaced.
+// It lets an application with a read-only root file system write temporary files and caches. A Memory backed
+// directory is a tmpfs, and what is written to it counts against the memory limit of the container.
+type EmptyDir struct {
+	Path      string
+	SizeLimit string `yaml:"sizeLimit"`
+	Memory    bool
+}
+
+// EmptyDirLimits caps the size of the emptyDirs applications in the cluster may have. An emptyDir with no size limit
+// gets the cap as its limit.
+type EmptyDirLimits struct {
+	MaxSize       string `yaml:"maxSize"`
+	MaxMemorySize string `yaml:"maxMemorySize"`
+}
+
+func validateEmptyDirLimits(limits EmptyDirLimits) error {
+	for name, limit := range map[string]string{"maxSize": limits.MaxSize, "maxMemorySize": limits.MaxMemorySize} {
+		if len(limit) == 0 {
+			continue
+		}
+		if quantity, err := k8sresource.ParseQuantity(limit); err != nil || quantity.Sign() <= 0 {
+			return fmt.Errorf("emptyDirLimits.%s must be a positive quantity, e.g. 1Gi, not %q", name, limit)
+		}
+	}
+	return nil
+}
+
+func validateEmptyDirs(manifest NaisManifest) *ValidationError {
+	paths := make(map[string]bool)
+	for _, emptyDir := range manifest.EmptyDirs {
+		if !path.IsAbs(emptyDir.Path) || path.Clean(emptyDir.Path) == "/" {
+			return &ValidationError{
+				"EmptyDir path must be an absolute path below /",
+				map[string]string{"EmptyDirs.Path": emptyDir.Path},
+			}
+		}
+		if paths[path.Clean(emptyDir.Path)] {
+			return &ValidationError{
+				"EmptyDir paths must be unique",
+				map[string]string{"EmptyDirs.Path": emptyDir.Path},
+			}
+		}
+		paths[path.Clean(emptyDir.Path)] = true
+
+		if len(emptyDir.SizeLimit) == 0 {
+			continue
+		}
+		if quantity, err := k8sresource.ParseQuantity(emptyDir.SizeLimit); err != nil || quantity.Sign() <= 0 {
+			return &ValidationError{
+				"EmptyDir sizeLimit must be a positive quantity, e.g. 512Mi",
+				map[string]string{"EmptyDirs.Path": emptyDir.Path, "EmptyDirs.SizeLimit": emptyDir.SizeLimit},
+			}
+		}
+	}
+	return nil
+}
+
+// applyEmptyDirLimits gives the emptyDirs of the manifest with no size limit the cap of the cluster, and fails if an
+// emptyDir asks for more than the cap
+func applyEmptyDirLimits(manifest *NaisManifest, limits EmptyDirLimits) error {
+	for i, emptyDir := range manifest.EmptyDirs {
+		max := limits.MaxSize
+		if emptyDir.Memory {
+			max = limits.MaxMemorySize
+		}
+		if len(max) == 0 {
+			continue
+		}
+		if len(emptyDir.SizeLimit) == 0 {
+			manifest.EmptyDirs[i].SizeLimit = max
+			continue
+		}
+
+		size, maxSize := k8sresource.MustParse(emptyDir.SizeLimit), k8sresource.MustParse(max)
+		if size.Cmp(maxSize) > 0 {
+			return fmt.Errorf("emptyDir %s has a size limit of %s, but at most %s is permitted", emptyDir.Path, emptyDir.SizeLimit, max)
+		}
+	}
+	return nil
+}
+
+func emptyDirVolumeName(index int) string {
+	return fmt.Sprintf("emptydir-%d", index)
+}
+
+func createEmptyDirVolumes(emptyDirs []EmptyDir) []k8score.Volume {
+	var volumes []k8score.Volume
+	for i, emptyDir := range emptyDirs {
+		source := &k8score.EmptyDirVolumeSource{}
+		if emptyDir.Memory {
+			source.Medium = k8score.StorageMediumMemory
+		}
+		if len(emptyDir.SizeLimit) > 0 {
+			sizeLimit := k8sresource.MustParse(emptyDir.SizeLimit)
+			source.SizeLimit = &sizeLimit
+		}
+		volumes = append(volumes, k8score.Volume{
+			Name:         emptyDirVolumeName(i),
+			VolumeSource: k8score.VolumeSource{EmptyDir: source},
+		})
+	}
+	return volumes
+}
+
+func createEmptyDirVolumeMounts(emptyDirs []EmptyDir) []k8score.VolumeMount {
+	var mounts []k8score.VolumeMount
+	for i, emptyDir := range emptyDirs {
+		mounts = append(mounts, k8score.VolumeMount{
+			Name:      emptyDirVolumeName(i),
+			MountPath: path.Clean(emptyDir.Path),
+		})
+	}
+	return mounts
+}
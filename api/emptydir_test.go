@@ -0,0 +1,66 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateEmptyDirs(t *testing.T) {
+	emptyDirs := func(emptyDirs ...EmptyDir) NaisManifest {
+		return NaisManifest{EmptyDirs: emptyDirs}
+	}
+
+	assert.Nil(t, validateEmptyDirs(NaisManifest{}))
+	assert.Nil(t, validateEmptyDirs(emptyDirs(EmptyDir{Path: "/tmp"}, EmptyDir{Path: "/var/cache/app", SizeLimit: "512Mi", Memory: true})))
+	assert.NotNil(t, validateEmptyDirs(emptyDirs(EmptyDir{Path: "tmp"})))
+	assert.NotNil(t, validateEmptyDirs(emptyDirs(EmptyDir{Path: "/"})))
+	assert.NotNil(t, validateEmptyDirs(emptyDirs(EmptyDir{Path: "/tmp"}, EmptyDir{Path: "/tmp/"})))
+	assert.NotNil(t, validateEmptyDirs(emptyDirs(EmptyDir{Path: "/tmp", SizeLimit: "lots"})))
+	assert.NotNil(t, validateEmptyDirs(emptyDirs(EmptyDir{Path: "/tmp", SizeLimit: "0"})))
+}
+
+func TestApplyEmptyDirLimits(t *testing.T) {
+	limits := EmptyDirLimits{MaxSize: "1Gi", MaxMemorySize: "256Mi"}
+
+	t.Run("Emptydirs without a size limit get the cap", func(t *testing.T) {
+		manifest := NaisManifest{EmptyDirs: []EmptyDir{{Path: "/tmp"}, {Path: "/cache", Memory: true}}}
+		assert.NoError(t, applyEmptyDirLimits(&manifest, limits))
+		assert.Equal(t, "1Gi", manifest.EmptyDirs[0].SizeLimit)
+		assert.Equal(t, "256Mi", manifest.EmptyDirs[1].SizeLimit)
+	})
+
+	t.Run("Emptydirs larger than the cap are rejected", func(t *testing.T) {
+		assert.NoError(t, applyEmptyDirLimits(&NaisManifest{EmptyDirs: []EmptyDir{{Path: "/tmp", SizeLimit: "1024Mi"}}}, limits))
+		assert.Error(t, applyEmptyDirLimits(&NaisManifest{EmptyDirs: []EmptyDir{{Path: "/tmp", SizeLimit: "2Gi"}}}, limits))
+		assert.Error(t, applyEmptyDirLimits(&NaisManifest{EmptyDirs: []EmptyDir{{Path: "/tmp", SizeLimit: "512Mi", Memory: true}}}, limits))
+	})
+
+	t.Run("Emptydirs are left alone without caps", func(t *testing.T) {
+		manifest := NaisManifest{EmptyDirs: []EmptyDir{{Path: "/tmp"}}}
+		assert.NoError(t, applyEmptyDirLimits(&manifest, EmptyDirLimits{}))
+		assert.Empty(t, manifest.EmptyDirs[0].SizeLimit)
+	})
+
+	assert.NoError(t, validateEmptyDirLimits(limits))
+	assert.Error(t, validateEmptyDirLimits(EmptyDirLimits{MaxSize: "-1Gi"}))
+}
+
+func TestEmptyDirVolumes(t *testing.T) {
+	manifest := newDefaultManifest()
+	manifest.EmptyDirs = []EmptyDir{{Path: "/tmp"}, {Path: "/var/cache/app/", SizeLimit: "64Mi", Memory: true}}
+
+	deployment, err := createOrUpdateDeployment(naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}, manifest, []NaisResource{}, false, fake.NewSimpleClientset())
+	assert.NoError(t, err)
+
+	sizeLimit := k8sresource.MustParse("64Mi")
+	podSpec := deployment.Spec.Template.Spec
+	assert.Contains(t, podSpec.Volumes, k8score.Volume{Name: "emptydir-0", VolumeSource: k8score.VolumeSource{EmptyDir: &k8score.EmptyDirVolumeSource{}}})
+	assert.Contains(t, podSpec.Volumes, k8score.Volume{Name: "emptydir-1", VolumeSource: k8score.VolumeSource{EmptyDir: &k8score.EmptyDirVolumeSource{Medium: k8score.StorageMediumMemory, SizeLimit: &sizeLimit}}})
+	assert.Contains(t, podSpec.Containers[0].VolumeMounts, k8score.VolumeMount{Name: "emptydir-0", MountPath: "/tmp"})
+	assert.Contains(t, podSpec.Containers[0].VolumeMounts, k8score.VolumeMount{Name: "emptydir-1", MountPath: "/var/cache/app"})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultBackendAnnotation   = "nginx.ingress.kubernetes.io/default-backend"
+	CustomHttpErrorsAnnotation = "nginx.ingress.kubernetes.io/custom-http-errors"
+)
+
+// The responses the error backend replaces when the manifest does not list any
+var defaultErrorBackendCodes = []int{404, 503}
+
+// ErrorBackend is a service in the application's namespace serving the error pages of its ingress. Responses with one
+// of the status codes, and requests made while the application has no ready pods, are answered by it instead.
+type ErrorBackend struct {
+	Service string
+	Codes   []int
+}
+
+func errorBackendCodes(backend ErrorBackend) []int {
+	if len(backend.Codes) == 0 {
+		return defaultErrorBackendCodes
+	}
+	return backend.Codes
+}
+
+func validateErrorBackend(manifest NaisManifest) *ValidationError {
+	backend := manifest.Ingress.ErrorBackend
+
+	if len(backend.Service) == 0 {
+		if len(backend.Codes) > 0 {
+			return &ValidationError{
+				"Ingress.ErrorBackend.Codes can only be set along with Ingress.ErrorBackend.Service",
+				map[string]string{"Ingress.ErrorBackend.Service": ""},
+			}
+		}
+		return nil
+	}
+
+	if errs := validation.IsDNS1123Label(backend.Service); len(errs) > 0 {
+		return &ValidationError{
+			"Ingress.ErrorBackend.Service must be the name of a service: " + strings.Join(errs, ", "),
+			map[string]string{"Ingress.ErrorBackend.Service": backend.Service},
+		}
+	}
+
+	for _, code := range backend.Codes {
+		if code < 400 || code > 599 {
+			return &ValidationError{
+				"Ingress.ErrorBackend.Codes must be HTTP error status codes, between 400 and 599",
+				map[string]string{"Ingress.ErrorBackend.Codes": strconv.Itoa(code)},
+			}
+		}
+	}
+
+	return nil
+}
+
+func errorBackendAnnotations(backend ErrorBackend) map[string]string {
+	if len(backend.Service) == 0 {
+		return nil
+	}
+
+	var codes []string
+	for _, code := range errorBackendCodes(backend) {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return map[string]string{
+		DefaultBackendAnnotation:   backend.Service,
+		CustomHttpErrorsAnnotation: strings.Join(codes, ","),
+	}
+}
+
+// checkErrorBackend fails if the error backend of the manifest is not a service in the namespace, as the ingress
+// controller would send the error pages nowhere
+func checkErrorBackend(namespace string, manifest NaisManifest, k8sClient kubernetes.Interface) error {
+	backend := manifest.Ingress.ErrorBackend
+	if len(backend.Service) == 0 || manifest.Ingress.Disabled {
+		return nil
+	}
+
+	service, err := getExistingService(backend.Service, namespace, k8sClient)
+	if err != nil {
+		return fmt.Errorf("unable to get error backend %s: %s", backend.Service, err)
+	}
+	if service == nil {
+		return fmt.Errorf("error backend %s is not a service in namespace %s", backend.Service, namespace)
+	}
+	return nil
+}
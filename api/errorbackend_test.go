@@ -0,0 +1,47 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateErrorBackend(t *testing.T) {
+	backend := func(backend ErrorBackend) NaisManifest {
+		return NaisManifest{Ingress: Ingress{ErrorBackend: backend}}
+	}
+
+	assert.Nil(t, validateErrorBackend(NaisManifest{}))
+	assert.Nil(t, validateErrorBackend(backend(ErrorBackend{Service: "errorpages"})))
+	assert.Nil(t, validateErrorBackend(backend(ErrorBackend{Service: "errorpages", Codes: []int{404, 500, 502, 503}})))
+	assert.NotNil(t, validateErrorBackend(backend(ErrorBackend{Service: "Error_Pages"})))
+	assert.NotNil(t, validateErrorBackend(backend(ErrorBackend{Service: "errorpages", Codes: []int{200}})))
+	assert.NotNil(t, validateErrorBackend(backend(ErrorBackend{Codes: []int{404}})))
+	assert.NotNil(t, validateIngressControls(NaisManifest{Ingress: Ingress{Disabled: true, ErrorBackend: ErrorBackend{Service: "errorpages"}}}))
+}
+
+func TestErrorBackendAnnotations(t *testing.T) {
+	ingress := &k8sextensions.Ingress{}
+	applyIngressAnnotations(ingress, NaisManifest{Ingress: Ingress{ErrorBackend: ErrorBackend{Service: "errorpages"}}})
+	assert.Equal(t, map[string]string{DefaultBackendAnnotation: "errorpages", CustomHttpErrorsAnnotation: "404,503"}, ingress.Annotations)
+
+	applyIngressAnnotations(ingress, NaisManifest{Ingress: Ingress{ErrorBackend: ErrorBackend{Service: "errorpages", Codes: []int{500, 502}}}})
+	assert.Equal(t, "500,502", ingress.Annotations[CustomHttpErrorsAnnotation])
+
+	applyIngressAnnotations(ingress, NaisManifest{})
+	assert.Nil(t, ingress.Annotations)
+}
+
+func TestCheckErrorBackend(t *testing.T) {
+	clientset := fake.NewSimpleClientset(createServiceDef("errorpages", namespace, teamName))
+	withBackend := func(service string) NaisManifest {
+		return NaisManifest{Ingress: Ingress{ErrorBackend: ErrorBackend{Service: service}}}
+	}
+
+	assert.NoError(t, checkErrorBackend(namespace, NaisManifest{}, clientset))
+	assert.NoError(t, checkErrorBackend(namespace, withBackend("errorpages"), clientset))
+	assert.EqualError(t, checkErrorBackend(namespace, withBackend("missing"), clientset), "error backend missing is not a service in namespace "+namespace)
+	assert.Error(t, checkErrorBackend("other", withBackend("errorpages"), clientset))
+}
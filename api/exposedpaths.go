@@ -0,0 +1,86 @@
+package api
+
+import (
+	"strings"
+)
+
+// exposedPathAlias is the alias of the resource exposing one of the paths of a resource. The path, without slashes at
+// either end and with the rest of them replaced by dashes, is added to the alias: /api/v2 of myapi is myapi-api-v2,
+// while / is myapi itself.
+func exposedPathAlias(alias, path string) string {
+	suffix := strings.Replace(strings.Trim(path, "/"), "/", "-", -1)
+	if len(suffix) == 0 {
+		return alias
+	}
+	return alias + "-" + suffix
+}
+
+func validateExposedPaths(manifest NaisManifest) *ValidationError {
+	aliases := make(map[string]bool)
+	for _, resource := range manifest.FasitResources.Exposed {
+		if len(resource.Paths) == 0 {
+			aliases[resource.Alias] = true
+		}
+	}
+
+	for _, resource := range manifest.FasitResources.Exposed {
+		if len(resource.Paths) == 0 {
+			continue
+		}
+
+		if len(resource.Path) > 0 {
+			return &ValidationError{
+				"Path and Paths can not both be set on an exposed resource",
+				map[string]string{"Alias": resource.Alias},
+			}
+		}
+
+		if !strings.EqualFold("restservice", resource.ResourceType) {
+			return &ValidationError{
+				"Paths is only supported for exposed resources of type RestService",
+				map[string]string{"Alias": resource.Alias, "ResourceType": resource.ResourceType},
+			}
+		}
+
+		for _, path := range resource.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return &ValidationError{
+					"Paths must start with /",
+					map[string]string{"Alias": resource.Alias, "Path": path},
+				}
+			}
+
+			alias := exposedPathAlias(resource.Alias, path)
+			if aliases[alias] {
+				return &ValidationError{
+					"Paths gives an alias that is already exposed",
+					map[string]string{"Alias": alias, "Path": path},
+				}
+			}
+			aliases[alias] = true
+		}
+	}
+
+	return nil
+}
+
+// expandExposedPaths replaces each exposed resource with paths by a resource per path, which is how API gateways
+// find every context root of an application
+func expandExposedPaths(manifest *NaisManifest) {
+	var exposed []ExposedResource
+	for _, resource := range manifest.FasitResources.Exposed {
+		if len(resource.Paths) == 0 {
+			exposed = append(exposed, resource)
+			continue
+		}
+
+		for _, path := range resource.Paths {
+			expanded := resource
+			expanded.Alias = exposedPathAlias(resource.Alias, path)
+			expanded.Path = path
+			expanded.Paths = nil
+			exposed = append(exposed, expanded)
+		}
+	}
+	manifest.FasitResources.Exposed = exposed
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestExposedPathAlias(t *testing.T) {
+	assert.Equal(t, "myapi", exposedPathAlias("myapi", "/"))
+	assert.Equal(t, "myapi-api", exposedPathAlias("myapi", "/api/"))
+	assert.Equal(t, "myapi-internal-api-v2", exposedPathAlias("myapi", "/internal/api/v2"))
+}
+
+func TestValidateExposedPaths(t *testing.T) {
+	exposed := func(resources ...ExposedResource) NaisManifest {
+		return NaisManifest{FasitResources: FasitResources{Exposed: resources}}
+	}
+
+	assert.Nil(t, validateExposedPaths(exposed(ExposedResource{Alias: "myapi", ResourceType: "RestService", Paths: []string{"/api", "/internal"}})))
+	assert.NotNil(t, validateExposedPaths(exposed(ExposedResource{Alias: "myapi", ResourceType: "RestService", Path: "/api", Paths: []string{"/internal"}})))
+	assert.NotNil(t, validateExposedPaths(exposed(ExposedResource{Alias: "myws", ResourceType: "WebserviceEndpoint", Paths: []string{"/ws"}})))
+	assert.NotNil(t, validateExposedPaths(exposed(ExposedResource{Alias: "myapi", ResourceType: "RestService", Paths: []string{"api"}})))
+	assert.NotNil(t, validateExposedPaths(exposed(ExposedResource{Alias: "myapi", ResourceType: "RestService", Paths: []string{"/api", "/api/"}})))
+	assert.NotNil(t, validateExposedPaths(exposed(
+		ExposedResource{Alias: "myapi-internal", ResourceType: "RestService", Path: "/other"},
+		ExposedResource{Alias: "myapi", ResourceType: "RestService", Paths: []string{"/internal"}},
+	)))
+}
+
+func TestExpandExposedPaths(t *testing.T) {
+	manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{
+		{Alias: "myapi", ResourceType: "RestService", Paths: []string{"/", "/internal/api"}, Description: "My API"},
+		{Alias: "other", ResourceType: "RestService", Path: "/other"},
+	}}}
+
+	expandExposedPaths(&manifest)
+
+	assert.Equal(t, []ExposedResource{
+		{Alias: "myapi", ResourceType: "RestService", Path: "/", Description: "My API"},
+		{Alias: "myapi-internal-api", ResourceType: "RestService", Path: "/internal/api", Description: "My API"},
+		{Alias: "other", ResourceType: "RestService", Path: "/other"},
+	}, manifest.FasitResources.Exposed)
+}
+
+func TestGenerateManifestWithExposedPaths(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://repo.local").
+		Get("/app/nais.yaml").
+		Reply(200).
+		BodyString("fasitResources:\n  exposed:\n  - alias: app-api\n    paths: [/api, /internal]\n    template: internal-rest\n")
+
+	templates := map[string]ExposedResource{"internal-rest": {ResourceType: "RestService"}}
+	manifest, err := GenerateManifestWithProfiles(naisrequest.Deploy{Application: "app", ManifestUrl: "https://repo.local/app/nais.yaml"}, nil, templates)
+
+	assert.NoError(t, err)
+	assert.Len(t, manifest.FasitResources.Exposed, 2)
+	assert.Equal(t, "app-api-api", manifest.FasitResources.Exposed[0].Alias)
+	assert.Equal(t, "/internal", manifest.FasitResources.Exposed[1].Path)
+}
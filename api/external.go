@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const (
+	ManifestKindApplication = "application"
+	// External applications run outside the cluster. naisd only registers them and their resources in Fasit.
+	ManifestKindExternal = "external"
+)
+
+var manifestKinds = []string{ManifestKindApplication, ManifestKindExternal}
+
+func isExternal(manifest NaisManifest) bool {
+	return manifest.Kind == ManifestKindExternal
+}
+
+func validateKind(manifest NaisManifest) *ValidationError {
+	if len(manifest.Kind) > 0 && !contains(manifestKinds, manifest.Kind) {
+		return &ValidationError{
+			"Kind must be one of " + strings.Join(manifestKinds, ", "),
+			map[string]string{"Kind": manifest.Kind},
+		}
+	}
+
+	if isExternal(manifest) && len(manifest.FasitResources.Exposed) > 0 && len(manifest.Hostname) == 0 {
+		return &ValidationError{
+			"Hostname must be set for external applications exposing resources",
+			map[string]string{"Kind": manifest.Kind},
+		}
+	}
+
+	return nil
+}
+
+// Rejects deployment options that only make sense for applications running in the cluster
+func checkExternalDeployment(deploymentRequest naisrequest.Deploy) error {
+	switch {
+	case deploymentRequest.SkipFasit:
+		return fmt.Errorf("external applications are only registered in Fasit, so skipFasit leaves nothing to do")
+	case len(deploymentRequest.FasitEnvironment) == 0:
+		return fmt.Errorf("external applications are registered in a Fasit environment, but none was given")
+	case deploymentRequest.Preview != nil:
+		return fmt.Errorf("external applications can not be previewed")
+	case deploymentRequest.Mirror != nil:
+		return fmt.Errorf("external applications can not be mirrored")
+	case deploymentRequest.DarkLaunch != nil:
+		return fmt.Errorf("external applications can not be dark launched")
+	case deploymentRequest.WaitForRollout:
+		return fmt.Errorf("external applications are not rolled out by naisd")
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	"gopkg.in/yaml.v2"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateKind(t *testing.T) {
+	assert.Nil(t, validateKind(NaisManifest{}))
+	assert.Nil(t, validateKind(NaisManifest{Kind: ManifestKindApplication}))
+	assert.Nil(t, validateKind(NaisManifest{Kind: ManifestKindExternal}))
+	assert.NotNil(t, validateKind(NaisManifest{Kind: "job"}))
+
+	exposing := NaisManifest{Kind: ManifestKindExternal, FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "api", ResourceType: "RestService"}}}}
+	assert.NotNil(t, validateKind(exposing))
+	exposing.Hostname = "legacy.adeo.no"
+	assert.Nil(t, validateKind(exposing))
+}
+
+func TestCheckExternalDeployment(t *testing.T) {
+	valid := naisrequest.Deploy{FasitEnvironment: "t1"}
+	assert.NoError(t, checkExternalDeployment(valid))
+
+	for _, invalid := range []naisrequest.Deploy{
+		{},
+		{FasitEnvironment: "t1", SkipFasit: true},
+		{FasitEnvironment: "t1", Preview: &naisrequest.Preview{}},
+		{FasitEnvironment: "t1", Mirror: &naisrequest.Mirror{}},
+		{FasitEnvironment: "t1", WaitForRollout: true},
+	} {
+		assert.Error(t, checkExternalDeployment(invalid))
+	}
+}
+
+func TestDeployExternalApplication(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	api := Api{Clientset: clientset, FasitUrl: "https://fasit.local", ClusterSubdomain: "nais.example.tk", ClusterName: "test-cluster"}
+
+	manifest := NaisManifest{Kind: ManifestKindExternal, Hostname: "legacy.adeo.no", Healthcheck: Healthcheck{Selftest: "selftest"}}
+	data, _ := yaml.Marshal(manifest)
+
+	defer gock.Off()
+	gock.New("http://repo.com").
+		Get("/app").
+		Reply(200).
+		BodyString(string(data))
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", NavTruststoreFasitAlias).
+		Reply(200).File("testdata/fasitTruststoreResponse.json")
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/resources/3024713/file/keystore").
+		Reply(200).
+		BodyString("")
+
+	gock.New("https://fasit.local").
+		Post("/api/v2/applicationinstances/").
+		BodyString(`"selftest":"https://legacy.adeo.no/selftest"`).
+		Reply(200).
+		BodyString("anything")
+
+	jsn, _ := json.Marshal(naisrequest.Deploy{
+		Application:      "legacy",
+		Version:          "1",
+		FasitEnvironment: "t1",
+		ManifestUrl:      "http://repo.com/app",
+		Zone:             "fss",
+		Namespace:        "default",
+	})
+	req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(string(jsn)))
+	rr := httptest.NewRecorder()
+	appHandler(api.deploy).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone())
+	assert.Contains(t, rr.Body.String(), "- external application, registered in Fasit only\n")
+	assert.NotContains(t, rr.Body.String(), "created deployment")
+
+	deployments, err := clientset.ExtensionsV1beta1().Deployments("default").List(k8smeta.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, deployments.Items)
+}
@@ -2,14 +2,16 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Jeffail/gabs"
 	"github.com/golang/glog"
@@ -18,23 +20,21 @@ import (
 	"regexp"
 )
 
-func init() {
-	prometheus.MustRegister(httpReqsCounter)
-}
-
 type ResourcePayload interface{}
 
 type RestResourcePayload struct {
-	Alias      string         `json:"alias"`
-	Scope      Scope          `json:"scope"`
-	Type       string         `json:"type"`
-	Properties RestProperties `json:"properties"`
+	Alias      string            `json:"alias"`
+	Scope      Scope             `json:"scope"`
+	Type       string            `json:"type"`
+	Properties RestProperties    `json:"properties"`
+	Metadata   *ResourceMetadata `json:"metadata,omitempty"`
 }
 type WebserviceResourcePayload struct {
 	Alias      string               `json:"alias"`
 	Scope      Scope                `json:"scope"`
 	Type       string               `json:"type"`
 	Properties WebserviceProperties `json:"properties"`
+	Metadata   *ResourceMetadata    `json:"metadata,omitempty"`
 }
 type WebserviceProperties struct {
 	EndpointUrl   string `json:"endpointUrl"`
@@ -50,10 +50,12 @@ type Scope struct {
 	EnvironmentClass string `json:"environmentclass"`
 	Environment      string `json:"environment,omitempty"`
 	Zone             string `json:"zone,omitempty"`
+	Application      string `json:"application,omitempty"`
 }
 
 type Password struct {
-	Ref string `json:"ref"`
+	Ref   string `json:"ref,omitempty"`
+	Value string `json:"value,omitempty"`
 }
 type ApplicationInstancePayload struct {
 	Application      string     `json:"application"`
@@ -63,26 +65,51 @@ type ApplicationInstancePayload struct {
 	UsedResources    []Resource `json:"usedresources"`
 	ClusterName      string     `json:"clustername"`
 	Domain           string     `json:"domain"`
+	HealthCheckUrls
 }
 
 type Resource struct {
 	Id int `json:"id"`
 }
 
+type DeploymentEventPayload struct {
+	Application string `json:"application"`
+	Version     string `json:"version"`
+	Environment string `json:"environment"`
+	Zone        string `json:"zone"`
+	Namespace   string `json:"namespace"`
+	ClusterName string `json:"clustername"`
+	DeployedBy  string `json:"deployedby"`
+	Timestamp   string `json:"timestamp"`
+}
+
 type FasitClient struct {
 	FasitUrl string
 	Username string
 	Password string
+	// Retry is how requests that only read from Fasit are retried. The zero policy sends every request once.
+	Retry RetryPolicy
+	// Transport sends the requests, made with NewFasitTransport. Without it they are sent with http.DefaultTransport.
+	Transport http.RoundTripper
+	// Requests are cancelled along with the context, and retried only as long as the deployment it carries allows
+	ctx context.Context
 }
+
+// FasitClientAdapter is everything naisd asks of Fasit. FasitClient talks to Fasit itself, and fasitfake.Client is an
+// in-memory Fasit for testing code that uses naisd.
 type FasitClientAdapter interface {
-	getScopedResource(resourcesRequest ResourceRequest, environment, application, zone string) (NaisResource, AppError)
-	createResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, deploymentRequest naisrequest.Deploy) (int, error)
-	updateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, deploymentRequest naisrequest.Deploy) (int, error)
+	GetScopedResource(resourcesRequest ResourceRequest, environment, application, zone string) (NaisResource, AppError)
+	CreateResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error)
+	UpdateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error)
 	GetFasitEnvironmentClass(environmentName string) (string, error)
 	GetFasitApplication(application string) error
 	GetScopedResources(resourcesRequests []ResourceRequest, environment string, application string, zone string) (resources []NaisResource, err error)
-	getLoadBalancerConfig(application string, environment string) (*NaisResource, error)
-	createApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int) error
+	FindResourceAliases(prefix, resourceType, environment, application, zone string) ([]string, error)
+	GetLoadBalancerConfig(application string, environment string) (*NaisResource, error)
+	CreateApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks HealthCheckUrls, instance ApplicationInstanceConfig) error
+	CreateDeploymentEvent(deploymentRequest naisrequest.Deploy, clusterName string) error
+	GetApplicationInstance(application, environment string) (*ApplicationInstance, error)
+	StopApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment string, instance ApplicationInstance) error
 }
 
 type FasitResource struct {
@@ -93,12 +120,14 @@ type FasitResource struct {
 	Properties   map[string]string
 	Secrets      map[string]map[string]string
 	Certificates map[string]interface{} `json:"files"`
+	Metadata     ResourceMetadata       `json:"metadata"`
 }
 
 type ResourceRequest struct {
 	Alias        string
 	ResourceType string
 	PropertyMap  map[string]string
+	Optional     bool
 }
 
 type NaisResource struct {
@@ -109,8 +138,35 @@ type NaisResource struct {
 	properties   map[string]string
 	propertyMap  map[string]string
 	secret       map[string]string
+	secretRefs   map[string]string
 	certificates map[string][]byte
 	ingresses    map[string]string
+	metadata     ResourceMetadata
+}
+
+// NewNaisResource creates a resource as FasitClient would from the resource in Fasit, with its secrets and files already
+// resolved. It is for adapters that do not get their resources from Fasit, such as fasitfake.
+func NewNaisResource(resource FasitResource, propertyMap, secret map[string]string, certificates map[string][]byte) NaisResource {
+	return NaisResource{
+		id:           resource.Id,
+		name:         resource.Alias,
+		resourceType: resource.ResourceType,
+		scope:        resource.Scope,
+		properties:   resource.Properties,
+		propertyMap:  propertyMap,
+		secret:       secret,
+		certificates: certificates,
+		metadata:     resource.Metadata,
+	}
+}
+
+// NewLoadBalancerConfig creates the load balancer config of an application, with its ingresses as host to context root
+func NewLoadBalancerConfig(ingresses map[string]string) NaisResource {
+	return NaisResource{resourceType: "LoadBalancerConfig", ingresses: ingresses}
+}
+
+func (nr NaisResource) Id() int {
+	return nr.id
 }
 
 func (nr NaisResource) Properties() map[string]string {
@@ -160,8 +216,26 @@ func normalizePropertyName(name string) string {
 }
 
 func (fasit FasitClient) GetScopedResources(resourcesRequests []ResourceRequest, environment string, application string, zone string) (resources []NaisResource, err error) {
-	for _, request := range resourcesRequests {
-		resource, appErr := fasit.getScopedResource(request, environment, application, zone)
+	var bulk map[int]NaisResource
+	if FasitBulkLookups {
+		bulk = fasit.bulkScopedResources(resourcesRequests, environment, application, zone)
+	}
+
+	for i, request := range resourcesRequests {
+		if resource, ok := bulk[i]; ok {
+			resources = append(resources, resource)
+			continue
+		}
+
+		resource, appErr := fasit.getCachedScopedResource(request, environment, application, zone)
+		if appErr != nil && request.Optional && appErr.Code() == http.StatusNotFound {
+			// an optional resource that does not exist is neither injected nor registered as used in Fasit
+			glog.Infof("optional resource %s (%s) not found in %s, skipping it", request.Alias, request.ResourceType, environment)
+			continue
+		}
+		if fasitErr, ok := appErr.(*FasitError); ok {
+			return []NaisResource{}, fasitErr
+		}
 		if appErr != nil {
 			return []NaisResource{}, fmt.Errorf("unable to get resource %s (%s). %s", request.Alias, request.ResourceType, appErr)
 		}
@@ -170,10 +244,10 @@ func (fasit FasitClient) GetScopedResources(resourcesRequests []ResourceRequest,
 	return resources, nil
 }
 
-func (fasit FasitClient) createApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int) error {
+func (fasit FasitClient) CreateApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks HealthCheckUrls, instance ApplicationInstanceConfig) error {
 	fasitPath := fasit.FasitUrl + "/api/v2/applicationinstances/"
 
-	payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds))
+	payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds, healthChecks, instance))
 	if err != nil {
 		errorCounter.WithLabelValues("create_request").Inc()
 		return fmt.Errorf("unable to create payload (%s)", err)
@@ -181,30 +255,68 @@ func (fasit FasitClient) createApplicationInstance(deploymentRequest naisrequest
 
 	glog.Infof("ApplicationInstancePayload: %s", payload)
 	req, err := http.NewRequest("POST", fasitPath, bytes.NewBuffer(payload))
+	req.SetBasicAuth(deploymentRequest.FasitUsername, deploymentRequest.FasitPassword)
+	req.Header.Set("Content-Type", "application/json")
+	operation := fmt.Sprintf("register application instance of %s", deploymentRequest.Application)
+	setFasitAuditHeaders(req, deploymentRequest, operation)
+
+	_, appErr := fasit.doRequest(operation, req)
+	if appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// Registers a deployment in Fasit's change log so the deployment shows up in the organization's event history
+func (fasit FasitClient) CreateDeploymentEvent(deploymentRequest naisrequest.Deploy, clusterName string) error {
+	payload, err := json.Marshal(buildDeploymentEventPayload(deploymentRequest, clusterName, time.Now()))
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return fmt.Errorf("unable to create payload (%s)", err)
+	}
+
+	req, err := http.NewRequest("POST", fasit.FasitUrl+"/api/v2/events/", bytes.NewBuffer(payload))
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return fmt.Errorf("unable to create request: %s", err)
+	}
+
 	req.SetBasicAuth(deploymentRequest.FasitUsername, deploymentRequest.FasitPassword)
 	req.Header.Set("Content-Type", "application/json")
 	if deploymentRequest.OnBehalfOf != "" {
-		glog.Infof("I am setting onbehalfofheader to: %s", deploymentRequest.OnBehalfOf)
 		req.Header.Set("x-onbehalfof", deploymentRequest.OnBehalfOf)
 	}
 
-	_, appErr := fasit.doRequest(req)
+	_, appErr := fasit.doRequest("register deployment event", req)
 	if appErr != nil {
 		return appErr
 	}
 	return nil
 }
 
-func (fasit FasitClient) getLoadBalancerConfig(application string, environment string) (*NaisResource, error) {
+// Returns the version of the application registered in the Fasit environment, or an empty string if it is not registered
+// applicationInstanceVersion is the version of the application registered in the environment, empty if it has none
+func applicationInstanceVersion(fasit FasitClientAdapter, application, environment string) (string, error) {
+	instance, err := fasit.GetApplicationInstance(application, environment)
+	if err != nil || instance == nil {
+		return "", err
+	}
+	return instance.Version, nil
+}
+
+func (fasit FasitClient) GetLoadBalancerConfig(application string, environment string) (*NaisResource, error) {
 	req, err := fasit.buildRequest("GET", "/api/v2/resources", map[string]string{
 		"environment": environment,
 		"application": application,
 		"type":        "LoadBalancerConfig",
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	body, appErr := fasit.doRequest(req)
+	body, appErr := fasit.doRequest("get load balancer config", req)
 	if appErr != nil {
-		return nil, err
+		return nil, appErr
 	}
 
 	ingresses, err := parseLoadBalancerConfig(body)
@@ -216,48 +328,64 @@ func (fasit FasitClient) getLoadBalancerConfig(application string, environment s
 		return nil, nil
 	}
 
-	return &NaisResource{
-		name:         "",
-		properties:   nil,
-		resourceType: "LoadBalancerConfig",
-		certificates: nil,
-		secret:       nil,
-		ingresses:    ingresses,
-	}, nil
+	resource := NewLoadBalancerConfig(ingresses)
+	return &resource, nil
 
 }
 
-func CreateOrUpdateFasitResources(fasit FasitClientAdapter, resources []ExposedResource, hostname, fasitEnvironmentClass, fasitEnvironment string, deploymentRequest naisrequest.Deploy) ([]int, error) {
+// Creates or updates the exposed resources, returning their ids and warnings about resources naisd did not create
+func CreateOrUpdateFasitResources(fasit FasitClientAdapter, resources []ExposedResource, hostname, fasitEnvironmentClass, fasitEnvironment, team string, deploymentRequest naisrequest.Deploy) ([]int, []string, error) {
 	var exposedResourceIds []int
+	var warnings []string
+	metadata := resourceMetadata(deploymentRequest, team)
 
-	for _, resource := range resources {
+	// every resource is looked up, and the ones naisd did not create are warned about, before any of them is written
+	existingResources := make([]*NaisResource, len(resources))
+	for i, resource := range resources {
 		var request = ResourceRequest{Alias: resource.Alias, ResourceType: resource.ResourceType}
-		existingResource, appError := fasit.getScopedResource(request, fasitEnvironment, deploymentRequest.Application, deploymentRequest.Zone)
+		existingResource, appError := fasit.GetScopedResource(request, fasitEnvironment, deploymentRequest.Application, deploymentRequest.Zone)
 
 		if appError != nil {
-			if appError.Code() == 404 {
-				// Create new resource if none was found
-				createdResourceId, err := fasit.createResource(resource, fasitEnvironmentClass, fasitEnvironment, hostname, deploymentRequest)
-				if err != nil {
-					return nil, fmt.Errorf("failed creating resource: %s of type %s with path %s. (%s)", resource.Alias, resource.ResourceType, resource.Path, err)
-				}
-				exposedResourceIds = append(exposedResourceIds, createdResourceId)
-			} else {
+			if appError.Code() != 404 {
 				// Failed contacting Fasit
-				return nil, appError
+				return nil, warnings, appError
+			}
+			continue
+		}
+
+		if warning := unmanagedResourceWarning(resource, existingResource); len(warning) > 0 {
+			glog.Warning(warning)
+			warnings = append(warnings, warning)
+		}
+		existingResources[i] = &existingResource
+	}
+
+	for i, resource := range resources {
+		if existingResources[i] == nil {
+			// Create new resource if none was found
+			createdResourceId, err := fasit.CreateResource(resource, fasitEnvironmentClass, fasitEnvironment, hostname, metadata, deploymentRequest)
+			if fasitErr, ok := err.(*FasitError); ok {
+				return nil, warnings, fasitErr
+			}
+			if err != nil {
+				return nil, warnings, fmt.Errorf("failed creating resource: %s of type %s with path %s. (%s)", resource.Alias, resource.ResourceType, resource.Path, err)
 			}
+			exposedResourceIds = append(exposedResourceIds, createdResourceId)
 
 		} else {
 			// Updating Fasit resource
-			updatedResourceId, err := fasit.updateResource(existingResource, resource, fasitEnvironmentClass, fasitEnvironment, hostname, deploymentRequest)
+			updatedResourceId, err := fasit.UpdateResource(*existingResources[i], resource, fasitEnvironmentClass, fasitEnvironment, hostname, metadata, deploymentRequest)
+			if fasitErr, ok := err.(*FasitError); ok {
+				return nil, warnings, fasitErr
+			}
 			if err != nil {
-				return nil, fmt.Errorf("failed updating resource: %s of type %s with path %s. (%s)", resource.Alias, resource.ResourceType, resource.Path, err)
+				return nil, warnings, fmt.Errorf("failed updating resource: %s of type %s with path %s. (%s)", resource.Alias, resource.ResourceType, resource.Path, err)
 			}
 			exposedResourceIds = append(exposedResourceIds, updatedResourceId)
 
 		}
 	}
-	return exposedResourceIds, nil
+	return exposedResourceIds, warnings, nil
 }
 
 func getResourceIds(usedResources []NaisResource) (usedResourceIds []int) {
@@ -277,6 +405,7 @@ func FetchFasitResources(fasit FasitClientAdapter, application string, environme
 			Alias:        resource.Alias,
 			ResourceType: resource.ResourceType,
 			PropertyMap:  resource.PropertyMap,
+			Optional:     resource.Optional,
 		})
 	}
 
@@ -285,7 +414,11 @@ func FetchFasitResources(fasit FasitClientAdapter, application string, environme
 		return naisresources, err
 	}
 
-	if lbResource, e := fasit.getLoadBalancerConfig(application, environment); e == nil {
+	if naisresources, err = convertResourcePropertyTypes(naisresources, usedResources); err != nil {
+		return naisresources, err
+	}
+
+	if lbResource, e := fasit.GetLoadBalancerConfig(application, environment); e == nil {
 		if lbResource != nil {
 			naisresources = append(naisresources, *lbResource)
 		}
@@ -301,65 +434,113 @@ func arrayToString(a []int) string {
 }
 
 // Updates Fasit with information
-func updateFasit(fasit FasitClientAdapter, deploymentRequest naisrequest.Deploy, usedResources []NaisResource, manifest NaisManifest, hostname, fasitEnvironmentClass, fasitEnvironment, domain string) error {
+func updateFasit(fasit FasitClientAdapter, deploymentRequest naisrequest.Deploy, usedResources []NaisResource, manifest NaisManifest, hostname, fasitEnvironmentClass, fasitEnvironment, domain string, instance ApplicationInstanceConfig) ([]string, error) {
 
 	usedResourceIds := getResourceIds(usedResources)
 	var exposedResourceIds []int
+	var warnings []string
 	var err error
 
 	if len(manifest.FasitResources.Exposed) > 0 {
 		if len(hostname) == 0 {
-			return fmt.Errorf("unable to create resources when no ingress nor loadbalancer is specified")
+			return nil, fmt.Errorf("unable to create resources when no ingress nor loadbalancer is specified")
 		}
-		exposedResourceIds, err = CreateOrUpdateFasitResources(fasit, manifest.FasitResources.Exposed, hostname, fasitEnvironmentClass, fasitEnvironment, deploymentRequest)
+		exposedResourceIds, warnings, err = CreateOrUpdateFasitResources(fasit, manifest.FasitResources.Exposed, hostname, fasitEnvironmentClass, fasitEnvironment, manifest.Team, deploymentRequest)
 		if err != nil {
-			return err
+			return warnings, err
 		}
 	}
 
 	glog.Infof("exposed: %s\nused: %s", arrayToString(exposedResourceIds), arrayToString(usedResourceIds))
 
-	if err := fasit.createApplicationInstance(deploymentRequest, fasitEnvironment, domain, exposedResourceIds, usedResourceIds); err != nil {
-		return err
+	if err := fasit.CreateApplicationInstance(deploymentRequest, fasitEnvironment, domain, exposedResourceIds, usedResourceIds, healthCheckUrls(manifest, hostname), instance); err != nil {
+		return warnings, err
 	}
 
-	return nil
+	return warnings, nil
+}
+
+// doRequest sends a request to Fasit, returning the body of the response, or a *FasitError saying what operation failed
+func (fasit FasitClient) doRequest(operation string, r *http.Request) ([]byte, AppError) {
+	_, body, err := fasit.exchange(operation, r)
+	if err != nil {
+		return []byte{}, err
+	}
+	return body, nil
 }
 
-func (fasit FasitClient) doRequest(r *http.Request) ([]byte, AppError) {
+// exchange is doRequest for operations that need the response itself, e.g. its headers
+func (fasit FasitClient) exchange(operation string, r *http.Request) (*http.Response, []byte, *FasitError) {
 	requestCounter.With(nil).Inc()
 
-	client := &http.Client{}
-	resp, err := client.Do(r)
+	key, conditional := fasit.conditionalKey(r)
+	var cached cachedFasitResponse
+	var hasCached bool
+	if conditional {
+		cached, hasCached = withETag(r, key)
+	}
 
+	resp, err := fasit.do(r)
 	if err != nil {
 		errorCounter.WithLabelValues("contact_fasit").Inc()
-		return []byte{}, appError{err, "Error contacting fasit", http.StatusInternalServerError}
+		return nil, nil, newFasitError(operation, nil, nil, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		errorCounter.WithLabelValues("read_body").Inc()
-		return []byte{}, appError{err, "Could not read body", http.StatusInternalServerError}
+		return resp, nil, newFasitError(operation, resp, nil, fmt.Errorf("could not read body: %s", err))
 	}
 
-	httpReqsCounter.WithLabelValues(strconv.Itoa(resp.StatusCode), "GET").Inc()
-	if resp.StatusCode == 404 {
-		errorCounter.WithLabelValues("error_fasit").Inc()
-		return []byte{}, appError{nil, fmt.Sprintf("item not found in Fasit: %s", string(body)), http.StatusNotFound}
+	httpReqsCounter.WithLabelValues(strconv.Itoa(resp.StatusCode), r.Method).Inc()
+	if hasCached && resp.StatusCode == http.StatusNotModified {
+		fasitConditionalLookups.WithLabelValues("not_modified").Inc()
+		return resp, cached.body, nil
 	}
-
-	httpReqsCounter.WithLabelValues(strconv.Itoa(resp.StatusCode), "GET").Inc()
 	if resp.StatusCode > 299 {
 		errorCounter.WithLabelValues("error_fasit").Inc()
-		return []byte{}, appError{nil, fmt.Sprintf("error contacting Fasit: %s", string(body)), resp.StatusCode}
+		return resp, body, newFasitError(operation, resp, body, nil)
 	}
 
-	return body, nil
+	if conditional {
+		if hasCached {
+			fasitConditionalLookups.WithLabelValues("modified").Inc()
+		}
+		keepETag(resp, body, key)
+	}
+	return resp, body, nil
+}
+
+func (fasit FasitClient) GetScopedResource(resourcesRequest ResourceRequest, fasitEnvironment, application, zone string) (resource NaisResource, appErr AppError) {
+	var lookup, downloads time.Duration
+	defer func() {
+		fasitDeploymentOf(fasit.ctx).resolved(newResourceTiming(resourcesRequest, lookup, downloads, appErr))
+	}()
+
+	lookupStarted := time.Now()
+	var fasitResource FasitResource
+	if FasitScopeResolution == ScopeResolutionFasit {
+		fasitResource, appErr = fasit.fetchScopedResource(resourcesRequest, fasitEnvironment, application, zone)
+	} else {
+		fasitResource, appErr = fasit.matchScopedResource(resourcesRequest, fasitEnvironment, application, zone)
+	}
+	lookup = time.Since(lookupStarted)
+	if appErr != nil {
+		return NaisResource{}, appErr
+	}
 
+	downloadsStarted := time.Now()
+	resource, err := fasit.mapToNaisResource(fasitResource, resourcesRequest.PropertyMap)
+	downloads = time.Since(downloadsStarted)
+	if err != nil {
+		return NaisResource{}, appError{err, "unable to map response to Nais resource", 500}
+	}
+	return resource, nil
 }
-func (fasit FasitClient) getScopedResource(resourcesRequest ResourceRequest, fasitEnvironment, application, zone string) (NaisResource, AppError) {
+
+// fetchScopedResource is the resource Fasit itself resolves for the scope of the deployment
+func (fasit FasitClient) fetchScopedResource(resourcesRequest ResourceRequest, fasitEnvironment, application, zone string) (FasitResource, AppError) {
 	req, err := fasit.buildRequest("GET", "/api/v2/scopedresource", map[string]string{
 		"alias":       resourcesRequest.Alias,
 		"type":        resourcesRequest.ResourceType,
@@ -369,12 +550,12 @@ func (fasit FasitClient) getScopedResource(resourcesRequest ResourceRequest, fas
 	})
 
 	if err != nil {
-		return NaisResource{}, appError{err, "unable to create request", 500}
+		return FasitResource{}, appError{err, "unable to create request", 500}
 	}
 
-	body, appErr := fasit.doRequest(req)
+	body, appErr := fasit.doRequest(fmt.Sprintf("get resource %s (%s)", resourcesRequest.Alias, resourcesRequest.ResourceType), req)
 	if appErr != nil {
-		return NaisResource{}, appErr
+		return FasitResource{}, appErr
 	}
 
 	var fasitResource FasitResource
@@ -382,14 +563,9 @@ func (fasit FasitClient) getScopedResource(resourcesRequest ResourceRequest, fas
 	err = json.Unmarshal(body, &fasitResource)
 	if err != nil {
 		errorCounter.WithLabelValues("unmarshal_body").Inc()
-		return NaisResource{}, appError{err, "could not unmarshal body", 500}
-	}
-
-	resource, err := fasit.mapToNaisResource(fasitResource, resourcesRequest.PropertyMap)
-	if err != nil {
-		return NaisResource{}, appError{err, "unable to map response to Nais resource", 500}
+		return FasitResource{}, appError{err, "could not unmarshal body", 500}
 	}
-	return resource, nil
+	return fasitResource, nil
 }
 
 func SafeMarshal(v interface{}) ([]byte, error) {
@@ -400,8 +576,13 @@ func SafeMarshal(v interface{}) ([]byte, error) {
 	b = bytes.Replace(b, []byte("\\u0026"), []byte("&"), -1)
 	return b, err
 }
-func (fasit FasitClient) createResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, deploymentRequest naisrequest.Deploy) (int, error) {
-	payload, err := SafeMarshal(buildResourcePayload(resource, NaisResource{}, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname))
+func (fasit FasitClient) CreateResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	resource, err := fasit.withCredentialSecret(resource, NaisResource{}, deploymentRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := SafeMarshal(withResourceMetadata(buildResourcePayload(resource, NaisResource{}, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname), metadata))
 	if err != nil {
 		errorCounter.WithLabelValues("create_request").Inc()
 		return 0, fmt.Errorf("unable to create payload (%s)", err)
@@ -415,25 +596,12 @@ func (fasit FasitClient) createResource(resource ExposedResource, fasitEnvironme
 
 	req.SetBasicAuth(deploymentRequest.FasitUsername, deploymentRequest.FasitPassword)
 	req.Header.Set("Content-Type", "application/json")
-	if deploymentRequest.OnBehalfOf != "" {
-		req.Header.Set("x-onbehalfof", deploymentRequest.OnBehalfOf)
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		errorCounter.WithLabelValues("create_request").Inc()
-		return 0, fmt.Errorf("unable to contact Fasit: %s", err)
-	}
-
-	defer resp.Body.Close()
+	operation := fmt.Sprintf("create resource %s (%s)", resource.Alias, resource.ResourceType)
+	setFasitAuditHeaders(req, deploymentRequest, operation)
 
-	body, err := ioutil.ReadAll(resp.Body)
-
-	httpReqsCounter.WithLabelValues(strconv.Itoa(resp.StatusCode), "POST").Inc()
-	if resp.StatusCode > 299 {
-		errorCounter.WithLabelValues("error_fasit").Inc()
-		return 0, fmt.Errorf("fasit returned: %s (%s)", body, strconv.Itoa(resp.StatusCode))
+	resp, _, fasitErr := fasit.exchange(operation, req)
+	if fasitErr != nil {
+		return 0, fasitErr
 	}
 
 	location := strings.Split(resp.Header.Get("Location"), "/")
@@ -444,11 +612,15 @@ func (fasit FasitClient) createResource(resource ExposedResource, fasitEnvironme
 
 	return id, nil
 }
-func (fasit FasitClient) updateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, deploymentRequest naisrequest.Deploy) (int, error) {
+func (fasit FasitClient) UpdateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
 	requestCounter.With(nil).Inc()
 
-	payload, err := SafeMarshal(buildResourcePayload(resource, existingResource, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname))
-	glog.Infof("Updating resource with the following payload: %s", payload)
+	resource, err := fasit.withCredentialSecret(resource, existingResource, deploymentRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := SafeMarshal(withResourceMetadata(buildResourcePayload(resource, existingResource, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname), metadata))
 	if err != nil {
 		errorCounter.WithLabelValues("create_request").Inc()
 		return 0, fmt.Errorf("unable to create payload (%s)", err)
@@ -459,14 +631,14 @@ func (fasit FasitClient) updateResource(existingResource NaisResource, resource
 		errorCounter.WithLabelValues("create_request").Inc()
 		return 0, fmt.Errorf("unable to create request: %s", err)
 	}
-	glog.Infof("Putting to: %s/api/v2/resources/%d", fasit.FasitUrl, existingResource.id)
+	// the payload is not logged, as it may hold the password of a DataSource
+	glog.Infof("Updating resource %s (%s): PUT %s/api/v2/resources/%d", resource.Alias, resource.ResourceType, fasit.FasitUrl, existingResource.id)
 	req.SetBasicAuth(deploymentRequest.FasitUsername, deploymentRequest.FasitPassword)
 	req.Header.Set("Content-Type", "application/json")
-	if deploymentRequest.OnBehalfOf != "" {
-		req.Header.Set("x-onbehalfof", deploymentRequest.OnBehalfOf)
-	}
+	operation := fmt.Sprintf("update resource %s (%s)", resource.Alias, resource.ResourceType)
+	setFasitAuditHeaders(req, deploymentRequest, operation)
 
-	_, appErr := fasit.doRequest(req)
+	_, appErr := fasit.doRequest(operation, req)
 	if appErr != nil {
 		return 0, appErr
 	}
@@ -481,7 +653,7 @@ func (fasit FasitClient) GetFasitEnvironmentClass(environmentName string) (strin
 		return "", fmt.Errorf("could not create request: %s", err)
 	}
 
-	resp, appErr := fasit.doRequest(req)
+	resp, appErr := fasit.doRequest(fmt.Sprintf("get environment %s", environmentName), req)
 	if appErr != nil {
 		return "", appErr
 	}
@@ -504,8 +676,7 @@ func (fasit FasitClient) GetFasitApplication(application string) error {
 		return fmt.Errorf("could not create request: %s", err)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := fasit.do(req)
 	if err != nil {
 		errorCounter.WithLabelValues("create_request").Inc()
 		return fmt.Errorf("unable to contact Fasit: %s", err)
@@ -529,18 +700,23 @@ func (fasit FasitClient) mapToNaisResource(fasitResource FasitResource, property
 	resource.propertyMap = propertyMap
 	resource.id = fasitResource.Id
 	resource.scope = fasitResource.Scope
+	resource.metadata = fasitResource.Metadata
 
 	if len(fasitResource.Secrets) > 0 {
-		secret, err := resolveSecret(fasitResource.Secrets, fasit.Username, fasit.Password)
+		secret, err := fasit.resolveSecret(fasitResource.Secrets)
 		if err != nil {
 			errorCounter.WithLabelValues("resolve_secret").Inc()
 			return NaisResource{}, fmt.Errorf("unable to resolve secret: %s", err)
 		}
 		resource.secret = secret
+		resource.secretRefs = make(map[string]string, len(fasitResource.Secrets))
+		for key, secret := range fasitResource.Secrets {
+			resource.secretRefs[key] = secret["ref"]
+		}
 	}
 
 	if fasitResource.ResourceType == "certificate" && len(fasitResource.Certificates) > 0 {
-		files, err := resolveCertificates(fasitResource.Certificates)
+		files, err := fasit.resolveCertificates(fasitResource.Certificates)
 
 		if err != nil {
 			errorCounter.WithLabelValues("resolve_file").Inc()
@@ -569,30 +745,40 @@ func (fasit FasitClient) mapToNaisResource(fasitResource FasitResource, property
 
 	return resource, nil
 }
-func resolveCertificates(files map[string]interface{}) (map[string][]byte, error) {
+
+// resolveCertificates downloads every file of a certificate resource, e.g. a keystore and a truststore, keeping each
+// under its filename
+func (fasit FasitClient) resolveCertificates(files map[string]interface{}) (map[string][]byte, error) {
 	fileContent := make(map[string][]byte)
 
-	fileName, fileUrl, err := parseFilesObject(files)
+	fileUrls, err := parseFilesObject(files)
 	if err != nil {
 		return fileContent, err
 	}
 
-	response, err := http.Get(fileUrl)
-	if err != nil {
-		errorCounter.WithLabelValues("contact_fasit").Inc()
-		return fileContent, fmt.Errorf("error contacting fasit when resolving file: %s", err)
-	}
-	defer response.Body.Close()
+	for fileName, fileUrl := range fileUrls {
+		req, err := http.NewRequest("GET", fileUrl, nil)
+		if err != nil {
+			return fileContent, err
+		}
 
-	bodyBytes, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		errorCounter.WithLabelValues("contact_fasit").Inc()
-		return fileContent, fmt.Errorf("error downloading file: %s", err)
+		response, err := fasit.do(req)
+		if err != nil {
+			errorCounter.WithLabelValues("contact_fasit").Inc()
+			return fileContent, fmt.Errorf("error contacting fasit when resolving file %s: %s", fileName, err)
+		}
+
+		bodyBytes, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			errorCounter.WithLabelValues("contact_fasit").Inc()
+			return fileContent, fmt.Errorf("error downloading file %s: %s", fileName, err)
+		}
+
+		fileContent[fileName] = bodyBytes
 	}
 
-	fileContent[fileName] = bodyBytes
 	return fileContent, nil
-
 }
 
 func parseLoadBalancerConfig(config []byte) (map[string]string, error) {
@@ -625,43 +811,91 @@ func parseLoadBalancerConfig(config []byte) (map[string]string, error) {
 	return ingresses, nil
 }
 
-func parseFilesObject(files map[string]interface{}) (fileName string, fileUrl string, e error) {
+// parseFilesObject returns the url of every file of a resource by its filename. Two files with the same filename would
+// overwrite each other where they are mounted, so that is an error.
+func parseFilesObject(files map[string]interface{}) (map[string]string, error) {
 	jsn, err := gabs.Consume(files)
 	if err != nil {
 		errorCounter.WithLabelValues("error_fasit").Inc()
-		return "", "", fmt.Errorf("error parsing fasit json: %s ", files)
+		return nil, fmt.Errorf("error parsing fasit json: %s ", files)
 	}
 
-	fileName, fileNameFound := jsn.Path("keystore.filename").Data().(string)
-	if !fileNameFound {
+	children, err := jsn.ChildrenMap()
+	if err != nil || len(children) == 0 {
 		errorCounter.WithLabelValues("error_fasit").Inc()
-		return "", "", fmt.Errorf("error parsing fasit json. Filename not found: %s ", files)
+		return nil, fmt.Errorf("error parsing fasit json. No files found: %s ", files)
 	}
 
-	fileUrl, fileUrlfound := jsn.Path("keystore.ref").Data().(string)
-	if !fileUrlfound {
-		errorCounter.WithLabelValues("error_fasit").Inc()
-		return "", "", fmt.Errorf("error parsing fasit json. Fileurl not found: %s ", files)
+	fileUrls := make(map[string]string, len(children))
+	for key, file := range children {
+		fileName, fileNameFound := file.Path("filename").Data().(string)
+		if !fileNameFound {
+			errorCounter.WithLabelValues("error_fasit").Inc()
+			return nil, fmt.Errorf("error parsing fasit json. Filename of %s not found: %s ", key, files)
+		}
+
+		fileUrl, fileUrlfound := file.Path("ref").Data().(string)
+		if !fileUrlfound {
+			errorCounter.WithLabelValues("error_fasit").Inc()
+			return nil, fmt.Errorf("error parsing fasit json. Fileurl of %s not found: %s ", key, files)
+		}
+
+		if _, duplicate := fileUrls[fileName]; duplicate {
+			errorCounter.WithLabelValues("error_fasit").Inc()
+			return nil, fmt.Errorf("error parsing fasit json. More than one file is named %s: %s ", fileName, files)
+		}
+		fileUrls[fileName] = fileUrl
 	}
 
-	return fileName, fileUrl, nil
+	return fileUrls, nil
 }
 
-func resolveSecret(secrets map[string]map[string]string, username string, password string) (map[string]string, error) {
+// resolveSecret fetches every secret of a resource concurrently, keeping each under its key in Fasit, e.g. password,
+// keystorePassword and truststorePassword. The first secret that can not be resolved fails the resource.
+func (fasit FasitClient) resolveSecret(secrets map[string]map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(secrets))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(chan error, len(secrets))
 
-	req, err := http.NewRequest("GET", secrets[getFirstKey(secrets)]["ref"], nil)
+	for key, secret := range secrets {
+		wg.Add(1)
+		go func(key, ref string) {
+			defer wg.Done()
 
-	if err != nil {
+			value, err := fasit.resolveSecretRef(ref)
+			if err != nil {
+				errs <- fmt.Errorf("%s: %s", key, err)
+				return
+			}
+
+			mutex.Lock()
+			resolved[key] = value
+			mutex.Unlock()
+		}(key, secret["ref"])
+	}
+	wg.Wait()
+	close(errs)
+
+	if err, failed := <-errs; failed {
 		return map[string]string{}, err
 	}
+	return resolved, nil
+}
+
+func (fasit FasitClient) resolveSecretRef(ref string) (string, error) {
+	req, err := http.NewRequest("GET", ref, nil)
+
+	if err != nil {
+		return "", err
+	}
 
-	req.SetBasicAuth(username, password)
+	req.SetBasicAuth(fasit.Username, fasit.Password)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := fasit.do(req)
 	if err != nil {
 		errorCounter.WithLabelValues("contact_fasit").Inc()
-		return map[string]string{}, fmt.Errorf("error contacting fasit when resolving secret: %s", err)
+		return "", fmt.Errorf("error contacting fasit when resolving secret: %s", err)
 	}
 
 	defer resp.Body.Close()
@@ -671,22 +905,12 @@ func resolveSecret(secrets map[string]map[string]string, username string, passwo
 	httpReqsCounter.WithLabelValues(strconv.Itoa(resp.StatusCode), "GET").Inc()
 	if resp.StatusCode > 299 {
 		errorCounter.WithLabelValues("error_fasit").Inc()
-		if requestDump, e := httputil.DumpRequest(req, false); e == nil {
-			glog.Errorf("Fasit request: ", requestDump)
-		}
-		return map[string]string{}, fmt.Errorf("fasit gave error message when resolving secret: %s (HTTP %v)", body, strconv.Itoa(resp.StatusCode))
+		// the method and URL only, as the headers hold the credentials
+		glog.Errorf("Fasit request: %s %s", req.Method, req.URL)
+		return "", fmt.Errorf("fasit gave error message when resolving secret: %s (HTTP %v)", body, strconv.Itoa(resp.StatusCode))
 	}
 
-	return map[string]string{"password": string(body)}, nil
-}
-
-func getFirstKey(m map[string]map[string]string) string {
-	if len(m) > 0 {
-		for key := range m {
-			return key
-		}
-	}
-	return ""
+	return string(body), nil
 }
 
 func (fasit FasitClient) buildRequest(method, path string, queryParams map[string]string) (*http.Request, error) {
@@ -723,19 +947,19 @@ func generateScope(resource ExposedResource, existingResource NaisResource, fasi
 	}
 }
 
-func buildApplicationInstancePayload(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int) ApplicationInstancePayload {
+func buildApplicationInstancePayload(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks HealthCheckUrls, instance ApplicationInstanceConfig) ApplicationInstancePayload {
 	// Need to make an empty array of Resources in order for json.Marshall to return [] and not null
 	// see https://danott.co/posts/json-marshalling-empty-slices-to-empty-arrays-in-go.html for details
 	emptyResources := make([]Resource, 0)
-	domain := strings.Join(strings.Split(subDomain, ".")[1:], ".")
 	applicationInstancePayload := ApplicationInstancePayload{
 		Application:      deploymentRequest.Application,
 		Environment:      fasitEnvironment,
 		Version:          deploymentRequest.Version,
-		ClusterName:      "nais",
-		Domain:           domain,
+		ClusterName:      instanceClusterName(instance),
+		Domain:           instanceDomain(instance, subDomain),
 		ExposedResources: emptyResources,
 		UsedResources:    emptyResources,
+		HealthCheckUrls:  healthChecks,
 	}
 	if len(exposedResourceIds) > 0 {
 		for _, id := range exposedResourceIds {
@@ -751,7 +975,30 @@ func buildApplicationInstancePayload(deploymentRequest naisrequest.Deploy, fasit
 	return applicationInstancePayload
 }
 
+func buildDeploymentEventPayload(deploymentRequest naisrequest.Deploy, clusterName string, timestamp time.Time) DeploymentEventPayload {
+	deployedBy := deploymentRequest.OnBehalfOf
+	if len(deployedBy) == 0 {
+		deployedBy = deploymentRequest.FasitUsername
+	}
+
+	return DeploymentEventPayload{
+		Application: deploymentRequest.Application,
+		Version:     deploymentRequest.Version,
+		Environment: deploymentRequest.FasitEnvironment,
+		Zone:        deploymentRequest.Zone,
+		Namespace:   deploymentRequest.Namespace,
+		ClusterName: clusterName,
+		DeployedBy:  deployedBy,
+		Timestamp:   timestamp.UTC().Format(time.RFC3339),
+	}
+}
+
+// buildResourcePayload is the payload of the resource type, with the properties of the resource added
 func buildResourcePayload(resource ExposedResource, existingResource NaisResource, fasitEnvironmentClass, fasitEnvironment, zone, hostname string) ResourcePayload {
+	return withResourceProperties(buildTypedResourcePayload(resource, existingResource, fasitEnvironmentClass, fasitEnvironment, zone, hostname), resource.Properties)
+}
+
+func buildTypedResourcePayload(resource ExposedResource, existingResource NaisResource, fasitEnvironmentClass, fasitEnvironment, zone, hostname string) ResourcePayload {
 	// Reference of valid resources in Fasit
 	// ['DataSource', 'MSSQLDataSource', 'DB2DataSource', 'LDAP', 'BaseUrl', 'Credential', 'Certificate', 'OpenAm', 'Cics', 'RoleMapping', 'QueueManager', 'WebserviceEndpoint', 'RestService', 'WebserviceGateway', 'EJB', 'Datapower', 'EmailAddress', 'SMTPServer', 'Queue', 'Topic', 'DeploymentManager', 'ApplicationProperties', 'MemoryParameters', 'LoadBalancer', 'LoadBalancerConfig', 'FileLibrary', 'Channel
 	if strings.EqualFold("restservice", resource.ResourceType) {
@@ -781,11 +1028,19 @@ func buildResourcePayload(resource ExposedResource, existingResource NaisResourc
 			Properties: WebserviceProperties{
 				EndpointUrl:   "https://" + hostname + resource.Path,
 				WsdlUrl:       Url.String(),
-				SecurityToken: resource.SecurityToken,
+				SecurityToken: webserviceSecurityToken(resource),
 				Description:   resource.Description,
 			},
 			Scope: generateScope(resource, existingResource, fasitEnvironmentClass, fasitEnvironment, zone),
 		}
+	} else if isWebserviceGateway(resource.ResourceType) {
+		return buildWebserviceGatewayPayload(resource, generateScope(resource, existingResource, fasitEnvironmentClass, fasitEnvironment, zone))
+	} else if isCredential(resource.ResourceType) {
+		return buildCredentialPayload(resource, generateScope(resource, existingResource, fasitEnvironmentClass, fasitEnvironment, zone))
+	} else if isDataSource(resource.ResourceType) {
+		return buildDataSourcePayload(resource, generateScope(resource, existingResource, fasitEnvironmentClass, fasitEnvironment, zone))
+	} else if isMqResourceType(resource.ResourceType) {
+		return buildMqResourcePayload(resource, generateScope(resource, existingResource, fasitEnvironmentClass, fasitEnvironment, zone))
 	} else {
 		return nil
 	}
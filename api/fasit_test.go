@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nais/naisd/api/constant"
 	"github.com/nais/naisd/api/naisrequest"
@@ -20,12 +21,14 @@ func TestResourceEnvironmentVariableName(t *testing.T) {
 			1,
 			"test.resource",
 			"type",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{},
 			map[string]string{},
 			map[string]string{},
+			nil,
 			map[string][]byte{},
 			nil,
+			ResourceMetadata{},
 		}
 		assert.Equal(t, "TEST_RESOURCE_KEY", resource.ToEnvironmentVariable("key"))
 		assert.Equal(t, "test_resource_key", resource.ToResourceVariable("key"))
@@ -34,14 +37,16 @@ func TestResourceEnvironmentVariableName(t *testing.T) {
 			1,
 			"test.resource",
 			"applicationproperties",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{
 				"foo.var-with.mixed_stuff": "fizz",
 			},
 			map[string]string{},
 			map[string]string{},
+			nil,
 			map[string][]byte{},
 			nil,
+			ResourceMetadata{},
 		}
 		assert.Equal(t, "FOO_VAR_WITH_MIXED_STUFF", resource.ToEnvironmentVariable("foo.var-with.mixed_stuff"))
 		assert.Equal(t, "foo_var_with_mixed_stuff", resource.ToResourceVariable("foo.var-with.mixed_stuff"))
@@ -52,7 +57,7 @@ func TestResourceEnvironmentVariableName(t *testing.T) {
 			1,
 			"test.resource",
 			"applicationproperties",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{
 				"foo.var-with.mixed_stuff": "fizz",
 			},
@@ -60,8 +65,10 @@ func TestResourceEnvironmentVariableName(t *testing.T) {
 				"foo.var-with.mixed_stuff": "SOMETHING_NEW",
 			},
 			map[string]string{},
+			nil,
 			map[string][]byte{},
 			nil,
+			ResourceMetadata{},
 		}
 		assert.Equal(t, "SOMETHING_NEW", resource.ToEnvironmentVariable("foo.var-with.mixed_stuff"))
 		assert.Equal(t, "something_new", resource.ToResourceVariable("foo.var-with.mixed_stuff"))
@@ -70,7 +77,7 @@ func TestResourceEnvironmentVariableName(t *testing.T) {
 			1,
 			"test.resource",
 			"datasource",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{
 				"url":      "fizzbuzz",
 				"username": "fizz",
@@ -81,8 +88,10 @@ func TestResourceEnvironmentVariableName(t *testing.T) {
 				"password": "DB_PW",
 			},
 			map[string]string{},
+			nil,
 			map[string][]byte{},
 			nil,
+			ResourceMetadata{},
 		}
 		assert.Equal(t, "TEST_RESOURCE_URL", resource.ToEnvironmentVariable("url"))
 		assert.Equal(t, "test_resource_url", resource.ToResourceVariable("url"))
@@ -100,7 +109,7 @@ func TestGettingResource(t *testing.T) {
 	application := "application"
 	zone := "zone"
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	defer gock.Off()
 	gock.New("https://fasit.local").
@@ -112,7 +121,7 @@ func TestGettingResource(t *testing.T) {
 		MatchParam("zone", zone).
 		Reply(200).File("testdata/fasitResponse.json")
 
-	resource, err := fasit.getScopedResource(ResourceRequest{alias, resourceType, map[string]string{"username": "DB_USER"}}, environment, application, zone)
+	resource, err := fasit.GetScopedResource(ResourceRequest{alias, resourceType, map[string]string{"username": "DB_USER"}, false}, environment, application, zone)
 
 	assert.Nil(t, err)
 	assert.Equal(t, alias, resource.name)
@@ -135,15 +144,71 @@ func TestCreatingApplicationInstance(t *testing.T) {
 		Reply(201).
 		BodyString("aiit")
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 	exposedResourceIds, usedResourceIds := []int{1, 2, 3}, []int{4, 5, 6}
 	deploymentRequest := naisrequest.Deploy{Application: "app", FasitEnvironment: "env", Version: "123"}
 
 	t.Run("A valid payload creates ApplicationInstance", func(t *testing.T) {
-		err := fasit.createApplicationInstance(deploymentRequest, "", "", exposedResourceIds, usedResourceIds)
+		err := fasit.CreateApplicationInstance(deploymentRequest, "", "", exposedResourceIds, usedResourceIds, HealthCheckUrls{}, ApplicationInstanceConfig{})
+		assert.NoError(t, err)
+		assert.True(t, gock.IsDone())
+	})
+}
+
+func TestCreatingDeploymentEvent(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{
+		Application:      "app",
+		FasitEnvironment: "env",
+		Version:          "123",
+		Zone:             constant.ZONE_FSS,
+		Namespace:        "default",
+		FasitUsername:    "user",
+		OnBehalfOf:       "deployer",
+	}
+
+	t.Run("Payload contains who deployed what and where", func(t *testing.T) {
+		timestamp := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+		payload := buildDeploymentEventPayload(deploymentRequest, "prod-fss", timestamp)
+
+		assert.Equal(t, "app", payload.Application)
+		assert.Equal(t, "123", payload.Version)
+		assert.Equal(t, "env", payload.Environment)
+		assert.Equal(t, "prod-fss", payload.ClusterName)
+		assert.Equal(t, "deployer", payload.DeployedBy)
+		assert.Equal(t, "2018-04-01T12:00:00Z", payload.Timestamp)
+	})
+
+	t.Run("Fasit user is used as deployer when not deploying on behalf of someone", func(t *testing.T) {
+		request := deploymentRequest
+		request.OnBehalfOf = ""
+		assert.Equal(t, "user", buildDeploymentEventPayload(request, "", time.Now()).DeployedBy)
+	})
+
+	t.Run("Deployment event is posted to Fasit", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Post("/api/v2/events").
+			HeaderPresent("Authorization").
+			MatchHeader("Content-Type", "application/json").
+			MatchHeader("x-onbehalfof", "deployer").
+			Reply(201)
+
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+		err := fasit.CreateDeploymentEvent(deploymentRequest, "prod-fss")
 		assert.NoError(t, err)
 		assert.True(t, gock.IsDone())
 	})
+
+	t.Run("Error from Fasit is returned", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Post("/api/v2/events").
+			Reply(404)
+
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+		err := fasit.CreateDeploymentEvent(deploymentRequest, "prod-fss")
+		assert.Error(t, err)
+	})
 }
 func TestCreatingResource(t *testing.T) {
 	environment := "environment"
@@ -160,12 +225,12 @@ func TestCreatingResource(t *testing.T) {
 		Zone:        "zone",
 	}
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	defer gock.Off()
 
-	t.Run("createResource returns error if fasit is unreachable", func(t *testing.T) {
-		_, err := fasit.createResource(exposedResource, class, environment, hostname, deploymentRequest)
+	t.Run("CreateResource returns error if fasit is unreachable", func(t *testing.T) {
+		_, err := fasit.CreateResource(exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.Error(t, err)
 	})
 	gock.New("https://fasit.local").
@@ -175,8 +240,8 @@ func TestCreatingResource(t *testing.T) {
 		Reply(201).
 		SetHeader("Location", fmt.Sprintf("http://localhost:8089/v2/resources/%d", id))
 
-	t.Run("createResource returns ID when called", func(t *testing.T) {
-		createdResourceId, err := fasit.createResource(exposedResource, class, environment, hostname, deploymentRequest)
+	t.Run("CreateResource returns ID when called", func(t *testing.T) {
+		createdResourceId, err := fasit.CreateResource(exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.NoError(t, err)
 		assert.True(t, gock.IsDone())
 		assert.Equal(t, id, createdResourceId)
@@ -186,8 +251,8 @@ func TestCreatingResource(t *testing.T) {
 		HeaderPresent("Authorization").
 		Reply(501).
 		BodyString("bish")
-	t.Run("createResource errs when Fasit fails", func(t *testing.T) {
-		createdResourceId, err := fasit.createResource(exposedResource, class, environment, hostname, deploymentRequest)
+	t.Run("CreateResource errs when Fasit fails", func(t *testing.T) {
+		createdResourceId, err := fasit.CreateResource(exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.Error(t, err)
 		assert.Equal(t, 0, createdResourceId)
 	})
@@ -208,12 +273,12 @@ func TestUpdateResource(t *testing.T) {
 	}
 	naisResource := NaisResource{id: 4242}
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	defer gock.Off()
 
-	t.Run("updateResource returns error if fasit is unreachable", func(t *testing.T) {
-		_, err := fasit.updateResource(naisResource, exposedResource, class, environment, hostname, deploymentRequest)
+	t.Run("UpdateResource returns error if fasit is unreachable", func(t *testing.T) {
+		_, err := fasit.UpdateResource(naisResource, exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.Error(t, err)
 	})
 	gock.New("https://fasit.local").
@@ -222,8 +287,8 @@ func TestUpdateResource(t *testing.T) {
 		MatchHeader("Content-Type", "application/json").
 		Reply(200)
 
-	t.Run("updateResource returns ID when called", func(t *testing.T) {
-		createdResourceId, err := fasit.updateResource(naisResource, exposedResource, class, environment, hostname, deploymentRequest)
+	t.Run("UpdateResource returns ID when called", func(t *testing.T) {
+		createdResourceId, err := fasit.UpdateResource(naisResource, exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.NoError(t, err)
 		assert.True(t, gock.IsDone())
 		assert.Equal(t, naisResource.id, createdResourceId)
@@ -236,13 +301,13 @@ func TestUpdateResource(t *testing.T) {
 		Reply(200)
 
 	t.Run("x-onbehalfof header not set when no OnBehalfOf flag is present", func(t *testing.T) {
-		createdResourceId, _ := fasit.updateResource(naisResource, exposedResource, class, environment, hostname, deploymentRequest)
+		createdResourceId, _ := fasit.UpdateResource(naisResource, exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.False(t, gock.IsDone())
 		assert.Equal(t, 0, createdResourceId)
 	})
 	t.Run("OnBehalfOf flag sets x-onbehalfof header", func(t *testing.T) {
 		deploymentRequest.OnBehalfOf = "username"
-		createdResourceId, _ := fasit.updateResource(naisResource, exposedResource, class, environment, hostname, deploymentRequest)
+		createdResourceId, _ := fasit.UpdateResource(naisResource, exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.True(t, gock.IsDone())
 		assert.Equal(t, naisResource.id, createdResourceId)
 	})
@@ -252,8 +317,8 @@ func TestUpdateResource(t *testing.T) {
 		MatchHeader("Content-Type", "application/json").
 		Reply(501).
 		BodyString("bish")
-	t.Run("updateResource errs when Fasit fails", func(t *testing.T) {
-		createdResourceId, err := fasit.updateResource(naisResource, exposedResource, class, environment, hostname, deploymentRequest)
+	t.Run("UpdateResource errs when Fasit fails", func(t *testing.T) {
+		createdResourceId, err := fasit.UpdateResource(naisResource, exposedResource, class, environment, hostname, ResourceMetadata{}, deploymentRequest)
 		assert.Error(t, err)
 		assert.Equal(t, 0, createdResourceId)
 	})
@@ -264,7 +329,7 @@ func TestGetLoadBalancerConfig(t *testing.T) {
 	environment := "environment"
 	application := "application"
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	t.Run("Get load balancer config happy path", func(t *testing.T) {
 
@@ -276,13 +341,25 @@ func TestGetLoadBalancerConfig(t *testing.T) {
 			MatchParam("type", "LoadBalancerConfig").
 			Reply(200).File("testdata/fasitLbConfigResponse.json")
 
-		resource, err := fasit.getLoadBalancerConfig("application", "environment")
+		resource, err := fasit.GetLoadBalancerConfig("application", "environment")
 
 		assert.NoError(t, err)
 		assert.Equal(t, 2, len(resource.ingresses))
 		assert.Equal(t, "LoadBalancerConfig", resource.resourceType)
 	})
 
+	t.Run("Error from Fasit is returned", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/resources").
+			MatchParam("type", "LoadBalancerConfig").
+			Reply(500)
+
+		resource, err := fasit.GetLoadBalancerConfig("application", "environment")
+
+		assert.Error(t, err)
+		assert.Nil(t, resource)
+	})
 }
 func TestGetResourceId(t *testing.T) {
 	naisResources := []NaisResource{{id: 1}, {id: 2}, {id: 0, resourceType: "LoadBalancerConfig"}}
@@ -295,7 +372,7 @@ type FakeFasitClient struct {
 	FasitClient
 }
 
-func (fasit FakeFasitClient) getScopedResource(resourcesRequest ResourceRequest, environment, application, zone string) (NaisResource, AppError) {
+func (fasit FakeFasitClient) GetScopedResource(resourcesRequest ResourceRequest, environment, application, zone string) (NaisResource, AppError) {
 	switch application {
 	case "notfound":
 		return NaisResource{}, appError{fmt.Errorf("not found"), "Resource not found in Fasit", 404}
@@ -306,7 +383,7 @@ func (fasit FakeFasitClient) getScopedResource(resourcesRequest ResourceRequest,
 	}
 }
 
-func (fasit FakeFasitClient) createResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, deploymentRequest naisrequest.Deploy) (int, error) {
+func (fasit FakeFasitClient) CreateResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
 	switch deploymentRequest.Zone {
 	case "failed":
 		return 0, fmt.Errorf("random error")
@@ -317,7 +394,7 @@ func (fasit FakeFasitClient) createResource(resource ExposedResource, fasitEnvir
 
 var updateCalled bool
 
-func (fasit FakeFasitClient) updateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, deploymentRequest naisrequest.Deploy) (int, error) {
+func (fasit FakeFasitClient) UpdateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
 	updateCalled = true
 	switch deploymentRequest.Zone {
 	case "failed":
@@ -330,7 +407,7 @@ func (fasit FakeFasitClient) updateResource(existingResource NaisResource, resou
 
 var createApplicationInstanceCalled bool
 
-func (fasit FakeFasitClient) createApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int) error {
+func (fasit FakeFasitClient) CreateApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks HealthCheckUrls, instance ApplicationInstanceConfig) error {
 	createApplicationInstanceCalled = true
 	return nil
 }
@@ -357,26 +434,26 @@ func TestCreateOrUpdateFasitResources(t *testing.T) {
 
 	fakeFasitClient := FakeFasitClient{}
 
-	// Using application field to identify which response to return from getScopedResource on FakeFasitClient
+	// Using application field to identify which response to return from GetScopedResource on FakeFasitClient
 	t.Run("Resources are created when their resource ID isn't found in Fasit", func(t *testing.T) {
 		deploymentRequest.Application = "notfound"
-		resourceIds, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, deploymentRequest)
+		resourceIds, _, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, "team", deploymentRequest)
 		assert.NoError(t, err)
 		assert.Equal(t, []int{4242, 4242}, resourceIds)
 	})
 	t.Run("Returns an error if contacting Fasit fails", func(t *testing.T) {
 		deploymentRequest.Application = "fasitError"
-		resourceIds, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, deploymentRequest)
+		resourceIds, _, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, "team", deploymentRequest)
 		assert.Error(t, err)
 		assert.Nil(t, resourceIds)
 		assert.True(t, strings.Contains(err.Error(), "random error: error from fasit (500)"))
 	})
 
-	// Using Zone field to identify which response to return from createResource on FakeFasitClient
+	// Using Zone field to identify which response to return from CreateResource on FakeFasitClient
 	t.Run("Returns an error if unable to create resource", func(t *testing.T) {
 		deploymentRequest.Application = "notfound"
 		deploymentRequest.Zone = "failed"
-		resourceIds, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, deploymentRequest)
+		resourceIds, _, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, "team", deploymentRequest)
 		assert.Error(t, err)
 		assert.Nil(t, resourceIds)
 		assert.True(t, strings.Contains(err.Error(), "failed creating resource: alias1 of type RestService with path . (random error)"))
@@ -385,17 +462,19 @@ func TestCreateOrUpdateFasitResources(t *testing.T) {
 		updateCalled = false
 		deploymentRequest.Zone = "zone"
 		deploymentRequest.Application = "application"
-		resourceIds, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, deploymentRequest)
+		resourceIds, warnings, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, "team", deploymentRequest)
 		assert.NoError(t, err)
 		assert.Equal(t, []int{1, 1}, resourceIds)
+		assert.Len(t, warnings, 2, "resources found in Fasit were not created by naisd")
 		assert.True(t, updateCalled)
 	})
-	// Using Zone field to identify which response to return from updateResource on FakeFasitClient
+	// Using Zone field to identify which response to return from UpdateResource on FakeFasitClient
 	t.Run("Returns an error if unable to update resource", func(t *testing.T) {
 		deploymentRequest.Zone = "failed"
-		resourceIds, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, deploymentRequest)
+		resourceIds, warnings, err := CreateOrUpdateFasitResources(fakeFasitClient, exposedResources, hostname, class, environment, "team", deploymentRequest)
 		assert.Error(t, err)
 		assert.Nil(t, resourceIds)
+		assert.Len(t, warnings, 2, "resources naisd did not create are warned about before they are written")
 		assert.True(t, strings.Contains(err.Error(), "failed updating resource: alias1 of type RestService with path . (random error)"))
 	})
 }
@@ -420,6 +499,41 @@ func TestResourceError(t *testing.T) {
 	assert.True(t, strings.Contains(err.Error(), fmt.Sprintf("unable to get resource %s (%s)", resourceAlias, resourceType)))
 }
 
+func TestOptionalResources(t *testing.T) {
+	fasitClient := FasitClient{FasitUrl: "https://fasit.local"}
+	defer gock.Off()
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", NavTruststoreFasitAlias).
+		Reply(200).File("testdata/fasitResponse.json")
+
+	t.Run("Optional resources that do not exist are skipped", func(t *testing.T) {
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "missing").
+			Reply(404).BodyString("not found")
+
+		resources, err := FetchFasitResources(fasitClient, "app", "env", "123", []UsedResource{{Alias: "missing", ResourceType: "baseurl", Optional: true}})
+		assert.NoError(t, err)
+		assert.Len(t, resources, 1)
+		assert.Equal(t, []int{resources[0].id}, getResourceIds(resources), "only the resources that were resolved are registered as used")
+	})
+
+	t.Run("Optional resources still fail the deployment when Fasit fails", func(t *testing.T) {
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", NavTruststoreFasitAlias).
+			Reply(200).File("testdata/fasitResponse.json")
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "broken").
+			Reply(500).BodyString("internal error")
+
+		_, err := FetchFasitResources(fasitClient, "app", "env", "123", []UsedResource{{Alias: "broken", ResourceType: "baseurl", Optional: true}})
+		assert.Error(t, err)
+	})
+}
+
 func TestUpdateFasit(t *testing.T) {
 
 	alias := "alias"
@@ -450,20 +564,20 @@ func TestUpdateFasit(t *testing.T) {
 
 	t.Run("Calling updateFasit with resources returns no error", func(t *testing.T) {
 		createApplicationInstanceCalled = false
-		err := updateFasit(fakeFasitClient, deploymentRequest, usedResources, manifest, hostname, class, clustername, "")
+		_, err := updateFasit(fakeFasitClient, deploymentRequest, usedResources, manifest, hostname, class, clustername, "", ApplicationInstanceConfig{})
 		assert.NoError(t, err)
 		assert.True(t, createApplicationInstanceCalled)
 	})
 	t.Run("Calling updateFasit without hostname when you have exposed resources fails", func(t *testing.T) {
 		createApplicationInstanceCalled = false
-		err := updateFasit(fakeFasitClient, deploymentRequest, usedResources, manifest, "", class, clustername, "")
+		_, err := updateFasit(fakeFasitClient, deploymentRequest, usedResources, manifest, "", class, clustername, "", ApplicationInstanceConfig{})
 		assert.Error(t, err)
 		assert.False(t, createApplicationInstanceCalled)
 	})
 	t.Run("Calling updateFasit without hostname when you have no exposed resources works", func(t *testing.T) {
 		createApplicationInstanceCalled = false
 		manifest.FasitResources.Exposed = nil
-		err := updateFasit(fakeFasitClient, deploymentRequest, usedResources, manifest, "", class, clustername, "")
+		_, err := updateFasit(fakeFasitClient, deploymentRequest, usedResources, manifest, "", class, clustername, "", ApplicationInstanceConfig{})
 		assert.NoError(t, err)
 		assert.True(t, createApplicationInstanceCalled)
 	})
@@ -515,7 +629,7 @@ func TestBuildingFasitPayloads(t *testing.T) {
 	usedResources := []Resource{{4}, {5}, {6}}
 
 	t.Run("Building ApplicationInstancePayload", func(t *testing.T) {
-		payload := buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds)
+		payload := buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds, HealthCheckUrls{}, ApplicationInstanceConfig{})
 		assert.Equal(t, application, payload.Application)
 		assert.Equal(t, environment, payload.Environment)
 		assert.Equal(t, version, payload.Version)
@@ -523,21 +637,21 @@ func TestBuildingFasitPayloads(t *testing.T) {
 		assert.Equal(t, usedResources, payload.UsedResources)
 	})
 	t.Run("Marshalling payload with both exposed and used resources works", func(t *testing.T) {
-		payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds))
+		payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds, HealthCheckUrls{}, ApplicationInstanceConfig{}))
 		assert.NoError(t, err)
 		n := len(payload)
 		assert.Equal(t, "{\"application\":\"appName\",\"environment\":\"t1000\",\"version\":\"2.1\",\"exposedresources\":[{\"id\":1},{\"id\":2},{\"id\":3}],\"usedresources\":[{\"id\":4},{\"id\":5},{\"id\":6}],\"clustername\":\"nais\",\"domain\":\"devillo.no\"}", string(payload[:n]))
 	})
 	t.Run("Marshalling payload with no exposed resources returns empty array in json", func(t *testing.T) {
 		emptyResourceList := []int{}
-		payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, emptyResourceList, usedResourceIds))
+		payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, emptyResourceList, usedResourceIds, HealthCheckUrls{}, ApplicationInstanceConfig{}))
 		assert.NoError(t, err)
 		n := len(payload)
 		assert.Equal(t, "{\"application\":\"appName\",\"environment\":\"t1000\",\"version\":\"2.1\",\"exposedresources\":[],\"usedresources\":[{\"id\":4},{\"id\":5},{\"id\":6}],\"clustername\":\"nais\",\"domain\":\"devillo.no\"}", string(payload[:n]))
 	})
 	t.Run("Marshalling payload with no used resources returns empty array in json", func(t *testing.T) {
 		emptyResourceList := []int{}
-		payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, emptyResourceList))
+		payload, err := json.Marshal(buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, emptyResourceList, HealthCheckUrls{}, ApplicationInstanceConfig{}))
 		assert.NoError(t, err)
 		n := len(payload)
 		assert.Equal(t, "{\"application\":\"appName\",\"environment\":\"t1000\",\"version\":\"2.1\",\"exposedresources\":[{\"id\":1},{\"id\":2},{\"id\":3}],\"usedresources\":[],\"clustername\":\"nais\",\"domain\":\"devillo.no\"}", string(payload[:n]))
@@ -625,7 +739,7 @@ func TestGetFasitEnvironment(t *testing.T) {
 		Reply(200).
 		JSON(map[string]string{"environmentclass": "u"})
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 	t.Run("Returns an error if environment isn't found", func(t *testing.T) {
 		_, err := fasit.GetFasitEnvironmentClass("notExisting")
 		assert.Error(t, err)
@@ -647,7 +761,7 @@ func TestGetFasitApplication(t *testing.T) {
 		Reply(200).
 		BodyString("anything")
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	t.Run("Returns err if application isn't found", func(t *testing.T) {
 		err := fasit.GetFasitApplication("Nonexistant")
@@ -673,7 +787,7 @@ func TestGettingListOfResources(t *testing.T) {
 	application := "application"
 	zone := "zone"
 
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	defer gock.Off()
 	gock.New("https://fasit.local").
@@ -713,10 +827,10 @@ func TestGettingListOfResources(t *testing.T) {
 		Reply(200).File("testdata/fasitResponse4.json")
 
 	resources := []ResourceRequest{}
-	resources = append(resources, ResourceRequest{alias, resourceType, nil})
-	resources = append(resources, ResourceRequest{alias2, resourceType, nil})
-	resources = append(resources, ResourceRequest{alias3, resourceType, nil})
-	resources = append(resources, ResourceRequest{alias4, "applicationproperties", nil})
+	resources = append(resources, ResourceRequest{alias, resourceType, nil, false})
+	resources = append(resources, ResourceRequest{alias2, resourceType, nil, false})
+	resources = append(resources, ResourceRequest{alias3, resourceType, nil, false})
+	resources = append(resources, ResourceRequest{alias4, "applicationproperties", nil, false})
 
 	resourcesReplies, err := fasit.GetScopedResources(resources, environment, application, zone)
 
@@ -734,7 +848,7 @@ func TestGettingListOfResources(t *testing.T) {
 }
 
 func TestResourceWithArbitraryPropertyKeys(t *testing.T) {
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	defer gock.Off()
 	gock.New("https://fasit.local").
@@ -742,7 +856,7 @@ func TestResourceWithArbitraryPropertyKeys(t *testing.T) {
 		MatchParam("alias", "alias").
 		Reply(200).File("testdata/fasitResponse-arbitrary-keys.json")
 
-	resource, appError := fasit.getScopedResource(ResourceRequest{"alias", "DataSource", nil}, "dev", "app", "zone")
+	resource, appError := fasit.GetScopedResource(ResourceRequest{"alias", "DataSource", nil, false}, "dev", "app", "zone")
 	assert.Nil(t, appError)
 
 	assert.Equal(t, "1", resource.properties["a"])
@@ -752,7 +866,7 @@ func TestResourceWithArbitraryPropertyKeys(t *testing.T) {
 
 func TestResolvingSecret(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
-		fasit := FasitClient{"https://fasit.local", "", ""}
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 		defer gock.Off()
 		gock.New("https://fasit.local").
@@ -765,7 +879,7 @@ func TestResolvingSecret(t *testing.T) {
 			HeaderPresent("Authorization").
 			Reply(200).BodyString("hemmelig")
 
-		resource, appError := fasit.getScopedResource(ResourceRequest{"aliaset", "DataSource", nil}, "dev", "app", "zone")
+		resource, appError := fasit.GetScopedResource(ResourceRequest{"aliaset", "DataSource", nil, false}, "dev", "app", "zone")
 
 		assert.Nil(t, appError)
 
@@ -774,7 +888,7 @@ func TestResolvingSecret(t *testing.T) {
 	})
 
 	t.Run("Unauthorized to get secret", func(t *testing.T) {
-		fasit := FasitClient{"https://fasit.local", "", ""}
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 		defer gock.Off()
 		gock.New("https://fasit.local").
@@ -787,15 +901,64 @@ func TestResolvingSecret(t *testing.T) {
 			HeaderPresent("Authorization").
 			Reply(401).BodyString("no access")
 
-		_, appError := fasit.getScopedResource(ResourceRequest{"aliaset", "DataSource", nil}, "dev", "app", "zone")
+		_, appError := fasit.GetScopedResource(ResourceRequest{"aliaset", "DataSource", nil, false}, "dev", "app", "zone")
 
 		assert.NotNil(t, appError)
 		assert.Contains(t, appError.Error(), "no access", "propagates fasit response to enduser")
 	})
+
+	t.Run("every secret is resolved under its own key", func(t *testing.T) {
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "keystore").
+			Reply(200).File("testdata/response-with-secrets.json")
+
+		gock.New("https://fasit.adeo.no").
+			Get("/api/v2/secrets/1001").
+			HeaderPresent("Authorization").
+			Reply(200).BodyString("keystore-secret")
+
+		gock.New("https://fasit.adeo.no").
+			Get("/api/v2/secrets/1002").
+			HeaderPresent("Authorization").
+			Reply(200).BodyString("truststore-secret")
+
+		resource, appError := fasit.GetScopedResource(ResourceRequest{"keystore", "DataSource", nil, false}, "dev", "app", "zone")
+
+		assert.Nil(t, appError)
+		assert.Equal(t, map[string]string{"keystorepassword": "keystore-secret", "truststorepassword": "truststore-secret"}, resource.secret)
+	})
+
+	t.Run("one secret failing fails the resource", func(t *testing.T) {
+		fasit := FasitClient{FasitUrl: "https://fasit.local"}
+
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "keystore").
+			Reply(200).File("testdata/response-with-secrets.json")
+
+		gock.New("https://fasit.adeo.no").
+			Get("/api/v2/secrets/1001").
+			Reply(200).BodyString("keystore-secret")
+
+		gock.New("https://fasit.adeo.no").
+			Get("/api/v2/secrets/1002").
+			Reply(403).BodyString("forbidden")
+
+		_, appError := fasit.GetScopedResource(ResourceRequest{"keystore", "DataSource", nil, false}, "dev", "app", "zone")
+
+		assert.NotNil(t, appError)
+		assert.Contains(t, appError.Error(), "truststorepassword")
+		assert.Contains(t, appError.Error(), "forbidden")
+	})
 }
 
 func TestResolveCertificates(t *testing.T) {
-	fasit := FasitClient{"https://fasit.local", "", ""}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
 
 	t.Run("Fetch certificate file for resources of type certificate", func(t *testing.T) {
 
@@ -808,7 +971,7 @@ func TestResolveCertificates(t *testing.T) {
 			Get("/api/v2/resources/3024713/file/keystore").
 			Reply(200).Body(bytes.NewReader([]byte("Some binary format")))
 
-		resource, appError := fasit.getScopedResource(ResourceRequest{"alias", "Certificate", nil}, "dev", "app", "zone")
+		resource, appError := fasit.GetScopedResource(ResourceRequest{"alias", "Certificate", nil, false}, "dev", "app", "zone")
 
 		assert.Nil(t, appError)
 
@@ -816,6 +979,28 @@ func TestResolveCertificates(t *testing.T) {
 
 	})
 
+	t.Run("Fetch every file of a certificate", func(t *testing.T) {
+
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "alias").
+			Reply(200).File("testdata/fasitCertWithTruststoreResponse.json")
+		gock.New("https://fasit.adeo.no").
+			Get("/api/v2/resources/3024713/file/keystore").
+			Reply(200).Body(bytes.NewReader([]byte("keystore content")))
+		gock.New("https://fasit.adeo.no").
+			Get("/api/v2/resources/3024713/file/truststore").
+			Reply(200).Body(bytes.NewReader([]byte("truststore content")))
+
+		resource, appError := fasit.GetScopedResource(ResourceRequest{"alias", "Certificate", nil, false}, "dev", "app", "zone")
+
+		assert.Nil(t, appError)
+		assert.Equal(t, "keystore content", string(resource.certificates["keystore"]))
+		assert.Equal(t, "truststore content", string(resource.certificates["truststore.jks"]))
+		assert.Equal(t, "srvvarseloppgave_cert_truststore_jks", resource.ToResourceVariable("truststore.jks"))
+	})
+
 	t.Run("Ignore non certificate resources with files ", func(t *testing.T) {
 
 		defer gock.Off()
@@ -825,7 +1010,7 @@ func TestResolveCertificates(t *testing.T) {
 			Reply(200).File("testdata/fasitFilesNoCertifcateResponse.json").
 			Done()
 
-		resource, appError := fasit.getScopedResource(ResourceRequest{"alias", "Certificate", nil}, "dev", "app", "zone")
+		resource, appError := fasit.GetScopedResource(ResourceRequest{"alias", "Certificate", nil, false}, "dev", "app", "zone")
 
 		assert.Nil(t, appError)
 
@@ -866,12 +1051,44 @@ func TestParseFilesObject(t *testing.T) {
 				"filename": "keystore",
 				"ref": "https://file.url"
 			}}`), &jsonMap)
-		fileName, fileUrl, err := parseFilesObject(jsonMap)
+		fileUrls, err := parseFilesObject(jsonMap)
 
 		assert.NoError(t, err)
-		assert.Equal(t, "keystore", fileName)
-		assert.Equal(t, "https://file.url", fileUrl)
+		assert.Equal(t, map[string]string{"keystore": "https://file.url"}, fileUrls)
+
+	})
 
+	t.Run("Parse every file", func(t *testing.T) {
+		var jsonMap map[string]interface{}
+		json.Unmarshal([]byte(`{
+			"keystore": {
+				"filename": "keystore",
+				"ref": "https://keystore.url"
+			},
+			"truststore": {
+				"filename": "truststore.jks",
+				"ref": "https://truststore.url"
+			}}`), &jsonMap)
+		fileUrls, err := parseFilesObject(jsonMap)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"keystore": "https://keystore.url", "truststore.jks": "https://truststore.url"}, fileUrls)
+	})
+
+	t.Run("Err if two files have the same filename", func(t *testing.T) {
+		var jsonMap map[string]interface{}
+		json.Unmarshal([]byte(`{
+			"keystore": {
+				"filename": "store",
+				"ref": "https://keystore.url"
+			},
+			"truststore": {
+				"filename": "store",
+				"ref": "https://truststore.url"
+			}}`), &jsonMap)
+		_, err := parseFilesObject(jsonMap)
+
+		assert.Error(t, err)
 	})
 
 	t.Run("Err if filename not found ", func(t *testing.T) {
@@ -880,7 +1097,7 @@ func TestParseFilesObject(t *testing.T) {
 			"keystore": {
 				"ref": "https://file.url"
 			}}`), &jsonMap)
-		_, _, err := parseFilesObject(jsonMap)
+		_, err := parseFilesObject(jsonMap)
 
 		assert.Error(t, err)
 	})
@@ -891,7 +1108,7 @@ func TestParseFilesObject(t *testing.T) {
 			"keystore": {
 				"filename": "keystore",
 			}}`), &jsonMap)
-		_, _, err := parseFilesObject(jsonMap)
+		_, err := parseFilesObject(jsonMap)
 
 		assert.Error(t, err)
 	})
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// Where the deployment request came from, as told in Fasit's change history
+func deploymentRequestSource(deploymentRequest naisrequest.Deploy) string {
+	if pr := deploymentRequest.PullRequest; pr != nil {
+		return fmt.Sprintf("pull request %s/%s#%d", pr.Provider, pr.Repository, pr.Number)
+	}
+	return "deploy request"
+}
+
+// Returns the comment Fasit shows for a change naisd makes during a deployment, e.g.
+// "naisd: create resource mydb (DataSource) while deploying app:1.2.3 to t1, by alice from deploy request"
+func fasitChangeComment(deploymentRequest naisrequest.Deploy, operation string) string {
+	deployedBy := deploymentRequest.OnBehalfOf
+	if len(deployedBy) == 0 {
+		deployedBy = deploymentRequest.FasitUsername
+	}
+
+	comment := fmt.Sprintf("naisd: %s while deploying %s:%s to %s, by %s from %s", operation, deploymentRequest.Application, deploymentRequest.Version, deploymentRequest.FasitEnvironment, deployedBy, deploymentRequestSource(deploymentRequest))
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(comment)
+}
+
+// Sets the headers Fasit records in its change history, so that changes naisd makes can be traced to the deployment
+// that caused them. Without x-onbehalfof, Fasit attributes the change to the user naisd authenticates as.
+func setFasitAuditHeaders(req *http.Request, deploymentRequest naisrequest.Deploy, operation string) {
+	if deploymentRequest.OnBehalfOf != "" {
+		req.Header.Set("x-onbehalfof", deploymentRequest.OnBehalfOf)
+	}
+	req.Header.Set("x-comment", fasitChangeComment(deploymentRequest, operation))
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFasitChangeComment(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: appName, Version: version, FasitEnvironment: "t1", FasitUsername: "srvnaisd"}
+
+	assert.Equal(t, "naisd: create resource db (DataSource) while deploying "+appName+":"+version+" to t1, by srvnaisd from deploy request",
+		fasitChangeComment(deploymentRequest, "create resource db (DataSource)"))
+
+	deploymentRequest.OnBehalfOf = "alice\nbob"
+	deploymentRequest.PullRequest = &naisrequest.PullRequest{Provider: "github", Repository: "navikt/app", Number: 12}
+	assert.Equal(t, "naisd: register application instance of "+appName+" while deploying "+appName+":"+version+" to t1, by alice bob from pull request github/navikt/app#12",
+		fasitChangeComment(deploymentRequest, "register application instance of "+appName), "the comment is kept on one line")
+}
+
+func TestFasitAuditHeaders(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+		w.Header().Set("Location", "/api/v2/resources/42")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	fasit := FasitClient{FasitUrl: server.URL}
+	deploymentRequest := naisrequest.Deploy{Application: appName, Version: version, FasitEnvironment: "t1", OnBehalfOf: "alice"}
+	resource := ExposedResource{Alias: "api", ResourceType: "RestService"}
+
+	_, err := fasit.CreateResource(resource, "t", "t1", "app.nais.example.no", ResourceMetadata{}, deploymentRequest)
+	assert.NoError(t, err)
+	_, err = fasit.UpdateResource(NaisResource{id: 42}, resource, "t", "t1", "app.nais.example.no", ResourceMetadata{}, deploymentRequest)
+	assert.NoError(t, err)
+	assert.NoError(t, fasit.CreateApplicationInstance(deploymentRequest, "t1", "nais.example.no", nil, nil, HealthCheckUrls{}, ApplicationInstanceConfig{}))
+
+	assert.Len(t, headers, 3)
+	for i, operation := range []string{"create resource api (RestService)", "update resource api (RestService)", "register application instance of " + appName} {
+		assert.Equal(t, "alice", headers[i].Get("x-onbehalfof"))
+		assert.Equal(t, fasitChangeComment(deploymentRequest, operation), headers[i].Get("x-comment"))
+	}
+}
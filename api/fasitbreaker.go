@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerHalfOpen = "half-open"
+	BreakerOpen     = "open"
+)
+
+// The values of fasit_circuit_breaker_state
+var breakerStates = map[string]float64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}
+
+// CircuitBreakerPolicy opens the circuit breaker of a Fasit instance after Failures requests in a row have failed,
+// after their retries, with a network error or a 5xx response. Requests then fail at once until Cooldown has passed,
+// when a single request is let through to see if Fasit is back. 0 failures disables the circuit breaker.
+type CircuitBreakerPolicy struct {
+	Failures int
+	Cooldown time.Duration
+}
+
+var FasitCircuitBreaker = CircuitBreakerPolicy{Failures: 5, Cooldown: 30 * time.Second}
+
+var errFasitUnavailable = errors.New("Fasit unavailable: too many requests to it have failed, try again later")
+
+var fasitBreakers = newCircuitBreakers()
+
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	host     string
+	state    string
+	failures int
+	opened   time.Time
+	probing  bool
+}
+
+type circuitBreakers struct {
+	mutex    sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{breakers: make(map[string]*circuitBreaker)}
+}
+
+// forHost returns the circuit breaker of the Fasit instance at host, as each zone can have its own
+func (b *circuitBreakers) forHost(host string) *circuitBreaker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	breaker, ok := b.breakers[host]
+	if !ok {
+		breaker = &circuitBreaker{host: host, state: BreakerClosed}
+		b.breakers[host] = breaker
+	}
+	return breaker
+}
+
+// allow says if a request may be sent. Once the cooldown of an open breaker has passed, one request is let through.
+func (b *circuitBreaker) allow(policy CircuitBreakerPolicy, now time.Time) bool {
+	if policy.Failures <= 0 {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.opened) < policy.Cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record counts the outcome of a request that was allowed
+func (b *circuitBreaker) record(policy CircuitBreakerPolicy, succeeded bool, now time.Time) {
+	if policy.Failures <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if succeeded {
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= policy.Failures {
+		b.opened = now
+		b.setState(BreakerOpen)
+	}
+}
+
+// release lets another request through a half-open breaker, when the one let through was cancelled by naisd
+func (b *circuitBreaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// open says if requests fail at once, as the breaker is open and its cooldown has not passed
+func (b *circuitBreaker) open(policy CircuitBreakerPolicy, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state == BreakerOpen && now.Sub(b.opened) < policy.Cooldown
+}
+
+func (b *circuitBreaker) setState(state string) {
+	if b.state != state {
+		glog.Infof("circuit breaker for fasit %s is %s (%d failed requests in a row)", b.host, state, b.failures)
+	}
+	b.state = state
+	fasitBreakerState.WithLabelValues(b.host).Set(breakerStates[state])
+}
+
+// unavailable says if the circuit breaker of the client's Fasit is open
+func (fasit FasitClient) unavailable() bool {
+	fasitUrl, err := url.Parse(fasit.FasitUrl)
+	if err != nil {
+		return false
+	}
+	return fasitBreakers.forHost(fasitUrl.Host).open(FasitCircuitBreaker, time.Now())
+}
+
+// fasitAppError is an error from Fasit as the deploy handler responds with it: 503 if Fasit is unavailable, so clients
+// know to try again later instead of waiting on Fasit, and otherwise the status of a FasitError, or status
+func fasitAppError(fasit FasitClient, err error, message string, status int) *appError {
+	if fasit.unavailable() {
+		return &appError{err, "Fasit unavailable", http.StatusServiceUnavailable}
+	}
+	return &appError{err, message, fasitResponseStatus(err, status)}
+}
+
+func isFasitUnavailable(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return err == errFasitUnavailable
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	policy := CircuitBreakerPolicy{Failures: 2, Cooldown: time.Minute}
+	breaker := newCircuitBreakers().forHost("fasit.local")
+	now := time.Now()
+
+	assert.True(t, breaker.allow(policy, now))
+	breaker.record(policy, false, now)
+	assert.True(t, breaker.allow(policy, now), "the breaker stays closed until enough requests have failed")
+	breaker.record(policy, false, now)
+
+	assert.True(t, breaker.open(policy, now))
+	assert.False(t, breaker.allow(policy, now.Add(time.Second)))
+
+	later := now.Add(time.Minute)
+	assert.False(t, breaker.open(policy, later))
+	assert.True(t, breaker.allow(policy, later), "one request is let through after the cooldown")
+	assert.False(t, breaker.allow(policy, later), "only one request is let through while half-open")
+	breaker.record(policy, false, later)
+	assert.True(t, breaker.open(policy, later), "a failure while half-open opens the breaker again")
+
+	evenLater := later.Add(time.Minute)
+	assert.True(t, breaker.allow(policy, evenLater))
+	breaker.record(policy, true, evenLater)
+	assert.Equal(t, BreakerClosed, breaker.state)
+	assert.True(t, breaker.allow(policy, evenLater))
+
+	t.Run("Cancelled requests let another one through", func(t *testing.T) {
+		breaker := newCircuitBreakers().forHost("fasit.local")
+		breaker.record(policy, false, now)
+		breaker.record(policy, false, now)
+
+		assert.True(t, breaker.allow(policy, later))
+		breaker.release()
+		assert.True(t, breaker.allow(policy, later))
+	})
+
+	t.Run("Disabled breakers let everything through", func(t *testing.T) {
+		breaker := newCircuitBreakers().forHost("fasit.local")
+		for i := 0; i < 10; i++ {
+			breaker.record(CircuitBreakerPolicy{}, false, now)
+		}
+		assert.True(t, breaker.allow(CircuitBreakerPolicy{}, now))
+	})
+}
+
+func TestFasitCircuitBreaker(t *testing.T) {
+	policy := FasitCircuitBreaker
+	defer func() { FasitCircuitBreaker, fasitBreakers = policy, newCircuitBreakers() }()
+	FasitCircuitBreaker, fasitBreakers = CircuitBreakerPolicy{Failures: 2, Cooldown: time.Minute}, newCircuitBreakers()
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	fasit := FasitClient{FasitUrl: server.URL}
+
+	for i := 0; i < 2; i++ {
+		_, err := fasit.GetFasitEnvironmentClass("t1")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, requests)
+	assert.True(t, fasit.unavailable())
+
+	_, err := fasit.GetFasitEnvironmentClass("t1")
+	assert.Equal(t, http.StatusServiceUnavailable, err.(*FasitError).Code())
+	assert.Equal(t, 2, requests, "requests fail at once while the breaker is open")
+
+	host, _ := url.Parse(server.URL)
+	assert.Equal(t, float64(2), gaugeValue(fasitBreakerState.WithLabelValues(host.Host)))
+
+	appErr := fasitAppError(fasit, err, "unable to fetch fasit resources", http.StatusBadRequest)
+	assert.Equal(t, http.StatusServiceUnavailable, appErr.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, fasitAppError(FasitClient{FasitUrl: "https://other.local"}, fmt.Errorf("invalid resource"), "unable to fetch fasit resources", http.StatusBadRequest).StatusCode)
+
+	resp, err := fasitHealthClient(nil).Get(server.URL)
+	assert.NoError(t, err, "health checks are not stopped by the breaker")
+	resp.Body.Close()
+}
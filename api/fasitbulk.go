@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// FasitBulkLookups makes naisd list the resources of each type a deployment uses in one request, instead of looking up
+// every used resource on its own
+var FasitBulkLookups = false
+
+// listResources lists the resources in Fasit matching the query, none if Fasit has none
+func (fasit FasitClient) listResources(operation string, query map[string]string) ([]FasitResource, AppError) {
+	req, err := fasit.buildRequest("GET", "/api/v2/resources", query)
+	if err != nil {
+		return nil, appError{err, "unable to create request", 500}
+	}
+
+	body, appErr := fasit.doRequest(operation, req)
+	if appErr != nil {
+		if appErr.Code() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, appErr
+	}
+
+	var resources []FasitResource
+	if err := json.Unmarshal(body, &resources); err != nil {
+		errorCounter.WithLabelValues("unmarshal_body").Inc()
+		return nil, appError{err, "could not unmarshal body", 500}
+	}
+	return resources, nil
+}
+
+// bulkScopedResources resolves the requested resources from one listing per resource type, keyed by the index of their
+// request. Requests that are cached, missing, ambiguous or fail are left out, to be looked up on their own, as are those
+// with several resources with their alias unless --fasit-scope-resolution makes naisd pick among them.
+func (fasit FasitClient) bulkScopedResources(requests []ResourceRequest, environment, application, zone string) map[int]NaisResource {
+	byType := make(map[string][]int)
+	var types []string
+	for i, request := range requests {
+		if ScopedResourceCacheTtl > 0 && !fasit.bypassesCache() {
+			if _, cached := scopedResources.get(newScopedResourceKey(fasit, request, environment, application, zone), time.Now()); cached {
+				continue
+			}
+		}
+		resourceType := strings.ToLower(request.ResourceType)
+		if _, seen := byType[resourceType]; !seen {
+			types = append(types, resourceType)
+		}
+		byType[resourceType] = append(byType[resourceType], i)
+	}
+
+	resolved := make(map[int]NaisResource)
+	for _, resourceType := range types {
+		indexes := byType[resourceType]
+		if len(indexes) < 2 {
+			continue
+		}
+
+		lookupStarted := time.Now()
+		listed, appErr := fasit.listResources(fmt.Sprintf("list resources of type %s", resourceType), map[string]string{
+			"type":        requests[indexes[0]].ResourceType,
+			"environment": environment,
+			"application": application,
+			"zone":        zone,
+		})
+		lookup := time.Since(lookupStarted)
+		if appErr != nil {
+			glog.Warningf("unable to list resources of type %s in %s, looking them up one by one: %s", resourceType, environment, appErr)
+			continue
+		}
+
+		for _, i := range indexes {
+			request := requests[i]
+			var candidates []FasitResource
+			for _, resource := range listed {
+				if resource.Alias == request.Alias && scopeApplies(resource.Scope, environment, application, zone) {
+					candidates = append(candidates, resource)
+				}
+			}
+
+			// with the fasit resolution, Fasit picks among several resources with the alias, which the per-alias lookup
+			// leaves to it
+			if FasitScopeResolution == ScopeResolutionFasit && len(candidates) > 1 {
+				continue
+			}
+
+			fasitResource, appErr := pickScopedResource(request, environment, FasitScopeResolution, candidates)
+			if appErr != nil {
+				continue
+			}
+
+			downloadsStarted := time.Now()
+			resource, err := fasit.mapToNaisResource(fasitResource, request.PropertyMap)
+			if err != nil {
+				continue
+			}
+			fasitDeploymentOf(fasit.ctx).resolved(newResourceTiming(request, lookup, time.Since(downloadsStarted), nil))
+
+			if ScopedResourceCacheTtl > 0 {
+				scopedResources.put(newScopedResourceKey(fasit, request, environment, application, zone), resource, time.Now().Add(ScopedResourceCacheTtl))
+			}
+			resolved[i] = resource
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestBulkScopedResources(t *testing.T) {
+	FasitBulkLookups = true
+	defer func() { FasitBulkLookups = false }()
+	defer gock.Off()
+
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	requests := []ResourceRequest{
+		{Alias: "mydb", ResourceType: "DataSource"},
+		{Alias: "otherdb", ResourceType: "datasource"},
+		{Alias: "thirddb", ResourceType: "DataSource"},
+		{Alias: "myqueue", ResourceType: "queue"},
+	}
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/resources").
+		MatchParam("type", "DataSource").
+		MatchParam("environment", "t1").
+		MatchParam("application", "app").
+		MatchParam("zone", "fss").
+		Reply(200).
+		BodyString(`[{"id": 1, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t"}, "properties": {"url": "jdbc:class"}},
+			{"id": 2, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t", "environment": "t1"}, "properties": {"url": "jdbc:t1"}},
+			{"id": 3, "alias": "otherdb", "type": "DataSource", "scope": {"environmentclass": "t", "environment": "t2"}, "properties": {"url": "jdbc:t2"}},
+			{"id": 4, "alias": "thirddb", "type": "DataSource", "scope": {"environmentclass": "t"}, "properties": {"url": "jdbc:third"}}]`)
+
+	// mydb has two resources in the listing, which Fasit resolves, otherdb is not in the listing for t1, and the queue is
+	// the only one of its type, so they are looked up on their own
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "mydb").
+		Reply(200).
+		BodyString(`{"id": 2, "alias": "mydb", "type": "DataSource", "properties": {"url": "jdbc:t1"}}`)
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "otherdb").
+		Reply(200).
+		BodyString(`{"id": 5, "alias": "otherdb", "type": "DataSource", "properties": {"url": "jdbc:other"}}`)
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "myqueue").
+		Reply(200).
+		BodyString(`{"id": 6, "alias": "myqueue", "type": "queue", "properties": {"queueName": "QUEUE"}}`)
+
+	resources, err := fasit.GetScopedResources(requests, "t1", "app", "fss")
+
+	assert.NoError(t, err)
+	assert.True(t, gock.IsDone())
+	assert.Len(t, resources, 4)
+	var ids []int
+	for _, resource := range resources {
+		ids = append(ids, resource.id)
+	}
+	assert.Equal(t, []int{2, 5, 4, 6}, ids, "resources keep the order they were requested in")
+	assert.Equal(t, "jdbc:t1", resources[0].properties["url"])
+}
+
+func TestBulkScopedResourcesPicksByScopeResolution(t *testing.T) {
+	defer gock.Off()
+	defer func(resolution string) { FasitScopeResolution = resolution }(FasitScopeResolution)
+
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	requests := []ResourceRequest{{Alias: "mydb", ResourceType: "DataSource"}, {Alias: "otherdb", ResourceType: "DataSource"}}
+	listing := `[{"id": 1, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t"}},
+		{"id": 2, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t", "environment": "t1"}},
+		{"id": 3, "alias": "otherdb", "type": "DataSource", "scope": {"environmentclass": "t"}}]`
+
+	for resolution, expected := range map[string][]int{
+		ScopeResolutionFasit:     {3},
+		ScopeResolutionBestMatch: {2, 3},
+		ScopeResolutionConflict:  {3},
+	} {
+		FasitScopeResolution = resolution
+		gock.New("https://fasit.local").Get("/api/v2/resources").Reply(200).BodyString(listing)
+
+		var ids []int
+		for _, resource := range fasit.bulkScopedResources(requests, "t1", "app", "fss") {
+			ids = append(ids, resource.id)
+		}
+		sort.Ints(ids)
+		assert.Equal(t, expected, ids, resolution)
+	}
+}
+
+func TestBulkScopedResourcesFallsBackWhenListingFails(t *testing.T) {
+	defer gock.Off()
+
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	requests := []ResourceRequest{{Alias: "mydb", ResourceType: "DataSource"}, {Alias: "otherdb", ResourceType: "DataSource"}}
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/resources").
+		Reply(400)
+
+	assert.Empty(t, fasit.bulkScopedResources(requests, "t1", "app", "fss"))
+}
@@ -0,0 +1,161 @@
+// Package fasitcontract is the contract between naisd and the Fasit API: the requests naisd makes and the responses it
+// depends on. A Simulator serves a contract as a fake Fasit for naisd's tests, and Verify replays it against a real
+// Fasit, so changes to either side that break the other are found before they reach production deployments.
+package fasitcontract
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Contract is every interaction naisd has with Fasit that it depends on
+type Contract struct {
+	Consumer     string        `json:"consumer"`
+	Provider     string        `json:"provider"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request naisd makes and the response it expects. Only the query parameters and body properties in
+// the request are matched, so naisd may send more than the contract says.
+type Interaction struct {
+	Description string   `json:"description"`
+	Request     Request  `json:"request"`
+	Response    Response `json:"response"`
+}
+
+type Request struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Query  map[string]string `json:"query,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// Response has a JSON Body, or a Text body for responses that are not JSON, e.g. the value of a secret
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Text    string            `json:"text,omitempty"`
+}
+
+// Load reads a contract from a JSON file
+func Load(path string) (Contract, error) {
+	var contract Contract
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return contract, fmt.Errorf("unable to read contract %s: %s", path, err)
+	}
+	if err := json.Unmarshal(data, &contract); err != nil {
+		return contract, fmt.Errorf("unable to unmarshal contract %s: %s", path, err)
+	}
+	return contract, nil
+}
+
+// Find returns the interaction with the description
+func (c Contract) Find(description string) (Interaction, bool) {
+	for _, interaction := range c.Interactions {
+		if interaction.Description == description {
+			return interaction, true
+		}
+	}
+	return Interaction{}, false
+}
+
+// matches is true if the request is the one of the interaction. A trailing slash on the path does not matter.
+func (r Request) matches(method, path string, query url.Values, body []byte) bool {
+	if !strings.EqualFold(r.Method, method) || strings.TrimSuffix(r.Path, "/") != strings.TrimSuffix(path, "/") {
+		return false
+	}
+	for key, value := range r.Query {
+		if query.Get(key) != value {
+			return false
+		}
+	}
+	if len(r.Body) == 0 {
+		return true
+	}
+
+	var expected, actual interface{}
+	if json.Unmarshal(r.Body, &expected) != nil || json.Unmarshal(body, &actual) != nil {
+		return false
+	}
+	return contains(actual, expected)
+}
+
+// contains is true if every property of expected is in actual with the same value. Arrays must be equal.
+func contains(actual, expected interface{}) bool {
+	expectedObject, ok := expected.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(actual, expected)
+	}
+	actualObject, ok := actual.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, value := range expectedObject {
+		if !contains(actualObject[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Simulator is a fake Fasit answering the requests of a contract with their responses. Requests that are not in the
+// contract are answered with 501 Not Implemented and kept, so tests can fail on them.
+type Simulator struct {
+	contract  Contract
+	mutex     sync.Mutex
+	unmatched []string
+}
+
+func NewSimulator(contract Contract) *Simulator {
+	return &Simulator{contract: contract}
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	for _, interaction := range s.contract.Interactions {
+		if !interaction.Request.matches(r.Method, r.URL.Path, r.URL.Query(), body) {
+			continue
+		}
+
+		baseUrl := "http://" + r.Host
+		for name, value := range interaction.Response.Headers {
+			w.Header().Set(name, withBaseUrl(value, baseUrl))
+		}
+		if len(interaction.Response.Body) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(interaction.Response.Status)
+			w.Write([]byte(withBaseUrl(string(interaction.Response.Body), baseUrl)))
+			return
+		}
+		w.WriteHeader(interaction.Response.Status)
+		w.Write([]byte(interaction.Response.Text))
+		return
+	}
+
+	s.mutex.Lock()
+	s.unmatched = append(s.unmatched, r.Method+" "+r.URL.String())
+	s.mutex.Unlock()
+	http.Error(w, "request is not in the contract", http.StatusNotImplemented)
+}
+
+// withBaseUrl replaces {baseUrl} in a response with the url of the simulator, for links naisd follows, e.g. to secrets
+func withBaseUrl(value, baseUrl string) string {
+	return strings.Replace(value, "{baseUrl}", baseUrl, -1)
+}
+
+// Unmatched lists the requests the simulator got that are not in the contract
+func (s *Simulator) Unmatched() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.unmatched...)
+}
@@ -0,0 +1,123 @@
+package fasitcontract
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testContract = Contract{
+	Consumer: "naisd",
+	Provider: "fasit",
+	Interactions: []Interaction{
+		{
+			Description: "get resource",
+			Request:     Request{Method: "GET", Path: "/api/v2/scopedresource", Query: map[string]string{"alias": "mydb"}},
+			Response:    Response{Status: 200, Body: json.RawMessage(`{"id": 1, "alias": "mydb", "secrets": {"password": {"ref": "{baseUrl}/api/v2/secrets/1"}}, "files": []}`)},
+		},
+		{
+			Description: "get secret",
+			Request:     Request{Method: "GET", Path: "/api/v2/secrets/1"},
+			Response:    Response{Status: 200, Text: "hunter2"},
+		},
+		{
+			Description: "create resource",
+			Request:     Request{Method: "POST", Path: "/api/v2/resources/", Body: json.RawMessage(`{"alias": "myapi", "scope": {"environment": "t1"}}`)},
+			Response:    Response{Status: 201, Headers: map[string]string{"Location": "{baseUrl}/api/v2/resources/2"}},
+		},
+	},
+}
+
+func TestSimulator(t *testing.T) {
+	simulator := NewSimulator(testContract)
+	server := httptest.NewServer(simulator)
+	defer server.Close()
+
+	t.Run("answers requests in the contract, with links to itself", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/api/v2/scopedresource?alias=mydb&type=DataSource")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Contains(t, string(body), server.URL+"/api/v2/secrets/1")
+
+		resp, err = http.Get(server.URL + "/api/v2/secrets/1")
+		assert.NoError(t, err)
+		body, _ = ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "hunter2", string(body))
+	})
+
+	t.Run("matches the properties of the body in the contract, ignoring others and the trailing slash", func(t *testing.T) {
+		resp, err := http.Post(server.URL+"/api/v2/resources", "application/json", bytes.NewBufferString(`{"alias": "myapi", "type": "RestService", "scope": {"environmentclass": "t", "environment": "t1"}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, 201, resp.StatusCode)
+		assert.Equal(t, server.URL+"/api/v2/resources/2", resp.Header.Get("Location"))
+		assert.Empty(t, simulator.Unmatched())
+	})
+
+	t.Run("requests that are not in the contract are not implemented", func(t *testing.T) {
+		resp, err := http.Post(server.URL+"/api/v2/resources/", "application/json", bytes.NewBufferString(`{"alias": "myapi", "scope": {"environment": "q1"}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+		resp, err = http.Get(server.URL + "/api/v2/scopedresource?alias=otherdb")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+		assert.Equal(t, []string{"POST /api/v2/resources/", "GET /api/v2/scopedresource?alias=otherdb"}, simulator.Unmatched())
+	})
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("a provider keeping the contract has no failures", func(t *testing.T) {
+		server := httptest.NewServer(NewSimulator(testContract))
+		defer server.Close()
+
+		assert.Empty(t, Verify(testContract, Provider{Url: server.URL}))
+	})
+
+	t.Run("responses of another status or shape fail", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v2/scopedresource":
+				w.Write([]byte(`{"id": "1", "alias": "mydb", "secrets": {"password": {"ref": "https://fasit/api/v2/secrets/1"}}, "files": [], "revision": 3}`))
+			case "/api/v2/secrets/1":
+				w.Write([]byte("hunter2"))
+			default:
+				w.WriteHeader(http.StatusCreated)
+			}
+		}))
+		defer server.Close()
+
+		failures := Verify(testContract, Provider{Url: server.URL})
+		assert.Equal(t, []Failure{
+			{"get resource", "body.id: expected a number, got a string"},
+			{"create resource", "expected header Location"},
+		}, failures)
+	})
+
+	t.Run("unknown provider fails every interaction", func(t *testing.T) {
+		assert.Len(t, Verify(testContract, Provider{Url: "http://localhost:1"}), 3)
+	})
+}
+
+func TestSameShape(t *testing.T) {
+	var expected, actual interface{}
+	json.Unmarshal([]byte(`{"scope": {"zone": "fss"}, "usedresources": [{"id": 1}], "lifecycle": null}`), &expected)
+
+	json.Unmarshal([]byte(`{"scope": {"zone": "sbs", "environment": "t1"}, "usedresources": [{"id": 2}, {"id": 3}], "lifecycle": {}}`), &actual)
+	assert.Empty(t, sameShape("body", actual, expected), "other values and additional properties are the same shape")
+
+	json.Unmarshal([]byte(`{"scope": {}, "usedresources": [], "lifecycle": null}`), &actual)
+	assert.Equal(t, "body.scope: missing property zone", sameShape("body", actual, expected))
+
+	json.Unmarshal([]byte(`{"lifecycle": null, "scope": {"zone": "fss"}, "usedresources": [{"id": "1"}]}`), &actual)
+	assert.Equal(t, "body.usedresources[0].id: expected a number, got a string", sameShape("body", actual, expected))
+
+	json.Unmarshal([]byte(`{"lifecycle": null, "scope": "fss", "usedresources": []}`), &actual)
+	assert.Equal(t, "body.scope: expected an object, got a string", sameShape("body", actual, expected))
+}
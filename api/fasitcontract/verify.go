@@ -0,0 +1,160 @@
+package fasitcontract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var verifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// Provider is the Fasit a contract is verified against. It must have the environments, applications and resources
+// the interactions refer to, and the user must be allowed to change them, as the interactions are replayed as is.
+type Provider struct {
+	Url      string
+	Username string
+	Password string
+}
+
+// Failure is an interaction the provider does not keep
+type Failure struct {
+	Description string
+	Reason      string
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("%s: %s", f.Description, f.Reason)
+}
+
+// Verify replays the interactions of the contract against the provider, in order, and lists those whose response is
+// not the one naisd depends on: a different status, a missing header, or a body missing properties, or with properties
+// of other types, than the contract's. Values are not compared, only the shape of the response.
+func Verify(contract Contract, provider Provider) []Failure {
+	var failures []Failure
+	for _, interaction := range contract.Interactions {
+		if reason := verifyInteraction(interaction, provider); len(reason) > 0 {
+			failures = append(failures, Failure{interaction.Description, reason})
+		}
+	}
+	return failures
+}
+
+func verifyInteraction(interaction Interaction, provider Provider) string {
+	req, err := http.NewRequest(strings.ToUpper(interaction.Request.Method), strings.TrimSuffix(provider.Url, "/")+interaction.Request.Path, bytes.NewReader(interaction.Request.Body))
+	if err != nil {
+		return fmt.Sprintf("unable to create request: %s", err)
+	}
+
+	q := req.URL.Query()
+	for key, value := range interaction.Request.Query {
+		q.Add(key, value)
+	}
+	req.URL.RawQuery = q.Encode()
+	if len(interaction.Request.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(provider.Username) > 0 {
+		req.SetBasicAuth(provider.Username, provider.Password)
+	}
+
+	resp, err := verifyClient.Do(req)
+	if err != nil {
+		return fmt.Sprintf("unable to contact Fasit: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Sprintf("unable to read body: %s", err)
+	}
+
+	if resp.StatusCode != interaction.Response.Status {
+		return fmt.Sprintf("expected HTTP %d, got HTTP %d: %s", interaction.Response.Status, resp.StatusCode, body)
+	}
+	for name := range interaction.Response.Headers {
+		if len(resp.Header.Get(name)) == 0 {
+			return fmt.Sprintf("expected header %s", name)
+		}
+	}
+	if len(interaction.Response.Body) == 0 {
+		if len(interaction.Response.Text) > 0 && len(body) == 0 {
+			return "expected a body"
+		}
+		return ""
+	}
+
+	var expected, actual interface{}
+	if err := json.Unmarshal(interaction.Response.Body, &expected); err != nil {
+		return fmt.Sprintf("the contract's response body is not JSON: %s", err)
+	}
+	if err := json.Unmarshal(body, &actual); err != nil {
+		return fmt.Sprintf("response body is not JSON: %s", err)
+	}
+	return sameShape("body", actual, expected)
+}
+
+// sameShape describes the first difference in shape between actual and expected, in alphabetical order of the
+// properties, or is empty if actual has every property of expected with the same JSON type. Every element of an array
+// must have the shape of the first element expected.
+func sameShape(path string, actual, expected interface{}) string {
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		object, ok := actual.(map[string]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: expected an object, got %s", path, jsonType(actual))
+		}
+		keys := make([]string, 0, len(expected))
+		for key := range expected {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, exists := object[key]
+			if !exists {
+				return fmt.Sprintf("%s: missing property %s", path, key)
+			}
+			if difference := sameShape(path+"."+key, property, expected[key]); len(difference) > 0 {
+				return difference
+			}
+		}
+	case []interface{}:
+		array, ok := actual.([]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: expected an array, got %s", path, jsonType(actual))
+		}
+		if len(expected) == 0 {
+			return ""
+		}
+		for i, element := range array {
+			if difference := sameShape(fmt.Sprintf("%s[%d]", path, i), element, expected[0]); len(difference) > 0 {
+				return difference
+			}
+		}
+	default:
+		if expected != nil && jsonType(actual) != jsonType(expected) {
+			return fmt.Sprintf("%s: expected %s, got %s", path, jsonType(expected), jsonType(actual))
+		}
+	}
+	return ""
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
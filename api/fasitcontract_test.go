@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/naisd/api/fasitcontract"
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+// The contract tests run FasitClient against a simulator serving testdata/fasitcontract.json, the interactions with
+// Fasit naisd depends on. A request that is not in the contract fails the test, so the contract has to be changed along
+// with FasitClient, and the Fasit team verifies their changes against it with fasit-simulator (make fasitsimulator).
+func withFasitSimulator(t *testing.T, test func(fasit FasitClient)) {
+	contract, err := fasitcontract.Load("testdata/fasitcontract.json")
+	assert.NoError(t, err)
+
+	simulator := fasitcontract.NewSimulator(contract)
+	server := httptest.NewServer(simulator)
+	defer server.Close()
+
+	test(FasitClient{FasitUrl: server.URL, Username: "contract", Password: "contract"})
+	assert.Empty(t, simulator.Unmatched(), "requests that are not in the Fasit contract")
+}
+
+func TestFasitContract(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{
+		Application:      "contractapp",
+		Version:          "2.0.0",
+		Zone:             "fss",
+		FasitEnvironment: "t1",
+		FasitUsername:    "contract",
+		FasitPassword:    "contract",
+	}
+	contractdb := ResourceRequest{Alias: "contractdb", ResourceType: "DataSource"}
+
+	t.Run("environment class is read from the environment", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			environmentClass, err := fasit.GetFasitEnvironmentClass("t1")
+			assert.NoError(t, err)
+			assert.Equal(t, "t", environmentClass)
+		})
+	})
+
+	t.Run("application is found", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			assert.NoError(t, fasit.GetFasitApplication("contractapp"))
+		})
+	})
+
+	t.Run("scoped resource is resolved with its secret", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			resource, appErr := fasit.GetScopedResource(contractdb, "t1", "contractapp", "fss")
+			assert.Nil(t, appErr)
+			assert.Equal(t, 4242, resource.Id())
+			assert.Equal(t, "contractapp", resource.Properties()["username"])
+			assert.Equal(t, "hunter2", resource.Secret()["password"])
+		})
+	})
+
+	t.Run("resources of a type are listed", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			resources, appErr := fasit.listResources("list data sources", map[string]string{
+				"type":        "DataSource",
+				"environment": "t1",
+				"application": "contractapp",
+				"zone":        "fss",
+			})
+			assert.Nil(t, appErr)
+			assert.Len(t, resources, 1)
+			assert.Equal(t, "contractdb", resources[0].Alias)
+			assert.Equal(t, "t1", resources[0].Scope.Environment)
+		})
+	})
+
+	t.Run("load balancer config is read", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			resource, err := fasit.GetLoadBalancerConfig("contractapp", "t1")
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{"contractapp.adeo.no": "/contractapp"}, resource.ingresses)
+		})
+	})
+
+	t.Run("resource is created and updated", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			resource := ExposedResource{Alias: "contractapi", ResourceType: "RestService", Path: "/api"}
+
+			id, err := fasit.CreateResource(resource, "t", "t1", "contractapp.nais.local", ResourceMetadata{}, deploymentRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 4444, id)
+
+			id, err = fasit.UpdateResource(NaisResource{id: 4444}, resource, "t", "t1", "contractapp.nais.local", ResourceMetadata{}, deploymentRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 4444, id)
+		})
+	})
+
+	t.Run("previous application instance is stopped", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			instance, err := fasit.GetApplicationInstance("contractapp", "t1")
+			assert.NoError(t, err)
+			assert.Equal(t, &ApplicationInstance{Id: 4545, Version: "1.0.0"}, instance)
+			assert.NoError(t, fasit.StopApplicationInstance(deploymentRequest, "t1", *instance))
+		})
+	})
+
+	t.Run("application instance is registered", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			assert.NoError(t, fasit.CreateApplicationInstance(deploymentRequest, "t1", "contractapp.nais.local", nil, []int{4242}, HealthCheckUrls{}, ApplicationInstanceConfig{}))
+		})
+	})
+
+	t.Run("deployment event is registered", func(t *testing.T) {
+		withFasitSimulator(t, func(fasit FasitClient) {
+			assert.NoError(t, fasit.CreateDeploymentEvent(deploymentRequest, "nais-dev"))
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FasitCredentialsTeamLabel is the label naming the team a Fasit credentials secret belongs to. Only that team's
+// applications can be deployed with it.
+const FasitCredentialsTeamLabel = "team"
+
+// resolveFasitCredentials replaces the fasitCredentialsRef of the request with the username and password in the
+// referenced secret. As the manifest naming the team is written by the caller, the request must be authenticated as an
+// identity acting for the team. Secrets are kept in the namespace given with --fasit-credentials-namespace, so
+// pipelines never need to send passwords to naisd.
+func (api Api) resolveFasitCredentials(r *http.Request, deploymentRequest *naisrequest.Deploy, team string) *appError {
+	if len(deploymentRequest.FasitCredentialsRef) == 0 {
+		return nil
+	}
+
+	if _, appErr := api.authorizeTeam(r, team); appErr != nil {
+		return &appError{appErr.OriginalError, "not authorized to use Fasit credentials", appErr.StatusCode}
+	}
+	return api.readFasitCredentials(deploymentRequest, team)
+}
+
+// readFasitCredentials replaces the fasitCredentialsRef of the request with the username and password in the
+// referenced secret, as long as the secret belongs to the team and the application does not belong to another team.
+// The caller has made sure the request acts for the team.
+func (api Api) readFasitCredentials(deploymentRequest *naisrequest.Deploy, team string) *appError {
+	ref := deploymentRequest.FasitCredentialsRef
+	if len(ref) == 0 {
+		return nil
+	}
+
+	if len(api.FasitCredentialsNamespace) == 0 {
+		return &appError{fmt.Errorf("no namespace for Fasit credentials is configured"), "fasitCredentialsRef is not supported", http.StatusBadRequest}
+	}
+
+	secret, err := api.Clientset.CoreV1().Secrets(api.FasitCredentialsNamespace).Get(ref, k8smeta.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		return &appError{fmt.Errorf("secret %s not found", ref), "unable to read Fasit credentials", http.StatusBadRequest}
+	case err != nil:
+		return &appError{err, "unable to read Fasit credentials", http.StatusInternalServerError}
+	}
+
+	owner := secret.Labels[FasitCredentialsTeamLabel]
+	if len(team) == 0 || owner != team {
+		return &appError{fmt.Errorf("secret %s does not belong to team %q", ref, team), "not authorized to use Fasit credentials", http.StatusForbidden}
+	}
+
+	applicationTeam, err := api.applicationTeam(deploymentRequest.Namespace, deploymentRequest.Application)
+	if err != nil {
+		return &appError{err, "unable to get the team of the application", http.StatusInternalServerError}
+	}
+	if len(applicationTeam) > 0 && applicationTeam != owner {
+		return &appError{fmt.Errorf("%s in %s belongs to team %s, not %s", deploymentRequest.Application, deploymentRequest.Namespace, applicationTeam, owner), "not authorized to use Fasit credentials", http.StatusForbidden}
+	}
+
+	username, password := string(secret.Data["username"]), string(secret.Data["password"])
+	if len(username) == 0 || len(password) == 0 {
+		return &appError{fmt.Errorf("secret %s must have username and password", ref), "unable to read Fasit credentials", http.StatusBadRequest}
+	}
+
+	deploymentRequest.FasitUsername = username
+	deploymentRequest.FasitPassword = password
+	return nil
+}
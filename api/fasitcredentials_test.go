@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func fasitCredentialsSecret(name, team string, data map[string][]byte) *k8score.Secret {
+	return &k8score.Secret{
+		ObjectMeta: k8smeta.ObjectMeta{Name: name, Namespace: "nais", Labels: map[string]string{FasitCredentialsTeamLabel: team}},
+		Data:       data,
+	}
+}
+
+func TestResolveFasitCredentials(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		fasitCredentialsSecret("aura-fasit", "aura", map[string][]byte{"username": []byte("srvaura"), "password": []byte("secret")}),
+		fasitCredentialsSecret("incomplete", "aura", map[string][]byte{"username": []byte("srvaura")}),
+	)
+	api := Api{Clientset: clientset, FasitCredentialsNamespace: "nais", OperatorToken: "operator", Identities: []Identity{
+		{Name: "alice", Teams: []string{"aura"}, Token: "alice-token"},
+		{Name: "bob", Teams: []string{"other"}, Token: "bob-token"},
+	}}
+	alice := requestWithToken("alice-token")
+
+	t.Run("Requests without a reference are left as they are", func(t *testing.T) {
+		request := naisrequest.Deploy{FasitUsername: "user", FasitPassword: "pass"}
+		assert.Nil(t, api.resolveFasitCredentials(alice, &request, "aura"))
+		assert.Equal(t, "user", request.FasitUsername)
+	})
+
+	t.Run("Credentials are read from the team's secret", func(t *testing.T) {
+		request := naisrequest.Deploy{FasitCredentialsRef: "aura-fasit"}
+		assert.Nil(t, api.resolveFasitCredentials(alice, &request, "aura"))
+		assert.Equal(t, "srvaura", request.FasitUsername)
+		assert.Equal(t, "secret", request.FasitPassword)
+	})
+
+	t.Run("Other teams can not use the secret", func(t *testing.T) {
+		request := naisrequest.Deploy{FasitCredentialsRef: "aura-fasit"}
+		appErr := api.resolveFasitCredentials(alice, &request, "other")
+		assert.Equal(t, http.StatusForbidden, appErr.StatusCode)
+		assert.Empty(t, request.FasitPassword)
+
+		appErr = api.resolveFasitCredentials(alice, &request, "")
+		assert.Equal(t, http.StatusForbidden, appErr.StatusCode)
+	})
+
+	t.Run("Only identities of the team can use the secret", func(t *testing.T) {
+		request := naisrequest.Deploy{FasitCredentialsRef: "aura-fasit"}
+		assert.Equal(t, http.StatusUnauthorized, api.resolveFasitCredentials(requestWithToken(""), &request, "aura").StatusCode)
+		assert.Equal(t, http.StatusForbidden, api.resolveFasitCredentials(requestWithToken("bob-token"), &request, "aura").StatusCode, "naming team aura in the manifest is not enough")
+		assert.Empty(t, request.FasitPassword)
+
+		assert.Nil(t, api.resolveFasitCredentials(requestWithToken("operator"), &request, "aura"))
+	})
+
+	t.Run("Applications of other teams can not use the secret", func(t *testing.T) {
+		deployment := &k8sextensions.Deployment{ObjectMeta: createObjectMeta("app", "default", "other")}
+		api := api
+		api.Clientset = fake.NewSimpleClientset(fasitCredentialsSecret("aura-fasit", "aura", map[string][]byte{"username": []byte("srvaura"), "password": []byte("secret")}), deployment)
+
+		request := naisrequest.Deploy{Application: "app", Namespace: "default", FasitCredentialsRef: "aura-fasit"}
+		assert.Equal(t, http.StatusForbidden, api.resolveFasitCredentials(alice, &request, "aura").StatusCode)
+		assert.Empty(t, request.FasitPassword)
+	})
+
+	t.Run("Missing and incomplete secrets are rejected", func(t *testing.T) {
+		request := naisrequest.Deploy{FasitCredentialsRef: "missing"}
+		assert.Equal(t, http.StatusBadRequest, api.resolveFasitCredentials(alice, &request, "aura").StatusCode)
+
+		request = naisrequest.Deploy{FasitCredentialsRef: "incomplete"}
+		assert.Equal(t, http.StatusBadRequest, api.resolveFasitCredentials(alice, &request, "aura").StatusCode)
+	})
+
+	t.Run("References are rejected when no namespace is configured", func(t *testing.T) {
+		request := naisrequest.Deploy{FasitCredentialsRef: "aura-fasit"}
+		noNamespace := api
+		noNamespace.FasitCredentialsNamespace = ""
+		assert.Equal(t, http.StatusBadRequest, noNamespace.resolveFasitCredentials(alice, &request, "aura").StatusCode)
+	})
+}
+
+func TestValidateDeployRequestWithFasitCredentialsRef(t *testing.T) {
+	request := naisrequest.Deploy{Application: "app", Version: "1", Zone: "fss", Namespace: "default", FasitEnvironment: "t1", FasitCredentialsRef: "aura-fasit"}
+	assert.Empty(t, request.Validate())
+
+	request.FasitPassword = "secret"
+	assert.Len(t, request.Validate(), 1)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const (
+	FasitDryRunCreate = "create"
+	FasitDryRunUpdate = "update"
+)
+
+// FasitDryRun is what a deployment would send to Fasit, returned instead of deploying when fasitDryRun is set
+type FasitDryRun struct {
+	Resources           []FasitDryRunChange         `json:"resources"`
+	ApplicationInstance *ApplicationInstancePayload `json:"applicationInstance,omitempty"`
+	Warnings            []string                    `json:"warnings,omitempty"`
+}
+
+// FasitDryRunChange is an exposed resource naisd would create or update. Diff lists the changed properties of a
+// resource that is updated, e.g. "~ url: https://old -> https://new".
+type FasitDryRunChange struct {
+	Operation    string          `json:"operation"`
+	Alias        string          `json:"alias"`
+	ResourceType string          `json:"type"`
+	Id           int             `json:"id,omitempty"`
+	Payload      ResourcePayload `json:"payload"`
+	Diff         []string        `json:"diff,omitempty"`
+}
+
+// dryRunFasitClient reads from Fasit like the client it wraps, but records the writes instead of sending them
+type dryRunFasitClient struct {
+	FasitClientAdapter
+	result *FasitDryRun
+}
+
+func (fasit dryRunFasitClient) CreateResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	payload := withResourceMetadata(buildResourcePayload(resource, NaisResource{}, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname), metadata)
+	fasit.result.Resources = append(fasit.result.Resources, FasitDryRunChange{
+		Operation:    FasitDryRunCreate,
+		Alias:        resource.Alias,
+		ResourceType: resource.ResourceType,
+		Payload:      payload,
+	})
+	// the resource has no id until Fasit has created it
+	return 0, nil
+}
+
+func (fasit dryRunFasitClient) UpdateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	payload := withResourceMetadata(buildResourcePayload(resource, existingResource, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname), metadata)
+	diff, err := resourceDiff(existingResource, payload)
+	if err != nil {
+		return 0, err
+	}
+	fasit.result.Resources = append(fasit.result.Resources, FasitDryRunChange{
+		Operation:    FasitDryRunUpdate,
+		Alias:        resource.Alias,
+		ResourceType: resource.ResourceType,
+		Id:           existingResource.id,
+		Payload:      payload,
+		Diff:         diff,
+	})
+	return existingResource.id, nil
+}
+
+func (fasit dryRunFasitClient) CreateApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks HealthCheckUrls, instance ApplicationInstanceConfig) error {
+	payload := buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds, healthChecks, instance)
+	fasit.result.ApplicationInstance = &payload
+	return nil
+}
+
+func (fasit dryRunFasitClient) CreateDeploymentEvent(deploymentRequest naisrequest.Deploy, clusterName string) error {
+	return nil
+}
+
+func (fasit dryRunFasitClient) StopApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment string, instance ApplicationInstance) error {
+	return nil
+}
+
+// resourceDiff compares the properties of the payload with the ones of the existing resource, one line per changed
+// property, sorted by property name
+func resourceDiff(existingResource NaisResource, payload ResourcePayload) ([]string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create payload (%s)", err)
+	}
+
+	var parsed struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("unable to read payload (%s)", err)
+	}
+
+	properties := make(map[string]string)
+	for key, value := range parsed.Properties {
+		properties[key] = fmt.Sprint(value)
+	}
+
+	var diff []string
+	for key, value := range properties {
+		existing, ok := existingResource.properties[key]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("+ %s: %s", key, value))
+		} else if existing != value {
+			diff = append(diff, fmt.Sprintf("~ %s: %s -> %s", key, existing, value))
+		}
+	}
+	for key, existing := range existingResource.properties {
+		if _, ok := properties[key]; !ok {
+			diff = append(diff, fmt.Sprintf("- %s: %s", key, existing))
+		}
+	}
+
+	sort.Slice(diff, func(i, j int) bool { return diff[i][2:] < diff[j][2:] })
+	return diff, nil
+}
+
+// fasitDryRun answers a deployment request with fasitDryRun set with the changes the deployment would make to Fasit,
+// without changing anything in Fasit or the cluster. The passwords of exposed DataSources are not resolved, so they
+// are left out of the payloads.
+func (api Api) fasitDryRun(w http.ResponseWriter, r *http.Request, deploymentRequest naisrequest.Deploy) *appError {
+	if deploymentRequest.SkipFasit {
+		return &appError{errors.New("fasitDryRun can not be combined with skipFasit"), "invalid Fasit dry run", http.StatusBadRequest}
+	}
+	// neither of these are registered in Fasit, so there is nothing to show
+	if deploymentRequest.Preview != nil || deploymentRequest.Mirror != nil || deploymentRequest.DarkLaunch != nil {
+		return &appError{errors.New("previews, mirrors and dark launches are not registered in Fasit"), "invalid Fasit dry run", http.StatusBadRequest}
+	}
+
+	manifest, err := GenerateManifestWithProfiles(deploymentRequest, api.ManifestProfiles, api.ResourceTemplates)
+	if err != nil {
+		return &appError{err, "unable to generate manifest/nais.yaml", http.StatusBadRequest}
+	}
+	manifest.DefaultEnv = api.DefaultEnv
+
+	if appErr := api.resolveFasitCredentials(r, &deploymentRequest, manifest.Team); appErr != nil {
+		return appErr
+	}
+
+	fasit := api.fasitClient(&deploymentRequest, api.isOperator(r)).withContext(r.Context())
+	if deploymentRequest.BypassFasitCache {
+		fasit = fasit.withoutCache()
+	}
+	fasitBackend := api.fasitBackend(fasit)
+
+	external := isExternal(manifest)
+	result := FasitDryRun{Resources: []FasitDryRunChange{}}
+
+	var fasitEnvironmentClass string
+	if hasResources(manifest) {
+		if deploymentRequest.FasitEnvironment == "" {
+			return &appError{errors.New("no fasit environment provided"), "no fasit environment provided, but contains resources to be consumed or exposed", http.StatusBadRequest}
+		}
+		if fasitEnvironmentClass, err = fasitBackend.GetFasitEnvironmentClass(deploymentRequest.FasitEnvironment); err != nil {
+			return fasitAppError(fasit, err, "unable to get environment class", http.StatusInternalServerError)
+		}
+	}
+
+	if err := ResolveAliasPrefixes(fasitBackend, &manifest, deploymentRequest.FasitEnvironment, deploymentRequest.Application, deploymentRequest.Zone); err != nil {
+		return fasitAppError(fasit, err, "unable to fetch fasit resources", http.StatusBadRequest)
+	}
+	naisResources, err := FetchFasitResources(fasitBackend, deploymentRequest.Application, deploymentRequest.FasitEnvironment, deploymentRequest.Zone, manifest.FasitResources.Used)
+	if err != nil {
+		return fasitAppError(fasit, err, "unable to fetch fasit resources", http.StatusBadRequest)
+	}
+
+	hostname, domain := createIngressHostname(deploymentRequest.Application, deploymentRequest.Namespace, api.ClusterSubdomain), api.ClusterSubdomain
+	if external {
+		hostname, domain = manifest.Hostname, manifest.Hostname
+	}
+
+	if hasResources(manifest) || external {
+		warnings, err := updateFasit(dryRunFasitClient{fasitBackend, &result}, deploymentRequest, naisResources, manifest, hostname, fasitEnvironmentClass, deploymentRequest.FasitEnvironment, domain, api.FasitApplicationInstance)
+		result.Warnings = warnings
+		if err != nil {
+			return fasitAppError(fasit, err, "unable to compute the changes to Fasit", http.StatusBadRequest)
+		}
+	}
+
+	glog.Infof("Fasit dry run of %s:%s in %s: %d resources would be changed\n", deploymentRequest.Application, deploymentRequest.Version, deploymentRequest.FasitEnvironment, len(result.Resources))
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return &appError{err, "unable to marshal Fasit dry run", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	return nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResourceDiff(t *testing.T) {
+	existing := NaisResource{properties: map[string]string{"url": "https://old/api", "owner": "someone"}}
+	payload := RestResourcePayload{Properties: RestProperties{Url: "https://new/api", Description: "my api"}}
+
+	diff, err := resourceDiff(existing, payload)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"+ description: my api", "- owner: someone", "~ url: https://old/api -> https://new/api"}, diff)
+
+	diff, err = resourceDiff(NaisResource{properties: map[string]string{"url": "https://new/api"}}, RestResourcePayload{Properties: RestProperties{Url: "https://new/api"}})
+	assert.NoError(t, err)
+	assert.Empty(t, diff, "unchanged resources have no diff")
+}
+
+func TestDryRunFasitClient(t *testing.T) {
+	createApplicationInstanceCalled = false
+	updateCalled = false
+
+	manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "myapi", ResourceType: "RestService", Path: "/api"}}}}
+	deploymentRequest := naisrequest.Deploy{Application: appName, FasitEnvironment: "t1", Version: "2"}
+	result := FasitDryRun{}
+
+	_, err := updateFasit(dryRunFasitClient{FakeFasitClient{}, &result}, deploymentRequest, []NaisResource{{id: 2}}, manifest, "app.nais.example.no", "t", "t1", "nais.example.no", ApplicationInstanceConfig{ClusterName: "nais-dev"})
+	assert.NoError(t, err)
+	assert.False(t, updateCalled, "nothing is sent to Fasit")
+	assert.False(t, createApplicationInstanceCalled, "nothing is sent to Fasit")
+
+	assert.Len(t, result.Resources, 1)
+	assert.Equal(t, FasitDryRunUpdate, result.Resources[0].Operation)
+	assert.Equal(t, 1, result.Resources[0].Id)
+	assert.Equal(t, []string{"+ url: https://app.nais.example.no/api"}, result.Resources[0].Diff)
+	assert.Equal(t, []Resource{{Id: 1}}, result.ApplicationInstance.ExposedResources)
+	assert.Equal(t, []Resource{{Id: 2}}, result.ApplicationInstance.UsedResources)
+	assert.Equal(t, "nais-dev", result.ApplicationInstance.ClusterName)
+
+	t.Run("Resources that do not exist are to be created", func(t *testing.T) {
+		result := FasitDryRun{}
+		deploymentRequest.Application = "notfound"
+
+		_, err := updateFasit(dryRunFasitClient{FakeFasitClient{}, &result}, deploymentRequest, nil, manifest, "app.nais.example.no", "t", "t1", "nais.example.no", ApplicationInstanceConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, FasitDryRunCreate, result.Resources[0].Operation)
+		assert.Equal(t, 0, result.Resources[0].Id)
+		assert.Empty(t, result.Resources[0].Diff)
+	})
+}
+
+func TestFasitDryRunDeployment(t *testing.T) {
+	manifest := NaisManifest{
+		Image:          "name/Container",
+		Port:           8080,
+		FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "myapi", ResourceType: "RestService", Path: "/api"}}},
+	}
+	data, _ := yaml.Marshal(manifest)
+
+	defer gock.Off()
+	gock.New("http://repo.com").
+		Get("/app").
+		Reply(200).
+		BodyString(string(data))
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/environments/t1").
+		Reply(200).
+		JSON(map[string]string{"environmentclass": "t"})
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", NavTruststoreFasitAlias).
+		Reply(200).File("testdata/fasitTruststoreResponse.json")
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/resources/3024713/file/keystore").
+		Reply(200).
+		BodyString("")
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "myapi").
+		Reply(200).
+		JSON(map[string]interface{}{"id": 4711, "alias": "myapi", "type": "RestService", "properties": map[string]string{"url": "https://old.example.no/api"}})
+
+	clientset := fake.NewSimpleClientset()
+	api := Api{Clientset: clientset, FasitUrl: "https://fasit.local", ClusterSubdomain: "nais.example.no", DeploymentHistory: NewDeploymentHistory()}
+
+	request, _ := json.Marshal(naisrequest.Deploy{
+		Application:      appName,
+		Version:          "2",
+		FasitEnvironment: "t1",
+		FasitUsername:    "user",
+		FasitPassword:    "password",
+		ManifestUrl:      "http://repo.com/app",
+		Zone:             "fss",
+		Namespace:        namespace,
+		FasitDryRun:      true,
+	})
+	req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(string(request)))
+	rr := httptest.NewRecorder()
+	appHandler(api.deploy).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var result FasitDryRun
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+	assert.Len(t, result.Resources, 1)
+	assert.Equal(t, 4711, result.Resources[0].Id)
+	assert.Equal(t, []string{"~ url: https://old.example.no/api -> https://" + createIngressHostname(appName, namespace, "nais.example.no") + "/api"}, result.Resources[0].Diff)
+	assert.Equal(t, "2", result.ApplicationInstance.Version)
+
+	deployment, _ := getExistingDeployment(appName, namespace, clientset)
+	assert.Nil(t, deployment, "nothing is deployed")
+	assert.Empty(t, api.DeploymentHistory.Records())
+
+	t.Run("Dry run without Fasit is rejected", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(`{"application": "app", "skipFasit": true, "fasitDryRun": true}`))
+		rr := httptest.NewRecorder()
+		appHandler(api.deploy).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
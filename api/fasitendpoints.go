@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const DefaultFasitHealthCheckPath = "/api/v2/environments"
+
+type FasitEndpointHealth struct {
+	Zone    string `json:"zone"`
+	Url     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Returns a Fasit client for the zone of the deployment request. The credentials configured for the zone are naisd's
+// own, so they are only used when the deployment request does not carry any and serviceCredentials is set, which is
+// for naisd's own work, e.g. syncing feature toggles, and for requests from the operator.
+func (api Api) fasitClient(deploymentRequest *naisrequest.Deploy, serviceCredentials bool) FasitClient {
+	endpoint, ok := api.FasitEndpoints[deploymentRequest.Zone]
+	if !ok {
+		return FasitClient{FasitUrl: api.FasitUrl, Username: deploymentRequest.FasitUsername, Password: deploymentRequest.FasitPassword, Retry: api.FasitRetryPolicy, Transport: api.FasitTransport}
+	}
+
+	if serviceCredentials && len(deploymentRequest.FasitUsername) == 0 && len(deploymentRequest.FasitPassword) == 0 {
+		deploymentRequest.FasitUsername = endpoint.Username
+		deploymentRequest.FasitPassword = endpoint.Password
+	}
+
+	return FasitClient{FasitUrl: endpoint.Url, Username: deploymentRequest.FasitUsername, Password: deploymentRequest.FasitPassword, Retry: api.FasitRetryPolicy, Transport: api.FasitTransport}
+}
+
+func checkFasitEndpoint(zone string, endpoint FasitEndpoint, transport http.RoundTripper) FasitEndpointHealth {
+	health := FasitEndpointHealth{Zone: zone, Url: endpoint.Url}
+
+	path := endpoint.HealthCheckPath
+	if len(path) == 0 {
+		path = DefaultFasitHealthCheckPath
+	}
+
+	req, err := http.NewRequest("GET", endpoint.Url+path, nil)
+	if err != nil {
+		health.Error = fmt.Sprintf("unable to create request: %s", err)
+		return health
+	}
+
+	if len(endpoint.Username) > 0 {
+		req.SetBasicAuth(endpoint.Username, endpoint.Password)
+	}
+
+	resp, err := fasitHealthClient(transport).Do(req)
+	if err != nil {
+		health.Error = fmt.Sprintf("unable to contact Fasit: %s", err)
+		return health
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		health.Error = fmt.Sprintf("Fasit responded with HTTP %d", resp.StatusCode)
+		return health
+	}
+
+	health.Healthy = true
+	return health
+}
+
+// Checks every configured Fasit endpoint, including the default one which is reported with an empty zone
+func checkFasitEndpoints(defaultUrl string, endpoints map[string]FasitEndpoint, transport http.RoundTripper) []FasitEndpointHealth {
+	var zones []string
+	for zone := range endpoints {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	results := []FasitEndpointHealth{checkFasitEndpoint("", FasitEndpoint{Url: defaultUrl}, transport)}
+	for _, zone := range zones {
+		results = append(results, checkFasitEndpoint(zone, endpoints[zone], transport))
+	}
+
+	return results
+}
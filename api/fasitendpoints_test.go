@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/naisd/api/constant"
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestFasitClientPerZone(t *testing.T) {
+	api := Api{
+		FasitUrl: "https://fasit.local",
+		FasitEndpoints: map[string]FasitEndpoint{
+			constant.ZONE_SBS: {Url: "https://fasit-sbs.local", Username: "srvnaisd", Password: "secret"},
+		},
+	}
+
+	t.Run("Default Fasit is used for zones without an endpoint", func(t *testing.T) {
+		request := naisrequest.Deploy{Zone: constant.ZONE_FSS, FasitUsername: "user", FasitPassword: "pass"}
+		fasit := api.fasitClient(&request, true)
+		assert.Equal(t, "https://fasit.local", fasit.FasitUrl)
+		assert.Equal(t, "user", fasit.Username)
+	})
+
+	t.Run("Zone endpoint and its credentials are used when request has no credentials", func(t *testing.T) {
+		request := naisrequest.Deploy{Zone: constant.ZONE_SBS}
+		fasit := api.fasitClient(&request, true)
+		assert.Equal(t, "https://fasit-sbs.local", fasit.FasitUrl)
+		assert.Equal(t, "srvnaisd", fasit.Username)
+		assert.Equal(t, "secret", fasit.Password)
+		assert.Equal(t, "srvnaisd", request.FasitUsername)
+	})
+
+	t.Run("Zone credentials are only used for naisd's own requests", func(t *testing.T) {
+		request := naisrequest.Deploy{Zone: constant.ZONE_SBS}
+		fasit := api.fasitClient(&request, false)
+		assert.Equal(t, "https://fasit-sbs.local", fasit.FasitUrl)
+		assert.Empty(t, fasit.Username)
+		assert.Empty(t, fasit.Password)
+		assert.Empty(t, request.FasitUsername)
+	})
+
+	t.Run("Credentials from the request take precedence", func(t *testing.T) {
+		request := naisrequest.Deploy{Zone: constant.ZONE_SBS, FasitUsername: "user", FasitPassword: "pass"}
+		fasit := api.fasitClient(&request, true)
+		assert.Equal(t, "https://fasit-sbs.local", fasit.FasitUrl)
+		assert.Equal(t, "user", fasit.Username)
+		assert.Equal(t, "pass", fasit.Password)
+	})
+}
+
+func TestFasitHealth(t *testing.T) {
+	api := Api{
+		FasitUrl: "https://fasit.local",
+		FasitEndpoints: map[string]FasitEndpoint{
+			constant.ZONE_SBS: {Url: "https://fasit-sbs.local", Username: "srvnaisd", Password: "secret", HealthCheckPath: "/selftest"},
+		},
+	}
+
+	t.Run("All endpoints healthy gives 200", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get(DefaultFasitHealthCheckPath).
+			Reply(200)
+		gock.New("https://fasit-sbs.local").
+			Get("/selftest").
+			HeaderPresent("Authorization").
+			Reply(200)
+
+		results := checkFasitEndpoints(api.FasitUrl, api.FasitEndpoints, nil)
+		assert.Len(t, results, 2)
+		assert.True(t, results[0].Healthy)
+		assert.Equal(t, constant.ZONE_SBS, results[1].Zone)
+		assert.True(t, results[1].Healthy)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Unhealthy endpoint gives 503", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get(DefaultFasitHealthCheckPath).
+			Reply(200)
+		gock.New("https://fasit-sbs.local").
+			Get("/selftest").
+			Reply(500)
+
+		req, _ := http.NewRequest("GET", "/fasithealth", nil)
+		rr := httptest.NewRecorder()
+		appHandler(api.fasitHealth).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Fasit responded with HTTP 500")
+	})
+}
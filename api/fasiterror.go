@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FasitError is a request to Fasit that failed, either without a response or with a status that is not a success.
+// Operation says what naisd was doing, e.g. "get resource mydb (DataSource)", and Body is Fasit's explanation.
+type FasitError struct {
+	Operation  string
+	StatusCode int
+	Body       string
+	Err        error
+	Retryable  bool
+}
+
+func newFasitError(operation string, resp *http.Response, body []byte, err error) *FasitError {
+	fasitErr := &FasitError{Operation: operation, Err: err, Body: strings.TrimSpace(string(body))}
+	if resp != nil {
+		fasitErr.StatusCode = resp.StatusCode
+	}
+
+	if err != nil {
+		fasitErr.Retryable = true
+	} else {
+		fasitErr.Retryable = fasitErr.StatusCode >= 500 || fasitErr.StatusCode == http.StatusTooManyRequests
+	}
+	return fasitErr
+}
+
+func (e *FasitError) Error() string {
+	switch {
+	case isFasitUnavailable(e.Err):
+		return fmt.Sprintf("unable to %s: %s", e.Operation, errFasitUnavailable)
+	case e.Err != nil:
+		return fmt.Sprintf("unable to %s: %s", e.Operation, e.Err)
+	case len(e.Body) > 0:
+		return fmt.Sprintf("unable to %s: Fasit returned %d: %s", e.Operation, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("unable to %s: Fasit returned %d", e.Operation, e.StatusCode)
+}
+
+// Code is the status Fasit responded with, 503 if the circuit breaker kept the request from being sent, or 500 if
+// Fasit could not be reached
+func (e *FasitError) Code() int {
+	switch {
+	case isFasitUnavailable(e.Err):
+		return http.StatusServiceUnavailable
+	case e.StatusCode == 0:
+		return http.StatusInternalServerError
+	}
+	return e.StatusCode
+}
+
+// ResponseStatus is the status naisd responds with when a request fails with the error. Clients are told to try again
+// later (503) if Fasit is unavailable or throttling, that naisd's upstream failed (502) if Fasit did, and that they are
+// not allowed (401, 403) if Fasit did not accept their credentials. Other errors, e.g. a resource that does not exist,
+// are responded to with status, which the handler decides.
+func (e *FasitError) ResponseStatus(status int) int {
+	switch {
+	case isFasitUnavailable(e.Err), e.StatusCode == http.StatusTooManyRequests:
+		return http.StatusServiceUnavailable
+	case e.StatusCode == 0, e.StatusCode >= 500:
+		return http.StatusBadGateway
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden:
+		return e.StatusCode
+	}
+	return status
+}
+
+// fasitResponseStatus is the status naisd responds with when a request fails with err, status unless it is from Fasit
+func fasitResponseStatus(err error, status int) int {
+	if fasitErr, ok := err.(*FasitError); ok {
+		return fasitErr.ResponseStatus(status)
+	}
+	return status
+}
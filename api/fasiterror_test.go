@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestFasitError(t *testing.T) {
+	response := func(status int) *http.Response { return &http.Response{StatusCode: status} }
+
+	notFound := newFasitError("get resource mydb (DataSource)", response(404), []byte("not found\n"), nil)
+	assert.EqualError(t, notFound, "unable to get resource mydb (DataSource): Fasit returned 404: not found")
+	assert.Equal(t, http.StatusNotFound, notFound.Code())
+	assert.Equal(t, http.StatusBadRequest, notFound.ResponseStatus(http.StatusBadRequest))
+	assert.False(t, notFound.Retryable)
+
+	forbidden := newFasitError("get resource mydb (DataSource)", response(403), nil, nil)
+	assert.EqualError(t, forbidden, "unable to get resource mydb (DataSource): Fasit returned 403")
+	assert.Equal(t, http.StatusForbidden, forbidden.ResponseStatus(http.StatusBadRequest))
+
+	serverError := newFasitError("create resource myapi (RestService)", response(500), []byte("oops"), nil)
+	assert.Equal(t, http.StatusBadGateway, serverError.ResponseStatus(http.StatusBadRequest))
+	assert.True(t, serverError.Retryable)
+
+	throttled := newFasitError("create resource myapi (RestService)", response(429), nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, throttled.ResponseStatus(http.StatusBadRequest))
+	assert.True(t, throttled.Retryable)
+
+	networkError := newFasitError("get environment t1", nil, nil, errors.New("connection refused"))
+	assert.EqualError(t, networkError, "unable to get environment t1: connection refused")
+	assert.Equal(t, http.StatusInternalServerError, networkError.Code())
+	assert.Equal(t, http.StatusBadGateway, networkError.ResponseStatus(http.StatusBadRequest))
+	assert.True(t, networkError.Retryable)
+
+	unavailable := newFasitError("get environment t1", nil, nil, errFasitUnavailable)
+	assert.Equal(t, http.StatusServiceUnavailable, unavailable.Code())
+	assert.Equal(t, http.StatusServiceUnavailable, unavailable.ResponseStatus(http.StatusBadRequest))
+
+	assert.Equal(t, http.StatusBadRequest, fasitResponseStatus(errors.New("not from Fasit"), http.StatusBadRequest))
+}
+
+func TestFasitErrorsFromOperations(t *testing.T) {
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	defer gock.Off()
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		Reply(403).
+		BodyString("no access to secret")
+	_, err := fasit.GetScopedResources([]ResourceRequest{{Alias: "mydb", ResourceType: "DataSource"}}, "t1", "app", "fss")
+	fasitErr, ok := err.(*FasitError)
+	assert.True(t, ok)
+	assert.Equal(t, "get resource mydb (DataSource)", fasitErr.Operation)
+	assert.Equal(t, "no access to secret", fasitErr.Body)
+
+	gock.New("https://fasit.local").
+		Post("/api/v2/resources").
+		Reply(400).
+		BodyString("invalid scope")
+	_, err = fasit.CreateResource(ExposedResource{Alias: "myapi", ResourceType: "RestService"}, "u", "t1", "app.nais.local", ResourceMetadata{}, naisrequest.Deploy{Zone: "fss"})
+	assert.EqualError(t, err, "unable to create resource myapi (RestService): Fasit returned 400: invalid scope")
+
+	gock.New("https://fasit.local").
+		Put("/api/v2/resources/42").
+		Reply(409).
+		BodyString("changed")
+	_, err = fasit.UpdateResource(NaisResource{id: 42}, ExposedResource{Alias: "myapi", ResourceType: "RestService"}, "u", "t1", "app.nais.local", ResourceMetadata{}, naisrequest.Deploy{Zone: "fss"})
+	assert.Equal(t, http.StatusConflict, err.(*FasitError).StatusCode)
+}
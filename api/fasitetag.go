@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const maxCachedFasitResponses = 1000
+
+// FasitConditionalRequests makes naisd send the ETag of the last response it got for a resource lookup, so Fasit can
+// answer 304 Not Modified instead of sending the same body again
+var FasitConditionalRequests = true
+
+// The lookups whose responses are kept for conditional requests. Other responses are not worth keeping, or change with
+// every request.
+var conditionalFasitPaths = map[string]bool{
+	"/api/v2/scopedresource": true,
+	"/api/v2/resources":      true,
+}
+
+var fasitResponses = newFasitResponseCache()
+
+// fasitResponseKey identifies a lookup by its url and the credentials it was made with, hashed, as Fasit only gives
+// secrets to some users
+type fasitResponseKey struct {
+	url         string
+	credentials string
+}
+
+type cachedFasitResponse struct {
+	etag string
+	body []byte
+}
+
+type fasitResponseCache struct {
+	mutex   sync.Mutex
+	entries map[fasitResponseKey]cachedFasitResponse
+}
+
+func newFasitResponseCache() *fasitResponseCache {
+	return &fasitResponseCache{entries: make(map[fasitResponseKey]cachedFasitResponse)}
+}
+
+func (c *fasitResponseCache) get(key fasitResponseKey) (cachedFasitResponse, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *fasitResponseCache) put(key fasitResponseKey, response cachedFasitResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxCachedFasitResponses {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+
+	c.entries[key] = response
+}
+
+// conditionalKey is the key of the request's response, and false if the request is not one to make conditional
+func (fasit FasitClient) conditionalKey(r *http.Request) (fasitResponseKey, bool) {
+	if !FasitConditionalRequests || r.Method != "GET" || !conditionalFasitPaths[r.URL.Path] {
+		return fasitResponseKey{}, false
+	}
+	return fasitResponseKey{
+		url:         r.URL.String(),
+		credentials: fmt.Sprintf("%x", sha256.Sum256([]byte(fasit.Username+":"+fasit.Password))),
+	}, true
+}
+
+// withETag adds If-None-Match to a lookup naisd has a response for, returning the cached response
+func withETag(r *http.Request, key fasitResponseKey) (cachedFasitResponse, bool) {
+	cached, ok := fasitResponses.get(key)
+	if !ok {
+		return cachedFasitResponse{}, false
+	}
+	r.Header.Set("If-None-Match", cached.etag)
+	return cached, true
+}
+
+// keepETag remembers a successful response that has an ETag
+func keepETag(resp *http.Response, body []byte, key fasitResponseKey) {
+	etag := resp.Header.Get("ETag")
+	if len(etag) == 0 {
+		return
+	}
+	fasitResponses.put(key, cachedFasitResponse{etag: etag, body: body})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestConditionalFasitLookups(t *testing.T) {
+	fasitResponses = newFasitResponseCache()
+	defer func() { fasitResponses = newFasitResponseCache() }()
+	defer gock.Off()
+
+	fasit := FasitClient{FasitUrl: "https://fasit.local", Username: "user", Password: "password"}
+	request := ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}
+	lookup := func() *gock.Request {
+		return gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "mydb")
+	}
+
+	t.Run("A response with an ETag is reused when Fasit answers 304", func(t *testing.T) {
+		lookup().Reply(200).SetHeader("ETag", `"v1"`).BodyString(`{"id": 1, "alias": "mydb", "type": "DataSource", "properties": {"url": "jdbc:v1"}}`)
+		resource, err := fasit.GetScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, err)
+		assert.Equal(t, "jdbc:v1", resource.properties["url"])
+
+		lookup().MatchHeader("If-None-Match", `"v1"`).Reply(304)
+		resource, err = fasit.GetScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, err)
+		assert.Equal(t, "jdbc:v1", resource.properties["url"])
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("A changed resource replaces the kept response", func(t *testing.T) {
+		lookup().MatchHeader("If-None-Match", `"v1"`).Reply(200).SetHeader("ETag", `"v2"`).BodyString(`{"id": 1, "alias": "mydb", "type": "DataSource", "properties": {"url": "jdbc:v2"}}`)
+		resource, err := fasit.GetScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, err)
+		assert.Equal(t, "jdbc:v2", resource.properties["url"])
+
+		lookup().MatchHeader("If-None-Match", `"v2"`).Reply(304)
+		resource, err = fasit.GetScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, err)
+		assert.Equal(t, "jdbc:v2", resource.properties["url"])
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Responses are kept per user", func(t *testing.T) {
+		key, _ := fasit.conditionalKey(scopedResourceRequest(t, fasit))
+		_, ok := fasitResponses.get(key)
+		assert.True(t, ok)
+
+		other := FasitClient{FasitUrl: "https://fasit.local", Username: "other", Password: "password"}
+		key, _ = other.conditionalKey(scopedResourceRequest(t, other))
+		_, ok = fasitResponses.get(key)
+		assert.False(t, ok)
+	})
+
+	t.Run("Only lookups are conditional", func(t *testing.T) {
+		req, _ := fasit.buildRequest("PUT", "/api/v2/resources/1", nil)
+		_, conditional := fasit.conditionalKey(req)
+		assert.False(t, conditional)
+
+		req, _ = fasit.buildRequest("GET", "/api/v2/secrets/1", nil)
+		_, conditional = fasit.conditionalKey(req)
+		assert.False(t, conditional)
+	})
+}
+
+func scopedResourceRequest(t *testing.T, fasit FasitClient) *http.Request {
+	req, err := fasit.buildRequest("GET", "/api/v2/scopedresource", map[string]string{
+		"alias":       "mydb",
+		"type":        "DataSource",
+		"environment": "t1",
+		"application": "app",
+		"zone":        "fss",
+	})
+	assert.NoError(t, err)
+	return req
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// createDeploymentEventAfterRollout registers the deployment event in Fasit once the rollout of a deployment that did
+// not wait for it has succeeded. The request has returned by then, so a failure can only be logged, and the client
+// must not be the deployment's, which is cancelled when the deployment finishes.
+func (api Api) createDeploymentEventAfterRollout(fasit FasitClientAdapter, deploymentRequest naisrequest.Deploy) {
+	deadline := time.Now().Add(defaultRolloutTimeout)
+
+	for time.Now().Before(deadline) {
+		status, view, err := api.DeploymentStatusViewer.DeploymentStatusView(deploymentRequest.Namespace, deploymentRequest.Application)
+		switch {
+		case err == nil && status == Success:
+			if err := fasit.CreateDeploymentEvent(deploymentRequest, api.ClusterName); err != nil {
+				glog.Warningf("best-effort step %s failed: unable to register deployment event in Fasit: %s", StepFasitEvent, err)
+				bestEffortFailures.WithLabelValues(StepFasitEvent).Inc()
+			}
+			return
+		case err == nil && status == Failed:
+			glog.Warningf("rollout of %s in %s failed, no deployment event is registered in Fasit: %s", deploymentRequest.Application, deploymentRequest.Namespace, view.Reason)
+			return
+		}
+		time.Sleep(rolloutPollInterval)
+	}
+
+	glog.Warningf("rollout of %s in %s did not finish within %s, no deployment event is registered in Fasit", deploymentRequest.Application, deploymentRequest.Namespace, defaultRolloutTimeout)
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestCreateDeploymentEventAfterRollout(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version, FasitEnvironment: "t1"}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+
+	t.Run("Event is posted when the rollout succeeds", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Post("/api/v2/events").
+			Reply(201)
+
+		api := Api{ClusterName: "prod-fss", DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: Success}}
+		api.createDeploymentEventAfterRollout(fasit, deploymentRequest)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("No event is posted when the rollout fails", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Post("/api/v2/events").
+			Reply(201)
+
+		api := Api{ClusterName: "prod-fss", DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: Failed}}
+		api.createDeploymentEventAfterRollout(fasit, deploymentRequest)
+		assert.False(t, gock.IsDone())
+	})
+}
@@ -0,0 +1,369 @@
+// Package fasitfake is an in-memory Fasit, for testing code that uses naisd's Fasit functions without a Fasit to talk to.
+package fasitfake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nais/naisd/api"
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// Names of the methods of api.FasitClientAdapter, as recorded in Call and given to Fail
+const (
+	GetScopedResource         = "GetScopedResource"
+	CreateResource            = "CreateResource"
+	UpdateResource            = "UpdateResource"
+	GetFasitEnvironmentClass  = "GetFasitEnvironmentClass"
+	GetFasitApplication       = "GetFasitApplication"
+	GetScopedResources        = "GetScopedResources"
+	FindResourceAliases       = "FindResourceAliases"
+	GetLoadBalancerConfig     = "GetLoadBalancerConfig"
+	CreateApplicationInstance = "CreateApplicationInstance"
+	CreateDeploymentEvent     = "CreateDeploymentEvent"
+	GetApplicationInstance    = "GetApplicationInstance"
+	StopApplicationInstance   = "StopApplicationInstance"
+)
+
+// Resource is a resource in the fake Fasit. Secrets and Certificates hold the resolved secrets and files, as naisd
+// would have downloaded them from Fasit. A resource with no Environment is found in every environment.
+type Resource struct {
+	Id           int
+	Alias        string
+	Type         string
+	Environment  string
+	Properties   map[string]string
+	Secrets      map[string]string
+	Certificates map[string][]byte
+}
+
+// Call is a call to the fake, with its arguments in the order of the method
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Error is returned by the fake, e.g. a 404 for a resource it does not have
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+func (e Error) Code() int {
+	return e.Status
+}
+
+// Client is an api.FasitClientAdapter that answers from the resources, environments and applications it has been given,
+// and records every call. Resources created by naisd are added to it, so later lookups find them. It is safe for
+// concurrent use.
+type Client struct {
+	mutex              sync.Mutex
+	resources          []Resource
+	environmentClasses map[string]string
+	applications       map[string]bool
+	loadBalancerConfig map[string]map[string]string
+	instances          map[string]api.ApplicationInstance
+	failures           map[string]error
+	calls              []Call
+	nextId             int
+}
+
+var _ api.FasitClientAdapter = &Client{}
+
+// New returns a fake Fasit with no resources, environments or applications
+func New() *Client {
+	return &Client{
+		environmentClasses: make(map[string]string),
+		applications:       make(map[string]bool),
+		loadBalancerConfig: make(map[string]map[string]string),
+		instances:          make(map[string]api.ApplicationInstance),
+		failures:           make(map[string]error),
+		nextId:             1000,
+	}
+}
+
+// AddResource adds a resource, giving it an id if it has none, and returns the id
+func (c *Client) AddResource(resource Resource) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if resource.Id == 0 {
+		resource.Id = c.newId()
+	}
+	c.resources = append(c.resources, resource)
+	return resource.Id
+}
+
+// AddEnvironment adds an environment of the environment class, e.g. t1 in t
+func (c *Client) AddEnvironment(environment, environmentClass string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.environmentClasses[environment] = environmentClass
+}
+
+// AddApplication adds an application, so naisd may deploy it
+func (c *Client) AddApplication(application string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.applications[application] = true
+}
+
+// SetLoadBalancerConfig sets the ingresses, host to context root, of the application's load balancer config
+func (c *Client) SetLoadBalancerConfig(application, environment string, ingresses map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.loadBalancerConfig[application+"/"+environment] = ingresses
+}
+
+// SetApplicationInstance registers an application instance of the version, as if an earlier deployment had, and returns
+// its id
+func (c *Client) SetApplicationInstance(application, environment, version string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id := c.newId()
+	c.instances[application+"/"+environment] = api.ApplicationInstance{Id: id, Version: version}
+	return id
+}
+
+// Fail makes every later call to the method return err, until Fail is called again with a nil error
+func (c *Client) Fail(method string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err == nil {
+		delete(c.failures, method)
+		return
+	}
+	c.failures[method] = err
+}
+
+// Resources returns the resources of the fake, including the ones created by naisd
+func (c *Client) Resources() []Resource {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Resource{}, c.resources...)
+}
+
+// Calls returns the calls made to the fake in the order they were made, optionally only the ones to some methods
+func (c *Client) Calls(methods ...string) []Call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	calls := []Call{}
+	for _, call := range c.calls {
+		if len(methods) == 0 || contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *Client) GetScopedResource(resourcesRequest api.ResourceRequest, environment, application, zone string) (api.NaisResource, api.AppError) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(GetScopedResource, resourcesRequest, environment, application, zone); err != nil {
+		if appErr, ok := err.(api.AppError); ok {
+			return api.NaisResource{}, appErr
+		}
+		return api.NaisResource{}, Error{http.StatusInternalServerError, err.Error()}
+	}
+
+	return c.getScopedResource(resourcesRequest, environment)
+}
+
+func (c *Client) CreateResource(resource api.ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata api.ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(CreateResource, resource, fasitEnvironmentClass, environment, hostname, metadata, deploymentRequest); err != nil {
+		return 0, err
+	}
+
+	id := c.newId()
+	c.resources = append(c.resources, Resource{
+		Id:          id,
+		Alias:       resource.Alias,
+		Type:        resource.ResourceType,
+		Environment: environment,
+		Properties:  map[string]string{"url": "https://" + hostname + resource.Path},
+	})
+	return id, nil
+}
+
+func (c *Client) UpdateResource(existingResource api.NaisResource, resource api.ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata api.ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(UpdateResource, existingResource, resource, fasitEnvironmentClass, environment, hostname, metadata, deploymentRequest); err != nil {
+		return 0, err
+	}
+	return existingResource.Id(), nil
+}
+
+func (c *Client) GetFasitEnvironmentClass(environmentName string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(GetFasitEnvironmentClass, environmentName); err != nil {
+		return "", err
+	}
+
+	environmentClass, ok := c.environmentClasses[environmentName]
+	if !ok {
+		return "", Error{http.StatusNotFound, fmt.Sprintf("environment %s not found", environmentName)}
+	}
+	return environmentClass, nil
+}
+
+func (c *Client) GetFasitApplication(application string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(GetFasitApplication, application); err != nil {
+		return err
+	}
+
+	if !c.applications[application] {
+		return fmt.Errorf("could not find application %s in Fasit", application)
+	}
+	return nil
+}
+
+// GetScopedResources gets each of the resources, skipping optional resources that are not found, like api.FasitClient
+func (c *Client) GetScopedResources(resourcesRequests []api.ResourceRequest, environment string, application string, zone string) ([]api.NaisResource, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(GetScopedResources, resourcesRequests, environment, application, zone); err != nil {
+		return nil, err
+	}
+
+	var resources []api.NaisResource
+	for _, request := range resourcesRequests {
+		resource, appErr := c.getScopedResource(request, environment)
+		if appErr != nil && request.Optional && appErr.Code() == http.StatusNotFound {
+			continue
+		}
+		if appErr != nil {
+			return []api.NaisResource{}, fmt.Errorf("unable to get resource %s (%s). %s", request.Alias, request.ResourceType, appErr)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func (c *Client) FindResourceAliases(prefix, resourceType, environment, application, zone string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(FindResourceAliases, prefix, resourceType, environment, application, zone); err != nil {
+		return nil, err
+	}
+
+	var aliases []string
+	for _, resource := range c.resources {
+		if strings.HasPrefix(resource.Alias, prefix) && strings.EqualFold(resource.Type, resourceType) && inEnvironment(resource, environment) {
+			aliases = append(aliases, resource.Alias)
+		}
+	}
+	return aliases, nil
+}
+
+func (c *Client) GetLoadBalancerConfig(application string, environment string) (*api.NaisResource, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(GetLoadBalancerConfig, application, environment); err != nil {
+		return nil, err
+	}
+
+	ingresses, ok := c.loadBalancerConfig[application+"/"+environment]
+	if !ok {
+		return nil, nil
+	}
+	resource := api.NewLoadBalancerConfig(ingresses)
+	return &resource, nil
+}
+
+func (c *Client) CreateApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks api.HealthCheckUrls, instance api.ApplicationInstanceConfig) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.record(CreateApplicationInstance, deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds, healthChecks); err != nil {
+		return err
+	}
+
+	c.instances[deploymentRequest.Application+"/"+fasitEnvironment] = api.ApplicationInstance{Id: c.newId(), Version: deploymentRequest.Version}
+	return nil
+}
+
+func (c *Client) CreateDeploymentEvent(deploymentRequest naisrequest.Deploy, clusterName string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.record(CreateDeploymentEvent, deploymentRequest, clusterName)
+}
+
+func (c *Client) GetApplicationInstance(application, environment string) (*api.ApplicationInstance, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.record(GetApplicationInstance, application, environment); err != nil {
+		return nil, err
+	}
+
+	instance, ok := c.instances[application+"/"+environment]
+	if !ok {
+		return nil, nil
+	}
+	return &instance, nil
+}
+
+func (c *Client) StopApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment string, instance api.ApplicationInstance) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.record(StopApplicationInstance, deploymentRequest, fasitEnvironment, instance)
+}
+
+// record records the call, and returns the error the method has been told to fail with. The lock must be held.
+func (c *Client) record(method string, args ...interface{}) error {
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+	return c.failures[method]
+}
+
+func (c *Client) getScopedResource(request api.ResourceRequest, environment string) (api.NaisResource, api.AppError) {
+	for _, resource := range c.resources {
+		if resource.Alias == request.Alias && strings.EqualFold(resource.Type, request.ResourceType) && inEnvironment(resource, environment) {
+			properties := make(map[string]string, len(resource.Properties))
+			for key, value := range resource.Properties {
+				properties[key] = value
+			}
+			fasitResource := api.FasitResource{Id: resource.Id, Alias: resource.Alias, ResourceType: resource.Type, Properties: properties}
+			return api.NewNaisResource(fasitResource, request.PropertyMap, resource.Secrets, resource.Certificates), nil
+		}
+	}
+	return api.NaisResource{}, Error{http.StatusNotFound, fmt.Sprintf("resource %s (%s) not found in %s", request.Alias, request.ResourceType, environment)}
+}
+
+func (c *Client) newId() int {
+	c.nextId++
+	return c.nextId
+}
+
+func inEnvironment(resource Resource, environment string) bool {
+	return len(resource.Environment) == 0 || resource.Environment == environment
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
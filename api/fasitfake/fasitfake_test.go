@@ -0,0 +1,109 @@
+package fasitfake
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nais/naisd/api"
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetScopedResource(t *testing.T) {
+	fasit := New()
+	id := fasit.AddResource(Resource{Alias: "mydb", Type: "DataSource", Environment: "t1", Properties: map[string]string{"url": "jdbc:oracle:thin:@db"}, Secrets: map[string]string{"password": "secret"}})
+
+	resource, appErr := fasit.GetScopedResource(api.ResourceRequest{Alias: "mydb", ResourceType: "datasource"}, "t1", "app", "fss")
+	assert.Nil(t, appErr)
+	assert.Equal(t, id, resource.Id())
+	assert.Equal(t, "jdbc:oracle:thin:@db", resource.Properties()["url"])
+	assert.Equal(t, "secret", resource.Secret()["password"])
+
+	_, appErr = fasit.GetScopedResource(api.ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}, "q1", "app", "fss")
+	assert.Equal(t, http.StatusNotFound, appErr.Code(), "resources are scoped to their environment")
+
+	assert.Len(t, fasit.Calls(GetScopedResource), 2)
+}
+
+func TestFetchFasitResources(t *testing.T) {
+	fasit := New()
+	fasit.AddResource(Resource{Alias: api.NavTruststoreFasitAlias, Type: "certificate", Certificates: map[string][]byte{"keystore": []byte("truststore")}})
+	fasit.AddResource(Resource{Alias: "myapi", Type: "RestService", Environment: "t1", Properties: map[string]string{"url": "https://myapi"}})
+	fasit.SetLoadBalancerConfig("app", "t1", map[string]string{"app.example.no": "app"})
+
+	resources, err := api.FetchFasitResources(fasit, "app", "t1", "fss", []api.UsedResource{
+		{Alias: "myapi", ResourceType: "RestService"},
+		{Alias: "optional", ResourceType: "RestService", Optional: true},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 3, "the truststore, myapi and the load balancer config, but not the missing optional resource")
+
+	t.Run("Failures are returned to naisd", func(t *testing.T) {
+		fasit.Fail(GetScopedResources, errors.New("Fasit is down"))
+		_, err := api.FetchFasitResources(fasit, "app", "t1", "fss", nil)
+		assert.EqualError(t, err, "Fasit is down")
+
+		fasit.Fail(GetScopedResources, nil)
+		_, err = api.FetchFasitResources(fasit, "app", "t1", "fss", nil)
+		assert.NoError(t, err)
+	})
+}
+
+func TestCreateOrUpdateFasitResources(t *testing.T) {
+	fasit := New()
+	existing := fasit.AddResource(Resource{Alias: "existing", Type: "RestService", Environment: "t1"})
+	exposed := []api.ExposedResource{{Alias: "existing", ResourceType: "RestService", Path: "/api"}, {Alias: "new", ResourceType: "RestService", Path: "/new"}}
+
+	ids, _, err := api.CreateOrUpdateFasitResources(fasit, exposed, "app.example.no", "t", "t1", "team", naisrequest.Deploy{Application: "app", Zone: "fss"})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 2)
+	assert.Equal(t, existing, ids[0])
+
+	assert.Len(t, fasit.Calls(UpdateResource), 1)
+	created := fasit.Calls(CreateResource)
+	assert.Len(t, created, 1)
+	assert.Equal(t, "new", created[0].Args[0].(api.ExposedResource).Alias)
+
+	resource, appErr := fasit.GetScopedResource(api.ResourceRequest{Alias: "new", ResourceType: "RestService"}, "t1", "app", "fss")
+	assert.Nil(t, appErr, "created resources can be looked up")
+	assert.Equal(t, "https://app.example.no/new", resource.Properties()["url"])
+}
+
+func TestEnvironmentsAndApplications(t *testing.T) {
+	fasit := New()
+	fasit.AddEnvironment("t1", "t")
+	fasit.AddApplication("app")
+
+	environmentClass, err := fasit.GetFasitEnvironmentClass("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "t", environmentClass)
+	_, err = fasit.GetFasitEnvironmentClass("q1")
+	assert.Error(t, err)
+
+	assert.NoError(t, fasit.GetFasitApplication("app"))
+	assert.Error(t, fasit.GetFasitApplication("other"))
+}
+
+func TestApplicationInstances(t *testing.T) {
+	fasit := New()
+	deploymentRequest := naisrequest.Deploy{Application: "app", Version: "2.0.0"}
+
+	instance, err := fasit.GetApplicationInstance("app", "t1")
+	assert.NoError(t, err)
+	assert.Nil(t, instance)
+
+	id := fasit.SetApplicationInstance("app", "t1", "1.0.0")
+	instance, err = fasit.GetApplicationInstance("app", "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, &api.ApplicationInstance{Id: id, Version: "1.0.0"}, instance)
+
+	assert.NoError(t, fasit.CreateApplicationInstance(deploymentRequest, "t1", "app.nais.local", nil, nil, api.HealthCheckUrls{}, api.ApplicationInstanceConfig{}))
+	registered, err := fasit.GetApplicationInstance("app", "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "2.0.0", registered.Version)
+	assert.NotEqual(t, id, registered.Id, "registering a new version is a new instance")
+
+	assert.NoError(t, fasit.StopApplicationInstance(deploymentRequest, "t1", *instance))
+	assert.Len(t, fasit.Calls(StopApplicationInstance), 1)
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	ver "github.com/nais/naisd/api/version"
+)
+
+const RequestIdHeader = "X-Request-ID"
+
+// Requests for deployments without a deadline are not retried if Fasit asks for a longer delay than this
+var maxFasitRetryDelay = time.Minute
+
+// RetryPolicy decides how often and how soon a request that failed is sent again. The delay starts at Backoff and
+// doubles for every retry up to MaxBackoff, and is varied randomly by up to Jitter (e.g. 0.2 for 20%) so that
+// deployments waiting for the same Fasit do not retry in step.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+// delay returns how long to wait before retry number attempt+1
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 0; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (2*mathrand.Float64() - 1))
+	}
+	return delay
+}
+
+// Headers Fasit and the proxies in front of it use to correlate a request with their own logs
+var fasitCorrelationHeaders = []string{RequestIdHeader, "X-Correlation-ID", "X-Trace-ID"}
+
+// FasitUserAgent is sent with every request to Fasit, so the Fasit team can tell which naisd is calling
+var FasitUserAgent = DefaultFasitUserAgent("")
+
+// DefaultFasitUserAgent identifies naisd, its version and the cluster it runs in
+func DefaultFasitUserAgent(clusterName string) string {
+	version := ver.Version
+	if len(version) == 0 {
+		version = "unknown"
+	}
+
+	userAgent := "naisd/" + version
+	if len(ver.Revision) > 0 {
+		userAgent += " (" + ver.Revision + ")"
+	}
+	if len(clusterName) > 0 {
+		userAgent += " cluster/" + clusterName
+	}
+	return userAgent
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// fasitTransport identifies naisd on every request to Fasit and logs how Fasit can find the request again. Requests
+// that only read, e.g. GET, and fail with a network error or a 5xx response are retried according to the retry policy,
+// and when Fasit is throttling them after the delay Fasit asks for, as long as the deployment has time for it. Requests
+// that change Fasit are never sent twice, as Fasit may have acted on the first one. Health checks are not retried, so
+// they report failures as soon as Fasit has them, and are not stopped by the circuit breaker.
+type fasitTransport struct {
+	retry          RetryPolicy
+	circuitBreaker bool
+	// base sends the requests, http.DefaultTransport if nil
+	base http.RoundTripper
+}
+
+func (t fasitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := new(http.Request)
+	*req = *r
+	req.Header = make(http.Header, len(r.Header)+2)
+	for key, values := range r.Header {
+		req.Header[key] = values
+	}
+
+	req.Header.Set("User-Agent", FasitUserAgent)
+	if len(req.Header.Get(RequestIdHeader)) == 0 {
+		req.Header.Set(RequestIdHeader, newRequestId())
+	}
+	req = withConnectionTrace(req)
+
+	if !t.circuitBreaker {
+		return t.send(req)
+	}
+
+	breaker := fasitBreakers.forHost(req.URL.Host)
+	if !breaker.allow(FasitCircuitBreaker, time.Now()) {
+		return nil, errFasitUnavailable
+	}
+
+	resp, err := t.send(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		breaker.release()
+	case err != nil || (resp.StatusCode >= 500 && !throttled(resp)):
+		breaker.record(FasitCircuitBreaker, false, time.Now())
+	default:
+		breaker.record(FasitCircuitBreaker, true, time.Now())
+	}
+	return resp, err
+}
+
+// send sends the request, retrying it as long as the retry policy allows. Every attempt waits for FasitRateLimit.
+func (t fasitTransport) send(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := fasitRateLimiter.wait(req.Context(), FasitRateLimit); err != nil {
+			return nil, err
+		}
+		resp, err := fasitBaseTransport(t.base).RoundTrip(req)
+
+		application := ""
+		if deployment := fasitDeployment(req); deployment != nil {
+			application = deployment.Application
+		}
+
+		var reason string
+		switch {
+		case err != nil && req.Context().Err() != nil:
+			return nil, err
+		case err != nil:
+			glog.Warningf("fasit request %s %s failed (%s %s): %s", req.Method, req.URL, RequestIdHeader, req.Header.Get(RequestIdHeader), err)
+			reason = "network_error"
+		case throttled(resp):
+			glog.Infof("fasit request %s %s returned %d (%s)", req.Method, req.URL, resp.StatusCode, correlationIds(req, resp))
+			fasitThrottled.WithLabelValues(application).Inc()
+			reason = "throttled"
+		case resp.StatusCode >= 500:
+			glog.Warningf("fasit request %s %s returned %d (%s)", req.Method, req.URL, resp.StatusCode, correlationIds(req, resp))
+			reason = "server_error"
+		default:
+			glog.Infof("fasit request %s %s returned %d (%s)", req.Method, req.URL, resp.StatusCode, correlationIds(req, resp))
+			return resp, nil
+		}
+
+		if !idempotent(req.Method) {
+			return resp, err
+		}
+
+		delay := t.retry.delay(attempt)
+		if reason == "throttled" {
+			delay = retryAfter(resp, time.Now(), delay)
+		}
+		if attempt >= t.retry.MaxRetries || !canRetry(req, delay) {
+			glog.Warningf("fasit request %s %s for %s gave up after %d attempts (%s)", req.Method, req.URL, application, attempt+1, reason)
+			return resp, err
+		}
+
+		glog.Infof("retrying fasit request %s %s for %s in %s (%s)", req.Method, req.URL, application, delay, reason)
+		fasitRetries.WithLabelValues(reason).Inc()
+		if resp != nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// idempotent is true for the methods naisd only reads from Fasit with
+func idempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func throttled(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusServiceUnavailable && len(resp.Header.Get("Retry-After")) > 0)
+}
+
+// Returns the delay given by the Retry-After header of the response, in seconds or as a date, or backoff without one
+func retryAfter(resp *http.Response, now time.Time, backoff time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return backoff
+}
+
+// A request is only retried if its body can be sent again, and the delay ends before the deadline of the deployment
+func canRetry(req *http.Request, delay time.Duration) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if deployment := fasitDeployment(req); deployment != nil {
+		if deadline, ok := deployment.context().Deadline(); ok {
+			return time.Now().Add(delay).Before(deadline)
+		}
+	}
+	return delay <= maxFasitRetryDelay
+}
+
+type fasitDeploymentKey struct{}
+
+// Returns the deployment a request to Fasit is made for, if any
+func fasitDeployment(req *http.Request) *trackedDeployment {
+	return fasitDeploymentOf(req.Context())
+}
+
+func fasitDeploymentOf(ctx context.Context) *trackedDeployment {
+	if ctx == nil {
+		return nil
+	}
+	deployment, _ := ctx.Value(fasitDeploymentKey{}).(*trackedDeployment)
+	return deployment
+}
+
+// forDeployment returns a client whose requests are cancelled with the deployment, retried within its deadline, and
+// counted for its application when Fasit is throttling. The client keeps the deadline the deployment has when it is
+// created, so limit the deployment first.
+func (fasit FasitClient) forDeployment(deployment *trackedDeployment) FasitClient {
+	fasit.ctx = context.WithValue(deployment.context(), fasitDeploymentKey{}, deployment)
+	return fasit
+}
+
+// withContext returns a client whose requests are cancelled along with ctx, e.g. when the client calling naisd goes away
+func (fasit FasitClient) withContext(ctx context.Context) FasitClient {
+	fasit.ctx = ctx
+	return fasit
+}
+
+func (fasit FasitClient) do(req *http.Request) (*http.Response, error) {
+	if fasit.ctx != nil {
+		req = req.WithContext(fasit.ctx)
+	}
+	return fasitHttpClient(fasit.Retry, fasit.Transport).Do(req)
+}
+
+// Lists the correlation headers of the response, falling back to the request id naisd sent
+func correlationIds(req *http.Request, resp *http.Response) string {
+	ids := ""
+	for _, header := range fasitCorrelationHeaders {
+		if value := resp.Header.Get(header); len(value) > 0 {
+			if len(ids) > 0 {
+				ids += ", "
+			}
+			ids += fmt.Sprintf("%s %s", header, value)
+		}
+	}
+
+	if len(ids) == 0 {
+		return fmt.Sprintf("%s %s", RequestIdHeader, req.Header.Get(RequestIdHeader))
+	}
+	return ids
+}
+
+// fasitHttpClient returns a client retrying requests to Fasit according to the policy, sending them with the transport
+func fasitHttpClient(retry RetryPolicy, transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: fasitTransport{retry: retry, circuitBreaker: true, base: transport}}
+}
+
+func fasitHealthClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: fasitTransport{base: transport}}
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	ver "github.com/nais/naisd/api/version"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mocked Fasit responses are only given once, so failures do not open the circuit breaker unless a test asks for it
+func TestMain(m *testing.M) {
+	FasitCircuitBreaker = CircuitBreakerPolicy{}
+	os.Exit(m.Run())
+}
+
+func TestDefaultFasitUserAgent(t *testing.T) {
+	version, revision := ver.Version, ver.Revision
+	defer func() { ver.Version, ver.Revision = version, revision }()
+
+	ver.Version, ver.Revision = "", ""
+	assert.Equal(t, "naisd/unknown", DefaultFasitUserAgent(""))
+
+	ver.Version, ver.Revision = "1.2.3", "abc123"
+	assert.Equal(t, "naisd/1.2.3 (abc123) cluster/preprod-fss", DefaultFasitUserAgent("preprod-fss"))
+}
+
+func TestFasitRequestsIdentifyNaisd(t *testing.T) {
+	userAgent := FasitUserAgent
+	defer func() { FasitUserAgent = userAgent }()
+	FasitUserAgent = "naisd/1.2.3 cluster/preprod-fss"
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.Header().Set("X-Correlation-ID", "fasit-42")
+	}))
+	defer server.Close()
+
+	t.Run("user agent and a new request id are sent", func(t *testing.T) {
+		resp, err := fasitHttpClient(RetryPolicy{}, nil).Get(server.URL)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "naisd/1.2.3 cluster/preprod-fss", received.Get("User-Agent"))
+		assert.Len(t, received.Get(RequestIdHeader), 32)
+	})
+
+	t.Run("every request gets its own request id", func(t *testing.T) {
+		resp, _ := fasitHttpClient(RetryPolicy{}, nil).Get(server.URL)
+		resp.Body.Close()
+		first := received.Get(RequestIdHeader)
+		resp, _ = fasitHttpClient(RetryPolicy{}, nil).Get(server.URL)
+		resp.Body.Close()
+
+		assert.NotEqual(t, first, received.Get(RequestIdHeader))
+	})
+
+	t.Run("an existing request id is kept", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set(RequestIdHeader, "deploy-1")
+
+		resp, err := fasitHttpClient(RetryPolicy{}, nil).Do(req)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "deploy-1", received.Get(RequestIdHeader))
+	})
+}
+
+func TestCorrelationIds(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://fasit.local", nil)
+	req.Header.Set(RequestIdHeader, "abc")
+
+	t.Run("falls back to the request id sent", func(t *testing.T) {
+		assert.Equal(t, "X-Request-ID abc", correlationIds(req, &http.Response{Header: http.Header{}}))
+	})
+
+	t.Run("lists the correlation headers Fasit returned", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("X-Request-ID", "abc")
+		header.Set("X-Correlation-ID", "fasit-42")
+
+		assert.Equal(t, "X-Request-ID abc, X-Correlation-ID fasit-42", correlationIds(req, &http.Response{Header: header}))
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	response := func(retryAfter string) *http.Response {
+		header := http.Header{}
+		if len(retryAfter) > 0 {
+			header.Set("Retry-After", retryAfter)
+		}
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}
+	}
+
+	assert.Equal(t, 3*time.Second, retryAfter(response("3"), now, time.Second))
+	assert.Equal(t, 10*time.Second, retryAfter(response("Thu, 01 Mar 2018 12:00:10 GMT"), now, time.Second))
+	assert.Equal(t, time.Duration(0), retryAfter(response("Thu, 01 Mar 2018 11:00:00 GMT"), now, time.Second))
+	assert.Equal(t, 2*time.Second, retryAfter(response(""), now, 2*time.Second))
+}
+
+func TestFasitThrottling(t *testing.T) {
+	fasit := FasitClient{Retry: RetryPolicy{MaxRetries: 5, Backoff: time.Millisecond}}
+
+	var throttledResponses int
+	var retryAfterHeader string
+	var bodies, requestIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		requestIds = append(requestIds, r.Header.Get(RequestIdHeader))
+		if len(bodies) <= throttledResponses {
+			w.Header().Set("Retry-After", retryAfterHeader)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("throttled requests are sent again with the same request id", func(t *testing.T) {
+		bodies, requestIds, throttledResponses, retryAfterHeader = nil, nil, 2, ""
+		deployment := &trackedDeployment{TrackedDeployment: TrackedDeployment{Application: "throttled"}, ctx: context.Background()}
+		throttled := counterValue(fasitThrottled.WithLabelValues("throttled"))
+		req, _ := http.NewRequest("GET", server.URL, nil)
+
+		resp, err := fasit.forDeployment(deployment).do(req)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, requestIds, 3)
+		assert.Equal(t, requestIds[0], requestIds[2])
+		assert.Equal(t, throttled+2, counterValue(fasitThrottled.WithLabelValues("throttled")))
+	})
+
+	t.Run("requests that change Fasit are not sent again", func(t *testing.T) {
+		bodies, requestIds, throttledResponses, retryAfterHeader = nil, nil, 2, ""
+		deployment := &trackedDeployment{TrackedDeployment: TrackedDeployment{Application: "throttled"}, ctx: context.Background()}
+		req, _ := http.NewRequest("POST", server.URL, bytes.NewBufferString("payload"))
+
+		resp, err := fasit.forDeployment(deployment).do(req)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, []string{"payload"}, bodies)
+	})
+
+	t.Run("requests are not retried past the deadline of the deployment", func(t *testing.T) {
+		bodies, requestIds, throttledResponses, retryAfterHeader = nil, nil, 1, "120"
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		deployment := &trackedDeployment{TrackedDeployment: TrackedDeployment{Application: "throttled"}, ctx: ctx}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+
+		resp, err := fasit.forDeployment(deployment).do(req)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Len(t, bodies, 1)
+	})
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.delay(0))
+	assert.Equal(t, 2*time.Second, policy.delay(1))
+	assert.Equal(t, 4*time.Second, policy.delay(2))
+	assert.Equal(t, 5*time.Second, policy.delay(3))
+	assert.Equal(t, 5*time.Second, policy.delay(100))
+
+	policy.Jitter = 0.2
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := policy.delay(attempt)
+		assert.True(t, delay >= 800*time.Millisecond && delay <= 6*time.Second, "delay %s is outside the jitter", delay)
+	}
+}
+
+func TestFasitRetries(t *testing.T) {
+	client := fasitHttpClient(RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}, nil)
+
+	var failures, requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("5xx responses are retried with backoff", func(t *testing.T) {
+		failures, requests = 2, 0
+		retries := counterValue(fasitRetries.WithLabelValues("server_error"))
+
+		resp, err := client.Get(server.URL)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 3, requests)
+		assert.Equal(t, retries+2, counterValue(fasitRetries.WithLabelValues("server_error")))
+	})
+
+	t.Run("the last failure is returned when the retries are used up", func(t *testing.T) {
+		failures, requests = 5, 0
+
+		resp, err := client.Get(server.URL)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("health checks are not retried", func(t *testing.T) {
+		failures, requests = 1, 0
+
+		resp, err := fasitHealthClient(nil).Get(server.URL)
+
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("network errors are retried", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		retries := counterValue(fasitRetries.WithLabelValues("network_error"))
+
+		_, err := client.Get(closed.URL)
+
+		assert.Error(t, err)
+		assert.Equal(t, retries+2, counterValue(fasitRetries.WithLabelValues("network_error")))
+	})
+}
+
+func TestFasitRequestsAreCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fasit := FasitClient{FasitUrl: server.URL}.withContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := fasit.GetFasitEnvironmentClass("t1")
+	assert.Error(t, err)
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestFasitTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	transport, err := NewFasitTransport(FasitTransportConfig{ConnectTimeout: time.Second, ReadTimeout: 10 * time.Millisecond})
+	assert.NoError(t, err)
+
+	started := time.Now()
+	_, err = fasitHttpClient(RetryPolicy{}, transport).Get(server.URL)
+	assert.Error(t, err)
+	assert.True(t, time.Since(started) < time.Second, "request was not cut off by the read timeout")
+}
+
+func counterValue(counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	counter.Write(&metric)
+	return metric.GetCounter().GetValue()
+}
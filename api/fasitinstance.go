@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// Lifecycle status Fasit gives an application instance that is no longer running
+const fasitLifecycleStopped = "stopped"
+
+// The cluster name application instances are registered with when none is configured
+const DefaultInstanceClusterName = "nais"
+
+// ApplicationInstanceConfig is the cluster and domain naisd registers application instances in Fasit with, so Fasit can
+// tell apart the same application deployed to several clusters or datacenters. Without a Domain, it is the ingress
+// domain of the application without its first label.
+type ApplicationInstanceConfig struct {
+	ClusterName string `yaml:"clusterName"`
+	Domain      string
+}
+
+// instanceClusterName is the cluster name application instances are registered with
+func instanceClusterName(config ApplicationInstanceConfig) string {
+	if len(config.ClusterName) > 0 {
+		return config.ClusterName
+	}
+	return DefaultInstanceClusterName
+}
+
+// instanceDomain is the domain an application instance with the subdomain is registered with
+func instanceDomain(config ApplicationInstanceConfig, subDomain string) string {
+	if len(config.Domain) > 0 {
+		return config.Domain
+	}
+	return strings.Join(strings.Split(subDomain, ".")[1:], ".")
+}
+
+// ApplicationInstance is the part of an application instance in Fasit naisd reads
+type ApplicationInstance struct {
+	Id      int
+	Version string
+}
+
+type LifecyclePayload struct {
+	Status string `json:"status"`
+}
+
+type ApplicationInstanceLifecyclePayload struct {
+	Lifecycle LifecyclePayload `json:"lifecycle"`
+}
+
+// GetApplicationInstance returns the application instance registered in the Fasit environment, or nil if it is not
+// registered
+func (fasit FasitClient) GetApplicationInstance(application, environment string) (*ApplicationInstance, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v2/applicationinstances/environment/%s/application/%s", fasit.FasitUrl, environment, application), nil)
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+
+	body, appErr := fasit.doRequest("get application instance", req)
+	if appErr != nil {
+		if appErr.Code() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, appErr
+	}
+
+	var instance ApplicationInstance
+	if err := json.Unmarshal(body, &instance); err != nil {
+		errorCounter.WithLabelValues("unmarshal_body").Inc()
+		return nil, fmt.Errorf("unable to unmarshal application instance: %s", err)
+	}
+
+	return &instance, nil
+}
+
+// StopApplicationInstance marks the application instance the deployment replaced as stopped in Fasit, so that it is
+// not shown as running alongside the new one
+func (fasit FasitClient) StopApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment string, instance ApplicationInstance) error {
+	payload, err := json.Marshal(ApplicationInstanceLifecyclePayload{LifecyclePayload{fasitLifecycleStopped}})
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return fmt.Errorf("unable to create payload (%s)", err)
+	}
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/api/v2/applicationinstances/%d", fasit.FasitUrl, instance.Id), bytes.NewBuffer(payload))
+	if err != nil {
+		errorCounter.WithLabelValues("create_request").Inc()
+		return fmt.Errorf("unable to create request: %s", err)
+	}
+
+	req.SetBasicAuth(deploymentRequest.FasitUsername, deploymentRequest.FasitPassword)
+	req.Header.Set("Content-Type", "application/json")
+	operation := fmt.Sprintf("stop application instance %d of %s:%s", instance.Id, deploymentRequest.Application, instance.Version)
+	setFasitAuditHeaders(req, deploymentRequest, operation)
+
+	if _, appErr := fasit.doRequest(operation, req); appErr != nil {
+		return appErr
+	}
+
+	glog.Infof("stopped application instance %d of %s:%s in %s", instance.Id, deploymentRequest.Application, instance.Version, fasitEnvironment)
+	return nil
+}
+
+// stopPreviousInstance stops the application instance that was registered before the deployment, once the new one has
+// been registered. Nothing is stopped if there was none, if it was of the version being deployed, as registering it
+// again updated it, or if Fasit updated it with the new version instead of registering a new instance.
+func stopPreviousInstance(fasit FasitClientAdapter, deploymentRequest naisrequest.Deploy, fasitEnvironment string, previous *ApplicationInstance) error {
+	if previous == nil || previous.Version == deploymentRequest.Version {
+		return nil
+	}
+
+	current, err := fasit.GetApplicationInstance(deploymentRequest.Application, fasitEnvironment)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Id == previous.Id {
+		return nil
+	}
+	return fasit.StopApplicationInstance(deploymentRequest, fasitEnvironment, *previous)
+}
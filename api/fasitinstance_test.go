@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestStopApplicationInstance(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: "app", Version: "2.0.0", FasitEnvironment: "t1", OnBehalfOf: "deployer"}
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	previous := &ApplicationInstance{Id: 42, Version: "1.0.0"}
+
+	t.Run("Previous instance is marked as stopped once the new one is registered", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/applicationinstances/environment/t1/application/app").
+			Reply(200).
+			JSON(map[string]interface{}{"id": 43, "version": "2.0.0"})
+		gock.New("https://fasit.local").
+			Put("/api/v2/applicationinstances/42").
+			HeaderPresent("Authorization").
+			MatchHeader("x-onbehalfof", "deployer").
+			JSON(map[string]interface{}{"lifecycle": map[string]string{"status": "stopped"}}).
+			Reply(200)
+
+		assert.NoError(t, stopPreviousInstance(fasit, deploymentRequest, "t1", previous))
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Nothing is stopped when the application was not registered", func(t *testing.T) {
+		defer gock.Off()
+
+		assert.NoError(t, stopPreviousInstance(fasit, deploymentRequest, "t1", nil))
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Nothing is stopped when the same version is redeployed", func(t *testing.T) {
+		defer gock.Off()
+
+		assert.NoError(t, stopPreviousInstance(fasit, deploymentRequest, "t1", &ApplicationInstance{Id: 42, Version: "2.0.0"}))
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Nothing is stopped when Fasit updated the previous instance with the new version", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/applicationinstances/environment/t1/application/app").
+			Reply(200).
+			JSON(map[string]interface{}{"id": 42, "version": "2.0.0"})
+
+		assert.NoError(t, stopPreviousInstance(fasit, deploymentRequest, "t1", previous))
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Error from Fasit is returned", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/applicationinstances/environment/t1/application/app").
+			Reply(200).
+			JSON(map[string]interface{}{"id": 43, "version": "2.0.0"})
+		gock.New("https://fasit.local").
+			Put("/api/v2/applicationinstances/42").
+			Reply(http.StatusForbidden)
+
+		assert.Error(t, stopPreviousInstance(fasit, deploymentRequest, "t1", previous))
+	})
+}
+
+func TestApplicationInstanceClusterAndDomain(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: "app", Version: "1.0.0"}
+
+	t.Run("Defaults to nais and the domain of the subdomain", func(t *testing.T) {
+		payload := buildApplicationInstancePayload(deploymentRequest, "t1", "nais-dev.example.no", nil, nil, HealthCheckUrls{}, ApplicationInstanceConfig{})
+		assert.Equal(t, "nais", payload.ClusterName)
+		assert.Equal(t, "example.no", payload.Domain)
+	})
+
+	t.Run("Cluster name and domain are configured", func(t *testing.T) {
+		instance := ApplicationInstanceConfig{ClusterName: "nais-dev-sbs", Domain: "dev-sbs.example.no"}
+
+		payload := buildApplicationInstancePayload(deploymentRequest, "t1", "nais-dev.example.no", nil, nil, HealthCheckUrls{}, instance)
+		assert.Equal(t, "nais-dev-sbs", payload.ClusterName)
+		assert.Equal(t, "dev-sbs.example.no", payload.Domain)
+	})
+
+	t.Run("Cluster name is configured alone", func(t *testing.T) {
+		instance := ApplicationInstanceConfig{ClusterName: "nais-prod-fss"}
+
+		payload := buildApplicationInstancePayload(deploymentRequest, "p", "app.adeo.no", nil, nil, HealthCheckUrls{}, instance)
+		assert.Equal(t, "nais-prod-fss", payload.ClusterName)
+		assert.Equal(t, "adeo.no", payload.Domain)
+	})
+}
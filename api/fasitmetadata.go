@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// ManagedByNaisd marks Fasit resources created and kept up to date by naisd
+const ManagedByNaisd = "naisd"
+
+// ResourceMetadata tells who owns a Fasit resource, shown in the Fasit UI and used to recognize resources naisd manages
+type ResourceMetadata struct {
+	ManagedBy   string `json:"managed-by"`
+	Application string `json:"application,omitempty"`
+	Team        string `json:"team,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+}
+
+func resourceMetadata(deploymentRequest naisrequest.Deploy, team string) ResourceMetadata {
+	owner := deploymentRequest.OnBehalfOf
+	if len(owner) == 0 {
+		owner = deploymentRequest.FasitUsername
+	}
+
+	return ResourceMetadata{
+		ManagedBy:   ManagedByNaisd,
+		Application: deploymentRequest.Application,
+		Team:        team,
+		Owner:       owner,
+	}
+}
+
+func withResourceMetadata(payload ResourcePayload, metadata ResourceMetadata) ResourcePayload {
+	switch p := payload.(type) {
+	case extendedResourcePayload:
+		p.ResourcePayload = withResourceMetadata(p.ResourcePayload, metadata)
+		return p
+	case RestResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case WebserviceResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case WebserviceGatewayResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case CredentialResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case DataSourceResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case QueueResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case TopicResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case ChannelResourcePayload:
+		p.Metadata = &metadata
+		return p
+	case QueueManagerResourcePayload:
+		p.Metadata = &metadata
+		return p
+	default:
+		return payload
+	}
+}
+
+// Returns a warning if the existing resource was created by hand or by other tooling, and is about to be overwritten.
+// It is checked before the resource is written, so the warning is logged even if the update fails.
+func unmanagedResourceWarning(resource ExposedResource, existingResource NaisResource) string {
+	if existingResource.metadata.ManagedBy == ManagedByNaisd {
+		return ""
+	}
+
+	return fmt.Sprintf("Fasit resource %s (%s) was not created by naisd, and is overwritten with the values from the manifest", resource.Alias, resource.ResourceType)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceMetadata(t *testing.T) {
+	t.Run("Owner is the user deploying on behalf of someone, or the Fasit user", func(t *testing.T) {
+		metadata := resourceMetadata(naisrequest.Deploy{Application: "app", FasitUsername: "srvapp", OnBehalfOf: "A123456"}, "team")
+		assert.Equal(t, ResourceMetadata{ManagedBy: ManagedByNaisd, Application: "app", Team: "team", Owner: "A123456"}, metadata)
+
+		metadata = resourceMetadata(naisrequest.Deploy{Application: "app", FasitUsername: "srvapp"}, "team")
+		assert.Equal(t, "srvapp", metadata.Owner)
+	})
+
+	t.Run("Metadata is included in resource payloads", func(t *testing.T) {
+		resource := ExposedResource{Alias: "api", ResourceType: "RestService", Path: "/api"}
+		payload := withResourceMetadata(buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "app.example.com"), ResourceMetadata{ManagedBy: ManagedByNaisd, Application: "app"})
+
+		data, err := json.Marshal(payload)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"metadata":{"managed-by":"naisd","application":"app"}`)
+	})
+
+	t.Run("Updating resources naisd did not create gives warning", func(t *testing.T) {
+		resource := ExposedResource{Alias: "api", ResourceType: "RestService"}
+
+		assert.Empty(t, unmanagedResourceWarning(resource, NaisResource{id: 1, metadata: ResourceMetadata{ManagedBy: ManagedByNaisd}}))
+		assert.Equal(t, "Fasit resource api (RestService) was not created by naisd, and is overwritten with the values from the manifest", unmanagedResourceWarning(resource, NaisResource{id: 1}))
+	})
+}
@@ -0,0 +1,518 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+// The id of the first resource created in an offline Fasit, well above the ids of resources copied from Fasit
+const firstOfflineResourceId = 900000000
+
+// the operation of the writes registering application instances, which the current instance is found from
+const offlineRegisterInstance = "register application instance"
+
+// OfflineFasit is a Fasit kept in a directory, for running naisd without one, e.g. for local development or in an
+// air-gapped test environment. It reads the directory on every call, so it can be changed while naisd runs:
+//
+//	environments.json   environment name to environment class, e.g. {"t1": "t"}
+//	applications.json   names of the applications that exist, every application if there is no such file
+//	resources/*.json    resources, or arrays of resources, as Fasit returns them from /api/v2/resources
+//
+// Secrets are given as {"password": {"value": "secret"}}, or {"password": {"ref": "secrets/password"}} to read them from
+// a file in the directory. The ref of a file of a certificate is also a file in the directory. Every write is appended
+// to writes.jsonl, with the payload naisd would have sent Fasit. Created resources are added to resources/ and updated
+// ones replaced there, so later deployments find them, and the application instance registered last is the current one.
+type OfflineFasit struct {
+	directory string
+	mutex     sync.Mutex
+}
+
+var _ FasitClientAdapter = &OfflineFasit{}
+
+// offlineWrite is a line of writes.jsonl
+type offlineWrite struct {
+	Timestamp   string      `json:"timestamp"`
+	Operation   string      `json:"operation"`
+	Application string      `json:"application"`
+	Environment string      `json:"environment"`
+	Id          int         `json:"id,omitempty"`
+	Payload     interface{} `json:"payload,omitempty"`
+}
+
+// fasitBackend is what a deployment reads from and writes to Fasit through: the offline Fasit if naisd has one, or else
+// the Fasit of the client
+func (api Api) fasitBackend(fasit FasitClient) FasitClientAdapter {
+	if api.OfflineFasit != nil {
+		return api.OfflineFasit
+	}
+	return fasit
+}
+
+func NewOfflineFasit(directory string) (*OfflineFasit, error) {
+	if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("offline Fasit directory %s does not exist", directory)
+	}
+	return &OfflineFasit{directory: directory}, nil
+}
+
+func (fasit *OfflineFasit) GetScopedResource(resourcesRequest ResourceRequest, environment, application, zone string) (NaisResource, AppError) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	resources, err := fasit.readResources()
+	if err != nil {
+		return NaisResource{}, appError{err, "unable to read offline Fasit", http.StatusInternalServerError}
+	}
+	environmentClass, _ := fasit.environmentClass(environment)
+
+	var candidates []FasitResource
+	for _, resource := range resources {
+		if resource.Alias == resourcesRequest.Alias && strings.EqualFold(resource.ResourceType, resourcesRequest.ResourceType) && offlineScopeApplies(resource.Scope, environmentClass, environment, application, zone) {
+			candidates = append(candidates, resource)
+		}
+	}
+
+	resolution := FasitScopeResolution
+	if resolution == ScopeResolutionFasit {
+		resolution = ScopeResolutionBestMatch
+	}
+	fasitResource, appErr := pickScopedResource(resourcesRequest, environment, resolution, candidates)
+	if appErr != nil {
+		return NaisResource{}, appErr
+	}
+
+	resource, err := fasit.toNaisResource(fasitResource, resourcesRequest.PropertyMap)
+	if err != nil {
+		return NaisResource{}, appError{err, "unable to map offline resource to Nais resource", http.StatusInternalServerError}
+	}
+	return resource, nil
+}
+
+// GetScopedResources gets each of the resources, skipping optional resources that are not found, like FasitClient
+func (fasit *OfflineFasit) GetScopedResources(resourcesRequests []ResourceRequest, environment string, application string, zone string) ([]NaisResource, error) {
+	var resources []NaisResource
+	for _, request := range resourcesRequests {
+		resource, appErr := fasit.GetScopedResource(request, environment, application, zone)
+		if appErr != nil && request.Optional && appErr.Code() == http.StatusNotFound {
+			continue
+		}
+		if appErr != nil {
+			return []NaisResource{}, fmt.Errorf("unable to get resource %s (%s). %s", request.Alias, request.ResourceType, appErr)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func (fasit *OfflineFasit) FindResourceAliases(prefix, resourceType, environment, application, zone string) ([]string, error) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	resources, err := fasit.readResources()
+	if err != nil {
+		return nil, err
+	}
+	environmentClass, _ := fasit.environmentClass(environment)
+
+	found := make(map[string]bool)
+	var aliases []string
+	for _, resource := range resources {
+		if strings.HasPrefix(resource.Alias, prefix) && strings.EqualFold(resource.ResourceType, resourceType) && offlineScopeApplies(resource.Scope, environmentClass, environment, application, zone) && !found[resource.Alias] {
+			found[resource.Alias] = true
+			aliases = append(aliases, resource.Alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases, nil
+}
+
+func (fasit *OfflineFasit) GetLoadBalancerConfig(application string, environment string) (*NaisResource, error) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	resources, err := fasit.readResources()
+	if err != nil {
+		return nil, err
+	}
+
+	ingresses := make(map[string]string)
+	for _, resource := range resources {
+		if strings.EqualFold(resource.ResourceType, "LoadBalancerConfig") && resource.Scope.Application == application && strings.EqualFold(resource.Scope.Environment, environment) && len(resource.Properties["url"]) > 0 {
+			ingresses[resource.Properties["url"]] = resource.Properties["contextRoots"]
+		}
+	}
+	if len(ingresses) == 0 {
+		return nil, nil
+	}
+
+	resource := NewLoadBalancerConfig(ingresses)
+	return &resource, nil
+}
+
+func (fasit *OfflineFasit) GetFasitEnvironmentClass(environmentName string) (string, error) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	return fasit.environmentClass(environmentName)
+}
+
+func (fasit *OfflineFasit) GetFasitApplication(application string) error {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	var applications []string
+	if err := fasit.readJson("applications.json", &applications); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range applications {
+		if name == application {
+			return nil
+		}
+	}
+	return fmt.Errorf("could not find application %s in Fasit", application)
+}
+
+func (fasit *OfflineFasit) CreateResource(resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	resources, err := fasit.readResources()
+	if err != nil {
+		return 0, err
+	}
+	id := firstOfflineResourceId
+	for _, existing := range resources {
+		if existing.Id >= id {
+			id = existing.Id + 1
+		}
+	}
+
+	payload := withResourceMetadata(buildResourcePayload(resource, NaisResource{}, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname), metadata)
+	if err := fasit.addResource(id, payload); err != nil {
+		return 0, err
+	}
+	return id, fasit.recordWrite(offlineWrite{Operation: "create resource", Application: deploymentRequest.Application, Environment: environment, Id: id, Payload: payload})
+}
+
+func (fasit *OfflineFasit) UpdateResource(existingResource NaisResource, resource ExposedResource, fasitEnvironmentClass, environment, hostname string, metadata ResourceMetadata, deploymentRequest naisrequest.Deploy) (int, error) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	payload := withResourceMetadata(buildResourcePayload(resource, existingResource, fasitEnvironmentClass, environment, deploymentRequest.Zone, hostname), metadata)
+	if err := fasit.replaceResource(existingResource.id, payload); err != nil {
+		return 0, err
+	}
+	return existingResource.id, fasit.recordWrite(offlineWrite{Operation: "update resource", Application: deploymentRequest.Application, Environment: environment, Id: existingResource.id, Payload: payload})
+}
+
+func (fasit *OfflineFasit) CreateApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment, subDomain string, exposedResourceIds, usedResourceIds []int, healthChecks HealthCheckUrls, instance ApplicationInstanceConfig) error {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	writes, err := fasit.readWrites()
+	if err != nil {
+		return err
+	}
+	id := 1
+	for _, write := range writes {
+		if write.Operation == offlineRegisterInstance && write.Id >= id {
+			id = write.Id + 1
+		}
+	}
+
+	payload := buildApplicationInstancePayload(deploymentRequest, fasitEnvironment, subDomain, exposedResourceIds, usedResourceIds, healthChecks, instance)
+	return fasit.recordWrite(offlineWrite{Operation: offlineRegisterInstance, Application: deploymentRequest.Application, Environment: fasitEnvironment, Id: id, Payload: payload})
+}
+
+func (fasit *OfflineFasit) CreateDeploymentEvent(deploymentRequest naisrequest.Deploy, clusterName string) error {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	payload := buildDeploymentEventPayload(deploymentRequest, clusterName, time.Now())
+	return fasit.recordWrite(offlineWrite{Operation: "register deployment event", Application: deploymentRequest.Application, Environment: deploymentRequest.FasitEnvironment, Payload: payload})
+}
+
+// GetApplicationInstance is the application instance registered last for the application in the environment, from
+// writes.jsonl
+func (fasit *OfflineFasit) GetApplicationInstance(application, environment string) (*ApplicationInstance, error) {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	writes, err := fasit.readWrites()
+	if err != nil {
+		return nil, err
+	}
+
+	var instance *ApplicationInstance
+	for _, write := range writes {
+		if write.Operation != offlineRegisterInstance || write.Application != application || !strings.EqualFold(write.Environment, environment) {
+			continue
+		}
+		var payload ApplicationInstancePayload
+		if err := remarshal(write.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("unable to read offline application instance: %s", err)
+		}
+		instance = &ApplicationInstance{Id: write.Id, Version: payload.Version}
+	}
+	return instance, nil
+}
+
+func (fasit *OfflineFasit) StopApplicationInstance(deploymentRequest naisrequest.Deploy, fasitEnvironment string, instance ApplicationInstance) error {
+	fasit.mutex.Lock()
+	defer fasit.mutex.Unlock()
+
+	payload := ApplicationInstanceLifecyclePayload{LifecyclePayload{fasitLifecycleStopped}}
+	return fasit.recordWrite(offlineWrite{Operation: "stop application instance", Application: deploymentRequest.Application, Environment: fasitEnvironment, Id: instance.Id, Payload: payload})
+}
+
+// offlineScopeApplies is scopeApplies, also requiring a resource scoped to an environment class alone to be in the
+// class of the environment, which Fasit checks itself
+func offlineScopeApplies(scope Scope, environmentClass, environment, application, zone string) bool {
+	if len(scope.Environment) == 0 && len(scope.EnvironmentClass) > 0 && !strings.EqualFold(scope.EnvironmentClass, environmentClass) {
+		return false
+	}
+	return scopeApplies(scope, environment, application, zone)
+}
+
+// environmentClass is the class of the environment. The lock must be held.
+func (fasit *OfflineFasit) environmentClass(environment string) (string, error) {
+	var environments map[string]string
+	if err := fasit.readJson("environments.json", &environments); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	environmentClass, ok := environments[environment]
+	if !ok {
+		return "", appError{nil, fmt.Sprintf("environment %s not found in offline Fasit", environment), http.StatusNotFound}
+	}
+	return environmentClass, nil
+}
+
+// readResources reads every resource in resources/. The lock must be held.
+func (fasit *OfflineFasit) readResources() ([]FasitResource, error) {
+	files, err := filepath.Glob(filepath.Join(fasit.directory, "resources", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var resources []FasitResource
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read offline resource %s: %s", file, err)
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+			var many []FasitResource
+			if err := json.Unmarshal(data, &many); err != nil {
+				return nil, fmt.Errorf("unable to unmarshal offline resources %s: %s", file, err)
+			}
+			resources = append(resources, many...)
+			continue
+		}
+
+		var resource FasitResource
+		if err := json.Unmarshal(data, &resource); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal offline resource %s: %s", file, err)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// toNaisResource resolves the secrets and files of the resource from the directory. The lock must be held.
+func (fasit *OfflineFasit) toNaisResource(resource FasitResource, propertyMap map[string]string) (NaisResource, error) {
+	var secret map[string]string
+	if len(resource.Secrets) > 0 {
+		secret = make(map[string]string, len(resource.Secrets))
+		for key, value := range resource.Secrets {
+			if ref, ok := value["ref"]; ok {
+				data, err := fasit.readFile(ref)
+				if err != nil {
+					return NaisResource{}, fmt.Errorf("unable to resolve secret %s: %s", key, err)
+				}
+				secret[key] = strings.TrimSuffix(string(data), "\n")
+				continue
+			}
+			secret[key] = value["value"]
+		}
+	}
+
+	var certificates map[string][]byte
+	if len(resource.Certificates) > 0 {
+		files, err := parseFilesObject(resource.Certificates)
+		if err != nil {
+			return NaisResource{}, err
+		}
+		certificates = make(map[string][]byte, len(files))
+		for fileName, ref := range files {
+			data, err := fasit.readFile(ref)
+			if err != nil {
+				return NaisResource{}, fmt.Errorf("unable to resolve file %s: %s", fileName, err)
+			}
+			certificates[fileName] = data
+		}
+	}
+
+	return NewNaisResource(resource, propertyMap, secret, certificates), nil
+}
+
+// offlineResource is the payload as Fasit would return the resource with the id
+func offlineResource(id int, payload ResourcePayload) (json.RawMessage, error) {
+	var resource map[string]interface{}
+	if err := remarshal(payload, &resource); err != nil {
+		return nil, fmt.Errorf("unable to create payload (%s)", err)
+	}
+	resource["id"] = id
+	return json.MarshalIndent(resource, "", "  ")
+}
+
+// addResource stores a created resource in resources/, as Fasit would return it. The lock must be held.
+func (fasit *OfflineFasit) addResource(id int, payload ResourcePayload) error {
+	data, err := offlineResource(id, payload)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(fasit.directory, "resources"), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(fasit.directory, "resources", fmt.Sprintf("created-%d.json", id)), data, 0644)
+}
+
+// replaceResource replaces the resource with the id in the file in resources/ it was read from, keeping the other
+// resources of the file. A resource that is in no file is added like a created one. The lock must be held.
+func (fasit *OfflineFasit) replaceResource(id int, payload ResourcePayload) error {
+	updated, err := offlineResource(id, payload)
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(fasit.directory, "resources", "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read offline resource %s: %s", file, err)
+		}
+
+		if !strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+			if offlineResourceId(data) == id {
+				return ioutil.WriteFile(file, updated, 0644)
+			}
+			continue
+		}
+
+		var many []json.RawMessage
+		if err := json.Unmarshal(data, &many); err != nil {
+			return fmt.Errorf("unable to unmarshal offline resources %s: %s", file, err)
+		}
+		for i := range many {
+			if offlineResourceId(many[i]) == id {
+				many[i] = updated
+				if data, err = json.MarshalIndent(many, "", "  "); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(file, data, 0644)
+			}
+		}
+	}
+
+	return fasit.addResource(id, payload)
+}
+
+func offlineResourceId(data []byte) int {
+	var resource struct {
+		Id int `json:"id"`
+	}
+	json.Unmarshal(data, &resource)
+	return resource.Id
+}
+
+// remarshal converts between types through their JSON
+func remarshal(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// readWrites reads writes.jsonl, which has no writes before the first is recorded. The lock must be held.
+func (fasit *OfflineFasit) readWrites() ([]offlineWrite, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fasit.directory, "writes.jsonl"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read offline Fasit writes: %s", err)
+	}
+
+	var writes []offlineWrite
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		var write offlineWrite
+		if err := json.Unmarshal([]byte(line), &write); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal offline Fasit write: %s", err)
+		}
+		writes = append(writes, write)
+	}
+	return writes, nil
+}
+
+// recordWrite appends the write to writes.jsonl. The lock must be held.
+func (fasit *OfflineFasit) recordWrite(write offlineWrite) error {
+	write.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	line, err := json.Marshal(write)
+	if err != nil {
+		return fmt.Errorf("unable to marshal offline Fasit write: %s", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(fasit.directory, "writes.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to record offline Fasit write: %s", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (fasit *OfflineFasit) readJson(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(fasit.directory, name))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to unmarshal offline Fasit %s: %s", name, err)
+	}
+	return nil
+}
+
+// readFile reads a file the directory refers to, which must be in the directory
+func (fasit *OfflineFasit) readFile(ref string) ([]byte, error) {
+	path := filepath.Join(fasit.directory, filepath.FromSlash(ref))
+	if rel, err := filepath.Rel(fasit.directory, path); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%s is outside the offline Fasit directory", ref)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOfflineFasit(t *testing.T, files map[string]string) (*OfflineFasit, string) {
+	directory, err := ioutil.TempDir("", "offlinefasit")
+	assert.NoError(t, err)
+
+	for name, content := range files {
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	fasit, err := NewOfflineFasit(directory)
+	assert.NoError(t, err)
+	return fasit, directory
+}
+
+func TestOfflineFasit(t *testing.T) {
+	files := map[string]string{
+		"environments.json": `{"t1": "t", "t2": "t", "q1": "q"}`,
+		"resources/db.json": `[
+			{"id": 1, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t"},
+			 "properties": {"url": "jdbc:oracle:thin:@t"}, "secrets": {"password": {"value": "tpassword"}}},
+			{"id": 2, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t", "environment": "t1"},
+			 "properties": {"url": "jdbc:oracle:thin:@t1"}, "secrets": {"password": {"ref": "secrets/t1-password"}}}
+		]`,
+		"resources/cert.json": `{"id": 3, "alias": "mycert", "type": "Certificate", "scope": {"environmentclass": "t"},
+			"files": {"keystore": {"filename": "app.jks", "ref": "files/app.jks"}}}`,
+		"resources/lb.json": `{"id": 4, "alias": "lb", "type": "LoadBalancerConfig", "scope": {"environmentclass": "t", "environment": "t1", "application": "app"},
+			"properties": {"url": "app.adeo.no", "contextRoots": "/app"}}`,
+		"secrets/t1-password": "t1password\n",
+		"files/app.jks":       "keystore",
+	}
+
+	t.Run("Resource with the most specific scope is resolved, with its secret", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, files)
+		defer os.RemoveAll(directory)
+
+		resource, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "mydb", ResourceType: "datasource"}, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, 2, resource.Id())
+		assert.Equal(t, "jdbc:oracle:thin:@t1", resource.Properties()["url"])
+		assert.Equal(t, "t1password", resource.Secret()["password"])
+
+		resource, appErr = fasit.GetScopedResource(ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}, "t2", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, 1, resource.Id(), "t2 is in the environment class of the resource")
+
+		_, appErr = fasit.GetScopedResource(ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}, "q1", "app", "fss")
+		assert.Equal(t, http.StatusNotFound, appErr.Code(), "resources scoped to another environment class do not apply")
+	})
+
+	t.Run("Files are read from the directory", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, files)
+		defer os.RemoveAll(directory)
+
+		resource, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "mycert", ResourceType: "Certificate"}, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, []byte("keystore"), resource.Certificates()["app.jks"])
+	})
+
+	t.Run("Refs outside the directory are not read", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, map[string]string{
+			"environments.json": `{"t1": "t"}`,
+			"resources/db.json": `{"id": 1, "alias": "mydb", "type": "DataSource", "secrets": {"password": {"ref": "../../etc/passwd"}}}`,
+		})
+		defer os.RemoveAll(directory)
+
+		_, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}, "t1", "app", "fss")
+		assert.Equal(t, http.StatusInternalServerError, appErr.Code())
+		assert.Contains(t, appErr.Error(), "outside the offline Fasit directory")
+	})
+
+	t.Run("Optional resources that are missing are skipped", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, files)
+		defer os.RemoveAll(directory)
+
+		resources, err := fasit.GetScopedResources([]ResourceRequest{
+			{Alias: "mydb", ResourceType: "DataSource"},
+			{Alias: "missing", ResourceType: "RestService", Optional: true},
+		}, "t1", "app", "fss")
+		assert.NoError(t, err)
+		assert.Len(t, resources, 1)
+
+		_, err = fasit.GetScopedResources([]ResourceRequest{{Alias: "missing", ResourceType: "RestService"}}, "t1", "app", "fss")
+		assert.Error(t, err)
+	})
+
+	t.Run("Environments, applications, aliases and load balancer config are read", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, files)
+		defer os.RemoveAll(directory)
+
+		environmentClass, err := fasit.GetFasitEnvironmentClass("q1")
+		assert.NoError(t, err)
+		assert.Equal(t, "q", environmentClass)
+		_, err = fasit.GetFasitEnvironmentClass("p")
+		assert.Error(t, err)
+
+		assert.NoError(t, fasit.GetFasitApplication("app"), "every application exists without applications.json")
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(directory, "applications.json"), []byte(`["app"]`), 0644))
+		assert.NoError(t, fasit.GetFasitApplication("app"))
+		assert.Error(t, fasit.GetFasitApplication("otherapp"))
+
+		aliases, err := fasit.FindResourceAliases("my", "DataSource", "t1", "app", "fss")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"mydb"}, aliases)
+
+		lb, err := fasit.GetLoadBalancerConfig("app", "t1")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"app.adeo.no": "/app"}, lb.ingresses)
+	})
+
+	t.Run("Writes are recorded, and created resources are found afterwards", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, files)
+		defer os.RemoveAll(directory)
+		deploymentRequest := naisrequest.Deploy{Application: "app", Version: "1.0", Zone: "fss", FasitEnvironment: "t1"}
+
+		id, err := fasit.CreateResource(ExposedResource{Alias: "myapi", ResourceType: "RestService", Path: "/api"}, "t", "t1", "app.nais.local", ResourceMetadata{}, deploymentRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, firstOfflineResourceId, id)
+
+		resource, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "myapi", ResourceType: "RestService"}, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, id, resource.Id())
+		assert.Equal(t, "https://app.nais.local/api", resource.Properties()["url"])
+
+		assert.NoError(t, fasit.CreateApplicationInstance(deploymentRequest, "t1", "app.nais.local", []int{id}, []int{2}, HealthCheckUrls{}, ApplicationInstanceConfig{}))
+		assert.NoError(t, fasit.CreateDeploymentEvent(deploymentRequest, "nais-dev"))
+
+		data, err := ioutil.ReadFile(filepath.Join(directory, "writes.jsonl"))
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		assert.Len(t, lines, 3)
+
+		var write struct {
+			Operation string
+			Payload   ApplicationInstancePayload
+		}
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &write))
+		assert.Equal(t, "register application instance", write.Operation)
+		assert.Equal(t, []Resource{{id}}, write.Payload.ExposedResources)
+		assert.Equal(t, []Resource{{2}}, write.Payload.UsedResources)
+	})
+
+	t.Run("Updated resources are replaced in the file they were read from", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, map[string]string{
+			"environments.json": `{"t1": "t"}`,
+			"resources/api.json": `[
+				{"id": 5, "alias": "otherapi", "type": "RestService", "properties": {"url": "https://other/api"}},
+				{"id": 6, "alias": "myapi", "type": "RestService", "properties": {"url": "https://old/api"}}
+			]`,
+		})
+		defer os.RemoveAll(directory)
+		deploymentRequest := naisrequest.Deploy{Application: "app", Version: "1.0", Zone: "fss", FasitEnvironment: "t1"}
+
+		existing, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "myapi", ResourceType: "RestService"}, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		id, err := fasit.UpdateResource(existing, ExposedResource{Alias: "myapi", ResourceType: "RestService", Path: "/api/v2"}, "t", "t1", "app.nais.local", ResourceMetadata{}, deploymentRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, 6, id)
+
+		updated, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "myapi", ResourceType: "RestService"}, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, 6, updated.Id())
+		assert.Equal(t, "https://app.nais.local/api/v2", updated.Properties()["url"])
+
+		other, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "otherapi", ResourceType: "RestService"}, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, "https://other/api", other.Properties()["url"], "the other resources of the file are kept")
+
+		created, _ := filepath.Glob(filepath.Join(directory, "resources", "created-*.json"))
+		assert.Empty(t, created)
+	})
+
+	t.Run("The application instance registered last is the current one", func(t *testing.T) {
+		fasit, directory := newTestOfflineFasit(t, files)
+		defer os.RemoveAll(directory)
+
+		instance, err := fasit.GetApplicationInstance("app", "t1")
+		assert.NoError(t, err)
+		assert.Nil(t, instance)
+
+		for _, version := range []string{"1.0", "2.0"} {
+			deploymentRequest := naisrequest.Deploy{Application: "app", Version: version, FasitEnvironment: "t1"}
+			assert.NoError(t, fasit.CreateApplicationInstance(deploymentRequest, "t1", "app.nais.local", nil, nil, HealthCheckUrls{}, ApplicationInstanceConfig{}))
+		}
+		assert.NoError(t, fasit.CreateApplicationInstance(naisrequest.Deploy{Application: "other", Version: "3.0"}, "t1", "", nil, nil, HealthCheckUrls{}, ApplicationInstanceConfig{}))
+
+		instance, err = fasit.GetApplicationInstance("app", "t1")
+		assert.NoError(t, err)
+		assert.Equal(t, &ApplicationInstance{Id: 2, Version: "2.0"}, instance)
+
+		version, err := applicationInstanceVersion(fasit, "other", "t1")
+		assert.NoError(t, err)
+		assert.Equal(t, "3.0", version)
+	})
+
+	t.Run("Missing directory is an error", func(t *testing.T) {
+		_, err := NewOfflineFasit("testdata/nonexisting")
+		assert.Error(t, err)
+	})
+}
+
+func TestFasitBackend(t *testing.T) {
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	assert.Equal(t, fasit, Api{}.fasitBackend(fasit))
+
+	offline := &OfflineFasit{directory: "testdata"}
+	assert.Equal(t, offline, Api{OfflineFasit: offline}.fasitBackend(fasit))
+}
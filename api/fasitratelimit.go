@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit lets Rate requests a second through on average, and up to Burst at once after a quiet period. Requests over
+// the limit wait for their turn, for as long as the deployment they are made for has time. 0 rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// FasitRateLimit is shared by every request naisd makes to Fasit, including retries, so that mass redeploys do not
+// flood it
+var FasitRateLimit = RateLimit{Rate: 50, Burst: 100}
+
+var fasitRateLimiter = &tokenBucket{}
+
+// tokenBucket holds up to Burst tokens, refilled at Rate a second. A request takes a token, and waits for one if the
+// bucket is empty.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token, returning how long to wait until it is available. The bucket goes into debt for requests
+// waiting, so they are let through in the order they came.
+func (b *tokenBucket) reserve(limit RateLimit, now time.Time) time.Duration {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / limit.Rate * float64(time.Second))
+}
+
+// release gives back the token of a request that stopped waiting for it
+func (b *tokenBucket) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens++
+}
+
+// wait blocks until the request may be sent, or ctx is done
+func (b *tokenBucket) wait(ctx context.Context, limit RateLimit) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	start := time.Now()
+	if delay := b.reserve(limit, start); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			b.release()
+			return ctx.Err()
+		}
+	}
+
+	fasitRateLimitWait.Observe(time.Since(start).Seconds())
+	return nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{Rate: 10, Burst: 2}
+	now := time.Now()
+	bucket := &tokenBucket{}
+
+	assert.Equal(t, time.Duration(0), bucket.reserve(limit, now))
+	assert.Equal(t, time.Duration(0), bucket.reserve(limit, now), "a burst is let through at once")
+	assert.Equal(t, 100*time.Millisecond, bucket.reserve(limit, now))
+	assert.Equal(t, 200*time.Millisecond, bucket.reserve(limit, now), "waiting requests are let through in turn")
+
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), bucket.reserve(limit, now), "the bucket is refilled over time")
+	assert.Equal(t, time.Duration(0), bucket.reserve(limit, now))
+	assert.Equal(t, 100*time.Millisecond, bucket.reserve(limit, now), "but never beyond the burst")
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	t.Run("No limit does not wait", func(t *testing.T) {
+		bucket := &tokenBucket{}
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, bucket.wait(context.Background(), RateLimit{}))
+		}
+	})
+
+	t.Run("Requests that stop waiting give their token back", func(t *testing.T) {
+		limit := RateLimit{Rate: 0.001, Burst: 1}
+		bucket := &tokenBucket{}
+		assert.NoError(t, bucket.wait(context.Background(), limit))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, bucket.wait(ctx, limit))
+		assert.InDelta(t, 0, bucket.tokens, 0.01, "the bucket is as if the cancelled request never came")
+	})
+}
+
+func TestFasitRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	defer func(limit RateLimit, limiter *tokenBucket) {
+		FasitRateLimit, fasitRateLimiter = limit, limiter
+	}(FasitRateLimit, fasitRateLimiter)
+	FasitRateLimit = RateLimit{Rate: 20, Burst: 1}
+	fasitRateLimiter = &tokenBucket{}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := fasitHttpClient(RetryPolicy{}, nil).Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "the second and third requests wait 50ms each")
+}
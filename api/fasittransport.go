@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// FasitTransportConfig tunes the connections to Fasit. They are shared by every request naisd makes to Fasit, so that
+// the resources of a deployment are fetched over connections that are already open, instead of connecting and
+// negotiating TLS again for each of them.
+type FasitTransportConfig struct {
+	ConnectTimeout      time.Duration
+	ReadTimeout         time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	MinTLSVersion       string
+	// Proxy is the URL of the proxy to reach Fasit through. Without it, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used.
+	Proxy string
+	// CABundle is a file with PEM certificates of CAs Fasit's certificate may be signed by, besides the system's
+	CABundle string
+	// InsecureSkipVerify accepts any certificate from Fasit, and is only meant for test environments
+	InsecureSkipVerify bool
+}
+
+// NewFasitTransport sets up the connections to Fasit, to be given to the Api as its FasitTransport. Requests that time
+// out connecting, or waiting for Fasit to start answering, fail with a network error, and are retried like one.
+func NewFasitTransport(config FasitTransportConfig) (http.RoundTripper, error) {
+	tlsConfig := &tls.Config{}
+	if len(config.MinTLSVersion) > 0 {
+		version, ok := tlsVersions[config.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown minimum TLS version %s for Fasit, expected 1.0, 1.1 or 1.2", config.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(config.CABundle) > 0 {
+		rootCAs, err := loadCABundle(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.InsecureSkipVerify {
+		glog.Warning("certificates from Fasit are not verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if len(config.Proxy) > 0 {
+		proxyUrl, err := url.Parse(config.Proxy)
+		if err != nil || len(proxyUrl.Host) == 0 {
+			return nil, fmt.Errorf("invalid proxy %q for Fasit, expected e.g. http://proxy:8080", config.Proxy)
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	dialer := &net.Dialer{Timeout: config.ConnectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           countedDial(dialer.DialContext),
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ReadTimeout,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+// loadCABundle returns the system's CAs along with the ones in the PEM file
+func loadCABundle(path string) (*x509.CertPool, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle for Fasit: %s", err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		glog.Warningf("unable to load the system's CAs, only trusting the CA bundle for Fasit: %s", err)
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s for Fasit", path)
+	}
+	return rootCAs, nil
+}
+
+// Without a transport of its own, http.DefaultTransport is looked up for every request, so tests can intercept it
+func fasitBaseTransport(transport http.RoundTripper) http.RoundTripper {
+	if transport != nil {
+		return transport
+	}
+	return http.DefaultTransport
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// countedDial keeps fasit_open_connections up to date with the connections dial opens
+func countedDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		fasitOpenConnections.Inc()
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	closed sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closed.Do(fasitOpenConnections.Dec)
+	return c.Conn.Close()
+}
+
+// fasitConnectionTrace counts whether requests got a connection from the pool, or had to open a new one
+var fasitConnectionTrace = &httptrace.ClientTrace{
+	GotConn: func(info httptrace.GotConnInfo) {
+		fasitConnections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+	},
+}
+
+func withConnectionTrace(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), fasitConnectionTrace))
+}
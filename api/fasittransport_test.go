@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureFasitTransport(t *testing.T) {
+	_, err := NewFasitTransport(FasitTransportConfig{MinTLSVersion: "2.0"})
+	assert.EqualError(t, err, "unknown minimum TLS version 2.0 for Fasit, expected 1.0, 1.1 or 1.2")
+
+	transport, err := NewFasitTransport(FasitTransportConfig{MaxIdleConnsPerHost: 20, MinTLSVersion: "1.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 20, transport.(*http.Transport).MaxIdleConnsPerHost)
+	assert.Equal(t, tlsVersions["1.2"], transport.(*http.Transport).TLSClientConfig.MinVersion)
+
+	other, err := NewFasitTransport(FasitTransportConfig{MinTLSVersion: "1.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, tlsVersions["1.0"], other.(*http.Transport).TLSClientConfig.MinVersion)
+	assert.Equal(t, tlsVersions["1.2"], transport.(*http.Transport).TLSClientConfig.MinVersion, "transports do not share their TLS settings")
+
+	client := FasitClient{Transport: transport}
+	assert.Equal(t, transport, client.withContext(context.Background()).Transport)
+	assert.Equal(t, transport, Api{FasitTransport: transport}.fasitClient(&naisrequest.Deploy{}, false).Transport)
+}
+
+func TestFasitConnectionsAreReused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	transport, err := NewFasitTransport(FasitTransportConfig{MaxIdleConnsPerHost: 2})
+	assert.NoError(t, err)
+
+	reused := counterValue(fasitConnections.WithLabelValues("true"))
+	opened := counterValue(fasitConnections.WithLabelValues("false"))
+	open := gaugeValue(fasitOpenConnections)
+
+	for i := 0; i < 3; i++ {
+		resp, err := fasitHttpClient(RetryPolicy{}, transport).Get(server.URL)
+		assert.NoError(t, err)
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, opened+1, counterValue(fasitConnections.WithLabelValues("false")))
+	assert.Equal(t, reused+2, counterValue(fasitConnections.WithLabelValues("true")))
+	assert.Equal(t, open+1, gaugeValue(fasitOpenConnections))
+
+	transport.(*http.Transport).CloseIdleConnections()
+	assert.Equal(t, open, gaugeValue(fasitOpenConnections))
+}
+
+func TestFasitProxy(t *testing.T) {
+	_, err := NewFasitTransport(FasitTransportConfig{Proxy: "proxy:8080"})
+	assert.Error(t, err)
+
+	transport, err := NewFasitTransport(FasitTransportConfig{Proxy: "http://proxy.example.no:8080"})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest("GET", "https://fasit.example.no/api/v2/resources", nil)
+	proxy, err := transport.(*http.Transport).Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, &url.URL{Scheme: "http", Host: "proxy.example.no:8080"}, proxy)
+}
+
+func TestFasitCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	bundle, _ := ioutil.TempFile("", "fasit-ca")
+	defer os.Remove(bundle.Name())
+	pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	bundle.Close()
+
+	t.Run("Fasit is not trusted without the CA bundle", func(t *testing.T) {
+		transport, err := NewFasitTransport(FasitTransportConfig{})
+		assert.NoError(t, err)
+		_, err = fasitHealthClient(transport).Get(server.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("Fasit is trusted with the CA bundle", func(t *testing.T) {
+		transport, err := NewFasitTransport(FasitTransportConfig{CABundle: bundle.Name()})
+		assert.NoError(t, err)
+		resp, err := fasitHealthClient(transport).Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("Any certificate is accepted when verification is skipped", func(t *testing.T) {
+		transport, err := NewFasitTransport(FasitTransportConfig{InsecureSkipVerify: true})
+		assert.NoError(t, err)
+		resp, err := fasitHealthClient(transport).Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("CA bundle without certificates is rejected", func(t *testing.T) {
+		_, err := NewFasitTransport(FasitTransportConfig{CABundle: "/does/not/exist"})
+		assert.Error(t, err)
+
+		empty, _ := ioutil.TempFile("", "fasit-ca")
+		defer os.Remove(empty.Name())
+		empty.Close()
+		_, err = NewFasitTransport(FasitTransportConfig{CABundle: empty.Name()})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FeatureFlagsConfigMapKey is the key of the ConfigMap holding feature flags in the same format as the daemon config
+const FeatureFlagsConfigMapKey = "featureflags.yaml"
+
+// FeatureFlag gates a new naisd behavior. It is on for everyone when Enabled, otherwise only for the listed teams and namespaces.
+type FeatureFlag struct {
+	Enabled    bool     `json:"enabled"`
+	Teams      []string `json:"teams"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// FeatureFlags are the platform's feature flags, safe to replace while deployments are running
+type FeatureFlags struct {
+	mutex sync.RWMutex
+	flags map[string]FeatureFlag
+}
+
+func NewFeatureFlags(flags map[string]FeatureFlag) *FeatureFlags {
+	return &FeatureFlags{flags: flags}
+}
+
+// Enabled tells if the flag is on for the team and namespace. Unknown flags are off.
+func (f *FeatureFlags) Enabled(name, team, namespace string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	flag, ok := f.flags[name]
+	if !ok {
+		return false
+	}
+	return flag.Enabled || contains(flag.Teams, team) || contains(flag.Namespaces, namespace)
+}
+
+func (f *FeatureFlags) Replace(flags map[string]FeatureFlag) {
+	if f == nil {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.flags = flags
+}
+
+// Flags returns a copy of the feature flags, keyed by name
+func (f *FeatureFlags) Flags() map[string]FeatureFlag {
+	flags := map[string]FeatureFlag{}
+	if f == nil {
+		return flags
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	for name, flag := range f.flags {
+		flags[name] = flag
+	}
+	return flags
+}
+
+func (f *FeatureFlags) Names() []string {
+	names := []string{}
+	if f == nil {
+		return names
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	for name := range f.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reads feature flags from the ConfigMap given as namespace/name. A missing ConfigMap gives no flags.
+func loadFeatureFlags(configMap string, k8sClient kubernetes.Interface) (map[string]FeatureFlag, error) {
+	parts := strings.SplitN(configMap, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("feature flag configmap must be given as namespace/name, got %s", configMap)
+	}
+
+	existing, err := k8sClient.CoreV1().ConfigMaps(parts[0]).Get(parts[1], k8smeta.GetOptions{})
+	if errors.IsNotFound(err) {
+		return map[string]FeatureFlag{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get feature flag configmap: %s", err)
+	}
+
+	flags := map[string]FeatureFlag{}
+	if err := yaml.Unmarshal([]byte(existing.Data[FeatureFlagsConfigMapKey]), &flags); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal feature flags in %s: %s", configMap, err)
+	}
+	return flags, nil
+}
+
+// ReloadFeatureFlagsPeriodically replaces the feature flags with the ones in the ConfigMap given as namespace/name
+func (api Api) ReloadFeatureFlagsPeriodically(configMap string, interval time.Duration) {
+	for range time.Tick(interval) {
+		flags, err := loadFeatureFlags(configMap, api.Clientset)
+		if err != nil {
+			glog.Errorf("unable to reload feature flags: %s", err)
+			continue
+		}
+		api.FeatureFlags.Replace(flags)
+	}
+}
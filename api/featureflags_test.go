@@ -0,0 +1,67 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	flags := NewFeatureFlags(map[string]FeatureFlag{
+		"everyone": {Enabled: true},
+		"targeted": {Teams: []string{"aura"}, Namespaces: []string{"t1"}},
+	})
+
+	t.Run("Enabled flag is on for everyone", func(t *testing.T) {
+		assert.True(t, flags.Enabled("everyone", "other", "default"))
+	})
+
+	t.Run("Targeted flag is on for listed teams and namespaces only", func(t *testing.T) {
+		assert.True(t, flags.Enabled("targeted", "aura", "default"))
+		assert.True(t, flags.Enabled("targeted", "other", "t1"))
+		assert.False(t, flags.Enabled("targeted", "other", "default"))
+	})
+
+	t.Run("Unknown flag is off", func(t *testing.T) {
+		assert.False(t, flags.Enabled("unknown", "aura", "t1"))
+		assert.False(t, Api{}.FeatureFlags.Enabled("everyone", "aura", "t1"), "no flags are configured")
+	})
+
+	t.Run("Flags can be replaced", func(t *testing.T) {
+		flags := NewFeatureFlags(map[string]FeatureFlag{"a": {Enabled: true}})
+		flags.Replace(map[string]FeatureFlag{"b": {Enabled: true}})
+		assert.Equal(t, []string{"b"}, flags.Names())
+		assert.False(t, flags.Enabled("a", "", ""))
+	})
+}
+
+func TestLoadFeatureFlags(t *testing.T) {
+	configMap := &k8score.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{Name: "naisd-featureflags", Namespace: "nais"},
+		Data: map[string]string{FeatureFlagsConfigMapKey: `
+someNewBehavior:
+  teams: [aura]
+`},
+	}
+	clientset := fake.NewSimpleClientset(configMap)
+
+	t.Run("Flags are read from configmap", func(t *testing.T) {
+		flags, err := loadFeatureFlags("nais/naisd-featureflags", clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"aura"}, flags["someNewBehavior"].Teams)
+	})
+
+	t.Run("Missing configmap gives no flags", func(t *testing.T) {
+		flags, err := loadFeatureFlags("nais/missing", clientset)
+		assert.NoError(t, err)
+		assert.Empty(t, flags)
+	})
+
+	t.Run("Configmap must be given with namespace", func(t *testing.T) {
+		_, err := loadFeatureFlags("naisd-featureflags", clientset)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	FeatureToggleResourceType     = "FeatureToggle"
+	FeatureToggleLabel            = "nais.io/feature-toggles"
+	FeatureToggleSourceAnnotation = "nais.io/feature-toggle-source"
+	FeatureToggleMountPoint       = "/var/run/configmaps/featuretoggles/"
+	FeatureToggleVolumeName       = "feature-toggles"
+	FeatureTogglePathEnvVar       = "NAIS_FEATURE_TOGGLES_PATH"
+)
+
+// FeatureToggleSource records where the toggles in a ConfigMap came from, so that they can be kept in sync with Fasit
+type FeatureToggleSource struct {
+	Application string            `json:"application"`
+	Environment string            `json:"environment"`
+	Zone        string            `json:"zone"`
+	Resources   []ResourceRequest `json:"resources"`
+}
+
+func isFeatureToggle(resourceType string) bool {
+	return strings.EqualFold(resourceType, FeatureToggleResourceType)
+}
+
+func hasFeatureToggles(resources []NaisResource) bool {
+	for _, resource := range resources {
+		if isFeatureToggle(resource.resourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+func featureToggleConfigMapName(application string) string {
+	return application + "-featuretoggles"
+}
+
+func createFeatureToggleData(resources []NaisResource) map[string]string {
+	data := make(map[string]string)
+	for _, resource := range resources {
+		if !isFeatureToggle(resource.resourceType) {
+			continue
+		}
+		for property, value := range resource.properties {
+			data[resource.ToResourceVariable(property)] = value
+		}
+	}
+	return data
+}
+
+func createFeatureToggleEnvVar(application string, resource NaisResource, property string) k8score.EnvVar {
+	return k8score.EnvVar{
+		Name: resource.ToEnvironmentVariable(property),
+		ValueFrom: &k8score.EnvVarSource{
+			ConfigMapKeyRef: &k8score.ConfigMapKeySelector{
+				LocalObjectReference: k8score.LocalObjectReference{
+					Name: featureToggleConfigMapName(application),
+				},
+				Key: resource.ToResourceVariable(property),
+			},
+		},
+	}
+}
+
+// Environment variables are only read at startup, the mounted files are updated when the toggles change.
+// The path is exposed so that applications can watch it.
+func createFeatureTogglePathEnvVar() k8score.EnvVar {
+	return k8score.EnvVar{Name: FeatureTogglePathEnvVar, Value: FeatureToggleMountPoint}
+}
+
+func createFeatureToggleVolume(application string) k8score.Volume {
+	return k8score.Volume{
+		Name: FeatureToggleVolumeName,
+		VolumeSource: k8score.VolumeSource{
+			ConfigMap: &k8score.ConfigMapVolumeSource{
+				LocalObjectReference: k8score.LocalObjectReference{Name: featureToggleConfigMapName(application)},
+			},
+		},
+	}
+}
+
+func createFeatureToggleVolumeMount() k8score.VolumeMount {
+	return k8score.VolumeMount{
+		Name:      FeatureToggleVolumeName,
+		MountPath: FeatureToggleMountPoint,
+		ReadOnly:  true,
+	}
+}
+
+func featureToggleResourceRequests(manifest NaisManifest) []ResourceRequest {
+	var requests []ResourceRequest
+	for _, resource := range manifest.FasitResources.Used {
+		if isFeatureToggle(resource.ResourceType) {
+			requests = append(requests, ResourceRequest{Alias: resource.Alias, ResourceType: resource.ResourceType, PropertyMap: resource.PropertyMap})
+		}
+	}
+	return requests
+}
+
+func createOrUpdateFeatureToggleConfigMap(deploymentRequest naisrequest.Deploy, manifest NaisManifest, resources []NaisResource, k8sClient kubernetes.Interface) (*k8score.ConfigMap, error) {
+	name := featureToggleConfigMapName(deploymentRequest.Application)
+
+	existing, err := getExistingConfigMap(name, deploymentRequest.Namespace, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get existing feature toggle configmap: %s", err)
+	}
+
+	if !hasFeatureToggles(resources) {
+		if existing != nil {
+			return nil, k8sClient.CoreV1().ConfigMaps(deploymentRequest.Namespace).Delete(name, &k8smeta.DeleteOptions{})
+		}
+		return nil, nil
+	}
+
+	source, err := json.Marshal(FeatureToggleSource{
+		Application: deploymentRequest.Application,
+		Environment: deploymentRequest.FasitEnvironment,
+		Zone:        deploymentRequest.Zone,
+		Resources:   featureToggleResourceRequests(manifest),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal feature toggle source: %s", err)
+	}
+
+	configMap := existing
+	if configMap == nil {
+		configMap = createConfigMapDef(name, deploymentRequest.Namespace, manifest.Team)
+	}
+	configMap.Labels[FeatureToggleLabel] = "true"
+	if configMap.Annotations == nil {
+		configMap.Annotations = make(map[string]string)
+	}
+	configMap.Annotations[FeatureToggleSourceAnnotation] = string(source)
+	configMap.Data = createFeatureToggleData(resources)
+
+	if existing != nil {
+		return k8sClient.CoreV1().ConfigMaps(deploymentRequest.Namespace).Update(configMap)
+	}
+	return k8sClient.CoreV1().ConfigMaps(deploymentRequest.Namespace).Create(configMap)
+}
+
+// Fetches the feature toggles of every application from Fasit and updates the ConfigMaps that have changed.
+// Returns namespace/name of the updated ConfigMaps.
+func syncFeatureToggles(fasitForZone func(zone string) FasitClientAdapter, k8sClient kubernetes.Interface) ([]string, error) {
+	configMaps, err := k8sClient.CoreV1().ConfigMaps("").List(k8smeta.ListOptions{LabelSelector: FeatureToggleLabel + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list feature toggle configmaps: %s", err)
+	}
+
+	// an application that fails is skipped, so it does not keep the others from getting their toggles
+	var updated, failures []string
+	for _, configMap := range configMaps.Items {
+		var source FeatureToggleSource
+		if err := json.Unmarshal([]byte(configMap.Annotations[FeatureToggleSourceAnnotation]), &source); err != nil {
+			glog.Warningf("feature toggle configmap %s/%s has invalid source: %s", configMap.Namespace, configMap.Name, err)
+			continue
+		}
+
+		resources, err := fasitForZone(source.Zone).GetScopedResources(source.Resources, source.Environment, source.Application, source.Zone)
+		if err != nil {
+			glog.Warningf("unable to fetch feature toggles for %s/%s: %s", configMap.Namespace, source.Application, err)
+			continue
+		}
+
+		data := createFeatureToggleData(resources)
+		changed := !reflect.DeepEqual(data, configMap.Data)
+		if changed {
+			configMap.Data = data
+			if _, err := k8sClient.CoreV1().ConfigMaps(configMap.Namespace).Update(&configMap); err != nil {
+				failures = append(failures, fmt.Sprintf("unable to update feature toggles for %s/%s: %s", configMap.Namespace, source.Application, err))
+				continue
+			}
+			updated = append(updated, configMap.Namespace+"/"+configMap.Name)
+		}
+
+		// checked even when the ConfigMap is unchanged, in case the last sync updated it but not the deployment
+		if err := updateFeatureToggleChecksum(configMap.Namespace, source.Application, data, changed, k8sClient); err != nil {
+			failures = append(failures, fmt.Sprintf("unable to restart %s/%s with the new feature toggles: %s", configMap.Namespace, source.Application, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return updated, fmt.Errorf("%s", strings.Join(failures, ", "))
+	}
+	return updated, nil
+}
+
+// SyncFeatureTogglesPeriodically keeps feature toggle ConfigMaps in sync with Fasit until the process exits
+func (api Api) SyncFeatureTogglesPeriodically(interval time.Duration) {
+	fasitForZone := func(zone string) FasitClientAdapter {
+		return api.fasitBackend(api.fasitClient(&naisrequest.Deploy{Zone: zone}, true))
+	}
+
+	for range time.Tick(interval) {
+		updated, err := syncFeatureToggles(fasitForZone, api.Clientset)
+		for _, name := range updated {
+			glog.Infof("updated feature toggles in %s", name)
+		}
+		if err != nil {
+			glog.Errorf("unable to sync feature toggles: %s", err)
+		}
+	}
+}
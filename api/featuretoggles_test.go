@@ -0,0 +1,146 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func featureToggleResource(properties map[string]string) NaisResource {
+	return NaisResource{name: "toggles", resourceType: FeatureToggleResourceType, properties: properties}
+}
+
+func TestFeatureTogglesInPodSpec(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version, SkipFasit: true}
+	resources := []NaisResource{featureToggleResource(map[string]string{"newCheckout": "true"})}
+
+	spec, err := createPodSpec(deploymentRequest, newDefaultManifest(), resources)
+	assert.NoError(t, err)
+
+	container := spec.Containers[0]
+	toggle := container.Env[2]
+	assert.Equal(t, "TOGGLES_NEWCHECKOUT", toggle.Name)
+	assert.Equal(t, featureToggleConfigMapName(appName), toggle.ValueFrom.ConfigMapKeyRef.Name)
+	assert.Equal(t, "toggles_newcheckout", toggle.ValueFrom.ConfigMapKeyRef.Key)
+	assert.Equal(t, FeatureTogglePathEnvVar, container.Env[3].Name)
+
+	assert.Equal(t, featureToggleConfigMapName(appName), spec.Volumes[0].ConfigMap.Name)
+	assert.Equal(t, FeatureToggleMountPoint, container.VolumeMounts[0].MountPath)
+}
+
+func TestCreateOrUpdateFeatureToggleConfigMap(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, FasitEnvironment: "t1", Zone: "fss"}
+	manifest := NaisManifest{Team: teamName, FasitResources: FasitResources{Used: []UsedResource{{Alias: "toggles", ResourceType: FeatureToggleResourceType}}}}
+
+	t.Run("Toggles are stored in a configmap with their Fasit source", func(t *testing.T) {
+		configMap, err := createOrUpdateFeatureToggleConfigMap(deploymentRequest, manifest, []NaisResource{featureToggleResource(map[string]string{"newCheckout": "true"})}, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"toggles_newcheckout": "true"}, configMap.Data)
+		assert.Equal(t, "true", configMap.Labels[FeatureToggleLabel])
+		assert.Contains(t, configMap.Annotations[FeatureToggleSourceAnnotation], `"environment":"t1"`)
+	})
+
+	t.Run("Existing configmap is updated", func(t *testing.T) {
+		configMap, err := createOrUpdateFeatureToggleConfigMap(deploymentRequest, manifest, []NaisResource{featureToggleResource(map[string]string{"newCheckout": "false"})}, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, "false", configMap.Data["toggles_newcheckout"])
+	})
+
+	t.Run("Configmap is deleted when the toggles are no longer used", func(t *testing.T) {
+		configMap, err := createOrUpdateFeatureToggleConfigMap(deploymentRequest, NaisManifest{}, []NaisResource{}, clientset)
+		assert.NoError(t, err)
+		assert.Nil(t, configMap)
+
+		existing, _ := getExistingConfigMap(featureToggleConfigMapName(appName), namespace, clientset)
+		assert.Nil(t, existing)
+	})
+}
+
+func TestSyncFeatureToggles(t *testing.T) {
+	fasitUrl := "https://fasit.local"
+	clientset := fake.NewSimpleClientset()
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, FasitEnvironment: "t1", Zone: "fss"}
+	manifest := NaisManifest{Team: teamName, FasitResources: FasitResources{Used: []UsedResource{{Alias: "toggles", ResourceType: FeatureToggleResourceType}}}}
+	resources := []NaisResource{featureToggleResource(map[string]string{"newCheckout": "false"})}
+	_, err := createOrUpdateFeatureToggleConfigMap(deploymentRequest, manifest, resources, clientset)
+	assert.NoError(t, err)
+	deployedManifest := newDefaultManifest()
+	deployedManifest.FasitResources = manifest.FasitResources
+	_, err = createOrUpdateDeployment(deploymentRequest, deployedManifest, resources, false, clientset)
+	assert.NoError(t, err)
+	deployment, _ := getExistingDeployment(appName, namespace, clientset)
+	deployedChecksum := deployment.Spec.Template.Annotations[FeatureToggleChecksumAnnotation]
+	assert.NotEmpty(t, deployedChecksum)
+
+	fasitForZone := func(zone string) FasitClientAdapter {
+		return FasitClient{FasitUrl: fasitUrl}
+	}
+
+	defer gock.Off()
+	gock.New(fasitUrl).
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "toggles").
+		MatchParam("environment", "t1").
+		MatchParam("application", appName).
+		MatchParam("zone", "fss").
+		Times(2).
+		Reply(200).
+		BodyString(`{"alias": "toggles", "type": "FeatureToggle", "properties": {"newCheckout": "true"}}`)
+
+	updated, err := syncFeatureToggles(fasitForZone, clientset)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{namespace + "/" + featureToggleConfigMapName(appName)}, updated)
+
+	configMap, _ := getExistingConfigMap(featureToggleConfigMapName(appName), namespace, clientset)
+	assert.Equal(t, "true", configMap.Data["toggles_newcheckout"])
+	deployment, _ = getExistingDeployment(appName, namespace, clientset)
+	assert.Equal(t, featureToggleChecksum(configMap.Data), deployment.Spec.Template.Annotations[FeatureToggleChecksumAnnotation], "the pods are restarted with the new toggles")
+	assert.NotEqual(t, deployedChecksum, deployment.Spec.Template.Annotations[FeatureToggleChecksumAnnotation])
+
+	updated, err = syncFeatureToggles(fasitForZone, clientset)
+	assert.NoError(t, err)
+	assert.Empty(t, updated)
+	assert.True(t, gock.IsDone())
+}
+
+func TestSyncFeatureTogglesContinuesPastFailures(t *testing.T) {
+	fasitUrl := "https://fasit.local"
+	clientset := fake.NewSimpleClientset()
+	manifest := NaisManifest{Team: teamName, FasitResources: FasitResources{Used: []UsedResource{{Alias: "toggles", ResourceType: FeatureToggleResourceType}}}}
+	resources := []NaisResource{featureToggleResource(map[string]string{"newCheckout": "false"})}
+	for _, application := range []string{"broken", "working"} {
+		deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: application, FasitEnvironment: "t1", Zone: "fss"}
+		_, err := createOrUpdateFeatureToggleConfigMap(deploymentRequest, manifest, resources, clientset)
+		assert.NoError(t, err)
+	}
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.UpdateAction).GetObject().(*k8score.ConfigMap).Name == featureToggleConfigMapName("broken") {
+			return true, nil, fmt.Errorf("conflict")
+		}
+		return false, nil, nil
+	})
+
+	fasitForZone := func(zone string) FasitClientAdapter {
+		return FasitClient{FasitUrl: fasitUrl}
+	}
+
+	defer gock.Off()
+	gock.New(fasitUrl).
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "toggles").
+		Times(2).
+		Reply(200).
+		BodyString(`{"alias": "toggles", "type": "FeatureToggle", "properties": {"newCheckout": "true"}}`)
+
+	updated, err := syncFeatureToggles(fasitForZone, clientset)
+	assert.EqualError(t, err, "unable to update feature toggles for "+namespace+"/broken: conflict")
+	assert.Equal(t, []string{namespace + "/" + featureToggleConfigMapName("working")}, updated)
+}
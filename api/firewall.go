@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var firewallClient = &http.Client{Timeout: 10 * time.Second}
+
+// FirewallConfig is a network automation API that firewall opening requests are filed with
+type FirewallConfig struct {
+	Url   string
+	Token string
+}
+
+// ResourceEndpoint is the address of a used Fasit resource, found from its resolved properties
+type ResourceEndpoint struct {
+	Alias        string `json:"alias"`
+	ResourceType string `json:"resourceType"`
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+}
+
+type firewallRequest struct {
+	Requests []EgressRequest `json:"requests"`
+}
+
+// Returns the endpoints of the resources that have an address. Resources such as credentials and certificates are skipped.
+func resourceEndpoints(naisResources []NaisResource) []ResourceEndpoint {
+	var endpoints []ResourceEndpoint
+	for _, resource := range naisResources {
+		target, err := tcpDependencyTarget(resource.properties)
+		if err != nil {
+			continue
+		}
+
+		host, portString, _ := net.SplitHostPort(target)
+		port, err := strconv.Atoi(portString)
+		if err != nil {
+			continue
+		}
+
+		endpoints = append(endpoints, ResourceEndpoint{
+			Alias:        resource.name,
+			ResourceType: resource.resourceType,
+			Host:         host,
+			Port:         port,
+		})
+	}
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].Alias < endpoints[j].Alias
+	})
+
+	return endpoints
+}
+
+// Returns the openings of record that were not needed by the previous deployment of the application
+func newEgressRequests(previous *DeploymentRecord, record DeploymentRecord, egressIps map[string][]string) []EgressRequest {
+	existing := make(map[string]bool)
+	if previous != nil {
+		for _, request := range egressRequests([]DeploymentRecord{*previous}, egressIps) {
+			existing[request.Host+":"+strconv.Itoa(request.Port)+"/"+request.Protocol] = true
+		}
+	}
+
+	requests := []EgressRequest{}
+	for _, request := range egressRequests([]DeploymentRecord{record}, egressIps) {
+		if !existing[request.Host+":"+strconv.Itoa(request.Port)+"/"+request.Protocol] {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func fileFirewallRequests(config FirewallConfig, requests []EgressRequest) error {
+	payload, err := json.Marshal(firewallRequest{requests})
+	if err != nil {
+		return fmt.Errorf("unable to marshal firewall request: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", config.Url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to create firewall request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(config.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+
+	resp, err := firewallClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to contact network automation API: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("network automation API responded with HTTP %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Files requests for the openings the deployment needs that the previous deployment of the application did not
+func (api Api) requestFirewallOpenings(record DeploymentRecord) (int, error) {
+	if len(api.Firewall.Url) == 0 {
+		return 0, nil
+	}
+
+	var previous *DeploymentRecord
+	for _, latest := range api.DeploymentHistory.Latest() {
+		if latest.Environment == record.Environment && latest.Namespace == record.Namespace && latest.Application == record.Application {
+			latest := latest
+			previous = &latest
+		}
+	}
+
+	requests := newEgressRequests(previous, record, api.Egress.Ips)
+	if len(requests) == 0 {
+		return 0, nil
+	}
+
+	if err := fileFirewallRequests(api.Firewall, requests); err != nil {
+		return 0, err
+	}
+
+	for _, request := range requests {
+		api.AuditLog.Record(AuditEntry{
+			Event:       "firewall_request",
+			Application: record.Application,
+			Namespace:   record.Namespace,
+			Version:     record.Version,
+			Details:     map[string]string{"host": request.Host, "port": strconv.Itoa(request.Port), "protocol": request.Protocol, "resource": request.Resource},
+		})
+	}
+	glog.Infof("filed %d firewall requests for %s", len(requests), record.Application)
+
+	return len(requests), nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestResourceEndpoints(t *testing.T) {
+	endpoints := resourceEndpoints([]NaisResource{
+		{name: "mydb", resourceType: "DataSource", properties: map[string]string{"url": "jdbc:oracle:thin:@//db.example.com:1521/SERVICE"}},
+		{name: "creds", resourceType: "Credential", properties: map[string]string{"username": "user"}},
+		{name: "api", resourceType: "RestService", properties: map[string]string{"url": "https://api.example.com/rest"}},
+	})
+
+	assert.Equal(t, []ResourceEndpoint{
+		{Alias: "api", ResourceType: "RestService", Host: "api.example.com", Port: 443},
+		{Alias: "mydb", ResourceType: "DataSource", Host: "db.example.com", Port: 1521},
+	}, endpoints)
+}
+
+func TestNewEgressRequests(t *testing.T) {
+	egressIps := map[string][]string{"fss": {"10.1.0.1"}}
+	record := DeploymentRecord{
+		Application:       "app",
+		Namespace:         "default",
+		Zone:              "fss",
+		ExternalServices:  []ExternalService{{Host: "legacy.adeo.no", Port: 443}},
+		ResourceEndpoints: []ResourceEndpoint{{Alias: "mydb", ResourceType: "DataSource", Host: "db.example.com", Port: 1521}},
+	}
+
+	t.Run("All openings are new on first deployment", func(t *testing.T) {
+		requests := newEgressRequests(nil, record, egressIps)
+		assert.Equal(t, 2, len(requests))
+		assert.Equal(t, "mydb", requests[1].Resource)
+		assert.Equal(t, []string{"10.1.0.1"}, requests[1].SourceIps)
+	})
+
+	t.Run("Openings of the previous deployment are not requested again", func(t *testing.T) {
+		previous := DeploymentRecord{Application: "app", Namespace: "default", ExternalServices: []ExternalService{{Host: "legacy.adeo.no", Port: 443}}}
+		requests := newEgressRequests(&previous, record, egressIps)
+		assert.Equal(t, 1, len(requests))
+		assert.Equal(t, "db.example.com", requests[0].Host)
+	})
+}
+
+func TestRequestFirewallOpenings(t *testing.T) {
+	record := DeploymentRecord{
+		Application:       "app",
+		Namespace:         "default",
+		ResourceEndpoints: []ResourceEndpoint{{Alias: "mydb", ResourceType: "DataSource", Host: "db.example.com", Port: 1521}},
+	}
+
+	t.Run("Nothing is filed without a configured API", func(t *testing.T) {
+		count, err := Api{}.requestFirewallOpenings(record)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("New openings are filed with the network automation API", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://netauto.local").
+			Post("/api/requests").
+			MatchHeader("Authorization", "Bearer secret").
+			BodyString(`"host":"db.example.com"`).
+			Reply(201)
+
+		api := Api{Firewall: FirewallConfig{Url: "https://netauto.local/api/requests", Token: "secret"}, DeploymentHistory: NewDeploymentHistory()}
+		count, err := api.requestFirewallOpenings(record)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Openings already deployed are not filed again", func(t *testing.T) {
+		history := NewDeploymentHistory()
+		history.Add(record)
+
+		api := Api{Firewall: FirewallConfig{Url: "https://netauto.local/api/requests"}, DeploymentHistory: history}
+		count, err := api.requestFirewallOpenings(record)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("Error from the API is returned", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://netauto.local").
+			Post("/api/requests").
+			Reply(500)
+
+		api := Api{Firewall: FirewallConfig{Url: "https://netauto.local/api/requests"}}
+		_, err := api.requestFirewallOpenings(record)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"strings"
+)
+
+// HealthCheckUrls are the public URLs of an application's health checks, registered in Fasit for monitoring to pick up
+type HealthCheckUrls struct {
+	IsAlive  string `json:"isalive,omitempty"`
+	IsReady  string `json:"isready,omitempty"`
+	SelfTest string `json:"selftest,omitempty"`
+}
+
+func healthCheckUrl(hostname, path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return "https://" + hostname + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Derives the health check URLs from the probes in the manifest. Applications without an ingress have none.
+func healthCheckUrls(manifest NaisManifest, hostname string) HealthCheckUrls {
+	if manifest.Ingress.Disabled || len(hostname) == 0 {
+		return HealthCheckUrls{}
+	}
+
+	return HealthCheckUrls{
+		IsAlive:  healthCheckUrl(hostname, manifest.Healthcheck.Liveness.httpPath()),
+		IsReady:  healthCheckUrl(hostname, manifest.Healthcheck.Readiness.httpPath()),
+		SelfTest: healthCheckUrl(hostname, manifest.Healthcheck.Selftest),
+	}
+}
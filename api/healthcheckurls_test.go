@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckUrls(t *testing.T) {
+	manifest := NaisManifest{Healthcheck: Healthcheck{
+		Liveness:  Probe{Path: "isAlive"},
+		Readiness: Probe{Path: "/internal/isReady"},
+		Selftest:  "internal/selftest",
+	}}
+
+	t.Run("Probe paths are made into URLs on the ingress hostname", func(t *testing.T) {
+		assert.Equal(t, HealthCheckUrls{
+			IsAlive:  "https://app.nais.example.no/isAlive",
+			IsReady:  "https://app.nais.example.no/internal/isReady",
+			SelfTest: "https://app.nais.example.no/internal/selftest",
+		}, healthCheckUrls(manifest, "app.nais.example.no"))
+	})
+
+	t.Run("Applications without ingress have no health check URLs", func(t *testing.T) {
+		withoutIngress := manifest
+		withoutIngress.Ingress.Disabled = true
+		assert.Equal(t, HealthCheckUrls{}, healthCheckUrls(withoutIngress, "app.nais.example.no"))
+	})
+
+	t.Run("Health check URLs are included in the application instance payload", func(t *testing.T) {
+		payload, err := json.Marshal(buildApplicationInstancePayload(naisrequest.Deploy{Application: "app", Version: "1"}, "t1", "nais.example.no", nil, nil, healthCheckUrls(manifest, "app.nais.example.no"), ApplicationInstanceConfig{}))
+		assert.NoError(t, err)
+		assert.Contains(t, string(payload), `"isalive":"https://app.nais.example.no/isAlive","isready":"https://app.nais.example.no/internal/isReady","selftest":"https://app.nais.example.no/internal/selftest"`)
+	})
+}
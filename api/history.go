@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const maxDeploymentRecords = 10000
+
+type DeploymentRecord struct {
+	Sequence          uint64             `json:"sequence"`
+	Timestamp         time.Time          `json:"timestamp"`
+	DeploymentId      string             `json:"deploymentId,omitempty"`
+	Result            string             `json:"result,omitempty"`
+	Application       string             `json:"application"`
+	Namespace         string             `json:"namespace"`
+	Version           string             `json:"version"`
+	PreviousVersion   string             `json:"previousVersion,omitempty"`
+	Environment       string             `json:"environment,omitempty"`
+	Zone              string             `json:"zone"`
+	Cluster           string             `json:"cluster"`
+	Team              string             `json:"team,omitempty"`
+	DeployedBy        string             `json:"deployedBy,omitempty"`
+	FasitResources    FasitResources     `json:"fasitResources"`
+	ManifestChecksum  string             `json:"manifestChecksum,omitempty"`
+	ExternalServices  []ExternalService  `json:"externalServices,omitempty"`
+	ResourceEndpoints []ResourceEndpoint `json:"resourceEndpoints,omitempty"`
+	spec              *deploymentSpec
+	promotion         *deploymentSpec
+}
+
+// deploymentSpec is what a deployment applied to Kubernetes, kept so it can be applied again without the manifest or
+// Fasit. The Fasit credentials of the request are not kept.
+type deploymentSpec struct {
+	request   naisrequest.Deploy
+	manifest  NaisManifest
+	resources []NaisResource
+
+	// the manifest as the team declared it, for checking the deployment policy when a dark launch is promoted
+	declared NaisManifest
+}
+
+// Records without a result are from before failed deployments were recorded, when only successful ones were
+func (record DeploymentRecord) succeeded() bool {
+	return len(record.Result) == 0 || record.Result == DeploymentSucceeded
+}
+
+// DeploymentHistory keeps the most recent deployments in memory, successful or not
+type DeploymentHistory struct {
+	mutex    sync.RWMutex
+	records  []DeploymentRecord
+	sequence uint64
+	store    StateStore
+}
+
+func NewDeploymentHistory() *DeploymentHistory {
+	return &DeploymentHistory{}
+}
+
+func (h *DeploymentHistory) Add(record DeploymentRecord) {
+	if h == nil {
+		return
+	}
+
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+
+	h.mutex.Lock()
+	h.sequence++
+	record.Sequence = h.sequence
+	h.records = append(h.records, record)
+	if len(h.records) > maxDeploymentRecords {
+		h.records = h.records[len(h.records)-maxDeploymentRecords:]
+	}
+	store := h.store
+	h.mutex.Unlock()
+
+	// the store is written without holding the lock, so a slow Kubernetes API or database does not hold up readers of
+	// the history. Concurrent records may be stored out of order, and are sorted again when they are restored.
+	saveState(store, StateDeploymentHistory, record.Sequence, record, maxDeploymentRecords)
+}
+
+// Restore loads the deployments kept in store, and keeps every deployment added from now on in it too. Restored
+// deployments can not be redeployed, as what they applied to Kubernetes is not kept.
+func (h *DeploymentHistory) Restore(store StateStore) error {
+	entries, err := store.Load(StateDeploymentHistory)
+	if err != nil {
+		return err
+	}
+
+	records := make([]DeploymentRecord, 0, len(entries))
+	for _, entry := range entries {
+		var record DeploymentRecord
+		if err := json.Unmarshal(entry, &record); err != nil {
+			return fmt.Errorf("unable to restore deployment history: %s", err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Sequence < records[j].Sequence })
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.records = append(records, h.records...)
+	if len(h.records) > maxDeploymentRecords {
+		h.records = h.records[len(h.records)-maxDeploymentRecords:]
+	}
+	if len(records) > 0 && records[len(records)-1].Sequence > h.sequence {
+		h.sequence = records[len(records)-1].Sequence
+	}
+	h.store = store
+	return nil
+}
+
+func (h *DeploymentHistory) Records() []DeploymentRecord {
+	if h == nil {
+		return []DeploymentRecord{}
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	records := make([]DeploymentRecord, len(h.records))
+	copy(records, h.records)
+	return records
+}
+
+// Replace discards the deployments in the history, and keeps records, oldest first, in their place. Sequence numbers
+// are kept, so cursors into a history that is moved to another naisd stay valid.
+func (h *DeploymentHistory) Replace(records []DeploymentRecord) error {
+	if len(records) > maxDeploymentRecords {
+		records = records[len(records)-maxDeploymentRecords:]
+	}
+
+	entries := make([]StateEntry, len(records))
+	for i, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		entries[i] = StateEntry{Sequence: record.Sequence, Entry: b}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.store != nil {
+		if err := h.store.Replace(StateDeploymentHistory, entries); err != nil {
+			return fmt.Errorf("unable to store deployment history: %s", err)
+		}
+	}
+
+	h.records = make([]DeploymentRecord, len(records))
+	copy(h.records, records)
+	if len(records) > 0 {
+		h.sequence = records[len(records)-1].Sequence
+	}
+	return nil
+}
+
+// Succeeded returns the records of successful deployments
+func (h *DeploymentHistory) Succeeded() []DeploymentRecord {
+	succeeded := []DeploymentRecord{}
+	for _, record := range h.Records() {
+		if record.succeeded() {
+			succeeded = append(succeeded, record)
+		}
+	}
+	return succeeded
+}
+
+// Latest returns the most recent successful deployment of every application, keyed by environment, namespace and
+// application
+func (h *DeploymentHistory) Latest() []DeploymentRecord {
+	var latest []DeploymentRecord
+	index := make(map[string]int)
+
+	for _, record := range h.Succeeded() {
+		key := record.Environment + "/" + record.Namespace + "/" + record.Application
+		if i, ok := index[key]; ok {
+			latest[i] = record
+			continue
+		}
+		index[key] = len(latest)
+		latest = append(latest, record)
+	}
+
+	return latest
+}
+
+// previousVersion returns the version of the last successful deployment of the application, if naisd remembers it
+func (h *DeploymentHistory) previousVersion(environment, namespace, application string) string {
+	records := h.Succeeded()
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Environment == environment && records[i].Namespace == namespace && records[i].Application == application {
+			return records[i].Version
+		}
+	}
+	return ""
+}
+
+// lastDarkLaunch returns the most recent successful dark launch with a spec that deploys its version as the application
+func (h *DeploymentHistory) lastDarkLaunch(namespace, darkLaunch string) (DeploymentRecord, bool) {
+	records := h.Succeeded()
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Namespace == namespace && record.Application == darkLaunch {
+			return record, record.promotion != nil
+		}
+	}
+	return DeploymentRecord{}, false
+}
+
+// lastKnownGood returns the most recent successful deployment of the application with a spec that can be applied again
+func (h *DeploymentHistory) lastKnownGood(environment, namespace, application string) (DeploymentRecord, bool) {
+	records := h.Succeeded()
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Environment == environment && record.Namespace == namespace && record.Application == application {
+			return record, record.spec != nil
+		}
+	}
+	return DeploymentRecord{}, false
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentHistory(t *testing.T) {
+	t.Run("Latest gives most recent deployment per application", func(t *testing.T) {
+		history := NewDeploymentHistory()
+		history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "t1", Version: "1"})
+		history.Add(DeploymentRecord{Application: "other", Namespace: "default", Environment: "t1", Version: "1"})
+		history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "t1", Version: "2"})
+		history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "q1", Version: "1"})
+
+		latest := history.Latest()
+		assert.Equal(t, 3, len(latest))
+		assert.Equal(t, "2", latest[0].Version)
+		assert.Equal(t, "other", latest[1].Application)
+		assert.Equal(t, "q1", latest[2].Environment)
+		assert.Equal(t, 4, len(history.Records()))
+		assert.False(t, history.Records()[0].Timestamp.IsZero())
+	})
+
+	t.Run("History is capped", func(t *testing.T) {
+		history := NewDeploymentHistory()
+		for i := 0; i < maxDeploymentRecords+5; i++ {
+			history.Add(DeploymentRecord{Application: "app"})
+		}
+		assert.Equal(t, maxDeploymentRecords, len(history.Records()))
+	})
+
+	t.Run("Nil history is empty", func(t *testing.T) {
+		var history *DeploymentHistory
+		history.Add(DeploymentRecord{Application: "app"})
+		assert.Empty(t, history.Records())
+		assert.Empty(t, history.Latest())
+	})
+}
+
+func TestFailedDeploymentsInHistory(t *testing.T) {
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "t1", Version: "1", Result: DeploymentSucceeded})
+	history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "t1", Version: "2", Result: DeploymentFailed})
+
+	assert.Equal(t, 2, len(history.Records()))
+	assert.Equal(t, 1, len(history.Succeeded()))
+	assert.Equal(t, "1", history.Latest()[0].Version)
+	assert.Equal(t, "1", history.previousVersion("t1", "default", "app"))
+	assert.Empty(t, history.previousVersion("q1", "default", "app"))
+}
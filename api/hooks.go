@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	k8sbatch "k8s.io/api/batch/v1"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	PhasePreDeploy  = "pre-deploy"
+	PhasePostDeploy = "post-deploy"
+
+	HookPolicyFail = "fail"
+	HookPolicyWarn = "warn"
+
+	// HookLabel names the hook a Job runs, so the Jobs of earlier deployments can be found and replaced
+	HookLabel = "nais.io/hook"
+	// HookApplicationLabel names the application on the pods of a hook. They are not labelled app=<application>, so
+	// the application's Service, network policies and pod disruption budget do not select them.
+	HookApplicationLabel = "nais.io/hook-application"
+
+	maxHookNameLength = 20
+)
+
+// How long a hook may run, unless its manifest says otherwise
+var defaultHookTimeout = 5 * time.Minute
+
+var validHookName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Hooks are run as Kubernetes Jobs in the namespace of the application, one at a time: preDeploy before anything is
+// applied, and postDeploy once the new version has rolled out, e.g. to warm caches or run smoke tests
+type Hooks struct {
+	PreDeploy  []Hook `yaml:"preDeploy"`
+	PostDeploy []Hook `yaml:"postDeploy"`
+}
+
+// Hook is a command run to completion. The image defaults to the application's own, and policy says whether a hook
+// that fails fails the deployment (fail, the default) or is reported as a warning (warn).
+type Hook struct {
+	Name    string
+	Image   string
+	Command []string
+	Timeout string
+	Policy  string
+}
+
+func (hook Hook) timeout() time.Duration {
+	if timeout, err := time.ParseDuration(hook.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultHookTimeout
+}
+
+func validateHooks(manifest NaisManifest) *ValidationError {
+	for phase, hooks := range map[string][]Hook{"PreDeploy": manifest.Hooks.PreDeploy, "PostDeploy": manifest.Hooks.PostDeploy} {
+		names := make(map[string]bool)
+		for _, hook := range hooks {
+			if len(hook.Name) > maxHookNameLength || !validHookName.MatchString(hook.Name) {
+				return &ValidationError{
+					fmt.Sprintf("Hooks must have a name of at most %d lower case letters, digits and dashes", maxHookNameLength),
+					map[string]string{"Hooks." + phase: hook.Name},
+				}
+			}
+			if names[hook.Name] {
+				return &ValidationError{
+					"Hook names must be unique",
+					map[string]string{"Hooks." + phase: hook.Name},
+				}
+			}
+			names[hook.Name] = true
+
+			if len(hook.Timeout) > 0 {
+				if timeout, err := time.ParseDuration(hook.Timeout); err != nil || timeout <= 0 {
+					return &ValidationError{
+						"Hook timeout must be a positive duration, e.g. 5m",
+						map[string]string{"Hooks." + phase: hook.Name, "Timeout": hook.Timeout},
+					}
+				}
+			}
+
+			if hook.Policy != "" && hook.Policy != HookPolicyFail && hook.Policy != HookPolicyWarn {
+				return &ValidationError{
+					fmt.Sprintf("Hook policy must be %s or %s", HookPolicyFail, HookPolicyWarn),
+					map[string]string{"Hooks." + phase: hook.Name, "Policy": hook.Policy},
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// runHooks runs the hooks of a phase in order. A hook that fails stops the deployment, unless its policy is warn, in
+// which case the failure is returned as a warning and the next hook is run.
+func (api Api) runHooks(deployment *trackedDeployment, phase string, hooks []Hook, deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource) ([]string, *appError) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+
+	if appErr := api.enterPhase(deployment, phase); appErr != nil {
+		return nil, appErr
+	}
+
+	var warnings []string
+	for _, hook := range hooks {
+		err := api.runHook(deployment, hook, deploymentRequest, manifest, naisResources)
+		switch {
+		case err == nil:
+			continue
+		case deployment.context().Err() != nil:
+			return warnings, api.enterPhase(deployment, phase)
+		case hook.Policy == HookPolicyWarn:
+			glog.Warningf("%s hook %s of %s failed: %s", phase, hook.Name, deploymentRequest.Application, err)
+			warnings = append(warnings, fmt.Sprintf("%s hook %s failed: %s", phase, hook.Name, err))
+		default:
+			return warnings, &appError{err, fmt.Sprintf("%s hook %s failed", phase, hook.Name), http.StatusInternalServerError}
+		}
+	}
+
+	return warnings, nil
+}
+
+// runHook replaces the Job of the hook from earlier deployments, and waits for the new one to finish
+func (api Api) runHook(deployment *trackedDeployment, hook Hook, deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource) error {
+	job, err := createHookJob(deployment.Id, hook, deploymentRequest, manifest, naisResources)
+	if err != nil {
+		return err
+	}
+
+	jobs := api.Clientset.BatchV1().Jobs(deploymentRequest.Namespace)
+	if err := api.deleteHookJobs(deploymentRequest, hook); err != nil {
+		return err
+	}
+	if _, err := jobs.Create(job); err != nil {
+		return fmt.Errorf("unable to create job %s: %s", job.Name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(deployment.context(), hook.timeout())
+	defer cancel()
+
+	for {
+		current, err := jobs.Get(job.Name, k8smeta.GetOptions{})
+		switch {
+		case err != nil:
+			glog.Warningf("unable to get status of job %s: %s", job.Name, err)
+		case current.Status.Succeeded > 0:
+			return nil
+		case current.Status.Failed > 0:
+			return fmt.Errorf("job %s failed, see kubectl logs -n %s job/%s", job.Name, job.Namespace, job.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			// the job is stopped, so it does not outlive the deployment that started it
+			background := k8smeta.DeletePropagationBackground
+			jobs.Delete(job.Name, &k8smeta.DeleteOptions{PropagationPolicy: &background})
+			if deployment.context().Err() != nil {
+				return deployment.context().Err()
+			}
+			return fmt.Errorf("job %s did not finish within %s", job.Name, hook.timeout())
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+func (api Api) deleteHookJobs(deploymentRequest naisrequest.Deploy, hook Hook) error {
+	jobs := api.Clientset.BatchV1().Jobs(deploymentRequest.Namespace)
+	existing, err := jobs.List(k8smeta.ListOptions{LabelSelector: fmt.Sprintf("app=%s,%s=%s", deploymentRequest.Application, HookLabel, hook.Name)})
+	if err != nil {
+		return fmt.Errorf("unable to list jobs of hook %s: %s", hook.Name, err)
+	}
+
+	background := k8smeta.DeletePropagationBackground
+	for _, job := range existing.Items {
+		if err := jobs.Delete(job.Name, &k8smeta.DeleteOptions{PropagationPolicy: &background}); err != nil {
+			return fmt.Errorf("unable to delete job %s of an earlier deployment: %s", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// hookJobName is unique per deployment, shortening the application name to fit the 63 characters of a label value
+func hookJobName(application, hook, deploymentId string) string {
+	suffix := "-" + hook + "-" + deploymentId[:8]
+	if len(application)+len(suffix) > maxApplicationNameLength {
+		application = application[:maxApplicationNameLength-len(suffix)]
+	}
+	return application + suffix
+}
+
+// createHookJob runs the hook once, with the environment variables of the application. The hook can read the
+// application's secret, but it is optional, as it is not created until the first deployment has been applied.
+func createHookJob(deploymentId string, hook Hook, deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource) (*k8sbatch.Job, error) {
+	envVars, err := createEnvironmentVariables(deploymentRequest, manifest, naisResources)
+	if err != nil {
+		return nil, err
+	}
+
+	optional := true
+	for _, envVar := range envVars {
+		if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+			envVar.ValueFrom.SecretKeyRef.Optional = &optional
+		}
+	}
+
+	image := hook.Image
+	if len(image) == 0 {
+		image = fmt.Sprintf("%s:%s", manifest.Image, deploymentRequest.Version)
+	}
+
+	backoffLimit := int32(0)
+	activeDeadline := int64(hook.timeout().Seconds())
+	labels := map[string]string{"app": deploymentRequest.Application, HookLabel: hook.Name}
+	podLabels := map[string]string{HookApplicationLabel: deploymentRequest.Application, HookLabel: hook.Name}
+
+	return &k8sbatch.Job{
+		TypeMeta: k8smeta.TypeMeta{Kind: "Job", APIVersion: "batch/v1"},
+		ObjectMeta: k8smeta.ObjectMeta{
+			Name:      hookJobName(deploymentRequest.Application, hook.Name, deploymentId),
+			Namespace: deploymentRequest.Namespace,
+			Labels:    labels,
+		},
+		Spec: k8sbatch.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadline,
+			Template: k8score.PodTemplateSpec{
+				ObjectMeta: k8smeta.ObjectMeta{Labels: podLabels},
+				Spec: k8score.PodSpec{
+					RestartPolicy: k8score.RestartPolicyNever,
+					Containers: []k8score.Container{{
+						Name:    hook.Name,
+						Image:   image,
+						Command: hook.Command,
+						Env:     envVars,
+					}},
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sbatch "k8s.io/api/batch/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestValidateHooks(t *testing.T) {
+	hooks := func(hooks ...Hook) NaisManifest {
+		return NaisManifest{Hooks: Hooks{PreDeploy: hooks}}
+	}
+
+	assert.Nil(t, validateHooks(hooks(Hook{Name: "migrate", Timeout: "10m", Policy: HookPolicyFail}, Hook{Name: "warmup", Policy: HookPolicyWarn})))
+	assert.NotNil(t, validateHooks(hooks(Hook{Name: "Migrate"})))
+	assert.NotNil(t, validateHooks(hooks(Hook{Name: "a-hook-name-that-is-too-long"})))
+	assert.NotNil(t, validateHooks(hooks(Hook{Name: "migrate"}, Hook{Name: "migrate"})))
+	assert.NotNil(t, validateHooks(hooks(Hook{Name: "migrate", Timeout: "forever"})))
+	assert.NotNil(t, validateHooks(hooks(Hook{Name: "migrate", Policy: "ignore"})))
+	assert.NotNil(t, validateHooks(NaisManifest{Hooks: Hooks{PostDeploy: []Hook{{Name: ""}}}}))
+}
+
+func TestHookJobName(t *testing.T) {
+	assert.Equal(t, "app-migrate-0123abcd", hookJobName("app", "migrate", "0123abcd4567ef89"))
+
+	name := hookJobName(strings.Repeat("a", 63), "migrate", "0123abcd4567ef89")
+	assert.Len(t, name, maxApplicationNameLength)
+	assert.True(t, strings.HasSuffix(name, "-migrate-0123abcd"))
+}
+
+func TestCreateHookJob(t *testing.T) {
+	manifest := newDefaultManifest()
+	manifest.Image = "docker.adeo.no:5000/appname"
+	request := naisrequest.Deploy{Application: appName, Version: version, Namespace: namespace}
+	resources := []NaisResource{{name: "db", resourceType: "datasource", secret: map[string]string{"password": "secret"}}}
+
+	job, err := createHookJob("0123abcd4567ef89", Hook{Name: "migrate", Command: []string{"/migrate.sh"}, Timeout: "2m"}, request, manifest, resources)
+	assert.NoError(t, err)
+	assert.Equal(t, "appname-migrate-0123abcd", job.Name)
+	assert.Equal(t, map[string]string{"app": appName, HookLabel: "migrate"}, job.Labels)
+	assert.Equal(t, map[string]string{HookApplicationLabel: appName, HookLabel: "migrate"}, job.Spec.Template.Labels)
+	assert.Equal(t, int32(0), *job.Spec.BackoffLimit)
+	assert.Equal(t, int64(120), *job.Spec.ActiveDeadlineSeconds)
+
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "docker.adeo.no:5000/appname:13", container.Image)
+	assert.Equal(t, []string{"/migrate.sh"}, container.Command)
+	for _, envVar := range container.Env {
+		if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+			assert.True(t, *envVar.ValueFrom.SecretKeyRef.Optional, "the application's secret does not exist before the first deployment")
+		}
+	}
+
+	job, _ = createHookJob("0123abcd4567ef89", Hook{Name: "smoketest", Image: "navikt/smoketest:1"}, request, manifest, nil)
+	assert.Equal(t, "navikt/smoketest:1", job.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, int64(defaultHookTimeout.Seconds()), *job.Spec.ActiveDeadlineSeconds)
+}
+
+// Gives jobs the status of the hook they run when naisd asks for them
+func hookJobStatuses(clientset *fake.Clientset, statuses map[string]k8sbatch.JobStatus) {
+	clientset.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.GetAction).GetName()
+		for hook, status := range statuses {
+			if strings.Contains(name, "-"+hook+"-") {
+				return true, &k8sbatch.Job{ObjectMeta: k8smeta.ObjectMeta{Name: name}, Status: status}, nil
+			}
+		}
+		return false, nil, nil
+	})
+}
+
+func TestRunHooks(t *testing.T) {
+	request := naisrequest.Deploy{Application: appName, Version: version, Namespace: namespace}
+	manifest := newDefaultManifest()
+
+	t.Run("Hooks run in order, replacing the jobs of earlier deployments", func(t *testing.T) {
+		earlier := &k8sbatch.Job{ObjectMeta: k8smeta.ObjectMeta{Name: "appname-migrate-earlier", Namespace: namespace, Labels: map[string]string{"app": appName, HookLabel: "migrate"}}}
+		clientset := fake.NewSimpleClientset(earlier)
+		hookJobStatuses(clientset, map[string]k8sbatch.JobStatus{"migrate": {Succeeded: 1}, "warmup": {Succeeded: 1}})
+		api := Api{Clientset: clientset}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+
+		warnings, appErr := api.runHooks(deployment, PhasePreDeploy, []Hook{{Name: "migrate"}, {Name: "warmup"}}, request, manifest, nil)
+		assert.Nil(t, appErr)
+		assert.Empty(t, warnings)
+		assert.Equal(t, PhasePreDeploy, deployment.Phase)
+
+		jobs, _ := clientset.BatchV1().Jobs(namespace).List(k8smeta.ListOptions{})
+		assert.Len(t, jobs.Items, 2)
+		for _, job := range jobs.Items {
+			assert.NotEqual(t, "appname-migrate-earlier", job.Name)
+		}
+	})
+
+	t.Run("Failing hooks fail the deployment unless their policy is warn", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		hookJobStatuses(clientset, map[string]k8sbatch.JobStatus{"smoketest": {Failed: 1}, "warmup": {Failed: 1}})
+		api := Api{Clientset: clientset}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+
+		warnings, appErr := api.runHooks(deployment, PhasePostDeploy, []Hook{{Name: "warmup", Policy: HookPolicyWarn}, {Name: "smoketest"}}, request, manifest, nil)
+		assert.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "post-deploy hook warmup failed")
+		assert.Equal(t, http.StatusInternalServerError, appErr.StatusCode)
+		assert.Equal(t, "post-deploy hook smoketest failed", appErr.Message)
+	})
+
+	t.Run("Hooks that do not finish in time are stopped", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		api := Api{Clientset: clientset}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+
+		_, appErr := api.runHooks(deployment, PhasePreDeploy, []Hook{{Name: "migrate", Timeout: "10ms"}}, request, manifest, nil)
+		assert.NotNil(t, appErr)
+		assert.Contains(t, appErr.OriginalError.Error(), "did not finish within 10ms")
+
+		jobs, _ := clientset.BatchV1().Jobs(namespace).List(k8smeta.ListOptions{})
+		assert.Empty(t, jobs.Items)
+	})
+}
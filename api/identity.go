@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Identity is a person or pipeline that acts for one or more teams, e.g. to approve a pipeline stage or the transfer of
+// an application. It authenticates with its token as a bearer token, like the operator.
+type Identity struct {
+	Name  string
+	Teams []string
+	Token string
+
+	operator bool
+}
+
+// OperatorIdentity is who requests with the operator token act as. The operator acts for every team.
+const OperatorIdentity = "operator"
+
+// actsFor is true if the identity can act for the team
+func (identity Identity) actsFor(team string) bool {
+	if identity.operator {
+		return true
+	}
+	for _, t := range identity.Teams {
+		if len(team) > 0 && t == team {
+			return true
+		}
+	}
+	return false
+}
+
+func validateIdentities(identities []Identity, operatorToken string) error {
+	tokens := make(map[string]string)
+	for _, identity := range identities {
+		switch {
+		case len(identity.Name) == 0:
+			return fmt.Errorf("identities must have a name")
+		case len(identity.Token) == 0:
+			return fmt.Errorf("identity %s has no token", identity.Name)
+		case len(identity.Teams) == 0:
+			return fmt.Errorf("identity %s has no teams", identity.Name)
+		case identity.Token == operatorToken:
+			return fmt.Errorf("identity %s has the operator token", identity.Name)
+		}
+		if other, ok := tokens[identity.Token]; ok {
+			return fmt.Errorf("identities %s and %s have the same token", other, identity.Name)
+		}
+		tokens[identity.Token] = identity.Name
+	}
+	return nil
+}
+
+// authenticate returns the identity whose token the request carries as its bearer token
+func (api Api) authenticate(r *http.Request) (Identity, bool) {
+	if api.isOperator(r) {
+		return Identity{Name: OperatorIdentity, operator: true}, true
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Identity{}, false
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for _, identity := range api.Identities {
+		if subtle.ConstantTimeCompare(token, []byte(identity.Token)) == 1 {
+			return identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+// authorizeTeam authenticates the request as an identity acting for the team, the operator or one of its members
+func (api Api) authorizeTeam(r *http.Request, team string) (Identity, *appError) {
+	identity, ok := api.authenticate(r)
+	if !ok {
+		return Identity{}, &appError{fmt.Errorf("missing or unknown bearer token"), "not authorized", http.StatusUnauthorized}
+	}
+	if !identity.actsFor(team) {
+		return identity, &appError{fmt.Errorf("%s does not act for team %q", identity.Name, team), "not authorized for team", http.StatusForbidden}
+	}
+	return identity, nil
+}
+
+// applicationTeam is the team the application's deployment in the namespace is labelled with, empty if it has none
+func (api Api) applicationTeam(namespace, application string) (string, error) {
+	deployment, err := getExistingDeployment(application, namespace, api.Clientset)
+	if err != nil || deployment == nil {
+		return "", err
+	}
+	return deployment.Labels["team"], nil
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func requestWithToken(token string) *http.Request {
+	return requestWithMethod("POST", "/", token)
+}
+
+func TestAuthorizeTeam(t *testing.T) {
+	api := Api{OperatorToken: "operator", Identities: []Identity{
+		{Name: "alice", Teams: []string{"aura"}, Token: "alice-token"},
+		{Name: "bob", Teams: []string{"other", "aura"}, Token: "bob-token"},
+	}}
+
+	identity, appErr := api.authorizeTeam(requestWithToken("alice-token"), "aura")
+	assert.Nil(t, appErr)
+	assert.Equal(t, "alice", identity.Name)
+
+	identity, appErr = api.authorizeTeam(requestWithToken("operator"), "aura")
+	assert.Nil(t, appErr)
+	assert.Equal(t, OperatorIdentity, identity.Name)
+
+	_, appErr = api.authorizeTeam(requestWithToken("alice-token"), "other")
+	assert.Equal(t, http.StatusForbidden, appErr.StatusCode)
+
+	_, appErr = api.authorizeTeam(requestWithToken("alice-token"), "")
+	assert.Equal(t, http.StatusForbidden, appErr.StatusCode, "only the operator acts for applications without a team")
+
+	_, appErr = api.authorizeTeam(requestWithToken("unknown"), "aura")
+	assert.Equal(t, http.StatusUnauthorized, appErr.StatusCode)
+
+	_, appErr = api.authorizeTeam(requestWithToken(""), "aura")
+	assert.Equal(t, http.StatusUnauthorized, appErr.StatusCode)
+
+	_, appErr = Api{Identities: []Identity{{Name: "empty", Teams: []string{"aura"}}}}.authorizeTeam(requestWithToken(""), "aura")
+	assert.Equal(t, http.StatusUnauthorized, appErr.StatusCode, "an identity without a token never matches")
+}
+
+func TestValidateIdentities(t *testing.T) {
+	alice := Identity{Name: "alice", Teams: []string{"aura"}, Token: "alice-token"}
+
+	assert.NoError(t, validateIdentities([]Identity{alice}, "operator"))
+	assert.Error(t, validateIdentities([]Identity{{Teams: []string{"aura"}, Token: "token"}}, "operator"))
+	assert.Error(t, validateIdentities([]Identity{{Name: "alice", Teams: []string{"aura"}}}, "operator"))
+	assert.Error(t, validateIdentities([]Identity{{Name: "alice", Token: "token"}}, "operator"))
+	assert.Error(t, validateIdentities([]Identity{alice}, "alice-token"))
+	assert.EqualError(t, validateIdentities([]Identity{alice, {Name: "bob", Teams: []string{"aura"}, Token: "alice-token"}}, ""), "identities alice and bob have the same token")
+}
+
+func TestApplicationTeam(t *testing.T) {
+	deployment := &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app", Namespace: "default", Labels: map[string]string{"team": "aura"}}}
+	api := Api{Clientset: fake.NewSimpleClientset(deployment)}
+
+	team, err := api.applicationTeam("default", "app")
+	assert.NoError(t, err)
+	assert.Equal(t, "aura", team)
+
+	team, err = api.applicationTeam("default", "other")
+	assert.NoError(t, err)
+	assert.Empty(t, team)
+}
+
+func requestWithMethod(method, target, token string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if len(token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
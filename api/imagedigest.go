@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DockerHubRegistry     = "registry-1.docker.io"
+	ContentDigestHeader   = "Docker-Content-Digest"
+	manifestAcceptHeaders = "application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.index.v1+json, " +
+		"application/vnd.docker.distribution.manifest.v2+json, application/vnd.oci.image.manifest.v1+json"
+)
+
+var registryClient = &http.Client{Timeout: 30 * time.Second}
+
+// imageReference is an image split into the registry serving it, the repository and the tag
+type imageReference struct {
+	Name       string
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+// Images without a registry host are on Docker Hub, where official images are in the library repository
+func parseImageReference(image string) (imageReference, error) {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	if len(name) == 0 || len(tag) == 0 || strings.Contains(image, "@") {
+		return imageReference{}, fmt.Errorf("%s is not an image with a tag", image)
+	}
+
+	reference := imageReference{Name: name, Registry: DockerHubRegistry, Repository: name, Tag: tag}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		reference.Registry, reference.Repository = parts[0], parts[1]
+		if reference.Registry == "docker.io" {
+			reference.Registry = DockerHubRegistry
+		}
+	}
+	if reference.Registry == DockerHubRegistry && !strings.Contains(reference.Repository, "/") {
+		reference.Repository = "library/" + reference.Repository
+	}
+	return reference, nil
+}
+
+// Pins the image to the digest the registry currently serves for its tag, so that what is scanned can not be replaced
+// by pushing the tag again while the scan runs
+func resolveImageDigest(image string) (string, error) {
+	reference, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	manifestUrl := fmt.Sprintf("https://%s/v2/%s/manifests/%s", reference.Registry, reference.Repository, reference.Tag)
+	resp, err := headManifest(manifestUrl, "")
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := registryToken(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = headManifest(manifestUrl, token); err != nil {
+			return "", err
+		}
+	}
+
+	if resp.StatusCode > 299 {
+		return "", fmt.Errorf("registry returned %d for %s", resp.StatusCode, manifestUrl)
+	}
+
+	digest := resp.Header.Get(ContentDigestHeader)
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("registry returned no digest for %s", image)
+	}
+
+	return reference.Name + "@" + digest, nil
+}
+
+func headManifest(manifestUrl, token string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", manifestUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+	req.Header.Set("Accept", manifestAcceptHeaders)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact registry: %s", err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// Gets an anonymous pull token from the token service named in the registry's challenge,
+// e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+func registryToken(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("registry requires unsupported authentication: %q", challenge)
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if len(params["realm"]) == 0 {
+		return "", fmt.Errorf("registry challenge has no realm: %q", challenge)
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if len(params[key]) > 0 {
+			query.Set(key, params[key])
+		}
+	}
+
+	resp, err := registryClient.Get(params["realm"] + "?" + query.Encode())
+	if err != nil {
+		return "", fmt.Errorf("unable to contact registry token service: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read response from registry token service: %s", err)
+	}
+
+	if resp.StatusCode > 299 {
+		return "", fmt.Errorf("registry token service returned: %s (%d)", body, resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("unable to unmarshal response from registry token service: %s", err)
+	}
+
+	if len(token.Token) > 0 {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestParseImageReference(t *testing.T) {
+	reference, err := parseImageReference("docker.adeo.no:5000/team/app:1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, imageReference{Name: "docker.adeo.no:5000/team/app", Registry: "docker.adeo.no:5000", Repository: "team/app", Tag: "1.0"}, reference)
+
+	reference, err = parseImageReference("nginx")
+	assert.NoError(t, err)
+	assert.Equal(t, imageReference{Name: "nginx", Registry: DockerHubRegistry, Repository: "library/nginx", Tag: "latest"}, reference)
+
+	reference, err = parseImageReference("docker.io/navikt/app:2")
+	assert.NoError(t, err)
+	assert.Equal(t, imageReference{Name: "docker.io/navikt/app", Registry: DockerHubRegistry, Repository: "navikt/app", Tag: "2"}, reference)
+
+	_, err = parseImageReference("app@sha256:abc:1")
+	assert.Error(t, err)
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	t.Run("The digest is read from the manifest of the tag", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://docker.adeo.no:5000").
+			Head("/v2/app/manifests/1.0").
+			MatchHeader("Accept", "application/vnd.docker.distribution.manifest.v2\\+json").
+			Reply(200).
+			SetHeader(ContentDigestHeader, "sha256:4a5b6c")
+
+		digest, err := resolveImageDigest("docker.adeo.no:5000/app:1.0")
+		assert.NoError(t, err)
+		assert.Equal(t, "docker.adeo.no:5000/app@sha256:4a5b6c", digest)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("An anonymous token is fetched when the registry asks for one", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://"+DockerHubRegistry).
+			Head("/v2/library/nginx/manifests/1.15").
+			MatchHeader("Authorization", "Bearer abc").
+			Reply(200).
+			SetHeader(ContentDigestHeader, "sha256:4a5b6c")
+		gock.New("https://"+DockerHubRegistry).
+			Head("/v2/library/nginx/manifests/1.15").
+			Reply(401).
+			SetHeader("WWW-Authenticate", `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`)
+		gock.New("https://auth.docker.io").
+			Get("/token").
+			MatchParam("service", "registry.docker.io").
+			MatchParam("scope", "repository:library/nginx:pull").
+			Reply(200).
+			BodyString(`{"token": "abc"}`)
+
+		digest, err := resolveImageDigest("nginx:1.15")
+		assert.NoError(t, err)
+		assert.Equal(t, "nginx@sha256:4a5b6c", digest)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("A response without a digest is an error", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://docker.adeo.no:5000").
+			Head("/v2/app/manifests/1.0").
+			Reply(200)
+
+		_, err := resolveImageDigest("docker.adeo.no:5000/app:1.0")
+		assert.EqualError(t, err, "registry returned no digest for docker.adeo.no:5000/app:1.0")
+	})
+}
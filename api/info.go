@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	ver "github.com/nais/naisd/api/version"
+)
+
+// ManifestSchemaVersions are the nais.yaml formats this naisd understands
+var ManifestSchemaVersions = []string{"v1"}
+
+type DaemonInfo struct {
+	Version                string            `json:"version"`
+	Revision               string            `json:"revision"`
+	ManifestSchemaVersions []string          `json:"manifestSchemaVersions"`
+	ClusterName            string            `json:"clusterName"`
+	ClusterSubdomain       string            `json:"clusterSubdomain"`
+	Features               map[string]bool   `json:"features"`
+	FeatureFlags           []string          `json:"featureFlags"`
+	Steps                  map[string]string `json:"steps"`
+}
+
+// Features are the optional parts of naisd that are enabled by flags or daemon configuration
+func (api Api) features() map[string]bool {
+	return map[string]bool{
+		"istio":               api.IstioEnabled,
+		"fasitEvents":         api.FasitEventsEnabled,
+		"fasitStopPrevious":   api.FasitStopPrevious,
+		"imageSignatures":     len(api.Provenance.CosignPublicKey) > 0,
+		"vulnerabilityScan":   len(api.Scanner.Url) > 0,
+		"pullRequestComments": len(api.PullRequestProviders) > 0,
+		"networkPolicies":     api.Egress.NetworkPolicies,
+		"firewallRequests":    len(api.Firewall.Url) > 0,
+		"deploymentLog":       len(api.DeploymentLog.Url) > 0,
+		"manifestProfiles":    len(api.ManifestProfiles) > 0,
+		"resourceTemplates":   len(api.ResourceTemplates) > 0,
+		"defaultEnv":          len(api.DefaultEnv) > 0,
+		"zoneFasitEndpoints":  len(api.FasitEndpoints) > 0,
+		"offlineFasit":        api.OfflineFasit != nil,
+		"dnsAllowList":        len(api.DnsAllowList.HostAliases) > 0 || len(api.DnsAllowList.Nameservers) > 0,
+	}
+}
+
+func (api Api) info(w http.ResponseWriter, _ *http.Request) *appError {
+	info := DaemonInfo{
+		Version:                ver.Version,
+		Revision:               ver.Revision,
+		ManifestSchemaVersions: ManifestSchemaVersions,
+		ClusterName:            api.ClusterName,
+		ClusterSubdomain:       api.ClusterSubdomain,
+		Features:               api.features(),
+		FeatureFlags:           api.FeatureFlags.Names(),
+		Steps:                  api.DeploySteps.classifications(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
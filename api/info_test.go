@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ver "github.com/nais/naisd/api/version"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfo(t *testing.T) {
+	ver.Version = "1.0.0"
+	ver.Revision = "abc123"
+	defer func() { ver.Version, ver.Revision = "", "" }()
+
+	api := Api{
+		ClusterName:      "preprod-fss",
+		ClusterSubdomain: "nais.preprod.local",
+		IstioEnabled:     true,
+		Scanner:          ScannerConfig{Url: "https://scanner.local"},
+	}
+
+	req, _ := http.NewRequest("GET", "/internal/info", nil)
+	rr := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+
+	var info DaemonInfo
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, "1.0.0", info.Version)
+	assert.Equal(t, "abc123", info.Revision)
+	assert.Equal(t, ManifestSchemaVersions, info.ManifestSchemaVersions)
+	assert.Equal(t, "preprod-fss", info.ClusterName)
+	assert.True(t, info.Features["istio"])
+	assert.True(t, info.Features["vulnerabilityScan"])
+	assert.False(t, info.Features["networkPolicies"])
+	assert.Equal(t, StepCritical, info.Steps[StepFasitUpdate])
+	assert.Equal(t, StepBestEffort, info.Steps[StepPullRequestComment])
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+)
+
+const (
+	LimitRpsAnnotation             = "nginx.ingress.kubernetes.io/limit-rps"
+	ProxyBodySizeAnnotation        = "nginx.ingress.kubernetes.io/proxy-body-size"
+	WhitelistSourceRangeAnnotation = "nginx.ingress.kubernetes.io/whitelist-source-range"
+	EnableModsecurityAnnotation    = "nginx.ingress.kubernetes.io/enable-modsecurity"
+	EnableOwaspCoreRulesAnnotation = "nginx.ingress.kubernetes.io/enable-owasp-core-rules"
+)
+
+// The annotations naisd owns on an application's ingress. They are removed when no longer set in the manifest, so
+// annotations added by hand are overwritten on the next deploy.
+var managedIngressAnnotations = []string{
+	LimitRpsAnnotation,
+	ProxyBodySizeAnnotation,
+	WhitelistSourceRangeAnnotation,
+	EnableModsecurityAnnotation,
+	EnableOwaspCoreRulesAnnotation,
+	AffinityAnnotation,
+	SessionCookieNameAnnotation,
+	SessionCookieMaxAgeAnnotation,
+	SessionCookieExpiresAnnotation,
+	DefaultBackendAnnotation,
+	CustomHttpErrorsAnnotation,
+}
+
+// nginx sizes are a number of bytes, optionally with a k, m or g suffix
+var ingressBodySizePattern = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+func hasIngressControls(ingress Ingress) bool {
+	return ingress.RateLimit > 0 || len(ingress.MaxBodySize) > 0 || len(ingress.Whitelist) > 0 || ingress.Waf || len(ingress.ErrorBackend.Service) > 0
+}
+
+func validateIngressControls(manifest NaisManifest) *ValidationError {
+	ingress := manifest.Ingress
+
+	if ingress.Disabled && hasIngressControls(ingress) {
+		return &ValidationError{
+			"Ingress.RateLimit, MaxBodySize, Whitelist, Waf and ErrorBackend can not be used when the ingress is disabled",
+			map[string]string{"Ingress.Disabled": "true"},
+		}
+	}
+
+	if ingress.RateLimit < 0 {
+		return &ValidationError{
+			"Ingress.RateLimit must be a positive number of requests per second",
+			map[string]string{"Ingress.RateLimit": strconv.Itoa(ingress.RateLimit)},
+		}
+	}
+
+	if len(ingress.MaxBodySize) > 0 && !ingressBodySizePattern.MatchString(ingress.MaxBodySize) {
+		return &ValidationError{
+			"Ingress.MaxBodySize must be a size in bytes, optionally suffixed with k, m or g",
+			map[string]string{"Ingress.MaxBodySize": ingress.MaxBodySize},
+		}
+	}
+
+	for _, cidr := range ingress.Whitelist {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return &ValidationError{
+				"Ingress.Whitelist must contain CIDRs, e.g. 10.0.0.0/8",
+				map[string]string{"Ingress.Whitelist": cidr},
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sets the ingress controller annotations for the controls and session affinity in the manifest, leaving annotations
+// naisd does not manage
+func applyIngressAnnotations(ingress *k8sextensions.Ingress, manifest NaisManifest) {
+	controls := manifest.Ingress
+	if ingress.Annotations == nil {
+		ingress.Annotations = make(map[string]string)
+	}
+	for _, annotation := range managedIngressAnnotations {
+		delete(ingress.Annotations, annotation)
+	}
+
+	if controls.RateLimit > 0 {
+		ingress.Annotations[LimitRpsAnnotation] = strconv.Itoa(controls.RateLimit)
+	}
+	if len(controls.MaxBodySize) > 0 {
+		ingress.Annotations[ProxyBodySizeAnnotation] = controls.MaxBodySize
+	}
+	if len(controls.Whitelist) > 0 {
+		ingress.Annotations[WhitelistSourceRangeAnnotation] = strings.Join(controls.Whitelist, ",")
+	}
+	if controls.Waf {
+		ingress.Annotations[EnableModsecurityAnnotation] = "true"
+		ingress.Annotations[EnableOwaspCoreRulesAnnotation] = "true"
+	}
+	for annotation, value := range sessionAffinityAnnotations(manifest.SessionAffinity) {
+		ingress.Annotations[annotation] = value
+	}
+	for annotation, value := range errorBackendAnnotations(controls.ErrorBackend) {
+		ingress.Annotations[annotation] = value
+	}
+
+	if len(ingress.Annotations) == 0 {
+		ingress.Annotations = nil
+	}
+}
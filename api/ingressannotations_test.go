@@ -0,0 +1,70 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateIngressControls(t *testing.T) {
+	ingress := func(ingress Ingress) NaisManifest {
+		return NaisManifest{Ingress: ingress}
+	}
+
+	assert.Nil(t, validateIngressControls(NaisManifest{}))
+	assert.Nil(t, validateIngressControls(ingress(Ingress{RateLimit: 10, MaxBodySize: "8m", Whitelist: []string{"10.0.0.0/8", "192.168.1.0/24"}, Waf: true})))
+	assert.Nil(t, validateIngressControls(ingress(Ingress{MaxBodySize: "1048576"})))
+	assert.NotNil(t, validateIngressControls(ingress(Ingress{RateLimit: -1})))
+	assert.NotNil(t, validateIngressControls(ingress(Ingress{MaxBodySize: "8MB"})))
+	assert.NotNil(t, validateIngressControls(ingress(Ingress{Whitelist: []string{"10.0.0.1"}})))
+	assert.NotNil(t, validateIngressControls(ingress(Ingress{Disabled: true, Waf: true})))
+	assert.Nil(t, validateIngressControls(ingress(Ingress{Disabled: true})))
+}
+
+func TestApplyIngressAnnotations(t *testing.T) {
+	t.Run("Controls are mapped to annotations", func(t *testing.T) {
+		ingress := &k8sextensions.Ingress{}
+		applyIngressAnnotations(ingress, NaisManifest{Ingress: Ingress{RateLimit: 20, MaxBodySize: "2m", Whitelist: []string{"10.0.0.0/8", "172.16.0.0/12"}, Waf: true}})
+
+		assert.Equal(t, map[string]string{
+			LimitRpsAnnotation:             "20",
+			ProxyBodySizeAnnotation:        "2m",
+			WhitelistSourceRangeAnnotation: "10.0.0.0/8,172.16.0.0/12",
+			EnableModsecurityAnnotation:    "true",
+			EnableOwaspCoreRulesAnnotation: "true",
+		}, ingress.Annotations)
+	})
+
+	t.Run("Controls removed from the manifest are removed, other annotations are kept", func(t *testing.T) {
+		ingress := &k8sextensions.Ingress{ObjectMeta: k8smeta.ObjectMeta{Annotations: map[string]string{
+			LimitRpsAnnotation:          "20",
+			EnableModsecurityAnnotation: "true",
+			"other":                     "value",
+		}}}
+		applyIngressAnnotations(ingress, NaisManifest{Ingress: Ingress{MaxBodySize: "2m"}})
+
+		assert.Equal(t, map[string]string{ProxyBodySizeAnnotation: "2m", "other": "value"}, ingress.Annotations)
+	})
+
+	t.Run("No controls give no annotations", func(t *testing.T) {
+		ingress := &k8sextensions.Ingress{}
+		applyIngressAnnotations(ingress, NaisManifest{})
+		assert.Nil(t, ingress.Annotations)
+	})
+}
+
+func TestCreateOrUpdateIngressWithControls(t *testing.T) {
+	existing := createIngressDef(appName, namespace, teamName)
+	existing.ResourceVersion = "1"
+	existing.Annotations = map[string]string{LimitRpsAnnotation: "5"}
+	clientset := fake.NewSimpleClientset(existing)
+
+	ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: appName}, NaisManifest{Team: teamName, Ingress: Ingress{Waf: true}}, "example.no", []NaisResource{}, clientset)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{EnableModsecurityAnnotation: "true", EnableOwaspCoreRulesAnnotation: "true"}, ingress.Annotations)
+}
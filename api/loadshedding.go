@@ -0,0 +1,210 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Seconds clients are asked to wait before retrying a rejected deployment
+const loadSheddingRetryAfter = 30
+
+// LoadShedder rejects deployments while the Kubernetes API is degraded, too many deployments are running, or an
+// operator has paused deployments. Zero limits are not enforced.
+type LoadShedder struct {
+	MaxLatency               time.Duration
+	MaxErrors                int
+	MaxConcurrentDeployments int64
+
+	mutex   sync.RWMutex
+	latency time.Duration
+	errors  int
+	pauses  map[string]string
+}
+
+// pauseRequest pauses or resumes deployments to Namespace, or to the whole cluster when it is empty
+type pauseRequest struct {
+	Namespace string `json:"namespace"`
+	Reason    string `json:"reason"`
+}
+
+func NewLoadShedder() *LoadShedder {
+	return &LoadShedder{pauses: map[string]string{}}
+}
+
+// observe records the outcome of a Kubernetes API health probe
+func (l *LoadShedder) observe(latency time.Duration, err error) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.latency = latency
+	if err != nil {
+		l.errors++
+	} else {
+		l.errors = 0
+	}
+}
+
+// Pause stops deployments to the namespace, or to every namespace when it is empty
+func (l *LoadShedder) Pause(namespace, reason string) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.pauses == nil {
+		l.pauses = map[string]string{}
+	}
+	l.pauses[namespace] = reason
+}
+
+func (l *LoadShedder) Resume(namespace string) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.pauses, namespace)
+}
+
+// Pauses returns the reason of every pause, keyed by namespace. A cluster-wide pause has an empty namespace.
+func (l *LoadShedder) Pauses() map[string]string {
+	pauses := map[string]string{}
+	if l == nil {
+		return pauses
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for namespace, reason := range l.pauses {
+		pauses[namespace] = reason
+	}
+	return pauses
+}
+
+// ReplacePauses lifts every pause, and pauses the namespaces in pauses instead
+func (l *LoadShedder) ReplacePauses(pauses map[string]string) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.pauses = map[string]string{}
+	for namespace, reason := range pauses {
+		l.pauses[namespace] = reason
+	}
+}
+
+func pauseError(scope, reason string) error {
+	if len(reason) == 0 {
+		return fmt.Errorf("deployments %s are paused by an operator", scope)
+	}
+	return fmt.Errorf("deployments %s are paused by an operator: %s", scope, reason)
+}
+
+// admit returns an error telling why a new deployment to the namespace can not start now
+func (l *LoadShedder) admit(namespace string, deploymentsInProgress int64) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if reason, ok := l.pauses[""]; ok {
+		return pauseError("to this cluster", reason)
+	}
+	if reason, ok := l.pauses[namespace]; ok {
+		return pauseError("to namespace "+namespace, reason)
+	}
+
+	switch {
+	case l.MaxErrors > 0 && l.errors >= l.MaxErrors:
+		return fmt.Errorf("the Kubernetes API has failed the last %d health checks", l.errors)
+	case l.MaxLatency > 0 && l.latency > l.MaxLatency:
+		return fmt.Errorf("the Kubernetes API is slow, responding in %s", l.latency)
+	case l.MaxConcurrentDeployments > 0 && deploymentsInProgress >= l.MaxConcurrentDeployments:
+		return fmt.Errorf("%d deployments are already in progress", deploymentsInProgress)
+	}
+	return nil
+}
+
+// MonitorKubernetesPeriodically measures how long the Kubernetes API takes to respond, for the load shedder
+func (api Api) MonitorKubernetesPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		start := time.Now()
+		_, err := api.Clientset.Discovery().ServerVersion()
+		api.LoadShedder.observe(time.Since(start), err)
+		if err != nil {
+			glog.Warningf("Kubernetes API health check failed: %s", err)
+		}
+	}
+}
+
+func (api Api) pauseDeployments(w http.ResponseWriter, r *http.Request) *appError {
+	var request pauseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return &appError{err, "unable to unmarshal pause request", http.StatusBadRequest}
+		}
+	}
+
+	api.LoadShedder.Pause(request.Namespace, request.Reason)
+	api.AuditLog.Record(AuditEntry{Event: "deployments_paused", Namespace: request.Namespace, Details: map[string]string{"reason": request.Reason}})
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (api Api) resumeDeployments(w http.ResponseWriter, r *http.Request) *appError {
+	var request pauseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return &appError{err, "unable to unmarshal resume request", http.StatusBadRequest}
+		}
+	}
+
+	api.LoadShedder.Resume(request.Namespace)
+	api.AuditLog.Record(AuditEntry{Event: "deployments_resumed", Namespace: request.Namespace})
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (api Api) listPauses(w http.ResponseWriter, _ *http.Request) *appError {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.LoadShedder.Pauses()); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+// requireOperator only lets requests with the configured operator token through. Without a token the endpoint is disabled.
+func (api Api) requireOperator(handler appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		if len(api.OperatorToken) == 0 {
+			return &appError{fmt.Errorf("no operator token configured"), "operator endpoints are disabled", http.StatusForbidden}
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+api.OperatorToken)) != 1 {
+			return &appError{fmt.Errorf("missing or wrong bearer token"), "not authorized", http.StatusUnauthorized}
+		}
+		return handler(w, r)
+	}
+}
+
+// isOperator is true if the request carries the configured operator token
+func (api Api) isOperator(r *http.Request) bool {
+	return len(api.OperatorToken) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+api.OperatorToken)) == 1
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShedderAdmit(t *testing.T) {
+	t.Run("Deployments are admitted without limits", func(t *testing.T) {
+		shedder := NewLoadShedder()
+		shedder.observe(time.Minute, errors.New("timeout"))
+		assert.NoError(t, shedder.admit("default", 100))
+
+		var none *LoadShedder
+		assert.NoError(t, none.admit("default", 100))
+	})
+
+	t.Run("Slow Kubernetes API rejects deployments", func(t *testing.T) {
+		shedder := &LoadShedder{MaxLatency: time.Second}
+		shedder.observe(2*time.Second, nil)
+		assert.Error(t, shedder.admit("default", 0))
+
+		shedder.observe(100*time.Millisecond, nil)
+		assert.NoError(t, shedder.admit("default", 0))
+	})
+
+	t.Run("Failing Kubernetes API rejects deployments after max errors in a row", func(t *testing.T) {
+		shedder := &LoadShedder{MaxErrors: 2}
+		shedder.observe(0, errors.New("refused"))
+		assert.NoError(t, shedder.admit("default", 0))
+		shedder.observe(0, errors.New("refused"))
+		assert.EqualError(t, shedder.admit("default", 0), "the Kubernetes API has failed the last 2 health checks")
+		shedder.observe(0, nil)
+		assert.NoError(t, shedder.admit("default", 0))
+	})
+
+	t.Run("Too many concurrent deployments are rejected", func(t *testing.T) {
+		shedder := &LoadShedder{MaxConcurrentDeployments: 2}
+		assert.NoError(t, shedder.admit("default", 1))
+		assert.Error(t, shedder.admit("default", 2))
+	})
+
+	t.Run("Cluster-wide pause rejects deployments to every namespace", func(t *testing.T) {
+		shedder := NewLoadShedder()
+		shedder.Pause("", "cluster upgrade")
+		assert.EqualError(t, shedder.admit("default", 0), "deployments to this cluster are paused by an operator: cluster upgrade")
+		shedder.Resume("")
+		assert.NoError(t, shedder.admit("default", 0))
+	})
+
+	t.Run("Namespace pause only rejects deployments to that namespace", func(t *testing.T) {
+		shedder := NewLoadShedder()
+		shedder.Pause("t1", "")
+		assert.EqualError(t, shedder.admit("t1", 0), "deployments to namespace t1 are paused by an operator")
+		assert.NoError(t, shedder.admit("default", 0))
+		assert.Equal(t, map[string]string{"t1": ""}, shedder.Pauses())
+	})
+}
+
+func TestPauseDeployments(t *testing.T) {
+	api := Api{LoadShedder: NewLoadShedder(), AuditLog: NewAuditLog(), OperatorToken: "secret"}
+
+	operatorRequest := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Operator endpoints require token", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/internal/pause", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = httptest.NewRecorder()
+		Api{LoadShedder: NewLoadShedder()}.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Empty(t, api.LoadShedder.Pauses())
+	})
+
+	t.Run("Paused namespace rejects deployments with reason", func(t *testing.T) {
+		rr := operatorRequest("POST", "/internal/pause", `{"namespace": "t1", "reason": "database upgrade"}`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = operatorRequest("GET", "/internal/pause", "")
+		assert.JSONEq(t, `{"t1": "database upgrade"}`, rr.Body.String())
+
+		req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(`{"namespace": "t1"}`))
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+		assert.Contains(t, rr.Body.String(), "database upgrade")
+	})
+
+	t.Run("Resumed namespace accepts deployments", func(t *testing.T) {
+		rr := operatorRequest("POST", "/internal/resume", `{"namespace": "t1"}`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, api.LoadShedder.Pauses())
+		assert.Equal(t, "deployments_resumed", api.AuditLog.Entries()[1].Event)
+	})
+}
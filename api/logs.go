@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goji.io/pat"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultLogTailLines = 100
+	maxLogTailLines     = 10000
+)
+
+// applicationLogs returns the last lines the application's container has logged in each of its pods, every line
+// prefixed with the name of the pod. Logs may hold anything the application prints, so only its team and the operator
+// can read them.
+func (api Api) applicationLogs(w http.ResponseWriter, r *http.Request) *appError {
+	namespace := pat.Param(r, "namespace")
+	deployName := pat.Param(r, "deployName")
+
+	tailLines := int64(defaultLogTailLines)
+	if value := r.URL.Query().Get("tailLines"); len(value) > 0 {
+		lines, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || lines < 1 || lines > maxLogTailLines {
+			return &appError{fmt.Errorf("tailLines must be a number from 1 to %d, got %s", maxLogTailLines, value), "invalid tailLines", http.StatusBadRequest}
+		}
+		tailLines = lines
+	}
+
+	deployment, err := getExistingDeployment(deployName, namespace, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to get deployment", http.StatusInternalServerError}
+	}
+	if deployment == nil {
+		return &appError{fmt.Errorf("%s/%s does not exist", namespace, deployName), "application not found", http.StatusNotFound}
+	}
+	if _, appErr := api.authorizeTeam(r, deployment.Labels["team"]); appErr != nil {
+		return appErr
+	}
+
+	pods, err := api.Clientset.CoreV1().Pods(namespace).List(k8smeta.ListOptions{LabelSelector: "app=" + deployName})
+	if err != nil {
+		return &appError{err, "unable to list pods", http.StatusInternalServerError}
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	var logs bytes.Buffer
+	for _, pod := range pods.Items {
+		podLogs, err := api.Clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &k8score.PodLogOptions{Container: deployName, TailLines: &tailLines}).DoRaw()
+		if err != nil {
+			return &appError{err, "unable to get logs of pod " + pod.Name, http.StatusInternalServerError}
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(podLogs), "\n"), "\n") {
+			if len(line) > 0 {
+				fmt.Fprintf(&logs, "%s %s\n", pod.Name, line)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(logs.Bytes())
+	return nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// logsApiServer is a cluster with the application and two of its pods. The fake clientset can not return logs, so the
+// Kubernetes API is served for real.
+func logsApiServer(t *testing.T) *httptest.Server {
+	deployment := k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace, Labels: map[string]string{"team": teamName}}}
+	pods := k8score.PodList{Items: []k8score.Pod{
+		{ObjectMeta: k8smeta.ObjectMeta{Name: appName + "-2", Namespace: namespace}},
+		{ObjectMeta: k8smeta.ObjectMeta{Name: appName + "-1", Namespace: namespace}},
+	}}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/extensions/v1beta1/namespaces/" + namespace + "/deployments/" + appName:
+			json.NewEncoder(w).Encode(deployment)
+		case "/api/v1/namespaces/" + namespace + "/pods":
+			assert.Equal(t, "app="+appName, r.URL.Query().Get("labelSelector"))
+			json.NewEncoder(w).Encode(pods)
+		case "/api/v1/namespaces/" + namespace + "/pods/" + appName + "-1/log":
+			assert.Equal(t, appName, r.URL.Query().Get("container"))
+			assert.Equal(t, "2", r.URL.Query().Get("tailLines"))
+			w.Write([]byte("started\nready\n"))
+		case "/api/v1/namespaces/" + namespace + "/pods/" + appName + "-2/log":
+			w.Write([]byte("started\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+		}
+	}))
+}
+
+func TestApplicationLogs(t *testing.T) {
+	server := logsApiServer(t)
+	defer server.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+	api := Api{
+		Clientset:     clientset,
+		OperatorToken: "secret",
+		Identities:    []Identity{{Name: "bob", Teams: []string{"other"}, Token: "bob"}},
+	}
+
+	logs := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Logs of every pod are returned, prefixed with the pod", func(t *testing.T) {
+		rr := logs("/logs/"+namespace+"/"+appName+"?tailLines=2", "secret")
+		body, _ := ioutil.ReadAll(rr.Body)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, appName+"-1 started\n"+appName+"-1 ready\n"+appName+"-2 started\n", string(body))
+	})
+
+	t.Run("Only the team of the application and the operator can read them", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, logs("/logs/"+namespace+"/"+appName, "").Code)
+		assert.Equal(t, http.StatusForbidden, logs("/logs/"+namespace+"/"+appName, "bob").Code)
+	})
+
+	t.Run("Unknown applications and invalid tailLines are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, logs("/logs/"+namespace+"/missing", "secret").Code)
+		rr := logs("/logs/"+namespace+"/"+appName+"?tailLines=0", "secret")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.True(t, strings.Contains(rr.Body.String(), "invalid tailLines"))
+	})
+}
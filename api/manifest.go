@@ -17,15 +17,18 @@ import (
 
 type Probe struct {
 	Path             string
-	InitialDelay     int `yaml:"initialDelay"`
-	PeriodSeconds    int `yaml:"periodSeconds"`
-	FailureThreshold int `yaml:"failureThreshold"`
-	Timeout          int `yaml:"timeout"`
+	TcpSocket        bool     `yaml:"tcpSocket"`
+	Exec             []string `yaml:"exec"`
+	InitialDelay     int      `yaml:"initialDelay"`
+	PeriodSeconds    int      `yaml:"periodSeconds"`
+	FailureThreshold int      `yaml:"failureThreshold"`
+	Timeout          int      `yaml:"timeout"`
 }
 
 type Healthcheck struct {
 	Liveness  Probe
 	Readiness Probe
+	Selftest  string
 }
 
 type ResourceList struct {
@@ -49,33 +52,61 @@ type IstioConfig struct {
 }
 
 type NaisManifest struct {
-	Team            string
-	Image           string
-	Port            int
-	Healthcheck     Healthcheck
-	PreStopHookPath string `yaml:"preStopHookPath"`
-	Prometheus      PrometheusConfig
-	Istio           IstioConfig
-	Replicas        Replicas
-	Ingress         Ingress
-	Resources       ResourceRequirements
-	FasitResources  FasitResources `yaml:"fasitResources"`
-	LeaderElection  bool           `yaml:"leaderElection"`
-	Redis           bool           `yaml:"redis"`
-	WebProxy        bool
-	Alerts          []PrometheusAlertRule
-	Logformat       string
-	Logtransform    string
+	Kind              string
+	Team              string
+	Image             string
+	Port              int
+	Healthcheck       Healthcheck
+	PreStopHookPath   string        `yaml:"preStopHookPath"`
+	PostStartHook     PostStartHook `yaml:"postStartHook"`
+	Prometheus        PrometheusConfig
+	Istio             IstioConfig
+	Replicas          Replicas
+	Strategy          string
+	Ingress           Ingress
+	SessionAffinity   SessionAffinity `yaml:"sessionAffinity"`
+	Resources         ResourceRequirements
+	FasitResources    FasitResources `yaml:"fasitResources"`
+	LeaderElection    bool           `yaml:"leaderElection"`
+	Redis             bool           `yaml:"redis"`
+	WebProxy          bool
+	Alerts            []PrometheusAlertRule
+	Logformat         string
+	Logtransform      string
+	DownwardApi       DownwardApiConfig `yaml:"downwardApi"`
+	DnsPolicy         string            `yaml:"dnsPolicy"`
+	DnsConfig         DnsConfig         `yaml:"dnsConfig"`
+	HostAliases       []HostAlias       `yaml:"hostAliases"`
+	EmptyDirs         []EmptyDir        `yaml:"emptyDirs"`
+	Runtime           Runtime
+	Extends           string
+	ExternalServices  []ExternalService `yaml:"externalServices"`
+	MaxDeployDuration string            `yaml:"maxDeployDuration"`
+	Strict            *bool             `yaml:"strict"`
+	Hostname          string
+	DependsOn         []string `yaml:"dependsOn"`
+	Hooks             Hooks
+	Verification      Verification
+	Env               map[string]string
+	DefaultEnv        map[string]string `yaml:"-"`
+	Checksum          string            `yaml:"-"`
 }
 
 type Ingress struct {
-	Disabled bool
+	Disabled            bool
+	PauseDuringRecreate bool   `yaml:"pauseDuringRecreate"`
+	RateLimit           int    `yaml:"rateLimit"`
+	MaxBodySize         string `yaml:"maxBodySize"`
+	Whitelist           []string
+	Waf                 bool
+	ErrorBackend        ErrorBackend `yaml:"errorBackend"`
 }
 
 type Replicas struct {
 	Min                    int
 	Max                    int
-	CpuThresholdPercentage int `yaml:"cpuThresholdPercentage"`
+	CpuThresholdPercentage int  `yaml:"cpuThresholdPercentage"`
+	DisableSurge           bool `yaml:"disableSurge"`
 }
 
 type FasitResources struct {
@@ -84,21 +115,42 @@ type FasitResources struct {
 }
 
 type UsedResource struct {
-	Alias        string
-	ResourceType string            `yaml:"resourceType"`
-	PropertyMap  map[string]string `yaml:"propertyMap"`
+	Alias         string
+	AliasPrefix   string            `yaml:"aliasPrefix"`
+	ResourceType  string            `yaml:"resourceType"`
+	PropertyMap   map[string]string `yaml:"propertyMap"`
+	PropertyTypes map[string]string `yaml:"propertyTypes"`
+	Check         string
+	Optional      bool
 }
 
 type ExposedResource struct {
 	Alias          string
 	ResourceType   string `yaml:"resourceType"`
 	Path           string
+	Paths          []string `yaml:"paths"`
 	Description    string
 	WsdlGroupId    string `yaml:"wsdlGroupId"`
 	WsdlArtifactId string `yaml:"wsdlArtifactId"`
 	WsdlVersion    string `yaml:"wsdlVersion"`
 	SecurityToken  string `yaml:"securityToken"`
 	AllZones       bool   `yaml:"allZones"`
+	Template       string `yaml:"template"`
+	QueueName      string `yaml:"queueName"`
+	TopicString    string `yaml:"topicString"`
+	ChannelName    string `yaml:"channelName"`
+	QueueManager   string `yaml:"queueManager"`
+	Hostname       string `yaml:"hostname"`
+	Port           int    `yaml:"port"`
+	Url            string `yaml:"url"`
+	Username       string `yaml:"username"`
+	PasswordSecret string `yaml:"passwordSecret"`
+	PasswordKey    string `yaml:"passwordKey"`
+	Gateway        string `yaml:"gateway"`
+	Endpoint       string `yaml:"endpoint"`
+	Properties     map[string]string
+	password       string
+	passwordRef    string
 }
 
 type ValidationErrors struct {
@@ -116,29 +168,49 @@ type Field struct {
 }
 
 func GenerateManifest(deploymentRequest naisrequest.Deploy) (naisManifest NaisManifest, err error) {
+	return GenerateManifestWithProfiles(deploymentRequest, nil, nil)
+}
+
+// GenerateManifestWithProfiles resolves extends against the named manifest profiles, and exposed resources against
+// the named resource templates, before applying defaults
+func GenerateManifestWithProfiles(deploymentRequest naisrequest.Deploy, profiles map[string]string, templates map[string]ExposedResource) (naisManifest NaisManifest, err error) {
+	manifest, _, err := generateManifest(deploymentRequest, profiles, templates)
+	return manifest, err
+}
 
-	manifest, err := downloadManifest(deploymentRequest)
+// generateManifest is GenerateManifestWithProfiles, also returning the manifest as the team declared it, before
+// defaults were applied
+func generateManifest(deploymentRequest naisrequest.Deploy, profiles map[string]string, templates map[string]ExposedResource) (naisManifest NaisManifest, declared NaisManifest, err error) {
+
+	manifest, err := downloadManifest(deploymentRequest, profiles)
 
 	if err != nil {
 		glog.Errorf("could not download manifest", err)
-		return NaisManifest{}, err
+		return NaisManifest{}, NaisManifest{}, err
 	}
 
+	if err := applyResourceTemplates(&manifest, templates); err != nil {
+		return NaisManifest{}, NaisManifest{}, err
+	}
+	declared = manifest
+
 	if err := AddDefaultManifestValues(&manifest, deploymentRequest.Application); err != nil {
 		glog.Errorf("Could not merge manifest %s", err)
-		return NaisManifest{}, err
+		return NaisManifest{}, NaisManifest{}, err
 	}
 
 	validationErrors := ValidateManifest(manifest)
 	if len(validationErrors.Errors) != 0 {
 		glog.Error("Invalid manifest: ", validationErrors.Error())
-		return NaisManifest{}, validationErrors
+		return NaisManifest{}, NaisManifest{}, validationErrors
 	}
 
-	return manifest, nil
+	expandExposedPaths(&manifest)
+
+	return manifest, declared, nil
 }
 
-func downloadManifest(deploymentRequest naisrequest.Deploy) (naisManifest NaisManifest, err error) {
+func downloadManifest(deploymentRequest naisrequest.Deploy, profiles map[string]string) (naisManifest NaisManifest, err error) {
 	var urls []string
 	var errors error
 
@@ -150,7 +222,7 @@ func downloadManifest(deploymentRequest naisrequest.Deploy) (naisManifest NaisMa
 	}
 
 	for _, url := range urls {
-		if manifest, err := fetchManifest(url); err != nil {
+		if manifest, err := fetchManifest(url, profiles); err != nil {
 			errors = multierror.Append(errors, err)
 		} else {
 			return manifest, nil
@@ -172,32 +244,69 @@ func createManifestUrl(application, version string) []string {
 func AddDefaultManifestValues(manifest *NaisManifest, application string) error {
 	return mergo.Merge(manifest, GetDefaultManifest(application))
 }
-func fetchManifest(url string) (NaisManifest, error) {
+func fetchManifest(url string, profiles map[string]string) (NaisManifest, error) {
+	document, body, err := resolveManifest(url, profiles, nil)
+	if err != nil {
+		return NaisManifest{}, err
+	}
+
+	merged, err := yaml.Marshal(document)
+	if err != nil {
+		return NaisManifest{}, fmt.Errorf("unable to marshal merged manifest from URL: %s", url)
+	}
+
+	manifest, err := unmarshalManifest(merged)
+	if err != nil {
+		glog.Errorf("Could not unmarshal yaml %s from URL: %s", err, url)
+		return NaisManifest{}, fmt.Errorf("unable to unmarshal %s from URL: %s", err.Error(), url)
+	}
+	manifest.Checksum = manifestChecksum(body)
+	glog.Infof("Got manifest %s", manifest)
+	return manifest, nil
+}
+
+// Fetches the manifest, using a conditional GET when the manifest has been fetched before so that
+// repeated deploys of the same artifact version are served from the cache
+func fetchManifestBody(url string) ([]byte, error) {
 	glog.Infof("Fetching manifest from URL %s\n", url)
-	response, err := http.Get(url)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request for url: %s. %s", url, err.Error())
+	}
+
+	cached, isCached := manifests.get(url)
+	if isCached {
+		request.Header.Set("If-None-Match", cached.etag)
+	}
+
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		glog.Errorf("Could not fetch %s", err)
-		return NaisManifest{}, fmt.Errorf("HTTP GET failed for url: %s. %s", url, err.Error())
+		return nil, fmt.Errorf("HTTP GET failed for url: %s. %s", url, err.Error())
 	}
 
 	defer response.Body.Close()
 
+	if isCached && response.StatusCode == http.StatusNotModified {
+		glog.Infof("Manifest from URL %s not modified, using cached manifest with checksum %s", url, manifestChecksum(cached.body))
+		return cached.body, nil
+	}
+
 	if response.StatusCode > 299 {
 		glog.Errorf("got HTTP status code %d fetching manifest from URL: %s", response.StatusCode, url)
-		return NaisManifest{}, fmt.Errorf("got HTTP status code %d fetching manifest from URL: %s", response.StatusCode, url)
+		return nil, fmt.Errorf("got HTTP status code %d fetching manifest from URL: %s", response.StatusCode, url)
 	}
 
-	if body, err := ioutil.ReadAll(response.Body); err != nil {
-		return NaisManifest{}, err
-	} else {
-		var manifest NaisManifest
-		if err := yaml.Unmarshal(body, &manifest); err != nil {
-			glog.Errorf("Could not unmarshal yaml %s from URL: %s", err, url)
-			return NaisManifest{}, fmt.Errorf("unable to unmarshal %s from URL: %s", err.Error(), url)
-		}
-		glog.Infof("Got manifest %s", manifest)
-		return manifest, err
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if etag := response.Header.Get("ETag"); len(etag) > 0 {
+		manifests.put(url, cachedManifest{etag: etag, body: body})
 	}
+
+	return body, nil
 }
 
 func ValidateManifest(manifest NaisManifest) ValidationErrors {
@@ -213,6 +322,34 @@ func ValidateManifest(manifest NaisManifest) ValidationErrors {
 		validateLimitsCpuQuantity,
 		validateResources,
 		validateAlertRules,
+		validateDownwardApi,
+		validateDisableSurge,
+		validateStrategy,
+		validateDns,
+		validateExternalServices,
+		validateDependencyChecks,
+		validateMaxDeployDuration,
+		validatePropertyTypes,
+		validateKind,
+		validateDependsOn,
+		validateEnv,
+		validateExposedPaths,
+		validateHooks,
+		validateVerification,
+		validateAliasPrefixes,
+		validateExposedResourceProperties,
+		validateExposedResourcePropertyMaps,
+		validateWebserviceGateways,
+		validateSecurityTokens,
+		validateMqResources,
+		validateDataSources,
+		validatePostStartHook,
+		validateEmptyDirs,
+		validateRuntime,
+		validateProbes,
+		validateIngressControls,
+		validateSessionAffinity,
+		validateErrorBackend,
 	}
 
 	var validationErrors ValidationErrors
@@ -227,15 +364,24 @@ func ValidateManifest(manifest NaisManifest) ValidationErrors {
 
 func validateResources(manifest NaisManifest) *ValidationError {
 	for _, resource := range manifest.FasitResources.Exposed {
-		if resource.ResourceType == "" || resource.Alias == "" {
+		// the resource type of a resource using a template is not known until the template is applied
+		if (resource.ResourceType == "" && resource.Template == "") || resource.Alias == "" {
 			return &ValidationError{
 				"Alias and ResourceType must be specified",
 				map[string]string{"Alias": resource.Alias},
 			}
 		}
+		if resource.ResourceType != "" && !strings.EqualFold("restservice", resource.ResourceType) &&
+			!strings.EqualFold("WebserviceEndpoint", resource.ResourceType) && !isWebserviceGateway(resource.ResourceType) &&
+			!isMqResourceType(resource.ResourceType) && !isDataSource(resource.ResourceType) && !isCredential(resource.ResourceType) {
+			return &ValidationError{
+				"ResourceType of an exposed resource must be RestService, WebserviceEndpoint, WebserviceGateway, DataSource, Credential, Queue, Topic, Channel or QueueManager",
+				map[string]string{"Alias": resource.Alias, "ResourceType": resource.ResourceType},
+			}
+		}
 	}
 	for _, resource := range manifest.FasitResources.Used {
-		if resource.ResourceType == "" || resource.Alias == "" {
+		if resource.ResourceType == "" || (resource.Alias == "" && resource.AliasPrefix == "") {
 			return &ValidationError{
 				"Alias and ResourceType must be specified",
 				map[string]string{"Alias": resource.Alias},
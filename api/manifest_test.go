@@ -171,7 +171,7 @@ func TestDownLoadManifestErrors(t *testing.T) {
 		gock.New(urls[0]).
 			Reply(404)
 
-		_, err := downloadManifest(naisrequest.Deploy{ManifestUrl: urls[0]})
+		_, err := downloadManifest(naisrequest.Deploy{ManifestUrl: urls[0]}, nil)
 		assert.Error(t, err)
 		merr, _ := err.(*multierror.Error)
 		assert.Equal(t, 1, len(merr.Errors))
@@ -187,7 +187,7 @@ func TestDownLoadManifestErrors(t *testing.T) {
 		gock.New(urls[2]).
 			Reply(200).
 			File("testdata/nais_yaml_error.yaml")
-		_, err := downloadManifest(request)
+		_, err := downloadManifest(request, nil)
 
 		assert.Error(t, err)
 		merr, _ := err.(*multierror.Error)
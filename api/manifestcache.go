@@ -0,0 +1,51 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+const maxCachedManifests = 1000
+
+var manifests = newManifestCache()
+
+type cachedManifest struct {
+	etag string
+	body []byte
+}
+
+type manifestCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedManifest
+}
+
+func newManifestCache() *manifestCache {
+	return &manifestCache{entries: make(map[string]cachedManifest)}
+}
+
+func (c *manifestCache) get(url string) (cachedManifest, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[url]
+	return entry, ok
+}
+
+func (c *manifestCache) put(url string, entry cachedManifest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[url]; !exists && len(c.entries) >= maxCachedManifests {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+
+	c.entries[url] = entry
+}
+
+func manifestChecksum(body []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestManifestCache(t *testing.T) {
+	const repopath = "https://manifest.repo/cached"
+	manifestBody := "image: navikt/app\nteam: teamName\n"
+
+	t.Run("Manifest is cached by ETag and served on 304", func(t *testing.T) {
+		manifests = newManifestCache()
+		defer gock.Off()
+
+		gock.New(repopath).
+			Reply(200).
+			SetHeader("ETag", `"v1"`).
+			BodyString(manifestBody)
+		gock.New(repopath).
+			MatchHeader("If-None-Match", `"v1"`).
+			Reply(304)
+
+		first, err := GenerateManifest(naisrequest.Deploy{ManifestUrl: repopath})
+		assert.NoError(t, err)
+		second, err := GenerateManifest(naisrequest.Deploy{ManifestUrl: repopath})
+		assert.NoError(t, err)
+
+		assert.True(t, gock.IsDone())
+		assert.Equal(t, "teamName", second.Team)
+		assert.Equal(t, first.Checksum, second.Checksum)
+		assert.Equal(t, manifestChecksum([]byte(manifestBody)), second.Checksum)
+	})
+
+	t.Run("Manifests without ETag are not cached", func(t *testing.T) {
+		manifests = newManifestCache()
+		defer gock.Off()
+
+		gock.New(repopath).
+			Reply(200).
+			BodyString(manifestBody)
+
+		_, err := GenerateManifest(naisrequest.Deploy{ManifestUrl: repopath})
+		assert.NoError(t, err)
+
+		_, cached := manifests.get(repopath)
+		assert.False(t, cached)
+	})
+
+	t.Run("Cache is bounded", func(t *testing.T) {
+		cache := newManifestCache()
+		for i := 0; i < maxCachedManifests+10; i++ {
+			cache.put(string(rune(i)), cachedManifest{etag: "etag"})
+		}
+		assert.Len(t, cache.entries, maxCachedManifests)
+	})
+}
+
+func TestManifestChecksum(t *testing.T) {
+	assert.Equal(t, "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", manifestChecksum([]byte{}))
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Resolves the base of extends, which is either a named profile or a URL relative to the extending manifest
+func resolveManifestBase(extends, manifestUrl string, profiles map[string]string) (string, error) {
+	if profileUrl, ok := profiles[extends]; ok {
+		return profileUrl, nil
+	}
+
+	base, err := url.Parse(manifestUrl)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse manifest url %s: %s", manifestUrl, err)
+	}
+
+	reference, err := url.Parse(extends)
+	if err != nil {
+		return "", fmt.Errorf("extends %s is neither a manifest profile nor a URL: %s", extends, err)
+	}
+
+	resolved := base.ResolveReference(reference)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return "", fmt.Errorf("extends %s is neither a manifest profile nor a URL", extends)
+	}
+
+	return resolved.String(), nil
+}
+
+// Maps are merged key by key, anything else in the overriding manifest replaces the value from the base
+func deepMergeManifest(base, override map[interface{}]interface{}) map[interface{}]interface{} {
+	merged := make(map[interface{}]interface{}, len(base))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		baseMap, baseIsMap := merged[key].(map[interface{}]interface{})
+		overrideMap, overrideIsMap := value.(map[interface{}]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = deepMergeManifest(baseMap, overrideMap)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+// Fetches the manifest and everything it extends, returning the merged manifest and the body of the manifest itself
+func resolveManifest(manifestUrl string, profiles map[string]string, extendedBy []string) (map[interface{}]interface{}, []byte, error) {
+	for _, u := range extendedBy {
+		if u == manifestUrl {
+			return nil, nil, fmt.Errorf("manifest extends itself: %s -> %s", strings.Join(extendedBy, " -> "), manifestUrl)
+		}
+	}
+
+	body, err := fetchManifestBody(manifestUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	document, err := decodeManifestDocument(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to unmarshal %s from URL: %s", err.Error(), manifestUrl)
+	}
+
+	extends, ok := document["extends"].(string)
+	if !ok || len(extends) == 0 {
+		return document, body, nil
+	}
+
+	baseUrl, err := resolveManifestBase(extends, manifestUrl, profiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base, _, err := resolveManifest(baseUrl, profiles, append(extendedBy, manifestUrl))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to resolve manifest extended by %s: %s", manifestUrl, err)
+	}
+	delete(base, "extends")
+
+	return deepMergeManifest(base, document), body, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestManifestExtends(t *testing.T) {
+	repo := "https://repo.local"
+	baseProfile := `
+team: platform
+replicas:
+  min: 3
+  max: 6
+healthcheck:
+  liveness:
+    path: /internal/isAlive
+  readiness:
+    path: /internal/isReady
+resources:
+  limits:
+    memory: 1Gi
+`
+
+	t.Run("Manifest is deep merged onto named profile", func(t *testing.T) {
+		defer gock.Off()
+		gock.New(repo).
+			Get("/app/nais.yaml").
+			Reply(200).
+			BodyString("extends: hardened\nimage: navikt/app\nteam: teamName\nreplicas:\n  max: 10\nhealthcheck:\n  liveness:\n    path: /alive\n")
+		gock.New(repo).
+			Get("/profiles/hardened.yaml").
+			Reply(200).
+			BodyString(baseProfile)
+
+		manifest, err := GenerateManifestWithProfiles(naisrequest.Deploy{ManifestUrl: repo + "/app/nais.yaml"}, map[string]string{"hardened": repo + "/profiles/hardened.yaml"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "teamName", manifest.Team)
+		assert.Equal(t, 3, manifest.Replicas.Min)
+		assert.Equal(t, 10, manifest.Replicas.Max)
+		assert.Equal(t, "/alive", manifest.Healthcheck.Liveness.Path)
+		assert.Equal(t, "/internal/isReady", manifest.Healthcheck.Readiness.Path)
+		assert.Equal(t, "1Gi", manifest.Resources.Limits.Memory)
+		assert.Equal(t, "200m", manifest.Resources.Requests.Cpu)
+		assert.Equal(t, "hardened", manifest.Extends)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Extends can be a URL relative to the manifest", func(t *testing.T) {
+		defer gock.Off()
+		gock.New(repo).
+			Get("/app/nais.yaml").
+			Reply(200).
+			BodyString("extends: ../profiles/base.yaml\nimage: navikt/app\n")
+		gock.New(repo).
+			Get("/profiles/base.yaml").
+			Reply(200).
+			BodyString(baseProfile)
+
+		manifest, err := GenerateManifest(naisrequest.Deploy{ManifestUrl: repo + "/app/nais.yaml"})
+		assert.NoError(t, err)
+		assert.Equal(t, "platform", manifest.Team)
+	})
+
+	t.Run("Cycles give error", func(t *testing.T) {
+		defer gock.Off()
+		gock.New(repo).
+			Get("/a.yaml").
+			Reply(200).
+			BodyString("extends: b.yaml\nimage: navikt/app\n")
+		gock.New(repo).
+			Get("/b.yaml").
+			Reply(200).
+			BodyString("extends: a.yaml\n")
+
+		_, err := GenerateManifest(naisrequest.Deploy{ManifestUrl: repo + "/a.yaml"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "manifest extends itself")
+	})
+
+	t.Run("Extends that is neither a profile nor a http URL gives error", func(t *testing.T) {
+		base, err := resolveManifestBase("hardened", repo+"/app/nais.yaml", map[string]string{})
+		assert.NoError(t, err)
+		assert.Equal(t, repo+"/app/hardened", base)
+
+		_, err = resolveManifestBase("hardened", "file:///nais.yaml", map[string]string{})
+		assert.Error(t, err)
+	})
+}
+
+func TestDeepMergeManifest(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"team":     "platform",
+		"replicas": map[interface{}]interface{}{"min": 2, "max": 4},
+		"alerts":   []interface{}{"a", "b"},
+	}
+	override := map[interface{}]interface{}{
+		"replicas": map[interface{}]interface{}{"max": 8},
+		"alerts":   []interface{}{"c"},
+	}
+
+	merged := deepMergeManifest(base, override)
+	assert.Equal(t, "platform", merged["team"])
+	assert.Equal(t, map[interface{}]interface{}{"min": 2, "max": 8}, merged["replicas"])
+	assert.Equal(t, []interface{}{"c"}, merged["alerts"])
+	assert.Equal(t, map[interface{}]interface{}{"min": 2, "max": 4}, base["replicas"])
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Top level keys with this prefix are not part of the manifest, but a place to put anchors for the rest of it to use
+const manifestExtensionPrefix = "x-"
+
+var yamlErrorExplanations = []struct {
+	pattern     *regexp.Regexp
+	explanation string
+}{
+	{regexp.MustCompile(`unknown anchor '(.*)' referenced`), "alias *%s refers to an anchor that is not defined before it"},
+	{regexp.MustCompile(`anchor '(.*)' value contains itself`), "anchor &%s contains an alias to itself, which would never end"},
+	{regexp.MustCompile(`map merge requires map or sequence of maps`), "a merge key (<<) must refer to a mapping or a list of mappings"},
+}
+
+// Rewords the errors yaml.v2 gives for anchors and merge keys so that teams can tell what to fix
+func explainYamlError(err error) error {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	for _, e := range yamlErrorExplanations {
+		if match := e.pattern.FindStringSubmatch(message); match != nil {
+			if len(match) > 1 {
+				return fmt.Errorf(e.explanation, match[1])
+			}
+			return errors.New(e.explanation)
+		}
+	}
+	return err
+}
+
+// yaml.v2 lets a merge key override the keys set before it in the same mapping, while YAML says keys set explicitly
+// always win. Compares the document with anchors and merges resolved to the keys as they are written, and returns the
+// keys that were overridden by a merge.
+func mergeOverrides(explicit, resolved interface{}, path string) []string {
+	switch explicit := explicit.(type) {
+	case yaml.MapSlice:
+		resolvedMap, ok := resolved.(map[interface{}]interface{})
+		if !ok {
+			return []string{strings.TrimSuffix(path, ".")}
+		}
+		var overridden []string
+		for _, item := range explicit {
+			value, ok := resolvedMap[item.Key]
+			if !ok {
+				overridden = append(overridden, fmt.Sprintf("%s%v", path, item.Key))
+				continue
+			}
+			overridden = append(overridden, mergeOverrides(item.Value, value, fmt.Sprintf("%s%v.", path, item.Key))...)
+		}
+		return overridden
+	case []interface{}:
+		elements, ok := resolved.([]interface{})
+		if !ok || len(elements) != len(explicit) {
+			return []string{strings.TrimSuffix(path, ".")}
+		}
+		var overridden []string
+		for i, element := range explicit {
+			overridden = append(overridden, mergeOverrides(element, elements[i], fmt.Sprintf("%s%d.", path, i))...)
+		}
+		return overridden
+	default:
+		if !reflect.DeepEqual(explicit, resolved) {
+			return []string{strings.TrimSuffix(path, ".")}
+		}
+		return nil
+	}
+}
+
+// Decodes a manifest document, resolving anchors, aliases and merge keys (<<). Merges that yaml.v2 would resolve
+// differently from YAML, i.e. merge keys after the keys that override them, are errors rather than silently deploying
+// the merged value. Top level x- keys are removed.
+func decodeManifestDocument(data []byte) (map[interface{}]interface{}, error) {
+	var document map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, explainYamlError(err)
+	}
+
+	var explicit yaml.MapSlice
+	if err := yaml.Unmarshal(data, &explicit); err != nil {
+		return nil, explainYamlError(err)
+	}
+	if overridden := mergeOverrides(explicit, document, ""); len(overridden) > 0 {
+		sort.Strings(overridden)
+		return nil, fmt.Errorf("merge keys (<<) override keys set before them: %s, move the merge key first in its mapping", strings.Join(overridden, ", "))
+	}
+
+	for key := range document {
+		if name, ok := key.(string); ok && strings.HasPrefix(name, manifestExtensionPrefix) {
+			delete(document, key)
+		}
+	}
+
+	return document, nil
+}
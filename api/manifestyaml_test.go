@@ -0,0 +1,84 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestDecodeManifestDocument(t *testing.T) {
+	t.Run("Anchors, aliases and merge keys are resolved", func(t *testing.T) {
+		document, err := decodeManifestDocument([]byte(`
+x-common-env: &common
+  LOG_LEVEL: info
+  TZ: Europe/Oslo
+image: app
+env:
+  <<: *common
+  LOG_LEVEL: debug
+dependsOn: [&db database, *db]
+`))
+		assert.NoError(t, err)
+		assert.Equal(t, map[interface{}]interface{}{"LOG_LEVEL": "debug", "TZ": "Europe/Oslo"}, document["env"])
+		assert.Equal(t, []interface{}{"database", "database"}, document["dependsOn"])
+		assert.NotContains(t, document, "x-common-env")
+	})
+
+	t.Run("Merge keys overriding keys set before them give error", func(t *testing.T) {
+		_, err := decodeManifestDocument([]byte(`
+x-env: &common
+  LOG_LEVEL: info
+env:
+  LOG_LEVEL: debug
+  <<: *common
+alerts:
+- alert: down
+  labels: {severity: critical, <<: {severity: warning}}
+`))
+		assert.EqualError(t, err, "merge keys (<<) override keys set before them: alerts.0.labels.severity, env.LOG_LEVEL, move the merge key first in its mapping")
+	})
+
+	t.Run("Anchor errors are explained", func(t *testing.T) {
+		_, err := decodeManifestDocument([]byte("env: *common\n"))
+		assert.EqualError(t, err, "alias *common refers to an anchor that is not defined before it")
+
+		_, err = decodeManifestDocument([]byte("env: &common\n  nested: *common\n"))
+		assert.EqualError(t, err, "anchor &common contains an alias to itself, which would never end")
+
+		_, err = decodeManifestDocument([]byte("x-image: &image app\nenv:\n  <<: *image\n"))
+		assert.EqualError(t, err, "a merge key (<<) must refer to a mapping or a list of mappings")
+	})
+}
+
+func TestManifestAnchors(t *testing.T) {
+	t.Run("Strict manifests can keep anchors in x- keys", func(t *testing.T) {
+		manifest, err := unmarshalManifest([]byte(`
+strict: true
+x-limits: &limits
+  cpu: 500m
+  memory: 512Mi
+image: app
+resources:
+  limits: *limits
+  requests:
+    <<: *limits
+    cpu: 200m
+`))
+		assert.NoError(t, err)
+		assert.Equal(t, ResourceList{Cpu: "500m", Memory: "512Mi"}, manifest.Resources.Limits)
+		assert.Equal(t, ResourceList{Cpu: "200m", Memory: "512Mi"}, manifest.Resources.Requests)
+	})
+
+	t.Run("Errors name the manifest", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://repo.local").
+			Get("/app/nais.yaml").
+			Reply(200).
+			BodyString("image: app\nenv: *common\n")
+
+		_, err := GenerateManifest(naisrequest.Deploy{ManifestUrl: "https://repo.local/app/nais.yaml"})
+		assert.Contains(t, err.Error(), "unable to unmarshal alias *common refers to an anchor that is not defined before it from URL: https://repo.local/app/nais.yaml")
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deploymentsQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deployments_queued",
+		Help: "deployment requests received that have not been admitted and started yet",
+	})
+	deploymentsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deployments_active",
+		Help: "deployments in progress",
+	})
+	deploymentsInPhase = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deployments_in_phase",
+		Help: "deployments in progress per phase",
+	}, []string{"phase"})
+	deploymentLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "deployment_tracker_lock_wait_seconds",
+		Help:    "time spent waiting for the lock on the deployments in progress",
+		Buckets: []float64{.0001, .001, .01, .1, 1, 10},
+	})
+	fasitThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fasit_throttled_total",
+		Help: "responses from Fasit asking naisd to slow down, per application being deployed",
+	}, []string{"application"})
+	bestEffortFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deployment_best_effort_failures_total",
+		Help: "best-effort deployment steps that failed without failing the deployment, by step",
+	}, []string{"step"})
+	fasitResourceCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fasit_resource_cache_lookups_total",
+		Help: "lookups of used Fasit resources in the cache, by result: hit or miss",
+	}, []string{"result"})
+	fasitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fasit_circuit_breaker_state",
+		Help: "state of the circuit breaker per Fasit host: 0 closed, 1 half-open, 2 open",
+	}, []string{"host"})
+	fasitRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fasit_retries_total",
+		Help: "requests to Fasit sent again, by reason: network_error, server_error or throttled",
+	}, []string{"reason"})
+	fasitConditionalLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fasit_conditional_requests_total",
+		Help: "lookups sent to Fasit with the ETag of a cached response, by result: not_modified or modified",
+	}, []string{"result"})
+	fasitConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fasit_connections_total",
+		Help: "connections requests to Fasit were sent on, by whether they were reused from the pool: true or false",
+	}, []string{"reused"})
+	fasitOpenConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fasit_open_connections",
+		Help: "connections to Fasit that are open, in use or idle in the pool",
+	})
+	fasitRateLimitWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fasit_rate_limit_wait_seconds",
+		Help:    "time requests to Fasit waited for the rate limit before being sent",
+		Buckets: []float64{.001, .01, .1, 1, 10, 60},
+	})
+	deploymentLogFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deployment_log_failures_total",
+		Help: "deployments that could not be written to the deployment log in Elasticsearch",
+	})
+	stateStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "state_store_errors_total",
+		Help: "entries of the deployment history or audit log that could not be stored, by kind",
+	}, []string{"kind"})
+)
+
+func collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		requests,
+		deploys,
+		httpReqsCounter,
+		requestCounter,
+		errorCounter,
+		deploymentsQueued,
+		deploymentsActive,
+		deploymentsInPhase,
+		deploymentLockWait,
+		fasitThrottled,
+		fasitRetries,
+		fasitBreakerState,
+		fasitResourceCache,
+		fasitConditionalLookups,
+		fasitConnections,
+		fasitOpenConnections,
+		fasitRateLimitWait,
+		bestEffortFailures,
+		stateStoreErrors,
+		deploymentLogFailures,
+	}
+}
+
+// RegisterMetrics registers naisd's metrics with registerer. Registering them more than once is not an error, so
+// any number of Apis may share a registry.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range collectors() {
+		if err := registerer.Register(collector); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+				continue
+			}
+			return fmt.Errorf("unable to register metric: %s", err)
+		}
+	}
+	return nil
+}
+
+// NewMetricsRegistry returns a registry with naisd's metrics only, e.g. for tests and tools embedding the api package
+func NewMetricsRegistry() (*prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+	if err := RegisterMetrics(registry); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Metrics are served from the default registry unless the Api is given its own
+func (api Api) metricsGatherer() prometheus.Gatherer {
+	if api.Metrics == nil {
+		return prometheus.DefaultGatherer
+	}
+	return api.Metrics
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	t.Run("Metrics can be registered more than once", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		assert.NoError(t, RegisterMetrics(registry))
+		assert.NoError(t, RegisterMetrics(registry))
+	})
+
+	t.Run("Conflicting metric gives error", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "requests", Help: "something else"}))
+		assert.Error(t, RegisterMetrics(registry))
+	})
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	registry, err := NewMetricsRegistry()
+	assert.NoError(t, err)
+	requests.With(prometheus.Labels{"path": "isAlive"}).Inc()
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	Api{Metrics: registry}.Handler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `requests{path="isAlive"}`)
+	assert.NotContains(t, rr.Body.String(), "go_goroutines")
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	MirrorOfLabel           = "nais.io/mirror-of"
+	MirrorExpiresAnnotation = "nais.io/mirror-expires"
+	// Set on the ingress of the mirrored application, naming the mirror receiving its traffic
+	MirrorAnnotation = "nais.io/mirror"
+	// nginx sends a copy of each request to this target and discards the response
+	MirrorTargetAnnotation = "nginx.ingress.kubernetes.io/mirror-target"
+	DefaultMirrorDuration  = time.Hour
+	mirrorSuffix           = "-mirror"
+)
+
+// mirrorApplicationName is the name of the mirror of the application
+func mirrorApplicationName(application string) string {
+	return suffixedApplicationName(application, mirrorSuffix)
+}
+
+// suffixedApplicationName is the application's name with the suffix. A name that is too long is cut, and suffixed with
+// a hash of the application, so applications with the same beginning get different names.
+func suffixedApplicationName(application, suffix string) string {
+	if len(application)+len(suffix) > maxApplicationNameLength {
+		hash := sha256.Sum256([]byte(application))
+		short := hex.EncodeToString(hash[:])[:6]
+		application = strings.TrimRight(application[:maxApplicationNameLength-len(suffix)-len(short)-1], "-") + "-" + short
+	}
+	return application + suffix
+}
+
+// checkMirrorName fails if the name of the mirror is taken by something other than an earlier mirror of the
+// application, like an application that happens to end in -mirror
+func checkMirrorName(namespace, mirror, application string, k8sClient kubernetes.Interface) error {
+	deployment, err := getExistingDeployment(mirror, namespace, k8sClient)
+	if err != nil || deployment == nil {
+		return err
+	}
+
+	switch mirrorOf := deployment.Labels[MirrorOfLabel]; mirrorOf {
+	case application:
+		return nil
+	case "":
+		return fmt.Errorf("%s/%s is an application, not a mirror", namespace, mirror)
+	default:
+		return fmt.Errorf("%s/%s is the mirror of %s", namespace, mirror, mirrorOf)
+	}
+}
+
+func mirrorTarget(namespace, mirror string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local$request_uri", mirror, namespace)
+}
+
+// Rewrites the deployment into a mirror of the application. The mirror has no ingress of its own and is never
+// registered in Fasit, but uses the same Fasit resources as the application. Returns the time mirroring stops.
+func applyMirror(deploymentRequest *naisrequest.Deploy, manifest *NaisManifest, now time.Time) (time.Time, error) {
+	duration := DefaultMirrorDuration
+	if len(deploymentRequest.Mirror.Duration) > 0 {
+		var err error
+		if duration, err = time.ParseDuration(deploymentRequest.Mirror.Duration); err != nil {
+			return time.Time{}, fmt.Errorf("invalid mirror duration %s: %s", deploymentRequest.Mirror.Duration, err)
+		}
+	}
+
+	deploymentRequest.Application = mirrorApplicationName(deploymentRequest.Application)
+	manifest.Ingress.Disabled = true
+	manifest.FasitResources.Exposed = nil
+
+	return now.Add(duration), nil
+}
+
+// Marks the mirror deployment and makes the ingress of the application send a copy of its traffic to the mirror
+func startMirroring(namespace, application, mirror string, expires time.Time, k8sClient kubernetes.Interface) error {
+	ingress, err := getExistingIngress(application, namespace, k8sClient)
+	if err != nil {
+		return fmt.Errorf("unable to get ingress: %s", err)
+	}
+	if ingress == nil {
+		return fmt.Errorf("application %s has no ingress to mirror traffic from", application)
+	}
+
+	deployment, err := deployments(k8sClient, namespace).Get(mirror, k8smeta.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get deployment: %s", err)
+	}
+	if deployment.Labels == nil {
+		deployment.Labels = make(map[string]string)
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Labels[MirrorOfLabel] = application
+	deployment.Annotations[MirrorExpiresAnnotation] = expires.UTC().Format(time.RFC3339)
+	if _, err := deployments(k8sClient, namespace).Update(deployment); err != nil {
+		return fmt.Errorf("unable to mark mirror deployment: %s", err)
+	}
+
+	if ingress.Annotations == nil {
+		ingress.Annotations = make(map[string]string)
+	}
+	ingress.Annotations[MirrorAnnotation] = mirror
+	ingress.Annotations[MirrorTargetAnnotation] = mirrorTarget(namespace, mirror)
+	if _, err := ingresses(k8sClient, namespace).Update(ingress); err != nil {
+		return fmt.Errorf("unable to mirror traffic from ingress: %s", err)
+	}
+
+	return nil
+}
+
+// Stops mirroring traffic to the mirror and deletes it. The ingress is left alone if it mirrors to another instance.
+func stopMirroring(namespace, application, mirror string, k8sClient kubernetes.Interface) error {
+	ingress, err := getExistingIngress(application, namespace, k8sClient)
+	if err != nil {
+		return fmt.Errorf("unable to get ingress: %s", err)
+	}
+
+	if ingress != nil && ingress.Annotations[MirrorAnnotation] == mirror {
+		delete(ingress.Annotations, MirrorAnnotation)
+		delete(ingress.Annotations, MirrorTargetAnnotation)
+		if _, err := ingresses(k8sClient, namespace).Update(ingress); err != nil {
+			return fmt.Errorf("unable to stop mirroring traffic from ingress: %s", err)
+		}
+	}
+
+	if _, err := deleteK8sResouces(namespace, mirror, k8sClient); err != nil {
+		return fmt.Errorf("unable to delete mirror: %s", err)
+	}
+
+	return nil
+}
+
+// Stops every mirror that has expired, returning namespace/name of the stopped mirrors
+func expireMirrors(now time.Time, k8sClient kubernetes.Interface) ([]string, error) {
+	mirrors, err := deployments(k8sClient, "").List(k8smeta.ListOptions{LabelSelector: MirrorOfLabel})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list mirror deployments: %s", err)
+	}
+
+	var expired []string
+	for _, deployment := range mirrors.Items {
+		expires, err := time.Parse(time.RFC3339, deployment.Annotations[MirrorExpiresAnnotation])
+		if err != nil {
+			glog.Warningf("mirror %s/%s has invalid expiry: %s", deployment.Namespace, deployment.Name, err)
+			continue
+		}
+		if now.Before(expires) {
+			continue
+		}
+
+		if err := stopMirroring(deployment.Namespace, deployment.Labels[MirrorOfLabel], deployment.Name, k8sClient); err != nil {
+			return expired, fmt.Errorf("unable to stop mirror %s/%s: %s", deployment.Namespace, deployment.Name, err)
+		}
+		expired = append(expired, deployment.Namespace+"/"+deployment.Name)
+	}
+
+	return expired, nil
+}
+
+// ExpireMirrorsPeriodically stops mirrors whose duration has passed until the process exits
+func (api Api) ExpireMirrorsPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		expired, err := expireMirrors(time.Now(), api.Clientset)
+		for _, name := range expired {
+			glog.Infof("stopped expired mirror %s", name)
+		}
+		if err != nil {
+			glog.Errorf("unable to expire mirrors: %s", err)
+		}
+	}
+}
+
+func (api Api) stopMirror(w http.ResponseWriter, r *http.Request) *appError {
+	namespace := pat.Param(r, "namespace")
+	deployName := pat.Param(r, "deployName")
+
+	deployment, err := getExistingDeployment(deployName, namespace, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to get deployment", http.StatusInternalServerError}
+	}
+	if deployment == nil || len(deployment.Labels[MirrorOfLabel]) == 0 {
+		return &appError{fmt.Errorf("%s/%s is not a mirror", namespace, deployName), "mirror not found", http.StatusNotFound}
+	}
+	if _, appErr := api.authorizeTeam(r, deployment.Labels["team"]); appErr != nil {
+		return appErr
+	}
+
+	if err := stopMirroring(namespace, deployment.Labels[MirrorOfLabel], deployName, api.Clientset); err != nil {
+		return &appError{err, "unable to stop mirror", http.StatusInternalServerError}
+	}
+
+	api.AuditLog.Record(AuditEntry{Event: "mirror_stopped", Application: deployment.Labels[MirrorOfLabel], Namespace: namespace})
+	glog.Infof("Stopped mirror %s in %s\n", deployName, namespace)
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func mirroredIngress() *k8sextensions.Ingress {
+	return &k8sextensions.Ingress{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}}
+}
+
+func TestMirrorApplicationName(t *testing.T) {
+	assert.Equal(t, "app-mirror", mirrorApplicationName("app"))
+
+	long := mirrorApplicationName(strings.Repeat("a", 100))
+	assert.True(t, len(long) <= maxApplicationNameLength)
+	assert.True(t, strings.HasSuffix(long, mirrorSuffix))
+	assert.NotEqual(t, long, mirrorApplicationName(strings.Repeat("a", 100)+"b"), "long applications with the same beginning get different mirrors")
+}
+
+func TestCheckMirrorName(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-mirror", Namespace: namespace, Labels: map[string]string{MirrorOfLabel: "app"}}},
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "other-mirror", Namespace: namespace}},
+	)
+
+	assert.NoError(t, checkMirrorName(namespace, "new-mirror", "new", clientset))
+	assert.NoError(t, checkMirrorName(namespace, "app-mirror", "app", clientset), "an application is mirrored again")
+	assert.EqualError(t, checkMirrorName(namespace, "other-mirror", "other", clientset), namespace+"/other-mirror is an application, not a mirror")
+	assert.EqualError(t, checkMirrorName(namespace, "app-mirror", "app2", clientset), namespace+"/app-mirror is the mirror of app")
+}
+
+func TestApplyMirror(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Mirror has no ingress and exposes no resources", func(t *testing.T) {
+		request := naisrequest.Deploy{Application: "app", Mirror: &naisrequest.Mirror{Duration: "2h"}}
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "api"}}}}
+
+		expires, err := applyMirror(&request, &manifest, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "app-mirror", request.Application)
+		assert.True(t, manifest.Ingress.Disabled)
+		assert.Empty(t, manifest.FasitResources.Exposed)
+		assert.Equal(t, now.Add(2*time.Hour), expires)
+	})
+
+	t.Run("Default duration is used when not set", func(t *testing.T) {
+		expires, err := applyMirror(&naisrequest.Deploy{Application: "app", Mirror: &naisrequest.Mirror{}}, &NaisManifest{}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(DefaultMirrorDuration), expires)
+	})
+
+	t.Run("Invalid duration gives error", func(t *testing.T) {
+		_, err := applyMirror(&naisrequest.Deploy{Application: "app", Mirror: &naisrequest.Mirror{Duration: "a while"}}, &NaisManifest{}, now)
+		assert.Error(t, err)
+	})
+}
+
+func TestMirrorOfPreviewIsRejected(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	api := Api{Clientset: clientset}
+
+	body := `{"application": "app", "version": "1", "namespace": "default", "preview": {"branch": "feature"}, "mirror": {}}`
+	req, _ := http.NewRequest("POST", "/deploy", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	appHandler(api.deploy).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "preview and mirror can not be combined")
+	assert.Empty(t, clientset.Actions(), "nothing is applied")
+}
+
+func TestMirroring(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Application without ingress can not be mirrored", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-mirror", Namespace: namespace}})
+
+		err := startMirroring(namespace, appName, "app-mirror", now, clientset)
+		assert.EqualError(t, err, "application "+appName+" has no ingress to mirror traffic from")
+	})
+
+	t.Run("Traffic is mirrored until the mirror expires", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(
+			mirroredIngress(),
+			alertsConfigMap(),
+			&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}},
+			&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-mirror", Namespace: namespace}},
+		)
+
+		assert.NoError(t, startMirroring(namespace, appName, "app-mirror", now.Add(time.Hour), clientset))
+		ingress, _ := getExistingIngress(appName, namespace, clientset)
+		assert.Equal(t, "http://app-mirror."+namespace+".svc.cluster.local$request_uri", ingress.Annotations[MirrorTargetAnnotation])
+		deployment, _ := getExistingDeployment("app-mirror", namespace, clientset)
+		assert.Equal(t, appName, deployment.Labels[MirrorOfLabel])
+
+		expired, err := expireMirrors(now, clientset)
+		assert.NoError(t, err)
+		assert.Empty(t, expired)
+
+		expired, err = expireMirrors(now.Add(time.Hour), clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{namespace + "/app-mirror"}, expired)
+
+		ingress, _ = getExistingIngress(appName, namespace, clientset)
+		assert.NotContains(t, ingress.Annotations, MirrorTargetAnnotation)
+		assert.NotContains(t, ingress.Annotations, MirrorAnnotation)
+		deployment, _ = getExistingDeployment("app-mirror", namespace, clientset)
+		assert.Nil(t, deployment)
+		deployment, _ = getExistingDeployment(appName, namespace, clientset)
+		assert.NotNil(t, deployment)
+	})
+}
+
+func TestStopMirrorHandler(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		mirroredIngress(),
+		alertsConfigMap(),
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}},
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-mirror", Namespace: namespace, Labels: map[string]string{"team": teamName}}},
+	)
+	api := Api{
+		Clientset:     clientset,
+		AuditLog:      NewAuditLog(),
+		OperatorToken: "secret",
+		Identities:    []Identity{{Name: "alice", Teams: []string{teamName}, Token: "alice"}, {Name: "bob", Teams: []string{"other"}, Token: "bob"}},
+	}
+	assert.NoError(t, startMirroring(namespace, appName, "app-mirror", time.Now().Add(time.Hour), clientset))
+
+	stop := func(name, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/mirror/"+namespace+"/"+name+"/stop", nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Regular applications can not be stopped", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, stop(appName, "secret").Code)
+	})
+
+	t.Run("Only the team of the mirror and the operator can stop it", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, stop("app-mirror", "").Code)
+		assert.Equal(t, http.StatusForbidden, stop("app-mirror", "bob").Code)
+		deployment, _ := getExistingDeployment("app-mirror", namespace, clientset)
+		assert.NotNil(t, deployment)
+	})
+
+	t.Run("Mirror is deleted on stop", func(t *testing.T) {
+		rr := stop("app-mirror", "alice")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		deployment, _ := getExistingDeployment("app-mirror", namespace, clientset)
+		assert.Nil(t, deployment)
+		assert.Equal(t, "mirror_stopped", api.AuditLog.Entries()[0].Event)
+	})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Exposed resources for messaging. Queues, topics and channels refer to the queue manager they are on by its url,
+// mq://<hostname>:<port>/<name>, like Fasit does.
+const (
+	QueueResourceType        = "Queue"
+	TopicResourceType        = "Topic"
+	ChannelResourceType      = "Channel"
+	QueueManagerResourceType = "QueueManager"
+)
+
+type QueueResourcePayload struct {
+	Alias      string            `json:"alias"`
+	Scope      Scope             `json:"scope"`
+	Type       string            `json:"type"`
+	Properties QueueProperties   `json:"properties"`
+	Metadata   *ResourceMetadata `json:"metadata,omitempty"`
+}
+type TopicResourcePayload struct {
+	Alias      string            `json:"alias"`
+	Scope      Scope             `json:"scope"`
+	Type       string            `json:"type"`
+	Properties TopicProperties   `json:"properties"`
+	Metadata   *ResourceMetadata `json:"metadata,omitempty"`
+}
+type ChannelResourcePayload struct {
+	Alias      string            `json:"alias"`
+	Scope      Scope             `json:"scope"`
+	Type       string            `json:"type"`
+	Properties ChannelProperties `json:"properties"`
+	Metadata   *ResourceMetadata `json:"metadata,omitempty"`
+}
+type QueueManagerResourcePayload struct {
+	Alias      string                 `json:"alias"`
+	Scope      Scope                  `json:"scope"`
+	Type       string                 `json:"type"`
+	Properties QueueManagerProperties `json:"properties"`
+	Metadata   *ResourceMetadata      `json:"metadata,omitempty"`
+}
+type QueueProperties struct {
+	QueueName    string `json:"queueName"`
+	QueueManager string `json:"queueManager"`
+	Description  string `json:"description,omitempty"`
+}
+type TopicProperties struct {
+	TopicString  string `json:"topicString"`
+	QueueManager string `json:"queueManager"`
+	Description  string `json:"description,omitempty"`
+}
+type ChannelProperties struct {
+	Name         string `json:"name"`
+	QueueManager string `json:"queueManager"`
+	Description  string `json:"description,omitempty"`
+}
+type QueueManagerProperties struct {
+	Name        string `json:"name"`
+	Hostname    string `json:"hostname"`
+	Port        string `json:"port"`
+	Description string `json:"description,omitempty"`
+}
+
+var mqResourceTypes = []string{QueueResourceType, TopicResourceType, ChannelResourceType, QueueManagerResourceType}
+
+// mqResourceType is the name Fasit gives resourceType, or an empty string if it is not a messaging resource
+func mqResourceType(resourceType string) string {
+	for _, mqType := range mqResourceTypes {
+		if strings.EqualFold(mqType, resourceType) {
+			return mqType
+		}
+	}
+	return ""
+}
+
+func isMqResourceType(resourceType string) bool {
+	return len(mqResourceType(resourceType)) > 0
+}
+
+func buildMqResourcePayload(resource ExposedResource, scope Scope) ResourcePayload {
+	switch mqResourceType(resource.ResourceType) {
+	case QueueResourceType:
+		return QueueResourcePayload{
+			Type:  QueueResourceType,
+			Alias: resource.Alias,
+			Properties: QueueProperties{
+				QueueName:    resource.QueueName,
+				QueueManager: resource.QueueManager,
+				Description:  resource.Description,
+			},
+			Scope: scope,
+		}
+	case TopicResourceType:
+		return TopicResourcePayload{
+			Type:  TopicResourceType,
+			Alias: resource.Alias,
+			Properties: TopicProperties{
+				TopicString:  resource.TopicString,
+				QueueManager: resource.QueueManager,
+				Description:  resource.Description,
+			},
+			Scope: scope,
+		}
+	case ChannelResourceType:
+		return ChannelResourcePayload{
+			Type:  ChannelResourceType,
+			Alias: resource.Alias,
+			Properties: ChannelProperties{
+				Name:         resource.ChannelName,
+				QueueManager: resource.QueueManager,
+				Description:  resource.Description,
+			},
+			Scope: scope,
+		}
+	case QueueManagerResourceType:
+		return QueueManagerResourcePayload{
+			Type:  QueueManagerResourceType,
+			Alias: resource.Alias,
+			Properties: QueueManagerProperties{
+				Name:        resource.QueueManager,
+				Hostname:    resource.Hostname,
+				Port:        strconv.Itoa(resource.Port),
+				Description: resource.Description,
+			},
+			Scope: scope,
+		}
+	}
+	return nil
+}
+
+// validateMqResources checks the values of the properties of exposed messaging resources, which
+// validateExposedResourceProperties has checked are there
+func validateMqResources(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Exposed {
+		resourceType := mqResourceType(resource.ResourceType)
+		if len(resourceType) == 0 {
+			continue
+		}
+
+		fields := map[string]string{"Alias": resource.Alias, "ResourceType": resource.ResourceType}
+		if resourceType == QueueManagerResourceType {
+			if resource.Port < 0 || resource.Port > 65535 {
+				fields["Port"] = strconv.Itoa(resource.Port)
+				return &ValidationError{"Port must be between 1 and 65535 for an exposed resource of type QueueManager", fields}
+			}
+			continue
+		}
+
+		if len(resource.QueueManager) > 0 && !isQueueManagerUrl(resource.QueueManager) {
+			fields["QueueManager"] = resource.QueueManager
+			return &ValidationError{"QueueManager must be the url of a queue manager, mq://<hostname>:<port>/<name>", fields}
+		}
+	}
+
+	return nil
+}
+
+func isQueueManagerUrl(queueManager string) bool {
+	u, err := url.Parse(queueManager)
+	if err != nil || u.Scheme != "mq" || len(u.Hostname()) == 0 || len(strings.Trim(u.Path, "/")) == 0 {
+		return false
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	return err == nil && port > 0 && port < 65536
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildMqResourcePayload(t *testing.T) {
+	scope := Scope{EnvironmentClass: "t", Environment: "t1", Zone: "fss"}
+
+	t.Run("queue", func(t *testing.T) {
+		resource := ExposedResource{Alias: "myapp_queue_in", ResourceType: "queue", QueueName: "MYAPP.IN", QueueManager: "mq://mq.local:1414/QM1"}
+		payload, err := json.Marshal(buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"alias":"myapp_queue_in","scope":{"environmentclass":"t","environment":"t1","zone":"fss"},"type":"Queue","properties":{"queueName":"MYAPP.IN","queueManager":"mq://mq.local:1414/QM1"}}`, string(payload))
+	})
+
+	t.Run("topic", func(t *testing.T) {
+		resource := ExposedResource{Alias: "mytopic", ResourceType: "Topic", TopicString: "myapp/events", QueueManager: "mq://mq.local:1414/QM1", Description: "events"}
+		assert.Equal(t, TopicResourcePayload{
+			Alias:      "mytopic",
+			Type:       TopicResourceType,
+			Scope:      scope,
+			Properties: TopicProperties{TopicString: "myapp/events", QueueManager: "mq://mq.local:1414/QM1", Description: "events"},
+		}, buildMqResourcePayload(resource, scope))
+	})
+
+	t.Run("channel", func(t *testing.T) {
+		resource := ExposedResource{Alias: "mychannel", ResourceType: "channel", ChannelName: "MYAPP.CLIENT", QueueManager: "mq://mq.local:1414/QM1"}
+		payload := buildMqResourcePayload(resource, scope).(ChannelResourcePayload)
+		assert.Equal(t, ChannelResourceType, payload.Type)
+		assert.Equal(t, "MYAPP.CLIENT", payload.Properties.Name)
+	})
+
+	t.Run("queue manager", func(t *testing.T) {
+		resource := ExposedResource{Alias: "mymq", ResourceType: "queuemanager", QueueManager: "QM1", Hostname: "mq.local", Port: 1414}
+		assert.Equal(t, QueueManagerProperties{Name: "QM1", Hostname: "mq.local", Port: "1414"}, buildMqResourcePayload(resource, scope).(QueueManagerResourcePayload).Properties)
+	})
+
+	t.Run("metadata is added", func(t *testing.T) {
+		resource := ExposedResource{Alias: "myapp_queue_in", ResourceType: "Queue", QueueName: "MYAPP.IN", QueueManager: "mq://mq.local:1414/QM1"}
+		payload := withResourceMetadata(buildMqResourcePayload(resource, scope), ResourceMetadata{ManagedBy: ManagedByNaisd})
+		assert.Equal(t, ManagedByNaisd, payload.(QueueResourcePayload).Metadata.ManagedBy)
+	})
+}
+
+func TestValidateMqResources(t *testing.T) {
+	exposed := func(resource ExposedResource) NaisManifest {
+		return NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{resource}}}
+	}
+	queueManager := "mq://mq.local:1414/QM1"
+
+	assert.Nil(t, validateMqResources(exposed(ExposedResource{Alias: "q", ResourceType: "Queue", QueueName: "Q", QueueManager: queueManager})))
+	assert.Nil(t, validateMqResources(exposed(ExposedResource{Alias: "t", ResourceType: "topic", TopicString: "a/b", QueueManager: queueManager})))
+	assert.Nil(t, validateMqResources(exposed(ExposedResource{Alias: "c", ResourceType: "Channel", ChannelName: "C", QueueManager: queueManager})))
+	assert.Nil(t, validateMqResources(exposed(ExposedResource{Alias: "m", ResourceType: "QueueManager", QueueManager: "QM1", Hostname: "mq.local", Port: 1414})))
+	assert.Nil(t, validateMqResources(exposed(ExposedResource{Alias: "r", ResourceType: "RestService", Path: "/api"})))
+
+	err := validateExposedResourceProperties(exposed(ExposedResource{Alias: "q", ResourceType: "Queue", QueueManager: queueManager}))
+	assert.Equal(t, "QueueName must be specified for an exposed resource of type Queue", err.ErrorMessage)
+	err = validateExposedResourceProperties(exposed(ExposedResource{Alias: "c", ResourceType: "Channel", ChannelName: "C"}))
+	assert.Equal(t, "QueueManager must be specified for an exposed resource of type Channel", err.ErrorMessage)
+	assert.NotNil(t, validateMqResources(exposed(ExposedResource{Alias: "q", ResourceType: "Queue", QueueName: "Q", QueueManager: "QM1"})))
+	assert.NotNil(t, validateMqResources(exposed(ExposedResource{Alias: "q", ResourceType: "Queue", QueueName: "Q", QueueManager: "mq://mq.local/QM1"})))
+	assert.NotNil(t, validateMqResources(exposed(ExposedResource{Alias: "q", ResourceType: "Queue", QueueName: "Q", QueueManager: "mq://mq.local:1414/"})))
+	assert.NotNil(t, validateExposedResourceProperties(exposed(ExposedResource{Alias: "q", ResourceType: "Queue", QueueName: "Q", QueueManager: queueManager, Path: "/q"})))
+	assert.NotNil(t, validateExposedResourceProperties(exposed(ExposedResource{Alias: "m", ResourceType: "QueueManager", QueueManager: "QM1", Hostname: "mq.local"})))
+	assert.NotNil(t, validateMqResources(exposed(ExposedResource{Alias: "m", ResourceType: "QueueManager", QueueManager: "QM1", Hostname: "mq.local", Port: 70000})))
+}
+
+func TestExposedResourceTypes(t *testing.T) {
+	exposed := func(resourceType string) NaisManifest {
+		return NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "a", ResourceType: resourceType}}}}
+	}
+
+	assert.Nil(t, validateResources(exposed("restservice")))
+	assert.Nil(t, validateResources(exposed("WebserviceEndpoint")))
+	assert.Nil(t, validateResources(exposed("queue")))
+	assert.NotNil(t, validateResources(exposed("BaseUrl")))
+}
@@ -7,16 +7,51 @@ import (
 )
 
 type Deploy struct {
-	Application      string `json:"application"`
-	Version          string `json:"version"`
-	Zone             string `json:"zone"`
-	ManifestUrl      string `json:"manifesturl,omitempty"`
-	SkipFasit        bool   `json:"skipFasit,omitempty"`
-	FasitEnvironment string `json:"fasitEnvironment,omitempty"`
-	FasitUsername    string `json:"fasitUsername,omitempty"`
-	FasitPassword    string `json:"fasitPassword,omitempty"`
-	OnBehalfOf       string `json:"onbehalfof,omitempty"`
-	Namespace        string `json:"namespace"`
+	Application           string       `json:"application"`
+	Version               string       `json:"version"`
+	Zone                  string       `json:"zone"`
+	ManifestUrl           string       `json:"manifesturl,omitempty"`
+	SkipFasit             bool         `json:"skipFasit,omitempty"`
+	BypassFasitCache      bool         `json:"bypassFasitCache,omitempty"`
+	FasitDryRun           bool         `json:"fasitDryRun,omitempty"`
+	FasitEnvironment      string       `json:"fasitEnvironment,omitempty"`
+	FasitUsername         string       `json:"fasitUsername,omitempty"`
+	FasitPassword         string       `json:"fasitPassword,omitempty"`
+	FasitCredentialsRef   string       `json:"fasitCredentialsRef,omitempty"`
+	OnBehalfOf            string       `json:"onbehalfof,omitempty"`
+	Namespace             string       `json:"namespace"`
+	ManifestSha256        string       `json:"manifestSha256,omitempty"`
+	RequireImageSignature bool         `json:"requireImageSignature,omitempty"`
+	PullRequest           *PullRequest `json:"pullRequest,omitempty"`
+	Preview               *Preview     `json:"preview,omitempty"`
+	WaitForRollout        bool         `json:"waitForRollout,omitempty"`
+	Mirror                *Mirror      `json:"mirror,omitempty"`
+	DarkLaunch            *DarkLaunch  `json:"darkLaunch,omitempty"`
+	PolicyOverride        string       `json:"policyOverride,omitempty"`
+}
+
+// PullRequest identifies the pull/merge request a deployment was made from
+type PullRequest struct {
+	Provider   string `json:"provider"`
+	Repository string `json:"repository"`
+	Number     int    `json:"number"`
+}
+
+// Preview deploys a separate instance of the application for a branch, deleted after Ttl (e.g. 24h)
+type Preview struct {
+	Branch string `json:"branch"`
+	Ttl    string `json:"ttl,omitempty"`
+}
+
+// Mirror deploys a separate instance of the version receiving a copy of the application's traffic for Duration (e.g. 2h)
+type Mirror struct {
+	Duration string `json:"duration,omitempty"`
+}
+
+// DarkLaunch deploys a separate instance of the version, reached only by requests with the Header or Cookie set to "always"
+type DarkLaunch struct {
+	Header string `json:"header,omitempty"`
+	Cookie string `json:"cookie,omitempty"`
 }
 
 func (r Deploy) Validate() []error {
@@ -27,10 +62,12 @@ func (r Deploy) Validate() []error {
 		"namespace":        &r.Namespace,
 	}
 
-	if !r.SkipFasit {
+	if !r.SkipFasit && r.Preview == nil {
 		required["fasitEnvironment"] = &r.FasitEnvironment
-		required["fasitUsername"] = &r.FasitUsername
-		required["fasitPassword"] = &r.FasitPassword
+		if len(r.FasitCredentialsRef) == 0 {
+			required["fasitUsername"] = &r.FasitUsername
+			required["fasitPassword"] = &r.FasitPassword
+		}
 	}
 
 	var errs []error
@@ -44,5 +81,34 @@ func (r Deploy) Validate() []error {
 		errs = append(errs, errors.New("zone can only be fss, sbs or iapp"))
 	}
 
+	if r.Preview != nil && len(r.Preview.Branch) == 0 {
+		errs = append(errs, errors.New("preview branch is required"))
+	}
+
+	if len(r.FasitCredentialsRef) > 0 && (len(r.FasitUsername) > 0 || len(r.FasitPassword) > 0) {
+		errs = append(errs, errors.New("fasitCredentialsRef can not be combined with fasitUsername and fasitPassword"))
+	}
+
+	if r.FasitDryRun && r.SkipFasit {
+		errs = append(errs, errors.New("fasitDryRun can not be combined with skipFasit"))
+	}
+
+	if r.Preview != nil && r.Mirror != nil {
+		errs = append(errs, errors.New("preview and mirror can not be combined"))
+	}
+
+	if r.DarkLaunch != nil && (r.Preview != nil || r.Mirror != nil) {
+		errs = append(errs, errors.New("dark launch can not be combined with preview or mirror"))
+	}
+
+	if r.PullRequest != nil {
+		if r.PullRequest.Provider != "github" && r.PullRequest.Provider != "gitlab" {
+			errs = append(errs, errors.New("pull request provider can only be github or gitlab"))
+		}
+		if len(r.PullRequest.Repository) == 0 || r.PullRequest.Number <= 0 {
+			errs = append(errs, errors.New("pull request must have repository and number"))
+		}
+	}
+
 	return errs
 }
@@ -0,0 +1,69 @@
+package naisrequest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Pipeline deploys the same version to each stage in turn, e.g. t1, then q1, then p. The deployment fields apply to
+// every stage, except the environment and namespace which come from the stage.
+type Pipeline struct {
+	Deploy
+	Stages []Stage `json:"stages"`
+}
+
+// Stage is an environment in a pipeline. A stage with manual approval waits for it before it is deployed, and a stage
+// with a verification window (e.g. 15m) is watched for that long after rolling out before the next stage starts.
+type Stage struct {
+	Environment    string `json:"environment"`
+	Namespace      string `json:"namespace,omitempty"`
+	Zone           string `json:"zone,omitempty"`
+	ManualApproval bool   `json:"manualApproval,omitempty"`
+	Verify         string `json:"verify,omitempty"`
+}
+
+// StageRequest is the deployment request for stage i of the pipeline. Stages are rolled out before the next one starts.
+func (p Pipeline) StageRequest(i int) Deploy {
+	stage := p.Stages[i]
+
+	deploy := p.Deploy
+	deploy.FasitEnvironment = stage.Environment
+	if len(stage.Namespace) > 0 {
+		deploy.Namespace = stage.Namespace
+	}
+	if len(stage.Zone) > 0 {
+		deploy.Zone = stage.Zone
+	}
+	deploy.WaitForRollout = true
+
+	return deploy
+}
+
+func (p Pipeline) Validate() []error {
+	if len(p.Stages) == 0 {
+		return []error{errors.New("a pipeline must have at least one stage")}
+	}
+
+	if p.Preview != nil || p.Mirror != nil || p.DarkLaunch != nil {
+		return []error{errors.New("a pipeline can not deploy previews, mirrors or dark launches")}
+	}
+
+	var errs []error
+	if len(p.Application) == 0 || len(p.Version) == 0 {
+		errs = append(errs, errors.New("application and version are required"))
+	}
+
+	for i, stage := range p.Stages {
+		if len(stage.Environment) == 0 {
+			errs = append(errs, fmt.Errorf("environment of stage %d is required and is empty", i+1))
+			continue
+		}
+
+		if verify, err := time.ParseDuration(stage.Verify); len(stage.Verify) > 0 && (err != nil || verify < 0) {
+			errs = append(errs, fmt.Errorf("verify of stage %s must be a duration, e.g. 15m", stage.Environment))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,36 @@
+package naisrequest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Rollout deploys the latest successful deployment of every application matching the selector again, one at a time,
+// e.g. after a platform-wide secret or CA has been rotated. Empty selector fields match every application, but at
+// least one of them must be set.
+type Rollout struct {
+	Environment  string   `json:"environment,omitempty"`
+	Namespace    string   `json:"namespace,omitempty"`
+	Team         string   `json:"team,omitempty"`
+	Applications []string `json:"applications,omitempty"`
+	Interval     string   `json:"interval,omitempty"`
+	RefreshFasit bool     `json:"refreshFasit,omitempty"`
+	RequestedBy  string   `json:"requestedBy,omitempty"`
+}
+
+func (r Rollout) Validate() []error {
+	var errs []error
+
+	if len(r.Environment) == 0 && len(r.Namespace) == 0 && len(r.Team) == 0 && len(r.Applications) == 0 {
+		errs = append(errs, errors.New("one of environment, namespace, team or applications is required"))
+	}
+
+	if len(r.Interval) > 0 {
+		if interval, err := time.ParseDuration(r.Interval); err != nil || interval < 0 {
+			errs = append(errs, fmt.Errorf("interval %q is not a positive duration", r.Interval))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,43 @@
+package naisrequest
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Transfer reassigns an application from one team to another. Nothing is changed until both teams have approved it.
+type Transfer struct {
+	Application string `json:"application"`
+	Namespace   string `json:"namespace"`
+	FromTeam    string `json:"fromTeam"`
+	ToTeam      string `json:"toTeam"`
+	RequestedBy string `json:"requestedBy,omitempty"`
+}
+
+// TransferApproval is the approval of a transfer by one of its teams. The team is the one the approver acts for, and
+// only needs to be named if the approver acts for both.
+type TransferApproval struct {
+	Team string `json:"team,omitempty"`
+}
+
+func (r Transfer) Validate() []error {
+	required := map[string]string{
+		"application": r.Application,
+		"namespace":   r.Namespace,
+		"fromTeam":    r.FromTeam,
+		"toTeam":      r.ToTeam,
+	}
+
+	var errs []error
+	for key, value := range required {
+		if len(value) == 0 {
+			errs = append(errs, fmt.Errorf("%s is required and is empty", key))
+		}
+	}
+
+	if len(r.FromTeam) > 0 && r.FromTeam == r.ToTeam {
+		errs = append(errs, errors.New("fromTeam and toTeam must be different teams"))
+	}
+
+	return errs
+}
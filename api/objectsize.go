@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const (
+	// Linux refuses to start a process with a single environment variable larger than this (MAX_ARG_STRLEN)
+	MaxEnvironmentVariableSize = 128 * 1024
+	// Environment variables are stored in the Deployment, which etcd limits to 1.5MiB in total
+	MaxEnvironmentSize = 1024 * 1024
+	// Kubernetes rejects Secrets with more data than this
+	MaxSecretSize = 1024 * 1024
+)
+
+type resourceSize struct {
+	resource NaisResource
+	size     int
+}
+
+func sizeHint(resource NaisResource) string {
+	switch {
+	case resource.resourceType == "applicationproperties":
+		return "move applicationproperties to a ConfigMap mount"
+	case len(resource.certificates) > 0:
+		return "remove unused certificates from the resource"
+	default:
+		return "remove unused properties from the resource"
+	}
+}
+
+func formatSize(bytes int) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	return fmt.Sprintf("%.1fKiB", float64(bytes)/1024)
+}
+
+// largest returns the resource contributing the most bytes
+func largest(sizes []resourceSize) resourceSize {
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].size > sizes[j].size
+	})
+	return sizes[0]
+}
+
+func checkEnvironmentSize(deploymentRequest naisrequest.Deploy, naisResources []NaisResource) error {
+	total := 0
+	for _, envVar := range createDefaultEnvironmentVariables(&deploymentRequest) {
+		total += len(envVar.Name) + len(envVar.Value)
+	}
+
+	var sizes []resourceSize
+	for _, resource := range naisResources {
+		if isFeatureToggle(resource.resourceType) {
+			continue
+		}
+
+		size := 0
+		for key, value := range resource.properties {
+			name := resource.ToEnvironmentVariable(key)
+			if len(name)+len(value) > MaxEnvironmentVariableSize {
+				return fmt.Errorf("environment variable %s from %s (%s) is %s, more than the %s allowed for a single variable: %s",
+					name, resource.name, resource.resourceType, formatSize(len(name)+len(value)), formatSize(MaxEnvironmentVariableSize), sizeHint(resource))
+			}
+			size += len(name) + len(value)
+		}
+		sizes = append(sizes, resourceSize{resource, size})
+		total += size
+	}
+
+	if total > MaxEnvironmentSize {
+		biggest := largest(sizes)
+		return fmt.Errorf("environment variables are %s in total, more than the %s that fits in the deployment; %s (%s) contributes %s: %s",
+			formatSize(total), formatSize(MaxEnvironmentSize), biggest.resource.name, biggest.resource.resourceType, formatSize(biggest.size), sizeHint(biggest.resource))
+	}
+
+	return nil
+}
+
+func checkSecretSize(naisResources []NaisResource) error {
+	total := 0
+	var sizes []resourceSize
+	for _, resource := range naisResources {
+		size := 0
+		for key, value := range resource.secret {
+			size += len(resource.ToResourceVariable(key)) + len(value)
+		}
+		for key, value := range resource.certificates {
+			size += len(resource.ToResourceVariable(key)) + len(value)
+		}
+		sizes = append(sizes, resourceSize{resource, size})
+		total += size
+	}
+
+	if total > MaxSecretSize {
+		biggest := largest(sizes)
+		return fmt.Errorf("secret data is %s, more than the %s Kubernetes allows in a Secret; %s (%s) contributes %s: %s",
+			formatSize(total), formatSize(MaxSecretSize), biggest.resource.name, biggest.resource.resourceType, formatSize(biggest.size), sizeHint(biggest.resource))
+	}
+
+	return nil
+}
+
+// Checks that the environment variables and Secret generated from the Fasit resources fit within Kubernetes' limits,
+// so the deployment fails with an explanation instead of when the objects are applied
+func checkObjectSizes(deploymentRequest naisrequest.Deploy, naisResources []NaisResource) error {
+	if err := checkEnvironmentSize(deploymentRequest, naisResources); err != nil {
+		return err
+	}
+
+	return checkSecretSize(naisResources)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckObjectSizes(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}
+
+	t.Run("Ordinary resources are accepted", func(t *testing.T) {
+		resources := []NaisResource{
+			{name: "db", resourceType: "DataSource", properties: map[string]string{"url": "jdbc:oracle:thin:@db:1521/X"}, secret: map[string]string{"password": "secret"}},
+		}
+
+		assert.NoError(t, checkObjectSizes(deploymentRequest, resources))
+	})
+
+	t.Run("A too large environment variable names the resource and how to fix it", func(t *testing.T) {
+		resources := []NaisResource{
+			{name: "props", resourceType: "applicationproperties", properties: map[string]string{"big": strings.Repeat("x", MaxEnvironmentVariableSize)}},
+		}
+
+		err := checkObjectSizes(deploymentRequest, resources)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "from props (applicationproperties)")
+		assert.Contains(t, err.Error(), "move applicationproperties to a ConfigMap mount")
+	})
+
+	t.Run("Too many environment variables in total gives error", func(t *testing.T) {
+		properties := map[string]string{}
+		for i := 0; i < 10; i++ {
+			properties[strings.Repeat("k", i+1)] = strings.Repeat("x", MaxEnvironmentSize/10)
+		}
+		resources := []NaisResource{
+			{name: "small", resourceType: "RestService", properties: map[string]string{"url": "https://example.com"}},
+			{name: "props", resourceType: "applicationproperties", properties: properties},
+		}
+
+		err := checkObjectSizes(deploymentRequest, resources)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "props (applicationproperties) contributes 1024.0KiB")
+	})
+
+	t.Run("Too much secret data gives error", func(t *testing.T) {
+		resources := []NaisResource{
+			{name: "cert", resourceType: "Certificate", certificates: map[string][]byte{"keystore": make([]byte, MaxSecretSize)}},
+		}
+
+		err := checkObjectSizes(deploymentRequest, resources)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "more than the 1024.0KiB Kubernetes allows in a Secret")
+		assert.Contains(t, err.Error(), "remove unused certificates from the resource")
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultPageLimit = 100
+
+// pageQuery asks for up to limit entries of a list, starting after the entry with the sequence number cursor. Entries
+// get increasing sequence numbers as they are added, so a cursor stays valid while old entries are discarded, unlike an
+// offset.
+type pageQuery struct {
+	cursor uint64
+	limit  int
+}
+
+func parsePageQuery(values url.Values, maxLimit int) (pageQuery, error) {
+	query := pageQuery{}
+
+	if cursor := values.Get("cursor"); len(cursor) > 0 {
+		var err error
+		if query.cursor, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return query, fmt.Errorf("cursor must be the sequence number of an entry, got %s", cursor)
+		}
+	}
+
+	limit, err := parseReportInt(values, "limit", defaultPageLimit)
+	if err != nil {
+		return query, err
+	}
+	if limit == 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+	query.limit = limit
+
+	return query, nil
+}
+
+// nextPageLink links to the page after the one ending with the entry with sequence number last, keeping the rest of
+// the query
+func nextPageLink(r *http.Request, last uint64, limit int) string {
+	values := r.URL.Query()
+	values.Del("offset")
+	values.Set("cursor", strconv.FormatUint(last, 10))
+	values.Set("limit", strconv.Itoa(limit))
+	return fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, values.Encode())
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+func (w gzipResponseWriter) WriteHeader(status int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// compressed gzips the responses of handler for clients that accept it
+func compressed(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		defer writer.Close()
+		handler.ServeHTTP(gzipResponseWriter{ResponseWriter: w, writer: writer}, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(encoding, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+
+		// gzip;q=0 means the client does not accept it
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
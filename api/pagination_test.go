@@ -0,0 +1,123 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePageQuery(t *testing.T) {
+	query, err := parsePageQuery(url.Values{}, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, pageQuery{cursor: 0, limit: defaultPageLimit}, query)
+
+	query, err = parsePageQuery(url.Values{"cursor": {"42"}, "limit": {"10000"}}, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, pageQuery{cursor: 42, limit: 5000}, query)
+
+	_, err = parsePageQuery(url.Values{"cursor": {"-1"}}, 5000)
+	assert.Error(t, err)
+}
+
+func TestAuditPagination(t *testing.T) {
+	api := Api{AuditLog: NewAuditLog()}
+	for i := 0; i < 5; i++ {
+		api.AuditLog.Record(AuditEntry{Event: "event", Application: "app"})
+	}
+
+	get := func(path string) ([]AuditEntry, *httptest.ResponseRecorder) {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		var entries []AuditEntry
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+		return entries, rr
+	}
+
+	entries, rr := get("/audit?limit=2")
+	assert.Equal(t, "5", rr.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</audit?cursor=2&limit=2>; rel="next"`, rr.Header().Get("Link"))
+	assert.Equal(t, []uint64{1, 2}, []uint64{entries[0].Sequence, entries[1].Sequence})
+
+	entries, rr = get("/audit?cursor=4&limit=2")
+	assert.Empty(t, rr.Header().Get("Link"))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, uint64(5), entries[0].Sequence)
+
+	t.Run("cursors stay valid when old entries are discarded", func(t *testing.T) {
+		auditLog := NewAuditLog()
+		for i := 0; i < maxAuditEntries+10; i++ {
+			auditLog.Record(AuditEntry{Event: "event"})
+		}
+
+		entries, total, more := auditLog.Page(pageQuery{cursor: maxAuditEntries, limit: 100})
+		assert.Equal(t, maxAuditEntries, total)
+		assert.False(t, more)
+		assert.Len(t, entries, 10)
+		assert.Equal(t, uint64(maxAuditEntries+1), entries[0].Sequence)
+	})
+
+	t.Run("invalid cursors are rejected", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/audit?cursor=next", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestCompressed(t *testing.T) {
+	api := Api{AuditLog: NewAuditLog()}
+	api.AuditLog.Record(AuditEntry{Event: "event", Application: "app"})
+
+	t.Run("responses are gzipped when accepted", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/audit", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+
+		reader, err := gzip.NewReader(rr.Body)
+		assert.NoError(t, err)
+		body, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+
+		var entries []AuditEntry
+		assert.NoError(t, json.Unmarshal(body, &entries))
+		assert.Equal(t, "app", entries[0].Application)
+	})
+
+	t.Run("responses are not gzipped otherwise", func(t *testing.T) {
+		for _, acceptEncoding := range []string{"", "deflate", "gzip;q=0"} {
+			req, _ := http.NewRequest("GET", "/audit", nil)
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+			rr := httptest.NewRecorder()
+			api.Handler().ServeHTTP(rr, req)
+
+			assert.Empty(t, rr.Header().Get("Content-Encoding"), acceptEncoding)
+			assert.Contains(t, rr.Body.String(), `"application":"app"`)
+		}
+	})
+
+	t.Run("errors are gzipped too", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/audit?limit=x", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0.5")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		reader, err := gzip.NewReader(rr.Body)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(reader)
+		assert.Contains(t, string(body), "invalid audit query")
+	})
+}
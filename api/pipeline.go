@@ -0,0 +1,537 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+)
+
+const (
+	StagePending          = "pending"
+	StageAwaitingApproval = "awaiting_approval"
+	StageDeploying        = "deploying"
+	StageVerifying        = "verifying"
+	StageSkipped          = "skipped"
+
+	maxFinishedPipelines = 100
+)
+
+// PipelineStage is the progress of a pipeline in one environment
+type PipelineStage struct {
+	Environment  string `json:"environment"`
+	Namespace    string `json:"namespace"`
+	Status       string `json:"status"`
+	DeploymentId string `json:"deploymentId,omitempty"`
+	ApprovedBy   string `json:"approvedBy,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// PipelineRun is a pipeline naisd is running or has recently finished
+type PipelineRun struct {
+	Id          string          `json:"id"`
+	Application string          `json:"application"`
+	Version     string          `json:"version"`
+	Team        string          `json:"team,omitempty"`
+	Started     time.Time       `json:"started"`
+	Status      string          `json:"status"`
+	Stages      []PipelineStage `json:"stages"`
+}
+
+type pipelineRun struct {
+	PipelineRun
+	request  naisrequest.Pipeline
+	current  int
+	approved chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// the Authorization header of the request that started the pipeline, which its stages are deployed with
+	authorization string
+}
+
+// Pipelines keeps the pipelines in progress and the most recently finished ones. Stages are deployed one at a time,
+// each through the same deployment as POST /deploy.
+type Pipelines struct {
+	mutex     sync.Mutex
+	pipelines map[string]*pipelineRun
+	finished  []string
+}
+
+func NewPipelines() *Pipelines {
+	return &Pipelines{pipelines: make(map[string]*pipelineRun)}
+}
+
+func (p *Pipelines) start(request naisrequest.Pipeline) *pipelineRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &pipelineRun{
+		PipelineRun: PipelineRun{
+			Id:          newDeploymentId(),
+			Application: request.Application,
+			Version:     request.Version,
+			Started:     time.Now(),
+			Status:      DeploymentInProgress,
+		},
+		request:  request,
+		approved: make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for i, stage := range request.Stages {
+		run.Stages = append(run.Stages, PipelineStage{
+			Environment: stage.Environment,
+			Namespace:   request.StageRequest(i).Namespace,
+			Status:      StagePending,
+		})
+	}
+
+	if p == nil {
+		return run
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pipelines[run.Id] = run
+	return run
+}
+
+func (p *Pipelines) Get(id string) (PipelineRun, bool) {
+	if p == nil {
+		return PipelineRun{}, false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	run, ok := p.pipelines[id]
+	if !ok {
+		return PipelineRun{}, false
+	}
+	return run.snapshot(), true
+}
+
+func (run *pipelineRun) snapshot() PipelineRun {
+	snapshot := run.PipelineRun
+	snapshot.Stages = make([]PipelineStage, len(run.Stages))
+	copy(snapshot.Stages, run.Stages)
+	return snapshot
+}
+
+func (p *Pipelines) update(run *pipelineRun, update func(run *pipelineRun)) {
+	if p != nil {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+	}
+	update(run)
+}
+
+func (p *Pipelines) setStage(run *pipelineRun, i int, status string) {
+	p.update(run, func(run *pipelineRun) {
+		run.current = i
+		run.Stages[i].Status = status
+	})
+}
+
+// finish records the outcome of the pipeline. Stages that were never reached are skipped.
+func (p *Pipelines) finish(run *pipelineRun, status, message string) {
+	run.cancel()
+
+	p.update(run, func(run *pipelineRun) {
+		run.Status = status
+		if status != DeploymentSucceeded {
+			run.Stages[run.current].Status = status
+			run.Stages[run.current].Message = message
+		}
+		for i := run.current + 1; i < len(run.Stages); i++ {
+			run.Stages[i].Status = StageSkipped
+		}
+	})
+
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.finished = append(p.finished, run.Id)
+	if len(p.finished) > maxFinishedPipelines {
+		delete(p.pipelines, p.finished[0])
+		p.finished = p.finished[1:]
+	}
+}
+
+// Approve lets the pipeline deploy the stage that is waiting for manual approval, recording who approved it
+func (p *Pipelines) Approve(id, approvedBy string) (PipelineRun, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	run, ok := p.pipelines[id]
+	if !ok {
+		return PipelineRun{}, fmt.Errorf("pipeline %s not found", id)
+	}
+	if run.Status != DeploymentInProgress || run.Stages[run.current].Status != StageAwaitingApproval {
+		return run.snapshot(), fmt.Errorf("pipeline %s has no stage waiting for approval", id)
+	}
+
+	run.Stages[run.current].ApprovedBy = approvedBy
+	select {
+	case run.approved <- struct{}{}:
+	default:
+	}
+	return run.snapshot(), nil
+}
+
+// Cancel stops the pipeline before its next stage. A stage being deployed is left to finish.
+func (p *Pipelines) Cancel(id string) (PipelineRun, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	run, ok := p.pipelines[id]
+	if !ok {
+		return PipelineRun{}, fmt.Errorf("pipeline %s not found", id)
+	}
+	if run.Status != DeploymentInProgress {
+		return run.snapshot(), fmt.Errorf("pipeline %s is %s", id, run.Status)
+	}
+
+	run.cancel()
+	return run.snapshot(), nil
+}
+
+// Runs returns the pipelines naisd keeps, in the order they were started
+func (p *Pipelines) Runs() []PipelineRun {
+	runs := []PipelineRun{}
+	if p == nil {
+		return runs
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, run := range p.pipelines {
+		runs = append(runs, run.snapshot())
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.Before(runs[j].Started) })
+	return runs
+}
+
+// inProgress counts the pipelines that are still deploying or waiting for approval
+func (p *Pipelines) inProgress() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	count := 0
+	for _, run := range p.pipelines {
+		if run.Status == DeploymentInProgress {
+			count++
+		}
+	}
+	return count
+}
+
+// Replace replaces the pipelines with imported ones. Pipelines that were in progress were run by another naisd, and
+// can not go on here, so they are cancelled at the stage they had reached.
+func (p *Pipelines) Replace(runs []PipelineRun) {
+	if p == nil {
+		return
+	}
+
+	pipelines := make(map[string]*pipelineRun)
+	var finished []string
+	for _, imported := range runs {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		run := &pipelineRun{PipelineRun: imported, approved: make(chan struct{}, 1), ctx: ctx, cancel: cancel}
+		run.Stages = make([]PipelineStage, len(imported.Stages))
+		copy(run.Stages, imported.Stages)
+
+		if run.Status == DeploymentInProgress {
+			run.Status = DeploymentCancelled
+			for i := range run.Stages {
+				switch run.Stages[i].Status {
+				case StagePending:
+					run.Stages[i].Status = StageSkipped
+				case StageAwaitingApproval, StageDeploying, StageVerifying:
+					run.Stages[i].Status = DeploymentCancelled
+					run.Stages[i].Message = interruptedByStateImport
+				}
+			}
+		}
+
+		pipelines[run.Id] = run
+		finished = append(finished, run.Id)
+	}
+	if len(finished) > maxFinishedPipelines {
+		for _, id := range finished[:len(finished)-maxFinishedPipelines] {
+			delete(pipelines, id)
+		}
+		finished = finished[len(finished)-maxFinishedPipelines:]
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pipelines = pipelines
+	p.finished = finished
+}
+
+// Collects the response of a stage deployment
+type stageResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *stageResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *stageResponse) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+func (r *stageResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// Deploys a stage as if it had been posted to /deploy with the authorization of the pipeline, returning the id of the
+// deployment. Cancelling ctx cancels the deployment.
+func (api Api) deployStage(ctx context.Context, deploymentRequest naisrequest.Deploy, authorization string) (string, error) {
+	body, err := json.Marshal(deploymentRequest)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal deployment request: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", "/deploy", ioutil.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		return "", fmt.Errorf("unable to create deployment request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	response := &stageResponse{header: make(http.Header)}
+	appHandler(api.deploy).ServeHTTP(response, req)
+
+	id := response.header.Get("X-Deployment-Id")
+	if response.status != 0 && response.status != http.StatusOK {
+		return id, fmt.Errorf("deployment failed with status %d: %s", response.status, strings.TrimSpace(response.body.String()))
+	}
+	return id, nil
+}
+
+// Watches a stage that has rolled out for the verification window, failing it if the deployment fails in the meantime
+func (api Api) verifyStage(ctx context.Context, deploymentRequest naisrequest.Deploy, window time.Duration) error {
+	verified := time.After(window)
+	for {
+		status, view, err := api.DeploymentStatusViewer.DeploymentStatusView(deploymentRequest.Namespace, deploymentRequest.Application)
+		if err == nil && status == Failed {
+			return fmt.Errorf("deployment failed during verification: %s", view.Reason)
+		}
+
+		select {
+		case <-verified:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+// runPipeline deploys the stages in order, stopping at the first one that fails
+func (api Api) runPipeline(run *pipelineRun) {
+	for i, stage := range run.request.Stages {
+		if stage.ManualApproval {
+			api.Pipelines.setStage(run, i, StageAwaitingApproval)
+			select {
+			case <-run.approved:
+			case <-run.ctx.Done():
+			}
+		}
+		if run.ctx.Err() != nil {
+			api.Pipelines.setStage(run, i, StagePending)
+			api.Pipelines.finish(run, DeploymentCancelled, "pipeline was cancelled")
+			return
+		}
+
+		api.Pipelines.setStage(run, i, StageDeploying)
+		deploymentRequest := run.request.StageRequest(i)
+		deploymentId, err := api.deployStage(run.ctx, deploymentRequest, run.authorization)
+		api.Pipelines.update(run, func(run *pipelineRun) { run.Stages[i].DeploymentId = deploymentId })
+		if err != nil {
+			api.Pipelines.finish(run, DeploymentFailed, err.Error())
+			return
+		}
+		if team, err := api.applicationTeam(deploymentRequest.Namespace, run.Application); err == nil && len(team) > 0 {
+			api.Pipelines.update(run, func(run *pipelineRun) { run.Team = team })
+		}
+
+		if verify, _ := time.ParseDuration(stage.Verify); verify > 0 {
+			api.Pipelines.setStage(run, i, StageVerifying)
+			if err := api.verifyStage(run.ctx, deploymentRequest, verify); err != nil {
+				if run.ctx.Err() != nil {
+					api.Pipelines.finish(run, DeploymentCancelled, "pipeline was cancelled during verification")
+				} else {
+					api.Pipelines.finish(run, DeploymentFailed, err.Error())
+				}
+				return
+			}
+		}
+
+		api.Pipelines.setStage(run, i, DeploymentSucceeded)
+	}
+
+	api.Pipelines.finish(run, DeploymentSucceeded, "")
+}
+
+func (api Api) startPipeline(w http.ResponseWriter, r *http.Request) *appError {
+	var request naisrequest.Pipeline
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return &appError{err, "unable to unmarshal pipeline", http.StatusBadRequest}
+	}
+
+	if errs := request.Validate(); len(errs) > 0 {
+		var messages []string
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return &appError{fmt.Errorf("%s", strings.Join(messages, ", ")), "invalid pipeline", http.StatusBadRequest}
+	}
+
+	run := api.Pipelines.start(request)
+	run.authorization = r.Header.Get("Authorization")
+	glog.Infof("Starting pipeline %s, deploying %s:%s to %d stages", run.Id, request.Application, request.Version, len(request.Stages))
+	go api.runPipeline(run)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(api.Pipelines.snapshot(run)); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (p *Pipelines) snapshot(run *pipelineRun) PipelineRun {
+	if p != nil {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+	}
+	return run.snapshot()
+}
+
+func (api Api) getPipeline(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	run, ok := api.Pipelines.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("pipeline %s not found", id), "pipeline not found", http.StatusNotFound}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+// authorizePipeline authenticates the request as the operator or a member of the team of the pipeline's application.
+// The team is looked up on every request from the application where the stage waiting for approval deploys it, so
+// a stage can not be approved on behalf of another team owning the application in that namespace. Without a stage
+// waiting for approval, or before the application exists there, the team of the deployed stages is used.
+func (api Api) authorizePipeline(r *http.Request, run PipelineRun) (Identity, *appError) {
+	team := run.Team
+	for _, stage := range run.Stages {
+		if stage.Status != StageAwaitingApproval {
+			continue
+		}
+		owner, err := api.applicationTeam(stage.Namespace, run.Application)
+		if err != nil {
+			return Identity{}, &appError{err, "unable to get team of application", http.StatusInternalServerError}
+		}
+		if len(owner) > 0 {
+			team = owner
+		}
+	}
+	return api.authorizeTeam(r, team)
+}
+
+func (api Api) approvePipeline(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	existing, ok := api.Pipelines.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("pipeline %s not found", id), "pipeline not found", http.StatusNotFound}
+	}
+	identity, appErr := api.authorizePipeline(r, existing)
+	if appErr != nil {
+		return appErr
+	}
+
+	run, err := api.Pipelines.Approve(id, identity.Name)
+	if err != nil {
+		return &appError{err, "unable to approve pipeline", http.StatusConflict}
+	}
+
+	stage := run.Stages[0]
+	for _, s := range run.Stages {
+		if s.Status == StageAwaitingApproval {
+			stage = s
+		}
+	}
+	api.AuditLog.Record(AuditEntry{
+		Event:       "pipeline_approved",
+		Application: run.Application,
+		Namespace:   stage.Namespace,
+		Version:     run.Version,
+		Details:     map[string]string{"id": run.Id, "environment": stage.Environment, "approvedBy": identity.Name},
+	})
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (api Api) cancelPipeline(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	existing, ok := api.Pipelines.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("pipeline %s not found", id), "pipeline not found", http.StatusNotFound}
+	}
+	identity, appErr := api.authorizePipeline(r, existing)
+	if appErr != nil {
+		return appErr
+	}
+
+	run, err := api.Pipelines.Cancel(id)
+	if err != nil {
+		return &appError{err, "unable to cancel pipeline", http.StatusConflict}
+	}
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "pipeline_cancelled",
+		Application: run.Application,
+		Version:     run.Version,
+		Details:     map[string]string{"id": run.Id, "cancelledBy": identity.Name},
+	})
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPipeline() naisrequest.Pipeline {
+	return naisrequest.Pipeline{
+		Deploy: naisrequest.Deploy{Application: "app", Version: "1.2.3", Namespace: "default", Zone: "fss"},
+		Stages: []naisrequest.Stage{
+			{Environment: "t1"},
+			{Environment: "q1", Verify: "15m"},
+			{Environment: "p", Namespace: "prod", ManualApproval: true},
+		},
+	}
+}
+
+func TestPipelineValidate(t *testing.T) {
+	assert.Empty(t, testPipeline().Validate())
+	assert.Len(t, naisrequest.Pipeline{Deploy: naisrequest.Deploy{Application: "app", Version: "1"}}.Validate(), 1)
+
+	pipeline := testPipeline()
+	pipeline.Version = ""
+	pipeline.Stages[0].Environment = ""
+	pipeline.Stages[1].Verify = "soon"
+	assert.Len(t, pipeline.Validate(), 3)
+
+	pipeline = testPipeline()
+	pipeline.Preview = &naisrequest.Preview{}
+	assert.Len(t, pipeline.Validate(), 1)
+
+	pipeline = testPipeline()
+	pipeline.DarkLaunch = &naisrequest.DarkLaunch{}
+	assert.Len(t, pipeline.Validate(), 1)
+}
+
+func TestPipelineStageRequest(t *testing.T) {
+	pipeline := testPipeline()
+
+	first := pipeline.StageRequest(0)
+	assert.Equal(t, "t1", first.FasitEnvironment)
+	assert.Equal(t, "default", first.Namespace)
+	assert.True(t, first.WaitForRollout)
+
+	last := pipeline.StageRequest(2)
+	assert.Equal(t, "p", last.FasitEnvironment)
+	assert.Equal(t, "prod", last.Namespace)
+	assert.Equal(t, "1.2.3", last.Version)
+}
+
+func TestPipelines(t *testing.T) {
+	t.Run("Stages that are never reached are skipped", func(t *testing.T) {
+		pipelines := NewPipelines()
+		run := pipelines.start(testPipeline())
+		pipelines.setStage(run, 1, StageDeploying)
+		pipelines.finish(run, DeploymentFailed, "rollout failed")
+
+		pipeline, ok := pipelines.Get(run.Id)
+		assert.True(t, ok)
+		assert.Equal(t, DeploymentFailed, pipeline.Status)
+		assert.Equal(t, PipelineStage{Environment: "q1", Namespace: "default", Status: DeploymentFailed, Message: "rollout failed"}, pipeline.Stages[1])
+		assert.Equal(t, StageSkipped, pipeline.Stages[2].Status)
+	})
+
+	t.Run("Only stages awaiting approval can be approved", func(t *testing.T) {
+		pipelines := NewPipelines()
+		run := pipelines.start(testPipeline())
+
+		_, err := pipelines.Approve(run.Id, "alice")
+		assert.EqualError(t, err, "pipeline "+run.Id+" has no stage waiting for approval")
+
+		pipelines.setStage(run, 2, StageAwaitingApproval)
+		approved, err := pipelines.Approve(run.Id, "alice")
+		assert.NoError(t, err)
+		assert.Len(t, run.approved, 1)
+		assert.Equal(t, "alice", approved.Stages[2].ApprovedBy)
+
+		_, err = pipelines.Approve("missing", "alice")
+		assert.Error(t, err)
+	})
+
+	t.Run("A cancelled pipeline stops while waiting for approval", func(t *testing.T) {
+		api := Api{Pipelines: NewPipelines()}
+		request := testPipeline()
+		request.Stages = request.Stages[2:]
+		run := api.Pipelines.start(request)
+
+		done := make(chan struct{})
+		go func() {
+			api.runPipeline(run)
+			close(done)
+		}()
+
+		for {
+			if pipeline, _ := api.Pipelines.Get(run.Id); pipeline.Stages[0].Status == StageAwaitingApproval {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		_, err := api.Pipelines.Cancel(run.Id)
+		assert.NoError(t, err)
+		<-done
+
+		pipeline, _ := api.Pipelines.Get(run.Id)
+		assert.Equal(t, DeploymentCancelled, pipeline.Status)
+		assert.Equal(t, DeploymentCancelled, pipeline.Stages[0].Status)
+
+		_, err = api.Pipelines.Cancel(run.Id)
+		assert.Error(t, err)
+	})
+}
+
+func TestVerifyStage(t *testing.T) {
+	request := naisrequest.Deploy{Application: "app", Namespace: "default"}
+
+	api := Api{DeploymentStatusViewer: applicationStatusViewer{"app": Success}}
+	assert.NoError(t, api.verifyStage(context.Background(), request, 10*time.Millisecond))
+
+	api = Api{DeploymentStatusViewer: applicationStatusViewer{"app": Failed}}
+	assert.EqualError(t, api.verifyStage(context.Background(), request, time.Minute), "deployment failed during verification: ProgressDeadlineExceeded")
+}
+
+func TestPipelineHandlers(t *testing.T) {
+	api := Api{
+		Pipelines:     NewPipelines(),
+		Clientset:     fake.NewSimpleClientset(),
+		OperatorToken: "operator",
+		AuditLog:      NewAuditLog(),
+		Identities: []Identity{
+			{Name: "alice", Teams: []string{"aura"}, Token: "alice-token"},
+			{Name: "bob", Teams: []string{"other"}, Token: "bob-token"},
+		},
+	}
+
+	t.Run("Invalid pipelines are rejected", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/pipeline", strings.NewReader(`{"application": "app", "version": "1"}`)))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "a pipeline must have at least one stage")
+	})
+
+	t.Run("Pipelines are listed by id", func(t *testing.T) {
+		run := api.Pipelines.start(testPipeline())
+
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/pipeline/"+run.Id, nil))
+
+		var pipeline PipelineRun
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pipeline))
+		assert.Equal(t, "app", pipeline.Application)
+		assert.Len(t, pipeline.Stages, 3)
+
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/pipeline/missing", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	approve := func(id, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, requestWithMethod("POST", "/pipeline/"+id+"/approve", token))
+		return rr
+	}
+
+	t.Run("Pipelines without a stage awaiting approval can not be approved", func(t *testing.T) {
+		run := api.Pipelines.start(testPipeline())
+
+		assert.Equal(t, http.StatusConflict, approve(run.Id, "operator").Code)
+	})
+
+	t.Run("Only the operator and the team of the application can approve or cancel", func(t *testing.T) {
+		run := api.Pipelines.start(testPipeline())
+		api.Pipelines.setStage(run, 1, StageAwaitingApproval)
+		api.Pipelines.update(run, func(run *pipelineRun) { run.Team = "aura" })
+
+		assert.Equal(t, http.StatusUnauthorized, approve(run.Id, "").Code)
+		assert.Equal(t, http.StatusForbidden, approve(run.Id, "bob-token").Code)
+		assert.Equal(t, http.StatusOK, approve(run.Id, "alice-token").Code)
+
+		pipeline, _ := api.Pipelines.Get(run.Id)
+		assert.Equal(t, "alice", pipeline.Stages[1].ApprovedBy)
+		assert.Equal(t, "alice", api.AuditLog.Entries()[0].Details["approvedBy"])
+
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, requestWithMethod("DELETE", "/pipeline/"+run.Id, "bob-token"))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, requestWithMethod("DELETE", "/pipeline/"+run.Id, "alice-token"))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Approval is authorized against the team owning the application in the stage awaiting approval", func(t *testing.T) {
+		run := api.Pipelines.start(testPipeline())
+		api.Pipelines.setStage(run, 1, StageAwaitingApproval)
+		api.Pipelines.update(run, func(run *pipelineRun) { run.Team = "aura" })
+
+		deployment := &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app", Namespace: run.Stages[1].Namespace, Labels: map[string]string{"team": "other"}}}
+		_, err := api.Clientset.ExtensionsV1beta1().Deployments(deployment.Namespace).Create(deployment)
+		assert.NoError(t, err)
+		defer api.Clientset.ExtensionsV1beta1().Deployments(deployment.Namespace).Delete("app", &k8smeta.DeleteOptions{})
+
+		assert.Equal(t, http.StatusForbidden, approve(run.Id, "alice-token").Code)
+		assert.Equal(t, http.StatusOK, approve(run.Id, "bob-token").Code)
+	})
+}
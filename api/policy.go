@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DefaultPolicyKey = "default"
+
+	// EnvironmentClassLabel on a namespace is the environment class of the applications deployed to it, for namespaces
+	// of another class than the cluster
+	EnvironmentClassLabel = "nais.io/environment-class"
+)
+
+// DeploymentPolicy is what the manifest of an application must declare to be deployed to an environment class. The
+// defaults naisd fills in do not count, e.g. a readiness probe at the default path is not a readiness probe the team
+// has made.
+type DeploymentPolicy struct {
+	MinReplicas    int  `yaml:"minReplicas"`
+	ReadinessProbe bool `yaml:"readinessProbe"`
+	LivenessProbe  bool `yaml:"livenessProbe"`
+	ResourceLimits bool `yaml:"resourceLimits"`
+}
+
+// Policies are keyed by environment class, falling back to the "default" key
+type Policies map[string]DeploymentPolicy
+
+// forEnvironmentClass returns the policy of the environment class, and the key it was found by
+func (p Policies) forEnvironmentClass(environmentClass string) (DeploymentPolicy, string, bool) {
+	if policy, ok := p[environmentClass]; ok {
+		return policy, environmentClass, true
+	}
+	policy, ok := p[DefaultPolicyKey]
+	return policy, DefaultPolicyKey, ok
+}
+
+// PolicyViolation lists everything a manifest lacks to be deployed under a policy
+type PolicyViolation struct {
+	Policy     string
+	Violations []string
+}
+
+func (v PolicyViolation) Error() string {
+	return fmt.Sprintf("the manifest violates deployment policy %s: %s", v.Policy, strings.Join(v.Violations, "; "))
+}
+
+// checkDeploymentPolicy checks the replicas of the manifest naisd will deploy, and the probes and limits of the manifest
+// as the team declared it. Returns nil if the manifest follows the policy.
+func checkDeploymentPolicy(policy DeploymentPolicy, manifest, declared NaisManifest) []string {
+	var violations []string
+
+	if policy.MinReplicas > 0 && manifest.Replicas.Min < policy.MinReplicas {
+		violations = append(violations, fmt.Sprintf("replicas.min is %d, must be at least %d", manifest.Replicas.Min, policy.MinReplicas))
+	}
+	if policy.ReadinessProbe && !declared.Healthcheck.Readiness.declared() {
+		violations = append(violations, "healthcheck.readiness.path must be set")
+	}
+	if policy.LivenessProbe && !declared.Healthcheck.Liveness.declared() {
+		violations = append(violations, "healthcheck.liveness.path must be set")
+	}
+	if policy.ResourceLimits {
+		if len(declared.Resources.Limits.Cpu) == 0 {
+			violations = append(violations, "resources.limits.cpu must be set")
+		}
+		if len(declared.Resources.Limits.Memory) == 0 {
+			violations = append(violations, "resources.limits.memory must be set")
+		}
+	}
+
+	return violations
+}
+
+// policyEnvironmentClass is the environment class whose policy a deployment to the namespace follows: the class the
+// namespace is labelled with, else the class of the cluster. Both are set by operators, whereas the Fasit environment is
+// chosen by the deployment request, so the class of the Fasit environment is only used if neither is set.
+func (api Api) policyEnvironmentClass(namespace, fasitEnvironmentClass string) (string, error) {
+	if len(api.Policies) == 0 {
+		return "", nil
+	}
+
+	ns, err := api.Clientset.CoreV1().Namespaces().Get(namespace, k8smeta.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get namespace %s: %s", namespace, err)
+	}
+	if err == nil && len(ns.Labels[EnvironmentClassLabel]) > 0 {
+		return ns.Labels[EnvironmentClassLabel], nil
+	}
+
+	if len(api.EnvironmentClass) > 0 {
+		return api.EnvironmentClass, nil
+	}
+	return fasitEnvironmentClass, nil
+}
+
+// enforceDeploymentPolicy rejects deployments whose manifest violates the policy of the environment class, unless an
+// operator overrides it in an emergency. Overrides are audited along with what they let through.
+func (api Api) enforceDeploymentPolicy(r *http.Request, deploymentRequest naisrequest.Deploy, manifest, declared NaisManifest, environmentClass string) *appError {
+	policy, key, ok := api.Policies.forEnvironmentClass(environmentClass)
+	if !ok {
+		return nil
+	}
+
+	violations := checkDeploymentPolicy(policy, manifest, declared)
+	if len(violations) == 0 {
+		return nil
+	}
+	violation := PolicyViolation{Policy: key, Violations: violations}
+
+	if len(deploymentRequest.PolicyOverride) == 0 {
+		return &appError{violation, "deployment policy violated", http.StatusBadRequest}
+	}
+	if !api.isOperator(r) {
+		return &appError{fmt.Errorf("only operators can override the deployment policy"), "not authorized", http.StatusForbidden}
+	}
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "policy_overridden",
+		Application: deploymentRequest.Application,
+		Namespace:   deploymentRequest.Namespace,
+		Version:     deploymentRequest.Version,
+		Details:     map[string]string{"policy": key, "reason": deploymentRequest.PolicyOverride, "violations": strings.Join(violations, "; ")},
+	})
+	return nil
+}
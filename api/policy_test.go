@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckDeploymentPolicy(t *testing.T) {
+	policy := DeploymentPolicy{MinReplicas: 2, ReadinessProbe: true, LivenessProbe: true, ResourceLimits: true}
+
+	t.Run("Defaults filled in by naisd do not count", func(t *testing.T) {
+		declared := NaisManifest{Replicas: Replicas{Min: 1}}
+		manifest := declared
+		assert.NoError(t, AddDefaultManifestValues(&manifest, appName))
+
+		assert.Equal(t, []string{
+			"replicas.min is 1, must be at least 2",
+			"healthcheck.readiness.path must be set",
+			"healthcheck.liveness.path must be set",
+			"resources.limits.cpu must be set",
+			"resources.limits.memory must be set",
+		}, checkDeploymentPolicy(policy, manifest, declared))
+	})
+
+	t.Run("Manifests declaring what the policy requires pass", func(t *testing.T) {
+		declared := NaisManifest{
+			Healthcheck: Healthcheck{Liveness: Probe{Path: "internal/alive"}, Readiness: Probe{Path: "internal/ready"}},
+			Resources:   ResourceRequirements{Limits: ResourceList{Cpu: "1", Memory: "1Gi"}},
+		}
+		manifest := declared
+		assert.NoError(t, AddDefaultManifestValues(&manifest, appName))
+
+		assert.Empty(t, checkDeploymentPolicy(policy, manifest, declared))
+		assert.Empty(t, checkDeploymentPolicy(DeploymentPolicy{}, NaisManifest{}, NaisManifest{}), "an empty policy requires nothing")
+	})
+}
+
+func TestPoliciesForEnvironmentClass(t *testing.T) {
+	policies := Policies{"p": {MinReplicas: 2}, DefaultPolicyKey: {ReadinessProbe: true}}
+
+	policy, key, ok := policies.forEnvironmentClass("p")
+	assert.True(t, ok)
+	assert.Equal(t, "p", key)
+	assert.Equal(t, 2, policy.MinReplicas)
+
+	policy, key, ok = policies.forEnvironmentClass("q")
+	assert.True(t, ok)
+	assert.Equal(t, DefaultPolicyKey, key)
+	assert.True(t, policy.ReadinessProbe)
+
+	_, _, ok = Policies{"p": {}}.forEnvironmentClass("")
+	assert.False(t, ok)
+}
+
+func TestEnforceDeploymentPolicy(t *testing.T) {
+	api := Api{Policies: Policies{"p": {MinReplicas: 2}}, AuditLog: NewAuditLog(), OperatorToken: "secret"}
+	manifest := NaisManifest{Replicas: Replicas{Min: 1}}
+	deploymentRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version}
+
+	request := func(token string) *http.Request {
+		r, _ := http.NewRequest("POST", "/deploy", nil)
+		if len(token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	assert.Nil(t, api.enforceDeploymentPolicy(request(""), deploymentRequest, manifest, manifest, "t"), "environment classes without a policy are not checked")
+
+	appErr := api.enforceDeploymentPolicy(request(""), deploymentRequest, manifest, manifest, "p")
+	assert.Equal(t, http.StatusBadRequest, appErr.StatusCode)
+	assert.Contains(t, appErr.Error(), "the manifest violates deployment policy p: replicas.min is 1, must be at least 2")
+
+	deploymentRequest.PolicyOverride = "incident 42, scaling down to save the database"
+	appErr = api.enforceDeploymentPolicy(request("wrong"), deploymentRequest, manifest, manifest, "p")
+	assert.Equal(t, http.StatusForbidden, appErr.StatusCode, "only operators can override the policy")
+	assert.Empty(t, api.AuditLog.Entries())
+
+	assert.Nil(t, api.enforceDeploymentPolicy(request("secret"), deploymentRequest, manifest, manifest, "p"))
+	entries := api.AuditLog.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "policy_overridden", entries[0].Event)
+	assert.Equal(t, "incident 42, scaling down to save the database", entries[0].Details["reason"])
+	assert.Equal(t, "replicas.min is 1, must be at least 2", entries[0].Details["violations"])
+}
+
+func TestPolicyEnvironmentClass(t *testing.T) {
+	labelled := &k8score.Namespace{ObjectMeta: k8smeta.ObjectMeta{Name: "prod", Labels: map[string]string{EnvironmentClassLabel: "p"}}}
+	api := Api{Clientset: fake.NewSimpleClientset(labelled), Policies: Policies{"p": {MinReplicas: 2}}}
+
+	class, err := api.policyEnvironmentClass("prod", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, "p", class, "the class of the namespace is used, whatever Fasit environment the request names")
+
+	class, err = api.policyEnvironmentClass("default", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, "t", class, "the Fasit environment class is used if neither the namespace nor the cluster has one")
+
+	class, err = api.policyEnvironmentClass("default", "")
+	assert.NoError(t, err)
+	assert.Empty(t, class)
+
+	api.EnvironmentClass = "q"
+	class, err = api.policyEnvironmentClass("default", "t")
+	assert.NoError(t, err)
+	assert.Equal(t, "q", class, "the class of the cluster is used for namespaces without one, also when Fasit is skipped")
+}
+
+func TestGenerateManifestReturnsTheDeclaredManifest(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://repo.com").
+		Get("/app").
+		Reply(200).
+		BodyString("image: docker.io/app\nreplicas:\n  min: 3\n")
+
+	manifest, declared, err := generateManifest(naisrequest.Deploy{Application: appName, ManifestUrl: "http://repo.com/app"}, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "isReady", manifest.Healthcheck.Readiness.Path)
+	assert.Empty(t, declared.Healthcheck.Readiness.Path)
+	assert.Equal(t, 3, declared.Replicas.Min)
+}
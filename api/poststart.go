@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A container is not started until its postStart hook is done, so a hook may not hold it for longer than this
+const maxPostStartHookTimeout = 10 * time.Minute
+
+// PostStartHook runs right after the container is started, e.g. to register the application with a legacy system.
+// Either Exec, a command run in the container, or Path, a GET on the application's port, is set. A hook that fails
+// kills the container. Kubernetes has no timeout for hooks, so Timeout is enforced by naisd, which waits for the rollout
+// and fails the deployment if a hook holds a new pod for longer.
+type PostStartHook struct {
+	Exec    []string
+	Path    string
+	Timeout string
+}
+
+func (hook PostStartHook) handler() *k8score.Handler {
+	if len(hook.Path) > 0 {
+		return &k8score.Handler{
+			HTTPGet: &k8score.HTTPGetAction{
+				Path: hook.Path,
+				Port: intstr.FromString(DefaultPortName),
+			},
+		}
+	}
+
+	if len(hook.Exec) > 0 {
+		return &k8score.Handler{Exec: &k8score.ExecAction{Command: hook.Exec}}
+	}
+
+	return nil
+}
+
+func validatePostStartHook(manifest NaisManifest) *ValidationError {
+	hook := manifest.PostStartHook
+	fields := map[string]string{"PostStartHook.Exec": strings.Join(hook.Exec, " "), "PostStartHook.Path": hook.Path}
+
+	if len(hook.Exec) > 0 && len(hook.Path) > 0 {
+		return &ValidationError{"PostStartHook must have either exec or path, not both", fields}
+	}
+
+	if len(hook.Timeout) == 0 {
+		return nil
+	}
+	if len(hook.Exec) == 0 && len(hook.Path) == 0 {
+		return &ValidationError{"PostStartHook timeout can only be set with exec or path", map[string]string{"PostStartHook.Timeout": hook.Timeout}}
+	}
+
+	timeout, err := time.ParseDuration(hook.Timeout)
+	if err != nil || timeout <= 0 || timeout > maxPostStartHookTimeout {
+		return &ValidationError{
+			fmt.Sprintf("PostStartHook timeout must be a positive duration of at most %s, e.g. 30s", maxPostStartHookTimeout),
+			map[string]string{"PostStartHook.Timeout": hook.Timeout},
+		}
+	}
+	if maxDeployDuration, err := time.ParseDuration(manifest.MaxDeployDuration); err == nil && timeout >= maxDeployDuration {
+		return &ValidationError{
+			"PostStartHook timeout must be shorter than MaxDeployDuration, or the deployment times out before the hook does",
+			map[string]string{"PostStartHook.Timeout": hook.Timeout, "MaxDeployDuration": manifest.MaxDeployDuration},
+		}
+	}
+
+	return nil
+}
+
+// heldByPostStartHook returns a pod of the application whose container is still being created. The kubelet does not
+// report the container as running until its postStart hook is done, so such a pod may be held by its hook, or still
+// be pulling the image.
+func heldByPostStartHook(namespace, application string, k8sClient kubernetes.Interface) (string, error) {
+	pods, err := k8sClient.CoreV1().Pods(namespace).List(k8smeta.ListOptions{LabelSelector: "app=" + application})
+	if err != nil {
+		return "", fmt.Errorf("unable to list pods: %s", err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == application && status.State.Waiting != nil && status.State.Waiting.Reason == "ContainerCreating" {
+				return pod.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// waitForPostStartHooks fails the deployment if a new pod is still held by its postStart hook when the hook's timeout
+// has passed since the deployment was applied, which includes pulling the image. The hook keeps running, as Kubernetes
+// can not stop it, but the deployment does not wait for it any longer. A rollout that finishes first has no pods held.
+func (api Api) waitForPostStartHooks(deployment *trackedDeployment, deploymentRequest naisrequest.Deploy, timeout time.Duration) *appError {
+	if appErr := api.enterPhase(deployment, PhaseRollout); appErr != nil {
+		return appErr
+	}
+
+	deadline := time.After(timeout)
+	for {
+		status, _, err := api.DeploymentStatusViewer.DeploymentStatusView(deploymentRequest.Namespace, deploymentRequest.Application)
+		if err == nil && status != InProgress {
+			return nil
+		}
+
+		select {
+		case <-deployment.context().Done():
+			return api.enterPhase(deployment, PhaseRollout)
+		case <-deadline:
+			pod, err := heldByPostStartHook(deploymentRequest.Namespace, deploymentRequest.Application, api.Clientset)
+			if err != nil {
+				return &appError{err, "unable to check postStart hooks", http.StatusInternalServerError}
+			}
+			if len(pod) > 0 {
+				return &appError{fmt.Errorf("the postStart hook of pod %s did not finish within %s", pod, timeout), "postStart hook timed out", http.StatusGatewayTimeout}
+			}
+			return nil
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
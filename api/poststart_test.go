@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidatePostStartHook(t *testing.T) {
+	hook := func(hook PostStartHook) NaisManifest {
+		return NaisManifest{PostStartHook: hook}
+	}
+
+	assert.Nil(t, validatePostStartHook(NaisManifest{}))
+	assert.Nil(t, validatePostStartHook(hook(PostStartHook{Path: "/internal/register"})))
+	assert.Nil(t, validatePostStartHook(hook(PostStartHook{Exec: []string{"/register.sh"}, Timeout: "30s"})))
+	assert.NotNil(t, validatePostStartHook(hook(PostStartHook{Exec: []string{"/register.sh"}, Path: "/internal/register"})))
+	assert.Nil(t, validatePostStartHook(hook(PostStartHook{Path: "/internal/register", Timeout: "30s"})))
+	assert.NotNil(t, validatePostStartHook(hook(PostStartHook{Timeout: "30s"})))
+	assert.NotNil(t, validatePostStartHook(hook(PostStartHook{Exec: []string{"/register.sh"}, Timeout: "soon"})))
+	assert.NotNil(t, validatePostStartHook(hook(PostStartHook{Exec: []string{"/register.sh"}, Timeout: "1h"})))
+
+	manifest := hook(PostStartHook{Exec: []string{"/register.sh"}, Timeout: "5m"})
+	manifest.MaxDeployDuration = "2m"
+	assert.NotNil(t, validatePostStartHook(manifest))
+}
+
+func TestPostStartHook(t *testing.T) {
+	assert.Nil(t, PostStartHook{}.handler())
+	assert.Equal(t, &k8score.Handler{Exec: &k8score.ExecAction{Command: []string{"/register.sh", "--system", "legacy"}}},
+		PostStartHook{Exec: []string{"/register.sh", "--system", "legacy"}}.handler())
+	assert.Equal(t, []string{"/register.sh"}, PostStartHook{Exec: []string{"/register.sh"}, Timeout: "1500ms"}.handler().Exec.Command, "the timeout is enforced by naisd, not in the container")
+
+	manifest := newDefaultManifest()
+	manifest.PreStopHookPath = "/stop"
+	manifest.PostStartHook = PostStartHook{Path: "/internal/register"}
+
+	deployment, err := createOrUpdateDeployment(naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}, manifest, []NaisResource{}, false, fake.NewSimpleClientset())
+	assert.NoError(t, err)
+	lifecycle := deployment.Spec.Template.Spec.Containers[0].Lifecycle
+	assert.Equal(t, "/stop", lifecycle.PreStop.HTTPGet.Path)
+	assert.Equal(t, &k8score.HTTPGetAction{Path: "/internal/register", Port: intstr.FromString(DefaultPortName)}, lifecycle.PostStart.HTTPGet)
+}
+
+func TestWaitForPostStartHooks(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}
+	pod := func(name, reason string) *k8score.Pod {
+		return &k8score.Pod{
+			ObjectMeta: k8smeta.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": appName}},
+			Status: k8score.PodStatus{ContainerStatuses: []k8score.ContainerStatus{
+				{Name: appName, State: k8score.ContainerState{Waiting: &k8score.ContainerStateWaiting{Reason: reason}}},
+			}},
+		}
+	}
+	wait := func(clientset *fake.Clientset, status DeployStatus, timeout time.Duration) *appError {
+		api := Api{Clientset: clientset, DeploymentStatusViewer: FakeDeployStatusViewer{deployStatusToReturn: status}}
+		deployment, _ := api.Deployments.start(context.Background(), deploymentRequest, time.Minute)
+		return api.waitForPostStartHooks(deployment, deploymentRequest, timeout)
+	}
+
+	t.Run("A pod still held by its hook when the timeout has passed fails the deployment", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(pod("app-1", "CrashLoopBackOff"), pod("app-2", "ContainerCreating"))
+
+		appErr := wait(clientset, InProgress, 10*time.Millisecond)
+		assert.NotNil(t, appErr)
+		assert.Equal(t, http.StatusGatewayTimeout, appErr.StatusCode)
+		assert.Contains(t, appErr.OriginalError.Error(), "app-2")
+	})
+
+	t.Run("Pods that are not held leave the rest of the rollout to the rollout timeout", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(pod("app-1", "CrashLoopBackOff"))
+
+		assert.Nil(t, wait(clientset, InProgress, 10*time.Millisecond))
+	})
+
+	t.Run("A finished rollout has no pods held", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(pod("app-1", "ContainerCreating"))
+
+		assert.Nil(t, wait(clientset, Success, time.Hour))
+	})
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	PreviewLabel             = "nais.io/preview"
+	PreviewExpiresAnnotation = "nais.io/preview-expires"
+	DefaultPreviewTtl        = 48 * time.Hour
+	maxApplicationNameLength = 63
+
+	// the application and branch a preview instance is of, so another application or branch with the same name does
+	// not take it over
+	PreviewApplicationAnnotation = "nais.io/preview-application"
+	PreviewBranchAnnotation      = "nais.io/preview-branch"
+)
+
+var nonSlugCharacters = regexp.MustCompile("[^a-z0-9]+")
+
+// Turns a branch name into something that can be part of a k8s resource name and a hostname
+func branchSlug(branch string) string {
+	return strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(branch), "-"), "-")
+}
+
+// previewApplicationName is the name of the preview instance of the branch. A name that is too long is cut, and
+// suffixed with a hash of the application and branch, so branches with the same beginning get different names.
+func previewApplicationName(application, branch string) string {
+	name := application + "-" + branchSlug(branch)
+	if len(name) > maxApplicationNameLength {
+		hash := sha256.Sum256([]byte(application + "/" + branch))
+		suffix := hex.EncodeToString(hash[:])[:6]
+		name = strings.TrimRight(name[:maxApplicationNameLength-len(suffix)-1], "-") + "-" + suffix
+	}
+	return name
+}
+
+// checkPreviewName fails if the name of the preview instance is taken by something other than an earlier preview of
+// the same branch of the application, like another application, or a branch whose name gives the same slug
+func checkPreviewName(namespace, name, application, branch string, k8sClient kubernetes.Interface) error {
+	deployment, err := getExistingDeployment(name, namespace, k8sClient)
+	if err != nil || deployment == nil {
+		return err
+	}
+
+	if deployment.Labels[PreviewLabel] != "true" {
+		return fmt.Errorf("%s/%s is an application, not a preview", namespace, name)
+	}
+	previewOf, ok := deployment.Annotations[PreviewApplicationAnnotation]
+	if !ok {
+		// previews from before the annotations were set
+		return nil
+	}
+	if previewOf != application || deployment.Annotations[PreviewBranchAnnotation] != branch {
+		return fmt.Errorf("%s/%s is the preview of branch %s of %s", namespace, name, deployment.Annotations[PreviewBranchAnnotation], previewOf)
+	}
+	return nil
+}
+
+// Rewrites the deployment request into a preview deployment of the branch. Preview instances are never registered in Fasit.
+// Returns the time the preview expires.
+func applyPreview(deploymentRequest *naisrequest.Deploy, now time.Time) (time.Time, error) {
+	preview := deploymentRequest.Preview
+
+	ttl := DefaultPreviewTtl
+	if len(preview.Ttl) > 0 {
+		var err error
+		if ttl, err = time.ParseDuration(preview.Ttl); err != nil {
+			return time.Time{}, fmt.Errorf("invalid preview ttl %s: %s", preview.Ttl, err)
+		}
+	}
+
+	if len(branchSlug(preview.Branch)) == 0 {
+		return time.Time{}, fmt.Errorf("preview branch %q gives an empty name", preview.Branch)
+	}
+
+	deploymentRequest.Application = previewApplicationName(deploymentRequest.Application, preview.Branch)
+	deploymentRequest.SkipFasit = true
+
+	return now.Add(ttl), nil
+}
+
+func markPreviewDeployment(namespace, application, previewOf, branch string, expires time.Time, k8sClient kubernetes.Interface) error {
+	deployment, err := deployments(k8sClient, namespace).Get(application, k8smeta.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get deployment: %s", err)
+	}
+
+	if deployment.Labels == nil {
+		deployment.Labels = make(map[string]string)
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Labels[PreviewLabel] = "true"
+	deployment.Annotations[PreviewExpiresAnnotation] = expires.UTC().Format(time.RFC3339)
+	deployment.Annotations[PreviewApplicationAnnotation] = previewOf
+	deployment.Annotations[PreviewBranchAnnotation] = branch
+
+	_, err = deployments(k8sClient, namespace).Update(deployment)
+	return err
+}
+
+// Deletes every preview instance that has expired, returning namespace/name of the deleted instances
+func expirePreviews(now time.Time, k8sClient kubernetes.Interface) ([]string, error) {
+	previews, err := deployments(k8sClient, "").List(k8smeta.ListOptions{LabelSelector: PreviewLabel + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list preview deployments: %s", err)
+	}
+
+	var expired []string
+	for _, deployment := range previews.Items {
+		expires, err := time.Parse(time.RFC3339, deployment.Annotations[PreviewExpiresAnnotation])
+		if err != nil {
+			glog.Warningf("preview %s/%s has invalid expiry: %s", deployment.Namespace, deployment.Name, err)
+			continue
+		}
+		if now.Before(expires) {
+			continue
+		}
+
+		if _, err := deleteK8sResouces(deployment.Namespace, deployment.Name, k8sClient); err != nil {
+			return expired, fmt.Errorf("unable to delete preview %s/%s: %s", deployment.Namespace, deployment.Name, err)
+		}
+		expired = append(expired, deployment.Namespace+"/"+deployment.Name)
+	}
+
+	return expired, nil
+}
+
+// ExpirePreviewsPeriodically garbage-collects expired preview instances until the process exits
+func (api Api) ExpirePreviewsPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		expired, err := expirePreviews(time.Now(), api.Clientset)
+		for _, name := range expired {
+			glog.Infof("deleted expired preview %s", name)
+		}
+		if err != nil {
+			glog.Errorf("unable to expire previews: %s", err)
+		}
+	}
+}
+
+func (api Api) expirePreview(w http.ResponseWriter, r *http.Request) *appError {
+	namespace := pat.Param(r, "namespace")
+	deployName := pat.Param(r, "deployName")
+
+	deployment, err := getExistingDeployment(deployName, namespace, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to get deployment", http.StatusInternalServerError}
+	}
+	if deployment == nil || deployment.Labels[PreviewLabel] != "true" {
+		return &appError{fmt.Errorf("%s/%s is not a preview", namespace, deployName), "preview not found", http.StatusNotFound}
+	}
+	identity, appErr := api.authorizeTeam(r, deployment.Labels["team"])
+	if appErr != nil {
+		return appErr
+	}
+
+	if _, err := deleteK8sResouces(namespace, deployName, api.Clientset); err != nil {
+		return &appError{err, "unable to delete preview", http.StatusInternalServerError}
+	}
+
+	api.AuditLog.Record(AuditEntry{Event: "preview_expired", Application: deployName, Namespace: namespace, Details: map[string]string{"expiredBy": identity.Name}})
+	glog.Infof("Expired preview %s in %s\n", deployName, namespace)
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
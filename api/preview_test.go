@@ -0,0 +1,169 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func previewDeployment(name, expires string) *k8sextensions.Deployment {
+	return &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{
+		Name:        name,
+		Namespace:   namespace,
+		Labels:      map[string]string{PreviewLabel: "true"},
+		Annotations: map[string]string{PreviewExpiresAnnotation: expires},
+	}}
+}
+
+func alertsConfigMap() *k8score.ConfigMap {
+	configMap := createConfigMapDef(AlertsConfigMapName, AlertsConfigMapNamespace, teamName)
+	configMap.ObjectMeta.ResourceVersion = resourceVersion
+	return configMap
+}
+
+func TestPreviewApplicationName(t *testing.T) {
+	assert.Equal(t, "app-feature-login-page", previewApplicationName("app", "feature/Login_Page"))
+	assert.Equal(t, "app-1234", previewApplicationName("app", "--1234--"))
+
+	long := previewApplicationName("app", strings.Repeat("a", 100))
+	assert.True(t, len(long) <= maxApplicationNameLength)
+	assert.NotEqual(t, long, previewApplicationName("app", strings.Repeat("a", 100)+"b"), "long branches with the same beginning get different names")
+	assert.Equal(t, long, previewApplicationName("app", strings.Repeat("a", 100)))
+}
+
+func TestCheckPreviewName(t *testing.T) {
+	preview := previewDeployment("app-feature-x", "2018-05-01T13:00:00Z")
+	preview.Annotations[PreviewApplicationAnnotation] = "app"
+	preview.Annotations[PreviewBranchAnnotation] = "feature/x"
+	legacy := previewDeployment("app-legacy", "2018-05-01T13:00:00Z")
+	regular := &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: "app-main", Namespace: namespace}}
+	clientset := fake.NewSimpleClientset(preview, legacy, regular)
+
+	assert.NoError(t, checkPreviewName(namespace, "app-feature-y", "app", "feature/y", clientset), "new previews get the name")
+	assert.NoError(t, checkPreviewName(namespace, "app-feature-x", "app", "feature/x", clientset), "a preview is deployed again")
+	assert.NoError(t, checkPreviewName(namespace, "app-legacy", "app", "legacy", clientset))
+	assert.Error(t, checkPreviewName(namespace, "app-feature-x", "app", "feature-x", clientset), "another branch with the same slug")
+	assert.Error(t, checkPreviewName(namespace, "app-feature-x", "app-feature", "x", clientset), "another application")
+	assert.Error(t, checkPreviewName(namespace, "app-main", "app", "main", clientset), "an application that is not a preview")
+}
+
+func TestApplyPreview(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Preview is suffixed with branch and skips Fasit", func(t *testing.T) {
+		request := naisrequest.Deploy{Application: "app", Preview: &naisrequest.Preview{Branch: "feature/x", Ttl: "2h"}}
+
+		expires, err := applyPreview(&request, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "app-feature-x", request.Application)
+		assert.True(t, request.SkipFasit)
+		assert.Equal(t, now.Add(2*time.Hour), expires)
+	})
+
+	t.Run("Default ttl is used when not set", func(t *testing.T) {
+		request := naisrequest.Deploy{Application: "app", Preview: &naisrequest.Preview{Branch: "x"}}
+
+		expires, err := applyPreview(&request, now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(DefaultPreviewTtl), expires)
+	})
+
+	t.Run("Invalid ttl or branch gives error", func(t *testing.T) {
+		_, err := applyPreview(&naisrequest.Deploy{Application: "app", Preview: &naisrequest.Preview{Branch: "x", Ttl: "tomorrow"}}, now)
+		assert.Error(t, err)
+
+		_, err = applyPreview(&naisrequest.Deploy{Application: "app", Preview: &naisrequest.Preview{Branch: "///"}}, now)
+		assert.Error(t, err)
+	})
+}
+
+func TestExpirePreviews(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	regular := &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}}
+	clientset := fake.NewSimpleClientset(
+		regular,
+		alertsConfigMap(),
+		previewDeployment("app-expired", "2018-05-01T11:00:00Z"),
+		previewDeployment("app-active", "2018-05-01T13:00:00Z"),
+	)
+
+	expired, err := expirePreviews(now, clientset)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{namespace + "/app-expired"}, expired)
+
+	deployment, _ := getExistingDeployment("app-expired", namespace, clientset)
+	assert.Nil(t, deployment)
+	deployment, _ = getExistingDeployment("app-active", namespace, clientset)
+	assert.NotNil(t, deployment)
+	deployment, _ = getExistingDeployment(appName, namespace, clientset)
+	assert.NotNil(t, deployment)
+}
+
+func TestMarkPreviewDeployment(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}})
+	expires := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, markPreviewDeployment(namespace, appName, "app", "feature/x", expires, clientset))
+
+	deployment, _ := getExistingDeployment(appName, namespace, clientset)
+	assert.Equal(t, "true", deployment.Labels[PreviewLabel])
+	assert.Equal(t, "2018-05-01T12:00:00Z", deployment.Annotations[PreviewExpiresAnnotation])
+	assert.Equal(t, "app", deployment.Annotations[PreviewApplicationAnnotation])
+	assert.Equal(t, "feature/x", deployment.Annotations[PreviewBranchAnnotation])
+}
+
+func TestExpirePreviewHandler(t *testing.T) {
+	preview := previewDeployment("app-branch", "2018-05-01T13:00:00Z")
+	preview.Labels["team"] = teamName
+	clientset := fake.NewSimpleClientset(
+		&k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}},
+		alertsConfigMap(),
+		preview,
+	)
+	api := Api{
+		Clientset:     clientset,
+		AuditLog:      NewAuditLog(),
+		OperatorToken: "secret",
+		Identities:    []Identity{{Name: "alice", Teams: []string{teamName}, Token: "alice"}, {Name: "bob", Teams: []string{"other"}, Token: "bob"}},
+	}
+
+	expire := func(name, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/preview/"+namespace+"/"+name+"/expire", nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Regular applications can not be expired", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, expire(appName, "secret").Code)
+	})
+
+	t.Run("Only the team of the preview and the operator can expire it", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, expire("app-branch", "").Code)
+		assert.Equal(t, http.StatusForbidden, expire("app-branch", "bob").Code)
+		deployment, _ := getExistingDeployment("app-branch", namespace, clientset)
+		assert.NotNil(t, deployment)
+	})
+
+	t.Run("Preview is deleted on expire", func(t *testing.T) {
+		rr := expire("app-branch", "alice")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		deployment, _ := getExistingDeployment("app-branch", namespace, clientset)
+		assert.Nil(t, deployment)
+		assert.Equal(t, "preview_expired", api.AuditLog.Entries()[0].Event)
+		assert.Equal(t, "alice", api.AuditLog.Entries()[0].Details["expiredBy"])
+	})
+}
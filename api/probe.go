@@ -0,0 +1,62 @@
+package api
+
+import (
+	"strings"
+
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// A probe is a GET on Path by default. Applications without an HTTP endpoint, e.g. message-driven ones, can instead
+// have the probe open a TCP connection to their port with TcpSocket, or run a command in the container with Exec,
+// which succeeds if the command exits with 0. Path is then ignored, as it always has a default.
+func (probe Probe) handler() k8score.Handler {
+	switch {
+	case len(probe.Exec) > 0:
+		return k8score.Handler{Exec: &k8score.ExecAction{Command: probe.Exec}}
+	case probe.TcpSocket:
+		return k8score.Handler{TCPSocket: &k8score.TCPSocketAction{Port: intstr.FromString(DefaultPortName)}}
+	default:
+		return k8score.Handler{
+			HTTPGet: &k8score.HTTPGetAction{
+				Path: probe.Path,
+				Port: intstr.FromString(DefaultPortName),
+			},
+		}
+	}
+}
+
+// httpPath is the path of a probe that is a GET, or an empty string for other probes
+func (probe Probe) httpPath() string {
+	if len(probe.Exec) > 0 || probe.TcpSocket {
+		return ""
+	}
+	return probe.Path
+}
+
+// declared tells if the team has set up the probe in the manifest, rather than relying on the default path
+func (probe Probe) declared() bool {
+	return len(probe.Path) > 0 || len(probe.Exec) > 0 || probe.TcpSocket
+}
+
+func createProbe(probe Probe) *k8score.Probe {
+	return &k8score.Probe{
+		Handler:             probe.handler(),
+		InitialDelaySeconds: int32(probe.InitialDelay),
+		PeriodSeconds:       int32(probe.PeriodSeconds),
+		FailureThreshold:    int32(probe.FailureThreshold),
+		TimeoutSeconds:      int32(probe.Timeout),
+	}
+}
+
+func validateProbes(manifest NaisManifest) *ValidationError {
+	for name, probe := range map[string]Probe{"Liveness": manifest.Healthcheck.Liveness, "Readiness": manifest.Healthcheck.Readiness} {
+		if len(probe.Exec) > 0 && probe.TcpSocket {
+			return &ValidationError{
+				"Healthcheck probes can have either tcpSocket or exec, not both",
+				map[string]string{"Healthcheck." + name + ".Exec": strings.Join(probe.Exec, " ")},
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestProbes(t *testing.T) {
+	t.Run("Path gives a GET", func(t *testing.T) {
+		probe := createProbe(Probe{Path: "isReady", InitialDelay: 20, PeriodSeconds: 10, FailureThreshold: 3, Timeout: 1})
+		assert.Equal(t, &k8score.HTTPGetAction{Path: "isReady", Port: intstr.FromString(DefaultPortName)}, probe.HTTPGet)
+		assert.Equal(t, int32(20), probe.InitialDelaySeconds)
+		assert.Equal(t, int32(1), probe.TimeoutSeconds)
+	})
+
+	t.Run("TcpSocket and exec replace the default path", func(t *testing.T) {
+		probe := createProbe(Probe{Path: "isReady", TcpSocket: true})
+		assert.Nil(t, probe.HTTPGet)
+		assert.Equal(t, &k8score.TCPSocketAction{Port: intstr.FromString(DefaultPortName)}, probe.TCPSocket)
+
+		probe = createProbe(Probe{Path: "isAlive", Exec: []string{"/app/alive.sh"}})
+		assert.Nil(t, probe.HTTPGet)
+		assert.Equal(t, []string{"/app/alive.sh"}, probe.Exec.Command)
+	})
+
+	t.Run("Only GET probes have health check urls", func(t *testing.T) {
+		manifest := NaisManifest{Healthcheck: Healthcheck{Liveness: Probe{Path: "isAlive"}, Readiness: Probe{Path: "isReady", TcpSocket: true}}}
+		urls := healthCheckUrls(manifest, "app.nais.local")
+		assert.Equal(t, "https://app.nais.local/isAlive", urls.IsAlive)
+		assert.Empty(t, urls.IsReady)
+	})
+
+	t.Run("TcpSocket and exec can not be combined", func(t *testing.T) {
+		assert.Nil(t, validateProbes(NaisManifest{Healthcheck: Healthcheck{Readiness: Probe{Exec: []string{"/app/ready.sh"}}}}))
+		assert.NotNil(t, validateProbes(NaisManifest{Healthcheck: Healthcheck{Readiness: Probe{Exec: []string{"/app/ready.sh"}, TcpSocket: true}}}))
+	})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PropertyTypeString  = "string"
+	PropertyTypeJson    = "json"
+	PropertyTypeList    = "list"
+	PropertyTypeNumber  = "number"
+	PropertyTypeBoolean = "boolean"
+)
+
+var propertyTypes = []string{PropertyTypeString, PropertyTypeJson, PropertyTypeList, PropertyTypeNumber, PropertyTypeBoolean}
+
+func validatePropertyTypes(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Used {
+		for property, propertyType := range resource.PropertyTypes {
+			if !contains(propertyTypes, propertyType) {
+				return &ValidationError{
+					"PropertyTypes must be one of " + strings.Join(propertyTypes, ", "),
+					map[string]string{"Alias": resource.Alias, "Property": property, "Type": propertyType},
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Splits a comma separated list, ignoring whitespace around the elements and empty elements
+func splitList(value string) []string {
+	var elements []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); len(element) > 0 {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// Validates and reformats the properties of a resource according to their types. A list property is replaced by
+// one property per element, suffixed with its index, e.g. hosts=a,b becomes hosts_0=a and hosts_1=b.
+func convertPropertyTypes(resource NaisResource, types map[string]string) (NaisResource, error) {
+	if len(types) == 0 {
+		return resource, nil
+	}
+
+	properties := make(map[string]string, len(resource.properties))
+	for key, value := range resource.properties {
+		properties[key] = value
+	}
+	propertyMap := make(map[string]string, len(resource.propertyMap))
+	for key, value := range resource.propertyMap {
+		propertyMap[key] = value
+	}
+
+	for property, propertyType := range types {
+		value, ok := properties[property]
+		if !ok {
+			return resource, fmt.Errorf("resource %s (%s) has no property %s", resource.name, resource.resourceType, property)
+		}
+
+		switch propertyType {
+		case PropertyTypeJson:
+			var minified bytes.Buffer
+			if err := json.Compact(&minified, []byte(value)); err != nil {
+				return resource, fmt.Errorf("property %s of %s (%s) is not valid JSON: %s", property, resource.name, resource.resourceType, err)
+			}
+			properties[property] = minified.String()
+		case PropertyTypeList:
+			delete(properties, property)
+			mapped, isMapped := propertyMap[property]
+			delete(propertyMap, property)
+			for i, element := range splitList(value) {
+				key := fmt.Sprintf("%s_%d", property, i)
+				properties[key] = element
+				if isMapped {
+					propertyMap[key] = fmt.Sprintf("%s_%d", mapped, i)
+				}
+			}
+		case PropertyTypeNumber:
+			if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				return resource, fmt.Errorf("property %s of %s (%s) is not a number: %s", property, resource.name, resource.resourceType, value)
+			}
+			properties[property] = strings.TrimSpace(value)
+		case PropertyTypeBoolean:
+			boolean, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return resource, fmt.Errorf("property %s of %s (%s) is not a boolean: %s", property, resource.name, resource.resourceType, value)
+			}
+			properties[property] = strconv.FormatBool(boolean)
+		}
+	}
+
+	resource.properties = properties
+	resource.propertyMap = propertyMap
+	return resource, nil
+}
+
+// Converts the properties of the fetched resources according to the types given for them in the manifest
+func convertResourcePropertyTypes(naisResources []NaisResource, usedResources []UsedResource) ([]NaisResource, error) {
+	for _, used := range usedResources {
+		if len(used.PropertyTypes) == 0 {
+			continue
+		}
+
+		for i, resource := range naisResources {
+			if resource.name != used.Alias || !strings.EqualFold(resource.resourceType, used.ResourceType) {
+				continue
+			}
+
+			converted, err := convertPropertyTypes(resource, used.PropertyTypes)
+			if err != nil {
+				return naisResources, err
+			}
+			naisResources[i] = converted
+		}
+	}
+
+	return naisResources, nil
+}
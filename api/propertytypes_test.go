@@ -0,0 +1,75 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePropertyTypes(t *testing.T) {
+	valid := NaisManifest{FasitResources: FasitResources{Used: []UsedResource{
+		{Alias: "config", ResourceType: "BaseUrl", PropertyTypes: map[string]string{"url": "string", "settings": "json", "hosts": "list"}},
+	}}}
+	invalid := NaisManifest{FasitResources: FasitResources{Used: []UsedResource{
+		{Alias: "config", ResourceType: "BaseUrl", PropertyTypes: map[string]string{"settings": "yaml"}},
+	}}}
+
+	assert.Nil(t, validatePropertyTypes(valid))
+	err := validatePropertyTypes(invalid)
+	assert.Equal(t, "yaml", err.Fields["Type"])
+	assert.Equal(t, "settings", err.Fields["Property"])
+}
+
+func TestConvertPropertyTypes(t *testing.T) {
+	resource := NaisResource{
+		name:         "config",
+		resourceType: "BaseUrl",
+		properties: map[string]string{
+			"settings": "{\n  \"retries\": 3,\n  \"hosts\": [\"a\", \"b\"]\n}",
+			"hosts":    "a.example.com, b.example.com,",
+			"timeout":  " 30 ",
+			"enabled":  "TRUE",
+		},
+	}
+
+	t.Run("Properties are reformatted and lists split", func(t *testing.T) {
+		converted, err := convertPropertyTypes(resource, map[string]string{"settings": "json", "hosts": "list", "timeout": "number", "enabled": "boolean"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"settings": `{"retries":3,"hosts":["a","b"]}`,
+			"hosts_0":  "a.example.com",
+			"hosts_1":  "b.example.com",
+			"timeout":  "30",
+			"enabled":  "true",
+		}, converted.properties)
+		assert.Equal(t, "CONFIG_HOSTS_1", converted.ToEnvironmentVariable("hosts_1"))
+		assert.Contains(t, resource.properties, "hosts", "the original resource is left unchanged")
+	})
+
+	t.Run("Mapped list properties are mapped per element", func(t *testing.T) {
+		mapped := resource
+		mapped.propertyMap = map[string]string{"hosts": "UPSTREAM"}
+		converted, err := convertPropertyTypes(mapped, map[string]string{"hosts": "list"})
+		assert.NoError(t, err)
+		assert.Equal(t, "UPSTREAM_0", converted.ToEnvironmentVariable("hosts_0"))
+	})
+
+	t.Run("Invalid values give error", func(t *testing.T) {
+		_, err := convertPropertyTypes(resource, map[string]string{"hosts": "json"})
+		assert.Error(t, err)
+		_, err = convertPropertyTypes(resource, map[string]string{"hosts": "number"})
+		assert.EqualError(t, err, "property hosts of config (BaseUrl) is not a number: a.example.com, b.example.com,")
+		_, err = convertPropertyTypes(resource, map[string]string{"missing": "string"})
+		assert.EqualError(t, err, "resource config (BaseUrl) has no property missing")
+	})
+
+	t.Run("Types are applied to the used resource they belong to", func(t *testing.T) {
+		other := NaisResource{name: "other", resourceType: "BaseUrl", properties: map[string]string{"hosts": "x,y"}}
+		converted, err := convertResourcePropertyTypes([]NaisResource{resource, other}, []UsedResource{
+			{Alias: "config", ResourceType: "baseurl", PropertyTypes: map[string]string{"hosts": "list"}},
+		})
+		assert.NoError(t, err)
+		assert.Contains(t, converted[0].properties, "hosts_0")
+		assert.Equal(t, "x,y", converted[1].properties["hosts"])
+	})
+}
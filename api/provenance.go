@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const DefaultCosignBinary = "cosign"
+
+type ProvenanceConfig struct {
+	CosignBinary    string `yaml:"cosignBinary"`
+	CosignPublicKey string `yaml:"cosignPublicKey"`
+}
+
+type ProvenanceResult struct {
+	ManifestChecksum  string
+	ManifestVerified  bool
+	ImageVerified     bool
+	ImageVerification string
+}
+
+func (r ProvenanceResult) details() map[string]string {
+	return map[string]string{
+		"manifestChecksum":  r.ManifestChecksum,
+		"manifestVerified":  fmt.Sprintf("%t", r.ManifestVerified),
+		"imageVerified":     fmt.Sprintf("%t", r.ImageVerified),
+		"imageVerification": r.ImageVerification,
+	}
+}
+
+func verifyManifestChecksum(manifest NaisManifest, expectedSha256 string) error {
+	expected := "sha256:" + strings.ToLower(strings.TrimPrefix(expectedSha256, "sha256:"))
+	if manifest.Checksum != expected {
+		return fmt.Errorf("manifest checksum %s does not match expected %s", manifest.Checksum, expected)
+	}
+	return nil
+}
+
+// Verifies the image signature using cosign and the public key configured for the daemon
+func verifyImageSignature(image string, config ProvenanceConfig) (string, error) {
+	if len(config.CosignPublicKey) == 0 {
+		return "", fmt.Errorf("image signature required, but no cosign public key is configured")
+	}
+
+	binary := config.CosignBinary
+	if len(binary) == 0 {
+		binary = DefaultCosignBinary
+	}
+
+	output, err := exec.Command(binary, "verify", "--key", config.CosignPublicKey, image).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("signature verification of image %s failed: %s: %s", image, err, strings.TrimSpace(string(output)))
+	}
+
+	return string(output), nil
+}
+
+// Verifies the provenance requirements of the deployment request. The result is returned even when
+// verification fails, so that it can be recorded in the audit log.
+func verifyProvenance(deploymentRequest naisrequest.Deploy, manifest NaisManifest, config ProvenanceConfig) (ProvenanceResult, error) {
+	result := ProvenanceResult{ManifestChecksum: manifest.Checksum}
+
+	if len(deploymentRequest.ManifestSha256) > 0 {
+		if err := verifyManifestChecksum(manifest, deploymentRequest.ManifestSha256); err != nil {
+			return result, err
+		}
+		result.ManifestVerified = true
+	}
+
+	if deploymentRequest.RequireImageSignature {
+		image := fmt.Sprintf("%s:%s", manifest.Image, deploymentRequest.Version)
+		output, err := verifyImageSignature(image, config)
+		result.ImageVerification = strings.TrimSpace(output)
+		if err != nil {
+			return result, err
+		}
+		result.ImageVerified = true
+	}
+
+	return result, nil
+}
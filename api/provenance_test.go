@@ -0,0 +1,57 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyManifestChecksum(t *testing.T) {
+	body := []byte("image: navikt/app\n")
+	manifest := NaisManifest{Checksum: manifestChecksum(body)}
+	checksum := manifest.Checksum[len("sha256:"):]
+
+	assert.NoError(t, verifyManifestChecksum(manifest, checksum))
+	assert.NoError(t, verifyManifestChecksum(manifest, "sha256:"+checksum))
+	assert.Error(t, verifyManifestChecksum(manifest, "0000"))
+}
+
+func TestVerifyProvenance(t *testing.T) {
+	manifest := NaisManifest{Image: "navikt/app", Checksum: manifestChecksum([]byte("image: navikt/app\n"))}
+
+	t.Run("Nothing is verified when nothing is required", func(t *testing.T) {
+		result, err := verifyProvenance(naisrequest.Deploy{Version: "1"}, manifest, ProvenanceConfig{})
+		assert.NoError(t, err)
+		assert.False(t, result.ManifestVerified)
+		assert.False(t, result.ImageVerified)
+		assert.Equal(t, manifest.Checksum, result.ManifestChecksum)
+	})
+
+	t.Run("Checksum mismatch fails verification", func(t *testing.T) {
+		result, err := verifyProvenance(naisrequest.Deploy{Version: "1", ManifestSha256: "deadbeef"}, manifest, ProvenanceConfig{})
+		assert.Error(t, err)
+		assert.False(t, result.ManifestVerified)
+	})
+
+	t.Run("Required image signature fails without configured key", func(t *testing.T) {
+		_, err := verifyProvenance(naisrequest.Deploy{Version: "1", RequireImageSignature: true}, manifest, ProvenanceConfig{})
+		assert.EqualError(t, err, "image signature required, but no cosign public key is configured")
+	})
+
+	t.Run("Image is verified when cosign succeeds", func(t *testing.T) {
+		config := ProvenanceConfig{CosignBinary: "true", CosignPublicKey: "cosign.pub"}
+		result, err := verifyProvenance(naisrequest.Deploy{Version: "1", RequireImageSignature: true}, manifest, config)
+		assert.NoError(t, err)
+		assert.True(t, result.ImageVerified)
+		assert.Equal(t, "true", result.details()["imageVerified"])
+	})
+
+	t.Run("Image verification fails when cosign fails", func(t *testing.T) {
+		config := ProvenanceConfig{CosignBinary: "false", CosignPublicKey: "cosign.pub"}
+		result, err := verifyProvenance(naisrequest.Deploy{Version: "1", RequireImageSignature: true}, manifest, config)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "signature verification of image navikt/app:1 failed")
+		assert.False(t, result.ImageVerified)
+	})
+}
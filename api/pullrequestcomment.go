@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/pmezard/go-difflib/difflib"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+)
+
+const (
+	DefaultGithubApiUrl = "https://api.github.com"
+	DefaultGitlabApiUrl = "https://gitlab.com"
+)
+
+var pullRequestCommentClient = &http.Client{Timeout: 10 * time.Second}
+
+// PullRequestProvider holds the API url and token used when commenting on pull/merge requests
+type PullRequestProvider struct {
+	Url   string
+	Token string
+}
+
+type pullRequestComment struct {
+	Body string `json:"body"`
+}
+
+// Unified diff of the deployment spec before and after the deploy. An empty before means the deployment was created.
+func deploymentSpecDiff(before, after *k8sextensions.Deployment) (string, error) {
+	var beforeSpec, afterSpec []byte
+	var err error
+
+	if before != nil {
+		if beforeSpec, err = yaml.Marshal(before.Spec); err != nil {
+			return "", fmt.Errorf("unable to marshal existing deployment spec: %s", err)
+		}
+	}
+	if after != nil {
+		if afterSpec, err = yaml.Marshal(after.Spec); err != nil {
+			return "", fmt.Errorf("unable to marshal deployment spec: %s", err)
+		}
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(beforeSpec)),
+		B:        difflib.SplitLines(string(afterSpec)),
+		FromFile: "current",
+		ToFile:   "deployed",
+		Context:  3,
+	})
+}
+
+func ingressUrls(ingress *k8sextensions.Ingress) []string {
+	var urls []string
+	if ingress == nil {
+		return urls
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			urls = append(urls, "https://"+rule.Host+path.Path)
+		}
+	}
+	return urls
+}
+
+func createPullRequestCommentBody(deploymentRequest naisrequest.Deploy, diff string, urls []string) string {
+	var body bytes.Buffer
+
+	fmt.Fprintf(&body, "Deployed **%s:%s** to namespace `%s`\n\n", deploymentRequest.Application, deploymentRequest.Version, deploymentRequest.Namespace)
+
+	if len(urls) > 0 {
+		body.WriteString("Ingresses:\n")
+		for _, u := range urls {
+			fmt.Fprintf(&body, "- %s\n", u)
+		}
+		body.WriteString("\n")
+	}
+
+	if len(diff) == 0 {
+		body.WriteString("No changes to the deployment spec.\n")
+	} else {
+		fmt.Fprintf(&body, "```diff\n%s```\n", diff)
+	}
+
+	return body.String()
+}
+
+func pullRequestCommentRequest(pullRequest naisrequest.PullRequest, provider PullRequestProvider, body string) (*http.Request, error) {
+	payload, err := json.Marshal(pullRequestComment{Body: body})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create payload (%s)", err)
+	}
+
+	var req *http.Request
+	switch pullRequest.Provider {
+	case "github":
+		apiUrl := provider.Url
+		if len(apiUrl) == 0 {
+			apiUrl = DefaultGithubApiUrl
+		}
+		req, err = http.NewRequest("POST", fmt.Sprintf("%s/repos/%s/issues/%d/comments", strings.TrimSuffix(apiUrl, "/"), pullRequest.Repository, pullRequest.Number), bytes.NewBuffer(payload))
+		if err == nil {
+			req.Header.Set("Authorization", "token "+provider.Token)
+		}
+	case "gitlab":
+		apiUrl := provider.Url
+		if len(apiUrl) == 0 {
+			apiUrl = DefaultGitlabApiUrl
+		}
+		req, err = http.NewRequest("POST", fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d/notes", strings.TrimSuffix(apiUrl, "/"), url.PathEscape(pullRequest.Repository), pullRequest.Number), bytes.NewBuffer(payload))
+		if err == nil {
+			req.Header.Set("PRIVATE-TOKEN", provider.Token)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported pull request provider %s", pullRequest.Provider)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+func postPullRequestComment(pullRequest naisrequest.PullRequest, providers map[string]PullRequestProvider, body string) error {
+	provider, ok := providers[pullRequest.Provider]
+	if !ok {
+		return fmt.Errorf("no credentials configured for pull request provider %s", pullRequest.Provider)
+	}
+
+	req, err := pullRequestCommentRequest(pullRequest, provider, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := pullRequestCommentClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post pull request comment: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		response, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s responded with %d: %s", pullRequest.Provider, resp.StatusCode, response)
+	}
+
+	return nil
+}
+
+func (api Api) commentOnPullRequest(deploymentRequest naisrequest.Deploy, previous *k8sextensions.Deployment, deploymentResult DeploymentResult) error {
+	diff, err := deploymentSpecDiff(previous, deploymentResult.Deployment)
+	if err != nil {
+		return err
+	}
+
+	body := createPullRequestCommentBody(deploymentRequest, diff, ingressUrls(deploymentResult.Ingress))
+	return postPullRequestComment(*deploymentRequest.PullRequest, api.PullRequestProviders, body)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+)
+
+func deploymentWithImage(image string) *k8sextensions.Deployment {
+	return &k8sextensions.Deployment{
+		Spec: k8sextensions.DeploymentSpec{
+			Template: k8score.PodTemplateSpec{
+				Spec: k8score.PodSpec{
+					Containers: []k8score.Container{{Name: appName, Image: image}},
+				},
+			},
+		},
+	}
+}
+
+func TestDeploymentSpecDiff(t *testing.T) {
+	t.Run("Changed fields are part of the diff", func(t *testing.T) {
+		diff, err := deploymentSpecDiff(deploymentWithImage("app:1"), deploymentWithImage("app:2"))
+		assert.NoError(t, err)
+		assert.Contains(t, diff, "-    - image: app:1")
+		assert.Contains(t, diff, "+    - image: app:2")
+	})
+
+	t.Run("Unchanged deployment gives empty diff", func(t *testing.T) {
+		diff, err := deploymentSpecDiff(deploymentWithImage("app:1"), deploymentWithImage("app:1"))
+		assert.NoError(t, err)
+		assert.Empty(t, diff)
+	})
+
+	t.Run("New deployment is all additions", func(t *testing.T) {
+		diff, err := deploymentSpecDiff(nil, deploymentWithImage("app:1"))
+		assert.NoError(t, err)
+		assert.Contains(t, diff, "+    - image: app:1")
+		assert.NotContains(t, diff, "\n-  ")
+	})
+}
+
+func TestIngressUrls(t *testing.T) {
+	ingress := createIngressDef(appName, namespace, "team")
+	ingress.Spec.Rules = createIngressRules(naisrequest.Deploy{Application: appName, Namespace: namespace}, "nais.example.no", nil)
+
+	assert.Equal(t, []string{"https://" + createIngressHostname(appName, namespace, "nais.example.no") + "/"}, ingressUrls(ingress))
+	assert.Empty(t, ingressUrls(nil))
+}
+
+func TestPostPullRequestComment(t *testing.T) {
+	providers := map[string]PullRequestProvider{
+		"github": {Url: "https://github.local", Token: "ghtoken"},
+		"gitlab": {Url: "https://gitlab.local", Token: "gltoken"},
+	}
+
+	t.Run("Comment is posted to GitHub issue comments", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://github.local").
+			Post("/repos/navikt/app/issues/42/comments").
+			MatchHeader("Authorization", "token ghtoken").
+			JSON(map[string]string{"body": "comment"}).
+			Reply(201)
+
+		err := postPullRequestComment(naisrequest.PullRequest{Provider: "github", Repository: "navikt/app", Number: 42}, providers, "comment")
+		assert.NoError(t, err)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Comment is posted as GitLab merge request note", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://gitlab.local").
+			Post("/api/v4/projects/navikt(%2F|/)app/merge_requests/7/notes").
+			MatchHeader("PRIVATE-TOKEN", "gltoken").
+			Reply(201)
+
+		err := postPullRequestComment(naisrequest.PullRequest{Provider: "gitlab", Repository: "navikt/app", Number: 7}, providers, "comment")
+		assert.NoError(t, err)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Unconfigured provider gives error", func(t *testing.T) {
+		err := postPullRequestComment(naisrequest.PullRequest{Provider: "github", Repository: "navikt/app", Number: 1}, map[string]PullRequestProvider{}, "comment")
+		assert.Error(t, err)
+	})
+
+	t.Run("Error response is returned", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://github.local").
+			Post("/repos/navikt/app/issues/42/comments").
+			Reply(404).
+			BodyString("Not Found")
+
+		err := postPullRequestComment(naisrequest.PullRequest{Provider: "github", Repository: "navikt/app", Number: 42}, providers, "comment")
+		assert.Error(t, err)
+	})
+}
+
+func TestCreatePullRequestCommentBody(t *testing.T) {
+	request := naisrequest.Deploy{Application: appName, Version: version, Namespace: namespace}
+
+	body := createPullRequestCommentBody(request, "-a\n+b\n", []string{"https://app.nais.example.no/"})
+	assert.Contains(t, body, "https://app.nais.example.no/")
+	assert.Contains(t, body, "```diff\n-a\n+b\n```")
+
+	assert.Contains(t, createPullRequestCommentBody(request, "", nil), "No changes to the deployment spec.")
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+)
+
+const defaultRedeployNamespace = "default"
+
+func newDeploymentSpec(deploymentRequest naisrequest.Deploy, manifest NaisManifest, resources []NaisResource) *deploymentSpec {
+	deploymentRequest.FasitUsername = ""
+	deploymentRequest.FasitPassword = ""
+	deploymentRequest.PullRequest = nil
+	deploymentRequest.WaitForRollout = false
+
+	return &deploymentSpec{request: deploymentRequest, manifest: manifest, resources: resources}
+}
+
+// redeploy applies the last successful deployment of an application again, e.g. after its resources have been deleted
+// with kubectl. The spec is regenerated from the deployment history, so neither the manifest nor Fasit is needed, and
+// nothing is written to Fasit.
+func (api Api) redeploy(w http.ResponseWriter, r *http.Request) *appError {
+	environment := pat.Param(r, "environment")
+	application := pat.Param(r, "application")
+	namespace := r.URL.Query().Get("namespace")
+	if len(namespace) == 0 {
+		namespace = defaultRedeployNamespace
+	}
+
+	previous, ok := api.DeploymentHistory.lastKnownGood(environment, namespace, application)
+	if !ok {
+		return &appError{fmt.Errorf("no successful deployment of %s to %s in %s that can be redeployed", application, namespace, environment), "nothing to redeploy", http.StatusNotFound}
+	}
+	spec := previous.spec
+
+	glog.Infof("Redeploying %s:%s to %s in %s from deployment history\n", application, spec.request.Version, namespace, environment)
+
+	deploymentId, deploymentResult, appErr := api.redeployRecord(r.Context(), previous, spec, "redeploy", "operator")
+	if len(deploymentId) > 0 {
+		w.Header().Set("X-Deployment-Id", deploymentId)
+	}
+	if appErr != nil {
+		return appErr
+	}
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "redeploy",
+		Application: application,
+		Namespace:   namespace,
+		Version:     spec.request.Version,
+		Details:     map[string]string{"environment": environment, "from": previous.DeploymentId},
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(createResponse(deploymentResult))
+	return nil
+}
+
+// redeployRecord applies a spec from the deployment history again as a new deployment, which is recorded as a copy of
+// the deployment the spec was taken from. Returns the id of the new deployment, if it was started.
+func (api Api) redeployRecord(ctx context.Context, previous DeploymentRecord, spec *deploymentSpec, action, deployedBy string) (string, DeploymentResult, *appError) {
+	api.Status.deploymentStarted()
+	defer api.Status.deploymentFinished()
+
+	deployment, err := api.Deployments.start(ctx, spec.request, api.MaxDeployDuration)
+	if err != nil {
+		return "", DeploymentResult{}, &appError{err, "application is already being deployed", http.StatusConflict}
+	}
+	succeeded := false
+	record := previous
+	record.Timestamp = time.Time{}
+	record.DeploymentId = deployment.Id
+	record.PreviousVersion = previous.Version
+	record.DeployedBy = deployedBy
+	record.spec = spec
+	defer func() {
+		api.Deployments.finish(deployment, succeeded)
+		if !succeeded {
+			record.Result = deployment.Status
+		}
+		api.logDeployment(record, action, deployment.Started)
+	}()
+
+	if appErr := api.enterPhase(deployment, PhaseKubernetes); appErr != nil {
+		return deployment.Id, DeploymentResult{}, appErr
+	}
+
+	deploymentResult, appErr := api.applyDeploymentSpec(spec, DeploymentCause{Action: action, DeploymentId: deployment.Id, Version: spec.request.Version, DeployedBy: deployedBy})
+	if appErr != nil {
+		return deployment.Id, deploymentResult, appErr
+	}
+
+	record.Result = DeploymentSucceeded
+	api.DeploymentHistory.Add(record)
+
+	succeeded = true
+	return deployment.Id, deploymentResult, nil
+}
+
+// applyDeploymentSpec creates or updates the Kubernetes resources of a deployment from the deployment history
+func (api Api) applyDeploymentSpec(spec *deploymentSpec, cause DeploymentCause) (DeploymentResult, *appError) {
+	deploymentResult, err := createOrUpdateK8sResources(spec.request, spec.manifest, spec.resources, api.ClusterSubdomain, api.IstioEnabled, api.Clientset)
+	if err != nil {
+		return deploymentResult, &appError{err, "failed while creating or updating k8s-resources", http.StatusInternalServerError}
+	}
+	deploymentResult.ManifestChecksum = spec.manifest.Checksum
+
+	if err := annotateDeploymentCause(spec.request.Namespace, spec.request.Application, cause, api.Clientset); err != nil {
+		if appErr := api.stepFailed(StepChangeCause, err, "unable to set change cause of deployment", &deploymentResult); appErr != nil {
+			return deploymentResult, appErr
+		}
+	}
+
+	if api.Egress.NetworkPolicies {
+		networkPolicy, err := createOrUpdateNetworkPolicy(spec.request, spec.manifest, spec.resources, api.Clientset)
+		if err != nil {
+			return deploymentResult, &appError{err, "failed while creating or updating network policy", http.StatusInternalServerError}
+		}
+		deploymentResult.NetworkPolicy = networkPolicy
+	}
+
+	if deploymentResult.IngressPaused {
+		go api.resumeIngressAfterRollout(spec.request, spec.manifest, spec.resources)
+	}
+
+	return deploymentResult, nil
+}
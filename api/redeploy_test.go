@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewDeploymentSpec(t *testing.T) {
+	spec := newDeploymentSpec(naisrequest.Deploy{Application: appName, FasitUsername: "user", FasitPassword: "secret", WaitForRollout: true}, newDefaultManifest(), nil)
+
+	assert.Equal(t, naisrequest.Deploy{Application: appName}, spec.request)
+}
+
+func TestLastKnownGood(t *testing.T) {
+	spec := newDeploymentSpec(naisrequest.Deploy{Application: appName, Version: "1"}, newDefaultManifest(), nil)
+
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Application: appName, Namespace: namespace, Environment: "t1", Version: "1", spec: spec})
+	history.Add(DeploymentRecord{Application: appName, Namespace: namespace, Environment: "t1", Version: "2", Result: DeploymentFailed})
+
+	record, ok := history.lastKnownGood("t1", namespace, appName)
+	assert.True(t, ok)
+	assert.Equal(t, "1", record.Version)
+
+	_, ok = history.lastKnownGood("q1", namespace, appName)
+	assert.False(t, ok)
+
+	history.Add(DeploymentRecord{Application: appName, Namespace: namespace, Environment: "t1", Version: "3"})
+	_, ok = history.lastKnownGood("t1", namespace, appName)
+	assert.False(t, ok, "the latest deployment has no spec, so an older one is not redeployed in its place")
+}
+
+func TestRedeploy(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: version, FasitEnvironment: "t1", Zone: "fss"}
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{
+		DeploymentId: "first",
+		Application:  appName,
+		Namespace:    namespace,
+		Environment:  "t1",
+		Version:      version,
+		spec:         newDeploymentSpec(deploymentRequest, newDefaultManifest(), []NaisResource{}),
+	})
+
+	logged := make(chan deploymentLogEntry, 1)
+	deploymentLog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry deploymentLogEntry
+		json.NewDecoder(r.Body).Decode(&entry)
+		logged <- entry
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer deploymentLog.Close()
+
+	clientset := fake.NewSimpleClientset(alertsConfigMap())
+	api := Api{Clientset: clientset, ClusterSubdomain: "nais.example.no", DeploymentHistory: history, AuditLog: NewAuditLog(), OperatorToken: "secret", DeploymentLog: DeploymentLogConfig{Url: deploymentLog.URL, Index: "deployments"}}
+
+	redeploy := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Applications without a known good deployment can not be redeployed", func(t *testing.T) {
+		rr := redeploy("/redeploy/q1/" + appName + "?namespace=" + namespace)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("The last known good spec is applied again", func(t *testing.T) {
+		rr := redeploy("/redeploy/t1/" + appName + "?namespace=" + namespace)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		deployment, err := getExistingDeployment(appName, namespace, clientset)
+		assert.NoError(t, err)
+		assert.NotNil(t, deployment)
+
+		records := history.Records()
+		assert.Len(t, records, 2)
+		assert.Equal(t, "operator", records[1].DeployedBy)
+		assert.Equal(t, rr.Header().Get("X-Deployment-Id"), records[1].DeploymentId)
+		assert.Equal(t, "redeploy", api.AuditLog.Entries()[0].Event)
+
+		select {
+		case entry := <-logged:
+			assert.Equal(t, "redeploy", entry.Action)
+			assert.Equal(t, records[1].DeploymentId, entry.DeploymentId)
+			assert.Equal(t, DeploymentSucceeded, entry.Result)
+		case <-time.After(5 * time.Second):
+			t.Error("the redeploy was not written to the deployment log")
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/nais/naisd/api/naisrequest"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RenderRequest is a deployment request, optionally with the manifest inline instead of fetched from the repository
+type RenderRequest struct {
+	naisrequest.Deploy
+	Manifest string `json:"manifest"`
+}
+
+// Render returns the Kubernetes objects naisd would create for a new deployment of the application, without
+// contacting any cluster
+func Render(deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource, clusterSubdomain string, istioEnabled bool) ([]runtime.Object, error) {
+	result, err := Simulate(deploymentRequest, manifest, naisResources, nil, clusterSubdomain, istioEnabled)
+	if err != nil {
+		return nil, err
+	}
+	return result.Objects, nil
+}
+
+// Replaces the values of rendered secrets, as anyone can render an application and its secrets come from Fasit. The
+// masks are put in stringData to be readable, like when comparing environments.
+func maskRenderedSecrets(objects []runtime.Object) {
+	for _, obj := range objects {
+		if secret, ok := obj.(*k8score.Secret); ok {
+			secret.StringData = make(map[string]string)
+			for key := range secret.Data {
+				secret.StringData[key] = "<masked>"
+			}
+			secret.Data = nil
+		}
+	}
+}
+
+// Parses an inline manifest the same way as one fetched from the repository
+func parseManifest(application string, body []byte) (NaisManifest, error) {
+	manifest, err := unmarshalManifest(body)
+	if err != nil {
+		return NaisManifest{}, fmt.Errorf("unable to unmarshal manifest: %s", err)
+	}
+	manifest.Checksum = manifestChecksum(body)
+
+	if err := AddDefaultManifestValues(&manifest, application); err != nil {
+		return NaisManifest{}, fmt.Errorf("unable to merge manifest with defaults: %s", err)
+	}
+
+	if validationErrors := ValidateManifest(manifest); len(validationErrors.Errors) != 0 {
+		return NaisManifest{}, validationErrors
+	}
+
+	return manifest, nil
+}
+
+func (api Api) render(w http.ResponseWriter, r *http.Request) *appError {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &appError{err, "unable to read render request", http.StatusBadRequest}
+	}
+
+	var request RenderRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return &appError{err, "unable to unmarshal render request", http.StatusBadRequest}
+	}
+	deploymentRequest := request.Deploy
+
+	var manifest NaisManifest
+	if len(request.Manifest) > 0 {
+		manifest, err = parseManifest(deploymentRequest.Application, []byte(request.Manifest))
+	} else {
+		manifest, err = GenerateManifestWithProfiles(deploymentRequest, api.ManifestProfiles, api.ResourceTemplates)
+	}
+	if err != nil {
+		return &appError{err, "unable to generate manifest/nais.yaml", http.StatusBadRequest}
+	}
+	manifest.DefaultEnv = api.DefaultEnv
+	if err := applyEmptyDirLimits(&manifest, api.EmptyDirLimits); err != nil {
+		return &appError{err, "manifest has emptyDirs larger than permitted", http.StatusBadRequest}
+	}
+
+	if appErr := api.resolveFasitCredentials(r, &deploymentRequest, manifest.Team); appErr != nil {
+		return appErr
+	}
+
+	var naisResources []NaisResource
+	if !deploymentRequest.SkipFasit {
+		fasit := api.fasitBackend(api.fasitClient(&deploymentRequest, api.isOperator(r)).withContext(r.Context()))
+		if err := ResolveAliasPrefixes(fasit, &manifest, deploymentRequest.FasitEnvironment, deploymentRequest.Application, deploymentRequest.Zone); err != nil {
+			return &appError{err, "unable to fetch fasit resources", http.StatusBadRequest}
+		}
+		naisResources, err = FetchFasitResources(fasit, deploymentRequest.Application, deploymentRequest.FasitEnvironment, deploymentRequest.Zone, manifest.FasitResources.Used)
+		if err != nil {
+			return &appError{err, "unable to fetch fasit resources", http.StatusBadRequest}
+		}
+	}
+
+	objects, err := Render(deploymentRequest, manifest, naisResources, api.ClusterSubdomain, api.IstioEnabled)
+	if err != nil {
+		return &appError{err, "unable to render objects", http.StatusBadRequest}
+	}
+	maskRenderedSecrets(objects)
+
+	rendered, err := MarshalObjects(objects)
+	if err != nil {
+		return &appError{err, "unable to marshal objects", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write([]byte(rendered))
+	return nil
+}
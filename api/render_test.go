@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderGoldenFiles(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: "app", Namespace: "default", Version: "1.0.0", SkipFasit: true}
+	cases, err := filepath.Glob("testdata/render/*")
+	assert.NoError(t, err)
+
+	for _, dir := range cases {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			body, err := ioutil.ReadFile(filepath.Join(dir, "nais.yaml"))
+			assert.NoError(t, err)
+
+			manifest, err := parseManifest("app", body)
+			assert.NoError(t, err)
+
+			objects, err := Render(deploymentRequest, manifest, []NaisResource{}, "nais.example.no", false)
+			assert.NoError(t, err)
+
+			rendered, err := MarshalObjects(objects)
+			assert.NoError(t, err)
+			assertGolden(t, filepath.Join(dir, "rendered.golden.yaml"), rendered)
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	t.Run("Defaults are added", func(t *testing.T) {
+		manifest, err := parseManifest("app", []byte("image: docker.adeo.no:5000/app\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 8080, manifest.Port)
+		assert.NotEmpty(t, manifest.Checksum)
+	})
+
+	t.Run("Invalid manifest gives error", func(t *testing.T) {
+		_, err := parseManifest("app", []byte("image: docker.adeo.no:5000/app:1.0.0\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestRenderEndpoint(t *testing.T) {
+	request, _ := json.Marshal(RenderRequest{
+		Deploy:   naisrequest.Deploy{Application: "app", Namespace: "default", Version: "1.0.0", SkipFasit: true},
+		Manifest: "image: docker.adeo.no:5000/app\nteam: aura\n",
+	})
+
+	req, _ := http.NewRequest("POST", "/render", strings.NewReader(string(request)))
+	rr := httptest.NewRecorder()
+	Api{ClusterSubdomain: "nais.example.no"}.Handler().ServeHTTP(rr, req)
+
+	expected, _ := ioutil.ReadFile("testdata/render/minimal/rendered.golden.yaml")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-yaml", rr.Header().Get("Content-Type"))
+	assert.Equal(t, string(expected), rr.Body.String())
+}
+
+func TestMaskRenderedSecrets(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: "app", Namespace: "default", Version: "1.0.0"}
+	manifest, err := parseManifest("app", []byte("image: docker.adeo.no:5000/app\nteam: aura\n"))
+	assert.NoError(t, err)
+	resources := []NaisResource{{name: "mydb", resourceType: "DataSource", secret: map[string]string{"password": "hunter2"}}}
+
+	objects, err := Render(deploymentRequest, manifest, resources, "nais.example.no", false)
+	assert.NoError(t, err)
+	maskRenderedSecrets(objects)
+
+	rendered, err := MarshalObjects(objects)
+	assert.NoError(t, err)
+	assert.Contains(t, rendered, "mydb_password: <masked>")
+	assert.NotContains(t, rendered, "hunter2")
+	assert.NotContains(t, rendered, "aHVudGVyMg==")
+}
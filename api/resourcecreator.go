@@ -12,6 +12,7 @@ import (
 	k8sautoscaling "k8s.io/api/autoscaling/v1"
 	k8score "k8s.io/api/core/v1"
 	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8snetworking "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,14 +27,27 @@ const (
 )
 
 type DeploymentResult struct {
-	Autoscaler      *k8sautoscaling.HorizontalPodAutoscaler
-	Ingress         *k8sextensions.Ingress
-	Deployment      *k8sextensions.Deployment
-	Secret          *k8score.Secret
-	Service         *k8score.Service
-	Redis           *redisapi.RedisFailover
-	AlertsConfigMap *k8score.ConfigMap
-	ServiceAccount  *k8score.ServiceAccount
+	Autoscaler        *k8sautoscaling.HorizontalPodAutoscaler
+	Ingress           *k8sextensions.Ingress
+	Deployment        *k8sextensions.Deployment
+	Secret            *k8score.Secret
+	Service           *k8score.Service
+	Redis             *redisapi.RedisFailover
+	AlertsConfigMap   *k8score.ConfigMap
+	ServiceAccount    *k8score.ServiceAccount
+	NetworkPolicy     *k8snetworking.NetworkPolicy
+	FeatureToggles    *k8score.ConfigMap
+	ManifestChecksum  string
+	VulnerabilityScan string
+	PreviewExpires    string
+	MirrorOf          string
+	MirrorExpires     string
+	DarkLaunchOf      string
+	DarkLaunchRoute   string
+	IngressPaused     bool
+	External          bool
+	FirewallRequests  int
+	Warnings          []string
 }
 
 // Creates a Kubernetes Service object
@@ -77,6 +91,8 @@ func createDeploymentDef(naisResources []NaisResource, manifest NaisManifest, de
 	}
 
 	if existingDeployment != nil {
+		// the selector can not be changed in apps/v1, so it stays as the deployment was created with
+		spec.Selector = existingDeployment.Spec.Selector
 		existingDeployment.Spec = spec
 		return existingDeployment, nil
 	} else {
@@ -99,25 +115,19 @@ func createDeploymentSpec(deploymentRequest naisrequest.Deploy, manifest NaisMan
 		return k8sextensions.DeploymentSpec{}, err
 	}
 
+	objectMeta := createPodObjectMetaWithAnnotations(deploymentRequest, manifest, istioEnabled)
+	for key, value := range configChecksumAnnotations(naisResources) {
+		objectMeta.Annotations[key] = value
+	}
+
 	return k8sextensions.DeploymentSpec{
-		Replicas: int32p(1),
-		Strategy: k8sextensions.DeploymentStrategy{
-			Type: k8sextensions.RollingUpdateDeploymentStrategyType,
-			RollingUpdate: &k8sextensions.RollingUpdateDeployment{
-				MaxUnavailable: &intstr.IntOrString{
-					Type:   intstr.Int,
-					IntVal: int32(0),
-				},
-				MaxSurge: &intstr.IntOrString{
-					Type:   intstr.Int,
-					IntVal: int32(1),
-				},
-			},
-		},
+		Replicas:                int32p(1),
+		Strategy:                createDeploymentStrategy(manifest),
+		MinReadySeconds:         createMinReadySeconds(manifest),
 		ProgressDeadlineSeconds: int32p(300),
 		RevisionHistoryLimit:    int32p(10),
 		Template: k8score.PodTemplateSpec{
-			ObjectMeta: createPodObjectMetaWithAnnotations(deploymentRequest, manifest, istioEnabled),
+			ObjectMeta: objectMeta,
 			Spec:       spec,
 		},
 	}, nil
@@ -153,6 +163,8 @@ func createPodSpec(deploymentRequest naisrequest.Deploy, manifest NaisManifest,
 		return k8score.PodSpec{}, err
 	}
 
+	envVars = append(envVars, createDownwardApiEnvironmentVariables(deploymentRequest.Application, manifest.DownwardApi.Env)...)
+
 	podSpec := k8score.PodSpec{
 		Containers: []k8score.Container{
 			{
@@ -161,39 +173,19 @@ func createPodSpec(deploymentRequest naisrequest.Deploy, manifest NaisManifest,
 				Ports: []k8score.ContainerPort{
 					{ContainerPort: int32(manifest.Port), Protocol: k8score.ProtocolTCP, Name: DefaultPortName},
 				},
-				Resources: createResourceLimits(manifest.Resources.Requests.Cpu, manifest.Resources.Requests.Memory, manifest.Resources.Limits.Cpu, manifest.Resources.Limits.Memory),
-				LivenessProbe: &k8score.Probe{
-					Handler: k8score.Handler{
-						HTTPGet: &k8score.HTTPGetAction{
-							Path: manifest.Healthcheck.Liveness.Path,
-							Port: intstr.FromString(DefaultPortName),
-						},
-					},
-					InitialDelaySeconds: int32(manifest.Healthcheck.Liveness.InitialDelay),
-					PeriodSeconds:       int32(manifest.Healthcheck.Liveness.PeriodSeconds),
-					FailureThreshold:    int32(manifest.Healthcheck.Liveness.FailureThreshold),
-					TimeoutSeconds:      int32(manifest.Healthcheck.Liveness.Timeout),
-				},
-				ReadinessProbe: &k8score.Probe{
-					Handler: k8score.Handler{
-						HTTPGet: &k8score.HTTPGetAction{
-							Path: manifest.Healthcheck.Readiness.Path,
-							Port: intstr.FromString(DefaultPortName),
-						},
-					},
-					InitialDelaySeconds: int32(manifest.Healthcheck.Readiness.InitialDelay),
-					PeriodSeconds:       int32(manifest.Healthcheck.Readiness.PeriodSeconds),
-					FailureThreshold:    int32(manifest.Healthcheck.Readiness.FailureThreshold),
-					TimeoutSeconds:      int32(manifest.Healthcheck.Readiness.Timeout),
-				},
+				Resources:       createResourceLimits(manifest.Resources.Requests.Cpu, manifest.Resources.Requests.Memory, manifest.Resources.Limits.Cpu, manifest.Resources.Limits.Memory),
+				LivenessProbe:   createProbe(manifest.Healthcheck.Liveness),
+				ReadinessProbe:  createProbe(manifest.Healthcheck.Readiness),
 				Env:             envVars,
 				ImagePullPolicy: k8score.PullIfNotPresent,
-				Lifecycle:       createLifeCycle(manifest.PreStopHookPath),
+				Lifecycle:       createLifeCycle(manifest.PreStopHookPath, manifest.PostStartHook),
 			},
 		},
 		ServiceAccountName: deploymentRequest.Application,
 		RestartPolicy:      k8score.RestartPolicyAlways,
-		DNSPolicy:          k8score.DNSClusterFirst,
+		DNSPolicy:          createDnsPolicy(manifest),
+		DNSConfig:          createPodDnsConfig(manifest.DnsConfig),
+		HostAliases:        createHostAliases(manifest.HostAliases),
 	}
 
 	if manifest.LeaderElection {
@@ -213,6 +205,33 @@ func createPodSpec(deploymentRequest naisrequest.Deploy, manifest NaisManifest,
 		container.VolumeMounts = append(container.VolumeMounts, createCertificateVolumeMount(deploymentRequest, naisResources))
 	}
 
+	checks, err := dependencyChecks(manifest, naisResources)
+	if err != nil {
+		return k8score.PodSpec{}, err
+	}
+	if len(checks) > 0 {
+		podSpec.InitContainers = append(podSpec.InitContainers, createDependencyCheckContainer(checks))
+	}
+
+	if hasFeatureToggles(naisResources) {
+		podSpec.Volumes = append(podSpec.Volumes, createFeatureToggleVolume(deploymentRequest.Application))
+		container := &podSpec.Containers[0]
+		container.VolumeMounts = append(container.VolumeMounts, createFeatureToggleVolumeMount())
+		container.Env = append(container.Env, createFeatureTogglePathEnvVar())
+	}
+
+	if len(manifest.DownwardApi.Files) > 0 {
+		podSpec.Volumes = append(podSpec.Volumes, createDownwardApiVolume(deploymentRequest.Application, manifest.DownwardApi.Files))
+		container := &podSpec.Containers[0]
+		container.VolumeMounts = append(container.VolumeMounts, createDownwardApiVolumeMount())
+	}
+
+	if len(manifest.EmptyDirs) > 0 {
+		podSpec.Volumes = append(podSpec.Volumes, createEmptyDirVolumes(manifest.EmptyDirs)...)
+		container := &podSpec.Containers[0]
+		container.VolumeMounts = append(container.VolumeMounts, createEmptyDirVolumeMounts(manifest.EmptyDirs)...)
+	}
+
 	return podSpec, nil
 }
 
@@ -233,19 +252,18 @@ func createLeaderElectionContainer(appName string) k8score.Container {
 	}
 }
 
-func createLifeCycle(path string) *k8score.Lifecycle {
-	if len(path) > 0 {
-		return &k8score.Lifecycle{
-			PreStop: &k8score.Handler{
-				HTTPGet: &k8score.HTTPGetAction{
-					Path: path,
-					Port: intstr.FromString(DefaultPortName),
-				},
+func createLifeCycle(preStopHookPath string, postStartHook PostStartHook) *k8score.Lifecycle {
+	lifecycle := &k8score.Lifecycle{PostStart: postStartHook.handler()}
+	if len(preStopHookPath) > 0 {
+		lifecycle.PreStop = &k8score.Handler{
+			HTTPGet: &k8score.HTTPGetAction{
+				Path: preStopHookPath,
+				Port: intstr.FromString(DefaultPortName),
 			},
 		}
 	}
 
-	return &k8score.Lifecycle{}
+	return lifecycle
 }
 
 func hasCertificate(naisResources []NaisResource) bool {
@@ -328,6 +346,9 @@ func createEnvironmentVariables(deploymentRequest naisrequest.Deploy, manifest N
 	for _, res := range naisResources {
 		for variableName, v := range res.properties {
 			envVar := k8score.EnvVar{Name: res.ToEnvironmentVariable(variableName), Value: v}
+			if isFeatureToggle(res.resourceType) {
+				envVar = createFeatureToggleEnvVar(deploymentRequest.Application, res, variableName)
+			}
 
 			if err := checkForDuplicates(envVars, envVar, variableName, res); err != nil {
 				return nil, err
@@ -395,7 +416,19 @@ func createEnvironmentVariables(deploymentRequest naisrequest.Deploy, manifest N
 		}
 	}
 
-	return envVars, nil
+	javaOpts, err := createJavaOptsEnvVar(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if javaOpts != nil {
+		// a Fasit resource can not set it either
+		if err := checkForDuplicates(envVars, *javaOpts, JavaOptsEnvVar, NaisResource{name: manifest.Runtime.Name, resourceType: "runtime"}); err != nil {
+			return nil, err
+		}
+		envVars = append(envVars, *javaOpts)
+	}
+
+	return appendManifestEnvironmentVariables(envVars, manifest)
 }
 
 func getEnvDualCase(name string) string {
@@ -572,7 +605,7 @@ func createOrUpdateK8sResources(deploymentRequest naisrequest.Deploy, manifest N
 	}
 	deploymentResult.ServiceAccount = serviceAccount
 
-	service, err := createService(deploymentRequest, manifest.Team, k8sClient)
+	service, err := createOrUpdateService(deploymentRequest, manifest.Team, manifest.SessionAffinity, k8sClient)
 	if err != nil {
 		return deploymentResult, fmt.Errorf("failed while creating service: %s", err)
 	}
@@ -592,14 +625,25 @@ func createOrUpdateK8sResources(deploymentRequest naisrequest.Deploy, manifest N
 	}
 	deploymentResult.Deployment = deployment
 
+	featureToggles, err := createOrUpdateFeatureToggleConfigMap(deploymentRequest, manifest, resources, k8sClient)
+	if err != nil {
+		return deploymentResult, fmt.Errorf("failed while creating or updating feature toggles: %s", err)
+	}
+	deploymentResult.FeatureToggles = featureToggles
+
 	secret, err := createOrUpdateSecret(deploymentRequest, resources, k8sClient, manifest.Team)
 	if err != nil {
 		return deploymentResult, fmt.Errorf("failed while creating or updating secret: %s", err)
 	}
 	deploymentResult.Secret = secret
 
-	if !manifest.Ingress.Disabled {
-		ingress, err := createOrUpdateIngress(deploymentRequest, manifest.Team, clusterSubdomain, resources, k8sClient)
+	if pauseIngress(manifest) {
+		if _, err := deleteIngress(deploymentRequest.Namespace, deploymentRequest.Application, k8sClient); err != nil {
+			return deploymentResult, fmt.Errorf("failed while pausing ingress: %s", err)
+		}
+		deploymentResult.IngressPaused = true
+	} else if !manifest.Ingress.Disabled {
+		ingress, err := createOrUpdateIngress(deploymentRequest, manifest, clusterSubdomain, resources, k8sClient)
 		if err != nil {
 			return deploymentResult, fmt.Errorf("failed while creating ingress: %s", err)
 		}
@@ -656,8 +700,8 @@ func createOrUpdateAutoscaler(deploymentRequest naisrequest.Deploy, manifest Nai
 	return createOrUpdateAutoscalerResource(autoscalerDef, deploymentRequest.Namespace, k8sClient)
 }
 
-// Returns nil,nil if ingress already exists. No reason to do update, as nothing can change
-func createOrUpdateIngress(deploymentRequest naisrequest.Deploy, teamName, clusterSubdomain string, naisResources []NaisResource, k8sClient kubernetes.Interface) (*k8sextensions.Ingress, error) {
+// Creates the ingress, or updates the rules and annotations of the existing one
+func createOrUpdateIngress(deploymentRequest naisrequest.Deploy, manifest NaisManifest, clusterSubdomain string, naisResources []NaisResource, k8sClient kubernetes.Interface) (*k8sextensions.Ingress, error) {
 	ingress, err := getExistingIngress(deploymentRequest.Application, deploymentRequest.Namespace, k8sClient)
 
 	if err != nil {
@@ -665,11 +709,12 @@ func createOrUpdateIngress(deploymentRequest naisrequest.Deploy, teamName, clust
 	}
 
 	if ingress == nil {
-		ingress = createIngressDef(deploymentRequest.Application, deploymentRequest.Namespace, teamName)
+		ingress = createIngressDef(deploymentRequest.Application, deploymentRequest.Namespace, manifest.Team)
 	}
 
 	ingress.Spec.TLS = []k8sextensions.IngressTLS{{SecretName: "istio-ingress-certs"}}
 	ingress.Spec.Rules = createIngressRules(deploymentRequest, clusterSubdomain, naisResources)
+	applyIngressAnnotations(ingress, manifest)
 	return createOrUpdateIngressResource(ingress, deploymentRequest.Namespace, k8sClient)
 }
 
@@ -694,7 +739,8 @@ func createIngressRules(deploymentRequest naisrequest.Deploy, clusterSubdomain s
 	return ingressRules
 }
 
-func createService(deploymentRequest naisrequest.Deploy, teamName string, k8sClient kubernetes.Interface) (*k8score.Service, error) {
+// Returns nil,nil if the service already exists with the session affinity of the manifest, as nothing else can change
+func createOrUpdateService(deploymentRequest naisrequest.Deploy, teamName string, sessionAffinity SessionAffinity, k8sClient kubernetes.Interface) (*k8score.Service, error) {
 	existingService, err := getExistingService(deploymentRequest.Application, deploymentRequest.Namespace, k8sClient)
 
 	if err != nil {
@@ -702,10 +748,16 @@ func createService(deploymentRequest naisrequest.Deploy, teamName string, k8sCli
 	}
 
 	if existingService != nil {
-		return nil, nil // we have done nothing
+		if !applyServiceSessionAffinity(existingService, sessionAffinity) {
+			return nil, nil // we have done nothing
+		}
+		return k8sClient.CoreV1().Services(deploymentRequest.Namespace).Update(existingService)
 	}
 
 	serviceDef := createServiceDef(deploymentRequest.Application, deploymentRequest.Namespace, teamName)
+	if sessionAffinity.Enabled {
+		applyServiceSessionAffinity(serviceDef, sessionAffinity)
+	}
 	return createServiceResource(serviceDef, deploymentRequest.Namespace, k8sClient)
 }
 
@@ -772,7 +824,7 @@ func getExistingSecret(application string, namespace string, k8sClient kubernete
 }
 
 func getExistingDeployment(application string, namespace string, k8sClient kubernetes.Interface) (*k8sextensions.Deployment, error) {
-	deploymentClient := k8sClient.ExtensionsV1beta1().Deployments(namespace)
+	deploymentClient := deployments(k8sClient, namespace)
 	deployment, err := deploymentClient.Get(application, k8smeta.GetOptions{})
 
 	switch {
@@ -786,7 +838,7 @@ func getExistingDeployment(application string, namespace string, k8sClient kuber
 }
 
 func getExistingIngress(application string, namespace string, k8sClient kubernetes.Interface) (*k8sextensions.Ingress, error) {
-	ingressClient := k8sClient.ExtensionsV1beta1().Ingresses(namespace)
+	ingressClient := ingresses(k8sClient, namespace)
 	ingress, err := ingressClient.Get(application, k8smeta.GetOptions{})
 
 	switch {
@@ -837,17 +889,17 @@ func createOrUpdateAutoscalerResource(autoscalerSpec *k8sautoscaling.HorizontalP
 
 func createOrUpdateIngressResource(ingressSpec *k8sextensions.Ingress, namespace string, k8sClient kubernetes.Interface) (*k8sextensions.Ingress, error) {
 	if ingressSpec.ObjectMeta.ResourceVersion != "" {
-		return k8sClient.ExtensionsV1beta1().Ingresses(namespace).Update(ingressSpec)
+		return ingresses(k8sClient, namespace).Update(ingressSpec)
 	} else {
-		return k8sClient.ExtensionsV1beta1().Ingresses(namespace).Create(ingressSpec)
+		return ingresses(k8sClient, namespace).Create(ingressSpec)
 	}
 }
 
 func createOrUpdateDeploymentResource(deploymentSpec *k8sextensions.Deployment, namespace string, k8sClient kubernetes.Interface) (*k8sextensions.Deployment, error) {
 	if deploymentSpec.ObjectMeta.ResourceVersion != "" {
-		return k8sClient.ExtensionsV1beta1().Deployments(namespace).Update(deploymentSpec)
+		return deployments(k8sClient, namespace).Update(deploymentSpec)
 	} else {
-		return k8sClient.ExtensionsV1beta1().Deployments(namespace).Create(deploymentSpec)
+		return deployments(k8sClient, namespace).Create(deploymentSpec)
 	}
 }
 
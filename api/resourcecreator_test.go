@@ -94,7 +94,7 @@ func TestService(t *testing.T) {
 	})
 
 	t.Run("when no service exists, a new one is created", func(t *testing.T) {
-		service, err := createService(naisrequest.Deploy{Namespace: namespace, Application: otherAppName, Version: version}, otherTeamName, clientset)
+		service, err := createOrUpdateService(naisrequest.Deploy{Namespace: namespace, Application: otherAppName, Version: version}, otherTeamName, SessionAffinity{}, clientset)
 
 		assert.NoError(t, err)
 		assert.Equal(t, otherAppName, service.ObjectMeta.Name)
@@ -104,7 +104,7 @@ func TestService(t *testing.T) {
 		assert.Equal(t, map[string]string{"app": otherAppName}, service.Spec.Selector)
 	})
 	t.Run("when service exists, nothing happens", func(t *testing.T) {
-		nilValue, err := createService(naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}, teamName, clientset)
+		nilValue, err := createOrUpdateService(naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}, teamName, SessionAffinity{}, clientset)
 		assert.NoError(t, err)
 		assert.Nil(t, nilValue)
 	})
@@ -150,18 +150,20 @@ func TestDeployment(t *testing.T) {
 			1,
 			resource1Name,
 			resource1Type,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resource1Key: resource1Value},
 			map[string]string{},
 			map[string]string{secret1Key: secret1Value},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 		{
 			1,
 			resource2Name,
 			resource2Type,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resource2Key: resource2Value},
 			map[string]string{
 				resource2Key: resource2KeyMapping,
@@ -169,12 +171,14 @@ func TestDeployment(t *testing.T) {
 			map[string]string{secret2Key: secret2Value},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 		{
 			1,
 			"resource3",
 			"applicationproperties",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{
 				"key1": "value1",
 			},
@@ -182,12 +186,14 @@ func TestDeployment(t *testing.T) {
 			map[string]string{},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 		{
 			1,
 			"resource4",
 			"applicationproperties",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{
 				"key2.Property": "dc=preprod,dc=local",
 			},
@@ -195,28 +201,34 @@ func TestDeployment(t *testing.T) {
 			map[string]string{},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 		{
 			1,
 			invalidlyNamedResourceNameDot,
 			invalidlyNamedResourceTypeDot,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{invalidlyNamedResourceKeyDot: invalidlyNamedResourceValueDot},
 			map[string]string{},
 			map[string]string{invalidlyNamedResourceSecretKeyDot: invalidlyNamedResourceSecretValueDot},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 		{
 			1,
 			invalidlyNamedResourceNameColon,
 			invalidlyNamedResourceTypeColon,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{invalidlyNamedResourceKeyColon: invalidlyNamedResourceValueColon},
 			map[string]string{},
 			map[string]string{invalidlyNamedResourceSecretKeyColon: invalidlyNamedResourceSecretValueColon},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 	}
 
@@ -225,28 +237,33 @@ func TestDeployment(t *testing.T) {
 			1,
 			resource1Name,
 			"certificate",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resource1Key: resource1Value},
 			map[string]string{},
 			map[string]string{secret1Key: secret1Value},
+			nil,
 			map[string][]byte{cert1Key: cert1Value},
 			nil,
+			ResourceMetadata{},
 		},
 		{
 			1,
 			resource2Name,
 			resource2Type,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resource2Key: resource2Value},
 			map[string]string{
 				resource2Key: resource2KeyMapping,
 			},
 			map[string]string{secret2Key: secret2Value},
+			nil,
 			map[string][]byte{cert2Key: cert2Value},
 			nil,
+			ResourceMetadata{},
 		},
 	}
 
+	secretChecksum := checksumOf(createSecretData(naisResources))
 	deployment, err := createDeploymentDef(naisResources, newDefaultManifest(), naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}, nil, false)
 
 	assert.Nil(t, err)
@@ -309,6 +326,7 @@ func TestDeployment(t *testing.T) {
 			"prometheus.io/path":      "/path",
 			"prometheus.io/port":      "http",
 			"sidecar.istio.io/inject": "true",
+			SecretChecksumAnnotation:  secretChecksum,
 		}, deployment.Spec.Template.Annotations)
 
 		env := container.Env
@@ -393,9 +411,10 @@ func TestDeployment(t *testing.T) {
 		assert.NoError(t, err)
 
 		assert.Equal(t, map[string]string{
-			"prometheus.io/scrape": "false",
-			"prometheus.io/path":   "/newPath",
-			"prometheus.io/port":   "http",
+			"prometheus.io/scrape":   "false",
+			"prometheus.io/path":     "/newPath",
+			"prometheus.io/port":     "http",
+			SecretChecksumAnnotation: secretChecksum,
 		}, updatedDeployment.Spec.Template.Annotations)
 	})
 
@@ -407,11 +426,12 @@ func TestDeployment(t *testing.T) {
 		updateDeployment, err := createOrUpdateDeployment(naisrequest.Deploy{Namespace: namespace, Application: appName, Version: version}, manifest, naisResources, false, clientset)
 		assert.NoError(t, err)
 		assert.Equal(t, map[string]string{
-			"prometheus.io/scrape": "true",
-			"prometheus.io/path":   "/path",
-			"prometheus.io/port":   "http",
-			"nais.io/logformat":    "accesslog",
-			"nais.io/logtransform": "dns_loglevel",
+			"prometheus.io/scrape":   "true",
+			"prometheus.io/path":     "/path",
+			"prometheus.io/port":     "http",
+			"nais.io/logformat":      "accesslog",
+			"nais.io/logtransform":   "dns_loglevel",
+			SecretChecksumAnnotation: secretChecksum,
 		}, updateDeployment.Spec.Template.Annotations)
 	})
 
@@ -437,12 +457,14 @@ func TestDeployment(t *testing.T) {
 				1,
 				resource1Name,
 				resource1Type,
-				Scope{"u", "u1", constant.ZONE_FSS},
+				Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
+				nil,
 				nil,
 				nil,
 				nil,
 				map[string][]byte{updatedCertKey: updatedCertValue},
 				nil,
+				ResourceMetadata{},
 			},
 		}
 
@@ -495,12 +517,14 @@ func TestDeployment(t *testing.T) {
 				1,
 				resource1Name,
 				resource1Type,
-				Scope{"u", "u1", constant.ZONE_FSS},
+				Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
+				nil,
 				nil,
 				nil,
 				nil,
 				nil,
 				nil,
+				ResourceMetadata{},
 			},
 		}
 
@@ -583,7 +607,7 @@ func TestIngress(t *testing.T) {
 	})
 
 	t.Run("when no ingress exists, a default ingress is created", func(t *testing.T) {
-		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: otherAppName}, otherTeamName, subDomain, []NaisResource{}, clientset)
+		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: otherAppName}, NaisManifest{Team: otherTeamName}, subDomain, []NaisResource{}, clientset)
 
 		assert.NoError(t, err)
 		assert.Equal(t, otherAppName, ingress.ObjectMeta.Name)
@@ -597,7 +621,7 @@ func TestIngress(t *testing.T) {
 
 	t.Run("when ingress is created in non-default namespace, hostname is postfixed with namespace", func(t *testing.T) {
 		namespace := "nondefault"
-		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: otherAppName}, teamName, subDomain, []NaisResource{}, clientset)
+		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: otherAppName}, NaisManifest{Team: teamName}, subDomain, []NaisResource{}, clientset)
 		assert.NoError(t, err)
 		assert.Equal(t, otherAppName+"-"+namespace+"."+subDomain, ingress.Spec.Rules[0].Host)
 	})
@@ -618,7 +642,7 @@ func TestIngress(t *testing.T) {
 				},
 			},
 		}
-		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: otherAppName}, teamName, subDomain, naisResources, clientset)
+		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: otherAppName}, NaisManifest{Team: teamName}, subDomain, naisResources, clientset)
 
 		assert.NoError(t, err)
 		assert.Equal(t, 3, len(ingress.Spec.Rules))
@@ -637,7 +661,7 @@ func TestIngress(t *testing.T) {
 		clientset := fake.NewSimpleClientset(ingress) //Avoid interfering with other tests in suite.
 		var naisResources []NaisResource
 
-		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: "testapp", Zone: constant.ZONE_SBS, FasitEnvironment: "testenv"}, teamName, subDomain, naisResources, clientset)
+		ingress, err := createOrUpdateIngress(naisrequest.Deploy{Namespace: namespace, Application: "testapp", Zone: constant.ZONE_SBS, FasitEnvironment: "testenv"}, NaisManifest{Team: teamName}, subDomain, naisResources, clientset)
 		rules := ingress.Spec.Rules
 
 		assert.NoError(t, err)
@@ -683,22 +707,26 @@ func TestCreateOrUpdateSecret(t *testing.T) {
 			1,
 			resource1Name,
 			resource1Type,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resource1Key: resource1Value},
 			map[string]string{},
 			map[string]string{secret1Key: secret1Value},
+			nil,
 			files1,
 			nil,
+			ResourceMetadata{},
 		}, {
 			1,
 			resource2Name,
 			resource2Type,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resource2Key: resource2Value},
 			map[string]string{},
 			map[string]string{secret2Key: secret2Value},
+			nil,
 			files2,
 			nil,
+			ResourceMetadata{},
 		},
 	}
 
@@ -739,12 +767,14 @@ func TestCreateOrUpdateSecret(t *testing.T) {
 				1,
 				resource1Name,
 				resource1Type,
-				Scope{"u", "u1", constant.ZONE_FSS},
+				Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 				nil,
 				map[string]string{},
 				map[string]string{secret1Key: updatedSecretValue},
+				nil,
 				map[string][]byte{fileKey1: updatedFileValue},
 				nil,
+				ResourceMetadata{},
 			},
 		}, clientset, teamName)
 		assert.NoError(t, err)
@@ -813,12 +843,14 @@ func TestDNS1123ValidResourceNames(t *testing.T) {
 			1,
 			"name",
 			"resourcrType",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
+			nil,
 			nil,
 			nil,
 			nil,
 			map[string][]byte{"key": []byte("value")},
 			nil,
+			ResourceMetadata{},
 		},
 	}
 
@@ -867,12 +899,14 @@ func TestCreateK8sResources(t *testing.T) {
 			1,
 			"resourceName",
 			"resourceType",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{"resourceKey": "resource1Value"},
 			nil,
 			map[string]string{"secretKey": "secretValue"},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 	}
 
@@ -902,12 +936,14 @@ func TestCreateK8sResources(t *testing.T) {
 			1,
 			"resourceName",
 			"resourceType",
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{"resourceKey": "resource1Value"},
 			map[string]string{},
 			map[string]string{},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 	}
 
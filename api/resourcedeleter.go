@@ -46,6 +46,18 @@ func deleteK8sResouces(namespace string, deployName string, k8sClient kubernetes
 		return results, err
 	}
 
+	res, err = deleteFeatureToggles(namespace, deployName, k8sClient)
+	results = append(results, res)
+	if err != nil {
+		return results, err
+	}
+
+	res, err = deleteNetworkPolicy(namespace, deployName, k8sClient)
+	results = append(results, res)
+	if err != nil {
+		return results, err
+	}
+
 	res, err = deleteConfigMapRules(namespace, deployName, k8sClient)
 	results = append(results, res)
 	if err != nil {
@@ -69,7 +81,7 @@ func deleteService(namespace string, deployName string, k8sClient kubernetes.Int
 
 func deleteDeployment(namespace string, deployName string, k8sClient kubernetes.Interface) (result string, e error) {
 	deploymentDeleteOption := k8smeta.DeletePropagationForeground
-	if err := k8sClient.ExtensionsV1beta1().Deployments(namespace).Delete(deployName, &k8smeta.DeleteOptions{PropagationPolicy: &deploymentDeleteOption}); err != nil {
+	if err := deployments(k8sClient, namespace).Delete(deployName, &k8smeta.DeleteOptions{PropagationPolicy: &deploymentDeleteOption}); err != nil {
 		return filterNotFound("deployment: ", err)
 	}
 	return "deployment: OK", nil
@@ -113,7 +125,7 @@ func deleteAutoscaler(namespace string, deployName string, k8sClient kubernetes.
 func deleteIngress(namespace string, deployName string, k8sClient kubernetes.Interface) (result string, e error) {
 	ingress, err := getExistingIngress(deployName, namespace, k8sClient)
 	if ingress != nil {
-		err = k8sClient.ExtensionsV1beta1().Ingresses(namespace).Delete(deployName, &k8smeta.DeleteOptions{})
+		err = ingresses(k8sClient, namespace).Delete(deployName, &k8smeta.DeleteOptions{})
 	}
 
 	if err != nil {
@@ -123,6 +135,20 @@ func deleteIngress(namespace string, deployName string, k8sClient kubernetes.Int
 	return "ingress OK", nil
 }
 
+func deleteFeatureToggles(namespace string, deployName string, k8sClient kubernetes.Interface) (result string, e error) {
+	if err := k8sClient.CoreV1().ConfigMaps(namespace).Delete(featureToggleConfigMapName(deployName), &k8smeta.DeleteOptions{}); err != nil {
+		return filterNotFound("feature toggles: ", err)
+	}
+	return "feature toggles: OK", nil
+}
+
+func deleteNetworkPolicy(namespace string, deployName string, k8sClient kubernetes.Interface) (result string, e error) {
+	if err := k8sClient.NetworkingV1().NetworkPolicies(namespace).Delete(deployName, &k8smeta.DeleteOptions{}); err != nil {
+		return filterNotFound("network policy: ", err)
+	}
+	return "network policy: OK", nil
+}
+
 func deleteRedisFailover(namespace string, deployName string, k8sClient kubernetes.Interface) (result string, e error) {
 	svc, err := getExistingService("rfs-"+deployName, namespace, k8sClient)
 	if svc == nil {
@@ -24,12 +24,14 @@ func TestDeleteK8sResouces(t *testing.T) {
 			1,
 			resourceName,
 			resourceType,
-			Scope{"u", "u1", constant.ZONE_FSS},
+			Scope{EnvironmentClass: "u", Environment: "u1", Zone: constant.ZONE_FSS},
 			map[string]string{resourceKey: resourceValue},
 			map[string]string{},
 			map[string]string{secretKey: secretValue},
 			nil,
 			nil,
+			nil,
+			ResourceMetadata{},
 		},
 	}
 
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// extendedResourcePayload is a resource payload with the properties of the exposed resource that naisd does not know
+// of added to the properties naisd sets itself
+type extendedResourcePayload struct {
+	ResourcePayload
+	properties map[string]string
+}
+
+func withResourceProperties(payload ResourcePayload, properties map[string]string) ResourcePayload {
+	if payload == nil || len(properties) == 0 {
+		return payload
+	}
+	return extendedResourcePayload{payload, properties}
+}
+
+func (p extendedResourcePayload) MarshalJSON() ([]byte, error) {
+	body, err := json.Marshal(p.ResourcePayload)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	properties, _ := payload["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	for key, value := range p.properties {
+		if _, ok := properties[key]; ok {
+			return nil, fmt.Errorf("property %s is set by naisd, and can not be set in properties", key)
+		}
+		properties[key] = value
+	}
+	payload["properties"] = properties
+
+	return json.Marshal(payload)
+}
+
+func validateExposedResourcePropertyMaps(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Exposed {
+		schema := resourceSchemas[strings.ToLower(resource.ResourceType)]
+		for key := range resource.Properties {
+			fields := map[string]string{"Alias": resource.Alias, "Properties": key}
+			if len(strings.TrimSpace(key)) == 0 {
+				return &ValidationError{"Properties of an exposed resource must have names", fields}
+			}
+			for _, property := range schema.payload {
+				if strings.EqualFold(property, key) {
+					fields["ResourceType"] = resource.ResourceType
+					return &ValidationError{"Property " + key + " is set by naisd from the other fields of the exposed resource, and can not be set in properties", fields}
+				}
+			}
+		}
+	}
+	return nil
+}
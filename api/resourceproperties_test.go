@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceProperties(t *testing.T) {
+	resource := ExposedResource{Alias: "myapi", ResourceType: "RestService", Path: "/api", Properties: map[string]string{"apiVersion": "v2"}}
+
+	t.Run("Properties are added to the payload", func(t *testing.T) {
+		payload, err := json.Marshal(withResourceMetadata(buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"), ResourceMetadata{ManagedBy: ManagedByNaisd}))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"alias":"myapi","metadata":{"managed-by":"naisd"},"properties":{"apiVersion":"v2","url":"https://myapp.nais.local/api"},`+
+			`"scope":{"environmentclass":"t","environment":"t1","zone":"fss"},"type":"RestService"}`, string(payload))
+	})
+
+	t.Run("Payload is left as it is without properties", func(t *testing.T) {
+		withoutProperties := resource
+		withoutProperties.Properties = nil
+		assert.IsType(t, RestResourcePayload{}, buildResourcePayload(withoutProperties, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"))
+	})
+
+	t.Run("Properties naisd sets can not be set", func(t *testing.T) {
+		exposed := func(properties map[string]string) NaisManifest {
+			withProperties := resource
+			withProperties.Properties = properties
+			return NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{withProperties}}}
+		}
+
+		assert.Nil(t, validateExposedResourcePropertyMaps(exposed(map[string]string{"apiVersion": "v2"})))
+		assert.NotNil(t, validateExposedResourcePropertyMaps(exposed(map[string]string{"URL": "https://elsewhere"})))
+		assert.NotNil(t, validateExposedResourcePropertyMaps(exposed(map[string]string{" ": "v2"})))
+
+		_, err := json.Marshal(withResourceProperties(RestResourcePayload{Properties: RestProperties{Url: "https://myapp.nais.local"}}, map[string]string{"url": "https://elsewhere"}))
+		assert.Error(t, err)
+	})
+}
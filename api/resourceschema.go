@@ -0,0 +1,133 @@
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// resourceSchema lists the properties of an exposed resource that must be set, and the ones that may be set, for
+// a resource type. Alias, ResourceType, Description, AllZones, Template and Properties may be set on every exposed
+// resource. Payload lists the properties of the Fasit resource naisd sets from them, which Properties can not set.
+type resourceSchema struct {
+	required []string
+	optional []string
+	payload  []string
+}
+
+// resourceSchemas are keyed by the lower case name of the resource type
+var resourceSchemas = map[string]resourceSchema{
+	// Fasit does not register a security token for a RestService
+	"restservice": {
+		optional: []string{"Path", "Paths"},
+		payload:  []string{"url", "description"},
+	},
+	"webserviceendpoint": {
+		required: []string{"WsdlGroupId", "WsdlArtifactId", "WsdlVersion"},
+		optional: []string{"Path", "Paths", "SecurityToken"},
+		payload:  []string{"endpointUrl", "wsdlUrl", "securityToken", "description"},
+	},
+	"webservicegateway": {
+		required: []string{"Gateway", "Endpoint"},
+		optional: []string{"Path", "SecurityToken"},
+		payload:  []string{"url", "webserviceEndpoint", "securityToken", "description"},
+	},
+	"datasource": {
+		required: []string{"Url", "Username", "PasswordSecret"},
+		optional: []string{"PasswordKey"},
+		payload:  []string{"url", "username", "description"},
+	},
+	"credential": {
+		required: []string{"Username", "PasswordSecret"},
+		optional: []string{"PasswordKey"},
+		payload:  []string{"username", "description"},
+	},
+	"queue": {
+		required: []string{"QueueName", "QueueManager"},
+		payload:  []string{"queueName", "queueManager", "description"},
+	},
+	"topic": {
+		required: []string{"TopicString", "QueueManager"},
+		payload:  []string{"topicString", "queueManager", "description"},
+	},
+	"channel": {
+		required: []string{"ChannelName", "QueueManager"},
+		payload:  []string{"name", "queueManager", "description"},
+	},
+	"queuemanager": {
+		required: []string{"QueueManager", "Hostname", "Port"},
+		payload:  []string{"name", "hostname", "port", "description"},
+	},
+}
+
+// exposedResourceProperties returns the properties that are set on the resource, by field name
+func exposedResourceProperties(resource ExposedResource) map[string]string {
+	properties := map[string]string{
+		"Path":           resource.Path,
+		"Paths":          strings.Join(resource.Paths, ","),
+		"WsdlGroupId":    resource.WsdlGroupId,
+		"WsdlArtifactId": resource.WsdlArtifactId,
+		"WsdlVersion":    resource.WsdlVersion,
+		"SecurityToken":  resource.SecurityToken,
+		"QueueName":      resource.QueueName,
+		"TopicString":    resource.TopicString,
+		"ChannelName":    resource.ChannelName,
+		"QueueManager":   resource.QueueManager,
+		"Hostname":       resource.Hostname,
+		"Url":            resource.Url,
+		"Username":       resource.Username,
+		"PasswordSecret": resource.PasswordSecret,
+		"PasswordKey":    resource.PasswordKey,
+		"Gateway":        resource.Gateway,
+		"Endpoint":       resource.Endpoint,
+	}
+	if resource.Port != 0 {
+		properties["Port"] = strconv.Itoa(resource.Port)
+	}
+
+	for name, value := range properties {
+		if len(value) == 0 {
+			delete(properties, name)
+		}
+	}
+	return properties
+}
+
+// validateExposedResourceProperties checks the exposed resources against the schema of their type, so a resource
+// missing a property Fasit needs, e.g. the WSDL of a WebserviceEndpoint, is rejected before anything is written to
+// Fasit. The fields of the error are the properties that are missing, with empty values, or not permitted.
+func validateExposedResourceProperties(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Exposed {
+		schema, ok := resourceSchemas[strings.ToLower(resource.ResourceType)]
+		if !ok {
+			continue
+		}
+
+		properties := exposedResourceProperties(resource)
+		fields := map[string]string{"Alias": resource.Alias, "ResourceType": resource.ResourceType}
+
+		var missing []string
+		for _, name := range schema.required {
+			if _, ok := properties[name]; !ok {
+				missing = append(missing, name)
+				fields[name] = ""
+			}
+		}
+		if len(missing) > 0 {
+			return &ValidationError{strings.Join(missing, ", ") + " must be specified for an exposed resource of type " + resource.ResourceType, fields}
+		}
+
+		var unexpected []string
+		for name, value := range properties {
+			if !contains(schema.required, name) && !contains(schema.optional, name) {
+				unexpected = append(unexpected, name)
+				fields[name] = value
+			}
+		}
+		if len(unexpected) > 0 {
+			sort.Strings(unexpected)
+			return &ValidationError{strings.Join(unexpected, ", ") + " can not be set on an exposed resource of type " + resource.ResourceType, fields}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExposedResourceProperties(t *testing.T) {
+	exposed := func(resource ExposedResource) NaisManifest {
+		return NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{resource}}}
+	}
+
+	t.Run("Resources with the properties of their type are valid", func(t *testing.T) {
+		assert.Nil(t, validateExposedResourceProperties(exposed(ExposedResource{Alias: "api", ResourceType: "RestService", Path: "/api", Description: "API"})))
+		assert.Nil(t, validateExposedResourceProperties(exposed(ExposedResource{Alias: "ws", ResourceType: "webserviceendpoint", Path: "/ws", WsdlGroupId: "no.nav", WsdlArtifactId: "ws", WsdlVersion: "1.0"})))
+		assert.Nil(t, validateExposedResourceProperties(exposed(ExposedResource{Alias: "other", ResourceType: "LDAP", Url: "ldap://ldap.local"})), "unknown types are left to validateResources")
+	})
+
+	t.Run("Missing properties are listed", func(t *testing.T) {
+		err := validateExposedResourceProperties(exposed(ExposedResource{Alias: "ws", ResourceType: "WebserviceEndpoint", WsdlArtifactId: "ws"}))
+		assert.Equal(t, "WsdlGroupId, WsdlVersion must be specified for an exposed resource of type WebserviceEndpoint", err.ErrorMessage)
+		assert.Equal(t, map[string]string{"Alias": "ws", "ResourceType": "WebserviceEndpoint", "WsdlGroupId": "", "WsdlVersion": ""}, err.Fields)
+	})
+
+	t.Run("Properties of other types are rejected", func(t *testing.T) {
+		err := validateExposedResourceProperties(exposed(ExposedResource{Alias: "api", ResourceType: "RestService", Path: "/api", WsdlGroupId: "no.nav", QueueName: "Q"}))
+		assert.Equal(t, "QueueName, WsdlGroupId can not be set on an exposed resource of type RestService", err.ErrorMessage)
+		assert.Equal(t, "no.nav", err.Fields["WsdlGroupId"])
+
+		err = validateExposedResourceProperties(exposed(ExposedResource{Alias: "api", ResourceType: "RestService", Path: "/api", SecurityToken: "SAML"}))
+		assert.Equal(t, "SecurityToken can not be set on an exposed resource of type RestService", err.ErrorMessage)
+	})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Exposed resources using a template only give their alias and path or paths. Everything else comes from the template,
+// so applications exposing the same kind of resource describe and secure it the same way.
+func applyResourceTemplates(manifest *NaisManifest, templates map[string]ExposedResource) error {
+	for i, resource := range manifest.FasitResources.Exposed {
+		if len(resource.Template) == 0 {
+			continue
+		}
+
+		template, ok := templates[resource.Template]
+		if !ok {
+			return fmt.Errorf("exposed resource %s uses template %s, which does not exist", resource.Alias, resource.Template)
+		}
+
+		overrides := resource
+		overrides.Alias, overrides.Path, overrides.Paths, overrides.Template = "", "", nil, ""
+		if !reflect.DeepEqual(overrides, ExposedResource{}) {
+			return fmt.Errorf("exposed resource %s uses template %s, and can only set alias and path or paths", resource.Alias, resource.Template)
+		}
+
+		applied := template
+		applied.Alias = resource.Alias
+		applied.Path = resource.Path
+		applied.Paths = resource.Paths
+		applied.Template = resource.Template
+		manifest.FasitResources.Exposed[i] = applied
+	}
+
+	return nil
+}
+
+func validateResourceTemplates(templates map[string]ExposedResource) error {
+	for name, template := range templates {
+		if len(template.ResourceType) == 0 {
+			return fmt.Errorf("exposed resource template %s has no resourceType", name)
+		}
+		if len(template.Alias) > 0 || len(template.Template) > 0 {
+			return fmt.Errorf("exposed resource template %s can not set alias or template", name)
+		}
+	}
+
+	return nil
+}
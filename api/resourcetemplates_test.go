@@ -0,0 +1,59 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestApplyResourceTemplates(t *testing.T) {
+	templates := map[string]ExposedResource{
+		"internal-rest": {ResourceType: "RestService", Description: "Internal REST API", AllZones: true},
+	}
+
+	t.Run("Template is applied with alias and path from the manifest", func(t *testing.T) {
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{
+			{Alias: "app-api", Path: "/api", Template: "internal-rest"},
+			{Alias: "other", ResourceType: "RestService", Path: "/other"},
+		}}}
+
+		assert.NoError(t, applyResourceTemplates(&manifest, templates))
+		assert.Equal(t, ExposedResource{Alias: "app-api", ResourceType: "RestService", Path: "/api", Description: "Internal REST API", AllZones: true, Template: "internal-rest"}, manifest.FasitResources.Exposed[0])
+		assert.Equal(t, ExposedResource{Alias: "other", ResourceType: "RestService", Path: "/other"}, manifest.FasitResources.Exposed[1])
+	})
+
+	t.Run("Unknown templates are an error", func(t *testing.T) {
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "app-api", Template: "missing"}}}}
+
+		assert.EqualError(t, applyResourceTemplates(&manifest, templates), "exposed resource app-api uses template missing, which does not exist")
+	})
+
+	t.Run("Resources using a template can not override it", func(t *testing.T) {
+		manifest := NaisManifest{FasitResources: FasitResources{Exposed: []ExposedResource{{Alias: "app-api", Template: "internal-rest", Description: "Mine"}}}}
+
+		assert.EqualError(t, applyResourceTemplates(&manifest, templates), "exposed resource app-api uses template internal-rest, and can only set alias and path or paths")
+	})
+}
+
+func TestValidateResourceTemplates(t *testing.T) {
+	assert.NoError(t, validateResourceTemplates(map[string]ExposedResource{"rest": {ResourceType: "RestService"}}))
+	assert.Error(t, validateResourceTemplates(map[string]ExposedResource{"rest": {Description: "no type"}}))
+	assert.Error(t, validateResourceTemplates(map[string]ExposedResource{"rest": {ResourceType: "RestService", Alias: "fixed"}}))
+}
+
+func TestGenerateManifestWithResourceTemplates(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://repo.local").
+		Get("/app/nais.yaml").
+		Reply(200).
+		BodyString("fasitResources:\n  exposed:\n  - alias: app-api\n    path: /api\n    template: internal-rest\n")
+
+	templates := map[string]ExposedResource{"internal-rest": {ResourceType: "RestService", Description: "Internal REST API"}}
+	manifest, err := GenerateManifestWithProfiles(naisrequest.Deploy{Application: "app", ManifestUrl: "https://repo.local/app/nais.yaml"}, nil, templates)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "RestService", manifest.FasitResources.Exposed[0].ResourceType)
+	assert.Equal(t, "Internal REST API", manifest.FasitResources.Exposed[0].Description)
+}
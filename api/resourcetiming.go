@@ -0,0 +1,48 @@
+package api
+
+import "time"
+
+// ResourceTiming is how long a Fasit resource used by a deployment took to resolve. Lookup is the request for the
+// resource itself, and downloads the secrets and certificates it refers to. Cached resources were not asked for.
+type ResourceTiming struct {
+	Alias        string `json:"alias"`
+	ResourceType string `json:"type"`
+	Duration     string `json:"duration"`
+	Lookup       string `json:"lookup"`
+	Downloads    string `json:"downloads,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Cached       bool   `json:"cached,omitempty"`
+}
+
+func newResourceTiming(request ResourceRequest, lookup, downloads time.Duration, err error) ResourceTiming {
+	timing := ResourceTiming{
+		Alias:        request.Alias,
+		ResourceType: request.ResourceType,
+		Duration:     (lookup + downloads).String(),
+		Lookup:       lookup.String(),
+	}
+	if downloads > 0 {
+		timing.Downloads = downloads.String()
+	}
+	if err != nil {
+		timing.Error = err.Error()
+	}
+	return timing
+}
+
+// resolved adds the timing of a resource to the deployment, shown by GET /deploy/<id>
+func (deployment *trackedDeployment) resolved(timing ResourceTiming) {
+	if deployment == nil {
+		return
+	}
+
+	// resources are resolved concurrently, and the tracker reads them while the deployment runs
+	if deployment.tracker != nil {
+		deployment.tracker.lock()
+		defer deployment.tracker.mutex.Unlock()
+	} else {
+		deployment.mutex.Lock()
+		defer deployment.mutex.Unlock()
+	}
+	deployment.Resources = append(deployment.Resources, timing)
+}
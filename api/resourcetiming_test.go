@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestNewResourceTiming(t *testing.T) {
+	request := ResourceRequest{Alias: "mydb", ResourceType: "datasource"}
+
+	assert.Equal(t, ResourceTiming{Alias: "mydb", ResourceType: "datasource", Duration: "1.5s", Lookup: "1s", Downloads: "500ms"}, newResourceTiming(request, time.Second, 500*time.Millisecond, nil))
+	assert.Equal(t, ResourceTiming{Alias: "mydb", ResourceType: "datasource", Duration: "1s", Lookup: "1s", Error: "unable to get resource (404)"}, newResourceTiming(request, time.Second, 0, appError{nil, "unable to get resource", 404}))
+}
+
+func TestResourceTimingInDeploymentStatus(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "mydb").
+		Reply(200).File("testdata/fasitResponse.json")
+	gock.New("https://fasit.local").
+		Get("/api/v2/scopedresource").
+		MatchParam("alias", "missing").
+		Reply(404).BodyString("not found")
+
+	tracker := NewDeploymentTracker()
+	deployment, _ := tracker.start(context.Background(), naisrequest.Deploy{Application: "app", Namespace: "default"}, 0)
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}.forDeployment(deployment)
+
+	_, appErr := fasit.GetScopedResource(ResourceRequest{Alias: "mydb", ResourceType: "datasource"}, "t1", "app", "fss")
+	assert.Nil(t, appErr)
+	_, appErr = fasit.GetScopedResource(ResourceRequest{Alias: "missing", ResourceType: "baseurl"}, "t1", "app", "fss")
+	assert.NotNil(t, appErr)
+
+	status, ok := tracker.Get(deployment.Id)
+	assert.True(t, ok)
+	assert.Len(t, status.Resources, 2)
+	assert.Equal(t, "mydb", status.Resources[0].Alias)
+	assert.Empty(t, status.Resources[0].Error)
+	assert.NotEmpty(t, status.Resources[0].Duration)
+	assert.Equal(t, "missing", status.Resources[1].Alias)
+	assert.NotEmpty(t, status.Resources[1].Error)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// ResourceUsage lists the applications using and exposing a Fasit alias in an environment.
+// Exposed resources with no users are candidates for removal.
+type ResourceUsage struct {
+	Alias        string   `json:"alias"`
+	ResourceType string   `json:"resourceType"`
+	Environment  string   `json:"environment"`
+	UsedBy       []string `json:"usedBy"`
+	ExposedBy    []string `json:"exposedBy"`
+}
+
+// Aggregates the Fasit resources of the latest deployment of every application
+func resourceUsage(records []DeploymentRecord) []ResourceUsage {
+	usages := make(map[string]*ResourceUsage)
+
+	usage := func(alias, resourceType, environment string) *ResourceUsage {
+		key := environment + "/" + resourceType + "/" + alias
+		if _, ok := usages[key]; !ok {
+			usages[key] = &ResourceUsage{Alias: alias, ResourceType: resourceType, Environment: environment, UsedBy: []string{}, ExposedBy: []string{}}
+		}
+		return usages[key]
+	}
+
+	for _, record := range records {
+		application := record.Namespace + "/" + record.Application
+		for _, used := range record.FasitResources.Used {
+			u := usage(used.Alias, used.ResourceType, record.Environment)
+			u.UsedBy = append(u.UsedBy, application)
+		}
+		for _, exposed := range record.FasitResources.Exposed {
+			u := usage(exposed.Alias, exposed.ResourceType, record.Environment)
+			u.ExposedBy = append(u.ExposedBy, application)
+		}
+	}
+
+	result := []ResourceUsage{}
+	for _, u := range usages {
+		sort.Strings(u.UsedBy)
+		sort.Strings(u.ExposedBy)
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Environment != b.Environment {
+			return a.Environment < b.Environment
+		}
+		if a.ResourceType != b.ResourceType {
+			return a.ResourceType < b.ResourceType
+		}
+		return a.Alias < b.Alias
+	})
+
+	return result
+}
+
+func (api Api) resourceUsageReport(w http.ResponseWriter, r *http.Request) *appError {
+	environment := r.URL.Query().Get("environment")
+	alias := r.URL.Query().Get("alias")
+
+	report := []ResourceUsage{}
+	for _, usage := range resourceUsage(api.DeploymentHistory.Latest()) {
+		if (len(environment) == 0 || usage.Environment == environment) && (len(alias) == 0 || usage.Alias == alias) {
+			report = append(report, usage)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+
+	return nil
+}
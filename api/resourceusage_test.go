@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceUsage(t *testing.T) {
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "t1", FasitResources: FasitResources{
+		Used: []UsedResource{{Alias: "appDB", ResourceType: "DataSource"}, {Alias: "otherService", ResourceType: "RestService"}},
+	}})
+	history.Add(DeploymentRecord{Application: "other", Namespace: "default", Environment: "t1", FasitResources: FasitResources{
+		Exposed: []ExposedResource{{Alias: "otherService", ResourceType: "RestService"}, {Alias: "unusedService", ResourceType: "RestService"}},
+	}})
+	history.Add(DeploymentRecord{Application: "batch", Namespace: "default", Environment: "t1", FasitResources: FasitResources{
+		Used: []UsedResource{{Alias: "appDB", ResourceType: "DataSource"}},
+	}})
+	history.Add(DeploymentRecord{Application: "app", Namespace: "default", Environment: "q1", FasitResources: FasitResources{
+		Used: []UsedResource{{Alias: "appDB", ResourceType: "DataSource"}},
+	}})
+
+	t.Run("Usage is aggregated per environment and alias", func(t *testing.T) {
+		usage := resourceUsage(history.Latest())
+
+		assert.Equal(t, 4, len(usage))
+		assert.Equal(t, ResourceUsage{"appDB", "DataSource", "q1", []string{"default/app"}, []string{}}, usage[0])
+		assert.Equal(t, ResourceUsage{"appDB", "DataSource", "t1", []string{"default/app", "default/batch"}, []string{}}, usage[1])
+		assert.Equal(t, ResourceUsage{"otherService", "RestService", "t1", []string{"default/app"}, []string{"default/other"}}, usage[2])
+		assert.Equal(t, ResourceUsage{"unusedService", "RestService", "t1", []string{}, []string{"default/other"}}, usage[3])
+	})
+
+	t.Run("Report can be filtered on environment and alias", func(t *testing.T) {
+		api := Api{DeploymentHistory: history}
+
+		req, _ := http.NewRequest("GET", "/report/resource-usage?environment=t1&alias=appDB", nil)
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+
+		var report []ResourceUsage
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		assert.Equal(t, 1, len(report))
+		assert.Equal(t, []string{"default/app", "default/batch"}, report[0].UsedBy)
+	})
+}
@@ -0,0 +1,469 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+)
+
+const (
+	defaultRolloutInterval = 30 * time.Second
+	maxFinishedRollouts    = 100
+)
+
+// rolloutAdmissionPoll is how often a rollout held back by the load shedder checks whether it can go on
+var rolloutAdmissionPoll = loadSheddingRetryAfter * time.Second
+
+// RolloutTarget is the progress of a rollout for one application
+type RolloutTarget struct {
+	Environment  string `json:"environment"`
+	Namespace    string `json:"namespace"`
+	Application  string `json:"application"`
+	Version      string `json:"version"`
+	Status       string `json:"status"`
+	DeploymentId string `json:"deploymentId,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// Rollout is a bulk redeploy naisd is running or has recently finished. Completed counts the targets that have been
+// deployed, failed or skipped.
+type Rollout struct {
+	Id          string          `json:"id"`
+	RequestedBy string          `json:"requestedBy,omitempty"`
+	Started     time.Time       `json:"started"`
+	Interval    string          `json:"interval"`
+	Status      string          `json:"status"`
+	Total       int             `json:"total"`
+	Completed   int             `json:"completed"`
+	Failed      int             `json:"failed"`
+	Targets     []RolloutTarget `json:"targets"`
+}
+
+type rolloutRun struct {
+	Rollout
+	request  naisrequest.Rollout
+	interval time.Duration
+	records  []DeploymentRecord
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Rollouts keeps the rollouts in progress and the most recently finished ones
+type Rollouts struct {
+	mutex    sync.Mutex
+	rollouts map[string]*rolloutRun
+	finished []string
+}
+
+func NewRollouts() *Rollouts {
+	return &Rollouts{rollouts: make(map[string]*rolloutRun)}
+}
+
+// rolloutMatches is true if the record is selected by every selector field that is set
+func rolloutMatches(request naisrequest.Rollout, record DeploymentRecord) bool {
+	if len(request.Environment) > 0 && request.Environment != record.Environment {
+		return false
+	}
+	if len(request.Namespace) > 0 && request.Namespace != record.Namespace {
+		return false
+	}
+	if len(request.Team) > 0 && request.Team != record.Team {
+		return false
+	}
+	if len(request.Applications) == 0 {
+		return true
+	}
+	for _, application := range request.Applications {
+		if application == record.Application {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rollouts) start(request naisrequest.Rollout, records []DeploymentRecord) *rolloutRun {
+	interval := defaultRolloutInterval
+	if len(request.Interval) > 0 {
+		interval, _ = time.ParseDuration(request.Interval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &rolloutRun{
+		Rollout: Rollout{
+			Id:          newDeploymentId(),
+			RequestedBy: request.RequestedBy,
+			Started:     time.Now(),
+			Interval:    interval.String(),
+			Status:      DeploymentInProgress,
+			Total:       len(records),
+		},
+		request:  request,
+		interval: interval,
+		records:  records,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, record := range records {
+		run.Targets = append(run.Targets, RolloutTarget{
+			Environment: record.Environment,
+			Namespace:   record.Namespace,
+			Application: record.Application,
+			Version:     record.Version,
+			Status:      StagePending,
+		})
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rollouts[run.Id] = run
+	return run
+}
+
+func (r *Rollouts) Get(id string) (Rollout, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	run, ok := r.rollouts[id]
+	if !ok {
+		return Rollout{}, false
+	}
+	return run.snapshot(), true
+}
+
+func (run *rolloutRun) snapshot() Rollout {
+	snapshot := run.Rollout
+	snapshot.Targets = make([]RolloutTarget, len(run.Targets))
+	copy(snapshot.Targets, run.Targets)
+	return snapshot
+}
+
+func (r *Rollouts) snapshot(run *rolloutRun) Rollout {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return run.snapshot()
+}
+
+// setTarget records the progress of a target, counting it as completed once it is no longer pending or deploying
+func (r *Rollouts) setTarget(run *rolloutRun, i int, status, deploymentId, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	target := &run.Targets[i]
+	target.Status = status
+	target.DeploymentId = deploymentId
+	target.Message = message
+
+	switch status {
+	case DeploymentFailed:
+		run.Failed++
+		run.Completed++
+	case DeploymentSucceeded, StageSkipped:
+		run.Completed++
+	}
+}
+
+// finish records the outcome of the rollout. Targets that were never reached are skipped.
+func (r *Rollouts) finish(run *rolloutRun, status string) {
+	run.cancel()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	run.Status = status
+	for i := range run.Targets {
+		if run.Targets[i].Status == StagePending {
+			run.Targets[i].Status = StageSkipped
+			run.Completed++
+		}
+	}
+
+	r.finished = append(r.finished, run.Id)
+	if len(r.finished) > maxFinishedRollouts {
+		delete(r.rollouts, r.finished[0])
+		r.finished = r.finished[1:]
+	}
+}
+
+// Cancel stops the rollout before its next application. An application being deployed is left to finish.
+func (r *Rollouts) Cancel(id string) (Rollout, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	run, ok := r.rollouts[id]
+	if !ok {
+		return Rollout{}, fmt.Errorf("rollout %s not found", id)
+	}
+	if run.Status != DeploymentInProgress {
+		return run.snapshot(), fmt.Errorf("rollout %s is %s", id, run.Status)
+	}
+
+	run.cancel()
+	return run.snapshot(), nil
+}
+
+// Runs returns the rollouts naisd keeps, in the order they were started
+func (r *Rollouts) Runs() []Rollout {
+	rollouts := []Rollout{}
+	if r == nil {
+		return rollouts
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, run := range r.rollouts {
+		rollouts = append(rollouts, run.snapshot())
+	}
+	sort.SliceStable(rollouts, func(i, j int) bool { return rollouts[i].Started.Before(rollouts[j].Started) })
+	return rollouts
+}
+
+// inProgress counts the rollouts that have applications left to deploy
+func (r *Rollouts) inProgress() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := 0
+	for _, run := range r.rollouts {
+		if run.Status == DeploymentInProgress {
+			count++
+		}
+	}
+	return count
+}
+
+// Replace replaces the rollouts with imported ones. Rollouts that were in progress were run by another naisd, and can
+// not go on here, so they are cancelled, skipping the applications they had not deployed.
+func (r *Rollouts) Replace(rollouts []Rollout) {
+	if r == nil {
+		return
+	}
+
+	runs := make(map[string]*rolloutRun)
+	var finished []string
+	for _, imported := range rollouts {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		run := &rolloutRun{Rollout: imported, ctx: ctx, cancel: cancel}
+		run.Targets = make([]RolloutTarget, len(imported.Targets))
+		copy(run.Targets, imported.Targets)
+
+		if run.Status == DeploymentInProgress {
+			run.Status = DeploymentCancelled
+			for i := range run.Targets {
+				switch run.Targets[i].Status {
+				case StagePending, StageDeploying:
+					run.Targets[i].Status = StageSkipped
+					run.Targets[i].Message = interruptedByStateImport
+					run.Completed++
+				}
+			}
+		}
+
+		runs[run.Id] = run
+		finished = append(finished, run.Id)
+	}
+	if len(finished) > maxFinishedRollouts {
+		for _, id := range finished[:len(finished)-maxFinishedRollouts] {
+			delete(runs, id)
+		}
+		finished = finished[len(finished)-maxFinishedRollouts:]
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rollouts = runs
+	r.finished = finished
+}
+
+// refreshSpec returns a copy of the spec with the current default environment variables, and with its used resources
+// fetched from Fasit again if refreshFasit is set, so rotated secrets and certificates are deployed
+func (api Api) refreshSpec(ctx context.Context, spec *deploymentSpec, refreshFasit bool) (*deploymentSpec, error) {
+	refreshed := *spec
+	refreshed.manifest.DefaultEnv = api.DefaultEnv
+
+	if !refreshFasit || spec.request.SkipFasit {
+		return &refreshed, nil
+	}
+
+	// rollouts are started by the operator, who acts for every team
+	deploymentRequest := spec.request
+	if appErr := api.readFasitCredentials(&deploymentRequest, spec.manifest.Team); appErr != nil {
+		return nil, appErr
+	}
+	fasit := api.fasitBackend(api.fasitClient(&deploymentRequest, true).withContext(ctx).withoutCache())
+
+	resources, err := FetchFasitResources(fasit, deploymentRequest.Application, deploymentRequest.FasitEnvironment, deploymentRequest.Zone, spec.manifest.FasitResources.Used)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch fasit resources: %s", err)
+	}
+	refreshed.resources = resources
+	return &refreshed, nil
+}
+
+// runRollout redeploys the targets one at a time, waiting the interval between deployments. A failed target does not stop
+// the rollout, so one broken application does not hold back the rest.
+func (api Api) runRollout(run *rolloutRun) {
+	deployed := false
+	for i, record := range run.records {
+		if record.spec == nil {
+			api.Rollouts.setTarget(run, i, StageSkipped, "", "the latest deployment can not be applied again")
+			continue
+		}
+
+		if deployed {
+			select {
+			case <-time.After(run.interval):
+			case <-run.ctx.Done():
+			}
+		}
+		if !api.awaitRolloutAdmission(run, i, record) {
+			api.Rollouts.finish(run, DeploymentCancelled)
+			return
+		}
+		deployed = true
+
+		api.Rollouts.setTarget(run, i, StageDeploying, "", "")
+		deploymentId, err := api.rolloutTarget(run, record)
+		if err != nil {
+			glog.Warningf("Rollout %s failed to redeploy %s to %s in %s: %s", run.Id, record.Application, record.Namespace, record.Environment, err)
+			api.Rollouts.setTarget(run, i, DeploymentFailed, deploymentId, err.Error())
+			continue
+		}
+		api.Rollouts.setTarget(run, i, DeploymentSucceeded, deploymentId, "")
+	}
+
+	api.Rollouts.finish(run, DeploymentSucceeded)
+}
+
+// awaitRolloutAdmission holds the rollout while the load shedder would turn a deployment to the target's namespace away,
+// as when an operator has paused deployments, checking again every rolloutAdmissionPoll. It is false if the rollout is
+// cancelled before the target is admitted.
+func (api Api) awaitRolloutAdmission(run *rolloutRun, i int, record DeploymentRecord) bool {
+	for run.ctx.Err() == nil {
+		err := api.LoadShedder.admit(record.Namespace, api.Status.DeploymentsInProgress())
+		if err == nil {
+			return true
+		}
+
+		api.Rollouts.setTarget(run, i, StagePending, "", err.Error())
+		select {
+		case <-time.After(rolloutAdmissionPoll):
+		case <-run.ctx.Done():
+		}
+	}
+	return false
+}
+
+// rolloutTarget redeploys one application of the rollout. Cancelling the rollout stops the Fasit refresh and the
+// redeploy before anything is applied to Kubernetes, so an application is never left half deployed.
+func (api Api) rolloutTarget(run *rolloutRun, record DeploymentRecord) (string, error) {
+	spec, err := api.refreshSpec(run.ctx, record.spec, run.request.RefreshFasit)
+	if err != nil {
+		return "", err
+	}
+
+	deployedBy := run.RequestedBy
+	if len(deployedBy) == 0 {
+		deployedBy = "operator"
+	}
+
+	deploymentId, _, appErr := api.redeployRecord(run.ctx, record, spec, "rollout", deployedBy)
+	if appErr != nil {
+		return deploymentId, appErr
+	}
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "rollout",
+		Application: record.Application,
+		Namespace:   record.Namespace,
+		Version:     record.Version,
+		Details:     map[string]string{"environment": record.Environment, "from": record.DeploymentId, "rollout": run.Id, "refreshFasit": fmt.Sprint(run.request.RefreshFasit)},
+	})
+	return deploymentId, nil
+}
+
+// startRollout redeploys the applications matching the selector from the deployment history at the requested pace,
+// returning the rollout to follow its progress with
+func (api Api) startRollout(w http.ResponseWriter, r *http.Request) *appError {
+	var request naisrequest.Rollout
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return &appError{err, "unable to unmarshal rollout", http.StatusBadRequest}
+	}
+
+	if errs := request.Validate(); len(errs) > 0 {
+		var messages []string
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return &appError{fmt.Errorf("%s", strings.Join(messages, ", ")), "invalid rollout", http.StatusBadRequest}
+	}
+
+	var records []DeploymentRecord
+	for _, record := range api.DeploymentHistory.Latest() {
+		if rolloutMatches(request, record) {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return &appError{fmt.Errorf("no successful deployments match the selector"), "nothing to roll out", http.StatusNotFound}
+	}
+
+	run := api.Rollouts.start(request, records)
+	glog.Infof("Starting rollout %s of %d applications, one every %s", run.Id, len(records), run.interval)
+	api.AuditLog.Record(AuditEntry{
+		Event:   "rollout_started",
+		Details: map[string]string{"id": run.Id, "applications": fmt.Sprint(len(records)), "interval": run.Interval, "requestedBy": run.RequestedBy},
+	})
+	go api.runRollout(run)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(api.Rollouts.snapshot(run)); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (api Api) getRollout(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	rollout, ok := api.Rollouts.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("rollout %s not found", id), "rollout not found", http.StatusNotFound}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rollout); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (api Api) cancelRollout(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	if _, ok := api.Rollouts.Get(id); !ok {
+		return &appError{fmt.Errorf("rollout %s not found", id), "rollout not found", http.StatusNotFound}
+	}
+
+	if _, err := api.Rollouts.Cancel(id); err != nil {
+		return &appError{err, "unable to cancel rollout", http.StatusConflict}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRolloutMatches(t *testing.T) {
+	record := DeploymentRecord{Application: appName, Namespace: namespace, Environment: "t1", Team: teamName}
+
+	assert.True(t, rolloutMatches(naisrequest.Rollout{Environment: "t1"}, record))
+	assert.True(t, rolloutMatches(naisrequest.Rollout{Team: teamName, Applications: []string{"other", appName}}, record))
+	assert.False(t, rolloutMatches(naisrequest.Rollout{Environment: "t1", Namespace: "other"}, record))
+	assert.False(t, rolloutMatches(naisrequest.Rollout{Applications: []string{"other"}}, record))
+}
+
+func TestRollout(t *testing.T) {
+	rolloutRecord := func(application, environment, version string) DeploymentRecord {
+		deploymentRequest := naisrequest.Deploy{Application: application, Namespace: namespace, Version: version, FasitEnvironment: environment, Zone: "fss"}
+		return DeploymentRecord{
+			DeploymentId: application + "-" + environment,
+			Application:  application,
+			Namespace:    namespace,
+			Environment:  environment,
+			Version:      version,
+			Team:         teamName,
+			spec:         newDeploymentSpec(deploymentRequest, newDefaultManifest(), []NaisResource{}),
+		}
+	}
+
+	newApi := func() Api {
+		history := NewDeploymentHistory()
+		history.Add(rolloutRecord("first", "t1", "1"))
+		history.Add(rolloutRecord("second", "t1", "2"))
+		history.Add(rolloutRecord("third", "q1", "3"))
+		history.Add(DeploymentRecord{Application: "external", Namespace: namespace, Environment: "t1"})
+
+		return Api{
+			Clientset:         fake.NewSimpleClientset(alertsConfigMap()),
+			ClusterSubdomain:  "nais.example.no",
+			FasitUrl:          "https://fasit.local",
+			DefaultEnv:        map[string]string{"CA_BUNDLE_VERSION": "2"},
+			DeploymentHistory: history,
+			AuditLog:          NewAuditLog(),
+			Rollouts:          NewRollouts(),
+			OperatorToken:     "secret",
+		}
+	}
+
+	start := func(api Api, request naisrequest.Rollout) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		req, _ := http.NewRequest("POST", "/rollout", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	wait := func(api Api, rr *httptest.ResponseRecorder) Rollout {
+		var rollout Rollout
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&rollout))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for rollout.Status == DeploymentInProgress && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			rollout, _ = api.Rollouts.Get(rollout.Id)
+		}
+		return rollout
+	}
+
+	t.Run("Rollouts must select applications", func(t *testing.T) {
+		api := newApi()
+		assert.Equal(t, http.StatusBadRequest, start(api, naisrequest.Rollout{}).Code)
+		assert.Equal(t, http.StatusBadRequest, start(api, naisrequest.Rollout{Environment: "t1", Interval: "often"}).Code)
+		assert.Equal(t, http.StatusNotFound, start(api, naisrequest.Rollout{Environment: "p"}).Code)
+	})
+
+	t.Run("The latest deployments matching the selector are deployed again with the current default env", func(t *testing.T) {
+		api := newApi()
+		rr := start(api, naisrequest.Rollout{Environment: "t1", Interval: "1ms", RequestedBy: "alice"})
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+
+		rollout := wait(api, rr)
+		assert.Equal(t, DeploymentSucceeded, rollout.Status)
+		assert.Equal(t, 3, rollout.Total)
+		assert.Equal(t, 3, rollout.Completed)
+		assert.Equal(t, 0, rollout.Failed)
+		assert.Equal(t, DeploymentSucceeded, rollout.Targets[0].Status)
+		assert.NotEmpty(t, rollout.Targets[0].DeploymentId)
+		assert.Equal(t, StageSkipped, rollout.Targets[2].Status, "deployments without a spec can not be rolled out")
+
+		deployment, err := getExistingDeployment("first", namespace, api.Clientset)
+		assert.NoError(t, err)
+		assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Env, k8score.EnvVar{Name: "CA_BUNDLE_VERSION", Value: "2"})
+		third, _ := getExistingDeployment("third", namespace, api.Clientset)
+		assert.Nil(t, third, "applications in other environments are left alone")
+
+		records := api.DeploymentHistory.Records()
+		assert.Len(t, records, 6)
+		assert.Equal(t, "alice", records[4].DeployedBy)
+		assert.Equal(t, "rollout", api.AuditLog.Entries()[1].Event)
+	})
+
+	t.Run("Applications whose resources can not be fetched from Fasit fail without stopping the rollout", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			Times(2).
+			Reply(500).
+			BodyString("Fasit is down")
+
+		api := newApi()
+		rollout := wait(api, start(api, naisrequest.Rollout{Environment: "t1", Interval: "1ms", RefreshFasit: true}))
+		assert.Equal(t, DeploymentSucceeded, rollout.Status)
+		assert.Equal(t, 2, rollout.Failed)
+		assert.Equal(t, DeploymentFailed, rollout.Targets[0].Status)
+		assert.Contains(t, rollout.Targets[0].Message, "unable to fetch fasit resources")
+
+		deployment, _ := getExistingDeployment("first", namespace, api.Clientset)
+		assert.Nil(t, deployment)
+	})
+
+	t.Run("Cancelled rollouts skip the applications they have not reached", func(t *testing.T) {
+		api := newApi()
+		rr := start(api, naisrequest.Rollout{Environment: "t1", Interval: "1h"})
+		var started Rollout
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &started))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for rollout, _ := api.Rollouts.Get(started.Id); rollout.Completed == 0 && time.Now().Before(deadline); rollout, _ = api.Rollouts.Get(started.Id) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		req, _ := http.NewRequest("DELETE", "/rollout/"+started.Id, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		cancelled := httptest.NewRecorder()
+		api.Handler().ServeHTTP(cancelled, req)
+		assert.Equal(t, http.StatusOK, cancelled.Code)
+
+		rollout := wait(api, rr)
+		assert.Equal(t, DeploymentCancelled, rollout.Status)
+		assert.Equal(t, DeploymentSucceeded, rollout.Targets[0].Status)
+		assert.Equal(t, StageSkipped, rollout.Targets[1].Status)
+	})
+
+	t.Run("Rollouts are held while deployments to the namespace are paused", func(t *testing.T) {
+		defer func(poll time.Duration) { rolloutAdmissionPoll = poll }(rolloutAdmissionPoll)
+		rolloutAdmissionPoll = time.Millisecond
+
+		api := newApi()
+		api.LoadShedder = NewLoadShedder()
+		api.LoadShedder.Pause(namespace, "freeze")
+		rr := start(api, naisrequest.Rollout{Environment: "t1", Interval: "1ms"})
+		var started Rollout
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &started))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for rollout, _ := api.Rollouts.Get(started.Id); len(rollout.Targets[0].Message) == 0 && time.Now().Before(deadline); rollout, _ = api.Rollouts.Get(started.Id) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		held, _ := api.Rollouts.Get(started.Id)
+		assert.Equal(t, StagePending, held.Targets[0].Status)
+		assert.Contains(t, held.Targets[0].Message, "freeze")
+		deployment, _ := getExistingDeployment("first", namespace, api.Clientset)
+		assert.Nil(t, deployment, "nothing is deployed while paused")
+
+		api.LoadShedder.Resume(namespace)
+		rollout := wait(api, rr)
+		assert.Equal(t, DeploymentSucceeded, rollout.Status)
+		assert.Equal(t, DeploymentSucceeded, rollout.Targets[0].Status)
+	})
+
+	t.Run("Paused rollouts can be cancelled", func(t *testing.T) {
+		api := newApi()
+		api.LoadShedder = NewLoadShedder()
+		api.LoadShedder.Pause("", "")
+		rr := start(api, naisrequest.Rollout{Environment: "t1", Interval: "1ms"})
+		var started Rollout
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &started))
+
+		_, err := api.Rollouts.Cancel(started.Id)
+		assert.NoError(t, err)
+
+		rollout := wait(api, rr)
+		assert.Equal(t, DeploymentCancelled, rollout.Status)
+		assert.Equal(t, StageSkipped, rollout.Targets[0].Status)
+	})
+}
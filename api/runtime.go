@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+
+	k8score "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	RuntimeJava = "java"
+
+	// JavaOptsEnvVar is read by the start scripts of most Java applications, e.g. those made by Gradle and Spring Boot
+	JavaOptsEnvVar = "JAVA_OPTS"
+
+	// Share of the memory limit given to the heap unless the manifest says otherwise. The rest is left for metaspace,
+	// thread stacks, direct buffers and the like, which the JVM also allocates outside the heap.
+	DefaultJavaHeapPercentage = 75
+)
+
+// Runtime tells naisd what the application runs on, so that it can be configured to fit the container. For Java,
+// naisd sets JAVA_OPTS to give the heap a share of the memory limit, preventing the container from being killed for
+// using more memory than its limit while the heap still has room. JVMs with container support (Java 10 and later,
+// and 8u191 and later) are given -XX:MaxRAMPercentage, older ones -Xmx computed from the limit. A cluster-wide default
+// JAVA_OPTS is kept in front of the heap option, as the JVM uses the last value of an option.
+type Runtime struct {
+	Name             string
+	HeapPercentage   int  `yaml:"heapPercentage"`
+	ContainerSupport bool `yaml:"containerSupport"`
+}
+
+func validateRuntime(manifest NaisManifest) *ValidationError {
+	runtime := manifest.Runtime
+	if len(runtime.Name) == 0 {
+		if runtime.HeapPercentage != 0 || runtime.ContainerSupport {
+			return &ValidationError{"Runtime name must be set to configure the runtime", map[string]string{"Runtime.Name": runtime.Name}}
+		}
+		return nil
+	}
+
+	if runtime.Name != RuntimeJava {
+		return &ValidationError{"Runtime name must be " + RuntimeJava, map[string]string{"Runtime.Name": runtime.Name}}
+	}
+
+	if runtime.HeapPercentage != 0 && (runtime.HeapPercentage < 10 || runtime.HeapPercentage > 90) {
+		return &ValidationError{
+			"Runtime heapPercentage must be between 10 and 90",
+			map[string]string{"Runtime.HeapPercentage": fmt.Sprint(runtime.HeapPercentage)},
+		}
+	}
+
+	if _, ok := manifest.Env[JavaOptsEnvVar]; ok {
+		return &ValidationError{
+			fmt.Sprintf("%s is set by naisd for the %s runtime, and can not also be set in env", JavaOptsEnvVar, RuntimeJava),
+			map[string]string{"Runtime.Name": runtime.Name},
+		}
+	}
+
+	return nil
+}
+
+// createJavaOptsEnvVar returns the JVM options for the runtime and memory limit of the manifest, after the default
+// JAVA_OPTS of the cluster if there is one, or nil if it does not run on Java
+func createJavaOptsEnvVar(manifest NaisManifest) (*k8score.EnvVar, error) {
+	options, err := javaHeapOption(manifest)
+	if err != nil || len(options) == 0 {
+		return nil, err
+	}
+
+	if defaultOptions := manifest.DefaultEnv[JavaOptsEnvVar]; len(defaultOptions) > 0 {
+		options = defaultOptions + " " + options
+	}
+	return &k8score.EnvVar{Name: JavaOptsEnvVar, Value: options}, nil
+}
+
+// javaHeapOption is the option limiting the heap, empty if the application does not run on Java
+func javaHeapOption(manifest NaisManifest) (string, error) {
+	if manifest.Runtime.Name != RuntimeJava {
+		return "", nil
+	}
+
+	percentage := manifest.Runtime.HeapPercentage
+	if percentage == 0 {
+		percentage = DefaultJavaHeapPercentage
+	}
+
+	if manifest.Runtime.ContainerSupport {
+		return fmt.Sprintf("-XX:MaxRAMPercentage=%d.0", percentage), nil
+	}
+
+	limit, err := k8sresource.ParseQuantity(manifest.Resources.Limits.Memory)
+	if err != nil {
+		return "", fmt.Errorf("unable to compute the heap size from the memory limit %s: %s", manifest.Resources.Limits.Memory, err)
+	}
+
+	heapMegabytes := limit.Value() * int64(percentage) / 100 / (1024 * 1024)
+	if heapMegabytes < 1 {
+		return "", fmt.Errorf("memory limit %s leaves no room for a heap", manifest.Resources.Limits.Memory)
+	}
+	return fmt.Sprintf("-Xmx%dm", heapMegabytes), nil
+}
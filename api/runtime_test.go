@@ -0,0 +1,85 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	runtime := func(runtime Runtime) NaisManifest {
+		return NaisManifest{Runtime: runtime}
+	}
+
+	assert.Nil(t, validateRuntime(NaisManifest{}))
+	assert.Nil(t, validateRuntime(runtime(Runtime{Name: "java"})))
+	assert.Nil(t, validateRuntime(runtime(Runtime{Name: "java", HeapPercentage: 60, ContainerSupport: true})))
+	assert.NotNil(t, validateRuntime(runtime(Runtime{Name: "node"})))
+	assert.NotNil(t, validateRuntime(runtime(Runtime{HeapPercentage: 60})))
+	assert.NotNil(t, validateRuntime(runtime(Runtime{Name: "java", HeapPercentage: 95})))
+
+	manifest := runtime(Runtime{Name: "java"})
+	manifest.Env = map[string]string{"JAVA_OPTS": "-Xmx1g"}
+	assert.NotNil(t, validateRuntime(manifest))
+}
+
+func TestJavaOpts(t *testing.T) {
+	withRuntime := func(runtime Runtime, memoryLimit string) NaisManifest {
+		manifest := NaisManifest{Runtime: runtime}
+		manifest.Resources.Limits.Memory = memoryLimit
+		return manifest
+	}
+
+	t.Run("Heap is a share of the memory limit", func(t *testing.T) {
+		envVar, err := createJavaOptsEnvVar(withRuntime(Runtime{Name: "java"}, "512Mi"))
+		assert.NoError(t, err)
+		assert.Equal(t, &k8score.EnvVar{Name: "JAVA_OPTS", Value: "-Xmx384m"}, envVar)
+
+		envVar, err = createJavaOptsEnvVar(withRuntime(Runtime{Name: "java", HeapPercentage: 50}, "1Gi"))
+		assert.NoError(t, err)
+		assert.Equal(t, "-Xmx512m", envVar.Value)
+	})
+
+	t.Run("JVMs with container support are given the percentage", func(t *testing.T) {
+		envVar, err := createJavaOptsEnvVar(withRuntime(Runtime{Name: "java", ContainerSupport: true}, "512Mi"))
+		assert.NoError(t, err)
+		assert.Equal(t, "-XX:MaxRAMPercentage=75.0", envVar.Value)
+	})
+
+	t.Run("Nothing is set for other applications", func(t *testing.T) {
+		envVar, err := createJavaOptsEnvVar(withRuntime(Runtime{}, "512Mi"))
+		assert.NoError(t, err)
+		assert.Nil(t, envVar)
+	})
+
+	t.Run("JAVA_OPTS is added to the container", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.Runtime = Runtime{Name: "java"}
+
+		envVars, err := createEnvironmentVariables(naisrequest.Deploy{Application: appName, Version: version}, manifest, []NaisResource{})
+		assert.NoError(t, err)
+		assert.Contains(t, envVars, k8score.EnvVar{Name: "JAVA_OPTS", Value: "-Xmx300m"})
+	})
+
+	t.Run("The heap option follows the cluster-wide default JAVA_OPTS", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.Runtime = Runtime{Name: "java"}
+		manifest.DefaultEnv = map[string]string{"JAVA_OPTS": "-Djava.net.preferIPv4Stack=true"}
+
+		envVars, err := createEnvironmentVariables(naisrequest.Deploy{Application: appName, Version: version}, manifest, []NaisResource{})
+		assert.NoError(t, err)
+		assert.Contains(t, envVars, k8score.EnvVar{Name: "JAVA_OPTS", Value: "-Djava.net.preferIPv4Stack=true -Xmx300m"})
+		assert.Len(t, envVars, len(createDefaultEnvironmentVariables(&naisrequest.Deploy{}))+1)
+	})
+
+	t.Run("JAVA_OPTS from a Fasit resource conflicts with the runtime", func(t *testing.T) {
+		manifest := newDefaultManifest()
+		manifest.Runtime = Runtime{Name: "java"}
+		resources := []NaisResource{{name: "opts", resourceType: "applicationproperties", properties: map[string]string{"JAVA_OPTS": "-Xmx1g"}}}
+
+		_, err := createEnvironmentVariables(naisrequest.Deploy{Application: appName, Version: version}, manifest, resources)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const maxCachedScopedResources = 1000
+
+// ScopedResourceCacheTtl is how long a resolved Fasit resource is reused by deployments in the same environment, 0 to
+// always ask Fasit
+var ScopedResourceCacheTtl time.Duration
+
+var scopedResources = newScopedResourceCache()
+
+// scopedResourceKey identifies a lookup. Resources include secrets Fasit only gives to some users, so the credentials
+// they were resolved with are part of the key, hashed.
+type scopedResourceKey struct {
+	fasitUrl     string
+	credentials  string
+	alias        string
+	resourceType string
+	environment  string
+	application  string
+	zone         string
+}
+
+func newScopedResourceKey(fasit FasitClient, request ResourceRequest, environment, application, zone string) scopedResourceKey {
+	return scopedResourceKey{
+		fasitUrl:     fasit.FasitUrl,
+		credentials:  fmt.Sprintf("%x", sha256.Sum256([]byte(fasit.Username+":"+fasit.Password))),
+		alias:        request.Alias,
+		resourceType: request.ResourceType,
+		environment:  environment,
+		application:  application,
+		zone:         zone,
+	}
+}
+
+type cachedScopedResource struct {
+	resource NaisResource
+	expires  time.Time
+}
+
+type scopedResourceCache struct {
+	mutex   sync.Mutex
+	entries map[scopedResourceKey]cachedScopedResource
+}
+
+func newScopedResourceCache() *scopedResourceCache {
+	return &scopedResourceCache{entries: make(map[scopedResourceKey]cachedScopedResource)}
+}
+
+func (c *scopedResourceCache) get(key scopedResourceKey, now time.Time) (NaisResource, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return NaisResource{}, false
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return NaisResource{}, false
+	}
+	return entry.resource, true
+}
+
+func (c *scopedResourceCache) put(key scopedResourceKey, resource NaisResource, expires time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxCachedScopedResources {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+
+	c.entries[key] = cachedScopedResource{resource, expires}
+}
+
+type fasitCacheBypassKey struct{}
+
+// withoutCache returns a client that always asks Fasit for resources, and caches what it gets for others
+func (fasit FasitClient) withoutCache() FasitClient {
+	ctx := fasit.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	fasit.ctx = context.WithValue(ctx, fasitCacheBypassKey{}, true)
+	return fasit
+}
+
+func (fasit FasitClient) bypassesCache() bool {
+	return fasit.ctx != nil && fasit.ctx.Value(fasitCacheBypassKey{}) == true
+}
+
+// getCachedScopedResource is GetScopedResource for the resources a deployment uses, reusing resources resolved within
+// ScopedResourceCacheTtl. Failed lookups are not cached.
+func (fasit FasitClient) getCachedScopedResource(request ResourceRequest, environment, application, zone string) (NaisResource, AppError) {
+	if ScopedResourceCacheTtl <= 0 {
+		return fasit.GetScopedResource(request, environment, application, zone)
+	}
+
+	key := newScopedResourceKey(fasit, request, environment, application, zone)
+	if !fasit.bypassesCache() {
+		if resource, ok := scopedResources.get(key, time.Now()); ok {
+			fasitResourceCache.WithLabelValues("hit").Inc()
+			fasitDeploymentOf(fasit.ctx).resolved(ResourceTiming{Alias: request.Alias, ResourceType: request.ResourceType, Duration: "0s", Lookup: "0s", Cached: true})
+			resource.propertyMap = request.PropertyMap
+			return resource, nil
+		}
+		fasitResourceCache.WithLabelValues("miss").Inc()
+	}
+
+	resource, appErr := fasit.GetScopedResource(request, environment, application, zone)
+	if appErr == nil {
+		scopedResources.put(key, resource, time.Now().Add(ScopedResourceCacheTtl))
+	}
+	return resource, appErr
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestScopedResourceCache(t *testing.T) {
+	cache := newScopedResourceCache()
+	key := scopedResourceKey{alias: "mydb", environment: "t1"}
+	now := time.Now()
+
+	cache.put(key, NaisResource{name: "mydb"}, now.Add(time.Minute))
+	resource, ok := cache.get(key, now)
+	assert.True(t, ok)
+	assert.Equal(t, "mydb", resource.name)
+
+	_, ok = cache.get(key, now.Add(time.Minute))
+	assert.False(t, ok, "expired resources are not returned")
+	_, ok = cache.get(key, now)
+	assert.False(t, ok, "expired resources are removed")
+}
+
+func TestScopedResourceKeyIncludesCredentials(t *testing.T) {
+	request := ResourceRequest{Alias: "mydb", ResourceType: "datasource"}
+
+	key := newScopedResourceKey(FasitClient{FasitUrl: "https://fasit.local", Username: "user", Password: "pass"}, request, "t1", "app", "fss")
+	assert.Equal(t, key, newScopedResourceKey(FasitClient{FasitUrl: "https://fasit.local", Username: "user", Password: "pass"}, request, "t1", "app", "fss"))
+	assert.NotEqual(t, key, newScopedResourceKey(FasitClient{FasitUrl: "https://fasit.local", Username: "user", Password: "wrong"}, request, "t1", "app", "fss"))
+	assert.NotContains(t, key.credentials, "pass")
+}
+
+func TestGetCachedScopedResource(t *testing.T) {
+	ttl := ScopedResourceCacheTtl
+	defer func() { ScopedResourceCacheTtl, scopedResources = ttl, newScopedResourceCache() }()
+	ScopedResourceCacheTtl, scopedResources = time.Minute, newScopedResourceCache()
+
+	defer gock.Off()
+	mockResource := func() {
+		gock.New("https://fasit.local").
+			Get("/api/v2/scopedresource").
+			MatchParam("alias", "mydb").
+			Reply(200).File("testdata/fasitResponse.json")
+	}
+	request := ResourceRequest{Alias: "mydb", ResourceType: "datasource"}
+	fasit := FasitClient{FasitUrl: "https://fasit.local", Username: "user", Password: "pass"}
+
+	mockResource()
+	_, appErr := fasit.getCachedScopedResource(request, "t1", "app", "fss")
+	assert.Nil(t, appErr)
+	assert.True(t, gock.IsDone())
+
+	t.Run("Resources are reused from the cache, and shown as cached", func(t *testing.T) {
+		tracker := NewDeploymentTracker()
+		deployment, _ := tracker.start(context.Background(), naisrequest.Deploy{Application: "app", Namespace: "default"}, 0)
+
+		hit := counterValue(fasitResourceCache.WithLabelValues("hit"))
+		_, appErr := fasit.forDeployment(deployment).getCachedScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.Equal(t, hit+1, counterValue(fasitResourceCache.WithLabelValues("hit")))
+
+		status, _ := tracker.Get(deployment.Id)
+		assert.True(t, status.Resources[0].Cached)
+	})
+
+	t.Run("Deployments can bypass the cache", func(t *testing.T) {
+		mockResource()
+		_, appErr := fasit.withoutCache().getCachedScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Other credentials do not share the cache", func(t *testing.T) {
+		mockResource()
+		_, appErr := FasitClient{FasitUrl: "https://fasit.local", Username: "other", Password: "pass"}.getCachedScopedResource(request, "t1", "app", "fss")
+		assert.Nil(t, appErr)
+		assert.True(t, gock.IsDone())
+	})
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// ScopeResolutionFasit uses the resource Fasit resolves for the scope, whatever else has the same alias
+	ScopeResolutionFasit = "fasit"
+	// ScopeResolutionBestMatch uses the resource with the most specific scope, failing if several are equally specific
+	ScopeResolutionBestMatch = "best-match"
+	// ScopeResolutionConflict fails if more than one resource with the alias applies to the deployment
+	ScopeResolutionConflict = "conflict"
+)
+
+// FasitScopeResolution is how naisd picks the resource a deployment uses when several resources in Fasit have its alias
+var FasitScopeResolution = ScopeResolutionFasit
+
+var scopeResolutions = []string{ScopeResolutionFasit, ScopeResolutionBestMatch, ScopeResolutionConflict}
+
+func ValidScopeResolution(resolution string) bool {
+	for _, valid := range scopeResolutions {
+		if resolution == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeResolutionValue is a flag.Value that only accepts the known scope resolutions, so flag.Parse rejects others
+type ScopeResolutionValue string
+
+func (resolution *ScopeResolutionValue) String() string {
+	return string(*resolution)
+}
+
+func (resolution *ScopeResolutionValue) Set(value string) error {
+	if !ValidScopeResolution(value) {
+		return fmt.Errorf("must be one of %s", strings.Join(scopeResolutions, ", "))
+	}
+	*resolution = ScopeResolutionValue(value)
+	return nil
+}
+
+// scopeApplies is true if the resource can be used by the deployment. A scope field that is not set applies to all.
+func scopeApplies(scope Scope, environment, application, zone string) bool {
+	return (len(scope.Environment) == 0 || strings.EqualFold(scope.Environment, environment)) &&
+		(len(scope.Application) == 0 || scope.Application == application) &&
+		(len(scope.Zone) == 0 || strings.EqualFold(scope.Zone, zone))
+}
+
+// scopeSpecificity ranks scopes the way Fasit does: an environment is more specific than an application, which is more
+// specific than a zone
+func scopeSpecificity(scope Scope) int {
+	specificity := 0
+	if len(scope.Environment) > 0 {
+		specificity += 4
+	}
+	if len(scope.Application) > 0 {
+		specificity += 2
+	}
+	if len(scope.Zone) > 0 {
+		specificity += 1
+	}
+	return specificity
+}
+
+func describeScope(scope Scope) string {
+	var parts []string
+	if len(scope.Environment) > 0 {
+		parts = append(parts, "environment "+scope.Environment)
+	} else {
+		parts = append(parts, "environment class "+scope.EnvironmentClass)
+	}
+	if len(scope.Application) > 0 {
+		parts = append(parts, "application "+scope.Application)
+	}
+	if len(scope.Zone) > 0 {
+		parts = append(parts, "zone "+scope.Zone)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func scopeConflict(request ResourceRequest, environment string, candidates []FasitResource) AppError {
+	var matches []string
+	for _, candidate := range candidates {
+		matches = append(matches, fmt.Sprintf("#%d (%s)", candidate.Id, describeScope(candidate.Scope)))
+	}
+	return appError{
+		nil,
+		fmt.Sprintf("%d resources named %s (%s) apply to %s: %s. Remove or rescope all but one of them", len(candidates), request.Alias, request.ResourceType, environment, strings.Join(matches, ", ")),
+		http.StatusConflict,
+	}
+}
+
+// pickScopedResource chooses among the resources with the alias that apply to the deployment, most specific first
+func pickScopedResource(request ResourceRequest, environment, resolution string, candidates []FasitResource) (FasitResource, AppError) {
+	if len(candidates) == 0 {
+		return FasitResource{}, appError{nil, fmt.Sprintf("resource %s (%s) not found in %s", request.Alias, request.ResourceType, environment), http.StatusNotFound}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return scopeSpecificity(candidates[i].Scope) > scopeSpecificity(candidates[j].Scope)
+	})
+
+	if resolution == ScopeResolutionConflict && len(candidates) > 1 {
+		return FasitResource{}, scopeConflict(request, environment, candidates)
+	}
+
+	var best []FasitResource
+	for _, candidate := range candidates {
+		if scopeSpecificity(candidate.Scope) == scopeSpecificity(candidates[0].Scope) {
+			best = append(best, candidate)
+		}
+	}
+	if len(best) > 1 {
+		return FasitResource{}, scopeConflict(request, environment, best)
+	}
+	return best[0], nil
+}
+
+// matchScopedResource lists every resource with the alias of the request and picks the one the deployment uses
+// according to FasitScopeResolution, instead of trusting Fasit to pick it
+func (fasit FasitClient) matchScopedResource(request ResourceRequest, environment, application, zone string) (FasitResource, AppError) {
+	resources, appErr := fasit.listResources(fmt.Sprintf("find resources %s (%s)", request.Alias, request.ResourceType), map[string]string{
+		"alias":       request.Alias,
+		"type":        request.ResourceType,
+		"environment": environment,
+		"application": application,
+		"zone":        zone,
+	})
+	if appErr != nil {
+		return FasitResource{}, appErr
+	}
+
+	var candidates []FasitResource
+	for _, resource := range resources {
+		if resource.Alias == request.Alias && scopeApplies(resource.Scope, environment, application, zone) {
+			candidates = append(candidates, resource)
+		}
+	}
+	return pickScopedResource(request, environment, FasitScopeResolution, candidates)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestPickScopedResource(t *testing.T) {
+	request := ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}
+	class := FasitResource{Id: 1, Alias: "mydb", Scope: Scope{EnvironmentClass: "t"}}
+	environment := FasitResource{Id: 2, Alias: "mydb", Scope: Scope{EnvironmentClass: "t", Environment: "t1"}}
+	application := FasitResource{Id: 3, Alias: "mydb", Scope: Scope{EnvironmentClass: "t", Application: "app"}}
+	otherEnvironment := FasitResource{Id: 4, Alias: "mydb", Scope: Scope{EnvironmentClass: "t", Environment: "t1", Zone: "fss"}}
+
+	t.Run("The most specific scope is picked", func(t *testing.T) {
+		resource, err := pickScopedResource(request, "t1", ScopeResolutionBestMatch, []FasitResource{class, application, environment})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, resource.Id)
+
+		resource, err = pickScopedResource(request, "t1", ScopeResolutionBestMatch, []FasitResource{class, application})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, resource.Id)
+	})
+
+	t.Run("Equally specific scopes are a conflict", func(t *testing.T) {
+		_, err := pickScopedResource(request, "t1", ScopeResolutionBestMatch, []FasitResource{class, environment, {Id: 5, Alias: "mydb", Scope: Scope{EnvironmentClass: "t", Environment: "t1"}}})
+		assert.NotNil(t, err)
+		assert.Equal(t, http.StatusConflict, err.Code())
+		assert.Contains(t, err.Error(), "2 resources named mydb (DataSource) apply to t1: #2 (environment t1), #5 (environment t1)")
+	})
+
+	t.Run("Conflict mode fails whenever several resources apply", func(t *testing.T) {
+		resource, err := pickScopedResource(request, "t1", ScopeResolutionConflict, []FasitResource{environment})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, resource.Id)
+
+		_, err = pickScopedResource(request, "t1", ScopeResolutionConflict, []FasitResource{class, otherEnvironment})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "#4 (environment t1, zone fss), #1 (environment class t)")
+	})
+
+	t.Run("No candidates is not found", func(t *testing.T) {
+		_, err := pickScopedResource(request, "t1", ScopeResolutionBestMatch, nil)
+		assert.Equal(t, http.StatusNotFound, err.Code())
+	})
+}
+
+func TestScopeApplies(t *testing.T) {
+	assert.True(t, scopeApplies(Scope{EnvironmentClass: "t"}, "t1", "app", "fss"))
+	assert.True(t, scopeApplies(Scope{EnvironmentClass: "t", Environment: "t1", Application: "app", Zone: "fss"}, "t1", "app", "fss"))
+	assert.False(t, scopeApplies(Scope{EnvironmentClass: "t", Environment: "t2"}, "t1", "app", "fss"))
+	assert.False(t, scopeApplies(Scope{EnvironmentClass: "t", Application: "other"}, "t1", "app", "fss"))
+	assert.False(t, scopeApplies(Scope{EnvironmentClass: "t", Zone: "sbs"}, "t1", "app", "fss"))
+}
+
+func TestScopeResolutionValue(t *testing.T) {
+	resolution := ScopeResolutionValue(ScopeResolutionFasit)
+	assert.NoError(t, resolution.Set(ScopeResolutionBestMatch))
+	assert.Equal(t, ScopeResolutionBestMatch, resolution.String())
+
+	assert.EqualError(t, resolution.Set("closest"), "must be one of fasit, best-match, conflict")
+	assert.Equal(t, ScopeResolutionBestMatch, resolution.String(), "an invalid value is not set")
+}
+
+func TestGetScopedResourceWithBestMatch(t *testing.T) {
+	FasitScopeResolution = ScopeResolutionBestMatch
+	defer func() { FasitScopeResolution = ScopeResolutionFasit }()
+	defer gock.Off()
+
+	gock.New("https://fasit.local").
+		Get("/api/v2/resources").
+		MatchParam("alias", "mydb").
+		MatchParam("type", "DataSource").
+		MatchParam("environment", "t1").
+		Reply(200).
+		BodyString(`[{"id": 1, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t"}, "properties": {"url": "jdbc:class"}},
+			{"id": 2, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t", "environment": "t1"}, "properties": {"url": "jdbc:t1"}},
+			{"id": 3, "alias": "mydb", "type": "DataSource", "scope": {"environmentclass": "t", "environment": "t2"}, "properties": {"url": "jdbc:t2"}}]`)
+
+	fasit := FasitClient{FasitUrl: "https://fasit.local"}
+	resource, err := fasit.GetScopedResource(ResourceRequest{Alias: "mydb", ResourceType: "DataSource"}, "t1", "app", "fss")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, resource.id)
+	assert.Equal(t, "jdbc:t1", resource.properties["url"])
+	assert.True(t, gock.IsDone())
+}
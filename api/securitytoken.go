@@ -0,0 +1,52 @@
+package api
+
+import (
+	"strings"
+)
+
+const (
+	SecurityTokenNone = "NONE"
+	SecurityTokenLdap = "LDAP"
+	SecurityTokenSaml = "SAML"
+)
+
+// The security tokens Fasit accepts on web services
+var securityTokens = []string{SecurityTokenLdap, SecurityTokenNone, SecurityTokenSaml}
+
+func usesSecurityToken(resourceType string) bool {
+	return strings.EqualFold("WebserviceEndpoint", resourceType) || isWebserviceGateway(resourceType)
+}
+
+func validSecurityToken(token string) bool {
+	for _, valid := range securityTokens {
+		if token == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// webserviceSecurityToken is the security token registered in Fasit for a web service, NONE if it is not set
+func webserviceSecurityToken(resource ExposedResource) string {
+	if len(resource.SecurityToken) == 0 {
+		return SecurityTokenNone
+	}
+	return resource.SecurityToken
+}
+
+func validateSecurityTokens(manifest NaisManifest) *ValidationError {
+	for _, resource := range manifest.FasitResources.Exposed {
+		if !usesSecurityToken(resource.ResourceType) || len(resource.SecurityToken) == 0 {
+			continue
+		}
+
+		if !validSecurityToken(resource.SecurityToken) {
+			return &ValidationError{
+				"SecurityToken of an exposed " + resource.ResourceType + " must be one of " + strings.Join(securityTokens, ", "),
+				map[string]string{"Alias": resource.Alias, "SecurityToken": resource.SecurityToken},
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSecurityTokens(t *testing.T) {
+	exposed := func(resources ...ExposedResource) NaisManifest {
+		return NaisManifest{FasitResources: FasitResources{Exposed: resources}}
+	}
+
+	assert.Nil(t, validateSecurityTokens(exposed(ExposedResource{Alias: "ws", ResourceType: "WebserviceEndpoint"})))
+	assert.Nil(t, validateSecurityTokens(exposed(ExposedResource{Alias: "ws", ResourceType: "webserviceendpoint", SecurityToken: "SAML"})))
+	assert.Nil(t, validateSecurityTokens(exposed(ExposedResource{Alias: "ws_gw", ResourceType: "WebserviceGateway", SecurityToken: "LDAP"})))
+	assert.Nil(t, validateSecurityTokens(exposed(ExposedResource{Alias: "api", ResourceType: "RestService", SecurityToken: "OIDC"})), "rest services are not registered with a security token")
+
+	err := validateSecurityTokens(exposed(ExposedResource{Alias: "ws", ResourceType: "WebserviceEndpoint", SecurityToken: "saml"}))
+	assert.NotNil(t, err)
+	assert.Equal(t, "SecurityToken of an exposed WebserviceEndpoint must be one of LDAP, NONE, SAML", err.ErrorMessage)
+	assert.Equal(t, "saml", err.Fields["SecurityToken"])
+
+	assert.NotNil(t, validateSecurityTokens(exposed(ExposedResource{Alias: "ws_gw", ResourceType: "WebserviceGateway", SecurityToken: "OIDC"})))
+}
+
+func TestSecurityTokenInPayload(t *testing.T) {
+	t.Run("A web service without a security token is registered with NONE", func(t *testing.T) {
+		resource := ExposedResource{Alias: "ws", ResourceType: "WebserviceEndpoint", Path: "/ws"}
+		payload := buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "hostname").(WebserviceResourcePayload)
+		assert.Equal(t, SecurityTokenNone, payload.Properties.SecurityToken)
+	})
+
+	t.Run("The security token of a gateway is in its payload", func(t *testing.T) {
+		for token, expected := range map[string]string{"": SecurityTokenNone, SecurityTokenLdap: SecurityTokenLdap} {
+			resource := ExposedResource{Alias: "ws_gw", ResourceType: "WebserviceGateway", Gateway: "https://gw", Endpoint: "ws", SecurityToken: token}
+			payload, err := json.Marshal(buildResourcePayload(resource, NaisResource{}, "t", "t1", "fss", "hostname"))
+			assert.NoError(t, err)
+			assert.Contains(t, string(payload), `"securityToken":"`+expected+`"`)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"regexp"
+	"strconv"
+
+	k8score "k8s.io/api/core/v1"
+)
+
+const (
+	AffinityAnnotation             = "nginx.ingress.kubernetes.io/affinity"
+	SessionCookieNameAnnotation    = "nginx.ingress.kubernetes.io/session-cookie-name"
+	SessionCookieMaxAgeAnnotation  = "nginx.ingress.kubernetes.io/session-cookie-max-age"
+	SessionCookieExpiresAnnotation = "nginx.ingress.kubernetes.io/session-cookie-expires"
+
+	// The longest client IP affinity Kubernetes permits on a service, and the one it defaults to
+	maxSessionAffinityTimeout     = 86400
+	defaultSessionAffinityTimeout = 10800
+)
+
+var sessionCookieNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SessionAffinity sends the requests of a client to the same pod, with a cookie set by the ingress controller and by
+// client IP on the service. MaxAge is in seconds, the default is a session cookie and three hours on the service.
+type SessionAffinity struct {
+	Enabled    bool
+	CookieName string `yaml:"cookieName"`
+	MaxAge     int    `yaml:"maxAge"`
+}
+
+func validateSessionAffinity(manifest NaisManifest) *ValidationError {
+	affinity := manifest.SessionAffinity
+
+	if !affinity.Enabled {
+		if len(affinity.CookieName) > 0 || affinity.MaxAge != 0 {
+			return &ValidationError{
+				"SessionAffinity.CookieName and MaxAge can only be set when SessionAffinity is enabled",
+				map[string]string{"SessionAffinity.Enabled": "false"},
+			}
+		}
+		return nil
+	}
+
+	if len(affinity.CookieName) > 0 && !sessionCookieNamePattern.MatchString(affinity.CookieName) {
+		return &ValidationError{
+			"SessionAffinity.CookieName can only contain letters, digits, - and _",
+			map[string]string{"SessionAffinity.CookieName": affinity.CookieName},
+		}
+	}
+
+	if affinity.MaxAge < 0 || affinity.MaxAge > maxSessionAffinityTimeout {
+		return &ValidationError{
+			"SessionAffinity.MaxAge must be between 0 and " + strconv.Itoa(maxSessionAffinityTimeout) + " seconds",
+			map[string]string{"SessionAffinity.MaxAge": strconv.Itoa(affinity.MaxAge)},
+		}
+	}
+
+	return nil
+}
+
+// The ingress controller annotations for cookie based stickiness, none if session affinity is not enabled
+func sessionAffinityAnnotations(affinity SessionAffinity) map[string]string {
+	if !affinity.Enabled {
+		return nil
+	}
+
+	annotations := map[string]string{AffinityAnnotation: "cookie"}
+	if len(affinity.CookieName) > 0 {
+		annotations[SessionCookieNameAnnotation] = affinity.CookieName
+	}
+	if affinity.MaxAge > 0 {
+		annotations[SessionCookieMaxAgeAnnotation] = strconv.Itoa(affinity.MaxAge)
+		annotations[SessionCookieExpiresAnnotation] = strconv.Itoa(affinity.MaxAge)
+	}
+	return annotations
+}
+
+// Sets the client IP affinity of the service. Returns false if the service already has it.
+func applyServiceSessionAffinity(service *k8score.Service, affinity SessionAffinity) bool {
+	sessionAffinity := k8score.ServiceAffinityNone
+	var config *k8score.SessionAffinityConfig
+	if affinity.Enabled {
+		timeout := affinity.MaxAge
+		if timeout == 0 {
+			timeout = defaultSessionAffinityTimeout
+		}
+		sessionAffinity = k8score.ServiceAffinityClientIP
+		config = &k8score.SessionAffinityConfig{ClientIP: &k8score.ClientIPConfig{TimeoutSeconds: int32p(int32(timeout))}}
+	}
+
+	existing := service.Spec.SessionAffinity
+	if len(existing) == 0 {
+		existing = k8score.ServiceAffinityNone
+	}
+	if existing == sessionAffinity && sameSessionAffinityConfig(service.Spec.SessionAffinityConfig, config) {
+		return false
+	}
+	service.Spec.SessionAffinity = sessionAffinity
+	service.Spec.SessionAffinityConfig = config
+	return true
+}
+
+func sameSessionAffinityConfig(existing, config *k8score.SessionAffinityConfig) bool {
+	if config == nil {
+		return existing == nil
+	}
+	return existing != nil && existing.ClientIP != nil && existing.ClientIP.TimeoutSeconds != nil &&
+		*existing.ClientIP.TimeoutSeconds == *config.ClientIP.TimeoutSeconds
+}
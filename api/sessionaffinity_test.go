@@ -0,0 +1,76 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateSessionAffinity(t *testing.T) {
+	affinity := func(affinity SessionAffinity) NaisManifest {
+		return NaisManifest{SessionAffinity: affinity}
+	}
+
+	assert.Nil(t, validateSessionAffinity(NaisManifest{}))
+	assert.Nil(t, validateSessionAffinity(affinity(SessionAffinity{Enabled: true})))
+	assert.Nil(t, validateSessionAffinity(affinity(SessionAffinity{Enabled: true, CookieName: "JSESSION_ROUTE", MaxAge: 3600})))
+	assert.NotNil(t, validateSessionAffinity(affinity(SessionAffinity{CookieName: "route"})))
+	assert.NotNil(t, validateSessionAffinity(affinity(SessionAffinity{Enabled: true, CookieName: "my route"})))
+	assert.NotNil(t, validateSessionAffinity(affinity(SessionAffinity{Enabled: true, MaxAge: -1})))
+	assert.NotNil(t, validateSessionAffinity(affinity(SessionAffinity{Enabled: true, MaxAge: 100000})))
+}
+
+func TestSessionAffinityAnnotations(t *testing.T) {
+	assert.Nil(t, sessionAffinityAnnotations(SessionAffinity{}))
+	assert.Equal(t, map[string]string{AffinityAnnotation: "cookie"}, sessionAffinityAnnotations(SessionAffinity{Enabled: true}))
+	assert.Equal(t, map[string]string{
+		AffinityAnnotation:             "cookie",
+		SessionCookieNameAnnotation:    "route",
+		SessionCookieMaxAgeAnnotation:  "3600",
+		SessionCookieExpiresAnnotation: "3600",
+	}, sessionAffinityAnnotations(SessionAffinity{Enabled: true, CookieName: "route", MaxAge: 3600}))
+
+	t.Run("Affinity is removed from the ingress when no longer enabled", func(t *testing.T) {
+		ingress := &k8sextensions.Ingress{}
+		applyIngressAnnotations(ingress, NaisManifest{SessionAffinity: SessionAffinity{Enabled: true, CookieName: "route"}})
+		assert.Equal(t, "cookie", ingress.Annotations[AffinityAnnotation])
+
+		applyIngressAnnotations(ingress, NaisManifest{})
+		assert.Nil(t, ingress.Annotations)
+	})
+}
+
+func TestServiceSessionAffinity(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Namespace: namespace, Application: appName}
+
+	t.Run("A new service gets client IP affinity", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		service, err := createOrUpdateService(deploymentRequest, teamName, SessionAffinity{Enabled: true, MaxAge: 600}, clientset)
+
+		assert.NoError(t, err)
+		assert.Equal(t, k8score.ServiceAffinityClientIP, service.Spec.SessionAffinity)
+		assert.Equal(t, int32(600), *service.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds)
+	})
+
+	t.Run("An existing service is only updated when its affinity changes", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(createServiceDef(appName, namespace, teamName))
+
+		service, err := createOrUpdateService(deploymentRequest, teamName, SessionAffinity{Enabled: true}, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, k8score.ServiceAffinityClientIP, service.Spec.SessionAffinity)
+		assert.Equal(t, int32(defaultSessionAffinityTimeout), *service.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds)
+
+		service, err = createOrUpdateService(deploymentRequest, teamName, SessionAffinity{Enabled: true}, clientset)
+		assert.NoError(t, err)
+		assert.Nil(t, service)
+
+		service, err = createOrUpdateService(deploymentRequest, teamName, SessionAffinity{}, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, k8score.ServiceAffinityNone, service.Spec.SessionAffinity)
+		assert.Nil(t, service.Spec.SessionAffinityConfig)
+	})
+}
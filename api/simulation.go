@@ -0,0 +1,204 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/pmezard/go-difflib/difflib"
+	k8score "k8s.io/api/core/v1"
+	k8sapimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// ClusterSnapshot is the recorded state of the objects in a cluster, e.g. from kubectl get -o yaml
+type ClusterSnapshot []runtime.Object
+
+// SimulationResult holds the objects a deployment would create or update, and a diff against the snapshot
+type SimulationResult struct {
+	Objects []runtime.Object
+	Diff    string
+}
+
+// ParseClusterSnapshot reads YAML documents separated by ---. Documents that are lists, as given by kubectl get, are expanded.
+func ParseClusterSnapshot(data []byte) (ClusterSnapshot, error) {
+	var snapshot ClusterSnapshot
+	decoder := scheme.Codecs.UniversalDeserializer()
+
+	for _, document := range yamlDocumentSeparator.Split(string(data), -1) {
+		if len(strings.TrimSpace(document)) == 0 {
+			continue
+		}
+
+		obj, _, err := decoder.Decode([]byte(document), nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode snapshot object: %s", err)
+		}
+
+		list, ok := obj.(*k8score.List)
+		if !ok {
+			snapshot = append(snapshot, obj)
+			continue
+		}
+
+		for _, item := range list.Items {
+			obj, _, err := decoder.Decode(item.Raw, nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode snapshot list item: %s", err)
+			}
+			snapshot = append(snapshot, obj)
+		}
+	}
+
+	return snapshot, nil
+}
+
+func LoadClusterSnapshot(path string) (ClusterSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cluster snapshot %s: %s", path, err)
+	}
+	return ParseClusterSnapshot(data)
+}
+
+// Sets the kind on objects returned by the client, which leaves it empty
+func withKind(obj runtime.Object) runtime.Object {
+	kinds, _, err := scheme.Scheme.ObjectKinds(obj)
+	if err == nil && len(kinds) > 0 {
+		obj.GetObjectKind().SetGroupVersionKind(kinds[0])
+	}
+	return obj
+}
+
+func objectKey(obj runtime.Object) string {
+	meta, err := k8sapimeta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s", withKind(obj).GetObjectKind().GroupVersionKind().Kind, meta.GetNamespace(), meta.GetName())
+}
+
+// Marshals the object without the fields the API server maintains, so that only changes made by naisd show in diffs
+func marshalComparable(obj runtime.Object) ([]byte, error) {
+	if obj == nil {
+		return []byte{}, nil
+	}
+
+	data, err := yaml.Marshal(withKind(obj.DeepCopyObject()))
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	delete(fields, "status")
+	if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "selfLink", "generation"} {
+			delete(metadata, field)
+		}
+	}
+
+	return yaml.Marshal(fields)
+}
+
+func objectDiff(before, after runtime.Object) (string, error) {
+	beforeYaml, err := marshalComparable(before)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal existing object: %s", err)
+	}
+	afterYaml, err := marshalComparable(after)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal object: %s", err)
+	}
+
+	var beforeLines []string
+	if before != nil {
+		beforeLines = difflib.SplitLines(string(beforeYaml))
+	}
+
+	key := objectKey(after)
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        beforeLines,
+		B:        difflib.SplitLines(string(afterYaml)),
+		FromFile: "snapshot/" + key,
+		ToFile:   "deployed/" + key,
+		Context:  3,
+	})
+}
+
+func deploymentResultObjects(result DeploymentResult) []runtime.Object {
+	var objects []runtime.Object
+	add := func(obj runtime.Object, present bool) {
+		if present {
+			objects = append(objects, withKind(obj))
+		}
+	}
+
+	add(result.ServiceAccount, result.ServiceAccount != nil)
+	add(result.Service, result.Service != nil)
+	add(result.Deployment, result.Deployment != nil)
+	add(result.FeatureToggles, result.FeatureToggles != nil)
+	add(result.Secret, result.Secret != nil)
+	add(result.Ingress, result.Ingress != nil)
+	add(result.Autoscaler, result.Autoscaler != nil)
+	add(result.AlertsConfigMap, result.AlertsConfigMap != nil)
+
+	return objects
+}
+
+// Simulate runs a deployment against a fake cluster holding the snapshot, returning the objects it would create or
+// update and how they differ from the snapshot. Nothing is sent to a real cluster.
+func Simulate(deploymentRequest naisrequest.Deploy, manifest NaisManifest, naisResources []NaisResource, snapshot ClusterSnapshot, clusterSubdomain string, istioEnabled bool) (SimulationResult, error) {
+	if manifest.Redis {
+		return SimulationResult{}, fmt.Errorf("redis can not be simulated")
+	}
+
+	if err := checkObjectSizes(deploymentRequest, naisResources); err != nil {
+		return SimulationResult{}, err
+	}
+
+	existing := make(map[string]runtime.Object)
+	for _, obj := range snapshot {
+		existing[objectKey(obj)] = obj
+	}
+
+	k8sClient := fake.NewSimpleClientset(snapshot...)
+	result, err := createOrUpdateK8sResources(deploymentRequest, manifest, naisResources, clusterSubdomain, istioEnabled, k8sClient)
+	if err != nil {
+		return SimulationResult{}, err
+	}
+
+	simulation := SimulationResult{Objects: deploymentResultObjects(result)}
+	for _, obj := range simulation.Objects {
+		diff, err := objectDiff(existing[objectKey(obj)], obj)
+		if err != nil {
+			return SimulationResult{}, err
+		}
+		simulation.Diff += diff
+	}
+
+	return simulation, nil
+}
+
+// MarshalObjects renders objects as YAML documents separated by ---
+func MarshalObjects(objects []runtime.Object) (string, error) {
+	var documents []string
+	for _, obj := range objects {
+		data, err := marshalComparable(obj)
+		if err != nil {
+			return "", fmt.Errorf("unable to marshal %s: %s", objectKey(obj), err)
+		}
+		documents = append(documents, string(data))
+	}
+	return strings.Join(documents, "---\n"), nil
+}
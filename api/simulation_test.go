@@ -0,0 +1,83 @@
+package api
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+var updateGolden = flag.Bool("update", false, "write golden files from the current output instead of comparing with them")
+
+// assertGolden compares actual with the golden file, or replaces the golden file when tests are run with -update
+func assertGolden(t *testing.T, path string, actual string) {
+	if *updateGolden {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(actual), 0644))
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	assert.NoError(t, err, "run go test -update to create missing golden files")
+	assert.Equal(t, string(expected), actual, "%s differs, run go test -update and review the diff", path)
+}
+
+func loadTestManifest(t *testing.T, path string) NaisManifest {
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	var manifest NaisManifest
+	assert.NoError(t, yaml.Unmarshal(data, &manifest))
+	assert.NoError(t, AddDefaultManifestValues(&manifest, "app"))
+	return manifest
+}
+
+func TestSimulationGoldenFiles(t *testing.T) {
+	deploymentRequest := naisrequest.Deploy{Application: "app", Namespace: "default", Version: "2.0.0", SkipFasit: true}
+	cases, err := filepath.Glob("testdata/simulation/*")
+	assert.NoError(t, err)
+
+	for _, dir := range cases {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			manifest := loadTestManifest(t, filepath.Join(dir, "nais.yaml"))
+
+			var snapshot ClusterSnapshot
+			if _, err := os.Stat(filepath.Join(dir, "snapshot.yaml")); err == nil {
+				snapshot, err = LoadClusterSnapshot(filepath.Join(dir, "snapshot.yaml"))
+				assert.NoError(t, err)
+			}
+
+			result, err := Simulate(deploymentRequest, manifest, []NaisResource{}, snapshot, "nais.example.no", false)
+			assert.NoError(t, err)
+
+			objects, err := MarshalObjects(result.Objects)
+			assert.NoError(t, err)
+			assertGolden(t, filepath.Join(dir, "objects.golden.yaml"), objects)
+			assertGolden(t, filepath.Join(dir, "diff.golden"), result.Diff)
+		})
+	}
+}
+
+func TestParseClusterSnapshot(t *testing.T) {
+	t.Run("Lists are expanded", func(t *testing.T) {
+		snapshot, err := LoadClusterSnapshot("testdata/simulation/update-deployment/snapshot.yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(snapshot))
+		assert.Equal(t, "Deployment/default/app", objectKey(snapshot[0]))
+		assert.Equal(t, "Service/default/app", objectKey(snapshot[1]))
+	})
+
+	t.Run("Unknown kind gives error", func(t *testing.T) {
+		_, err := ParseClusterSnapshot([]byte("apiVersion: v1\nkind: Unknown\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestSimulateRedis(t *testing.T) {
+	_, err := Simulate(naisrequest.Deploy{Application: "app", Namespace: "default"}, NaisManifest{Redis: true}, nil, nil, "", false)
+	assert.EqualError(t, err, "redis can not be simulated")
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	k8score "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kinds of state kept in a StateStore
+const (
+	StateDeploymentHistory = "deployment-history"
+	StateAuditLog          = "audit-log"
+)
+
+// Backends for the daemon's state, given with --state-store
+const (
+	StateStoreMemory    = "memory"
+	StateStoreConfigMap = "configmap"
+	StateStoreSqlite    = "sqlite"
+	StateStorePostgres  = "postgres"
+)
+
+// configMapStateKey is the key of the entries in a state ConfigMap, one JSON document per line
+const configMapStateKey = "entries.ndjson"
+
+// maxConfigMapStateBytes keeps state ConfigMaps well below the 1MiB Kubernetes allows, discarding the oldest entries
+const maxConfigMapStateBytes = 900 * 1024
+
+// StateStore keeps the deployment history and the audit log across restarts of naisd. Entries are JSON documents
+// with increasing sequence numbers per kind of state.
+type StateStore interface {
+	// Load returns the entries of kind, oldest first
+	Load(kind string) ([][]byte, error)
+	// Save stores entry with its sequence number, and discards the entries of kind older than the most recent keep
+	Save(kind string, sequence uint64, entry []byte, keep int) error
+	// Replace discards every entry of kind, and stores entries, oldest first, in their place
+	Replace(kind string, entries []StateEntry) error
+}
+
+// StateEntry is an entry of state with its sequence number
+type StateEntry struct {
+	Sequence uint64
+	Entry    []byte
+}
+
+// NewStateStore returns the store given with --state-store. ConfigMaps are kept in namespace, while the SQL stores
+// connect to dsn, and need naisd to be built with the sqlite or postgres tag for their driver.
+func NewStateStore(backend string, clientset kubernetes.Interface, namespace, dsn string) (StateStore, error) {
+	switch backend {
+	case StateStoreMemory, "":
+		return nil, nil
+	case StateStoreConfigMap:
+		return NewConfigMapStateStore(clientset, namespace), nil
+	case StateStoreSqlite, StateStorePostgres:
+		return NewSqlStateStore(backend, dsn)
+	}
+	return nil, fmt.Errorf("unknown state store %s, expected %s, %s, %s or %s", backend, StateStoreMemory, StateStoreConfigMap, StateStoreSqlite, StateStorePostgres)
+}
+
+// saveState stores entry if there is a store, logging failures, as losing an entry of history should not fail the
+// deployment it is about
+func saveState(store StateStore, kind string, sequence uint64, entry interface{}, keep int) {
+	if store == nil {
+		return
+	}
+
+	b, err := json.Marshal(entry)
+	if err == nil {
+		err = store.Save(kind, sequence, b, keep)
+	}
+	if err != nil {
+		stateStoreErrors.WithLabelValues(kind).Inc()
+		glog.Errorf("unable to store %s entry %d: %s", kind, sequence, err)
+	}
+}
+
+// ConfigMapStateStore keeps each kind of state in the ConfigMap naisd-<kind>. Every entry rewrites the ConfigMap, and
+// only the most recent entries that fit in it are kept, so installations with many deployments should use a SQL store.
+type ConfigMapStateStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	mutex     sync.Mutex
+	entries   map[string][][]byte
+}
+
+func NewConfigMapStateStore(clientset kubernetes.Interface, namespace string) *ConfigMapStateStore {
+	return &ConfigMapStateStore{clientset: clientset, namespace: namespace, entries: make(map[string][][]byte)}
+}
+
+func configMapStateName(kind string) string {
+	return "naisd-" + kind
+}
+
+func (s *ConfigMapStateStore) Load(kind string) ([][]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(configMapStateName(kind), k8smeta.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		s.entries[kind] = nil
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to get ConfigMap %s: %s", configMapStateName(kind), err)
+	}
+
+	var entries [][]byte
+	for _, line := range bytes.Split([]byte(configMap.Data[configMapStateKey]), []byte("\n")) {
+		if len(line) > 0 {
+			entries = append(entries, line)
+		}
+	}
+	s.entries[kind] = entries
+	return entries, nil
+}
+
+func (s *ConfigMapStateStore) Save(kind string, sequence uint64, entry []byte, keep int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := append(s.entries[kind], entry)
+	if len(entries) > keep {
+		entries = entries[len(entries)-keep:]
+	}
+	return s.write(kind, entries)
+}
+
+func (s *ConfigMapStateStore) Replace(kind string, entries []StateEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	replaced := make([][]byte, len(entries))
+	for i, entry := range entries {
+		replaced[i] = entry.Entry
+	}
+	return s.write(kind, replaced)
+}
+
+// write keeps the most recent of entries that fit in the ConfigMap of kind
+func (s *ConfigMapStateStore) write(kind string, entries [][]byte) error {
+	size := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		size += len(entries[i]) + 1
+		if size > maxConfigMapStateBytes {
+			entries = entries[i+1:]
+			break
+		}
+	}
+	s.entries[kind] = entries
+
+	configMap := &k8score.ConfigMap{
+		ObjectMeta: k8smeta.ObjectMeta{Name: configMapStateName(kind), Namespace: s.namespace},
+		Data:       map[string]string{configMapStateKey: string(bytes.Join(entries, []byte("\n")))},
+	}
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	_, err := configMaps.Update(configMap)
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(configMap)
+	}
+	return err
+}
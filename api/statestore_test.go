@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapStateStore(t *testing.T) {
+	t.Run("entries are kept in a ConfigMap per kind", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		store := NewConfigMapStateStore(clientset, "nais")
+
+		entries, err := store.Load(StateAuditLog)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+
+		assert.NoError(t, store.Save(StateAuditLog, 1, []byte(`{"sequence":1}`), 2))
+		assert.NoError(t, store.Save(StateAuditLog, 2, []byte(`{"sequence":2}`), 2))
+		assert.NoError(t, store.Save(StateAuditLog, 3, []byte(`{"sequence":3}`), 2))
+
+		entries, err = NewConfigMapStateStore(clientset, "nais").Load(StateAuditLog)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte(`{"sequence":2}`), []byte(`{"sequence":3}`)}, entries)
+
+		entries, err = store.Load(StateDeploymentHistory)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("the oldest entries are discarded to keep the ConfigMap small enough", func(t *testing.T) {
+		store := NewConfigMapStateStore(fake.NewSimpleClientset(), "nais")
+		large := strings.Repeat("x", maxConfigMapStateBytes/3)
+		for i := 1; i <= 4; i++ {
+			assert.NoError(t, store.Save(StateAuditLog, uint64(i), []byte(fmt.Sprintf(`{"sequence":%d,"event":"%s"}`, i, large)), 100))
+		}
+
+		entries, err := store.Load(StateAuditLog)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		assert.Contains(t, string(entries[0]), `"sequence":3`)
+	})
+}
+
+func TestRestoreState(t *testing.T) {
+	store := NewConfigMapStateStore(fake.NewSimpleClientset(), "nais")
+
+	history := NewDeploymentHistory()
+	assert.NoError(t, history.Restore(store))
+	history.Add(DeploymentRecord{DeploymentId: "a1", Application: "app", Version: "1"})
+	history.Add(DeploymentRecord{DeploymentId: "a2", Application: "app", Version: "2"})
+
+	auditLog := NewAuditLog()
+	assert.NoError(t, auditLog.Restore(store))
+	auditLog.Record(AuditEntry{Event: "rollback", Application: "app"})
+
+	t.Run("a new daemon continues where the last one stopped", func(t *testing.T) {
+		restarted := NewDeploymentHistory()
+		assert.NoError(t, restarted.Restore(NewConfigMapStateStore(store.clientset, "nais")))
+		records := restarted.Records()
+		assert.Len(t, records, 2)
+		assert.Equal(t, "a2", records[1].DeploymentId)
+		assert.Equal(t, uint64(2), records[1].Sequence)
+
+		restarted.Add(DeploymentRecord{DeploymentId: "a3", Application: "app", Version: "3"})
+		assert.Equal(t, uint64(3), restarted.Records()[2].Sequence)
+
+		restartedAuditLog := NewAuditLog()
+		assert.NoError(t, restartedAuditLog.Restore(NewConfigMapStateStore(store.clientset, "nais")))
+		assert.Equal(t, "rollback", restartedAuditLog.Entries()[0].Event)
+	})
+
+	t.Run("entries that can not be read fail the restore", func(t *testing.T) {
+		broken := NewConfigMapStateStore(fake.NewSimpleClientset(), "nais")
+		assert.NoError(t, broken.Save(StateAuditLog, 1, []byte("not json"), 10))
+		assert.Error(t, NewAuditLog().Restore(broken))
+	})
+
+	t.Run("entries stored out of order are restored in order", func(t *testing.T) {
+		unordered := NewConfigMapStateStore(fake.NewSimpleClientset(), "nais")
+		assert.NoError(t, unordered.Save(StateDeploymentHistory, 2, []byte(`{"deploymentId":"b2","sequence":2}`), 10))
+		assert.NoError(t, unordered.Save(StateDeploymentHistory, 1, []byte(`{"deploymentId":"b1","sequence":1}`), 10))
+
+		restored := NewDeploymentHistory()
+		assert.NoError(t, restored.Restore(unordered))
+		restored.Add(DeploymentRecord{DeploymentId: "b3"})
+
+		records := restored.Records()
+		assert.Equal(t, []string{"b1", "b2", "b3"}, []string{records[0].DeploymentId, records[1].DeploymentId, records[2].DeploymentId})
+		assert.Equal(t, uint64(3), records[2].Sequence)
+	})
+
+	t.Run("state that can not be restored is kept in memory", func(t *testing.T) {
+		broken := NewConfigMapStateStore(fake.NewSimpleClientset(), "nais")
+		assert.NoError(t, broken.Save(StateAuditLog, 1, []byte("not json"), 10))
+
+		auditLog := NewAuditLog()
+		assert.Error(t, auditLog.Restore(broken))
+		auditLog.Record(AuditEntry{Event: "rollback"})
+
+		assert.Len(t, auditLog.Entries(), 1)
+		entries, err := NewConfigMapStateStore(broken.clientset, "nais").Load(StateAuditLog)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1, "nothing is stored after a failed restore")
+	})
+}
+
+func TestNewStateStore(t *testing.T) {
+	store, err := NewStateStore(StateStoreMemory, nil, "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	store, err = NewStateStore(StateStoreConfigMap, fake.NewSimpleClientset(), "nais", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, store)
+
+	_, err = NewStateStore("etcd", nil, "", "")
+	assert.Error(t, err)
+}
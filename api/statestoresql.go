@@ -0,0 +1,120 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const createStateTable = `CREATE TABLE IF NOT EXISTS naisd_state (
+	kind VARCHAR(64) NOT NULL,
+	sequence BIGINT NOT NULL,
+	entry TEXT NOT NULL,
+	PRIMARY KEY (kind, sequence)
+)`
+
+// sqlDrivers are the database/sql drivers of the SQL state stores, registered by building naisd with their tag
+var sqlDrivers = map[string]string{
+	StateStoreSqlite:   "sqlite3",
+	StateStorePostgres: "postgres",
+}
+
+// SqlStateStore keeps the state in the table naisd_state of an embedded SQLite database or an external Postgres, so
+// saving an entry is a single insert instead of rewriting all of them
+type SqlStateStore struct {
+	db      *sql.DB
+	backend string
+}
+
+// NewSqlStateStore connects to the database at dsn, a file for sqlite and a connection string for postgres, and
+// creates the state table unless it exists
+func NewSqlStateStore(backend, dsn string) (*SqlStateStore, error) {
+	if len(dsn) == 0 {
+		return nil, fmt.Errorf("the %s state store needs --state-store-dsn", backend)
+	}
+
+	db, err := sql.Open(sqlDrivers[backend], dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s state store (is naisd built with -tags %s?): %s", backend, backend, err)
+	}
+
+	store := &SqlStateStore{db: db, backend: backend}
+	if _, err := db.Exec(createStateTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create the state table: %s", err)
+	}
+	return store, nil
+}
+
+// rebind replaces the ? placeholders of query with the $1, $2 ... Postgres expects
+func (s *SqlStateStore) rebind(query string) string {
+	if s.backend != StateStorePostgres {
+		return query
+	}
+
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+func (s *SqlStateStore) Load(kind string) ([][]byte, error) {
+	rows, err := s.db.Query(s.rebind("SELECT entry FROM naisd_state WHERE kind = ? ORDER BY sequence"), kind)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %s: %s", kind, err)
+	}
+	defer rows.Close()
+
+	var entries [][]byte
+	for rows.Next() {
+		var entry string
+		if err := rows.Scan(&entry); err != nil {
+			return nil, fmt.Errorf("unable to load %s: %s", kind, err)
+		}
+		entries = append(entries, []byte(entry))
+	}
+	return entries, rows.Err()
+}
+
+func (s *SqlStateStore) Save(kind string, sequence uint64, entry []byte, keep int) error {
+	if _, err := s.db.Exec(s.rebind("INSERT INTO naisd_state (kind, sequence, entry) VALUES (?, ?, ?)"), kind, int64(sequence), string(entry)); err != nil {
+		return err
+	}
+
+	if sequence <= uint64(keep) {
+		return nil
+	}
+	_, err := s.db.Exec(s.rebind("DELETE FROM naisd_state WHERE kind = ? AND sequence <= ?"), kind, int64(sequence)-int64(keep))
+	return err
+}
+
+func (s *SqlStateStore) Replace(kind string, entries []StateEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(s.rebind("DELETE FROM naisd_state WHERE kind = ?"), kind); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := tx.Exec(s.rebind("INSERT INTO naisd_state (kind, sequence, entry) VALUES (?, ?, ?)"), kind, int64(entry.Sequence), string(entry.Entry)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SqlStateStore) Close() error {
+	return s.db.Close()
+}
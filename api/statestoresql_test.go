@@ -0,0 +1,27 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqlStateStore(t *testing.T) {
+	t.Run("postgres placeholders are numbered", func(t *testing.T) {
+		postgres := &SqlStateStore{backend: StateStorePostgres}
+		assert.Equal(t, "DELETE FROM naisd_state WHERE kind = $1 AND sequence <= $2", postgres.rebind("DELETE FROM naisd_state WHERE kind = ? AND sequence <= ?"))
+
+		sqlite := &SqlStateStore{backend: StateStoreSqlite}
+		assert.Equal(t, "SELECT entry FROM naisd_state WHERE kind = ?", sqlite.rebind("SELECT entry FROM naisd_state WHERE kind = ?"))
+	})
+
+	t.Run("a database is required", func(t *testing.T) {
+		_, err := NewSqlStateStore(StateStorePostgres, "")
+		assert.EqualError(t, err, "the postgres state store needs --state-store-dsn")
+	})
+
+	t.Run("drivers are only there when built with their tag", func(t *testing.T) {
+		_, err := NewSqlStateStore(StateStoreSqlite, "/tmp/naisd.db")
+		assert.Contains(t, err.Error(), "is naisd built with -tags sqlite?")
+	})
+}
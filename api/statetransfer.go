@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// StateExportVersion is the version of the export format. Exports of other versions are not imported.
+const StateExportVersion = 1
+
+// interruptedByStateImport is the message of pipelines, rollouts and transfers that were in progress in an imported
+// state, as the naisd that ran them is no longer the one following them
+const interruptedByStateImport = "interrupted by moving naisd's state to another instance"
+
+// StateExport is the state naisd keeps of its own, everything else being in Kubernetes and Fasit. It is exported from
+// one naisd and imported into a standby, so the standby can take over if the cluster of the first is lost.
+type StateExport struct {
+	Version           int                    `json:"version"`
+	ExportedAt        time.Time              `json:"exportedAt"`
+	ClusterName       string                 `json:"clusterName"`
+	DeploymentHistory []DeploymentRecord     `json:"deploymentHistory"`
+	AuditLog          []AuditEntry           `json:"auditLog"`
+	Pauses            map[string]string      `json:"pauses"`
+	FeatureFlags      map[string]FeatureFlag `json:"featureFlags"`
+	Pipelines         []PipelineRun          `json:"pipelines"`
+	Transfers         []Transfer             `json:"transfers"`
+	Rollouts          []Rollout              `json:"rollouts"`
+}
+
+// validate checks that the export is of a version naisd understands, that the sequence numbers of the history and the
+// audit log are increasing, as cursors and new entries depend on them, and that the pipelines, transfers and rollouts
+// can be looked up by their ids
+func (export StateExport) validate() error {
+	if export.Version != StateExportVersion {
+		return fmt.Errorf("unknown version %d, expected %d", export.Version, StateExportVersion)
+	}
+
+	for i := 1; i < len(export.DeploymentHistory); i++ {
+		if export.DeploymentHistory[i].Sequence <= export.DeploymentHistory[i-1].Sequence {
+			return fmt.Errorf("deployment %d has sequence %d, which is not after %d", i, export.DeploymentHistory[i].Sequence, export.DeploymentHistory[i-1].Sequence)
+		}
+	}
+	for i := 1; i < len(export.AuditLog); i++ {
+		if export.AuditLog[i].Sequence <= export.AuditLog[i-1].Sequence {
+			return fmt.Errorf("audit entry %d has sequence %d, which is not after %d", i, export.AuditLog[i].Sequence, export.AuditLog[i-1].Sequence)
+		}
+	}
+
+	var pipelines, transfers, rollouts []string
+	for _, pipeline := range export.Pipelines {
+		pipelines = append(pipelines, pipeline.Id)
+	}
+	for _, transfer := range export.Transfers {
+		transfers = append(transfers, transfer.Id)
+	}
+	for _, rollout := range export.Rollouts {
+		rollouts = append(rollouts, rollout.Id)
+	}
+	if err := validateStateIds("pipeline", pipelines); err != nil {
+		return err
+	}
+	if err := validateStateIds("transfer", transfers); err != nil {
+		return err
+	}
+	return validateStateIds("rollout", rollouts)
+}
+
+func validateStateIds(kind string, ids []string) error {
+	seen := make(map[string]bool)
+	for i, id := range ids {
+		if len(id) == 0 {
+			return fmt.Errorf("%s %d has no id", kind, i)
+		}
+		if seen[id] {
+			return fmt.Errorf("%s %s is exported twice", kind, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func (api Api) exportState() StateExport {
+	return StateExport{
+		Version:           StateExportVersion,
+		ExportedAt:        time.Now().UTC(),
+		ClusterName:       api.ClusterName,
+		DeploymentHistory: api.DeploymentHistory.Records(),
+		AuditLog:          api.AuditLog.Entries(),
+		Pauses:            api.LoadShedder.Pauses(),
+		FeatureFlags:      api.FeatureFlags.Flags(),
+		Pipelines:         api.Pipelines.Runs(),
+		Transfers:         api.Transfers.List(),
+		Rollouts:          api.Rollouts.Runs(),
+	}
+}
+
+// importState replaces naisd's state with the export, all of it or none of it. The export is validated before anything
+// is replaced, and if the audit log can not be stored after the history was, the history is put back. Feature flags are
+// only replaced if the export has any, and are replaced again when naisd reloads them from --feature-flags-configmap.
+func (api Api) importState(export StateExport) error {
+	if err := export.validate(); err != nil {
+		return err
+	}
+
+	previousHistory := api.DeploymentHistory.Records()
+	if err := api.DeploymentHistory.Replace(export.DeploymentHistory); err != nil {
+		return err
+	}
+	if err := api.AuditLog.Replace(export.AuditLog); err != nil {
+		if restoreErr := api.DeploymentHistory.Replace(previousHistory); restoreErr != nil {
+			glog.Errorf("Unable to put back the deployment history after a failed state import: %s", restoreErr)
+		}
+		return err
+	}
+
+	// the rest is kept in memory, and can not fail
+	api.LoadShedder.ReplacePauses(export.Pauses)
+	if len(export.FeatureFlags) > 0 {
+		api.FeatureFlags.Replace(export.FeatureFlags)
+	}
+	api.Pipelines.Replace(export.Pipelines)
+	api.Transfers.Replace(export.Transfers)
+	api.Rollouts.Replace(export.Rollouts)
+
+	api.AuditLog.Record(AuditEntry{Event: "state_imported", Details: map[string]string{
+		"clusterName": export.ClusterName,
+		"exportedAt":  export.ExportedAt.Format(time.RFC3339),
+	}})
+	return nil
+}
+
+func (api Api) getState(w http.ResponseWriter, _ *http.Request) *appError {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.exportState()); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (api Api) putState(w http.ResponseWriter, r *http.Request) *appError {
+	var export StateExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		return &appError{err, "unable to unmarshal state", http.StatusBadRequest}
+	}
+
+	if err := export.validate(); err != nil {
+		return &appError{err, "invalid state", http.StatusBadRequest}
+	}
+
+	// a deployment, pipeline, transfer or rollout in progress would add to the state that is being replaced
+	if inProgress := api.Deployments.InProgress(); len(inProgress) > 0 {
+		return &appError{fmt.Errorf("%d deployments are in progress", len(inProgress)), "unable to import state", http.StatusConflict}
+	}
+	if pipelines, transfers, rollouts := api.Pipelines.inProgress(), api.Transfers.inProgress(), api.Rollouts.inProgress(); pipelines+transfers+rollouts > 0 {
+		err := fmt.Errorf("%d pipelines, %d transfers and %d rollouts are in progress", pipelines, transfers, rollouts)
+		return &appError{err, "unable to import state", http.StatusConflict}
+	}
+
+	if err := api.importState(export); err != nil {
+		return &appError{err, "unable to import state", http.StatusInternalServerError}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
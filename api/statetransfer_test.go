@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func stateApi() Api {
+	return Api{
+		ClusterName:       "prod-fss",
+		OperatorToken:     "secret",
+		DeploymentHistory: NewDeploymentHistory(),
+		AuditLog:          NewAuditLog(),
+		LoadShedder:       NewLoadShedder(),
+		FeatureFlags:      NewFeatureFlags(nil),
+		Deployments:       NewDeploymentTracker(),
+		Pipelines:         NewPipelines(),
+		Transfers:         NewTransfers(),
+		Rollouts:          NewRollouts(),
+	}
+}
+
+func sendState(api Api, method string, body []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "/internal/state", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestStateTransfer(t *testing.T) {
+	primary := stateApi()
+	primary.DeploymentHistory.Add(DeploymentRecord{DeploymentId: "a1", Application: "app", Namespace: "default", Version: "1"})
+	primary.DeploymentHistory.Add(DeploymentRecord{DeploymentId: "a2", Application: "app", Namespace: "default", Version: "2", PreviousVersion: "1"})
+	primary.AuditLog.Record(AuditEntry{Event: "rollback", Application: "app"})
+	primary.LoadShedder.Pause("t1", "maintenance")
+	primary.FeatureFlags.Replace(map[string]FeatureFlag{"someNewBehavior": {Teams: []string{"aura"}}})
+	pipeline := primary.Pipelines.start(naisrequest.Pipeline{
+		Deploy: naisrequest.Deploy{Application: "app", Version: "3"},
+		Stages: []naisrequest.Stage{{Environment: "t1"}, {Environment: "p"}},
+	})
+	primary.Pipelines.setStage(pipeline, 0, StageAwaitingApproval)
+	waiting := primary.Transfers.request(naisrequest.Transfer{Application: "app", Namespace: "default", FromTeam: "aura", ToTeam: "nais"}, "", time.Now())
+	applying := primary.Transfers.request(naisrequest.Transfer{Application: "other", Namespace: "default", FromTeam: "aura", ToTeam: "nais"}, "", time.Now())
+	primary.Transfers.approve(applying.Id, "aura", "alice", time.Now())
+	primary.Transfers.approve(applying.Id, "nais", "bob", time.Now())
+	rollout := primary.Rollouts.start(naisrequest.Rollout{Environment: "t1"}, primary.DeploymentHistory.Records())
+
+	rr := sendState(primary, "GET", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	export := rr.Body.Bytes()
+
+	var decoded StateExport
+	assert.NoError(t, json.Unmarshal(export, &decoded))
+	assert.Equal(t, StateExportVersion, decoded.Version)
+	assert.Equal(t, "prod-fss", decoded.ClusterName)
+	assert.Len(t, decoded.DeploymentHistory, 2)
+	assert.Len(t, decoded.Pipelines, 1)
+	assert.Len(t, decoded.Transfers, 2)
+	assert.Len(t, decoded.Rollouts, 1)
+
+	t.Run("a standby takes over the state, and continues its sequences", func(t *testing.T) {
+		store := NewConfigMapStateStore(fake.NewSimpleClientset(), "nais")
+		standby := stateApi()
+		standby.DeploymentHistory.Restore(store)
+		standby.DeploymentHistory.Add(DeploymentRecord{DeploymentId: "standby", Application: "other"})
+		standby.LoadShedder.Pause("", "standby")
+
+		rr := sendState(standby, "PUT", export)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		records := standby.DeploymentHistory.Records()
+		assert.Len(t, records, 2)
+		assert.Equal(t, "a2", records[1].DeploymentId)
+		assert.Equal(t, "2", standby.DeploymentHistory.previousVersion("", "default", "app"))
+		assert.Equal(t, map[string]string{"t1": "maintenance"}, standby.LoadShedder.Pauses())
+		assert.True(t, standby.FeatureFlags.Enabled("someNewBehavior", "aura", "default"))
+
+		entries := standby.AuditLog.Entries()
+		assert.Equal(t, "rollback", entries[0].Event)
+		assert.Equal(t, "state_imported", entries[1].Event)
+		assert.Equal(t, uint64(2), entries[1].Sequence)
+
+		standby.DeploymentHistory.Add(DeploymentRecord{DeploymentId: "a3", Application: "app", Version: "3"})
+		assert.Equal(t, uint64(3), standby.DeploymentHistory.Records()[2].Sequence)
+
+		stored, err := store.Load(StateDeploymentHistory)
+		assert.NoError(t, err)
+		assert.Len(t, stored, 3)
+	})
+
+	t.Run("pipelines, transfers and rollouts are taken over, and those in progress are interrupted", func(t *testing.T) {
+		standby := stateApi()
+		assert.Equal(t, http.StatusOK, sendState(standby, "PUT", export).Code)
+
+		imported, ok := standby.Pipelines.Get(pipeline.Id)
+		assert.True(t, ok)
+		assert.Equal(t, DeploymentCancelled, imported.Status)
+		assert.Equal(t, DeploymentCancelled, imported.Stages[0].Status)
+		assert.Equal(t, interruptedByStateImport, imported.Stages[0].Message)
+		assert.Equal(t, StageSkipped, imported.Stages[1].Status)
+		_, err := standby.Pipelines.Approve(pipeline.Id, "alice")
+		assert.Error(t, err)
+
+		failed, _ := standby.Transfers.Get(applying.Id)
+		assert.Equal(t, TransferFailed, failed.Status)
+		assert.Equal(t, interruptedByStateImport, failed.Message)
+		approved, err := standby.Transfers.approve(waiting.Id, "aura", "alice", time.Now())
+		assert.NoError(t, err, "transfers waiting for approval can still be approved")
+		assert.Len(t, approved.Approvals, 1)
+
+		cancelled, _ := standby.Rollouts.Get(rollout.Id)
+		assert.Equal(t, DeploymentCancelled, cancelled.Status)
+		assert.Equal(t, StageSkipped, cancelled.Targets[0].Status)
+		assert.Equal(t, cancelled.Total, cancelled.Completed)
+	})
+
+	t.Run("invalid exports are rejected", func(t *testing.T) {
+		for _, body := range []string{
+			`not json`,
+			`{"version": 2}`,
+			`{"version": 1, "deploymentHistory": [{"sequence": 2}, {"sequence": 1}]}`,
+			`{"version": 1, "auditLog": [{"sequence": 1}, {"sequence": 1}]}`,
+			`{"version": 1, "pipelines": [{"status": "succeeded"}]}`,
+			`{"version": 1, "transfers": [{"id": "t"}, {"id": "t"}]}`,
+			`{"version": 1, "deploymentHistory": [{"sequence": 1}], "rollouts": [{"id": "r"}, {"id": "r"}]}`,
+		} {
+			standby := stateApi()
+			standby.DeploymentHistory.Add(DeploymentRecord{DeploymentId: "standby"})
+			assert.Equal(t, http.StatusBadRequest, sendState(standby, "PUT", []byte(body)).Code, body)
+			assert.Equal(t, "standby", standby.DeploymentHistory.Records()[0].DeploymentId, "nothing is replaced by an invalid export")
+		}
+	})
+
+	t.Run("state is not imported while deployments are in progress", func(t *testing.T) {
+		busy := stateApi()
+		_, err := busy.Deployments.start(context.Background(), naisrequest.Deploy{Application: "app", Namespace: "default"}, 0)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, sendState(busy, "PUT", export).Code)
+		assert.Empty(t, busy.DeploymentHistory.Records())
+
+		piping := stateApi()
+		piping.Pipelines.start(naisrequest.Pipeline{Stages: []naisrequest.Stage{{Environment: "t1"}}})
+		assert.Equal(t, http.StatusConflict, sendState(piping, "PUT", export).Code)
+		assert.Empty(t, piping.DeploymentHistory.Records())
+	})
+
+	t.Run("only operators can export and import state", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/internal/state", nil)
+		rr := httptest.NewRecorder()
+		primary.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
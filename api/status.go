@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DaemonStatus tracks the work naisd is doing right now
+type DaemonStatus struct {
+	deploymentsInProgress int64
+}
+
+type SubsystemStatus struct {
+	Name    string            `json:"name"`
+	Healthy bool              `json:"healthy"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// StatusReport is the health of every subsystem, with Score being the share of healthy subsystems
+type StatusReport struct {
+	Score      float64           `json:"score"`
+	Subsystems []SubsystemStatus `json:"subsystems"`
+}
+
+func NewDaemonStatus() *DaemonStatus {
+	return &DaemonStatus{}
+}
+
+func (s *DaemonStatus) deploymentStarted() {
+	if s != nil {
+		atomic.AddInt64(&s.deploymentsInProgress, 1)
+	}
+}
+
+func (s *DaemonStatus) deploymentFinished() {
+	if s != nil {
+		atomic.AddInt64(&s.deploymentsInProgress, -1)
+	}
+}
+
+func (s *DaemonStatus) DeploymentsInProgress() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.deploymentsInProgress)
+}
+
+func (api Api) fasitStatus() SubsystemStatus {
+	status := SubsystemStatus{Name: "fasit", Healthy: true, Details: map[string]string{}}
+	for _, endpoint := range checkFasitEndpoints(api.FasitUrl, api.FasitEndpoints, api.FasitTransport) {
+		zone := endpoint.Zone
+		if len(zone) == 0 {
+			zone = "default"
+		}
+
+		if endpoint.Healthy {
+			status.Details[zone] = "ok"
+		} else {
+			status.Healthy = false
+			status.Details[zone] = endpoint.Error
+			status.Error = "unable to reach Fasit in zone " + zone
+		}
+	}
+	return status
+}
+
+func (api Api) kubernetesStatus() SubsystemStatus {
+	status := SubsystemStatus{Name: "kubernetes", Healthy: true}
+	version, err := api.Clientset.Discovery().ServerVersion()
+	if err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+		return status
+	}
+	status.Details = map[string]string{"version": version.GitVersion}
+	return status
+}
+
+func (api Api) workStatus() SubsystemStatus {
+	return SubsystemStatus{Name: "deployments", Healthy: true, Details: map[string]string{
+		"inProgress": strconv.FormatInt(api.Status.DeploymentsInProgress(), 10),
+		"pauses":     strconv.Itoa(len(api.LoadShedder.Pauses())),
+	}}
+}
+
+// cacheStatus reports how many entries the in-memory deployment history and audit log hold. The caches of Fasit
+// resources, Fasit responses and manifests are not included; they limit their own size.
+func (api Api) cacheStatus() SubsystemStatus {
+	return SubsystemStatus{Name: "caches", Healthy: true, Details: map[string]string{
+		"deploymentHistory": strconv.Itoa(len(api.DeploymentHistory.Records())),
+		"auditLog":          strconv.Itoa(len(api.AuditLog.Entries())),
+	}}
+}
+
+func (api Api) lastDeploymentStatus() SubsystemStatus {
+	status := SubsystemStatus{Name: "lastDeployment", Healthy: true}
+	records := api.DeploymentHistory.Succeeded()
+	if len(records) == 0 {
+		return status
+	}
+
+	last := records[len(records)-1]
+	status.Details = map[string]string{
+		"application": last.Application,
+		"namespace":   last.Namespace,
+		"version":     last.Version,
+		"timestamp":   last.Timestamp.UTC().Format(time.RFC3339),
+	}
+	return status
+}
+
+func (api Api) statusReport() StatusReport {
+	subsystems := []SubsystemStatus{
+		api.kubernetesStatus(),
+		api.fasitStatus(),
+		api.workStatus(),
+		api.cacheStatus(),
+		api.lastDeploymentStatus(),
+	}
+
+	healthy := 0
+	for _, subsystem := range subsystems {
+		if subsystem.Healthy {
+			healthy++
+		}
+	}
+
+	return StatusReport{
+		Score:      float64(healthy) / float64(len(subsystems)),
+		Subsystems: subsystems,
+	}
+}
+
+// Responds with 503 when the Kubernetes API is unavailable, since naisd can not deploy anything without it
+func (api Api) internalStatus(w http.ResponseWriter, _ *http.Request) *appError {
+	report := api.statusReport()
+
+	status := http.StatusOK
+	for _, subsystem := range report.Subsystems {
+		if subsystem.Name == "kubernetes" && !subsystem.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+	return nil
+}
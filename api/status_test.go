@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDaemonStatus(t *testing.T) {
+	status := NewDaemonStatus()
+	status.deploymentStarted()
+	status.deploymentStarted()
+	status.deploymentFinished()
+	assert.Equal(t, int64(1), status.DeploymentsInProgress())
+
+	var none *DaemonStatus
+	none.deploymentStarted()
+	assert.Equal(t, int64(0), none.DeploymentsInProgress())
+}
+
+func TestInternalStatus(t *testing.T) {
+	history := NewDeploymentHistory()
+	history.Add(DeploymentRecord{Application: "app", Namespace: "default", Version: "1.2.3"})
+	api := Api{
+		Clientset:         fake.NewSimpleClientset(),
+		FasitUrl:          "https://fasit.local",
+		FasitEndpoints:    map[string]FasitEndpoint{"sbs": {Url: "https://fasit-sbs.local"}},
+		DeploymentHistory: history,
+		Status:            NewDaemonStatus(),
+	}
+
+	defer gock.Off()
+	gock.New("https://fasit.local").Get(DefaultFasitHealthCheckPath).Reply(200)
+	gock.New("https://fasit-sbs.local").Get(DefaultFasitHealthCheckPath).Reply(503)
+
+	req, _ := http.NewRequest("GET", "/internal/status", nil)
+	rr := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, req)
+
+	var report StatusReport
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 0.8, report.Score)
+
+	subsystems := make(map[string]SubsystemStatus)
+	for _, subsystem := range report.Subsystems {
+		subsystems[subsystem.Name] = subsystem
+	}
+	assert.True(t, subsystems["kubernetes"].Healthy)
+	assert.False(t, subsystems["fasit"].Healthy)
+	assert.Equal(t, "ok", subsystems["fasit"].Details["default"])
+	assert.Equal(t, "0", subsystems["deployments"].Details["inProgress"])
+	assert.Equal(t, "1", subsystems["caches"].Details["deploymentHistory"])
+	assert.Equal(t, "app", subsystems["lastDeployment"].Details["application"])
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// In a strict manifest, fields naisd does not know about are errors instead of being ignored
+func strictManifest(manifest NaisManifest) bool {
+	return manifest.Strict != nil && *manifest.Strict
+}
+
+// Returns the YAML keys of a struct and the types of their values, the way yaml.v2 names them
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if len(tag) > 1 && tag[1] == "inline" {
+			for key, value := range yamlFields(field.Type) {
+				fields[key] = value
+			}
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// Returns the known field closest to key, if any is close enough to be a likely typo
+func suggestField(key string, fields map[string]reflect.Type) string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	suggestion := ""
+	best := len(key)/3 + 1
+	for _, name := range names {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+		if distance := levenshtein(strings.ToLower(key), strings.ToLower(name)); distance <= best {
+			suggestion, best = name, distance-1
+		}
+	}
+	return suggestion
+}
+
+func unknownFields(value interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		document, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+
+		fields := yamlFields(t)
+		var keys []string
+		for key := range document {
+			keys = append(keys, fmt.Sprint(key))
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fieldType, known := fields[key]
+			if !known {
+				message := path + key
+				if suggestion := suggestField(key, fields); len(suggestion) > 0 {
+					message += fmt.Sprintf(" (did you mean %s?)", path+suggestion)
+				}
+				unknown = append(unknown, message)
+				continue
+			}
+			unknown = append(unknown, unknownFields(document[key], fieldType, path+key+".")...)
+		}
+	case reflect.Map:
+		if document, ok := value.(map[interface{}]interface{}); ok {
+			for key, element := range document {
+				unknown = append(unknown, unknownFields(element, t.Elem(), fmt.Sprintf("%s%v.", path, key))...)
+			}
+			sort.Strings(unknown)
+		}
+	case reflect.Slice:
+		if elements, ok := value.([]interface{}); ok {
+			for i, element := range elements {
+				unknown = append(unknown, unknownFields(element, t.Elem(), fmt.Sprintf("%s%d.", path, i))...)
+			}
+		}
+	}
+
+	return unknown
+}
+
+// Fails on fields in the manifest document that NaisManifest has no place for, suggesting what was probably meant
+func checkUnknownManifestFields(document map[interface{}]interface{}) error {
+	unknown := unknownFields(document, reflect.TypeOf(NaisManifest{}), "")
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown fields in manifest: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// Unmarshals the manifest with anchors and merge keys resolved, checking for unknown fields if the manifest is strict
+func unmarshalManifest(data []byte) (NaisManifest, error) {
+	document, err := decodeManifestDocument(data)
+	if err != nil {
+		return NaisManifest{}, err
+	}
+
+	resolved, err := yaml.Marshal(document)
+	if err != nil {
+		return NaisManifest{}, err
+	}
+
+	var manifest NaisManifest
+	if err := yaml.Unmarshal(resolved, &manifest); err != nil {
+		return NaisManifest{}, err
+	}
+
+	if strictManifest(manifest) {
+		if err := checkUnknownManifestFields(document); err != nil {
+			return NaisManifest{}, err
+		}
+	}
+
+	return manifest, nil
+}
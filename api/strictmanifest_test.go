@@ -0,0 +1,74 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrictManifest(t *testing.T) {
+	strict, lenient := true, false
+
+	assert.False(t, strictManifest(NaisManifest{}))
+	assert.True(t, strictManifest(NaisManifest{Strict: &strict}))
+	assert.False(t, strictManifest(NaisManifest{Strict: &lenient}))
+}
+
+func TestUnmarshalManifest(t *testing.T) {
+	t.Run("Unknown fields are ignored in lenient manifests", func(t *testing.T) {
+		manifest, err := unmarshalManifest([]byte("image: app\nreplicas:\n  maxx: 4\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, "app", manifest.Image)
+	})
+
+	t.Run("Unknown fields in strict manifests give error with suggestions", func(t *testing.T) {
+		data := []byte(`
+strict: true
+image: app
+replicas:
+  maxx: 4
+ingress:
+  Disabled: true
+healthchek:
+  liveness:
+    path: isalive
+fasitResources:
+  used:
+  - alias: db
+    resourcetype: datasource
+alerts:
+- alert: down
+  expr: up == 0
+  label:
+    severity: critical
+totallyunrelated: true
+`)
+
+		_, err := unmarshalManifest(data)
+		assert.EqualError(t, err, "unknown fields in manifest: "+
+			"alerts.0.label (did you mean alerts.0.labels?), "+
+			"fasitResources.used.0.resourcetype (did you mean fasitResources.used.0.resourceType?), "+
+			"healthchek (did you mean healthcheck?), "+
+			"ingress.Disabled (did you mean ingress.disabled?), "+
+			"replicas.maxx (did you mean replicas.max?), "+
+			"totallyunrelated")
+	})
+
+	t.Run("Known fields pass in strict manifests", func(t *testing.T) {
+		data := []byte(`
+strict: true
+image: app
+replicas:
+  max: 4
+alerts:
+- alert: down
+  expr: up == 0
+  labels:
+    severity: critical
+`)
+
+		manifest, err := unmarshalManifest(data)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, manifest.Replicas.Max)
+	})
+}
@@ -0,0 +1,813 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+	"goji.io/pat"
+	"gopkg.in/yaml.v2"
+	k8sautoscaling "k8s.io/api/autoscaling/v1"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8srbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	TransferAwaitingApproval = "awaiting_approval"
+	TransferInProgress       = "in_progress"
+	TransferCompleted        = "completed"
+	TransferFailed           = "failed"
+
+	// Set on the deployment of a transferred application, naming the team it was transferred from, whose manifest can
+	// no longer deploy it
+	TransferredFromAnnotation = "nais.io/transferred-from"
+
+	maxFinishedTransfers = 100
+)
+
+// TeamApproval is the approval of a transfer by one of its teams
+type TeamApproval struct {
+	Team       string    `json:"team"`
+	ApprovedBy string    `json:"approvedBy"`
+	Approved   time.Time `json:"approved"`
+}
+
+// Transfer is a transfer of an application to another team, waiting for the approval of both teams or done
+type Transfer struct {
+	Id          string         `json:"id"`
+	Application string         `json:"application"`
+	Namespace   string         `json:"namespace"`
+	FromTeam    string         `json:"fromTeam"`
+	ToTeam      string         `json:"toTeam"`
+	ToNamespace string         `json:"toNamespace,omitempty"`
+	RequestedBy string         `json:"requestedBy,omitempty"`
+	Requested   time.Time      `json:"requested"`
+	Status      string         `json:"status"`
+	Approvals   []TeamApproval `json:"approvals"`
+	Relabeled   []string       `json:"relabeled,omitempty"`
+	Message     string         `json:"message,omitempty"`
+}
+
+func (transfer Transfer) approvedBy(team string) bool {
+	for _, approval := range transfer.Approvals {
+		if approval.Team == team {
+			return true
+		}
+	}
+	return false
+}
+
+func (transfer Transfer) snapshot() Transfer {
+	snapshot := transfer
+	snapshot.Approvals = make([]TeamApproval, len(transfer.Approvals))
+	copy(snapshot.Approvals, transfer.Approvals)
+	return snapshot
+}
+
+// Transfers keeps the transfers waiting for approval and the most recently finished ones
+type Transfers struct {
+	mutex     sync.Mutex
+	transfers map[string]*Transfer
+	finished  []string
+}
+
+func NewTransfers() *Transfers {
+	return &Transfers{transfers: make(map[string]*Transfer)}
+}
+
+func (t *Transfers) request(request naisrequest.Transfer, toNamespace string, now time.Time) Transfer {
+	transfer := &Transfer{
+		Id:          newDeploymentId(),
+		Application: request.Application,
+		Namespace:   request.Namespace,
+		FromTeam:    request.FromTeam,
+		ToTeam:      request.ToTeam,
+		ToNamespace: toNamespace,
+		RequestedBy: request.RequestedBy,
+		Requested:   now,
+		Status:      TransferAwaitingApproval,
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.transfers[transfer.Id] = transfer
+	return transfer.snapshot()
+}
+
+func (t *Transfers) Get(id string) (Transfer, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	transfer, ok := t.transfers[id]
+	if !ok {
+		return Transfer{}, false
+	}
+	return transfer.snapshot(), true
+}
+
+// approve records the approval of one of the teams of the transfer. Once both teams have approved, the transfer is in
+// progress, and the caller applies it.
+func (t *Transfers) approve(id, team, approvedBy string, now time.Time) (Transfer, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	transfer, ok := t.transfers[id]
+	switch {
+	case !ok:
+		return Transfer{}, fmt.Errorf("transfer %s not found", id)
+	case transfer.Status != TransferAwaitingApproval:
+		return transfer.snapshot(), fmt.Errorf("transfer %s is %s", id, transfer.Status)
+	case team != transfer.FromTeam && team != transfer.ToTeam:
+		return transfer.snapshot(), fmt.Errorf("team %s is not a party to transfer %s", team, id)
+	case transfer.approvedBy(team):
+		return transfer.snapshot(), fmt.Errorf("team %s has already approved transfer %s", team, id)
+	}
+
+	transfer.Approvals = append(transfer.Approvals, TeamApproval{Team: team, ApprovedBy: approvedBy, Approved: now})
+	if transfer.approvedBy(transfer.FromTeam) && transfer.approvedBy(transfer.ToTeam) {
+		transfer.Status = TransferInProgress
+	}
+	return transfer.snapshot(), nil
+}
+
+func (t *Transfers) finish(id, status string, relabeled []string, message string) Transfer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	transfer := t.transfers[id]
+	transfer.Status = status
+	transfer.Relabeled = relabeled
+	transfer.Message = message
+
+	t.finished = append(t.finished, id)
+	if len(t.finished) > maxFinishedTransfers {
+		delete(t.transfers, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+	return transfer.snapshot()
+}
+
+// List returns the transfers naisd keeps, in the order they were requested
+func (t *Transfers) List() []Transfer {
+	transfers := []Transfer{}
+	if t == nil {
+		return transfers
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, transfer := range t.transfers {
+		transfers = append(transfers, transfer.snapshot())
+	}
+	sort.SliceStable(transfers, func(i, j int) bool { return transfers[i].Requested.Before(transfers[j].Requested) })
+	return transfers
+}
+
+// inProgress counts the transfers that have been approved and are being applied
+func (t *Transfers) inProgress() int {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	count := 0
+	for _, transfer := range t.transfers {
+		if transfer.Status == TransferInProgress {
+			count++
+		}
+	}
+	return count
+}
+
+// Replace replaces the transfers with imported ones. Transfers waiting for approval can still be approved here, while
+// one that was being applied by another naisd has failed, and has to be requested again.
+func (t *Transfers) Replace(imported []Transfer) {
+	if t == nil {
+		return
+	}
+
+	transfers := make(map[string]*Transfer)
+	var finished []string
+	for _, transfer := range imported {
+		transfer = transfer.snapshot()
+		if transfer.Status == TransferInProgress {
+			transfer.Status = TransferFailed
+			transfer.Message = interruptedByStateImport
+		}
+
+		transfers[transfer.Id] = &transfer
+		if transfer.Status != TransferAwaitingApproval {
+			finished = append(finished, transfer.Id)
+		}
+	}
+	if len(finished) > maxFinishedTransfers {
+		for _, id := range finished[:len(finished)-maxFinishedTransfers] {
+			delete(transfers, id)
+		}
+		finished = finished[len(finished)-maxFinishedTransfers:]
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.transfers = transfers
+	t.finished = finished
+}
+
+func setTeamLabel(meta *k8smeta.ObjectMeta, team string) {
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	meta.Labels["team"] = team
+}
+
+// transferApplication labels the objects of the application with the new team, and routes its alerts to it. The
+// deployment is annotated with the team it was transferred from, so deployments from its manifest are rejected. Returns
+// the kinds of objects that were relabeled.
+func transferApplication(namespace, application, fromTeam, toTeam string, k8sClient kubernetes.Interface) ([]string, error) {
+	var relabeled []string
+
+	deployment, err := getExistingDeployment(application, namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	}
+	if deployment == nil {
+		return relabeled, fmt.Errorf("application %s not found in %s", application, namespace)
+	}
+	setTeamLabel(&deployment.ObjectMeta, toTeam)
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Annotations[TransferredFromAnnotation] = fromTeam
+	if _, err := deployments(k8sClient, namespace).Update(deployment); err != nil {
+		return relabeled, fmt.Errorf("unable to relabel deployment: %s", err)
+	}
+	relabeled = append(relabeled, "deployment")
+
+	service, err := getExistingService(application, namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	} else if service != nil {
+		setTeamLabel(&service.ObjectMeta, toTeam)
+		if _, err := k8sClient.CoreV1().Services(namespace).Update(service); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel service: %s", err)
+		}
+		relabeled = append(relabeled, "service")
+	}
+
+	secret, err := getExistingSecret(application, namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	} else if secret != nil {
+		setTeamLabel(&secret.ObjectMeta, toTeam)
+		if _, err := k8sClient.CoreV1().Secrets(namespace).Update(secret); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel secret: %s", err)
+		}
+		relabeled = append(relabeled, "secret")
+	}
+
+	ingress, err := getExistingIngress(application, namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	} else if ingress != nil {
+		setTeamLabel(&ingress.ObjectMeta, toTeam)
+		if _, err := ingresses(k8sClient, namespace).Update(ingress); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel ingress: %s", err)
+		}
+		relabeled = append(relabeled, "ingress")
+	}
+
+	autoscaler, err := getExistingAutoscaler(application, namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	} else if autoscaler != nil {
+		setTeamLabel(&autoscaler.ObjectMeta, toTeam)
+		if _, err := k8sClient.AutoscalingV1().HorizontalPodAutoscalers(namespace).Update(autoscaler); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel autoscaler: %s", err)
+		}
+		relabeled = append(relabeled, "autoscaler")
+	}
+
+	featureToggles, err := getExistingConfigMap(featureToggleConfigMapName(application), namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	} else if featureToggles != nil {
+		setTeamLabel(&featureToggles.ObjectMeta, toTeam)
+		if _, err := k8sClient.CoreV1().ConfigMaps(namespace).Update(featureToggles); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel feature toggles: %s", err)
+		}
+		relabeled = append(relabeled, "feature toggles")
+	}
+
+	networkPolicy, err := getExistingNetworkPolicy(application, namespace, k8sClient)
+	if err != nil {
+		return relabeled, err
+	} else if networkPolicy != nil {
+		setTeamLabel(&networkPolicy.ObjectMeta, toTeam)
+		if _, err := k8sClient.NetworkingV1().NetworkPolicies(namespace).Update(networkPolicy); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel network policy: %s", err)
+		}
+		relabeled = append(relabeled, "network policy")
+	}
+
+	serviceAccount, err := k8sClient.CoreV1().ServiceAccounts(namespace).Get(application, k8smeta.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return relabeled, fmt.Errorf("unable to get service account: %s", err)
+	} else if err == nil {
+		setTeamLabel(&serviceAccount.ObjectMeta, toTeam)
+		if _, err := k8sClient.CoreV1().ServiceAccounts(namespace).Update(serviceAccount); err != nil {
+			return relabeled, fmt.Errorf("unable to relabel service account: %s", err)
+		}
+		relabeled = append(relabeled, "service account")
+	}
+
+	roleBindings, err := applicationRoleBindings(namespace, application, k8sClient)
+	if err != nil {
+		return relabeled, err
+	}
+	for _, roleBinding := range roleBindings {
+		setTeamLabel(&roleBinding.ObjectMeta, toTeam)
+		for i, subject := range roleBinding.Subjects {
+			if subject.Kind == k8srbac.GroupKind && subject.Name == fromTeam {
+				roleBinding.Subjects[i].Name = toTeam
+			}
+		}
+		if _, err := k8sClient.RbacV1().RoleBindings(namespace).Update(&roleBinding); err != nil {
+			return relabeled, fmt.Errorf("unable to update role binding %s: %s", roleBinding.Name, err)
+		}
+	}
+	if len(roleBindings) > 0 {
+		relabeled = append(relabeled, "role bindings")
+	}
+
+	routed, err := routeAlertsToTeam(namespace, application, toTeam, k8sClient)
+	if err != nil {
+		return relabeled, err
+	}
+	if routed {
+		relabeled = append(relabeled, "alert rules")
+	}
+
+	return relabeled, nil
+}
+
+// applicationRoleBindings are the role bindings labelled with the application, which give its team access to it
+func applicationRoleBindings(namespace, application string, k8sClient kubernetes.Interface) ([]k8srbac.RoleBinding, error) {
+	roleBindings, err := k8sClient.RbacV1().RoleBindings(namespace).List(k8smeta.ListOptions{LabelSelector: "app=" + application})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list role bindings: %s", err)
+	}
+	return roleBindings.Items, nil
+}
+
+// transferNamespace is the namespace the application moves to when it is transferred. Namespaces belong to teams when
+// they are labelled with the team; an application in a namespace of the team it is transferred from moves to the
+// namespace of the team it is transferred to. It is empty when the application stays where it is.
+func transferNamespace(namespace, fromTeam, toTeam string, k8sClient kubernetes.Interface) (string, error) {
+	current, err := k8sClient.CoreV1().Namespaces().Get(namespace, k8smeta.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("unable to get namespace %s: %s", namespace, err)
+	}
+	if current.Labels["team"] != fromTeam {
+		return "", nil
+	}
+
+	namespaces, err := k8sClient.CoreV1().Namespaces().List(k8smeta.ListOptions{LabelSelector: "team=" + toTeam})
+	if err != nil {
+		return "", fmt.Errorf("unable to list the namespaces of team %s: %s", toTeam, err)
+	}
+	if len(namespaces.Items) != 1 {
+		return "", fmt.Errorf("namespace %s belongs to team %s, and team %s has %d namespaces to move the application to, not one", namespace, fromTeam, toTeam, len(namespaces.Items))
+	}
+	return namespaces.Items[0].Name, nil
+}
+
+// movedMeta is the metadata of a copy of an object in another namespace
+func movedMeta(meta k8smeta.ObjectMeta, namespace string) k8smeta.ObjectMeta {
+	return k8smeta.ObjectMeta{Name: meta.Name, Namespace: namespace, Labels: meta.Labels, Annotations: meta.Annotations}
+}
+
+// moveApplication moves the objects of the application to another namespace, by creating copies of them there and
+// deleting the originals. If a copy can not be created, the copies are deleted again and the application is left
+// where it was. Returns the kinds of objects that were moved.
+func moveApplication(fromNamespace, toNamespace, application string, k8sClient kubernetes.Interface) ([]string, error) {
+	var moved []string
+	var undo []func() error
+
+	rollback := func(err error) ([]string, error) {
+		for _, deleteCopy := range undo {
+			if undoErr := deleteCopy(); undoErr != nil {
+				glog.Errorf("unable to delete copy of %s in %s after failed move: %s", application, toNamespace, undoErr)
+			}
+		}
+		return nil, err
+	}
+
+	deployment, err := getExistingDeployment(application, fromNamespace, k8sClient)
+	if err != nil {
+		return nil, err
+	}
+	if deployment == nil {
+		return nil, fmt.Errorf("application %s not found in %s", application, fromNamespace)
+	}
+	deployment.ObjectMeta = movedMeta(deployment.ObjectMeta, toNamespace)
+	deployment.Status = k8sextensions.DeploymentStatus{}
+	if _, err := deployments(k8sClient, toNamespace).Create(deployment); err != nil {
+		return rollback(fmt.Errorf("unable to move deployment: %s", err))
+	}
+	undo = append(undo, func() error { _, err := deleteDeployment(toNamespace, application, k8sClient); return err })
+	moved = append(moved, "deployment")
+
+	service, err := getExistingService(application, fromNamespace, k8sClient)
+	if err != nil {
+		return rollback(err)
+	} else if service != nil {
+		service.ObjectMeta = movedMeta(service.ObjectMeta, toNamespace)
+		// the cluster IP belongs to the service in the old namespace
+		service.Spec.ClusterIP = ""
+		service.Status = k8score.ServiceStatus{}
+		if _, err := k8sClient.CoreV1().Services(toNamespace).Create(service); err != nil {
+			return rollback(fmt.Errorf("unable to move service: %s", err))
+		}
+		undo = append(undo, func() error { _, err := deleteService(toNamespace, application, k8sClient); return err })
+		moved = append(moved, "service")
+	}
+
+	secret, err := getExistingSecret(application, fromNamespace, k8sClient)
+	if err != nil {
+		return rollback(err)
+	} else if secret != nil {
+		secret.ObjectMeta = movedMeta(secret.ObjectMeta, toNamespace)
+		if _, err := k8sClient.CoreV1().Secrets(toNamespace).Create(secret); err != nil {
+			return rollback(fmt.Errorf("unable to move secret: %s", err))
+		}
+		undo = append(undo, func() error { _, err := deleteSecret(toNamespace, application, k8sClient); return err })
+		moved = append(moved, "secret")
+	}
+
+	ingress, err := getExistingIngress(application, fromNamespace, k8sClient)
+	if err != nil {
+		return rollback(err)
+	} else if ingress != nil {
+		ingress.ObjectMeta = movedMeta(ingress.ObjectMeta, toNamespace)
+		ingress.Status = k8sextensions.IngressStatus{}
+		if _, err := ingresses(k8sClient, toNamespace).Create(ingress); err != nil {
+			return rollback(fmt.Errorf("unable to move ingress: %s", err))
+		}
+		undo = append(undo, func() error { _, err := deleteIngress(toNamespace, application, k8sClient); return err })
+		moved = append(moved, "ingress")
+	}
+
+	autoscaler, err := getExistingAutoscaler(application, fromNamespace, k8sClient)
+	if err != nil {
+		return rollback(err)
+	} else if autoscaler != nil {
+		autoscaler.ObjectMeta = movedMeta(autoscaler.ObjectMeta, toNamespace)
+		autoscaler.Status = k8sautoscaling.HorizontalPodAutoscalerStatus{}
+		if _, err := k8sClient.AutoscalingV1().HorizontalPodAutoscalers(toNamespace).Create(autoscaler); err != nil {
+			return rollback(fmt.Errorf("unable to move autoscaler: %s", err))
+		}
+		undo = append(undo, func() error { _, err := deleteAutoscaler(toNamespace, application, k8sClient); return err })
+		moved = append(moved, "autoscaler")
+	}
+
+	featureToggles, err := getExistingConfigMap(featureToggleConfigMapName(application), fromNamespace, k8sClient)
+	if err != nil {
+		return rollback(err)
+	} else if featureToggles != nil {
+		featureToggles.ObjectMeta = movedMeta(featureToggles.ObjectMeta, toNamespace)
+		if _, err := k8sClient.CoreV1().ConfigMaps(toNamespace).Create(featureToggles); err != nil {
+			return rollback(fmt.Errorf("unable to move feature toggles: %s", err))
+		}
+		undo = append(undo, func() error { _, err := deleteFeatureToggles(toNamespace, application, k8sClient); return err })
+		moved = append(moved, "feature toggles")
+	}
+
+	networkPolicy, err := getExistingNetworkPolicy(application, fromNamespace, k8sClient)
+	if err != nil {
+		return rollback(err)
+	} else if networkPolicy != nil {
+		networkPolicy.ObjectMeta = movedMeta(networkPolicy.ObjectMeta, toNamespace)
+		if _, err := k8sClient.NetworkingV1().NetworkPolicies(toNamespace).Create(networkPolicy); err != nil {
+			return rollback(fmt.Errorf("unable to move network policy: %s", err))
+		}
+		undo = append(undo, func() error { _, err := deleteNetworkPolicy(toNamespace, application, k8sClient); return err })
+		moved = append(moved, "network policy")
+	}
+
+	serviceAccount, err := k8sClient.CoreV1().ServiceAccounts(fromNamespace).Get(application, k8smeta.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return rollback(fmt.Errorf("unable to get service account: %s", err))
+	} else if err == nil {
+		serviceAccount.ObjectMeta = movedMeta(serviceAccount.ObjectMeta, toNamespace)
+		// the token secrets belong to the service account in the old namespace
+		serviceAccount.Secrets = nil
+		if _, err := k8sClient.CoreV1().ServiceAccounts(toNamespace).Create(serviceAccount); err != nil {
+			return rollback(fmt.Errorf("unable to move service account: %s", err))
+		}
+		undo = append(undo, func() error { return NewServiceAccountInterface(k8sClient).Delete(application, toNamespace) })
+		moved = append(moved, "service account")
+	}
+
+	roleBindings, err := applicationRoleBindings(fromNamespace, application, k8sClient)
+	if err != nil {
+		return rollback(err)
+	}
+	for _, roleBinding := range roleBindings {
+		name := roleBinding.Name
+		roleBinding.ObjectMeta = movedMeta(roleBinding.ObjectMeta, toNamespace)
+		for i, subject := range roleBinding.Subjects {
+			if subject.Kind == k8srbac.ServiceAccountKind && subject.Namespace == fromNamespace {
+				roleBinding.Subjects[i].Namespace = toNamespace
+			}
+		}
+		if _, err := k8sClient.RbacV1().RoleBindings(toNamespace).Create(&roleBinding); err != nil {
+			return rollback(fmt.Errorf("unable to move role binding %s: %s", name, err))
+		}
+		undo = append(undo, func() error {
+			return k8sClient.RbacV1().RoleBindings(toNamespace).Delete(name, &k8smeta.DeleteOptions{})
+		})
+	}
+	if len(roleBindings) > 0 {
+		moved = append(moved, "role bindings")
+	}
+
+	movedAlerts, err := moveAlertRules(fromNamespace, toNamespace, application, k8sClient)
+	if err != nil {
+		return rollback(err)
+	}
+	if movedAlerts {
+		moved = append(moved, "alert rules")
+	}
+
+	// the copies are complete, so the originals can go. Their alert rules have already moved.
+	if _, err := deleteK8sResouces(fromNamespace, application, k8sClient); err != nil {
+		return moved, fmt.Errorf("moved %s to %s, but unable to delete it from %s: %s", application, toNamespace, fromNamespace, err)
+	}
+	for _, roleBinding := range roleBindings {
+		if err := k8sClient.RbacV1().RoleBindings(fromNamespace).Delete(roleBinding.Name, &k8smeta.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return moved, fmt.Errorf("moved %s to %s, but unable to delete role binding %s from %s: %s", application, toNamespace, roleBinding.Name, fromNamespace, err)
+		}
+	}
+
+	return moved, nil
+}
+
+// moveAlertRules moves the alert rules of the application to the rule group of the namespace it moves to
+func moveAlertRules(fromNamespace, toNamespace, application string, k8sClient kubernetes.Interface) (bool, error) {
+	configMap, err := getExistingConfigMap(AlertsConfigMapName, AlertsConfigMapNamespace, k8sClient)
+	if err != nil {
+		return false, fmt.Errorf("unable to get existing configmap: %s", err)
+	}
+
+	fromKey := createDeploymentPrefix(fromNamespace, application) + ".yml"
+	if configMap == nil || len(configMap.Data[fromKey]) == 0 {
+		return false, nil
+	}
+
+	var alertGroups PrometheusAlertGroups
+	if err := yaml.Unmarshal([]byte(configMap.Data[fromKey]), &alertGroups); err != nil {
+		return false, fmt.Errorf("unable to parse alert rules of %s: %s", application, err)
+	}
+	for i := range alertGroups.Groups {
+		alertGroups.Groups[i].Name = createDeploymentPrefix(toNamespace, application)
+	}
+
+	alertGroupYamlBytes, err := yaml.Marshal(alertGroups)
+	if err != nil {
+		return false, fmt.Errorf("unable to marshal alert rules of %s: %s", application, err)
+	}
+	configMap.Data[createDeploymentPrefix(toNamespace, application)+".yml"] = string(alertGroupYamlBytes)
+
+	if _, err := createOrUpdateConfigMapResource(configMap, AlertsConfigMapNamespace, k8sClient); err != nil {
+		return false, fmt.Errorf("unable to move alert rules: %s", err)
+	}
+	return true, nil
+}
+
+// routeAlertsToTeam sets the team label of the application's alert rules, which Alertmanager routes alerts by
+func routeAlertsToTeam(namespace, application, team string, k8sClient kubernetes.Interface) (bool, error) {
+	configMap, err := getExistingConfigMap(AlertsConfigMapName, AlertsConfigMapNamespace, k8sClient)
+	if err != nil {
+		return false, fmt.Errorf("unable to get existing configmap: %s", err)
+	}
+
+	key := createDeploymentPrefix(namespace, application) + ".yml"
+	if configMap == nil || len(configMap.Data[key]) == 0 {
+		return false, nil
+	}
+
+	var alertGroups PrometheusAlertGroups
+	if err := yaml.Unmarshal([]byte(configMap.Data[key]), &alertGroups); err != nil {
+		return false, fmt.Errorf("unable to parse alert rules of %s: %s", application, err)
+	}
+	for _, group := range alertGroups.Groups {
+		addTeamLabel(group.Rules, team)
+	}
+
+	alertGroupYamlBytes, err := yaml.Marshal(alertGroups)
+	if err != nil {
+		return false, fmt.Errorf("unable to marshal alert rules of %s: %s", application, err)
+	}
+	configMap.Data[key] = string(alertGroupYamlBytes)
+
+	if _, err := createOrUpdateConfigMapResource(configMap, AlertsConfigMapNamespace, k8sClient); err != nil {
+		return false, fmt.Errorf("unable to update alert rules: %s", err)
+	}
+	return true, nil
+}
+
+// checkTransferredFrom rejects deployments of an application by the team it has been transferred from, as its manifest
+// would otherwise make the application theirs again, e.g. by using their Fasit credentials
+func checkTransferredFrom(namespace, application, team string, k8sClient kubernetes.Interface) error {
+	deployment, err := getExistingDeployment(application, namespace, k8sClient)
+	if err != nil {
+		// the deployment fails anyway if the cluster can not be reached
+		glog.Warningf("unable to check if %s has been transferred: %s", application, err)
+		return nil
+	}
+	if deployment == nil {
+		return nil
+	}
+
+	if fromTeam := deployment.Annotations[TransferredFromAnnotation]; len(fromTeam) > 0 && fromTeam == team {
+		return fmt.Errorf("%s has been transferred from team %s to team %s, so the manifest must name team %s", application, fromTeam, deployment.Labels["team"], deployment.Labels["team"])
+	}
+	return nil
+}
+
+func (api Api) requestTransfer(w http.ResponseWriter, r *http.Request) *appError {
+	var request naisrequest.Transfer
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return &appError{err, "unable to unmarshal transfer", http.StatusBadRequest}
+	}
+
+	if errs := request.Validate(); len(errs) > 0 {
+		var messages []string
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return &appError{fmt.Errorf("%s", strings.Join(messages, ", ")), "invalid transfer", http.StatusBadRequest}
+	}
+
+	deployment, err := getExistingDeployment(request.Application, request.Namespace, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to get deployment", http.StatusInternalServerError}
+	}
+	if deployment == nil {
+		return &appError{fmt.Errorf("application %s not found in %s", request.Application, request.Namespace), "application not found", http.StatusNotFound}
+	}
+	if owner := deployment.Labels["team"]; owner != request.FromTeam {
+		return &appError{fmt.Errorf("%s belongs to team %q, not %s", request.Application, owner, request.FromTeam), "application does not belong to fromTeam", http.StatusConflict}
+	}
+
+	toNamespace, err := transferNamespace(request.Namespace, request.FromTeam, request.ToTeam, api.Clientset)
+	if err != nil {
+		return &appError{err, "unable to find the namespace to move the application to", http.StatusConflict}
+	}
+
+	transfer := api.Transfers.request(request, toNamespace, time.Now())
+	api.AuditLog.Record(AuditEntry{
+		Event:       "transfer_requested",
+		Application: transfer.Application,
+		Namespace:   transfer.Namespace,
+		Details:     map[string]string{"id": transfer.Id, "fromTeam": transfer.FromTeam, "toTeam": transfer.ToTeam, "toNamespace": transfer.ToNamespace, "requestedBy": transfer.RequestedBy},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(transfer); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+func (api Api) getTransfer(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	transfer, ok := api.Transfers.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("transfer %s not found", id), "transfer not found", http.StatusNotFound}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(transfer); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
+
+// approvingTeam is the team of the transfer the identity approves it for: the one named in the approval, or else the
+// one party it acts for that has yet to approve
+func approvingTeam(identity Identity, transfer Transfer, team string) (string, *appError) {
+	if len(team) > 0 {
+		if !identity.actsFor(team) {
+			return "", &appError{fmt.Errorf("%s does not act for team %s", identity.Name, team), "not authorized for team", http.StatusForbidden}
+		}
+		return team, nil
+	}
+
+	var parties, unapproved []string
+	for _, party := range []string{transfer.FromTeam, transfer.ToTeam} {
+		if identity.actsFor(party) {
+			parties = append(parties, party)
+			if !transfer.approvedBy(party) {
+				unapproved = append(unapproved, party)
+			}
+		}
+	}
+	switch {
+	case len(parties) == 0:
+		return "", &appError{fmt.Errorf("%s acts for neither team of transfer %s", identity.Name, transfer.Id), "not authorized for team", http.StatusForbidden}
+	case len(unapproved) == 0:
+		return "", &appError{fmt.Errorf("%s has already approved transfer %s", strings.Join(parties, " and "), transfer.Id), "unable to approve transfer", http.StatusConflict}
+	case len(unapproved) > 1:
+		return "", &appError{fmt.Errorf("%s acts for both teams of transfer %s, so the approval must name the team", identity.Name, transfer.Id), "invalid approval", http.StatusBadRequest}
+	}
+	return unapproved[0], nil
+}
+
+// approveTransfer records the approval of one of the teams by an identity acting for it, and transfers the application
+// once both have approved
+func (api Api) approveTransfer(w http.ResponseWriter, r *http.Request) *appError {
+	id := pat.Param(r, "id")
+
+	identity, ok := api.authenticate(r)
+	if !ok {
+		return &appError{fmt.Errorf("missing or unknown bearer token"), "not authorized", http.StatusUnauthorized}
+	}
+
+	var approval naisrequest.TransferApproval
+	if err := json.NewDecoder(r.Body).Decode(&approval); err != nil && err != io.EOF {
+		return &appError{err, "unable to unmarshal approval", http.StatusBadRequest}
+	}
+
+	pending, ok := api.Transfers.Get(id)
+	if !ok {
+		return &appError{fmt.Errorf("transfer %s not found", id), "transfer not found", http.StatusNotFound}
+	}
+
+	team, appErr := approvingTeam(identity, pending, approval.Team)
+	if appErr != nil {
+		return appErr
+	}
+
+	transfer, err := api.Transfers.approve(id, team, identity.Name, time.Now())
+	if err != nil {
+		return &appError{err, "unable to approve transfer", http.StatusConflict}
+	}
+	api.AuditLog.Record(AuditEntry{
+		Event:       "transfer_approved",
+		Application: transfer.Application,
+		Namespace:   transfer.Namespace,
+		Details:     map[string]string{"id": transfer.Id, "team": team, "approvedBy": identity.Name},
+	})
+
+	if transfer.Status == TransferInProgress {
+		relabeled, err := transferApplication(transfer.Namespace, transfer.Application, transfer.FromTeam, transfer.ToTeam, api.Clientset)
+		if err == nil && len(transfer.ToNamespace) > 0 {
+			var moved []string
+			if moved, err = moveApplication(transfer.Namespace, transfer.ToNamespace, transfer.Application, api.Clientset); len(moved) > 0 {
+				relabeled = append(relabeled, "moved to "+transfer.ToNamespace+": "+strings.Join(moved, ", "))
+			}
+		}
+		if err != nil {
+			api.Transfers.finish(id, TransferFailed, relabeled, err.Error())
+			return &appError{err, "unable to transfer application", http.StatusInternalServerError}
+		}
+		transfer = api.Transfers.finish(id, TransferCompleted, relabeled, "")
+
+		var approvedBy []string
+		for _, approval := range transfer.Approvals {
+			approvedBy = append(approvedBy, approval.Team+":"+approval.ApprovedBy)
+		}
+		api.AuditLog.Record(AuditEntry{
+			Event:       "application_transferred",
+			Application: transfer.Application,
+			Namespace:   transfer.Namespace,
+			Details:     map[string]string{"id": transfer.Id, "fromTeam": transfer.FromTeam, "toTeam": transfer.ToTeam, "toNamespace": transfer.ToNamespace, "approvedBy": strings.Join(approvedBy, ",")},
+		})
+		glog.Infof("Transferred %s in %s from team %s to team %s\n", transfer.Application, transfer.Namespace, transfer.FromTeam, transfer.ToTeam)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(transfer); err != nil {
+		return &appError{err, "unable to encode JSON", http.StatusInternalServerError}
+	}
+	return nil
+}
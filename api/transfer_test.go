@@ -0,0 +1,266 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	k8score "k8s.io/api/core/v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8srbac "k8s.io/api/rbac/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTransferValidation(t *testing.T) {
+	assert.Empty(t, naisrequest.Transfer{Application: appName, Namespace: namespace, FromTeam: "a", ToTeam: "b"}.Validate())
+	assert.Len(t, naisrequest.Transfer{Application: appName, Namespace: namespace, FromTeam: "a", ToTeam: "a"}.Validate(), 1)
+	assert.Len(t, naisrequest.Transfer{}.Validate(), 4)
+}
+
+func TestTransferApprovals(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	transfers := NewTransfers()
+	transfer := transfers.request(naisrequest.Transfer{Application: appName, Namespace: namespace, FromTeam: "a", ToTeam: "b"}, "", now)
+	assert.Equal(t, TransferAwaitingApproval, transfer.Status)
+
+	_, err := transfers.approve(transfer.Id, "c", "carol", now)
+	assert.EqualError(t, err, "team c is not a party to transfer "+transfer.Id)
+
+	transfer, err = transfers.approve(transfer.Id, "a", "alice", now)
+	assert.NoError(t, err)
+	assert.Equal(t, TransferAwaitingApproval, transfer.Status, "the other team has not approved yet")
+
+	_, err = transfers.approve(transfer.Id, "a", "anna", now)
+	assert.EqualError(t, err, "team a has already approved transfer "+transfer.Id)
+
+	transfer, err = transfers.approve(transfer.Id, "b", "bob", now)
+	assert.NoError(t, err)
+	assert.Equal(t, TransferInProgress, transfer.Status)
+	assert.Len(t, transfer.Approvals, 2)
+
+	_, err = transfers.approve(transfer.Id, "b", "bob", now)
+	assert.Error(t, err, "a transfer in progress can not be approved again")
+
+	_, err = transfers.approve("unknown", "a", "alice", now)
+	assert.EqualError(t, err, "transfer unknown not found")
+}
+
+func TestTransferApplication(t *testing.T) {
+	alerts := alertsConfigMap()
+	alertRules, _ := yaml.Marshal(PrometheusAlertGroups{Groups: []PrometheusAlertGroup{{
+		Name:  createDeploymentPrefix(namespace, appName),
+		Rules: []PrometheusAlertRule{{Alert: "down", Expr: "up == 0", Labels: map[string]string{"team": "a"}}},
+	}}})
+	alerts.Data = map[string]string{createDeploymentPrefix(namespace, appName) + ".yml": string(alertRules)}
+
+	clientset := fake.NewSimpleClientset(
+		&k8sextensions.Deployment{ObjectMeta: createObjectMeta(appName, namespace, "a")},
+		createServiceDef(appName, namespace, "a"),
+		createIngressDef(appName, namespace, "a"),
+		&k8score.Secret{ObjectMeta: createObjectMeta(appName, namespace, "a")},
+		&k8score.ServiceAccount{ObjectMeta: createObjectMeta(appName, namespace, "a")},
+		teamRoleBinding(namespace, "a"),
+		alerts,
+	)
+
+	relabeled, err := transferApplication(namespace, appName, "a", "b", clientset)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deployment", "service", "secret", "ingress", "service account", "role bindings", "alert rules"}, relabeled)
+
+	roleBinding, _ := clientset.RbacV1().RoleBindings(namespace).Get(appName+"-team", k8smeta.GetOptions{})
+	assert.Equal(t, "b", roleBinding.Labels["team"])
+	assert.Equal(t, "b", roleBinding.Subjects[0].Name, "the new team gets access to the application")
+	assert.Equal(t, "someone", roleBinding.Subjects[1].Name)
+
+	deployment, _ := getExistingDeployment(appName, namespace, clientset)
+	assert.Equal(t, "b", deployment.Labels["team"])
+	assert.Equal(t, "a", deployment.Annotations[TransferredFromAnnotation])
+	ingress, _ := getExistingIngress(appName, namespace, clientset)
+	assert.Equal(t, "b", ingress.Labels["team"])
+	serviceAccount, _ := clientset.CoreV1().ServiceAccounts(namespace).Get(appName, k8smeta.GetOptions{})
+	assert.Equal(t, "b", serviceAccount.Labels["team"])
+
+	configMap, _ := getExistingConfigMap(AlertsConfigMapName, AlertsConfigMapNamespace, clientset)
+	var groups PrometheusAlertGroups
+	assert.NoError(t, yaml.Unmarshal([]byte(configMap.Data[createDeploymentPrefix(namespace, appName)+".yml"]), &groups))
+	assert.Equal(t, "b", groups.Groups[0].Rules[0].Labels["team"])
+
+	t.Run("The team it was transferred from can no longer deploy it", func(t *testing.T) {
+		assert.Error(t, checkTransferredFrom(namespace, appName, "a", clientset))
+		assert.NoError(t, checkTransferredFrom(namespace, appName, "b", clientset))
+		assert.NoError(t, checkTransferredFrom(namespace, "other", "a", clientset))
+	})
+}
+
+func teamRoleBinding(namespace, team string) *k8srbac.RoleBinding {
+	meta := createObjectMeta(appName, namespace, team)
+	meta.Name = appName + "-team"
+	return &k8srbac.RoleBinding{
+		ObjectMeta: meta,
+		RoleRef:    k8srbac.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		Subjects: []k8srbac.Subject{
+			{Kind: k8srbac.GroupKind, Name: team},
+			{Kind: k8srbac.UserKind, Name: "someone"},
+			{Kind: k8srbac.ServiceAccountKind, Name: appName, Namespace: namespace},
+		},
+	}
+}
+
+func TestMoveApplication(t *testing.T) {
+	teamNamespace := func(name, team string) *k8score.Namespace {
+		return &k8score.Namespace{ObjectMeta: k8smeta.ObjectMeta{Name: name, Labels: map[string]string{"team": team}}}
+	}
+
+	t.Run("Applications only move out of namespaces of the team they are transferred from", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(teamNamespace("a", "a"), teamNamespace("b", "b"), &k8score.Namespace{ObjectMeta: k8smeta.ObjectMeta{Name: "shared"}})
+
+		toNamespace, err := transferNamespace("a", "a", "b", clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, "b", toNamespace)
+
+		toNamespace, err = transferNamespace("shared", "a", "b", clientset)
+		assert.NoError(t, err)
+		assert.Empty(t, toNamespace)
+
+		_, err = transferNamespace("a", "a", "c", clientset)
+		assert.Error(t, err, "team c has no namespace")
+	})
+
+	t.Run("Objects are moved and deleted from the old namespace", func(t *testing.T) {
+		alerts := alertsConfigMap()
+		alertRules, _ := yaml.Marshal(PrometheusAlertGroups{Groups: []PrometheusAlertGroup{{Name: createDeploymentPrefix("a", appName)}}})
+		alerts.Data = map[string]string{createDeploymentPrefix("a", appName) + ".yml": string(alertRules)}
+
+		service := createServiceDef(appName, "a", "b")
+		service.Spec.ClusterIP = "10.0.0.1"
+		clientset := fake.NewSimpleClientset(
+			&k8sextensions.Deployment{ObjectMeta: createObjectMeta(appName, "a", "b")},
+			service,
+			&k8score.Secret{ObjectMeta: createObjectMeta(appName, "a", "b")},
+			teamRoleBinding("a", "b"),
+			alerts,
+		)
+
+		moved, err := moveApplication("a", "b", appName, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"deployment", "service", "secret", "role bindings", "alert rules"}, moved)
+
+		deployment, _ := getExistingDeployment(appName, "b", clientset)
+		assert.NotNil(t, deployment)
+		movedService, _ := getExistingService(appName, "b", clientset)
+		assert.Empty(t, movedService.Spec.ClusterIP)
+		roleBinding, err := clientset.RbacV1().RoleBindings("b").Get(appName+"-team", k8smeta.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "b", roleBinding.Subjects[2].Namespace)
+
+		deployment, _ = getExistingDeployment(appName, "a", clientset)
+		assert.Nil(t, deployment)
+		_, err = clientset.RbacV1().RoleBindings("a").Get(appName+"-team", k8smeta.GetOptions{})
+		assert.Error(t, err)
+
+		configMap, _ := getExistingConfigMap(AlertsConfigMapName, AlertsConfigMapNamespace, clientset)
+		assert.NotContains(t, configMap.Data, createDeploymentPrefix("a", appName)+".yml")
+		assert.Contains(t, configMap.Data[createDeploymentPrefix("b", appName)+".yml"], createDeploymentPrefix("b", appName))
+	})
+
+	t.Run("A failed move leaves the application where it was", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(
+			&k8sextensions.Deployment{ObjectMeta: createObjectMeta(appName, "a", "b")},
+			&k8score.Secret{ObjectMeta: createObjectMeta(appName, "a", "b")},
+			&k8score.Secret{ObjectMeta: createObjectMeta(appName, "b", "b")},
+		)
+
+		_, err := moveApplication("a", "b", appName, clientset)
+		assert.Error(t, err)
+
+		deployment, _ := getExistingDeployment(appName, "b", clientset)
+		assert.Nil(t, deployment, "the copy is deleted again")
+		deployment, _ = getExistingDeployment(appName, "a", clientset)
+		assert.NotNil(t, deployment)
+	})
+}
+
+func TestTransferEndpoints(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&k8sextensions.Deployment{ObjectMeta: createObjectMeta(appName, namespace, "a")}, alertsConfigMap())
+	api := Api{Clientset: clientset, AuditLog: NewAuditLog(), Transfers: NewTransfers(), OperatorToken: "operator", Identities: []Identity{
+		{Name: "alice", Teams: []string{"a"}, Token: "alice-token"},
+		{Name: "bob", Teams: []string{"b"}, Token: "bob-token"},
+		{Name: "carol", Teams: []string{"c"}, Token: "carol-token"},
+	}}
+
+	post := func(path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Only the team owning the application can transfer it", func(t *testing.T) {
+		rr := post("/transfer", "", `{"application": "`+appName+`", "namespace": "`+namespace+`", "fromTeam": "c", "toTeam": "b"}`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		rr = post("/transfer", "", `{"application": "unknown", "namespace": "`+namespace+`", "fromTeam": "a", "toTeam": "b"}`)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Application is transferred once both teams have approved", func(t *testing.T) {
+		rr := post("/transfer", "", `{"application": "`+appName+`", "namespace": "`+namespace+`", "fromTeam": "a", "toTeam": "b", "requestedBy": "alice"}`)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var transfer Transfer
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&transfer))
+		approve := "/transfer/" + transfer.Id + "/approve"
+
+		assert.Equal(t, http.StatusUnauthorized, post(approve, "", `{"team": "a"}`).Code)
+		assert.Equal(t, http.StatusForbidden, post(approve, "carol-token", `{}`).Code, "carol acts for neither team")
+		assert.Equal(t, http.StatusForbidden, post(approve, "bob-token", `{"team": "a"}`).Code, "bob can not approve for team a")
+
+		rr = post(approve, "alice-token", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		deployment, _ := getExistingDeployment(appName, namespace, clientset)
+		assert.Equal(t, "a", deployment.Labels["team"], "nothing is changed before both teams have approved")
+		assert.Equal(t, http.StatusConflict, post(approve, "alice-token", "").Code, "team a has already approved")
+
+		rr = post(approve, "bob-token", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&transfer))
+		assert.Equal(t, TransferCompleted, transfer.Status)
+
+		deployment, _ = getExistingDeployment(appName, namespace, clientset)
+		assert.Equal(t, "b", deployment.Labels["team"])
+
+		entries := api.AuditLog.Entries()
+		last := entries[len(entries)-1]
+		assert.Equal(t, "application_transferred", last.Event)
+		assert.Equal(t, "a:alice,b:bob", last.Details["approvedBy"])
+
+		req, _ := http.NewRequest("GET", "/transfer/"+transfer.Id, nil)
+		rr = httptest.NewRecorder()
+		api.Handler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("The operator names the team it approves for", func(t *testing.T) {
+		transfer := api.Transfers.request(naisrequest.Transfer{Application: appName, Namespace: namespace, FromTeam: "b", ToTeam: "c"}, "", time.Now())
+		approve := "/transfer/" + transfer.Id + "/approve"
+
+		assert.Equal(t, http.StatusBadRequest, post(approve, "operator", "").Code)
+		assert.Equal(t, http.StatusOK, post(approve, "operator", `{"team": "b"}`).Code)
+
+		transfer, _ = api.Transfers.Get(transfer.Id)
+		assert.Equal(t, OperatorIdentity, transfer.Approvals[0].ApprovedBy)
+	})
+
+	t.Run("Unknown transfers are not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, post("/transfer/unknown/approve", "alice-token", `{"team": "a"}`).Code)
+	})
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const (
+	PhaseVerification = "verification"
+
+	VerificationTargetService = "service"
+	VerificationTargetIngress = "ingress"
+
+	defaultVerificationRetries = 3
+	maxVerificationRetries     = 60
+	maxVerificationBodySize    = 1024 * 1024
+)
+
+// How long naisd waits between the attempts of a check, unless the manifest says otherwise
+var defaultVerificationInterval = 5 * time.Second
+
+var verificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// Verification is a smoke test of a new version once it has rolled out. Each check is a GET of a path on the service
+// of the application, or on its ingress, that must respond with the expected status and a body matching a regular
+// expression. A deployment whose checks fail is failed, and with rollback the last successful deployment of the
+// application is applied again.
+type Verification struct {
+	Target   string
+	Rollback bool
+	Checks   []VerificationCheck
+}
+
+// VerificationCheck is tried until it passes, at most retries times after the first attempt
+type VerificationCheck struct {
+	Path     string
+	Status   int
+	Body     string
+	Retries  *int
+	Interval string
+}
+
+func (check VerificationCheck) status() int {
+	if check.Status == 0 {
+		return http.StatusOK
+	}
+	return check.Status
+}
+
+func (check VerificationCheck) retries() int {
+	if check.Retries == nil {
+		return defaultVerificationRetries
+	}
+	return *check.Retries
+}
+
+func (check VerificationCheck) interval() time.Duration {
+	if interval, err := time.ParseDuration(check.Interval); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultVerificationInterval
+}
+
+func validateVerification(manifest NaisManifest) *ValidationError {
+	verification := manifest.Verification
+	if len(verification.Checks) == 0 {
+		return nil
+	}
+
+	switch verification.Target {
+	case "", VerificationTargetService:
+	case VerificationTargetIngress:
+		if manifest.Ingress.Disabled {
+			return &ValidationError{
+				"Verification can not target the ingress when it is disabled",
+				map[string]string{"Verification.Target": verification.Target},
+			}
+		}
+	default:
+		return &ValidationError{
+			fmt.Sprintf("Verification target must be %s or %s", VerificationTargetService, VerificationTargetIngress),
+			map[string]string{"Verification.Target": verification.Target},
+		}
+	}
+
+	for _, check := range verification.Checks {
+		if !strings.HasPrefix(check.Path, "/") {
+			return &ValidationError{
+				"Verification checks must have a path starting with /",
+				map[string]string{"Verification.Checks.Path": check.Path},
+			}
+		}
+		if check.Status != 0 && (check.Status < 100 || check.Status > 599) {
+			return &ValidationError{
+				"Verification check status must be an HTTP status code",
+				map[string]string{"Verification.Checks.Path": check.Path, "Status": fmt.Sprint(check.Status)},
+			}
+		}
+		if _, err := regexp.Compile(check.Body); err != nil {
+			return &ValidationError{
+				"Verification check body must be a valid regular expression",
+				map[string]string{"Verification.Checks.Path": check.Path, "Body": check.Body},
+			}
+		}
+		if check.retries() < 0 || check.retries() > maxVerificationRetries {
+			return &ValidationError{
+				fmt.Sprintf("Verification check retries must be between 0 and %d", maxVerificationRetries),
+				map[string]string{"Verification.Checks.Path": check.Path, "Retries": fmt.Sprint(check.retries())},
+			}
+		}
+		if len(check.Interval) > 0 {
+			if interval, err := time.ParseDuration(check.Interval); err != nil || interval <= 0 {
+				return &ValidationError{
+					"Verification check interval must be a positive duration, e.g. 5s",
+					map[string]string{"Verification.Checks.Path": check.Path, "Interval": check.Interval},
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// verificationUrl is where the checks are sent: the service of the application inside the cluster, or the hostname of
+// its ingress
+func verificationUrl(deploymentRequest naisrequest.Deploy, verification Verification, clusterSubdomain string) string {
+	if verification.Target == VerificationTargetIngress {
+		return "https://" + createIngressHostname(deploymentRequest.Application, deploymentRequest.Namespace, clusterSubdomain)
+	}
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local", deploymentRequest.Application, deploymentRequest.Namespace)
+}
+
+// verifyDeployment runs the checks of the manifest against the new version. If one of them fails, and the manifest asks
+// for it, the last successful deployment of the application is applied again before the deployment is failed.
+func (api Api) verifyDeployment(deployment *trackedDeployment, deploymentRequest naisrequest.Deploy, manifest NaisManifest) *appError {
+	if len(manifest.Verification.Checks) == 0 {
+		return nil
+	}
+
+	if appErr := api.enterPhase(deployment, PhaseVerification); appErr != nil {
+		return appErr
+	}
+
+	baseUrl := verificationUrl(deploymentRequest, manifest.Verification, api.ClusterSubdomain)
+	err := runVerificationChecks(deployment.context(), baseUrl, manifest.Verification.Checks)
+	if err == nil {
+		return nil
+	}
+	if deployment.context().Err() != nil {
+		return api.enterPhase(deployment, PhaseVerification)
+	}
+
+	glog.Warningf("verification of %s:%s failed: %s", deploymentRequest.Application, deploymentRequest.Version, err)
+	if !manifest.Verification.Rollback {
+		return &appError{err, "verification failed", http.StatusInternalServerError}
+	}
+
+	version, rollbackErr := api.rollback(deployment, deploymentRequest)
+	if rollbackErr != nil {
+		return &appError{fmt.Errorf("%s, and rollback failed: %s", err, rollbackErr), "verification failed", http.StatusInternalServerError}
+	}
+	return &appError{err, fmt.Sprintf("verification failed, rolled back to version %s", version), http.StatusInternalServerError}
+}
+
+// rollback applies the last successful deployment of the application again, like a redeploy, and returns its version
+func (api Api) rollback(deployment *trackedDeployment, deploymentRequest naisrequest.Deploy) (string, error) {
+	previous, ok := api.DeploymentHistory.lastKnownGood(deploymentRequest.FasitEnvironment, deploymentRequest.Namespace, deploymentRequest.Application)
+	if !ok {
+		return "", fmt.Errorf("no successful deployment of %s to roll back to", deploymentRequest.Application)
+	}
+
+	// the rollback is logged as a deployment of its own, with the id of the deployment it rolls back
+	started := time.Now()
+	record := previous
+	record.DeploymentId = deployment.Id
+	record.PreviousVersion = deploymentRequest.Version
+	record.DeployedBy = deploymentRequest.OnBehalfOf
+	record.Result = DeploymentSucceeded
+
+	cause := DeploymentCause{Action: "rollback", DeploymentId: deployment.Id, Version: previous.Version, DeployedBy: deploymentRequest.OnBehalfOf}
+	if _, appErr := api.applyDeploymentSpec(previous.spec, cause); appErr != nil {
+		record.Result = DeploymentFailed
+		api.logDeployment(record, "rollback", started)
+		return "", appErr
+	}
+	api.logDeployment(record, "rollback", started)
+
+	api.AuditLog.Record(AuditEntry{
+		Event:       "rollback",
+		Application: deploymentRequest.Application,
+		Namespace:   deploymentRequest.Namespace,
+		Version:     previous.Version,
+		Details:     map[string]string{"environment": deploymentRequest.FasitEnvironment, "from": deployment.Id, "to": previous.DeploymentId},
+	})
+	return previous.Version, nil
+}
+
+// runVerificationChecks runs the checks in order, and returns the error of the first that does not pass
+func runVerificationChecks(ctx context.Context, baseUrl string, checks []VerificationCheck) error {
+	for _, check := range checks {
+		if err := runVerificationCheck(ctx, baseUrl, check); err != nil {
+			return fmt.Errorf("check of %s failed after %d attempts: %s", check.Path, check.retries()+1, err)
+		}
+	}
+	return nil
+}
+
+func runVerificationCheck(ctx context.Context, baseUrl string, check VerificationCheck) error {
+	body := regexp.MustCompile(check.Body)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = verify(ctx, baseUrl+check.Path, check.status(), body); err == nil || attempt >= check.retries() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(check.interval()):
+		}
+	}
+}
+
+func verify(ctx context.Context, url string, status int, body *regexp.Regexp) error {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := verificationClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxVerificationBodySize))
+	if err != nil {
+		return fmt.Errorf("unable to read response: %s", err)
+	}
+
+	if response.StatusCode != status {
+		return fmt.Errorf("expected status %d, got %d", status, response.StatusCode)
+	}
+	if !body.Match(content) {
+		return fmt.Errorf("body does not match %s", body)
+	}
+	return nil
+}
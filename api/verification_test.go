@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateVerification(t *testing.T) {
+	retries := func(retries int) *int { return &retries }
+	verification := func(verification Verification) NaisManifest {
+		return NaisManifest{Verification: verification}
+	}
+
+	assert.Nil(t, validateVerification(NaisManifest{}))
+	assert.Nil(t, validateVerification(verification(Verification{Target: VerificationTargetIngress, Checks: []VerificationCheck{{Path: "/isalive", Status: 204, Body: "^OK$", Retries: retries(0), Interval: "1s"}}})))
+	assert.NotNil(t, validateVerification(verification(Verification{Target: "pod", Checks: []VerificationCheck{{Path: "/"}}})))
+	assert.NotNil(t, validateVerification(NaisManifest{Ingress: Ingress{Disabled: true}, Verification: Verification{Target: VerificationTargetIngress, Checks: []VerificationCheck{{Path: "/"}}}}))
+	assert.NotNil(t, validateVerification(verification(Verification{Checks: []VerificationCheck{{Path: "isalive"}}})))
+	assert.NotNil(t, validateVerification(verification(Verification{Checks: []VerificationCheck{{Path: "/", Status: 1000}}})))
+	assert.NotNil(t, validateVerification(verification(Verification{Checks: []VerificationCheck{{Path: "/", Body: "(unclosed"}}})))
+	assert.NotNil(t, validateVerification(verification(Verification{Checks: []VerificationCheck{{Path: "/", Retries: retries(-1)}}})))
+	assert.NotNil(t, validateVerification(verification(Verification{Checks: []VerificationCheck{{Path: "/", Interval: "often"}}})))
+}
+
+func TestVerificationUrl(t *testing.T) {
+	request := naisrequest.Deploy{Application: appName, Namespace: "team"}
+
+	assert.Equal(t, "http://appname.team.svc.cluster.local", verificationUrl(request, Verification{}, "nais.example.no"))
+	assert.Equal(t, "https://appname-team.nais.example.no", verificationUrl(request, Verification{Target: VerificationTargetIngress}, "nais.example.no"))
+}
+
+func TestRunVerificationChecks(t *testing.T) {
+	interval := defaultVerificationInterval
+	defer func() { defaultVerificationInterval = interval }()
+	defaultVerificationInterval = time.Millisecond
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/isalive":
+			w.Write([]byte("OK"))
+		case "/warming":
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			w.Write([]byte("version 13"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("Checks pass when status and body match", func(t *testing.T) {
+		assert.NoError(t, runVerificationChecks(context.Background(), server.URL, []VerificationCheck{{Path: "/isalive", Body: "^OK$"}}))
+	})
+
+	t.Run("Checks are retried until they pass", func(t *testing.T) {
+		requests = 0
+		assert.NoError(t, runVerificationChecks(context.Background(), server.URL, []VerificationCheck{{Path: "/warming", Body: "version 13"}}))
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("Checks fail after their retries", func(t *testing.T) {
+		retries := 1
+		requests = 0
+		err := runVerificationChecks(context.Background(), server.URL, []VerificationCheck{{Path: "/missing", Retries: &retries}})
+		assert.EqualError(t, err, "check of /missing failed after 2 attempts: expected status 200, got 404")
+		assert.Equal(t, 2, requests)
+
+		err = runVerificationChecks(context.Background(), server.URL, []VerificationCheck{{Path: "/isalive", Body: "version", Retries: &retries}})
+		assert.EqualError(t, err, "check of /isalive failed after 2 attempts: body does not match version")
+
+		assert.NoError(t, verify(context.Background(), server.URL+"/missing", http.StatusNotFound, regexp.MustCompile("")))
+	})
+}
+
+// Sends every request to the test server, wherever it was meant to go
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request.URL.Scheme = t.target.Scheme
+	request.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestVerifyDeployment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	client := verificationClient
+	defer func() { verificationClient = client }()
+	verificationClient = &http.Client{Transport: redirectTransport{target}}
+
+	retries := 0
+	manifest := newDefaultManifest()
+	manifest.Verification = Verification{Checks: []VerificationCheck{{Path: "/isalive", Retries: &retries}}}
+	request := naisrequest.Deploy{Application: appName, Namespace: namespace, Version: "14", FasitEnvironment: "t1", Zone: "fss"}
+	previousRequest := request
+	previousRequest.Version = "13"
+
+	t.Run("A failed verification fails the deployment", func(t *testing.T) {
+		api := Api{Clientset: fake.NewSimpleClientset(), DeploymentHistory: NewDeploymentHistory()}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+
+		appErr := api.verifyDeployment(deployment, request, manifest)
+		assert.Equal(t, http.StatusInternalServerError, appErr.StatusCode)
+		assert.Equal(t, "verification failed", appErr.Message)
+		assert.Equal(t, PhaseVerification, deployment.Phase)
+	})
+
+	t.Run("With rollback, the last successful deployment is applied again", func(t *testing.T) {
+		history := NewDeploymentHistory()
+		history.Add(DeploymentRecord{DeploymentId: "previous", Application: appName, Namespace: namespace, Environment: "t1", Version: "13", spec: newDeploymentSpec(previousRequest, newDefaultManifest(), []NaisResource{})})
+		clientset := fake.NewSimpleClientset(alertsConfigMap())
+		api := Api{Clientset: clientset, ClusterSubdomain: "nais.example.no", DeploymentHistory: history, AuditLog: NewAuditLog()}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+
+		manifest := manifest
+		manifest.Verification.Rollback = true
+		appErr := api.verifyDeployment(deployment, request, manifest)
+		assert.Equal(t, "verification failed, rolled back to version 13", appErr.Message)
+
+		existing, err := getExistingDeployment(appName, namespace, clientset)
+		assert.NoError(t, err)
+		assert.Equal(t, image+":13", existing.Spec.Template.Spec.Containers[0].Image)
+		assert.Equal(t, "rollback", api.AuditLog.Entries()[0].Event)
+	})
+
+	t.Run("Without a successful deployment there is nothing to roll back to", func(t *testing.T) {
+		api := Api{Clientset: fake.NewSimpleClientset(), DeploymentHistory: NewDeploymentHistory()}
+		deployment, _ := api.Deployments.start(context.Background(), request, 0)
+
+		manifest := manifest
+		manifest.Verification.Rollback = true
+		appErr := api.verifyDeployment(deployment, request, manifest)
+		assert.Equal(t, "verification failed", appErr.Message)
+		assert.Contains(t, appErr.OriginalError.Error(), "rollback failed: no successful deployment of appname to roll back to")
+	})
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	VulnerabilityScanAnnotation = "nais.io/vulnerability-scan"
+	DefaultScanThresholdKey     = "default"
+)
+
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// Scanning pulls the image, so it is given longer than other calls, but a scanner that hangs must not hold the deploy
+var vulnerabilityScanClient = &http.Client{Timeout: 2 * time.Minute}
+
+// ScannerConfig configures the image scanner called before deploying. Thresholds are keyed by
+// Fasit environment class, falling back to the "default" key.
+type ScannerConfig struct {
+	Url        string
+	Thresholds map[string]ScanThreshold
+}
+
+// ScanThreshold gives the lowest severity that blocks the deployment, and the lowest that gives a warning
+type ScanThreshold struct {
+	Block string
+	Warn  string
+}
+
+type Vulnerability struct {
+	Id       string `json:"id"`
+	Severity string `json:"severity"`
+	Package  string `json:"package"`
+}
+
+type scanRequest struct {
+	Image string `json:"image"`
+}
+
+type scanResponse struct {
+	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+}
+
+type ScanResult struct {
+	Image   string
+	Digest  string
+	Counts  map[string]int
+	Blocked bool
+	Warning string
+}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return 0
+}
+
+func knownSeverity(severity string) bool {
+	for _, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+// An unknown severity ranks with UNKNOWN, so a misspelt block threshold would block every image with vulnerabilities
+func validateScanThresholds(thresholds map[string]ScanThreshold) error {
+	for environmentClass, threshold := range thresholds {
+		for name, severity := range map[string]string{"block": threshold.Block, "warn": threshold.Warn} {
+			if len(severity) > 0 && !knownSeverity(severity) {
+				return fmt.Errorf("scanner.thresholds.%s.%s must be one of %s, not %q", environmentClass, name, strings.Join(severities, ", "), severity)
+			}
+		}
+	}
+	return nil
+}
+
+func (c ScannerConfig) threshold(environmentClass string) (ScanThreshold, bool) {
+	if threshold, ok := c.Thresholds[environmentClass]; ok {
+		return threshold, true
+	}
+	threshold, ok := c.Thresholds[DefaultScanThresholdKey]
+	return threshold, ok
+}
+
+// Summary is a stable, compact representation of the scan result, e.g. CRITICAL=0,HIGH=2,MEDIUM=5,LOW=1,UNKNOWN=0
+func (r ScanResult) Summary() string {
+	var parts []string
+	for i := len(severities) - 1; i >= 0; i-- {
+		parts = append(parts, fmt.Sprintf("%s=%d", severities[i], r.Counts[severities[i]]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func scanImage(scannerUrl, image string) ([]Vulnerability, error) {
+	payload, err := json.Marshal(scanRequest{Image: image})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create payload (%s)", err)
+	}
+
+	resp, err := vulnerabilityScanClient.Post(scannerUrl+"/api/v1/scan", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact image scanner: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response from image scanner: %s", err)
+	}
+
+	if resp.StatusCode > 299 {
+		return nil, fmt.Errorf("image scanner returned: %s (%d)", body, resp.StatusCode)
+	}
+
+	var response scanResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response from image scanner: %s", err)
+	}
+
+	return response.Vulnerabilities, nil
+}
+
+// Scans the image at the digest its tag resolves to, and evaluates the vulnerabilities against the threshold
+// for the environment class. Returns nil if no scanner or threshold is configured.
+func runVulnerabilityGate(config ScannerConfig, image, environmentClass string) (*ScanResult, error) {
+	if len(config.Url) == 0 {
+		return nil, nil
+	}
+
+	threshold, ok := config.threshold(environmentClass)
+	if !ok {
+		return nil, nil
+	}
+
+	digest, err := resolveImageDigest(image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve digest of image %s: %s", image, err)
+	}
+
+	vulnerabilities, err := scanImage(config.Url, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ScanResult{Image: image, Digest: digest, Counts: make(map[string]int)}
+	var blocking, warning []string
+	for _, v := range vulnerabilities {
+		severity := strings.ToUpper(v.Severity)
+		result.Counts[severity]++
+
+		if len(threshold.Block) > 0 && severityRank(severity) >= severityRank(threshold.Block) {
+			blocking = append(blocking, v.Id)
+		} else if len(threshold.Warn) > 0 && severityRank(severity) >= severityRank(threshold.Warn) {
+			warning = append(warning, v.Id)
+		}
+	}
+
+	if len(blocking) > 0 {
+		result.Blocked = true
+		return result, fmt.Errorf("image %s has vulnerabilities at or above %s: %s", image, threshold.Block, strings.Join(blocking, ", "))
+	}
+
+	if len(warning) > 0 {
+		result.Warning = fmt.Sprintf("image %s has vulnerabilities at or above %s: %s", image, threshold.Warn, strings.Join(warning, ", "))
+		glog.Warning(result.Warning)
+	}
+
+	return result, nil
+}
+
+// Attaches the scan summary to the deployment so that it is part of the deployment status
+func annotateDeploymentWithScanResult(namespace, application string, result ScanResult, k8sClient kubernetes.Interface) error {
+	deployment, err := deployments(k8sClient, namespace).Get(application, k8smeta.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get deployment: %s", err)
+	}
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Annotations[VulnerabilityScanAnnotation] = result.Summary()
+
+	_, err = deployments(k8sClient, namespace).Update(deployment)
+	return err
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+	k8sextensions "k8s.io/api/extensions/v1beta1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunVulnerabilityGate(t *testing.T) {
+	scannerUrl := "https://scanner.local"
+	image := "docker.adeo.no:5000/app:1.0"
+	digest := "docker.adeo.no:5000/app@sha256:4a5b6c"
+	config := ScannerConfig{
+		Url: scannerUrl,
+		Thresholds: map[string]ScanThreshold{
+			"p":       {Block: "HIGH", Warn: "MEDIUM"},
+			"default": {Warn: "CRITICAL"},
+		},
+	}
+	vulnerabilities := `{"vulnerabilities": [
+		{"id": "CVE-1", "severity": "high", "package": "openssl"},
+		{"id": "CVE-2", "severity": "MEDIUM", "package": "curl"},
+		{"id": "CVE-3", "severity": "LOW", "package": "zlib"}
+	]}`
+	registry := func() {
+		gock.New("https://docker.adeo.no:5000").
+			Head("/v2/app/manifests/1.0").
+			Reply(200).
+			SetHeader(ContentDigestHeader, "sha256:4a5b6c")
+	}
+
+	t.Run("No scanner configured skips the scan", func(t *testing.T) {
+		result, err := runVulnerabilityGate(ScannerConfig{}, image, "p")
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+	})
+
+	t.Run("Vulnerabilities at or above the block threshold block the deployment", func(t *testing.T) {
+		defer gock.Off()
+		registry()
+		gock.New(scannerUrl).
+			Post("/api/v1/scan").
+			JSON(map[string]string{"image": digest}).
+			Reply(200).
+			BodyString(vulnerabilities)
+
+		result, err := runVulnerabilityGate(config, image, "p")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "CVE-1")
+		assert.True(t, result.Blocked)
+		assert.Equal(t, digest, result.Digest)
+		assert.Equal(t, "CRITICAL=0,HIGH=1,MEDIUM=1,LOW=1,UNKNOWN=0", result.Summary())
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Unknown environment class uses the default threshold", func(t *testing.T) {
+		defer gock.Off()
+		registry()
+		gock.New(scannerUrl).
+			Post("/api/v1/scan").
+			Reply(200).
+			BodyString(vulnerabilities)
+
+		result, err := runVulnerabilityGate(config, image, "t")
+		assert.NoError(t, err)
+		assert.False(t, result.Blocked)
+		assert.Empty(t, result.Warning)
+	})
+
+	t.Run("Vulnerabilities below the block threshold give a warning", func(t *testing.T) {
+		defer gock.Off()
+		registry()
+		gock.New(scannerUrl).
+			Post("/api/v1/scan").
+			Reply(200).
+			BodyString(`{"vulnerabilities": [{"id": "CVE-2", "severity": "MEDIUM", "package": "curl"}]}`)
+
+		result, err := runVulnerabilityGate(config, image, "p")
+		assert.NoError(t, err)
+		assert.Contains(t, result.Warning, "CVE-2")
+	})
+
+	t.Run("Scanner errors are returned", func(t *testing.T) {
+		defer gock.Off()
+		registry()
+		gock.New(scannerUrl).
+			Post("/api/v1/scan").
+			Reply(500).
+			BodyString("internal error")
+
+		result, err := runVulnerabilityGate(config, image, "p")
+		assert.Error(t, err)
+		assert.Nil(t, result)
+	})
+
+	t.Run("Images whose digest can not be resolved are not scanned", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://docker.adeo.no:5000").
+			Head("/v2/app/manifests/1.0").
+			Reply(404)
+
+		result, err := runVulnerabilityGate(config, image, "p")
+		assert.EqualError(t, err, "unable to resolve digest of image docker.adeo.no:5000/app:1.0: registry returned 404 for https://docker.adeo.no:5000/v2/app/manifests/1.0")
+		assert.Nil(t, result)
+		assert.True(t, gock.IsDone())
+	})
+}
+
+func TestValidateScanThresholds(t *testing.T) {
+	assert.NoError(t, validateScanThresholds(map[string]ScanThreshold{"p": {Block: "high", Warn: "MEDIUM"}, "default": {}}))
+	assert.EqualError(t, validateScanThresholds(map[string]ScanThreshold{"p": {Block: "HIHG"}}), `scanner.thresholds.p.block must be one of UNKNOWN, LOW, MEDIUM, HIGH, CRITICAL, not "HIHG"`)
+	assert.Error(t, validateScanThresholds(map[string]ScanThreshold{"default": {Warn: "severe"}}))
+}
+
+func TestAnnotateDeploymentWithScanResult(t *testing.T) {
+	deployment := &k8sextensions.Deployment{ObjectMeta: k8smeta.ObjectMeta{Name: appName, Namespace: namespace}}
+	clientset := fake.NewSimpleClientset(deployment)
+	result := ScanResult{Counts: map[string]int{"HIGH": 2}}
+
+	err := annotateDeploymentWithScanResult(namespace, appName, result, clientset)
+	assert.NoError(t, err)
+
+	updated, _ := clientset.ExtensionsV1beta1().Deployments(namespace).Get(appName, k8smeta.GetOptions{})
+	assert.Equal(t, "CRITICAL=0,HIGH=2,MEDIUM=0,LOW=0,UNKNOWN=0", updated.Annotations[VulnerabilityScanAnnotation])
+
+	replicas := int32(1)
+	updated.Spec.Replicas = &replicas
+	view := deploymentStatusViewFrom(Success, "", *updated)
+	assert.Equal(t, "CRITICAL=0,HIGH=2,MEDIUM=0,LOW=0,UNKNOWN=0", view.VulnerabilityScan)
+}
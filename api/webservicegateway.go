@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+// WebserviceGatewayResourceType is a web service exposed through the Datapower gateway. It refers to the
+// WebserviceEndpoint the gateway forwards to by its alias, which must be exposed in the same manifest.
+const WebserviceGatewayResourceType = "WebserviceGateway"
+
+type WebserviceGatewayResourcePayload struct {
+	Alias      string                      `json:"alias"`
+	Scope      Scope                       `json:"scope"`
+	Type       string                      `json:"type"`
+	Properties WebserviceGatewayProperties `json:"properties"`
+	Metadata   *ResourceMetadata           `json:"metadata,omitempty"`
+}
+type WebserviceGatewayProperties struct {
+	Url                string `json:"url"`
+	WebserviceEndpoint string `json:"webserviceEndpoint"`
+	SecurityToken      string `json:"securityToken"`
+	Description        string `json:"description,omitempty"`
+}
+
+func isWebserviceGateway(resourceType string) bool {
+	return strings.EqualFold(WebserviceGatewayResourceType, resourceType)
+}
+
+// The url is where the gateway exposes the service, the path of the resource on the gateway
+func buildWebserviceGatewayPayload(resource ExposedResource, scope Scope) WebserviceGatewayResourcePayload {
+	return WebserviceGatewayResourcePayload{
+		Type:  WebserviceGatewayResourceType,
+		Alias: resource.Alias,
+		Properties: WebserviceGatewayProperties{
+			Url:                strings.TrimSuffix(resource.Gateway, "/") + resource.Path,
+			WebserviceEndpoint: resource.Endpoint,
+			SecurityToken:      webserviceSecurityToken(resource),
+			Description:        resource.Description,
+		},
+		Scope: scope,
+	}
+}
+
+func validateWebserviceGateways(manifest NaisManifest) *ValidationError {
+	endpoints := make(map[string]bool)
+	for _, resource := range manifest.FasitResources.Exposed {
+		if strings.EqualFold("WebserviceEndpoint", resource.ResourceType) {
+			endpoints[resource.Alias] = true
+		}
+	}
+
+	for _, resource := range manifest.FasitResources.Exposed {
+		if !isWebserviceGateway(resource.ResourceType) {
+			continue
+		}
+
+		// that gateway and endpoint are there is checked by validateExposedResourceProperties
+		if gateway, err := url.Parse(resource.Gateway); len(resource.Gateway) > 0 && (err != nil || gateway.Scheme != "https" || len(gateway.Host) == 0) {
+			return &ValidationError{
+				"Gateway of an exposed WebserviceGateway must be the https url of the gateway",
+				map[string]string{"Alias": resource.Alias, "Gateway": resource.Gateway},
+			}
+		}
+		if len(resource.Endpoint) > 0 && !endpoints[resource.Endpoint] {
+			return &ValidationError{
+				"Endpoint of an exposed WebserviceGateway must be the alias of a WebserviceEndpoint exposed by the application",
+				map[string]string{"Alias": resource.Alias, "Endpoint": resource.Endpoint},
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebserviceGateway(t *testing.T) {
+	endpoint := ExposedResource{Alias: "myservice", ResourceType: "WebserviceEndpoint", Path: "/ws/MyService", WsdlGroupId: "no.nav", WsdlArtifactId: "myservice", WsdlVersion: "1.0"}
+	gateway := ExposedResource{Alias: "myservice_gw", ResourceType: "webservicegateway", Gateway: "https://service-gw.local/", Path: "/myapp/MyService", Endpoint: "myservice", SecurityToken: "SAML"}
+
+	t.Run("Payload refers to the endpoint", func(t *testing.T) {
+		payload, err := json.Marshal(buildResourcePayload(gateway, NaisResource{}, "t", "t1", "fss", "myapp.nais.local"))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"alias":"myservice_gw","scope":{"environmentclass":"t","environment":"t1","zone":"fss"},"type":"WebserviceGateway",`+
+			`"properties":{"url":"https://service-gw.local/myapp/MyService","webserviceEndpoint":"myservice","securityToken":"SAML"}}`, string(payload))
+	})
+
+	t.Run("Gateway must be an https url and endpoint an exposed WebserviceEndpoint", func(t *testing.T) {
+		exposed := func(resources ...ExposedResource) NaisManifest {
+			return NaisManifest{FasitResources: FasitResources{Exposed: resources}}
+		}
+		modified := func(modify func(resource *ExposedResource)) ExposedResource {
+			resource := gateway
+			modify(&resource)
+			return resource
+		}
+
+		assert.Nil(t, validateResources(exposed(endpoint, gateway)))
+		assert.Nil(t, validateExposedResourceProperties(exposed(endpoint, gateway)))
+		assert.Nil(t, validateWebserviceGateways(exposed(endpoint, gateway)))
+		assert.NotNil(t, validateWebserviceGateways(exposed(gateway)))
+		assert.NotNil(t, validateWebserviceGateways(exposed(endpoint, modified(func(r *ExposedResource) { r.Gateway = "http://service-gw.local" }))))
+		assert.NotNil(t, validateExposedResourceProperties(exposed(endpoint, modified(func(r *ExposedResource) { r.Endpoint = "" }))))
+		assert.NotNil(t, validateExposedResourceProperties(exposed(endpoint, modified(func(r *ExposedResource) { r.WsdlGroupId = "no.nav" }))))
+	})
+}
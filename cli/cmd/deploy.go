@@ -1,24 +1,20 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/nais/naisd/api/constant"
 	"github.com/nais/naisd/api/naisrequest"
+	"github.com/nais/naisd/naisdclient"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"os/user"
 	"syscall"
 	"time"
 )
 
-const DeployEndpoint = "/deploy"
-const StatusEndpoint = "/deploystatus"
 const DefaultCluster = "preprod-fss"
 
 var clustersDict = map[string]string{
@@ -76,16 +72,19 @@ var deployCmd = &cobra.Command{
 		}
 
 		var cluster string
+		token := os.Getenv("NAISD_TOKEN")
 		strings := map[string]*string{
-			"app":               &deployRequest.Application,
-			"version":           &deployRequest.Version,
-			"zone":              &deployRequest.Zone,
-			"namespace":         &deployRequest.Namespace,
-			"fasit-environment": &deployRequest.FasitEnvironment,
-			"fasit-username":    &deployRequest.FasitUsername,
-			"fasit-password":    &deployRequest.FasitPassword,
-			"manifest-url":      &deployRequest.ManifestUrl,
-			"cluster":           &cluster,
+			"app":                   &deployRequest.Application,
+			"version":               &deployRequest.Version,
+			"zone":                  &deployRequest.Zone,
+			"namespace":             &deployRequest.Namespace,
+			"fasit-environment":     &deployRequest.FasitEnvironment,
+			"fasit-username":        &deployRequest.FasitUsername,
+			"fasit-password":        &deployRequest.FasitPassword,
+			"fasit-credentials-ref": &deployRequest.FasitCredentialsRef,
+			"manifest-url":          &deployRequest.ManifestUrl,
+			"cluster":               &cluster,
+			"token":                 &token,
 		}
 
 		for key, pointer := range strings {
@@ -98,8 +97,14 @@ var deployCmd = &cobra.Command{
 		}
 
 		deployRequest.SkipFasit, _ = cmd.Flags().GetBool("skip-fasit")
-
-		if !deployRequest.SkipFasit {
+		deployRequest.BypassFasitCache, _ = cmd.Flags().GetBool("bypass-fasit-cache")
+		deployRequest.FasitDryRun, _ = cmd.Flags().GetBool("fasit-dry-run")
+
+		// naisd reads the credentials from the referenced secret, so none are sent
+		if len(deployRequest.FasitCredentialsRef) > 0 {
+			deployRequest.FasitUsername = ""
+			deployRequest.FasitPassword = ""
+		} else if !deployRequest.SkipFasit {
 			if deployRequest.FasitUsername == "" {
 				currentUser, err := user.Current()
 				if err != nil {
@@ -132,36 +137,27 @@ var deployCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		jsonStr, err := json.Marshal(deployRequest)
-
+		client := naisdclient.New(clusterUrl)
+		client.Token = token
+		result, err := client.Deploy(context.Background(), deployRequest)
 		if err != nil {
-			fmt.Printf("Error while marshalling JSON: %v\n", err)
+			fmt.Printf("Error while deploying: %v\n", err)
 			os.Exit(1)
 		}
 
-		fmt.Println(string(jsonStr))
-
-		resp, err := http.Post(clusterUrl+DeployEndpoint, "application/json", bytes.NewBuffer(jsonStr))
-
-		if err != nil {
-			fmt.Printf("Error while POSTing to API: %v\n", err)
-			os.Exit(1)
+		if deployRequest.FasitDryRun {
+			fmt.Println(result.Message)
+			return
 		}
-		defer resp.Body.Close()
-
-		body, _ := ioutil.ReadAll(resp.Body)
-		fmt.Println("response Status:", resp.Status)
-		fmt.Println("response Body:", string(body))
 
-		if resp.StatusCode > 299 {
-			os.Exit(1)
-		}
+		fmt.Println("deployment id:", result.DeploymentId)
+		fmt.Println(result.Message)
 
 		if wait, err := cmd.Flags().GetBool("wait"); err != nil {
 			fmt.Printf("Error: %v\n", err)
 		} else if wait {
 			start := time.Now()
-			if err := waitForDeploy(clusterUrl + StatusEndpoint + "/" + deployRequest.Namespace + "/" + deployRequest.Application); err != nil {
+			if err := client.WaitForRollout(context.Background(), deployRequest.Namespace, deployRequest.Application, time.Second); err != nil {
 				fmt.Printf("%v\n", err)
 				os.Exit(1)
 			}
@@ -182,7 +178,11 @@ func init() {
 	deployCmd.Flags().StringP("namespace", "n", "default", "the kubernetes namespace")
 	deployCmd.Flags().StringP("fasit-username", "u", "", "the username")
 	deployCmd.Flags().StringP("fasit-password", "p", "", "the password")
+	deployCmd.Flags().String("fasit-credentials-ref", "", "secret in naisd's cluster holding the team's Fasit credentials, instead of username and password")
+	deployCmd.Flags().String("token", "", "token of an identity of the team, needed with --fasit-credentials-ref (or NAISD_TOKEN)")
 	deployCmd.Flags().StringP("manifest-url", "m", "", "alternative URL to the nais manifest")
 	deployCmd.Flags().Bool("wait", false, "whether to wait until the deploy has succeeded (or failed)")
 	deployCmd.Flags().Bool("skip-fasit", false, "whether to skip interaction with fasit")
+	deployCmd.Flags().Bool("bypass-fasit-cache", false, "whether to get every resource from fasit, even if naisd has it cached")
+	deployCmd.Flags().Bool("fasit-dry-run", false, "show the changes the deployment would make to fasit, without deploying")
 }
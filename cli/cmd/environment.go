@@ -125,6 +125,11 @@ Or just save it to a file
 			FasitUrl: fasitUrl,
 		}
 
+		if err := api.ResolveAliasPrefixes(fasit, &manifest, environment, application, zone); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to contact Fasit. %v\n", err)
+			os.Exit(1)
+		}
+
 		vars, err := api.FetchFasitResources(fasit, application, environment, zone, manifest.FasitResources.Used)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to contact Fasit. %v\n", err)
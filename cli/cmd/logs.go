@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nais/naisd/naisdclient"
+	"github.com/spf13/cobra"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Prints the logs of your app",
+	Long:  `Prints the last lines your app has logged in each of its pods`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var cluster, app, namespace string
+		token := os.Getenv("NAISD_TOKEN")
+		strings := map[string]*string{
+			"app":       &app,
+			"namespace": &namespace,
+			"cluster":   &cluster,
+			"token":     &token,
+		}
+
+		for key, pointer := range strings {
+			if value, err := cmd.Flags().GetString(key); err != nil {
+				fmt.Printf("Error when getting flag: %s. %v\n", key, err)
+				os.Exit(1)
+			} else if len(value) > 0 {
+				*pointer = value
+			}
+		}
+
+		if len(app) == 0 {
+			fmt.Println("Application cannot be empty")
+			os.Exit(1)
+		}
+
+		tail, err := cmd.Flags().GetInt("tail")
+		if err != nil {
+			fmt.Printf("Error when getting flag: tail. %v\n", err)
+			os.Exit(1)
+		}
+
+		clusterUrl, err := getClusterUrl(cluster)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		client := naisdclient.New(clusterUrl)
+		client.Token = token
+		logs, err := client.Logs(context.Background(), namespace, app, tail)
+		if err != nil {
+			fmt.Printf("Error while getting logs: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(logs)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringP("app", "a", "", "name of your app")
+	logsCmd.Flags().StringP("cluster", "c", "", "the cluster your app is in")
+	logsCmd.Flags().StringP("namespace", "n", "default", "the kubernetes namespace")
+	logsCmd.Flags().IntP("tail", "t", 100, "number of lines to print from each pod")
+	logsCmd.Flags().String("token", "", "token of an identity of the team (or NAISD_TOKEN)")
+}
@@ -1,35 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/nais/naisd/naisdclient"
 	"github.com/spf13/cobra"
-	"net/http"
 	"os"
 	"time"
 )
 
-func waitForDeploy(url string) error {
-	for {
-		resp, err := http.Get(url)
-
-		if err != nil {
-			return err
-		}
-
-		if resp.StatusCode == 200 {
-			break
-		}
-
-		if resp.StatusCode != 202 {
-			return fmt.Errorf("Deploy failed: %d\n", resp.StatusCode)
-		}
-
-		// do nothing, continue loop
-		time.Sleep(1000 * time.Millisecond)
-	}
-	return nil
-}
-
 var waitCmd = &cobra.Command{
 	Use:   "wait",
 	Short: "Waits for deploy",
@@ -64,7 +43,7 @@ var waitCmd = &cobra.Command{
 		}
 
 		start := time.Now()
-		if err := waitForDeploy(clusterUrl + StatusEndpoint + "/" + namespace + "/" + app); err != nil {
+		if err := naisdclient.New(clusterUrl).WaitForRollout(context.Background(), namespace, app, time.Second); err != nil {
 			fmt.Printf("%v\n", err)
 			os.Exit(1)
 		}
@@ -0,0 +1,53 @@
+// fasitsimulator serves the contract between naisd and Fasit as a fake Fasit, or verifies that a Fasit keeps it.
+//
+// The Fasit team runs it with --verify against a test instance of their changes, to find changes that break naisd
+// before they are deployed:
+//
+//	fasit-simulator --contract api/testdata/fasitcontract.json --verify https://fasit-test.example.no --username u --password p
+//
+// Verifying replays the writes of the contract, creating and updating resources and application instances in that
+// Fasit, so it must only be run against a test instance whose data can be thrown away.
+//
+// Without --verify it serves the contract on --listen, for testing naisd, or anything else depending on the same
+// interactions, without a Fasit.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/nais/naisd/api/fasitcontract"
+)
+
+func main() {
+	contractFile := flag.String("contract", "api/testdata/fasitcontract.json", "Path to the contract between naisd and Fasit")
+	listen := flag.String("listen", ":8089", "Address to serve the contract on")
+	verify := flag.String("verify", "", "URL of a Fasit to verify the contract against, instead of serving it. The writes of the contract are replayed, changing the data in that Fasit")
+	username := flag.String("username", "", "User to verify the contract as")
+	password := flag.String("password", "", "Password of the user to verify the contract as")
+	flag.Parse()
+
+	contract, err := fasitcontract.Load(*contractFile)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	if len(*verify) > 0 {
+		failures := fasitcontract.Verify(contract, fasitcontract.Provider{Url: *verify, Username: *username, Password: *password})
+		for _, failure := range failures {
+			fmt.Printf("FAIL %s\n", failure)
+		}
+		if len(failures) > 0 {
+			fmt.Printf("%s breaks %d of %d interactions %s depends on\n", *verify, len(failures), len(contract.Interactions), contract.Consumer)
+			os.Exit(1)
+		}
+		fmt.Printf("%s keeps all %d interactions %s depends on\n", *verify, len(contract.Interactions), contract.Consumer)
+		return
+	}
+
+	glog.Infof("serving %d interactions of %s on %s", len(contract.Interactions), *contractFile, *listen)
+	glog.Fatal(http.ListenAndServe(*listen, fasitcontract.NewSimulator(contract)))
+}
@@ -6,9 +6,11 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"net/http"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/nais/naisd/api"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const Port = ":8081"
@@ -19,6 +21,47 @@ func main() {
 	clusterSubdomain := flag.String("cluster-subdomain", "nais-example.nais.example.no", "Cluster sub-domain")
 	clusterName := flag.String("clustername", "kubernetes", "Name of the kubernetes cluster")
 	istioEnabled := flag.Bool("istio-enabled", false, "If istio is enabled or not")
+	configFile := flag.String("config", "", "Path to a YAML file with daemon configuration, e.g. per-zone Fasit endpoints")
+	fasitUserAgent := flag.String("fasit-user-agent", "", "User-Agent sent to Fasit, defaults to naisd's version and --clustername")
+	fasitConnectTimeout := flag.Duration("fasit-connect-timeout", 5*time.Second, "How long to wait for a connection to Fasit")
+	fasitReadTimeout := flag.Duration("fasit-read-timeout", 30*time.Second, "How long to wait for Fasit to start answering a request")
+	fasitMaxIdleConns := flag.Int("fasit-max-idle-conns", 100, "How many idle connections to Fasit are kept open for reuse, 0 for no limit")
+	fasitMaxIdleConnsPerHost := flag.Int("fasit-max-idle-conns-per-host", 20, "How many idle connections to each Fasit instance are kept open for reuse")
+	fasitIdleConnTimeout := flag.Duration("fasit-idle-conn-timeout", 90*time.Second, "How long an idle connection to Fasit is kept open, 0 for no limit")
+	fasitMinTlsVersion := flag.String("fasit-min-tls-version", "1.2", "Oldest TLS version accepted from Fasit: 1.0, 1.1 or 1.2")
+	fasitProxy := flag.String("fasit-proxy", "", "URL of the proxy to reach Fasit through, instead of HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+	fasitCABundle := flag.String("fasit-ca-bundle", "", "File with PEM certificates of CAs trusted for Fasit, besides the system's")
+	fasitInsecureSkipVerify := flag.Bool("fasit-insecure-skip-verify", false, "Accept any certificate from Fasit, for test environments only")
+	fasitResourceCacheTtl := flag.Duration("fasit-resource-cache-ttl", 0, "How long resources resolved from Fasit are reused by other deployments, 0 to disable")
+	fasitConditionalRequests := flag.Bool("fasit-conditional-requests", api.FasitConditionalRequests, "Revalidate resource lookups with the ETag of Fasit's last response, reusing it when Fasit answers 304")
+	fasitOfflineDir := flag.String("fasit-offline-dir", "", "Directory of Fasit environments, applications and resources to use instead of Fasit, for local development and testing")
+	fasitBulkLookups := flag.Bool("fasit-bulk-lookups", api.FasitBulkLookups, "List the used resources of each type in one request to Fasit, instead of looking them up one by one")
+	fasitScopeResolution := api.ScopeResolutionValue(api.FasitScopeResolution)
+	flag.Var(&fasitScopeResolution, "fasit-scope-resolution", "How a used resource is picked when several in Fasit have its alias: fasit, best-match or conflict")
+	fasitBreakerFailures := flag.Int("fasit-breaker-failures", api.FasitCircuitBreaker.Failures, "Failed requests to Fasit in a row before requests to it fail at once, 0 to disable")
+	fasitBreakerCooldown := flag.Duration("fasit-breaker-cooldown", api.FasitCircuitBreaker.Cooldown, "How long requests to Fasit fail at once before one is tried again")
+	fasitRateLimit := flag.Float64("fasit-rate-limit", api.FasitRateLimit.Rate, "Requests a second naisd sends to Fasit on average, 0 for no limit")
+	fasitRateLimitBurst := flag.Int("fasit-rate-limit-burst", api.FasitRateLimit.Burst, "Requests naisd may send to Fasit at once after a quiet period")
+	fasitMaxRetries := flag.Int("fasit-max-retries", 5, "How many times a failed or throttled request to Fasit is retried")
+	fasitRetryBackoff := flag.Duration("fasit-retry-backoff", time.Second, "Delay before the first retry of a request to Fasit, doubled for every retry")
+	fasitRetryMaxBackoff := flag.Duration("fasit-retry-max-backoff", 30*time.Second, "Longest delay between retries of a request to Fasit")
+	fasitRetryJitter := flag.Float64("fasit-retry-jitter", 0.2, "Share of the delay between retries of a request to Fasit that is random")
+	fasitCredentialsNamespace := flag.String("fasit-credentials-namespace", "nais", "Namespace of the secrets deployment requests can reference with fasitCredentialsRef, empty to disable")
+	fasitEventsEnabled := flag.Bool("fasit-events-enabled", false, "Register deployment events in Fasit's change log")
+	fasitStopPrevious := flag.Bool("fasit-stop-previous", false, "Mark the application instance a deployment replaces as stopped in Fasit")
+	featureToggleSyncInterval := flag.Duration("feature-toggle-sync-interval", time.Minute, "How often feature toggles are synced from Fasit")
+	previewReapInterval := flag.Duration("preview-reap-interval", 5*time.Minute, "How often expired preview deployments are deleted")
+	mirrorReapInterval := flag.Duration("mirror-reap-interval", time.Minute, "How often mirrors that have run for their duration are stopped")
+	kubernetesHealthInterval := flag.Duration("kubernetes-health-interval", 10*time.Second, "How often the Kubernetes API is checked for load shedding")
+	maxApiLatency := flag.Duration("max-api-latency", 0, "Reject deployments while the Kubernetes API is slower than this, 0 to disable")
+	maxApiErrors := flag.Int("max-api-errors", 3, "Reject deployments after this many failed Kubernetes API health checks in a row, 0 to disable")
+	maxConcurrentDeployments := flag.Int64("max-concurrent-deployments", 0, "Reject deployments while this many are in progress, 0 to disable")
+	maxDeployDuration := flag.Duration("max-deploy-duration", 0, "Fail deployments that take longer than this, unless the manifest says otherwise, 0 to disable")
+	featureFlagsConfigMap := flag.String("feature-flags-configmap", "", "ConfigMap (namespace/name) with feature flags, replacing those in --config")
+	featureFlagsReloadInterval := flag.Duration("feature-flags-reload-interval", time.Minute, "How often feature flags are read from --feature-flags-configmap")
+	stateStore := flag.String("state-store", api.StateStoreConfigMap, "Where the deployment history and audit log are kept across restarts: memory, configmap, sqlite or postgres")
+	stateStoreNamespace := flag.String("state-store-namespace", "nais", "Namespace of the ConfigMaps of the configmap state store")
+	stateStoreDsn := flag.String("state-store-dsn", "", "Database file (sqlite) or connection string (postgres) of the state store")
 
 	flag.Parse()
 
@@ -27,8 +70,108 @@ func main() {
 	glog.Infof("istio enabled = %b", *istioEnabled)
 
 
+	config, err := api.LoadDaemonConfig(*configFile)
+	if err != nil {
+		panic(err)
+	}
+
+	api.FasitUserAgent = api.DefaultFasitUserAgent(*clusterName)
+	if len(*fasitUserAgent) > 0 {
+		api.FasitUserAgent = *fasitUserAgent
+	}
+	api.ScopedResourceCacheTtl = *fasitResourceCacheTtl
+	api.FasitConditionalRequests = *fasitConditionalRequests
+	api.FasitBulkLookups = *fasitBulkLookups
+	api.FasitScopeResolution = string(fasitScopeResolution)
+	api.FasitCircuitBreaker = api.CircuitBreakerPolicy{Failures: *fasitBreakerFailures, Cooldown: *fasitBreakerCooldown}
+	api.FasitRateLimit = api.RateLimit{Rate: *fasitRateLimit, Burst: *fasitRateLimitBurst}
+	fasitTransport, err := api.NewFasitTransport(api.FasitTransportConfig{
+		ConnectTimeout:      *fasitConnectTimeout,
+		ReadTimeout:         *fasitReadTimeout,
+		MaxIdleConns:        *fasitMaxIdleConns,
+		MaxIdleConnsPerHost: *fasitMaxIdleConnsPerHost,
+		IdleConnTimeout:     *fasitIdleConnTimeout,
+		MinTLSVersion:       *fasitMinTlsVersion,
+		Proxy:               *fasitProxy,
+		CABundle:            *fasitCABundle,
+		InsecureSkipVerify:  *fasitInsecureSkipVerify,
+	})
+	if err != nil {
+		glog.Exitf("invalid Fasit transport settings: %s", err)
+	}
+
+	for zone, endpoint := range config.FasitEndpoints {
+		glog.Infof("using fasit instance %s for zone %s", endpoint.Url, zone)
+	}
+
+	if err := api.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		panic(err)
+	}
+
 	clientSet := newClientSet(*kubeconfig)
-	err := http.ListenAndServe(Port, api.NewApi(clientSet, *fasitUrl, *clusterSubdomain, *clusterName, *istioEnabled, api.NewDeploymentStatusViewer(clientSet)).Handler())
+	naisdApi := api.NewApi(clientSet, *fasitUrl, *clusterSubdomain, *clusterName, *istioEnabled, api.NewDeploymentStatusViewer(clientSet))
+	naisdApi.FasitTransport = fasitTransport
+	naisdApi.FasitEventsEnabled = *fasitEventsEnabled
+	naisdApi.FasitStopPrevious = *fasitStopPrevious
+	naisdApi.FasitCredentialsNamespace = *fasitCredentialsNamespace
+	naisdApi.FasitEndpoints = config.FasitEndpoints
+	naisdApi.FasitRetryPolicy = api.RetryPolicy{MaxRetries: *fasitMaxRetries, Backoff: *fasitRetryBackoff, MaxBackoff: *fasitRetryMaxBackoff, Jitter: *fasitRetryJitter}
+	naisdApi.FasitApplicationInstance = config.ApplicationInstance
+	if len(*fasitOfflineDir) > 0 {
+		glog.Warningf("using the offline Fasit in %s instead of Fasit", *fasitOfflineDir)
+		if naisdApi.OfflineFasit, err = api.NewOfflineFasit(*fasitOfflineDir); err != nil {
+			glog.Exitf("unable to use the offline Fasit: %s", err)
+		}
+	}
+	naisdApi.Provenance = config.Provenance
+	naisdApi.Scanner = config.Scanner
+	naisdApi.Policies = config.Policies
+	naisdApi.EnvironmentClass = config.EnvironmentClass
+	naisdApi.PullRequestProviders = config.PullRequestProviders
+	naisdApi.DnsAllowList = config.DnsAllowList
+	naisdApi.EmptyDirLimits = config.EmptyDirLimits
+	naisdApi.Egress = config.Egress
+	naisdApi.Firewall = config.Firewall
+	naisdApi.DeploymentLog = config.DeploymentLog
+	naisdApi.ManifestProfiles = config.ManifestProfiles
+	naisdApi.ResourceTemplates = config.ResourceTemplates
+	naisdApi.DefaultEnv = config.DefaultEnv
+	naisdApi.DeploySteps = config.Steps
+	naisdApi.FeatureFlags = api.NewFeatureFlags(config.FeatureFlags)
+	naisdApi.OperatorToken = config.OperatorToken
+	naisdApi.Identities = config.Identities
+	naisdApi.LoadShedder.MaxLatency = *maxApiLatency
+	naisdApi.LoadShedder.MaxErrors = *maxApiErrors
+	naisdApi.LoadShedder.MaxConcurrentDeployments = *maxConcurrentDeployments
+	naisdApi.MaxDeployDuration = *maxDeployDuration
+
+	// naisd can deploy without its history, so a state store it can not use is logged, and the state kept in memory
+	store, err := api.NewStateStore(*stateStore, clientSet, *stateStoreNamespace, *stateStoreDsn)
+	if err != nil {
+		glog.Errorf("unable to open the %s state store, keeping the deployment history and audit log in memory: %s", *stateStore, err)
+	} else if store != nil {
+		glog.Infof("keeping the deployment history and audit log in the %s state store", *stateStore)
+		if err := naisdApi.DeploymentHistory.Restore(store); err != nil {
+			glog.Errorf("unable to restore the deployment history, keeping it in memory: %s", err)
+		}
+		if err := naisdApi.AuditLog.Restore(store); err != nil {
+			glog.Errorf("unable to restore the audit log, keeping it in memory: %s", err)
+		}
+	}
+
+	if len(config.DefaultEnv) > 0 {
+		naisdApi.AuditLog.Record(api.AuditEntry{Event: "default_env_configured", Details: config.DefaultEnv})
+	}
+
+	go naisdApi.ExpirePreviewsPeriodically(*previewReapInterval)
+	go naisdApi.ExpireMirrorsPeriodically(*mirrorReapInterval)
+	go naisdApi.SyncFeatureTogglesPeriodically(*featureToggleSyncInterval)
+	go naisdApi.MonitorKubernetesPeriodically(*kubernetesHealthInterval)
+	if len(*featureFlagsConfigMap) > 0 {
+		go naisdApi.ReloadFeatureFlagsPeriodically(*featureFlagsConfigMap, *featureFlagsReloadInterval)
+	}
+
+	err = http.ListenAndServe(Port, naisdApi.Handler())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,255 @@
+// Package naisdclient is a client for the REST API of naisd, shared by the nais cli and other tools that deploy with it.
+package naisdclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nais/naisd/api"
+	"github.com/nais/naisd/api/naisrequest"
+)
+
+const (
+	DeployEndpoint = "/deploy"
+	StatusEndpoint = "/deploystatus"
+	RenderEndpoint = "/render"
+	LogsEndpoint   = "/logs"
+
+	DeploymentIdHeader = "X-Deployment-Id"
+)
+
+// Client sends requests to the naisd at Url. Requests that fail with a network error or a 502, 503 or 504 are retried
+// up to Retries times, Backoff apart and doubling, except deployments, which are only retried on 503, as naisd rejects
+// them with it before anything is deployed. Token is sent as a bearer token, for the requests naisd only accepts from
+// the operator or an identity of the application's team.
+type Client struct {
+	Url        string
+	HttpClient *http.Client
+	Retries    int
+	Backoff    time.Duration
+	Token      string
+}
+
+// New returns a client for the naisd at url, retrying requests 3 times
+func New(url string) *Client {
+	return &Client{
+		Url:        strings.TrimSuffix(url, "/"),
+		HttpClient: &http.Client{Timeout: 15 * time.Minute},
+		Retries:    3,
+		Backoff:    time.Second,
+	}
+}
+
+// Error is a response from naisd with a status that is not a success. Message is the body of the response.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("naisd returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.TrimSpace(e.Message))
+}
+
+// IsNotFound says if err is a 404 from naisd
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsConflict says if err is a 409 from naisd, e.g. as the application is already being deployed
+func IsConflict(err error) bool {
+	return statusCode(err) == http.StatusConflict
+}
+
+// IsUnavailable says if err is a 503 from naisd, e.g. as deployments are paused or Fasit is down
+func IsUnavailable(err error) bool {
+	return statusCode(err) == http.StatusServiceUnavailable
+}
+
+func statusCode(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// DeployResult is the response to a deployment that succeeded
+type DeployResult struct {
+	DeploymentId string
+	Message      string
+}
+
+// Deploy deploys an application, and returns once naisd has deployed it
+func (c *Client) Deploy(ctx context.Context, request naisrequest.Deploy) (DeployResult, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("unable to marshal deployment request: %s", err)
+	}
+
+	resp, content, err := c.send(ctx, http.MethodPost, DeployEndpoint, body)
+	if err != nil {
+		return DeployResult{}, err
+	}
+	return DeployResult{DeploymentId: resp.Header.Get(DeploymentIdHeader), Message: string(content)}, nil
+}
+
+// Validate renders the Kubernetes objects of a deployment request without deploying anything, returning them as YAML.
+// naisd validates the manifest while rendering it, and responds with the errors it finds.
+func (c *Client) Validate(ctx context.Context, request naisrequest.Deploy) (string, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal deployment request: %s", err)
+	}
+
+	_, content, err := c.send(ctx, http.MethodPost, RenderEndpoint, body)
+	return string(content), err
+}
+
+// Status returns the rollout status of an application. A rollout that has failed is a status, not an error.
+func (c *Client) Status(ctx context.Context, namespace, application string) (api.DeployStatus, api.DeploymentStatusView, error) {
+	var view api.DeploymentStatusView
+
+	resp, content, err := c.send(ctx, http.MethodGet, StatusEndpoint+"/"+namespace+"/"+application, nil)
+	status := api.Success
+	switch {
+	case err == nil && resp.StatusCode == http.StatusAccepted:
+		status = api.InProgress
+	case statusCode(err) == http.StatusInternalServerError && json.Unmarshal(content, &view) == nil:
+		return api.Failed, view, nil
+	case err != nil:
+		return status, view, err
+	}
+
+	if err := json.Unmarshal(content, &view); err != nil {
+		return status, view, fmt.Errorf("unable to unmarshal deployment status: %s", err)
+	}
+	return status, view, nil
+}
+
+// Deployment returns the phase and status of a deployment in progress, or one that finished recently
+func (c *Client) Deployment(ctx context.Context, id string) (api.TrackedDeployment, error) {
+	var deployment api.TrackedDeployment
+
+	_, content, err := c.send(ctx, http.MethodGet, DeployEndpoint+"/"+id, nil)
+	if err != nil {
+		return deployment, err
+	}
+
+	if err := json.Unmarshal(content, &deployment); err != nil {
+		return deployment, fmt.Errorf("unable to unmarshal deployment: %s", err)
+	}
+	return deployment, nil
+}
+
+// Cancel cancels a deployment in progress
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	_, _, err := c.send(ctx, http.MethodDelete, DeployEndpoint+"/"+id, nil)
+	return err
+}
+
+// Logs returns the last tailLines lines the application has logged in each of its pods, every line prefixed with the
+// name of its pod. naisd only returns them to the operator and identities of the application's team, so Token must be
+// set.
+func (c *Client) Logs(ctx context.Context, namespace, application string, tailLines int) (string, error) {
+	_, content, err := c.send(ctx, http.MethodGet, fmt.Sprintf("%s/%s/%s?tailLines=%d", LogsEndpoint, namespace, application, tailLines), nil)
+	return string(content), err
+}
+
+// WaitForRollout polls the status of an application every interval until it has rolled out, or it has failed
+func (c *Client) WaitForRollout(ctx context.Context, namespace, application string, interval time.Duration) error {
+	for {
+		status, view, err := c.Status(ctx, namespace, application)
+		switch {
+		case err != nil:
+			return err
+		case status == api.Success:
+			return nil
+		case status == api.Failed:
+			return fmt.Errorf("rollout of %s failed: %s", application, view.Reason)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// send sends a request, retrying it as long as the client allows, and returns the response and its body. Responses
+// with a status that is not a success are returned as an *Error, along with the response.
+func (c *Client) send(ctx context.Context, method, path string, body []byte) (*http.Response, []byte, error) {
+	delay := c.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, content, err := c.do(ctx, method, path, body)
+		if attempt >= c.Retries || !c.retry(method, resp, err) || ctx.Err() != nil {
+			return resp, content, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return resp, content, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) retry(method string, resp *http.Response, err error) bool {
+	if method == http.MethodPost {
+		return resp != nil && resp.StatusCode == http.StatusServiceUnavailable
+	}
+	if err != nil && resp == nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, c.Url+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, fmt.Errorf("unable to read response from naisd: %s", err)
+	}
+
+	if resp.StatusCode > 299 {
+		return resp, content, &Error{StatusCode: resp.StatusCode, Message: string(content)}
+	}
+	return resp, content, nil
+}
@@ -0,0 +1,165 @@
+package naisdclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nais/naisd/api"
+	"github.com/nais/naisd/api/naisrequest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(url string) *Client {
+	client := New(url)
+	client.Backoff = time.Millisecond
+	return client
+}
+
+func TestDeploy(t *testing.T) {
+	t.Run("The deployment id and response are returned", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request naisrequest.Deploy
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			assert.Equal(t, "app", request.Application)
+
+			w.Header().Set(DeploymentIdHeader, "0123abcd")
+			w.Write([]byte("result: \n- created deployment\n"))
+		}))
+		defer server.Close()
+
+		result, err := newTestClient(server.URL).Deploy(context.Background(), naisrequest.Deploy{Application: "app"})
+		assert.NoError(t, err)
+		assert.Equal(t, "0123abcd", result.DeploymentId)
+		assert.Equal(t, "result: \n- created deployment\n", result.Message)
+	})
+
+	t.Run("Deployments are retried while naisd is unavailable, but not when they fail", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				http.Error(w, "deployments are paused", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "application is already being deployed", http.StatusConflict)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).Deploy(context.Background(), naisrequest.Deploy{Application: "app"})
+		assert.True(t, IsConflict(err))
+		assert.EqualError(t, err, "naisd returned 409 Conflict: application is already being deployed")
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("The token is sent as a bearer token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer alice-token", r.Header.Get("Authorization"))
+		}))
+		defer server.Close()
+
+		client := newTestClient(server.URL)
+		client.Token = "alice-token"
+		_, err := client.Deploy(context.Background(), naisrequest.Deploy{Application: "app"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestStatus(t *testing.T) {
+	statuses := map[string]int{"/deploystatus/default/done": 200, "/deploystatus/default/rolling": 202, "/deploystatus/default/broken": 500}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		status, ok := statuses[r.URL.Path]
+		if !ok {
+			http.Error(w, "deployment not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(api.DeploymentStatusView{Name: "app", Reason: "reason"})
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	status, view, err := client.Status(context.Background(), "default", "done")
+	assert.NoError(t, err)
+	assert.Equal(t, api.Success, status)
+	assert.Equal(t, "app", view.Name)
+
+	status, _, err = client.Status(context.Background(), "default", "rolling")
+	assert.NoError(t, err)
+	assert.Equal(t, api.InProgress, status)
+
+	status, view, err = client.Status(context.Background(), "default", "broken")
+	assert.NoError(t, err)
+	assert.Equal(t, api.Failed, status)
+	assert.Equal(t, "reason", view.Reason)
+
+	requests = 0
+	_, _, err = client.Status(context.Background(), "default", "missing")
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, 1, requests, "only failures naisd may recover from are retried")
+
+	assert.EqualError(t, client.WaitForRollout(context.Background(), "default", "broken", time.Millisecond), "rollout of broken failed: reason")
+	assert.NoError(t, client.WaitForRollout(context.Background(), "default", "done", time.Millisecond))
+}
+
+func TestDeployment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/deploy/0123abcd":
+			json.NewEncoder(w).Encode(api.TrackedDeployment{Id: "0123abcd", Phase: "kubernetes"})
+		case r.Method == http.MethodDelete && r.URL.Path == "/deploy/0123abcd":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "deployment not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	deployment, err := client.Deployment(context.Background(), "0123abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, "kubernetes", deployment.Phase)
+
+	assert.NoError(t, client.Cancel(context.Background(), "0123abcd"))
+	assert.True(t, IsNotFound(client.Cancel(context.Background(), "other")))
+}
+
+func TestLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer alice-token" {
+			http.Error(w, "not authorized", http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "/logs/default/app", r.URL.Path)
+		assert.Equal(t, "10", r.URL.Query().Get("tailLines"))
+		w.Write([]byte("app-1 started\n"))
+	}))
+	defer server.Close()
+	client := newTestClient(server.URL)
+
+	_, err := client.Logs(context.Background(), "default", "app", 10)
+	assert.EqualError(t, err, "naisd returned 401 Unauthorized: not authorized")
+
+	client.Token = "alice-token"
+	logs, err := client.Logs(context.Background(), "default", "app", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "app-1 started\n", logs)
+}
+
+func TestRetriesOnNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	client := newTestClient(url)
+	client.Retries = 2
+	started := time.Now()
+	_, err := client.Deployment(context.Background(), "0123abcd")
+	assert.Error(t, err)
+	assert.True(t, time.Since(started) >= 3*time.Millisecond, "requests were not retried with backoff")
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+// +build postgres
+
+package main
+
+// The postgres state store needs naisd to be built with -tags postgres
+import _ "github.com/lib/pq"
@@ -0,0 +1,7 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// The sqlite state store needs naisd to be built with -tags sqlite, and cgo
+import _ "github.com/mattn/go-sqlite3"